@@ -1,16 +1,30 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"time"
 
+	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
 // Config holds configuration of xtreme.
 type Config struct {
-	LogLevel string         `yaml:"log_level"`
-	Backend  BackendConfig  `yaml:"backend"`
-	Frontend FrontendConfig `yaml:"frontend"`
+	LogLevel     string            `yaml:"log_level"`
+	Backend      BackendConfig     `yaml:"backend"`
+	Database     DatabaseConfig    `yaml:"database"`
+	Storage      StorageConfig     `yaml:"storage"`
+	Auth         AuthConfig        `yaml:"auth"`
+	RecycleBin   RecycleBinConfig  `yaml:"recycle_bin"`
+	Upload       UploadConfig      `yaml:"upload"`
+	Versioning   VersioningConfig  `yaml:"versioning"`
+	Quota        QuotaConfig       `yaml:"quota"`
+	Thumbnails   ThumbnailConfig   `yaml:"thumbnails"`
+	ContentTypes ContentTypeConfig `yaml:"content_types"`
+	Extraction   ExtractionConfig  `yaml:"extraction"`
+	Frontend     FrontendConfig    `yaml:"frontend"`
 }
 
 // BackendConfig holds properties of backend's configuration.
@@ -18,6 +32,186 @@ type BackendConfig struct {
 	Host      string `yaml:"host"`
 	Port      int    `yaml:"port"`
 	UploadDir string `yaml:"upload_dir"`
+	// DBPath is the path of the SQLite database file.
+	// Deprecated: use database.dsn instead, which DBPath is copied to.
+	DBPath string `yaml:"db_path"`
+}
+
+// DatabaseConfig holds properties of database's configuration.
+type DatabaseConfig struct {
+	// Driver is one of sqlite, postgres or memory.
+	Driver string `yaml:"driver"`
+	// DSN is the path of the database file for sqlite, or the connection string for postgres.
+	DSN string `yaml:"dsn"`
+}
+
+// StorageConfig holds properties of the storage of file content.
+type StorageConfig struct {
+	// Driver is one of local or s3. local stores the content in backend's upload_dir.
+	Driver string `yaml:"driver"`
+	// S3 configures the s3 driver.
+	S3 S3StorageConfig `yaml:"s3"`
+	// Encryption encrypts the content at rest with either driver.
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// Compression compresses the content at rest with either driver, before it is encrypted.
+	Compression CompressionConfig `yaml:"compression"`
+}
+
+// CompressionConfig holds properties of the compression of file content at rest. Content of
+// a compressible type, e.g. text, is compressed with gzip if that saves enough, and is
+// decompressed transparently. Files stored before the compression was enabled stay readable.
+// Quotas count the size of the content, not the bytes it takes up.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSavings is the fraction of its size, which compression must save on a content for it
+	// to be stored compressed, e.g. 0.1 for 10%. Zero falls back to 0.1.
+	MinSavings float64 `yaml:"min_savings"`
+}
+
+// EncryptionConfig holds properties of the encryption of file content at rest. Every file
+// is encrypted with its own data key, which is encrypted with the master key. Files stored
+// before the encryption was enabled stay readable. Partial files of resumable uploads are
+// encrypted too, but their chunks are only authenticated once the upload completes.
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MasterKey encrypts the data keys of new files.
+	MasterKey MasterKeyConfig `yaml:"master_key"`
+	// PreviousMasterKeys still decrypt the data keys of files stored before the master key
+	// was replaced, until the data keys are rotated with -rotate_master_key.
+	PreviousMasterKeys []MasterKeyConfig `yaml:"previous_master_keys"`
+}
+
+// MasterKeyConfig holds where a master key of 32 bytes, encoded as 64 hex digits or as
+// base64, is read from, e.g. generated by "openssl rand -hex 32". Exactly one of File and
+// Env must be set.
+type MasterKeyConfig struct {
+	// File is the path of a file holding the key.
+	File string `yaml:"file"`
+	// Env is the name of an environment variable holding the key.
+	Env string `yaml:"env"`
+}
+
+// S3StorageConfig holds properties of an S3-compatible object storage. Partial files of
+// resumable uploads are staged on the local disk in backend's upload_dir, and only saved to
+// the bucket once the upload completes.
+type S3StorageConfig struct {
+	// Endpoint is the URL of the service, e.g. https://s3.eu-central-1.amazonaws.com.
+	Endpoint string `yaml:"endpoint"`
+	// Region the requests are signed for, e.g. eu-central-1.
+	Region string `yaml:"region"`
+	// Bucket holding the content.
+	Bucket string `yaml:"bucket"`
+	// Prefix is prepended to the names of all objects, e.g. xtreme/.
+	Prefix          string `yaml:"prefix"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// PathStyle addresses the bucket in the path instead of the host name, which most
+	// S3-compatible services other than AWS require.
+	PathStyle bool `yaml:"path_style"`
+	// PartSize is the size in bytes of the parts large files are uploaded in, at least
+	// 5242880. Zero means 8388608.
+	PartSize int64 `yaml:"part_size"`
+}
+
+// AuthConfig holds properties of user authentication's configuration.
+type AuthConfig struct {
+	// JWTSecret signs the access and refresh tokens. It must be set, preferably to at least
+	// 32 random characters.
+	JWTSecret string `yaml:"jwt_secret"`
+	// AccessTokenTTL is how long an access token is valid, e.g. 15m. Logging out only revokes
+	// the refresh token, so keep it short.
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	// RefreshTokenTTL is how long a refresh token is valid, e.g. 720h.
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// PurgeInterval is how often expired refresh tokens are looked for, e.g. 1h.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+	// AllowRegistration lets anyone register a new user.
+	AllowRegistration bool `yaml:"allow_registration"`
+	// AdminUsername and AdminPassword create an admin on startup, unless a user with the
+	// name exists. An empty AdminUsername creates no admin.
+	AdminUsername string `yaml:"admin_username"`
+	AdminPassword string `yaml:"admin_password"`
+}
+
+// RecycleBinConfig holds properties of recycle bin's configuration.
+type RecycleBinConfig struct {
+	// Retention is how long entries stay in the recycle bin before they are removed
+	// permanently, e.g. 720h. Zero keeps them until they are removed by hand.
+	Retention time.Duration `yaml:"retention"`
+	// PurgeInterval is how often expired entries are looked for, e.g. 1h.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// UploadConfig holds properties of resumable uploads' configuration.
+type UploadConfig struct {
+	// Expiration is how long a resumable upload can be continued after its creation, e.g. 24h.
+	Expiration time.Duration `yaml:"expiration"`
+	// MaxSize is the maximum size of a resumable upload in bytes. Zero means no limit.
+	MaxSize int64 `yaml:"max_size"`
+	// PurgeInterval is how often expired uploads are looked for, e.g. 1h.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// VersioningConfig holds properties of file versioning's configuration.
+type VersioningConfig struct {
+	// Enabled makes an upload with the name of an existing file add a new version to it,
+	// and retains old versions. Otherwise such uploads are rejected.
+	Enabled bool `yaml:"enabled"`
+	// MaxVersions is the maximum number of retained versions of a file including the
+	// current one. Zero means no limit.
+	MaxVersions int `yaml:"max_versions"`
+	// MaxAge is how long an old version is retained after it was replaced, e.g. 720h.
+	// Zero means no limit.
+	MaxAge time.Duration `yaml:"max_age"`
+	// PurgeInterval is how often expired versions are looked for, e.g. 1h.
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// QuotaConfig holds properties of storage quotas' configuration.
+type QuotaConfig struct {
+	// DefaultUserBytes is the quota in bytes of users without a quota of their own, which
+	// limits the sizes of all versions of their files. Zero means no limit.
+	DefaultUserBytes int64 `yaml:"default_user_bytes"`
+}
+
+// ThumbnailConfig holds properties of the thumbnails of images.
+type ThumbnailConfig struct {
+	// Workers is the number of images thumbnails are made of at the same time in the
+	// background. Zero means 2.
+	Workers int `yaml:"workers"`
+}
+
+// ContentTypeConfig holds properties of the MIME types allowed for uploaded files. Types are
+// given without parameters, e.g. image/png, or as wildcards of all subtypes, e.g. image/*.
+type ContentTypeConfig struct {
+	// Allowed types. Empty allows all types, which are not blocked.
+	Allowed []string `yaml:"allowed"`
+	// Blocked types, which are rejected even if they are allowed.
+	Blocked []string `yaml:"blocked"`
+	// Directories replace the allowed and blocked types in directories and their
+	// subdirectories. The policy of the deepest directory applies.
+	Directories []DirContentTypeConfig `yaml:"directories"`
+}
+
+// DirContentTypeConfig holds the MIME types allowed for files uploaded into a directory.
+type DirContentTypeConfig struct {
+	// Path of the directory, which is the same in the trees of all users, e.g. /photos.
+	Path    string   `yaml:"path"`
+	Allowed []string `yaml:"allowed"`
+	Blocked []string `yaml:"blocked"`
+}
+
+// ExtractionConfig holds the limits of the extraction of uploaded archives, which defend
+// against decompression bombs.
+type ExtractionConfig struct {
+	// MaxEntries is the maximum number of entries of an archive. Zero means 10000.
+	MaxEntries int `yaml:"max_entries"`
+	// MaxBytes is the maximum total size in bytes of the content of an archive. Zero means
+	// 10737418240.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// MaxRatio is the maximum ratio of the total size of the content of an archive to the
+	// size of the archive. Zero means 100.
+	MaxRatio float64 `yaml:"max_ratio"`
 }
 
 // FrontendConfig holds properties of frontend's configuration.
@@ -35,5 +229,25 @@ func NewConfig(configFile string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := config.applyDeprecated(); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
+
+// applyDeprecated moves the values of deprecated keys to the keys replacing them. A deprecated
+// key conflicting with its replacement is an error, so no setting is lost silently.
+func (c *Config) applyDeprecated() error {
+	if c.Backend.DBPath != "" {
+		if c.Database.DSN != "" {
+			return errors.New("backend.db_path and database.dsn cannot both be set, use database.dsn only")
+		}
+		if c.Database.Driver != "" && c.Database.Driver != "sqlite" {
+			return fmt.Errorf("backend.db_path cannot be used with the database driver %s, use database.dsn instead",
+				c.Database.Driver)
+		}
+		log.Warn("backend.db_path is deprecated, use database.dsn instead")
+		c.Database.DSN = c.Backend.DBPath
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfig writes a config file with a given content and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	configFile := filepath.Join(t.TempDir(), "cfg.yml")
+	if err := ioutil.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configFile
+}
+
+func TestNewConfigKeepsDeprecatedDBPath(t *testing.T) {
+	cfg, err := NewConfig(writeConfig(t, "backend:\n  db_path: ./old.db\n"))
+	if err != nil {
+		t.Fatalf("NewConfig failed: %s", err)
+	}
+	if cfg.Database.DSN != "./old.db" {
+		t.Errorf("database DSN = %q, want %q", cfg.Database.DSN, "./old.db")
+	}
+}
+
+func TestNewConfigRejectsConflictingDBPath(t *testing.T) {
+	tests := map[string]string{
+		"both set":      "backend:\n  db_path: ./old.db\ndatabase:\n  dsn: ./new.db\n",
+		"not sqlite":    "backend:\n  db_path: ./old.db\ndatabase:\n  driver: postgres\n",
+		"memory driver": "backend:\n  db_path: ./old.db\ndatabase:\n  driver: memory\n",
+	}
+	for name, content := range tests {
+		if _, err := NewConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: NewConfig succeeded, want an error", name)
+		}
+	}
+}
+
+func TestNewFManRepoRequiresDSN(t *testing.T) {
+	for _, driver := range []string{"", "sqlite", "postgres"} {
+		if _, err := newFManRepo(DatabaseConfig{Driver: driver}); err == nil {
+			t.Errorf("newFManRepo with driver %q and no DSN succeeded, want an error", driver)
+		}
+	}
+}
+
+func TestContentTypePolicies(t *testing.T) {
+	policy, dirPolicies, err := contentTypePolicies(ContentTypeConfig{
+		Blocked:     []string{"application/x-msdownload"},
+		Directories: []DirContentTypeConfig{{Path: "/photos", Allowed: []string{"image/*"}}},
+	})
+	if err != nil {
+		t.Fatalf("contentTypePolicies failed: %s", err)
+	}
+	if policy.Allows("application/x-msdownload") || !policy.Allows("text/plain; charset=utf-8") {
+		t.Errorf("global policy %+v does not block executables only", policy)
+	}
+	if photos := dirPolicies["/photos"]; !photos.Allows("image/png") || photos.Allows("text/plain") {
+		t.Errorf("policy of /photos %+v does not allow images only", photos)
+	}
+
+	tests := map[string]ContentTypeConfig{
+		"no subtype":      {Allowed: []string{"image"}},
+		"any type":        {Blocked: []string{"*/*"}},
+		"with parameters": {Allowed: []string{"text/plain; charset=utf-8"}},
+		"relative path":   {Directories: []DirContentTypeConfig{{Path: "photos"}}},
+		"invalid in dir":  {Directories: []DirContentTypeConfig{{Path: "/photos", Blocked: []string{"/png"}}}},
+	}
+	for name, cfg := range tests {
+		if _, _, err := contentTypePolicies(cfg); err == nil {
+			t.Errorf("%s: contentTypePolicies succeeded, want an error", name)
+		}
+	}
+}
@@ -3,11 +3,14 @@ module github.com/nvthongswansea/xtreme
 go 1.16
 
 require (
+	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/google/uuid v1.2.0
 	github.com/labstack/echo/v4 v4.2.2
+	github.com/lib/pq v1.10.0
 	github.com/mattn/go-colorable v0.1.8 // indirect
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/sirupsen/logrus v1.8.1
-	golang.org/x/crypto v0.0.0-20210421142844-5bf0f12babf7 // indirect
+	golang.org/x/crypto v0.0.0-20210421142844-5bf0f12babf7
 	golang.org/x/net v0.0.0-20210420210106-798c2154c571 // indirect
 	golang.org/x/sys v0.0.0-20210420205809-ac73e9fd8988 // indirect
 	gopkg.in/yaml.v2 v2.4.0
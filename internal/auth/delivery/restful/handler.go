@@ -0,0 +1,140 @@
+package restful
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/auth"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// userContextKey is the key of the authenticated user in echo.Context.
+const userContextKey = "user"
+
+// Response represents http response in JSON format
+type Response struct {
+	Message string `json:"message"`
+}
+
+// CredentialsRequest represents a request to register or log in.
+type CredentialsRequest struct {
+	Username string `json:"username" form:"username"`
+	Password string `json:"password" form:"password"`
+}
+
+// RefreshRequest represents a request to refresh tokens or to log out.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+}
+
+// AuthHandler represents the http handler for authentication
+type AuthHandler struct {
+	AuthUsecase auth.AuthUsecase
+}
+
+// InitAuthHandler initializes authentication endpoints and returns the middleware which
+// authenticates requests to other endpoints.
+func InitAuthHandler(e *echo.Echo, uc auth.AuthUsecase) echo.MiddlewareFunc {
+	handler := &AuthHandler{AuthUsecase: uc}
+	mw := NewAuthMiddleware(uc)
+	g := e.Group("/auth")
+	g.POST("/register", handler.Register)
+	g.POST("/login", handler.Login)
+	g.POST("/refresh", handler.Refresh)
+	g.POST("/logout", handler.Logout)
+	g.GET("/me", handler.Me, mw)
+	return mw
+}
+
+// Register creates a new user and returns it.
+func (h *AuthHandler) Register(c echo.Context) error {
+	req := CredentialsRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	user, err := h.AuthUsecase.Register(req.Username, req.Password)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusCreated, user)
+}
+
+// Login returns a new pair of tokens for a username and a password.
+func (h *AuthHandler) Login(c echo.Context) error {
+	req := CredentialsRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	tokens, err := h.AuthUsecase.Login(req.Username, req.Password)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens.
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	req := RefreshRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	tokens, err := h.AuthUsecase.Refresh(req.RefreshToken)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes a refresh token. Access tokens are not revoked and stay valid until they
+// expire, so clients must discard them on logout.
+func (h *AuthHandler) Logout(c echo.Context) error {
+	req := RefreshRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.AuthUsecase.Logout(req.RefreshToken); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Logged out successfully"})
+}
+
+// Me returns the authenticated user.
+func (h *AuthHandler) Me(c echo.Context) error {
+	return c.JSON(http.StatusOK, UserFromContext(c))
+}
+
+// NewAuthMiddleware returns a middleware which authenticates requests with the access token
+// in the Authorization header, e.g. "Bearer <token>". The authenticated user is available
+// through UserFromContext.
+func NewAuthMiddleware(uc auth.AuthUsecase) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Request().Header.Get(echo.HeaderAuthorization)
+			const prefix = "Bearer "
+			if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return echo.NewHTTPError(http.StatusUnauthorized, "missing access token")
+			}
+			user, err := uc.Authenticate(header[len(prefix):])
+			if err != nil {
+				if models.IsFManErrorCode(err, models.UnauthorizedErrorCode) {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				}
+				return errUtils.ToHTTPError(err)
+			}
+			c.Set(userContextKey, user)
+			return next(c)
+		}
+	}
+}
+
+// UserFromContext returns the user authenticated by the middleware of NewAuthMiddleware.
+// It is empty if the request is not authenticated.
+func UserFromContext(c echo.Context) models.User {
+	user, _ := c.Get(userContextKey).(models.User)
+	return user
+}
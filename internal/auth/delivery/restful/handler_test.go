@@ -0,0 +1,150 @@
+package restful
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	authUC "github.com/nvthongswansea/xtreme/internal/auth/usecase"
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
+)
+
+// newTestEcho returns an echo.Echo serving the authentication endpoints over a memory
+// repository.
+func newTestEcho(allowRegistration bool) *echo.Echo {
+	r := repo.NewFManMemoryRepo()
+	uc := authUC.NewAuthJWTUsecase(r, r, &uuidUtils.GoogleUUIDGenerator{}, authUC.Options{
+		Secret:            []byte("test-secret"),
+		AllowRegistration: allowRegistration,
+	})
+	e := echo.New()
+	InitAuthHandler(e, uc)
+	return e
+}
+
+// serveJSON serves a request with a JSON body and an optional access token, and decodes
+// the JSON response into v unless it is nil.
+func serveJSON(t *testing.T, e *echo.Echo, method, target, accessToken string, body, v interface{}) int {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(string(b)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if accessToken != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+accessToken)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if v != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("invalid JSON body %q: %s", rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	e := newTestEcho(true)
+	alice := CredentialsRequest{Username: "alice", Password: "alice-password"}
+	var user models.User
+	if code := serveJSON(t, e, http.MethodPost, "/auth/register", "", alice, &user); code != http.StatusCreated {
+		t.Fatalf("register returned %d", code)
+	}
+	if user.UUID == "" || user.RootDirUUID == "" || user.IsAdmin {
+		t.Errorf("registered user = %+v, want a non-admin with a root directory", user)
+	}
+	invalid := []CredentialsRequest{
+		alice,
+		{Username: "al", Password: "alice-password"},
+		{Username: "bob", Password: "short"},
+	}
+	for _, creds := range invalid {
+		if code := serveJSON(t, e, http.MethodPost, "/auth/register", "", creds, nil); code < 400 || code >= 500 {
+			t.Errorf("register of %+v returned %d, want a client error", creds, code)
+		}
+	}
+
+	var tokens models.TokenPair
+	if code := serveJSON(t, e, http.MethodPost, "/auth/login", "", alice, &tokens); code != http.StatusOK {
+		t.Fatalf("login returned %d", code)
+	}
+	var me models.User
+	if code := serveJSON(t, e, http.MethodGet, "/auth/me", tokens.AccessToken, nil, &me); code != http.StatusOK || me.UUID != user.UUID {
+		t.Errorf("me returned %d with %+v, want %+v", code, me, user)
+	}
+	wrong := CredentialsRequest{Username: "alice", Password: "wrong-password"}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/login", "", wrong, nil); code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password returned %d, want 401", code)
+	}
+	unknown := CredentialsRequest{Username: "nobody", Password: "alice-password"}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/login", "", unknown, nil); code != http.StatusUnauthorized {
+		t.Errorf("login of an unknown user returned %d, want 401", code)
+	}
+}
+
+func TestRegistrationDisabled(t *testing.T) {
+	e := newTestEcho(false)
+	alice := CredentialsRequest{Username: "alice", Password: "alice-password"}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/register", "", alice, nil); code != http.StatusForbidden {
+		t.Errorf("register returned %d, want 403", code)
+	}
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	e := newTestEcho(true)
+	alice := CredentialsRequest{Username: "alice", Password: "alice-password"}
+	serveJSON(t, e, http.MethodPost, "/auth/register", "", alice, nil)
+	var tokens models.TokenPair
+	serveJSON(t, e, http.MethodPost, "/auth/login", "", alice, &tokens)
+
+	// A refresh token can be used once, and not as an access token or the other way round.
+	var refreshed models.TokenPair
+	refresh := RefreshRequest{RefreshToken: tokens.RefreshToken}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/refresh", "", refresh, &refreshed); code != http.StatusOK {
+		t.Fatalf("refresh returned %d", code)
+	}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/refresh", "", refresh, nil); code != http.StatusUnauthorized {
+		t.Errorf("second refresh with the same token returned %d, want 401", code)
+	}
+	if code := serveJSON(t, e, http.MethodGet, "/auth/me", refreshed.RefreshToken, nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("me with a refresh token returned %d, want 401", code)
+	}
+	accessAsRefresh := RefreshRequest{RefreshToken: refreshed.AccessToken}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/refresh", "", accessAsRefresh, nil); code != http.StatusUnauthorized {
+		t.Errorf("refresh with an access token returned %d, want 401", code)
+	}
+
+	logout := RefreshRequest{RefreshToken: refreshed.RefreshToken}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/logout", "", logout, nil); code != http.StatusOK {
+		t.Fatalf("logout returned %d", code)
+	}
+	if code := serveJSON(t, e, http.MethodPost, "/auth/refresh", "", logout, nil); code != http.StatusUnauthorized {
+		t.Errorf("refresh after a logout returned %d, want 401", code)
+	}
+	// Access tokens are not revoked on logout, only their TTL limits them.
+	if code := serveJSON(t, e, http.MethodGet, "/auth/me", refreshed.AccessToken, nil, nil); code != http.StatusOK {
+		t.Errorf("me after a logout returned %d, want 200 until the access token expires", code)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	e := newTestEcho(true)
+	for _, header := range []string{"", "Bearer", "Basic abc", "Bearer not-a-jwt"} {
+		req := httptest.NewRequest(http.MethodGet, "/auth/me", nil)
+		if header != "" {
+			req.Header.Set(echo.HeaderAuthorization, header)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("me with Authorization %q returned %d, want 401", header, rec.Code)
+		}
+	}
+}
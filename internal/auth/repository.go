@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// AuthUserDBRepo provides an interface for operations on users in the database.
+type AuthUserDBRepo interface {
+	// InsertUserRecord inserts a user record to the db together with the root directory
+	// of the user, whose UUID is user.RootDirUUID. The username must not be taken yet.
+	InsertUserRecord(user models.User) error
+
+	// ReadUserRecord reads a user record from the db with a given UUID.
+	ReadUserRecord(UUID string) (models.User, error)
+
+	// ReadUserRecordByName reads a user record from the db with a given username.
+	ReadUserRecordByName(username string) (models.User, error)
+}
+
+// AuthTokenDBRepo provides an interface for operations on refresh tokens in the database.
+type AuthTokenDBRepo interface {
+	// InsertRefreshTokenRecord inserts a refresh token record to the db.
+	InsertRefreshTokenRecord(token models.RefreshToken) error
+
+	// HardRemoveRefreshTokenRecord removes a refresh token record completely from the db
+	// and returns it. A token can only be removed once, so concurrent refreshes with the
+	// same token cannot both succeed.
+	HardRemoveRefreshTokenRecord(UUID string) (models.RefreshToken, error)
+
+	// HardRemoveExpiredRefreshTokenRecords removes the refresh token records which expired
+	// before a given time, and returns their number.
+	HardRemoveExpiredRefreshTokenRecords(before time.Time) (int, error)
+}
@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// AuthUsecase provides an interface for authenticating users.
+type AuthUsecase interface {
+	// Register a new user with a password. The user gets a root directory of its own.
+	Register(username, password string) (models.User, error)
+
+	// Make sure an admin with a given username exists, and create it with a password
+	// otherwise. An existing user with the name is left as it is.
+	EnsureAdmin(username, password string) (models.User, error)
+
+	// Log a user in with a username and a password. Return a new pair of tokens.
+	Login(username, password string) (models.TokenPair, error)
+
+	// Exchange a refresh token for a new pair of tokens. A refresh token can be used once.
+	Refresh(refreshToken string) (models.TokenPair, error)
+
+	// Log out by revoking a refresh token. Access tokens stay valid until they expire.
+	Logout(refreshToken string) error
+
+	// Return the user an access token is issued to.
+	Authenticate(accessToken string) (models.User, error)
+
+	// Remove the refresh tokens which expired before a given time. Return the number of
+	// removed tokens.
+	PurgeExpiredTokens(now time.Time) (int, error)
+}
@@ -0,0 +1,355 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/nvthongswansea/xtreme/internal/auth"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// DefaultAccessTokenTTL is how long an access token is valid if no TTL is given.
+	DefaultAccessTokenTTL = 15 * time.Minute
+
+	// DefaultRefreshTokenTTL is how long a refresh token is valid if no TTL is given.
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+	// MinPasswordLength is the minimum length of a password in bytes.
+	MinPasswordLength = 8
+
+	// MaxPasswordLength is the maximum length of a password in bytes, as bcrypt ignores
+	// everything after it.
+	MaxPasswordLength = 72
+)
+
+// Types of the issued tokens, so that one cannot be used as the other.
+const (
+	accessTokenType  = "access"
+	refreshTokenType = "refresh"
+)
+
+// usernamePattern matches valid usernames.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+// dummyPasswordHash is compared with the password of a login for an unknown user, so
+// that the response time does not reveal which usernames exist.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// tokenClaims are the claims of the issued JWTs. The subject is the UUID of the user,
+// and the ID is a UUID of its own.
+type tokenClaims struct {
+	jwt.StandardClaims
+	Type string `json:"typ"`
+}
+
+// AuthJWTUsecase provides usecase(logic) for authenticating users with JWTs signed
+// with HS256.
+type AuthJWTUsecase struct {
+	dbUserRepo  auth.AuthUserDBRepo
+	dbTokenRepo auth.AuthTokenDBRepo
+	uuidGen     uuidUtils.UUIDGenerator
+	opts        Options
+}
+
+// Options holds settings of AuthJWTUsecase.
+type Options struct {
+	// Secret signs and verifies the tokens. It must not be empty.
+	Secret []byte
+
+	// AccessTokenTTL is how long an access token is valid. Zero means DefaultAccessTokenTTL.
+	AccessTokenTTL time.Duration
+
+	// RefreshTokenTTL is how long a refresh token is valid. Zero means DefaultRefreshTokenTTL.
+	RefreshTokenTTL time.Duration
+
+	// AllowRegistration lets anyone register a new user. Otherwise only EnsureAdmin
+	// creates users.
+	AllowRegistration bool
+}
+
+// NewAuthJWTUsecase creates a new AuthJWTUsecase.
+func NewAuthJWTUsecase(dbUserRepo auth.AuthUserDBRepo, dbTokenRepo auth.AuthTokenDBRepo, uuidGen uuidUtils.UUIDGenerator,
+	opts Options) *AuthJWTUsecase {
+	if opts.AccessTokenTTL <= 0 {
+		opts.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if opts.RefreshTokenTTL <= 0 {
+		opts.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+	return &AuthJWTUsecase{
+		dbUserRepo:  dbUserRepo,
+		dbTokenRepo: dbTokenRepo,
+		uuidGen:     uuidGen,
+		opts:        opts,
+	}
+}
+
+func (u *AuthJWTUsecase) Register(username, password string) (models.User, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "Register",
+		"username":  username,
+	})
+	logger.Debug("Start registering user")
+	defer logger.Debug("Finish registering user")
+	if !u.opts.AllowRegistration {
+		logger.Info("[-USER-] registration is disabled")
+		return models.User{}, models.NewFManError(models.ForbiddenErrorCode, "registration is disabled")
+	}
+	return u.createUser(logger, username, password, false)
+}
+
+func (u *AuthJWTUsecase) EnsureAdmin(username, password string) (models.User, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "EnsureAdmin",
+		"username":  username,
+	})
+	logger.Debug("Start ensuring admin")
+	defer logger.Debug("Finish ensuring admin")
+	user, err := u.dbUserRepo.ReadUserRecordByName(username)
+	if err == nil {
+		if !user.IsAdmin {
+			logger.Warnf("User %s exists but is not an admin", username)
+		}
+		return user, nil
+	}
+	if !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		errUtils.LogErr(logger, "ReadUserRecordByName", err)
+		return models.User{}, err
+	}
+	return u.createUser(logger, username, password, true)
+}
+
+func (u *AuthJWTUsecase) Login(username, password string) (models.TokenPair, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "Login",
+		"username":  username,
+	})
+	logger.Debug("Start logging in")
+	defer logger.Debug("Finish logging in")
+	user, err := u.dbUserRepo.ReadUserRecordByName(username)
+	if err != nil && !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		errUtils.LogErr(logger, "ReadUserRecordByName", err)
+		return models.TokenPair{}, err
+	}
+	passwordHash := []byte(user.PasswordHash)
+	if err != nil {
+		passwordHash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) != nil || err != nil {
+		logger.Info("[-USER-] invalid username or password")
+		return models.TokenPair{}, models.NewFManError(models.UnauthorizedErrorCode, "invalid username or password")
+	}
+	return u.issueTokens(logger, user)
+}
+
+func (u *AuthJWTUsecase) Refresh(refreshToken string) (models.TokenPair, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "Refresh",
+	})
+	logger.Debug("Start refreshing tokens")
+	defer logger.Debug("Finish refreshing tokens")
+	claims, err := u.parseToken(refreshToken, refreshTokenType)
+	if err != nil {
+		errUtils.LogErr(logger, "parseToken", err)
+		return models.TokenPair{}, err
+	}
+	// The token is removed before new ones are issued, so it cannot be used twice.
+	record, err := u.dbTokenRepo.HardRemoveRefreshTokenRecord(claims.Id)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		logger.Info("[-USER-] refresh token is revoked")
+		return models.TokenPair{}, models.NewFManError(models.UnauthorizedErrorCode, "refresh token is revoked")
+	}
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveRefreshTokenRecord", err)
+		return models.TokenPair{}, err
+	}
+	if record.UserUUID != claims.Subject {
+		logger.Warnf("Refresh token %s was issued to user %s, not %s", record.UUID, record.UserUUID, claims.Subject)
+		return models.TokenPair{}, models.NewFManError(models.UnauthorizedErrorCode, "invalid or expired refresh token")
+	}
+	user, err := u.readUser(logger, claims.Subject)
+	if err != nil {
+		return models.TokenPair{}, err
+	}
+	return u.issueTokens(logger, user)
+}
+
+func (u *AuthJWTUsecase) Logout(refreshToken string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "Logout",
+	})
+	logger.Debug("Start logging out")
+	defer logger.Debug("Finish logging out")
+	claims, err := u.parseToken(refreshToken, refreshTokenType)
+	if err != nil {
+		errUtils.LogErr(logger, "parseToken", err)
+		return err
+	}
+	_, err = u.dbTokenRepo.HardRemoveRefreshTokenRecord(claims.Id)
+	if err != nil && !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		errUtils.LogErr(logger, "HardRemoveRefreshTokenRecord", err)
+		return err
+	}
+	// A token which is revoked already is fine as well.
+	return nil
+}
+
+func (u *AuthJWTUsecase) Authenticate(accessToken string) (models.User, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "Authenticate",
+	})
+	claims, err := u.parseToken(accessToken, accessTokenType)
+	if err != nil {
+		errUtils.LogErr(logger, "parseToken", err)
+		return models.User{}, err
+	}
+	return u.readUser(logger, claims.Subject)
+}
+
+func (u *AuthJWTUsecase) PurgeExpiredTokens(now time.Time) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-jwt",
+		"Operation": "PurgeExpiredTokens",
+	})
+	logger.Debug("Start purging expired tokens")
+	defer logger.Debug("Finish purging expired tokens")
+	removed, err := u.dbTokenRepo.HardRemoveExpiredRefreshTokenRecords(now)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveExpiredRefreshTokenRecords", err)
+		return 0, err
+	}
+	return removed, nil
+}
+
+// createUser creates a user with a hashed password and a root directory of its own.
+func (u *AuthJWTUsecase) createUser(logger *log.Entry, username, password string, isAdmin bool) (models.User, error) {
+	if !usernamePattern.MatchString(username) {
+		logger.Info("[-USER-] invalid username")
+		return models.User{}, models.NewFManError(models.InvalidArgumentErrorCode,
+			"username must consist of 3 to 64 letters, digits, dots, dashes or underscores")
+	}
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		logger.Info("[-USER-] invalid password length")
+		return models.User{}, models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("password must be %d to %d bytes long", MinPasswordLength, MaxPasswordLength))
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		errUtils.LogErr(logger, "GenerateFromPassword", err)
+		return models.User{}, err
+	}
+	user := models.User{
+		UUID:         u.uuidGen.NewUUID(),
+		Username:     username,
+		PasswordHash: string(passwordHash),
+		RootDirUUID:  u.uuidGen.NewUUID(),
+		IsAdmin:      isAdmin,
+	}
+	if err := u.dbUserRepo.InsertUserRecord(user); err != nil {
+		errUtils.LogErr(logger, "InsertUserRecord", err)
+		return models.User{}, err
+	}
+	// Read the user back for the timestamps set by the repository.
+	return u.readUser(logger, user.UUID)
+}
+
+// readUser reads the user with a given UUID. A user which does not exist anymore is
+// not authorized.
+func (u *AuthJWTUsecase) readUser(logger *log.Entry, userUUID string) (models.User, error) {
+	user, err := u.dbUserRepo.ReadUserRecord(userUUID)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		logger.Infof("[-USER-] user %s does not exist", userUUID)
+		return models.User{}, models.NewFManError(models.UnauthorizedErrorCode, "user does not exist")
+	}
+	if err != nil {
+		errUtils.LogErr(logger, "ReadUserRecord", err)
+		return models.User{}, err
+	}
+	return user, nil
+}
+
+// issueTokens issues a new access token and a new refresh token to a user.
+func (u *AuthJWTUsecase) issueTokens(logger *log.Entry, user models.User) (models.TokenPair, error) {
+	now := time.Now()
+	accessToken, err := u.signToken(tokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        u.uuidGen.NewUUID(),
+			Subject:   user.UUID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(u.opts.AccessTokenTTL).Unix(),
+		},
+		Type: accessTokenType,
+	})
+	if err != nil {
+		errUtils.LogErr(logger, "signToken", err)
+		return models.TokenPair{}, err
+	}
+	record := models.RefreshToken{
+		UUID:      u.uuidGen.NewUUID(),
+		UserUUID:  user.UUID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(u.opts.RefreshTokenTTL),
+	}
+	refreshToken, err := u.signToken(tokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        record.UUID,
+			Subject:   user.UUID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: record.ExpiresAt.Unix(),
+		},
+		Type: refreshTokenType,
+	})
+	if err != nil {
+		errUtils.LogErr(logger, "signToken", err)
+		return models.TokenPair{}, err
+	}
+	if err := u.dbTokenRepo.InsertRefreshTokenRecord(record); err != nil {
+		errUtils.LogErr(logger, "InsertRefreshTokenRecord", err)
+		return models.TokenPair{}, err
+	}
+	return models.TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(u.opts.AccessTokenTTL / time.Second),
+	}, nil
+}
+
+// signToken signs a token with HS256.
+func (u *AuthJWTUsecase) signToken(claims tokenClaims) (string, error) {
+	if len(u.opts.Secret) == 0 {
+		return "", errors.New("secret of the tokens is empty")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(u.opts.Secret)
+}
+
+// parseToken verifies a token of a given type and returns its claims. Tokens which are
+// malformed, expired, signed otherwise or of another type are not authorized.
+func (u *AuthJWTUsecase) parseToken(token, tokenType string) (tokenClaims, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return u.opts.Secret, nil
+	})
+	if err != nil || len(u.opts.Secret) == 0 || claims.Type != tokenType || claims.Subject == "" || claims.Id == "" {
+		return tokenClaims{}, models.NewFManError(models.UnauthorizedErrorCode,
+			fmt.Sprintf("invalid or expired %s token", tokenType))
+	}
+	return claims, nil
+}
@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/auth"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPurgeInterval is how often the purger looks for expired tokens if no interval
+// is given.
+const DefaultPurgeInterval = time.Hour
+
+// TokenPurger removes refresh tokens once they expired.
+type TokenPurger struct {
+	uc       auth.AuthUsecase
+	interval time.Duration
+}
+
+// NewTokenPurger creates a new TokenPurger. An interval <= 0 is replaced by
+// DefaultPurgeInterval.
+func NewTokenPurger(uc auth.AuthUsecase, interval time.Duration) *TokenPurger {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return &TokenPurger{
+		uc,
+		interval,
+	}
+}
+
+// Run purges expired tokens right away and then once every interval, until ctx is done.
+func (p *TokenPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.purge()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purge removes the refresh tokens which expired by now.
+func (p *TokenPurger) purge() {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-purger",
+		"Operation": "purge",
+	})
+	removed, err := p.uc.PurgeExpiredTokens(time.Now())
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] PurgeExpiredTokens failed with error %s", err.Error())
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Purged %d expired refresh tokens", removed)
+	}
+}
@@ -0,0 +1,54 @@
+package errUtils
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// ToHTTPError converts a FManError to an echo.HTTPError with a matching status code.
+// Other errors are returned as they are, which echo reports as internal server errors.
+func ToHTTPError(err error) error {
+	var fmanErr models.FManError
+	if !errors.As(err, &fmanErr) {
+		return err
+	}
+	switch fmanErr.Code {
+	case models.NotFoundErrorCode:
+		return echo.NewHTTPError(http.StatusNotFound, fmanErr.Message)
+	case models.AlreadyExistErrorCode:
+		return echo.NewHTTPError(http.StatusConflict, fmanErr.Message)
+	case models.InvalidArgumentErrorCode:
+		return echo.NewHTTPError(http.StatusBadRequest, fmanErr.Message)
+	case models.ConflictErrorCode:
+		return echo.NewHTTPError(http.StatusConflict, fmanErr.Message)
+	case models.TooLargeErrorCode:
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmanErr.Message)
+	case models.UnauthorizedErrorCode:
+		return echo.NewHTTPError(http.StatusUnauthorized, fmanErr.Message)
+	case models.ForbiddenErrorCode:
+		return echo.NewHTTPError(http.StatusForbidden, fmanErr.Message)
+	case models.QuotaExceededErrorCode:
+		return echo.NewHTTPError(http.StatusInsufficientStorage, fmanErr.Message)
+	case models.ExpiredErrorCode:
+		return echo.NewHTTPError(http.StatusGone, fmanErr.Message)
+	case models.UnsupportedMediaTypeErrorCode:
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmanErr.Message)
+	default:
+		return err
+	}
+}
+
+// LogErr logs an error of an operation. FManErrors other than internal ones are caused
+// by the user and logged as such.
+func LogErr(logger *log.Entry, operation string, err error) {
+	var fmanErr models.FManError
+	if errors.As(err, &fmanErr) && fmanErr.Code != models.InternalErrorCode {
+		logger.Infof("[-USER-] %s", fmanErr.Message)
+		return
+	}
+	logger.Errorf("[-INTERNAL-] %s failed with error %s", operation, err.Error())
+}
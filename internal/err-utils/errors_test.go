@@ -0,0 +1,45 @@
+package errUtils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+func TestToHTTPError(t *testing.T) {
+	tests := []struct {
+		code   int
+		status int
+	}{
+		{models.NotFoundErrorCode, http.StatusNotFound},
+		{models.AlreadyExistErrorCode, http.StatusConflict},
+		{models.InvalidArgumentErrorCode, http.StatusBadRequest},
+		{models.ConflictErrorCode, http.StatusConflict},
+		{models.TooLargeErrorCode, http.StatusRequestEntityTooLarge},
+		{models.UnauthorizedErrorCode, http.StatusUnauthorized},
+		{models.ForbiddenErrorCode, http.StatusForbidden},
+		{models.QuotaExceededErrorCode, http.StatusInsufficientStorage},
+		{models.ExpiredErrorCode, http.StatusGone},
+		{models.UnsupportedMediaTypeErrorCode, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		// Wrapped errors are converted as well.
+		err := fmt.Errorf("wrapped: %w", models.NewFManError(tt.code, "message"))
+		var httpErr *echo.HTTPError
+		if !errors.As(ToHTTPError(err), &httpErr) || httpErr.Code != tt.status || httpErr.Message != "message" {
+			t.Errorf("ToHTTPError of code %d returned %v, want status %d", tt.code, ToHTTPError(err), tt.status)
+		}
+	}
+	internal := models.NewFManError(models.InternalErrorCode, "message")
+	if err := ToHTTPError(internal); err != internal {
+		t.Errorf("ToHTTPError of an internal error returned %v, want it unchanged", err)
+	}
+	other := errors.New("other")
+	if err := ToHTTPError(other); err != other {
+		t.Errorf("ToHTTPError of another error returned %v, want it unchanged", err)
+	}
+}
@@ -0,0 +1,173 @@
+package restful
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// ACLRequest represents a request to set an access control entry. permissions is a list
+// of permission names, e.g. ["read","write"]. An empty list revokes inherited permissions.
+type ACLRequest struct {
+	PrincipalType string   `json:"principal_type" form:"principal_type"`
+	PrincipalUUID string   `json:"principal_uuid" form:"principal_uuid"`
+	Permissions   []string `json:"permissions" form:"permissions"`
+}
+
+// GroupRequest represents a request to create a group.
+type GroupRequest struct {
+	Name string `json:"name" form:"name"`
+}
+
+// PermissionsResponse represents the permissions of the user on a file or a directory.
+type PermissionsResponse struct {
+	Permissions models.Permissions `json:"permissions"`
+}
+
+// initACLHandler initializes the endpoints managing the access control entries on files
+// and directories, and the groups of users.
+func initACLHandler(g *echo.Group, handler *FmanHandler) {
+	for _, itemType := range []string{models.EntryTypeFile, models.EntryTypeDir} {
+		g.GET("/"+itemType+"/:uuid/acl", handler.ListACL(itemType))
+		g.PUT("/"+itemType+"/:uuid/acl", handler.SetACLEntry(itemType))
+		g.DELETE("/"+itemType+"/:uuid/acl/:principal_type/:principal_uuid", handler.RemoveACLEntry(itemType))
+		g.GET("/"+itemType+"/:uuid/permissions", handler.ReadPermissions(itemType))
+	}
+	g.GET("/shared-with-me", handler.ListSharedWithMe)
+	g.POST("/groups", handler.CreateGroup)
+	g.GET("/groups", handler.ListGroups)
+	g.DELETE("/groups/:uuid", handler.RemoveGroup)
+	g.PUT("/groups/:uuid/members/:user_uuid", handler.AddGroupMember)
+	g.DELETE("/groups/:uuid/members/:user_uuid", handler.RemoveGroupMember)
+}
+
+// ListACL returns a handler, which returns the access control entries on a file or a
+// directory of the given type.
+func (h *FmanHandler) ListACL(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		entries, err := h.FmanUsecase.ListACL(authRestful.UserFromContext(c), itemType, c.Param("uuid"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		if entries == nil {
+			entries = []models.ACLEntry{}
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
+
+// SetACLEntry returns a handler, which grants permissions on a file or a directory of the
+// given type to a user or a group.
+func (h *FmanHandler) SetACLEntry(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := ACLRequest{}
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		permissions, err := models.ParsePermissions(req.Permissions)
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		entry := models.ACLEntry{
+			ItemUUID:      c.Param("uuid"),
+			ItemType:      itemType,
+			PrincipalType: req.PrincipalType,
+			PrincipalUUID: req.PrincipalUUID,
+			Permissions:   permissions,
+		}
+		if err := h.FmanUsecase.SetACLEntry(authRestful.UserFromContext(c), entry); err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Set access control entry successfully"})
+	}
+}
+
+// RemoveACLEntry returns a handler, which removes the access control entry of a user or a
+// group on a file or a directory of the given type.
+func (h *FmanHandler) RemoveACLEntry(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := h.FmanUsecase.RemoveACLEntry(authRestful.UserFromContext(c), itemType, c.Param("uuid"),
+			c.Param("principal_type"), c.Param("principal_uuid"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Removed access control entry successfully"})
+	}
+}
+
+// ReadPermissions returns a handler, which returns the permissions of the user on a file or
+// a directory of the given type.
+func (h *FmanHandler) ReadPermissions(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		permissions, err := h.FmanUsecase.ReadPermissions(authRestful.UserFromContext(c), itemType, c.Param("uuid"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, PermissionsResponse{Permissions: permissions})
+	}
+}
+
+// ListSharedWithMe returns the files and directories of other users shared with the user,
+// ordered by path.
+func (h *FmanHandler) ListSharedWithMe(c echo.Context) error {
+	items, err := h.FmanUsecase.ListSharedWithMe(authRestful.UserFromContext(c))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if items == nil {
+		items = []models.SharedEntry{}
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// CreateGroup creates a new group without members, and returns it.
+func (h *FmanHandler) CreateGroup(c echo.Context) error {
+	req := GroupRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	group, err := h.FmanUsecase.CreateGroup(authRestful.UserFromContext(c), req.Name)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusCreated, group)
+}
+
+// ListGroups returns all groups to admins, or the groups of the user otherwise.
+func (h *FmanHandler) ListGroups(c echo.Context) error {
+	groups, err := h.FmanUsecase.ListGroups(authRestful.UserFromContext(c))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if groups == nil {
+		groups = []models.Group{}
+	}
+	return c.JSON(http.StatusOK, groups)
+}
+
+// RemoveGroup removes a group.
+func (h *FmanHandler) RemoveGroup(c echo.Context) error {
+	if err := h.FmanUsecase.RemoveGroup(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Removed group successfully"})
+}
+
+// AddGroupMember adds a user to a group.
+func (h *FmanHandler) AddGroupMember(c echo.Context) error {
+	if err := h.FmanUsecase.AddGroupMember(authRestful.UserFromContext(c), c.Param("uuid"), c.Param("user_uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Added group member successfully"})
+}
+
+// RemoveGroupMember removes a user from a group.
+func (h *FmanHandler) RemoveGroupMember(c echo.Context) error {
+	if err := h.FmanUsecase.RemoveGroupMember(authRestful.UserFromContext(c), c.Param("uuid"), c.Param("user_uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Removed group member successfully"})
+}
@@ -0,0 +1,125 @@
+package restful
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// grant sets an access control entry on a file or a directory, which grants permissions
+// with the given names to a principal.
+func (s *testServer) grant(user testUser, itemType, itemUUID, principalType, principalUUID string, names ...string) {
+	s.t.Helper()
+	req := ACLRequest{PrincipalType: principalType, PrincipalUUID: principalUUID, Permissions: names}
+	if names == nil {
+		req.Permissions = []string{}
+	}
+	mustStatus(s.t, s.request(http.MethodPut, "/fman/"+itemType+"/"+itemUUID+"/acl", user, req), http.StatusOK)
+}
+
+// permissions reads the names of the permissions of a user on a file or a directory.
+func (s *testServer) permissions(user testUser, itemType, itemUUID string) []string {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/"+itemType+"/"+itemUUID+"/permissions", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var res struct {
+		Permissions []string `json:"permissions"`
+	}
+	decodeJSON(s.t, rec, &res)
+	return res.Permissions
+}
+
+func TestACLInheritance(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice, bob := s.register("alice"), s.register("bob")
+	team := s.mkdir(alice, "team", alice.RootDirUUID)
+	private := s.mkdir(alice, "private", team)
+	file := s.upload(alice, "a.txt", private, "a")
+
+	// Only admins manage groups.
+	admin := s.registerAdmin("admin")
+	mustStatus(t, s.request(http.MethodPost, "/fman/groups", alice, GroupRequest{Name: "devs"}), http.StatusForbidden)
+	rec := s.request(http.MethodPost, "/fman/groups", admin, GroupRequest{Name: "devs"})
+	mustStatus(t, rec, http.StatusCreated)
+	var group models.Group
+	decodeJSON(t, rec, &group)
+	mustStatus(t, s.request(http.MethodPut, "/fman/groups/"+group.UUID+"/members/"+bob.UUID, admin, nil), http.StatusOK)
+
+	// Group permissions are inherited down the tree.
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalGroup, group.UUID, "write")
+	if got := s.permissions(bob, models.EntryTypeFile, file.UUID); !reflect.DeepEqual(got, []string{"read", "write"}) {
+		t.Errorf("inherited permissions = %v, want read and write", got)
+	}
+	mustStatus(t, s.download(bob, file.UUID, nil), http.StatusOK)
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+file.UUID, bob, nil), http.StatusForbidden)
+
+	// An entry of the user overrides the group entries, and an entry deeper in the tree
+	// overrides the inherited ones, even without permissions.
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "read", "delete")
+	if got := s.permissions(bob, models.EntryTypeDir, private); !reflect.DeepEqual(got, []string{"read", "delete"}) {
+		t.Errorf("permissions with a user entry = %v, want read and delete", got)
+	}
+	s.grant(alice, models.EntryTypeDir, private, models.PrincipalUser, bob.UUID)
+	mustStatus(t, s.download(bob, file.UUID, nil), http.StatusForbidden)
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+private, bob, nil), http.StatusForbidden)
+	// Children, which the user cannot read, are hidden from listings.
+	if names, _ := s.listNames(bob, team, nil); len(names) != 0 {
+		t.Errorf("listing of team = %v, want private hidden", names)
+	}
+	mustStatus(t, s.request(http.MethodDelete, "/fman/dir/"+private+"/acl/user/"+bob.UUID, alice, nil), http.StatusOK)
+	mustStatus(t, s.download(bob, file.UUID, nil), http.StatusOK)
+
+	// Only users with the manage permission change the entries.
+	mustStatus(t, s.request(http.MethodPut, "/fman/dir/"+team+"/acl", bob,
+		ACLRequest{PrincipalType: models.PrincipalUser, PrincipalUUID: bob.UUID, Permissions: []string{"manage"}}), http.StatusForbidden)
+	rec = s.request(http.MethodGet, "/fman/dir/"+team+"/acl", alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var entries []models.ACLEntry
+	decodeJSON(t, rec, &entries)
+	if len(entries) != 2 || entries[0].PrincipalType != models.PrincipalUser {
+		t.Errorf("entries = %+v, want the user entry first", entries)
+	}
+}
+
+func TestSharedWithMe(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice, bob := s.register("alice"), s.register("bob")
+	team := s.mkdir(alice, "team", alice.RootDirUUID)
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "a")
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "write")
+	s.grant(alice, models.EntryTypeFile, file.UUID, models.PrincipalUser, bob.UUID, "read")
+
+	rec := s.request(http.MethodGet, "/fman/shared-with-me", bob, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var shared []models.SharedEntry
+	decodeJSON(t, rec, &shared)
+	if len(shared) != 2 {
+		t.Fatalf("shared with bob = %+v, want team and a.txt", shared)
+	}
+	for _, entry := range shared {
+		switch {
+		case entry.Directory != nil && entry.Directory.UUID == team:
+			if !entry.Permissions.Has(models.PermWrite) {
+				t.Errorf("permissions on team = %v, want write", entry.Permissions.Names())
+			}
+		case entry.File != nil && entry.File.UUID == file.UUID:
+			if entry.Permissions.Has(models.PermWrite) {
+				t.Errorf("permissions on a.txt = %v, want read only", entry.Permissions.Names())
+			}
+		default:
+			t.Errorf("unexpected shared entry %+v", entry)
+		}
+	}
+
+	// Files uploaded by a recipient belong to the owner of the directory.
+	uploaded := s.upload(bob, "b.txt", team, "b")
+	if uploaded.OwnerUUID != alice.UUID {
+		t.Errorf("owner of an upload into a shared directory = %s, want alice", uploaded.OwnerUUID)
+	}
+	if shared := s.request(http.MethodGet, "/fman/shared-with-me", alice, nil); shared.Body.String() != "[]\n" {
+		t.Errorf("shared with alice = %s, want nothing", shared.Body.String())
+	}
+}
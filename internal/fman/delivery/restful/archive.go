@@ -0,0 +1,125 @@
+package restful
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// archiveContentTypes maps the archive formats to their MIME types.
+var archiveContentTypes = map[string]string{
+	models.ArchiveFormatZip:   "application/zip",
+	models.ArchiveFormatTar:   "application/x-tar",
+	models.ArchiveFormatTarGz: "application/gzip",
+}
+
+// ArchiveRequest represents a request to download several files and directories as an
+// archive. An empty format defaults to zip.
+type ArchiveRequest struct {
+	Items  []models.ItemRef `json:"items"`
+	Format string           `json:"format" form:"format"`
+}
+
+// archiveExtensions maps the extensions of archive files to their formats.
+var archiveExtensions = map[string]string{
+	".zip":    models.ArchiveFormatZip,
+	".tar":    models.ArchiveFormatTar,
+	".tar.gz": models.ArchiveFormatTarGz,
+	".tgz":    models.ArchiveFormatTarGz,
+}
+
+// initArchiveHandler initializes the endpoints downloading files and directories as archives,
+// and extracting uploaded archives.
+func initArchiveHandler(g *echo.Group, handler *FmanHandler) {
+	g.GET("/dir/:uuid/archive", handler.DownloadDirectoryArchive)
+	g.POST("/archive", handler.DownloadArchive)
+	g.POST("/dir/:uuid/extract", handler.ExtractArchive)
+}
+
+// DownloadDirectoryArchive streams a directory together with its subtree as an archive of
+// the format given by the query param format, zip (default), tar or tar.gz.
+func (h *FmanHandler) DownloadDirectoryArchive(c echo.Context) error {
+	items := []models.ItemRef{{ItemType: models.EntryTypeDir, UUID: c.Param("uuid")}}
+	return h.streamArchive(c, items, c.QueryParam("format"))
+}
+
+// DownloadArchive streams several files and directories together with their subtrees as an
+// archive, e.g. {"items": [{"item_type": "file", "uuid": "..."}], "format": "tar.gz"}.
+func (h *FmanHandler) DownloadArchive(c echo.Context) error {
+	req := ArchiveRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	return h.streamArchive(c, req.Items, req.Format)
+}
+
+// streamArchive streams an archive of files and directories of a format while it is built.
+// Building it stops when the client disconnects, which is when the context of the request is
+// done.
+func (h *FmanHandler) streamArchive(c echo.Context, items []models.ItemRef, format string) error {
+	if format == "" {
+		format = models.ArchiveFormatZip
+	}
+	// The context of the request is taken here, as echo reuses c for another request once the
+	// handler returns, while the archive may still be built.
+	ctx := c.Request().Context()
+	archive, content, err := h.FmanUsecase.DownloadArchive(ctx, authRestful.UserFromContext(c), items, format)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	defer content.Close()
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentDisposition, contentDisposition("attachment", archive.Name))
+	res.Header().Set(echo.HeaderContentType, archiveContentTypes[archive.Format])
+	res.Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	res.WriteHeader(http.StatusOK)
+	// The size of the archive is unknown until it is built. If building it fails midway, the
+	// archive ends early without its trailer, so clients find it incomplete.
+	_, err = io.Copy(res, content)
+	return err
+}
+
+// ExtractArchive extracts an archive uploaded as the form file "file" into a directory. The
+// form value format gives its format, zip, tar or tar.gz. If it is empty, the format is taken
+// from the extension of the uploaded file.
+func (h *FmanHandler) ExtractArchive(c echo.Context) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return err
+	}
+	format := c.FormValue("format")
+	if format == "" {
+		format = archiveFormatOf(file.Filename)
+		if format == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("format of archive %s is unknown", file.Filename))
+		}
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	extraction, err := h.FmanUsecase.ExtractArchive(authRestful.UserFromContext(c), c.Param("uuid"), src, file.Size, format)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, extraction)
+}
+
+// archiveFormatOf returns the format of an archive file from the extension of its name, or
+// an empty string if the extension is unknown.
+func archiveFormatOf(filename string) string {
+	filename = strings.ToLower(filename)
+	for ext, format := range archiveExtensions {
+		if strings.HasSuffix(filename, ext) {
+			return format
+		}
+	}
+	return ""
+}
@@ -0,0 +1,509 @@
+package restful
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// archivedEntry is a file or a directory read from an archive.
+type archivedEntry struct {
+	content string
+	modTime time.Time
+}
+
+// readArchive reads the entries of an archive of a format, keyed by their paths. The paths
+// of directories end with a slash.
+func readArchive(t *testing.T, format string, archive []byte) map[string]archivedEntry {
+	t.Helper()
+	entries := make(map[string]archivedEntry)
+	if format == models.ArchiveFormatZip {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatalf("invalid ZIP archive: %s", err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("opening %s failed: %s", f.Name, err)
+			}
+			b, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatalf("reading %s failed: %s", f.Name, err)
+			}
+			entries[f.Name] = archivedEntry{string(b), f.Modified}
+		}
+		return entries
+	}
+	var r io.Reader = bytes.NewReader(archive)
+	if format == models.ArchiveFormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatalf("invalid gzip stream: %s", err)
+		}
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("invalid tar archive: %s", err)
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("reading %s failed: %s", header.Name, err)
+		}
+		entries[header.Name] = archivedEntry{string(b), header.ModTime}
+	}
+}
+
+// archiveContents returns the contents of archived entries keyed by their paths.
+func archiveContents(entries map[string]archivedEntry) map[string]string {
+	contents := make(map[string]string, len(entries))
+	for entryPath, entry := range entries {
+		contents[entryPath] = entry.content
+	}
+	return contents
+}
+
+func TestDownloadDirectoryArchive(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	photos := s.mkdir(alice, "photos", alice.RootDirUUID)
+	trip := s.mkdir(alice, "trip", photos)
+	s.mkdir(alice, "empty", photos)
+	beach := s.upload(alice, "beach.txt", trip, "sand and sea")
+	s.upload(alice, "index.txt", photos, "all photos")
+	want := map[string]string{
+		"photos/":               "",
+		"photos/trip/":          "",
+		"photos/empty/":         "",
+		"photos/trip/beach.txt": "sand and sea",
+		"photos/index.txt":      "all photos",
+	}
+
+	for _, format := range []string{models.ArchiveFormatZip, models.ArchiveFormatTar, models.ArchiveFormatTarGz} {
+		rec := s.request(http.MethodGet, "/fman/dir/"+photos+"/archive?format="+format, alice, nil)
+		mustStatus(t, rec, http.StatusOK)
+		if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, `filename="photos.`+format+`"`) {
+			t.Errorf("Content-Disposition of the %s archive = %q", format, cd)
+		}
+		entries := readArchive(t, format, rec.Body.Bytes())
+		if got := archiveContents(entries); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s archive holds %v, want %v", format, got, want)
+		}
+		// Timestamps are kept to the second.
+		if d := entries["photos/trip/beach.txt"].modTime.Sub(beach.UpdatedAt); d < -time.Second || d > time.Second {
+			t.Errorf("%s archive has beach.txt modified at %s, want %s", format, entries["photos/trip/beach.txt"].modTime,
+				beach.UpdatedAt)
+		}
+	}
+	// ZIP is the default format.
+	rec := s.request(http.MethodGet, "/fman/dir/"+photos+"/archive", alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "application/zip" {
+		t.Errorf("Content-Type = %q, want application/zip", ct)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+photos+"/archive?format=rar", alice, nil), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+photos+"/archive", s.register("bob"), nil), http.StatusForbidden)
+
+	// The content of a root directory is put at the top of the archive.
+	rec = s.request(http.MethodGet, "/fman/dir/"+alice.RootDirUUID+"/archive", alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if got := archiveContents(readArchive(t, models.ArchiveFormatZip, rec.Body.Bytes())); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive of the root directory holds %v, want %v", got, want)
+	}
+}
+
+func TestDownloadArchiveOfSelection(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice, bob := s.register("alice"), s.register("bob")
+	docs := s.mkdir(alice, "docs", alice.RootDirUUID)
+	private := s.mkdir(alice, "private", docs)
+	s.upload(alice, "secret.txt", private, "secret")
+	report := s.upload(alice, "report.txt", docs, "docs report")
+	other := s.upload(alice, "report.txt", alice.RootDirUUID, "root report")
+	s.grant(alice, models.EntryTypeDir, docs, models.PrincipalUser, bob.UUID, "read")
+	s.grant(alice, models.EntryTypeDir, private, models.PrincipalUser, bob.UUID)
+	s.grant(alice, models.EntryTypeFile, other.UUID, models.PrincipalUser, bob.UUID, "read")
+
+	// Items of the same name get numbered names, and what the user cannot read is left out.
+	req := ArchiveRequest{Format: models.ArchiveFormatTarGz, Items: []models.ItemRef{
+		{ItemType: models.EntryTypeFile, UUID: report.UUID},
+		{ItemType: models.EntryTypeFile, UUID: other.UUID},
+		{ItemType: models.EntryTypeDir, UUID: docs},
+		{ItemType: models.EntryTypeFile, UUID: report.UUID},
+	}}
+	rec := s.request(http.MethodPost, "/fman/archive", bob, req)
+	mustStatus(t, rec, http.StatusOK)
+	if cd := rec.Header().Get(echo.HeaderContentDisposition); !strings.Contains(cd, `filename="download.tar.gz"`) {
+		t.Errorf("Content-Disposition = %q, want download.tar.gz", cd)
+	}
+	want := map[string]string{
+		"report.txt":      "docs report",
+		"report (1).txt":  "root report",
+		"docs/":           "",
+		"docs/report.txt": "docs report",
+	}
+	if got := archiveContents(readArchive(t, models.ArchiveFormatTarGz, rec.Body.Bytes())); !reflect.DeepEqual(got, want) {
+		t.Errorf("archive holds %v, want %v", got, want)
+	}
+
+	// A selection with an item the user cannot read at all is rejected.
+	req.Items = append(req.Items, models.ItemRef{ItemType: models.EntryTypeDir, UUID: private})
+	mustStatus(t, s.request(http.MethodPost, "/fman/archive", bob, req), http.StatusForbidden)
+	for _, items := range [][]models.ItemRef{
+		nil,
+		{{ItemType: "link", UUID: report.UUID}},
+		make([]models.ItemRef, models.MaxArchiveItems+1),
+	} {
+		mustStatus(t, s.request(http.MethodPost, "/fman/archive", alice, ArchiveRequest{Items: items}), http.StatusBadRequest)
+	}
+}
+
+func TestDownloadArchiveCancelled(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	file := s.upload(alice, "big.bin", alice.RootDirUUID, strings.Repeat("0123456789", 100000))
+	items := []models.ItemRef{{ItemType: models.EntryTypeFile, UUID: file.UUID}}
+
+	// Closing the content midway stops building the archive.
+	_, content, err := s.uc.DownloadArchive(context.Background(), alice.User, items, models.ArchiveFormatTar)
+	if err != nil {
+		t.Fatalf("DownloadArchive failed: %s", err)
+	}
+	if _, err := io.ReadFull(content, make([]byte, 1000)); err != nil {
+		t.Fatalf("reading the archive failed: %s", err)
+	}
+	content.Close()
+	if _, err := content.Read(make([]byte, 1)); err != io.ErrClosedPipe {
+		t.Errorf("reading a closed archive returned %v, want io.ErrClosedPipe", err)
+	}
+
+	// So does a client disconnecting midway, which is when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, content, err = s.uc.DownloadArchive(ctx, alice.User, items, models.ArchiveFormatTar)
+	if err != nil {
+		t.Fatalf("DownloadArchive failed: %s", err)
+	}
+	defer content.Close()
+	if _, err := io.ReadFull(content, make([]byte, 1000)); err != nil {
+		t.Fatalf("reading the archive failed: %s", err)
+	}
+	cancel()
+	if _, err := ioutil.ReadAll(content); err != context.Canceled {
+		t.Errorf("reading the archive after the cancellation returned %v, want context.Canceled", err)
+	}
+
+	// The request is cancelled while the archive is streamed, which must not race with echo
+	// reusing the context of the handler.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/fman/dir/"+alice.RootDirUUID+"/archive?format=tar", nil)
+	req = req.WithContext(ctx)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+alice.token)
+	w := &cancellingResponseWriter{ResponseRecorder: httptest.NewRecorder(), cancel: cancel, after: 1000}
+	s.e.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.Len() >= 1000000 {
+		t.Errorf("cancelled download got status %d and %d bytes, want %d and the archive cut off", w.Code,
+			w.Body.Len(), http.StatusOK)
+	}
+}
+
+// cancellingResponseWriter cancels a request once more bytes than a limit were written to it,
+// as a client disconnecting midway.
+type cancellingResponseWriter struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+	after  int
+}
+
+func (w *cancellingResponseWriter) Write(p []byte) (int, error) {
+	if w.Body.Len() >= w.after {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+// testArchiveEntry is an entry of an archive built by buildArchive.
+type testArchiveEntry struct {
+	name, content string
+	// typeflag is the type of a tar entry. ZIP entries are directories for tar.TypeDir and
+	// symbolic links for tar.TypeSymlink.
+	typeflag byte
+}
+
+// buildArchive builds an archive of a format with entries, which are regular files unless
+// their type says otherwise.
+func buildArchive(t *testing.T, format string, entries []testArchiveEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	if format == models.ArchiveFormatZip {
+		zw := zip.NewWriter(&buf)
+		for _, entry := range entries {
+			header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+			switch entry.typeflag {
+			case tar.TypeDir:
+				header.SetMode(os.ModeDir | 0755)
+			case tar.TypeSymlink:
+				header.SetMode(os.ModeSymlink | 0777)
+			default:
+				header.SetMode(0644)
+			}
+			var w io.Writer
+			if w, err = zw.CreateHeader(header); err == nil {
+				_, err = io.WriteString(w, entry.content)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if format == models.ArchiveFormatTarGz {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Mode: 0644, Size: int64(len(entry.content))}
+		switch entry.typeflag {
+		case 0:
+			header.Typeflag = tar.TypeReg
+		case tar.TypeSymlink:
+			header.Linkname = "/etc/passwd"
+		}
+		if err = tw.WriteHeader(header); err == nil {
+			_, err = io.WriteString(tw, entry.content)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tw.Close()
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// extract uploads an archive named filename and extracts it into a directory. The format is
+// taken from the name unless it is given.
+func (s *testServer) extract(user testUser, dirUUID, filename, format string, archive []byte) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	var err error
+	if format != "" {
+		err = w.WriteField("format", format)
+	}
+	var part io.Writer
+	if err == nil {
+		part, err = w.CreateFormFile("file", filename)
+	}
+	if err == nil {
+		_, err = part.Write(archive)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/fman/dir/"+dirUUID+"/extract", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return s.serve(req, user)
+}
+
+func TestExtractArchive(t *testing.T) {
+	entries := []testArchiveEntry{
+		{name: "project/", typeflag: tar.TypeDir},
+		{name: "project/README.md", content: "# Project"},
+		// Intermediate directories are created without entries of their own.
+		{name: "project/src/cmd/main.go", content: "package main"},
+		{name: "./notes.txt", content: "notes"},
+		{name: "project/link", typeflag: tar.TypeSymlink},
+	}
+	for _, format := range []string{models.ArchiveFormatZip, models.ArchiveFormatTar, models.ArchiveFormatTarGz} {
+		s := newTestServer(t, usecase.Options{})
+		alice := s.register("alice")
+		imports := s.mkdir(alice, "imports", alice.RootDirUUID)
+		// An existing directory is extracted into.
+		project := s.mkdir(alice, "project", imports)
+
+		rec := s.extract(alice, imports, "upload."+format, "", buildArchive(t, format, entries))
+		mustStatus(t, rec, http.StatusOK)
+		var extraction models.Extraction
+		decodeJSON(t, rec, &extraction)
+		want := models.Extraction{Format: format, Files: 3, Dirs: 2, Bytes: 26, Skipped: []string{"project/link"}}
+		if !reflect.DeepEqual(extraction, want) {
+			t.Errorf("%s extraction = %+v, want %+v", format, extraction, want)
+		}
+		if names, _ := s.listNames(alice, imports, nil); !reflect.DeepEqual(names, []string{"project/", "notes.txt"}) {
+			t.Errorf("%s: imports holds %v, want project and notes.txt", format, names)
+		}
+		if names, _ := s.listNames(alice, project, nil); !reflect.DeepEqual(names, []string{"src/", "README.md"}) {
+			t.Errorf("%s: project holds %v, want src and README.md", format, names)
+		}
+		main, err := s.repo.ReadFileRecordByName("main.go", s.dirByPath(alice, imports, "project/src/cmd"))
+		if err != nil {
+			t.Fatalf("%s: ReadFileRecordByName failed: %s", format, err)
+		}
+		if got := s.download(alice, main.UUID, nil).Body.String(); got != "package main" {
+			t.Errorf("%s: content of main.go = %q", format, got)
+		}
+	}
+}
+
+// dirByPath returns the UUID of the directory with a relative path in a directory.
+func (s *testServer) dirByPath(user testUser, dirUUID, dirPath string) string {
+	s.t.Helper()
+	for _, dirname := range strings.Split(dirPath, "/") {
+		dir, err := s.repo.ReadDirRecordByName(dirname, dirUUID)
+		if err != nil {
+			s.t.Fatalf("ReadDirRecordByName of %s failed: %s", dirname, err)
+		}
+		dirUUID = dir.UUID
+	}
+	return dirUUID
+}
+
+func TestExtractArchiveFormat(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	bob := s.register("bob")
+	root := alice.RootDirUUID
+	tarGz := buildArchive(t, models.ArchiveFormatTarGz, []testArchiveEntry{{name: "a.txt", content: "a"}})
+
+	// The format is taken from the extension unless it is given.
+	mustStatus(t, s.extract(alice, root, "upload.tgz", "", tarGz), http.StatusOK)
+	mustStatus(t, s.extract(alice, s.mkdir(alice, "given", root), "upload.bin", models.ArchiveFormatTarGz, tarGz),
+		http.StatusOK)
+	mustStatus(t, s.extract(alice, root, "upload.rar", "", tarGz), http.StatusBadRequest)
+	mustStatus(t, s.extract(alice, root, "upload.zip", "", tarGz), http.StatusBadRequest)
+	mustStatus(t, s.extract(bob, root, "upload.tgz", "", tarGz), http.StatusForbidden)
+}
+
+func TestExtractArchiveRejectsUnsafePaths(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	for _, entries := range [][]testArchiveEntry{
+		{{name: "ok.txt", content: "ok"}, {name: "dir/../../x", content: "x"}},
+		{{name: "ok.txt", content: "ok"}, {name: "/etc/x", content: "x"}},
+		{{name: "ok.txt", content: "ok"}, {name: `C:\x`, content: "x"}},
+		{{name: "ok.txt", content: "ok"}, {name: "ok.txt/x", content: "x"}},
+		{{name: "dir/x", content: "x"}, {name: "dir", content: "dir"}},
+		{{name: "ok.txt", content: "ok"}, {name: "ok.txt", content: "again"}},
+	} {
+		for _, format := range []string{models.ArchiveFormatZip, models.ArchiveFormatTar} {
+			rec := s.extract(alice, root, "upload."+format, "", buildArchive(t, format, entries))
+			mustStatus(t, rec, http.StatusBadRequest)
+		}
+	}
+	if names, _ := s.listNames(alice, root, nil); len(names) != 0 {
+		t.Errorf("root holds %v after rejected archives, want nothing", names)
+	}
+	if n := s.countStoredFiles(); n != 0 {
+		t.Errorf("%d files stored after rejected archives, want 0", n)
+	}
+}
+
+func TestExtractArchiveLimits(t *testing.T) {
+	entries := []testArchiveEntry{
+		{name: "a.txt", content: "1234567890"},
+		{name: "b.txt", content: "1234567890"},
+		{name: "c.txt", content: "1234567890"},
+	}
+	// A small archive of highly compressible content.
+	bomb := []testArchiveEntry{{name: "bomb.txt", content: strings.Repeat("0", 1<<20)}}
+	for _, c := range []struct {
+		name    string
+		limits  models.ExtractionLimits
+		entries []testArchiveEntry
+	}{
+		{"entries", models.ExtractionLimits{MaxEntries: 2}, entries},
+		{"bytes", models.ExtractionLimits{MaxBytes: 25}, entries},
+		{"ratio", models.ExtractionLimits{}, bomb},
+	} {
+		s := newTestServer(t, usecase.Options{ExtractionLimits: c.limits})
+		alice := s.register("alice")
+		for _, format := range []string{models.ArchiveFormatZip, models.ArchiveFormatTarGz} {
+			rec := s.extract(alice, alice.RootDirUUID, "upload."+format, "", buildArchive(t, format, c.entries))
+			mustStatus(t, rec, http.StatusRequestEntityTooLarge)
+		}
+		if n := s.countStoredFiles(); n != 0 {
+			t.Errorf("%s: %d files stored after rejected archives, want 0", c.name, n)
+		}
+	}
+}
+
+func TestExtractArchiveExistingFiles(t *testing.T) {
+	archive := buildArchive(t, models.ArchiveFormatZip, []testArchiveEntry{
+		{name: "new/a.txt", content: "a"},
+		{name: "b.txt", content: "new b"},
+	})
+
+	// Without versioning, an existing file is a conflict and everything extracted so far is
+	// removed again.
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	s.upload(alice, "b.txt", root, "old b")
+	mustStatus(t, s.extract(alice, root, "upload.zip", "", archive), http.StatusConflict)
+	if names, _ := s.listNames(alice, root, nil); !reflect.DeepEqual(names, []string{"b.txt"}) {
+		t.Errorf("root holds %v after a conflict, want only b.txt", names)
+	}
+	if n := s.countStoredFiles(); n != 1 {
+		t.Errorf("%d files stored after a conflict, want 1", n)
+	}
+
+	// With versioning, it gets a new version.
+	s = newTestServer(t, usecase.Options{Versioning: true})
+	alice = s.register("alice")
+	root = alice.RootDirUUID
+	b := s.upload(alice, "b.txt", root, "old b")
+	rec := s.extract(alice, root, "upload.zip", "", archive)
+	mustStatus(t, rec, http.StatusOK)
+	var extraction models.Extraction
+	decodeJSON(t, rec, &extraction)
+	if extraction.Files != 2 || extraction.Dirs != 1 {
+		t.Errorf("extraction = %+v, want 2 files and 1 directory", extraction)
+	}
+	if got := s.download(alice, b.UUID, nil).Body.String(); got != "new b" {
+		t.Errorf("content of b.txt = %q, want the extracted one", got)
+	}
+	if n := len(s.listVersions(alice, b.UUID)); n != 2 {
+		t.Errorf("b.txt has %d versions, want 2", n)
+	}
+}
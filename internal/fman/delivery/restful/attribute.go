@@ -0,0 +1,158 @@
+package restful
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// MetadataRequest represents a request to set the value of a metadata key.
+type MetadataRequest struct {
+	Value string `json:"value" form:"value"`
+}
+
+// TagItemsRequest represents a request to tag several files and directories at once.
+type TagItemsRequest struct {
+	Items []models.ItemRef `json:"items"`
+}
+
+// initAttributeHandler initializes the endpoints managing the tags and the metadata of files
+// and directories.
+func initAttributeHandler(g *echo.Group, handler *FmanHandler) {
+	for _, itemType := range []string{models.EntryTypeFile, models.EntryTypeDir} {
+		g.GET("/"+itemType+"/:uuid/tags", handler.ListTags(itemType))
+		g.PUT("/"+itemType+"/:uuid/tags/:tag", handler.AddTag(itemType))
+		g.DELETE("/"+itemType+"/:uuid/tags/:tag", handler.RemoveTag(itemType))
+		g.GET("/"+itemType+"/:uuid/metadata", handler.ListMetadata(itemType))
+		g.PUT("/"+itemType+"/:uuid/metadata/:key", handler.SetMetadata(itemType))
+		g.DELETE("/"+itemType+"/:uuid/metadata/:key", handler.RemoveMetadata(itemType))
+	}
+	g.GET("/tags/:tag", handler.ListTagged)
+	g.POST("/tags/:tag", handler.TagItems)
+}
+
+// ListTags returns a handler, which returns the tags of a file or a directory of the given
+// type.
+func (h *FmanHandler) ListTags(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tags, err := h.FmanUsecase.ListTags(authRestful.UserFromContext(c), itemType, c.Param("uuid"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		if tags == nil {
+			tags = []string{}
+		}
+		return c.JSON(http.StatusOK, tags)
+	}
+}
+
+// AddTag returns a handler, which adds a tag to a file or a directory of the given type.
+func (h *FmanHandler) AddTag(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := h.FmanUsecase.AddTag(authRestful.UserFromContext(c), itemType, c.Param("uuid"), unescapedParam(c, "tag"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Added tag successfully"})
+	}
+}
+
+// RemoveTag returns a handler, which removes a tag from a file or a directory of the given
+// type.
+func (h *FmanHandler) RemoveTag(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := h.FmanUsecase.RemoveTag(authRestful.UserFromContext(c), itemType, c.Param("uuid"), unescapedParam(c, "tag"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Removed tag successfully"})
+	}
+}
+
+// ListMetadata returns a handler, which returns the metadata of a file or a directory of the
+// given type.
+func (h *FmanHandler) ListMetadata(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		entries, err := h.FmanUsecase.ListMetadata(authRestful.UserFromContext(c), itemType, c.Param("uuid"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		if entries == nil {
+			entries = []models.MetadataEntry{}
+		}
+		return c.JSON(http.StatusOK, entries)
+	}
+}
+
+// SetMetadata returns a handler, which sets a metadata key of a file or a directory of the
+// given type.
+func (h *FmanHandler) SetMetadata(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := MetadataRequest{}
+		if err := c.Bind(&req); err != nil {
+			return err
+		}
+		err := h.FmanUsecase.SetMetadata(authRestful.UserFromContext(c), itemType, c.Param("uuid"), unescapedParam(c, "key"),
+			req.Value)
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Set metadata successfully"})
+	}
+}
+
+// RemoveMetadata returns a handler, which removes a metadata key of a file or a directory of
+// the given type.
+func (h *FmanHandler) RemoveMetadata(itemType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := h.FmanUsecase.RemoveMetadata(authRestful.UserFromContext(c), itemType, c.Param("uuid"), unescapedParam(c, "key"))
+		if err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Removed metadata successfully"})
+	}
+}
+
+// TagItems adds a tag to several files and directories at once.
+func (h *FmanHandler) TagItems(c echo.Context) error {
+	req := TagItemsRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.FmanUsecase.TagItems(authRestful.UserFromContext(c), unescapedParam(c, "tag"), req.Items); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Tagged items successfully"})
+}
+
+// ListTagged returns a page of the files and directories carrying a tag, ordered by path.
+// It takes the same query params as Search, where q is optional.
+func (h *FmanHandler) ListTagged(c echo.Context) error {
+	opts, err := searchOptions(c)
+	if err != nil {
+		return err
+	}
+	opts.Tag = unescapedParam(c, "tag")
+	results, nextOffset, err := h.FmanUsecase.Search(authRestful.UserFromContext(c), c.QueryParam("q"), opts)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	return c.JSON(http.StatusOK, SearchResponse{Results: results, NextOffset: nextOffset})
+}
+
+// unescapedParam returns a path param, which may still be escaped if the path contains
+// escaped characters allowed unescaped, e.g. %2B for +.
+func unescapedParam(c echo.Context, name string) string {
+	param := c.Param(name)
+	if unescaped, err := url.PathUnescape(param); err == nil {
+		return unescaped
+	}
+	return param
+}
@@ -0,0 +1,165 @@
+package restful
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// tags lists the tags of a file or a directory.
+func (s *testServer) tags(user testUser, itemType, itemUUID string) []string {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/"+itemType+"/"+itemUUID+"/tags", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var tags []string
+	decodeJSON(s.t, rec, &tags)
+	return tags
+}
+
+// metadata lists the metadata of a file or a directory as a map.
+func (s *testServer) metadata(user testUser, itemType, itemUUID string) map[string]string {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/"+itemType+"/"+itemUUID+"/metadata", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var entries []models.MetadataEntry
+	decodeJSON(s.t, rec, &entries)
+	metadata := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		metadata[entry.Key] = entry.Value
+	}
+	return metadata
+}
+
+// tagged lists the sorted names of the files and directories carrying a tag.
+func (s *testServer) tagged(user testUser, tag string) []string {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/tags/"+url.PathEscape(tag), user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var res SearchResponse
+	decodeJSON(s.t, rec, &res)
+	names := []string{}
+	for _, result := range res.Results {
+		if result.Directory != nil {
+			names = append(names, result.Directory.Dirname+"/")
+		} else {
+			names = append(names, result.File.Filename)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTags(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	docs := s.mkdir(alice, "docs", alice.RootDirUUID)
+	file := s.upload(alice, "a.txt", docs, "a")
+	other := s.upload(alice, "b.txt", alice.RootDirUUID, "b")
+
+	// Tags are normalized to lower case, and adding one twice keeps it once.
+	for _, tag := range []string{"Urgent", "urgent", "c++"} {
+		mustStatus(t, s.request(http.MethodPut, "/fman/file/"+file.UUID+"/tags/"+url.PathEscape(tag), alice, nil), http.StatusOK)
+	}
+	if got := s.tags(alice, models.EntryTypeFile, file.UUID); !reflect.DeepEqual(got, []string{"c++", "urgent"}) {
+		t.Errorf("tags = %v, want c++ and urgent", got)
+	}
+	mustStatus(t, s.request(http.MethodPut, "/fman/file/"+file.UUID+"/tags/"+url.PathEscape("no spaces"), alice, nil),
+		http.StatusBadRequest)
+
+	// A selection is tagged at once, and everything carrying a tag is listed.
+	req := TagItemsRequest{Items: []models.ItemRef{
+		{ItemType: models.EntryTypeDir, UUID: docs},
+		{ItemType: models.EntryTypeFile, UUID: other.UUID},
+	}}
+	mustStatus(t, s.request(http.MethodPost, "/fman/tags/urgent", alice, req), http.StatusOK)
+	if got := s.tagged(alice, "URGENT"); !reflect.DeepEqual(got, []string{"a.txt", "b.txt", "docs/"}) {
+		t.Errorf("tagged urgent = %v, want a.txt, b.txt and docs", got)
+	}
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+file.UUID+"/tags/urgent", alice, nil), http.StatusOK)
+	if got := s.tagged(alice, "urgent"); !reflect.DeepEqual(got, []string{"b.txt", "docs/"}) {
+		t.Errorf("tagged urgent after a removal = %v, want b.txt and docs", got)
+	}
+
+	// Tagging needs the write permission on every item of the selection.
+	bob := s.register("bob")
+	mustStatus(t, s.request(http.MethodPost, "/fman/tags/mine", bob, req), http.StatusForbidden)
+	if got := s.tagged(alice, "mine"); len(got) != 0 {
+		t.Errorf("tagged mine = %v, want nothing", got)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/file/"+file.UUID+"/tags", bob, nil), http.StatusForbidden)
+	if got := s.tagged(bob, "urgent"); len(got) != 0 {
+		t.Errorf("bob found %v tagged urgent, want nothing", got)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "a")
+	target := "/fman/file/" + file.UUID + "/metadata/"
+
+	mustStatus(t, s.request(http.MethodPut, target+"project", alice, MetadataRequest{Value: "apollo"}), http.StatusOK)
+	mustStatus(t, s.request(http.MethodPut, target+"reviewed.by", alice, MetadataRequest{Value: "bob"}), http.StatusOK)
+	mustStatus(t, s.request(http.MethodPut, target+"project", alice, MetadataRequest{Value: "gemini"}), http.StatusOK)
+	want := map[string]string{"project": "gemini", "reviewed.by": "bob"}
+	if got := s.metadata(alice, models.EntryTypeFile, file.UUID); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+	mustStatus(t, s.request(http.MethodPut, target+url.PathEscape("bad key"), alice, MetadataRequest{Value: "x"}), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodDelete, target+"project", alice, nil), http.StatusOK)
+	if got := s.metadata(alice, models.EntryTypeFile, file.UUID); !reflect.DeepEqual(got, map[string]string{"reviewed.by": "bob"}) {
+		t.Errorf("metadata after a removal = %v, want reviewed.by only", got)
+	}
+	mustStatus(t, s.request(http.MethodPut, target+"project", s.register("bob"), MetadataRequest{Value: "x"}), http.StatusForbidden)
+}
+
+func TestAttributesPreserved(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	docs := s.mkdir(alice, "docs", alice.RootDirUUID)
+	file := s.upload(alice, "a.txt", docs, "a")
+	mustStatus(t, s.request(http.MethodPut, "/fman/file/"+file.UUID+"/tags/draft", alice, nil), http.StatusOK)
+	mustStatus(t, s.request(http.MethodPut, "/fman/file/"+file.UUID+"/metadata/project", alice, MetadataRequest{Value: "apollo"}),
+		http.StatusOK)
+	mustStatus(t, s.request(http.MethodPut, "/fman/dir/"+docs+"/tags/team", alice, nil), http.StatusOK)
+
+	// Moves keep the attributes, and copies get their own.
+	archive := s.mkdir(alice, "archive", alice.RootDirUUID)
+	if err := s.uc.MoveFile(alice.User, file.UUID, "b.txt", archive); err != nil {
+		t.Fatalf("MoveFile failed: %s", err)
+	}
+	if err := s.uc.CopyFile(alice.User, file.UUID, docs); err != nil {
+		t.Fatalf("CopyFile failed: %s", err)
+	}
+	dirCopy := s.mkdir(alice, "copies", alice.RootDirUUID)
+	mustStatus(t, s.request(http.MethodPost, "/fman/dir/"+docs+"/copy", alice, CopyRequest{ParentUUID: dirCopy}),
+		http.StatusOK)
+	copied, err := s.repo.ReadFileRecordByName("b.txt", docs)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	copiedDir, err := s.repo.ReadDirRecordByName("docs", dirCopy)
+	if err != nil {
+		t.Fatalf("ReadDirRecordByName failed: %s", err)
+	}
+	for _, uuid := range []string{file.UUID, copied.UUID} {
+		if got := s.tags(alice, models.EntryTypeFile, uuid); !reflect.DeepEqual(got, []string{"draft"}) {
+			t.Errorf("tags of %s = %v, want draft", uuid, got)
+		}
+		if got := s.metadata(alice, models.EntryTypeFile, uuid); got["project"] != "apollo" {
+			t.Errorf("metadata of %s = %v, want project apollo", uuid, got)
+		}
+	}
+	if got := s.tags(alice, models.EntryTypeDir, copiedDir.UUID); !reflect.DeepEqual(got, []string{"team"}) {
+		t.Errorf("tags of the copied directory = %v, want team", got)
+	}
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+copied.UUID+"/tags/draft", alice, nil), http.StatusOK)
+	if got := s.tags(alice, models.EntryTypeFile, file.UUID); !reflect.DeepEqual(got, []string{"draft"}) {
+		t.Errorf("tags of the original after changing the copy = %v, want draft", got)
+	}
+}
@@ -0,0 +1,91 @@
+package restful
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+const (
+	headerDigest     = "Digest"
+	headerContentMD5 = "Content-MD5"
+)
+
+// checksumsFromHeader returns the checksums a client expects the uploaded content to have,
+// which are sent as a Digest header of RFC 3230 with the sha-256 and md5 algorithms, or as
+// a Content-MD5 header. Other algorithms are ignored.
+func checksumsFromHeader(header http.Header) (models.Checksums, error) {
+	var expected models.Checksums
+	for _, value := range header.Values(headerDigest) {
+		for _, instance := range strings.Split(value, ",") {
+			algorithm, encoded := instance, ""
+			if i := strings.IndexByte(instance, '='); i >= 0 {
+				algorithm, encoded = instance[:i], instance[i+1:]
+			}
+			var err error
+			switch strings.ToLower(strings.TrimSpace(algorithm)) {
+			case "sha-256":
+				expected.SHA256, err = decodeDigest(headerDigest, encoded, 32)
+			case "md5":
+				expected.MD5, err = decodeDigest(headerDigest, encoded, 16)
+			}
+			if err != nil {
+				return models.Checksums{}, err
+			}
+		}
+	}
+	if encoded := header.Get(headerContentMD5); encoded != "" {
+		md5, err := decodeDigest(headerContentMD5, encoded, 16)
+		if err != nil {
+			return models.Checksums{}, err
+		}
+		if expected.MD5 != "" && expected.MD5 != md5 {
+			return models.Checksums{}, echo.NewHTTPError(http.StatusBadRequest,
+				"Digest and Content-MD5 headers give different MD5 digests")
+		}
+		expected.MD5 = md5
+	}
+	return expected, nil
+}
+
+// uploadChecksums returns the checksums a client expects a file uploaded in a multipart form
+// to have. They are taken from the headers of the file part, or from the headers of the
+// request if the part has none.
+func uploadChecksums(c echo.Context, partHeader textproto.MIMEHeader) (models.Checksums, error) {
+	expected, err := checksumsFromHeader(http.Header(partHeader))
+	if err != nil || expected != (models.Checksums{}) {
+		return expected, err
+	}
+	return checksumsFromHeader(c.Request().Header)
+}
+
+// decodeDigest converts a base64-encoded digest of a length in bytes, sent in a header, to
+// hex.
+func decodeDigest(header, encoded string, length int) (string, error) {
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(digest) != length {
+		return "", echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s header has an invalid digest", header))
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// digestHeader returns a Digest header value of RFC 3230 with the digests of a file's
+// content, or an empty string if they are unknown.
+func digestHeader(file models.File) string {
+	var instances []string
+	for _, digest := range []struct{ algorithm, hex string }{
+		{"sha-256", file.ContentHash},
+		{"md5", file.ContentMD5},
+	} {
+		if raw, err := hex.DecodeString(digest.hex); err == nil && len(raw) > 0 {
+			instances = append(instances, digest.algorithm+"="+base64.StdEncoding.EncodeToString(raw))
+		}
+	}
+	return strings.Join(instances, ",")
+}
@@ -0,0 +1,89 @@
+package restful
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+)
+
+// putContentWithHeader replaces the content of a file, sending a header with the request.
+func (s *testServer) putContentWithHeader(user testUser, fileUUID, content string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/fman/file/"+fileUUID+"/content", strings.NewReader(content))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return s.serve(req, user)
+}
+
+func TestChecksums(t *testing.T) {
+	s := newTestServer(t, usecase.Options{Versioning: true})
+	alice := s.register("alice")
+	content := "hello world"
+	sha := sha256.Sum256([]byte(content))
+	sum := md5.Sum([]byte(content))
+	sha256Digest := "sha-256=" + base64.StdEncoding.EncodeToString(sha[:])
+	md5Digest := base64.StdEncoding.EncodeToString(sum[:])
+
+	// The digests are stored and returned on downloads and in listings.
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, content)
+	if file.ContentHash != hex.EncodeToString(sha[:]) || file.ContentMD5 != hex.EncodeToString(sum[:]) {
+		t.Errorf("digests = %s and %s, want the SHA-256 and MD5 of the content", file.ContentHash, file.ContentMD5)
+	}
+	rec := s.download(alice, file.UUID, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if got, want := rec.Header().Get("Digest"), sha256Digest+",md5="+md5Digest; got != want {
+		t.Errorf("Digest = %q, want %q", got, want)
+	}
+	rec = s.request(http.MethodGet, "/fman/dir/"+alice.RootDirUUID, alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var res DirListingResponse
+	decodeJSON(t, rec, &res)
+	if files := res.Directory.ListOfFiles; len(files) != 1 || files[0].ContentHash != file.ContentHash ||
+		files[0].ContentMD5 != file.ContentMD5 {
+		t.Errorf("listed files = %+v, want a.txt with its digests", files)
+	}
+
+	// Content with the expected digests is accepted.
+	for _, header := range []http.Header{
+		{"Digest": {sha256Digest}},
+		{"Digest": {"SHA-256=" + base64.StdEncoding.EncodeToString(sha[:]) + ", unixsum=30637"}},
+		{"Content-Md5": {md5Digest}},
+		{"Digest": {"md5=" + md5Digest}, "Content-Md5": {md5Digest}},
+	} {
+		mustStatus(t, s.putContentWithHeader(alice, file.UUID, content, header), http.StatusOK)
+	}
+	versions := len(s.listVersions(alice, file.UUID))
+
+	// Content with other digests is rejected and not kept, as are invalid headers.
+	stored := s.countStoredFiles()
+	for _, header := range []http.Header{
+		{"Digest": {sha256Digest}},
+		{"Content-Md5": {md5Digest}},
+	} {
+		mustStatus(t, s.putContentWithHeader(alice, file.UUID, "hello there", header), http.StatusBadRequest)
+	}
+	other := md5.Sum([]byte("other"))
+	for _, header := range []http.Header{
+		{"Digest": {"sha-256=not base64"}},
+		{"Digest": {"md5=" + base64.StdEncoding.EncodeToString(sha[:])}},
+		{"Digest": {"md5=" + md5Digest}, "Content-Md5": {base64.StdEncoding.EncodeToString(other[:])}},
+	} {
+		mustStatus(t, s.putContentWithHeader(alice, file.UUID, content, header), http.StatusBadRequest)
+	}
+	if n := len(s.listVersions(alice, file.UUID)); n != versions {
+		t.Errorf("%d versions after rejected content, want %d", n, versions)
+	}
+	if n := s.countStoredFiles(); n != stored {
+		t.Errorf("%d files stored after rejected content, want %d", n, stored)
+	}
+	if got := s.download(alice, file.UUID, nil).Body.String(); got != content {
+		t.Errorf("content after rejected content = %q, want %q", got, content)
+	}
+}
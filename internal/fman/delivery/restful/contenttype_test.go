@@ -0,0 +1,130 @@
+package restful
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// uploadForm uploads a file with a name and a content into a parent directory through the
+// upload form.
+func (s *testServer) uploadForm(user testUser, filename, parentUUID, content string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := w.WriteField("filename", filename)
+	if err == nil {
+		err = w.WriteField("parent_uuid", parentUUID)
+	}
+	var part io.Writer
+	if err == nil {
+		part, err = w.CreateFormFile("file", filename)
+	}
+	if err == nil {
+		_, err = part.Write([]byte(content))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/fman/file", &body)
+	req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+	return s.serve(req, user)
+}
+
+func TestContentTypeDetection(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+
+	for _, c := range []struct {
+		filename, content, want string
+	}{
+		{"page.html", "<html><body>hello</body></html>", "text/html; charset=utf-8"},
+		// The extension refines a generic type found in the content.
+		{"style.css", "body { color: red; }", "text/css; charset=utf-8"},
+		// But it cannot disguise the content.
+		{"photo.txt", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+		{"fake.png", "just some text", "text/plain; charset=utf-8"},
+		{"setup.dat", "MZ\x90\x00\x03\x00\x00\x00", "application/x-msdownload"},
+	} {
+		mustStatus(t, s.uploadForm(alice, c.filename, root, c.content), http.StatusOK)
+		file, err := s.repo.ReadFileRecordByName(c.filename, root)
+		if err != nil {
+			t.Fatalf("ReadFileRecordByName failed: %s", err)
+		}
+		if file.ContentType != c.want {
+			t.Errorf("content type of %s = %q, want %q", c.filename, file.ContentType, c.want)
+		}
+	}
+
+	// Downloads and listings return the detected type.
+	file, err := s.repo.ReadFileRecordByName("fake.png", root)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	rec := s.download(alice, file.UUID, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "text/plain; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/plain", ct)
+	}
+	if rec.Header().Get(echo.HeaderXContentTypeOptions) != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q, want nosniff", rec.Header().Get(echo.HeaderXContentTypeOptions))
+	}
+	rec = s.request(http.MethodGet, "/fman/dir/"+root, alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var res DirListingResponse
+	decodeJSON(t, rec, &res)
+	for _, listed := range res.Directory.ListOfFiles {
+		if listed.ContentType == "" {
+			t.Errorf("%s is listed without a content type", listed.Filename)
+		}
+	}
+}
+
+func TestContentTypePolicy(t *testing.T) {
+	s := newTestServer(t, usecase.Options{
+		ContentTypePolicy: models.ContentTypePolicy{Blocked: []string{"application/x-msdownload"}},
+		DirContentTypePolicies: map[string]models.ContentTypePolicy{
+			"/photos": {Allowed: []string{"image/*"}},
+		},
+	})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	photos := s.mkdir(alice, "photos", root)
+	trips := s.mkdir(alice, "trips", photos)
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+	// The global policy applies outside of the directories with a policy of their own.
+	mustStatus(t, s.uploadForm(alice, "setup.exe", root, "MZ\x90\x00"), http.StatusUnsupportedMediaType)
+	mustStatus(t, s.uploadForm(alice, "notes.txt", root, "notes"), http.StatusOK)
+
+	// The policy of a directory applies to its whole subtree instead.
+	mustStatus(t, s.uploadForm(alice, "notes.txt", trips, "notes"), http.StatusUnsupportedMediaType)
+	mustStatus(t, s.uploadForm(alice, "beach.png", trips, png), http.StatusOK)
+	beach, err := s.repo.ReadFileRecordByName("beach.png", trips)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	mustStatus(t, s.putContent(alice, beach.UUID, "not a photo anymore"), http.StatusUnsupportedMediaType)
+
+	// Rejected content is not kept.
+	if _, err := s.repo.ReadFileRecordByName("setup.exe", root); !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		t.Errorf("ReadFileRecordByName of a rejected file returned %v, want not found", err)
+	}
+	if n := s.countStoredFiles(); n != 2 {
+		t.Errorf("%d files stored, want 2", n)
+	}
+	if got := s.usage(alice).UsedBytes; got != int64(len("notes")+len(png)) {
+		t.Errorf("used bytes = %d, want %d", got, len("notes")+len(png))
+	}
+}
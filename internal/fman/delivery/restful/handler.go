@@ -1,10 +1,19 @@
 package restful
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
 	"github.com/nvthongswansea/xtreme/internal/fman"
+	"github.com/nvthongswansea/xtreme/internal/models"
 )
 
 // ResponseError represents http response error in JSON format
@@ -12,16 +21,85 @@ type Response struct {
 	Message string `json:"message"`
 }
 
+// DirListingResponse represents a page of a directory listing in JSON format.
+type DirListingResponse struct {
+	Directory  models.Directory `json:"directory"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// ProgressResponse represents a line of a streamed progress report in JSON format. The last
+// line holds either Message, or Status and Error.
+type ProgressResponse struct {
+	Progress *models.Progress `json:"progress,omitempty"`
+	Message  string           `json:"message,omitempty"`
+	Status   int              `json:"status,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// CopyRequest represents a request to copy a file/dir into a directory.
+type CopyRequest struct {
+	ParentUUID string `json:"parent_uuid" form:"parent_uuid"`
+}
+
+// MoveRequest represents a move/rename request. Empty fields are left unchanged.
+type MoveRequest struct {
+	Name       string `json:"name" form:"name"`
+	ParentUUID string `json:"parent_uuid" form:"parent_uuid"`
+}
+
+// RestoreRequest represents a request to restore an entry of the recycle bin. Empty fields
+// restore the entry with its original name into its original location.
+type RestoreRequest struct {
+	Name       string `json:"name" form:"name"`
+	ParentUUID string `json:"parent_uuid" form:"parent_uuid"`
+	OnConflict string `json:"on_conflict" form:"on_conflict"`
+}
+
+// QuotaRequest represents a request to set the quota of a user or a directory in bytes.
+type QuotaRequest struct {
+	LimitBytes int64 `json:"limit_bytes" form:"limit_bytes"`
+}
+
 // FmanHandler represents the http handler for file manage
 type FmanHandler struct {
 	FmanUsecase fman.FmanUsecase
 }
 
-// InitFmanHandler initialize file manager endpoints
-func InitFmanHandler(e *echo.Echo, uc fman.FmanUsecase) {
+// InitFmanHandler initialize file manager endpoints, which are only accessible to users
+// authenticated by authMiddleware, except for the endpoints of share links.
+func InitFmanHandler(e *echo.Echo, uc fman.FmanUsecase, authMiddleware echo.MiddlewareFunc) {
 	handler := &FmanHandler{FmanUsecase: uc}
-	g := e.Group("/fman")
+	g := e.Group("/fman", authMiddleware)
 	g.POST("/file", handler.UploadNewFile)
+	g.GET("/file/:uuid/content", handler.DownloadFile)
+	g.HEAD("/file/:uuid/content", handler.DownloadFile)
+	g.PUT("/file/:uuid/content", handler.UpdateFileContent)
+	g.GET("/file/:uuid/versions", handler.ListFileVersions)
+	g.GET("/file/:uuid/versions/:version/content", handler.DownloadFileVersion)
+	g.HEAD("/file/:uuid/versions/:version/content", handler.DownloadFileVersion)
+	g.POST("/file/:uuid/versions/:version/restore", handler.RestoreFileVersion)
+	g.PUT("/file/:uuid/version-policy", handler.SetFileVersionPolicy)
+	g.PATCH("/file/:uuid", handler.MoveFile)
+	g.DELETE("/file/:uuid", handler.RemoveFile)
+	g.GET("/dir/:uuid", handler.ListDirectory)
+	g.PATCH("/dir/:uuid", handler.MoveDirectory)
+	g.DELETE("/dir/:uuid", handler.RemoveDirectory)
+	g.POST("/dir/:uuid/copy", handler.CopyDirectory)
+	g.PUT("/dir/:uuid/quota", handler.SetDirectoryQuota)
+	g.GET("/trash", handler.ListRecycleBin)
+	g.DELETE("/trash", handler.EmptyRecycleBin)
+	g.POST("/trash/:uuid/restore", handler.RestoreFromRecycleBin)
+	g.DELETE("/trash/:uuid", handler.RemoveFromRecycleBin)
+	g.GET("/usage", handler.ReadUsage)
+	g.PUT("/users/:uuid/quota", handler.SetUserQuota)
+	g.GET("/storage/stats", handler.ReadStorageStats)
+	initTusHandler(g, handler)
+	initShareHandler(e, g, handler)
+	initACLHandler(g, handler)
+	initSearchHandler(g, handler)
+	initAttributeHandler(g, handler)
+	initThumbnailHandler(g, handler)
+	initArchiveHandler(g, handler)
 }
 
 func (h *FmanHandler) UploadNewFile(c echo.Context) error {
@@ -35,13 +113,436 @@ func (h *FmanHandler) UploadNewFile(c echo.Context) error {
 		return err
 	}
 	defer src.Close()
+	expected, err := uploadChecksums(c, file.Header)
+	if err != nil {
+		return err
+	}
 
+	user := authRestful.UserFromContext(c)
 	filename := c.FormValue("filename")
 	parentUUID := c.FormValue("parent_uuid")
+	if parentUUID == "" {
+		parentUUID = user.RootDirUUID
+	}
 	// Save file
-	err = h.FmanUsecase.UploadFile(filename, parentUUID, src)
+	err = h.FmanUsecase.UploadFile(user, filename, parentUUID, src, expected)
 	if err != nil {
-		return err
+		return errUtils.ToHTTPError(err)
 	}
 	return c.JSON(http.StatusOK, Response{Message: "Uploaded file successfully"})
 }
+
+// DownloadFile streams the content of a file. Single and multiple byte ranges, as well as
+// conditional requests (If-None-Match, If-Modified-Since, If-Range, ...) are supported.
+func (h *FmanHandler) DownloadFile(c echo.Context) error {
+	file, content, err := h.FmanUsecase.DownloadFile(authRestful.UserFromContext(c), c.Param("uuid"))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	defer content.Close()
+	res := c.Response()
+	setFileHeaders(res, file)
+	// ServeContent sets Content-Length and Last-Modified and handles Range/conditional
+	// headers. It only detects Content-Type itself for files uploaded before their type was
+	// detected.
+	http.ServeContent(res, c.Request(), file.Filename, file.UpdatedAt, content)
+	return nil
+}
+
+// UpdateFileContent replaces the content of a file with the request body, which is verified
+// against the Digest and Content-MD5 headers, and returns the new version.
+func (h *FmanHandler) UpdateFileContent(c echo.Context) error {
+	expected, err := checksumsFromHeader(c.Request().Header)
+	if err != nil {
+		return err
+	}
+	version, err := h.FmanUsecase.UpdateFileContent(authRestful.UserFromContext(c), c.Param("uuid"), c.Request().Body,
+		expected)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, version)
+}
+
+// ListFileVersions returns all versions of a file, newest first.
+func (h *FmanHandler) ListFileVersions(c echo.Context) error {
+	versions, err := h.FmanUsecase.ListFileVersions(authRestful.UserFromContext(c), c.Param("uuid"))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// DownloadFileVersion streams the content of a version of a file in the same way as DownloadFile.
+func (h *FmanHandler) DownloadFileVersion(c echo.Context) error {
+	version, err := versionParam(c)
+	if err != nil {
+		return err
+	}
+	file, content, err := h.FmanUsecase.DownloadFileVersion(authRestful.UserFromContext(c), c.Param("uuid"), version)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	defer content.Close()
+	res := c.Response()
+	setFileHeaders(res, file)
+	http.ServeContent(res, c.Request(), file.Filename, file.UpdatedAt, content)
+	return nil
+}
+
+// RestoreFileVersion makes the content of an old version of a file current again, and
+// returns the new version.
+func (h *FmanHandler) RestoreFileVersion(c echo.Context) error {
+	version, err := versionParam(c)
+	if err != nil {
+		return err
+	}
+	restored, err := h.FmanUsecase.RestoreFileVersion(authRestful.UserFromContext(c), c.Param("uuid"), version)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, restored)
+}
+
+// SetFileVersionPolicy sets the policy limiting the retained old versions of a file. The
+// body holds max_versions and max_age as a duration, e.g. {"max_versions": 5, "max_age": "720h"}.
+func (h *FmanHandler) SetFileVersionPolicy(c echo.Context) error {
+	var policy models.VersionPolicy
+	if err := json.NewDecoder(c.Request().Body).Decode(&policy); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid version policy")
+	}
+	if err := h.FmanUsecase.SetFileVersionPolicy(authRestful.UserFromContext(c), c.Param("uuid"), policy); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Set version policy successfully"})
+}
+
+// MoveFile moves and/or renames a file.
+func (h *FmanHandler) MoveFile(c echo.Context) error {
+	req := MoveRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.FmanUsecase.MoveFile(authRestful.UserFromContext(c), c.Param("uuid"), req.Name, req.ParentUUID); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Moved file successfully"})
+}
+
+// MoveDirectory moves and/or renames a directory together with its subtree.
+func (h *FmanHandler) MoveDirectory(c echo.Context) error {
+	req := MoveRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.FmanUsecase.MoveDirectory(authRestful.UserFromContext(c), c.Param("uuid"), req.Name, req.ParentUUID); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Moved directory successfully"})
+}
+
+// RemoveFile moves a file to the recycle bin, or removes it permanently if the query
+// param permanent is true.
+func (h *FmanHandler) RemoveFile(c echo.Context) error {
+	permanent, err := boolQueryParam(c, "permanent")
+	if err != nil {
+		return err
+	}
+	if permanent {
+		if err := h.FmanUsecase.RemoveFile(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: "Removed file successfully"})
+	}
+	if err := h.FmanUsecase.MoveFileToRecyleBin(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Moved file to recycle bin successfully"})
+}
+
+// RemoveDirectory moves a directory together with its subtree to the recycle bin, or removes
+// it permanently if the query param permanent is true. The progress of a permanent removal
+// is streamed if the client accepts application/x-ndjson.
+func (h *FmanHandler) RemoveDirectory(c echo.Context) error {
+	permanent, err := boolQueryParam(c, "permanent")
+	if err != nil {
+		return err
+	}
+	if permanent {
+		return runWithProgress(c, "Removed directory successfully", func(progress models.ProgressFunc) error {
+			return h.FmanUsecase.RemoveDirectory(authRestful.UserFromContext(c), c.Param("uuid"), progress)
+		})
+	}
+	if err := h.FmanUsecase.MoveDirectoryToRecycleBin(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Moved directory to recycle bin successfully"})
+}
+
+// CopyDirectory copies a directory together with its subtree into another directory.
+// The progress is streamed if the client accepts application/x-ndjson.
+func (h *FmanHandler) CopyDirectory(c echo.Context) error {
+	req := CopyRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	return runWithProgress(c, "Copied directory successfully", func(progress models.ProgressFunc) error {
+		return h.FmanUsecase.CopyDirectory(authRestful.UserFromContext(c), c.Param("uuid"), req.ParentUUID, progress)
+	})
+}
+
+// ListRecycleBin returns all entries of the recycle bin, most recently deleted first.
+func (h *FmanHandler) ListRecycleBin(c echo.Context) error {
+	entries, err := h.FmanUsecase.ListRecycleBin(authRestful.UserFromContext(c))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if entries == nil {
+		entries = []models.TrashEntry{}
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// RestoreFromRecycleBin restores an entry of the recycle bin. on_conflict is fail (default)
+// or rename, which restores the entry under a free name if its name is taken.
+func (h *FmanHandler) RestoreFromRecycleBin(c echo.Context) error {
+	req := RestoreRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	opts := models.RestoreOptions{
+		Name:       req.Name,
+		ParentUUID: req.ParentUUID,
+		OnConflict: req.OnConflict,
+	}
+	if err := h.FmanUsecase.RestoreFromRecycleBin(authRestful.UserFromContext(c), c.Param("uuid"), opts); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Restored successfully"})
+}
+
+// RemoveFromRecycleBin removes an entry of the recycle bin permanently.
+func (h *FmanHandler) RemoveFromRecycleBin(c echo.Context) error {
+	if err := h.FmanUsecase.RemoveFromRecycleBin(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Removed from recycle bin successfully"})
+}
+
+// EmptyRecycleBin removes all entries of the recycle bin permanently.
+func (h *FmanHandler) EmptyRecycleBin(c echo.Context) error {
+	if err := h.FmanUsecase.EmptyRecycleBin(authRestful.UserFromContext(c)); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Emptied recycle bin successfully"})
+}
+
+// ReadUsage returns the quota and the bytes used of the user, or of the user given by the
+// query param user_uuid, together with the quotas of the user's directories.
+func (h *FmanHandler) ReadUsage(c echo.Context) error {
+	usage, err := h.FmanUsecase.ReadUsage(authRestful.UserFromContext(c), c.QueryParam("user_uuid"))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+// SetUserQuota sets the quota of a user, e.g. {"limit_bytes": 1073741824}. Zero falls back
+// to the default quota.
+func (h *FmanHandler) SetUserQuota(c echo.Context) error {
+	req := QuotaRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.FmanUsecase.SetUserQuota(authRestful.UserFromContext(c), c.Param("uuid"), req.LimitBytes); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Set user quota successfully"})
+}
+
+// ReadStorageStats returns the number of stored contents, their total size and the bytes they
+// take up in the storage.
+func (h *FmanHandler) ReadStorageStats(c echo.Context) error {
+	stats, err := h.FmanUsecase.ReadStorageStats(authRestful.UserFromContext(c))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// SetDirectoryQuota sets the quota of a directory in the same way as SetUserQuota. Zero
+// removes the quota.
+func (h *FmanHandler) SetDirectoryQuota(c echo.Context) error {
+	req := QuotaRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := h.FmanUsecase.SetDirectoryQuota(authRestful.UserFromContext(c), c.Param("uuid"), req.LimitBytes); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Set directory quota successfully"})
+}
+
+// ListDirectory returns a directory with a page of its children.
+// Query params: sort (name, size, created_at, updated_at), order (asc, desc),
+// type (file, dir), prefix, limit and cursor.
+func (h *FmanHandler) ListDirectory(c echo.Context) error {
+	opts, err := dirListOptions(c)
+	if err != nil {
+		return err
+	}
+	dir, nextCursor, err := h.FmanUsecase.ListDirectory(authRestful.UserFromContext(c), c.Param("uuid"), opts)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, DirListingResponse{Directory: dir, NextCursor: nextCursor})
+}
+
+// ndjsonMIME is the media type of streamed progress reports.
+const ndjsonMIME = "application/x-ndjson"
+
+// progressInterval is the minimum time between two streamed progress lines.
+const progressInterval = 200 * time.Millisecond
+
+// runWithProgress runs an operation on a directory subtree. If the client accepts
+// application/x-ndjson, its progress is streamed as one ProgressResponse per line, ending
+// with the result. Otherwise, or if the operation fails before reporting any progress,
+// only the result is returned.
+func runWithProgress(c echo.Context, successMessage string, op func(progress models.ProgressFunc) error) error {
+	if !strings.Contains(c.Request().Header.Get(echo.HeaderAccept), ndjsonMIME) {
+		if err := op(nil); err != nil {
+			return errUtils.ToHTTPError(err)
+		}
+		return c.JSON(http.StatusOK, Response{Message: successMessage})
+	}
+	res := c.Response()
+	enc := json.NewEncoder(res)
+	var lastReport time.Time
+	err := op(func(p models.Progress) {
+		// Always report the first and the last progress.
+		done := p.FilesDone == p.FilesTotal && p.BlobsDone == p.BlobsTotal
+		if !lastReport.IsZero() && !done && time.Since(lastReport) < progressInterval {
+			return
+		}
+		if !res.Committed {
+			res.Header().Set(echo.HeaderContentType, ndjsonMIME)
+			res.WriteHeader(http.StatusOK)
+		}
+		lastReport = time.Now()
+		enc.Encode(ProgressResponse{Progress: &p})
+		res.Flush()
+	})
+	if err != nil && !res.Committed {
+		// The operation failed before reporting any progress, e.g. on validation.
+		return errUtils.ToHTTPError(err)
+	}
+	if err != nil {
+		// The status line is already sent, so the error goes into the last line.
+		var httpErr *echo.HTTPError
+		if errors.As(errUtils.ToHTTPError(err), &httpErr) {
+			return enc.Encode(ProgressResponse{Status: httpErr.Code, Error: fmt.Sprint(httpErr.Message)})
+		}
+		c.Logger().Error(err)
+		return enc.Encode(ProgressResponse{Status: http.StatusInternalServerError, Error: models.InternalServerErrorMessage})
+	}
+	return enc.Encode(ProgressResponse{Message: successMessage})
+}
+
+// dirListOptions parses the query params of a directory listing.
+func dirListOptions(c echo.Context) (models.DirListOptions, error) {
+	opts := models.DirListOptions{
+		SortBy:     c.QueryParam("sort"),
+		Type:       c.QueryParam("type"),
+		NamePrefix: c.QueryParam("prefix"),
+		Cursor:     c.QueryParam("cursor"),
+	}
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return models.DirListOptions{}, echo.NewHTTPError(http.StatusBadRequest, "order must be asc or desc")
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		var err error
+		opts.Limit, err = strconv.Atoi(limit)
+		if err != nil || opts.Limit <= 0 {
+			return models.DirListOptions{}, echo.NewHTTPError(http.StatusBadRequest, "limit must be a positive integer")
+		}
+	}
+	return opts, nil
+}
+
+// boolQueryParam parses an optional boolean query param, which defaults to false.
+func boolQueryParam(c echo.Context, name string) (bool, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(param)
+	if err != nil {
+		return false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be true or false", name))
+	}
+	return value, nil
+}
+
+// versionParam parses the version path param, which must be a positive integer.
+func versionParam(c echo.Context) (int, error) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "version must be a positive integer")
+	}
+	return version, nil
+}
+
+// fileETag returns a strong ETag of a file's content, which only changes with the content,
+// so renaming or moving a file keeps it. Files stored before their content was hashed fall
+// back to the UUID and the version of the content.
+func fileETag(file models.File) string {
+	if file.ContentHash != "" {
+		return fmt.Sprintf(`"%s"`, file.ContentHash)
+	}
+	return fmt.Sprintf(`"%s-%d"`, file.UUID, file.Version)
+}
+
+// setFileHeaders sets the headers of a response streaming the content of a file as an
+// attachment. The browser must not guess another type than the detected one, and the Digest
+// header lets clients verify the content.
+func setFileHeaders(res *echo.Response, file models.File) {
+	res.Header().Set(echo.HeaderContentDisposition, contentDisposition("attachment", file.Filename))
+	res.Header().Set("ETag", fileETag(file))
+	if file.ContentType != "" {
+		res.Header().Set(echo.HeaderContentType, file.ContentType)
+	}
+	res.Header().Set(echo.HeaderXContentTypeOptions, "nosniff")
+	if digest := digestHeader(file); digest != "" {
+		res.Header().Set(headerDigest, digest)
+	}
+}
+
+// contentDisposition returns a Content-Disposition header value with an ASCII filename
+// for old clients and a UTF-8 filename encoded as in RFC 5987.
+func contentDisposition(dispositionType, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, dispositionType, fallback, rfc5987Escape(filename))
+}
+
+// rfc5987Escape percent-encodes all bytes of s except attr-chars of RFC 5987.
+func rfc5987Escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || ('0' <= b && b <= '9') ||
+			strings.IndexByte("!#$&+-.^_`|~", b) >= 0 {
+			sb.WriteByte(b)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", b)
+	}
+	return sb.String()
+}
@@ -0,0 +1,811 @@
+package restful
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	authUC "github.com/nvthongswansea/xtreme/internal/auth/usecase"
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
+)
+
+// testServer serves the endpoints of the file manager and the authentication over a memory
+// repository, by default with a local storage in a temporary directory.
+type testServer struct {
+	t    *testing.T
+	e    *echo.Echo
+	repo *repo.FManMemoryRepo
+	uc   *usecase.FManLocalUsecase
+	auth *authUC.AuthJWTUsecase
+	// storageDir is the directory of the local storage, empty for other storages.
+	storageDir string
+}
+
+// testUser is a registered user together with an access token.
+type testUser struct {
+	models.User
+	token string
+}
+
+// newTestServer returns a testServer whose file manager has options opts.
+func newTestServer(t *testing.T, opts usecase.Options) *testServer {
+	t.Helper()
+	storageDir := t.TempDir()
+	s := newTestServerWithStorage(t, opts, fileUtils.CreateNewLocalFileOperator(storageDir))
+	s.storageDir = storageDir
+	return s
+}
+
+// newTestServerWithStorage returns a testServer whose file manager has options opts and
+// stores the content in fileOps.
+func newTestServerWithStorage(t *testing.T, opts usecase.Options, fileOps fileUtils.FileSaveReadRemover) *testServer {
+	t.Helper()
+	r := repo.NewFManMemoryRepo()
+	uuidGen := &uuidUtils.GoogleUUIDGenerator{}
+	uc := usecase.NewFManLocalUsecase(r, r, r, r, r, r, r, r, r, r, r, r, uuidGen, fileOps, opts)
+	auc := authUC.NewAuthJWTUsecase(r, r, uuidGen, authUC.Options{Secret: []byte("test-secret"), AllowRegistration: true})
+	e := echo.New()
+	InitFmanHandler(e, uc, authRestful.InitAuthHandler(e, auc))
+	return &testServer{t: t, e: e, repo: r, uc: uc, auth: auc}
+}
+
+// register registers a user with a name and logs it in.
+func (s *testServer) register(username string) testUser {
+	s.t.Helper()
+	user, err := s.auth.Register(username, username+"-password")
+	if err != nil {
+		s.t.Fatalf("Register failed: %s", err)
+	}
+	return s.login(user)
+}
+
+// registerAdmin creates an admin with a name and logs it in.
+func (s *testServer) registerAdmin(username string) testUser {
+	s.t.Helper()
+	user, err := s.auth.EnsureAdmin(username, username+"-password")
+	if err != nil {
+		s.t.Fatalf("EnsureAdmin failed: %s", err)
+	}
+	return s.login(user)
+}
+
+// login logs in a user registered by register or registerAdmin.
+func (s *testServer) login(user models.User) testUser {
+	s.t.Helper()
+	tokens, err := s.auth.Login(user.Username, user.Username+"-password")
+	if err != nil {
+		s.t.Fatalf("Login failed: %s", err)
+	}
+	return testUser{User: user, token: tokens.AccessToken}
+}
+
+// mkdir creates a directory with a name in a parent directory and returns its UUID.
+func (s *testServer) mkdir(user testUser, dirname, parentUUID string) string {
+	s.t.Helper()
+	if err := s.uc.CreateNewDirectory(user.User, dirname, parentUUID); err != nil {
+		s.t.Fatalf("CreateNewDirectory failed: %s", err)
+	}
+	dir, err := s.repo.ReadDirRecordByName(dirname, parentUUID)
+	if err != nil {
+		s.t.Fatalf("ReadDirRecordByName failed: %s", err)
+	}
+	return dir.UUID
+}
+
+// upload uploads a file with a name and a content into a parent directory and returns the
+// file.
+func (s *testServer) upload(user testUser, filename, parentUUID, content string) models.File {
+	s.t.Helper()
+	if err := s.uc.UploadFile(user.User, filename, parentUUID, strings.NewReader(content), models.Checksums{}); err != nil {
+		s.t.Fatalf("UploadFile failed: %s", err)
+	}
+	file, err := s.repo.ReadFileRecordByName(filename, parentUUID)
+	if err != nil {
+		s.t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	return file
+}
+
+// serve serves a request, which is authenticated as user unless the user has no token.
+func (s *testServer) serve(req *http.Request, user testUser) *httptest.ResponseRecorder {
+	if user.token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+user.token)
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// request serves a request with a JSON body, which is empty if body is nil.
+func (s *testServer) request(method, target string, user testUser, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		r = strings.NewReader(string(b))
+	}
+	req := httptest.NewRequest(method, target, r)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return s.serve(req, user)
+}
+
+// mustStatus fails a test if a response does not have a status code.
+func mustStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+// decodeJSON decodes the JSON body of a response into v.
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("invalid JSON body %q: %s", rec.Body.String(), err)
+	}
+}
+
+// listNames lists a directory with query params and returns the names of the listed
+// directories and files in order, together with the cursor of the next page.
+func (s *testServer) listNames(user testUser, dirUUID string, query url.Values) ([]string, string) {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/dir/"+dirUUID+"?"+query.Encode(), user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var res DirListingResponse
+	decodeJSON(s.t, rec, &res)
+	var names []string
+	for _, dir := range res.Directory.ListOfDirs {
+		names = append(names, dir.Dirname+"/")
+	}
+	for _, file := range res.Directory.ListOfFiles {
+		names = append(names, file.Filename)
+	}
+	return names, res.NextCursor
+}
+
+func TestListDirectory(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	s.mkdir(alice, "photos", root)
+	s.upload(alice, "a10.txt", root, "1234567890")
+	s.upload(alice, "a2.txt", root, "12")
+	s.upload(alice, "b.txt", root, "12345")
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{"natural order", url.Values{}, "photos/ a2.txt a10.txt b.txt"},
+		{"by size desc", url.Values{"sort": {"size"}, "order": {"desc"}}, "photos/ a10.txt b.txt a2.txt"},
+		{"files only", url.Values{"type": {"file"}}, "a2.txt a10.txt b.txt"},
+		{"prefix", url.Values{"prefix": {"a"}}, "a2.txt a10.txt"},
+	}
+	for _, tt := range tests {
+		names, _ := s.listNames(alice, root, tt.query)
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("%s: listed %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListDirectoryPages(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	for _, name := range []string{"c", "a", "d", "b", "e"} {
+		s.upload(alice, name, alice.RootDirUUID, name)
+	}
+	var all []string
+	cursor := ""
+	for page := 0; page < 5; page++ {
+		names, next := s.listNames(alice, alice.RootDirUUID, url.Values{"limit": {"2"}, "cursor": {cursor}})
+		all = append(all, names...)
+		if cursor = next; cursor == "" {
+			break
+		}
+	}
+	if got := strings.Join(all, " "); got != "a b c d e" {
+		t.Errorf("listed %q over all pages, want %q", got, "a b c d e")
+	}
+}
+
+func TestListDirectoryRejectsInvalidQuery(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	bob := s.register("bob")
+	for _, query := range []string{"order=up", "limit=0", "limit=x", "sort=color", "type=link"} {
+		rec := s.request(http.MethodGet, "/fman/dir/"+alice.RootDirUUID+"?"+query, alice, nil)
+		mustStatus(t, rec, http.StatusBadRequest)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+alice.RootDirUUID, bob, nil), http.StatusForbidden)
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+alice.RootDirUUID, testUser{}, nil), http.StatusUnauthorized)
+}
+
+// download serves a GET request of the content of a file with headers.
+func (s *testServer) download(user testUser, fileUUID string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/fman/file/"+fileUUID+"/content", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return s.serve(req, user)
+}
+
+func TestDownloadFile(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	file := s.upload(alice, "Grüße.txt", alice.RootDirUUID, "0123456789")
+
+	rec := s.download(alice, file.UUID, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "0123456789" || rec.Header().Get(echo.HeaderContentLength) != "10" {
+		t.Errorf("got body %q with length %s", rec.Body.String(), rec.Header().Get(echo.HeaderContentLength))
+	}
+	wantDisposition := `attachment; filename="Gr__e.txt"; filename*=UTF-8''Gr%C3%BC%C3%9Fe.txt`
+	if got := rec.Header().Get(echo.HeaderContentDisposition); got != wantDisposition {
+		t.Errorf("Content-Disposition = %q, want %q", got, wantDisposition)
+	}
+	if rec.Header().Get("ETag") == "" || rec.Header().Get(echo.HeaderLastModified) == "" {
+		t.Errorf("missing validators in %v", rec.Header())
+	}
+
+	rec = s.download(alice, file.UUID, http.Header{"Range": {"bytes=2-4"}})
+	mustStatus(t, rec, http.StatusPartialContent)
+	if rec.Body.String() != "234" || rec.Header().Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("got body %q with range %q", rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+	rec = s.download(alice, file.UUID, http.Header{"Range": {"bytes=0-1,8-"}})
+	mustStatus(t, rec, http.StatusPartialContent)
+	if !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), "multipart/byteranges") {
+		t.Errorf("Content-Type = %q, want multipart/byteranges", rec.Header().Get(echo.HeaderContentType))
+	}
+	mustStatus(t, s.download(alice, file.UUID, http.Header{"Range": {"bytes=20-"}}), http.StatusRequestedRangeNotSatisfiable)
+	mustStatus(t, s.download(s.register("bob"), file.UUID, nil), http.StatusForbidden)
+}
+
+func TestDownloadFileConditional(t *testing.T) {
+	s := newTestServer(t, usecase.Options{Versioning: true})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "0123456789")
+	etag := s.download(alice, file.UUID, nil).Header().Get("ETag")
+
+	mustStatus(t, s.download(alice, file.UUID, http.Header{"If-None-Match": {etag}}), http.StatusNotModified)
+	rec := s.download(alice, file.UUID, http.Header{"Range": {"bytes=5-"}, "If-Range": {etag}})
+	mustStatus(t, rec, http.StatusPartialContent)
+
+	// Renaming or moving a file keeps its content and so its ETag.
+	dirUUID := s.mkdir(alice, "docs", alice.RootDirUUID)
+	if err := s.uc.MoveFile(alice.User, file.UUID, "b.txt", dirUUID); err != nil {
+		t.Fatalf("MoveFile failed: %s", err)
+	}
+	if got := s.download(alice, file.UUID, nil).Header().Get("ETag"); got != etag {
+		t.Errorf("ETag after a move = %s, want %s", got, etag)
+	}
+	mustStatus(t, s.download(alice, file.UUID, http.Header{"If-None-Match": {etag}}), http.StatusNotModified)
+
+	// A new content gets a new ETag, so a resumed download starts over.
+	if _, err := s.uc.UpdateFileContent(alice.User, file.UUID, strings.NewReader("abcdefghij"), models.Checksums{}); err != nil {
+		t.Fatalf("UpdateFileContent failed: %s", err)
+	}
+	rec = s.download(alice, file.UUID, http.Header{"Range": {"bytes=5-"}, "If-Range": {etag}})
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "abcdefghij" || rec.Header().Get("ETag") == etag {
+		t.Errorf("got body %q with ETag %s after an update", rec.Body.String(), rec.Header().Get("ETag"))
+	}
+}
+
+// filePath returns the human-readable path of a file.
+func (s *testServer) filePath(fileUUID string) string {
+	s.t.Helper()
+	file, err := s.repo.ReadFileRecord(fileUUID)
+	if err != nil {
+		s.t.Fatalf("ReadFileRecord failed: %s", err)
+	}
+	return file.Path
+}
+
+// dirPath returns the human-readable path of a directory.
+func (s *testServer) dirPath(dirUUID string) string {
+	s.t.Helper()
+	dir, err := s.repo.ReadDirRecord(dirUUID)
+	if err != nil {
+		s.t.Fatalf("ReadDirRecord failed: %s", err)
+	}
+	return dir.Path
+}
+
+func TestMoveFile(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	docs := s.mkdir(alice, "docs", root)
+	file := s.upload(alice, "a.txt", root, "a")
+	s.upload(alice, "taken.txt", docs, "t")
+
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, alice, MoveRequest{Name: "b.txt"}), http.StatusOK)
+	if got := s.filePath(file.UUID); got != "/b.txt" {
+		t.Errorf("path after a rename = %q, want /b.txt", got)
+	}
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, alice, MoveRequest{ParentUUID: docs}), http.StatusOK)
+	if got := s.filePath(file.UUID); got != "/docs/b.txt" {
+		t.Errorf("path after a move = %q, want /docs/b.txt", got)
+	}
+
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, alice, MoveRequest{Name: "taken.txt"}), http.StatusConflict)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, alice, MoveRequest{Name: "a/b"}), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, alice, MoveRequest{ParentUUID: "missing"}), http.StatusNotFound)
+	bob := s.register("bob")
+	mustStatus(t, s.request(http.MethodPatch, "/fman/file/"+file.UUID, bob, MoveRequest{ParentUUID: bob.RootDirUUID}), http.StatusForbidden)
+}
+
+func TestMoveDirectory(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	a := s.mkdir(alice, "a", root)
+	b := s.mkdir(alice, "b", a)
+	file := s.upload(alice, "f.txt", b, "f")
+	other := s.mkdir(alice, "other", root)
+
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+a, alice, MoveRequest{Name: "x", ParentUUID: other}), http.StatusOK)
+	if got := s.dirPath(b); got != "/other/x/b" {
+		t.Errorf("path of a subdirectory = %q, want /other/x/b", got)
+	}
+	if got := s.filePath(file.UUID); got != "/other/x/b/f.txt" {
+		t.Errorf("path of a descendant file = %q, want /other/x/b/f.txt", got)
+	}
+
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+a, alice, MoveRequest{ParentUUID: b}), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+a, alice, MoveRequest{ParentUUID: a}), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+other, alice, MoveRequest{Name: "other"}), http.StatusOK)
+	s.mkdir(alice, "taken", root)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+other, alice, MoveRequest{Name: "taken"}), http.StatusConflict)
+	mustStatus(t, s.request(http.MethodPatch, "/fman/dir/"+root, alice, MoveRequest{Name: "root"}), http.StatusBadRequest)
+}
+
+// listTrash returns the entries of the recycle bin of a user.
+func (s *testServer) listTrash(user testUser) []models.TrashEntry {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/trash", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var entries []models.TrashEntry
+	decodeJSON(s.t, rec, &entries)
+	return entries
+}
+
+// countStoredFiles returns the number of regular files in the storage.
+func (s *testServer) countStoredFiles() int {
+	s.t.Helper()
+	count := 0
+	err := filepath.Walk(s.storageDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return count
+}
+
+func TestRecycleBin(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	docs := s.mkdir(alice, "docs", root)
+	file := s.upload(alice, "a.txt", docs, "a")
+
+	mustStatus(t, s.request(http.MethodDelete, "/fman/dir/"+docs, alice, nil), http.StatusOK)
+	entries := s.listTrash(alice)
+	if len(entries) != 1 || entries[0].ItemUUID != docs || entries[0].OriginalPath != "/docs" {
+		t.Fatalf("recycle bin = %+v, want only /docs", entries)
+	}
+	if names, _ := s.listNames(alice, root, url.Values{}); len(names) != 0 {
+		t.Errorf("listed %v after moving to the recycle bin", names)
+	}
+	mustStatus(t, s.download(alice, file.UUID, nil), http.StatusNotFound)
+	mustStatus(t, s.request(http.MethodGet, "/fman/trash", s.register("bob"), nil), http.StatusOK)
+
+	// The name is taken in the meantime, so the entry is restored under a free name.
+	s.mkdir(alice, "docs", root)
+	restore := "/fman/trash/" + entries[0].UUID + "/restore"
+	mustStatus(t, s.request(http.MethodPost, restore, alice, RestoreRequest{}), http.StatusConflict)
+	mustStatus(t, s.request(http.MethodPost, restore, alice, RestoreRequest{OnConflict: models.RestoreConflictRename}), http.StatusOK)
+	if names, _ := s.listNames(alice, root, url.Values{}); strings.Join(names, " ") != "docs/ docs (1)/" {
+		t.Errorf("listed %v after a restore, want docs/ and docs (1)/", names)
+	}
+	if got := s.filePath(file.UUID); got != "/docs (1)/a.txt" {
+		t.Errorf("path of a restored file = %q, want /docs (1)/a.txt", got)
+	}
+	mustStatus(t, s.download(alice, file.UUID, nil), http.StatusOK)
+	if entries := s.listTrash(alice); len(entries) != 0 {
+		t.Errorf("recycle bin = %+v after a restore, want empty", entries)
+	}
+}
+
+func TestPurgeRecycleBin(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	old := s.upload(alice, "old.txt", alice.RootDirUUID, "old")
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+old.UUID, alice, nil), http.StatusOK)
+	cutoff := time.Now()
+	recent := s.upload(alice, "recent.txt", alice.RootDirUUID, "recent")
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+recent.UUID, alice, nil), http.StatusOK)
+	if n := s.countStoredFiles(); n != 2 {
+		t.Fatalf("%d files stored, want 2", n)
+	}
+
+	removed, err := s.uc.PurgeRecycleBin(cutoff)
+	if err != nil {
+		t.Fatalf("PurgeRecycleBin failed: %s", err)
+	}
+	entries := s.listTrash(alice)
+	if removed != 1 || len(entries) != 1 || entries[0].ItemUUID != recent.UUID {
+		t.Errorf("purged %d entries leaving %+v, want only recent.txt left", removed, entries)
+	}
+	if n := s.countStoredFiles(); n != 1 {
+		t.Errorf("%d files stored after a purge, want 1", n)
+	}
+
+	mustStatus(t, s.request(http.MethodDelete, "/fman/trash", alice, nil), http.StatusOK)
+	if entries := s.listTrash(alice); len(entries) != 0 || s.countStoredFiles() != 0 {
+		t.Errorf("recycle bin = %+v with %d files stored after emptying it", entries, s.countStoredFiles())
+	}
+}
+
+// streamProgress serves a request whose progress is streamed and returns the decoded lines.
+func (s *testServer) streamProgress(method, target string, user testUser, body interface{}) []ProgressResponse {
+	s.t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(string(b)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAccept, ndjsonMIME)
+	rec := s.serve(req, user)
+	mustStatus(s.t, rec, http.StatusOK)
+	var lines []ProgressResponse
+	dec := json.NewDecoder(rec.Body)
+	for dec.More() {
+		var line ProgressResponse
+		if err := dec.Decode(&line); err != nil {
+			s.t.Fatalf("invalid progress line: %s", err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestCopyDirectory(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	src := s.mkdir(alice, "src", root)
+	sub := s.mkdir(alice, "sub", src)
+	s.upload(alice, "a.txt", src, "aa")
+	s.upload(alice, "b.txt", sub, "bbb")
+	dst := s.mkdir(alice, "dst", root)
+
+	lines := s.streamProgress(http.MethodPost, "/fman/dir/"+src+"/copy", alice, CopyRequest{ParentUUID: dst})
+	last := lines[len(lines)-1]
+	if last.Message == "" || last.Error != "" {
+		t.Fatalf("last progress line = %+v, want a success message", last)
+	}
+	final := lines[len(lines)-2].Progress
+	if final == nil || *final != (models.Progress{FilesDone: 2, FilesTotal: 2, BytesDone: 5, BytesTotal: 5}) {
+		t.Errorf("final progress = %+v, want 2 files with 5 bytes done", final)
+	}
+	srcCopy, err := s.repo.ReadDirRecordByName("src", dst)
+	if err != nil {
+		t.Fatalf("ReadDirRecordByName failed: %s", err)
+	}
+	subCopy, err := s.repo.ReadDirRecordByName("sub", srcCopy.UUID)
+	if err != nil {
+		t.Fatalf("ReadDirRecordByName failed: %s", err)
+	}
+	if names, _ := s.listNames(alice, subCopy.UUID, url.Values{}); strings.Join(names, " ") != "b.txt" {
+		t.Errorf("listed %v in the copy of sub, want b.txt", names)
+	}
+
+	mustStatus(t, s.request(http.MethodPost, "/fman/dir/"+src+"/copy", alice, CopyRequest{ParentUUID: sub}), http.StatusBadRequest)
+	mustStatus(t, s.request(http.MethodPost, "/fman/dir/"+src+"/copy", alice, CopyRequest{ParentUUID: dst}), http.StatusConflict)
+}
+
+func TestRemoveDirectoryProgress(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	dir := s.mkdir(alice, "dir", alice.RootDirUUID)
+	sub := s.mkdir(alice, "sub", dir)
+	// Three files sharing two contents.
+	s.upload(alice, "a.txt", dir, "same")
+	s.upload(alice, "b.txt", sub, "same")
+	s.upload(alice, "c.txt", sub, "other")
+
+	var reports []models.Progress
+	err := s.uc.RemoveDirectory(alice.User, dir, func(p models.Progress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("RemoveDirectory failed: %s", err)
+	}
+	want := []models.Progress{
+		{FilesDone: 3, FilesTotal: 3, BytesDone: 13, BytesTotal: 13, BlobsTotal: 2},
+		{FilesDone: 3, FilesTotal: 3, BytesDone: 13, BytesTotal: 13, BlobsDone: 1, BlobsTotal: 2},
+		{FilesDone: 3, FilesTotal: 3, BytesDone: 13, BytesTotal: 13, BlobsDone: 2, BlobsTotal: 2},
+	}
+	if fmt.Sprint(reports) != fmt.Sprint(want) {
+		t.Errorf("progress = %+v, want %+v", reports, want)
+	}
+	if n := s.countStoredFiles(); n != 0 {
+		t.Errorf("%d files stored after a removal, want 0", n)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/dir/"+sub, alice, nil), http.StatusNotFound)
+}
+
+func TestContentDeduplication(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	root := alice.RootDirUUID
+	docs := s.mkdir(alice, "docs", root)
+	a := s.upload(alice, "a.txt", root, "same content")
+	b := s.upload(alice, "b.txt", docs, "same content")
+	if err := s.uc.CopyFile(alice.User, a.UUID, docs); err != nil {
+		t.Fatalf("CopyFile failed: %s", err)
+	}
+	if a.ContentHash != b.ContentHash || a.StorageKey != b.StorageKey {
+		t.Errorf("files with the same content have hashes %s, %s and keys %s, %s",
+			a.ContentHash, b.ContentHash, a.StorageKey, b.StorageKey)
+	}
+	if n := s.countStoredFiles(); n != 1 {
+		t.Fatalf("%d files stored for the same content, want 1", n)
+	}
+
+	// The content is only removed together with the last file using it.
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+a.UUID+"?permanent=true", alice, nil), http.StatusOK)
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+b.UUID+"?permanent=true", alice, nil), http.StatusOK)
+	if n := s.countStoredFiles(); n != 1 {
+		t.Fatalf("%d files stored while a copy is left, want 1", n)
+	}
+	copied, err := s.repo.ReadFileRecordByName("a.txt", docs)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	if rec := s.download(alice, copied.UUID, nil); rec.Body.String() != "same content" {
+		t.Errorf("content of the copy = %q, want %q", rec.Body.String(), "same content")
+	}
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+copied.UUID+"?permanent=true", alice, nil), http.StatusOK)
+	if n := s.countStoredFiles(); n != 0 {
+		t.Errorf("%d files stored after removing all files, want 0", n)
+	}
+}
+
+// listVersions returns the versions of a file, newest first.
+func (s *testServer) listVersions(user testUser, fileUUID string) []models.FileVersion {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/file/"+fileUUID+"/versions", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var versions []models.FileVersion
+	decodeJSON(s.t, rec, &versions)
+	return versions
+}
+
+// putContent replaces the content of a file.
+func (s *testServer) putContent(user testUser, fileUUID, content string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/fman/file/"+fileUUID+"/content", strings.NewReader(content))
+	return s.serve(req, user)
+}
+
+func TestFileVersions(t *testing.T) {
+	s := newTestServer(t, usecase.Options{Versioning: true})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "one")
+	// An upload with the same name adds a version to the same file.
+	if again := s.upload(alice, "a.txt", alice.RootDirUUID, "two!"); again.UUID != file.UUID || again.Version != 2 {
+		t.Fatalf("upload with an existing name returned file %s version %d, want %s version 2", again.UUID, again.Version, file.UUID)
+	}
+	rec := s.putContent(alice, file.UUID, "three")
+	mustStatus(t, rec, http.StatusOK)
+
+	versions := s.listVersions(alice, file.UUID)
+	if len(versions) != 3 || versions[0].Version != 3 || !versions[0].IsCurrent || versions[2].FileSize != 3 ||
+		versions[2].UploadedBy != alice.UUID {
+		t.Fatalf("versions = %+v, want 3, 2, 1 with 3 current", versions)
+	}
+	rec = s.request(http.MethodGet, "/fman/file/"+file.UUID+"/versions/1/content", alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "one" {
+		t.Errorf("content of version 1 = %q, want one", rec.Body.String())
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/file/"+file.UUID+"/versions/9/content", alice, nil), http.StatusNotFound)
+	mustStatus(t, s.request(http.MethodGet, "/fman/file/"+file.UUID+"/versions/x/content", alice, nil), http.StatusBadRequest)
+
+	// A restore adds the old content as a new version.
+	mustStatus(t, s.request(http.MethodPost, "/fman/file/"+file.UUID+"/versions/1/restore", alice, nil), http.StatusOK)
+	if rec := s.download(alice, file.UUID, nil); rec.Body.String() != "one" {
+		t.Errorf("content after a restore = %q, want one", rec.Body.String())
+	}
+	if versions := s.listVersions(alice, file.UUID); len(versions) != 4 || versions[0].Version != 4 {
+		t.Errorf("versions after a restore = %+v, want 4 versions", versions)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/file/"+file.UUID+"/versions", s.register("bob"), nil), http.StatusForbidden)
+}
+
+func TestFileVersionPolicy(t *testing.T) {
+	s := newTestServer(t, usecase.Options{Versioning: true, VersionPolicy: models.VersionPolicy{MaxVersions: 3}})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "v1")
+	for _, content := range []string{"v2", "v3", "v4"} {
+		mustStatus(t, s.putContent(alice, file.UUID, content), http.StatusOK)
+	}
+	if versions := s.listVersions(alice, file.UUID); len(versions) != 3 || versions[2].Version != 2 {
+		t.Errorf("versions = %+v, want 4, 3 and 2 under the global policy", versions)
+	}
+
+	// A policy of the file takes precedence over the global one.
+	rec := s.request(http.MethodPut, "/fman/file/"+file.UUID+"/version-policy", alice, map[string]interface{}{"max_versions": 2})
+	mustStatus(t, rec, http.StatusOK)
+	mustStatus(t, s.putContent(alice, file.UUID, "v5"), http.StatusOK)
+	if versions := s.listVersions(alice, file.UUID); len(versions) != 2 || versions[1].Version != 4 {
+		t.Errorf("versions = %+v, want 5 and 4 under the policy of the file", versions)
+	}
+	rec = s.request(http.MethodPut, "/fman/file/"+file.UUID+"/version-policy", alice, map[string]interface{}{"max_age": "1h"})
+	mustStatus(t, rec, http.StatusOK)
+	removed, err := s.uc.PurgeFileVersions(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeFileVersions failed: %s", err)
+	}
+	if versions := s.listVersions(alice, file.UUID); removed != 1 || len(versions) != 1 || versions[0].Version != 5 {
+		t.Errorf("purged %d versions leaving %+v, want only the current version left", removed, versions)
+	}
+	if n := s.countStoredFiles(); n != 1 {
+		t.Errorf("%d files stored, want only the current content", n)
+	}
+	rec = s.request(http.MethodPut, "/fman/file/"+file.UUID+"/version-policy", alice, map[string]interface{}{"max_age": "soon"})
+	mustStatus(t, rec, http.StatusBadRequest)
+}
+
+// usage reads the quota and the bytes used of a user.
+func (s *testServer) usage(user testUser) models.Usage {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/usage", user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var usage models.Usage
+	decodeJSON(s.t, rec, &usage)
+	return usage
+}
+
+func TestQuotas(t *testing.T) {
+	s := newTestServer(t, usecase.Options{DefaultQuota: 100})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, strings.Repeat("a", 60))
+	if usage := s.usage(alice); usage.LimitBytes != 100 || usage.UsedBytes != 60 {
+		t.Errorf("usage = %+v, want 60 of 100 bytes used", usage)
+	}
+
+	// Content exceeding the quota is rejected before it is stored, and copies count in full.
+	err := s.uc.UploadFile(alice.User, "b.txt", alice.RootDirUUID, strings.NewReader(strings.Repeat("b", 41)), models.Checksums{})
+	if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+		t.Errorf("upload over the quota failed with %v, want QuotaExceededErrorCode", err)
+	}
+	docs := s.mkdir(alice, "docs", alice.RootDirUUID)
+	if err := s.uc.CopyFile(alice.User, file.UUID, docs); !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+		t.Errorf("copy over the quota failed with %v, want QuotaExceededErrorCode", err)
+	}
+	if n := s.countStoredFiles(); n != 1 {
+		t.Errorf("%d files stored, want only the first upload", n)
+	}
+
+	// A directory quota limits the files in its subtree.
+	rec := s.request(http.MethodPut, "/fman/dir/"+docs+"/quota", alice, map[string]interface{}{"limit_bytes": 10})
+	mustStatus(t, rec, http.StatusOK)
+	err = s.uc.UploadFile(alice.User, "c.txt", docs, strings.NewReader(strings.Repeat("c", 11)), models.Checksums{})
+	if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+		t.Errorf("upload over the directory quota failed with %v, want QuotaExceededErrorCode", err)
+	}
+	s.upload(alice, "c.txt", docs, strings.Repeat("c", 10))
+	if usage := s.usage(alice); usage.UsedBytes != 70 || len(usage.Directories) != 1 || usage.Directories[0].UsedBytes != 10 {
+		t.Errorf("usage = %+v, want 70 bytes used and 10 of them in docs", usage)
+	}
+}
+
+func TestQuotaReservations(t *testing.T) {
+	s := newTestServer(t, usecase.Options{DefaultQuota: 100})
+	alice := s.register("alice")
+
+	// Concurrent uploads of known and unknown size cannot exceed the quota together.
+	errs := make([]error, 8)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var content io.Reader = strings.NewReader(strings.Repeat("x", 30))
+			if i%2 == 1 {
+				// Hide the size of the content.
+				content = io.MultiReader(content)
+			}
+			errs[i] = s.uc.UploadFile(alice.User, fmt.Sprintf("%d.txt", i), alice.RootDirUUID, content, models.Checksums{})
+		}(i)
+	}
+	wg.Wait()
+	uploaded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			uploaded++
+		case !models.IsFManErrorCode(err, models.QuotaExceededErrorCode):
+			t.Errorf("concurrent upload failed with %v, want QuotaExceededErrorCode", err)
+		}
+	}
+	if usage := s.usage(alice); uploaded != 3 || usage.UsedBytes != 90 {
+		t.Errorf("%d concurrent uploads succeeded using %d bytes, want 3 using 90 bytes", uploaded, usage.UsedBytes)
+	}
+
+	// A failed upload releases its reservation.
+	err := s.uc.UploadFile(alice.User, "bad.txt", alice.RootDirUUID, strings.NewReader("0123456789"), models.Checksums{MD5: "0"})
+	if !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+		t.Errorf("upload with a wrong checksum failed with %v, want InvalidArgumentErrorCode", err)
+	}
+	s.upload(alice, "good.txt", alice.RootDirUUID, "0123456789")
+	if usage := s.usage(alice); usage.UsedBytes != 100 {
+		t.Errorf("used bytes = %d, want 100", usage.UsedBytes)
+	}
+}
+
+func TestStorageStats(t *testing.T) {
+	fileOps, err := fileUtils.CreateNewCompressedFileOperator(fileUtils.CreateNewLocalFileOperator(t.TempDir()), 0)
+	if err != nil {
+		t.Fatalf("CreateNewCompressedFileOperator failed: %s", err)
+	}
+	s := newTestServerWithStorage(t, usecase.Options{}, fileOps)
+	alice, admin := s.register("alice"), s.registerAdmin("admin")
+	serverLog := strings.Repeat("2026-01-01T00:00:00Z INFO request served\n", 1000)
+	file := s.upload(alice, "server.serverLog", alice.RootDirUUID, serverLog)
+	// A copy shares the content, which is counted once.
+	s.upload(alice, "copy.serverLog", alice.RootDirUUID, serverLog)
+	s.upload(alice, "tiny.txt", alice.RootDirUUID, "tiny")
+
+	// Files keep their size, while they take up fewer bytes, and quotas count the size.
+	if file.FileSize != uint64(len(serverLog)) || file.StoredSize*10 > file.FileSize {
+		t.Errorf("file of %d bytes is stored in %d bytes, want %d stored in a tenth", file.FileSize, file.StoredSize, len(serverLog))
+	}
+	if usage := s.usage(alice); usage.UsedBytes != int64(2*len(serverLog)+4) {
+		t.Errorf("used bytes = %d, want %d", usage.UsedBytes, 2*len(serverLog)+4)
+	}
+
+	mustStatus(t, s.request(http.MethodGet, "/fman/storage/stats", alice, nil), http.StatusForbidden)
+	rec := s.request(http.MethodGet, "/fman/storage/stats", admin, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var stats models.StorageStats
+	decodeJSON(t, rec, &stats)
+	want := models.StorageStats{
+		Blobs:           2,
+		CompressedBlobs: 1,
+		LogicalBytes:    int64(len(serverLog) + 4),
+		StoredBytes:     int64(file.StoredSize + 4),
+		SavedBytes:      int64(len(serverLog)) - int64(file.StoredSize),
+	}
+	if stats != want {
+		t.Errorf("storage stats = %+v, want %+v", stats, want)
+	}
+}
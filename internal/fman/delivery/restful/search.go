@@ -0,0 +1,102 @@
+package restful
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// SearchResponse represents a page of search results in JSON format.
+type SearchResponse struct {
+	Results    []models.SearchResult `json:"results"`
+	NextOffset int                   `json:"next_offset,omitempty"`
+}
+
+// initSearchHandler initializes the search endpoint.
+func initSearchHandler(g *echo.Group, handler *FmanHandler) {
+	g.GET("/search", handler.Search)
+}
+
+// Search returns a page of the files and directories matching the query param q, ranked by
+// relevance. The other query params filter the results, and limit and offset select the
+// page.
+func (h *FmanHandler) Search(c echo.Context) error {
+	opts, err := searchOptions(c)
+	if err != nil {
+		return err
+	}
+	results, nextOffset, err := h.FmanUsecase.Search(authRestful.UserFromContext(c), c.QueryParam("q"), opts)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if results == nil {
+		results = []models.SearchResult{}
+	}
+	return c.JSON(http.StatusOK, SearchResponse{Results: results, NextOffset: nextOffset})
+}
+
+// searchOptions parses the query params of a search. Dates are in RFC 3339 format.
+func searchOptions(c echo.Context) (models.SearchOptions, error) {
+	opts := models.SearchOptions{
+		Type:         c.QueryParam("type"),
+		OwnerUUID:    c.QueryParam("owner"),
+		UnderDirUUID: c.QueryParam("under"),
+		Tag:          c.QueryParam("tag"),
+	}
+	var err error
+	if opts.MinSize, err = int64QueryParam(c, "min_size"); err != nil {
+		return models.SearchOptions{}, err
+	}
+	if opts.MaxSize, err = int64QueryParam(c, "max_size"); err != nil {
+		return models.SearchOptions{}, err
+	}
+	if opts.UpdatedAfter, err = timeQueryParam(c, "updated_after"); err != nil {
+		return models.SearchOptions{}, err
+	}
+	if opts.UpdatedBefore, err = timeQueryParam(c, "updated_before"); err != nil {
+		return models.SearchOptions{}, err
+	}
+	limit, err := int64QueryParam(c, "limit")
+	if err != nil {
+		return models.SearchOptions{}, err
+	}
+	offset, err := int64QueryParam(c, "offset")
+	if err != nil {
+		return models.SearchOptions{}, err
+	}
+	opts.Limit, opts.Offset = int(limit), int(offset)
+	return opts, nil
+}
+
+// int64QueryParam parses an optional non-negative integer query param, which defaults to 0.
+func int64QueryParam(c echo.Context, name string) (int64, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || value < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative integer", name))
+	}
+	return value, nil
+}
+
+// timeQueryParam parses an optional RFC 3339 time query param, which defaults to the zero
+// time.
+func timeQueryParam(c echo.Context, name string) (time.Time, error) {
+	param := c.QueryParam(name)
+	if param == "" {
+		return time.Time{}, nil
+	}
+	value, err := time.Parse(time.RFC3339, param)
+	if err != nil {
+		return time.Time{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 time", name))
+	}
+	return value, nil
+}
@@ -0,0 +1,156 @@
+package restful
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// search searches with query params and returns the names of the found directories and
+// files in order, together with the offset of the next page.
+func (s *testServer) search(user testUser, query url.Values) ([]string, int) {
+	s.t.Helper()
+	rec := s.request(http.MethodGet, "/fman/search?"+query.Encode(), user, nil)
+	mustStatus(s.t, rec, http.StatusOK)
+	var res SearchResponse
+	decodeJSON(s.t, rec, &res)
+	names := []string{}
+	for _, result := range res.Results {
+		if result.Directory != nil {
+			names = append(names, result.Directory.Dirname+"/")
+		} else {
+			names = append(names, result.File.Filename)
+		}
+	}
+	return names, res.NextOffset
+}
+
+// searchNames searches for a query and returns the sorted names of the found files and
+// directories.
+func (s *testServer) searchNames(user testUser, q string, params ...string) []string {
+	s.t.Helper()
+	query := url.Values{"q": {q}}
+	for i := 0; i+1 < len(params); i += 2 {
+		query.Set(params[i], params[i+1])
+	}
+	names, _ := s.search(user, query)
+	sort.Strings(names)
+	return names
+}
+
+func TestSearch(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	reports := s.mkdir(alice, "reports", alice.RootDirUUID)
+	s.upload(alice, "annual-report.md", reports, "# Revenue\nThe annual revenue grew.")
+	s.upload(alice, "notes.txt", alice.RootDirUUID, "a report on the revenue of last year")
+	s.upload(alice, "photo.png", alice.RootDirUUID, "\x89PNG\r\n\x1a\nrevenue")
+
+	for _, c := range []struct {
+		q    string
+		want []string
+	}{
+		// Names, paths and the content of text files are found, but not binary content.
+		{"revenue", []string{"annual-report.md", "notes.txt"}},
+		{"reports", []string{"annual-report.md", "reports/"}},
+		// Phrases match words in order, and a trailing * matches a prefix.
+		{`"annual revenue"`, []string{"annual-report.md"}},
+		{`"revenue annual"`, []string{}},
+		{"rep*", []string{"annual-report.md", "notes.txt", "reports/"}},
+		{"revenue year", []string{"notes.txt"}},
+	} {
+		if got := s.searchNames(alice, c.q); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("search for %s = %v, want %v", c.q, got, c.want)
+		}
+	}
+
+	// Filters narrow the results.
+	if got := s.searchNames(alice, "rep*", "type", models.EntryTypeDir); !reflect.DeepEqual(got, []string{"reports/"}) {
+		t.Errorf("directories found = %v, want reports", got)
+	}
+	if got := s.searchNames(alice, "revenue", "under", reports); !reflect.DeepEqual(got, []string{"annual-report.md"}) {
+		t.Errorf("files found under reports = %v, want annual-report.md", got)
+	}
+	if got := s.searchNames(alice, "revenue", "min_size", "35"); !reflect.DeepEqual(got, []string{"notes.txt"}) {
+		t.Errorf("files found of at least 35 bytes = %v, want notes.txt", got)
+	}
+	if got := s.searchNames(alice, "revenue", "updated_after", "2999-01-01T00:00:00Z"); len(got) != 0 {
+		t.Errorf("files found updated in the future = %v", got)
+	}
+	for _, query := range []url.Values{
+		{"q": {""}},
+		{"q": {"revenue"}, "min_size": {"-1"}},
+		{"q": {"revenue"}, "updated_after": {"yesterday"}},
+		{"q": {"revenue"}, "type": {"link"}},
+	} {
+		mustStatus(t, s.request(http.MethodGet, "/fman/search?"+query.Encode(), alice, nil), http.StatusBadRequest)
+	}
+
+	// Others find only what is readable by them.
+	bob := s.register("bob")
+	if got := s.searchNames(bob, "revenue"); len(got) != 0 {
+		t.Errorf("bob found %v, want nothing", got)
+	}
+	mustStatus(t, s.request(http.MethodGet, "/fman/search?q=revenue&under="+reports, bob, nil), http.StatusForbidden)
+}
+
+func TestSearchIndexUpdates(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	archive := s.mkdir(alice, "archive", alice.RootDirUUID)
+	file := s.upload(alice, "draft.txt", alice.RootDirUUID, "quarterly figures")
+
+	// The index follows moves, new content and removals.
+	if err := s.uc.MoveFile(alice.User, file.UUID, "final.txt", archive); err != nil {
+		t.Fatalf("MoveFile failed: %s", err)
+	}
+	if got := s.searchNames(alice, "draft"); len(got) != 0 {
+		t.Errorf("search for the old name = %v, want nothing", got)
+	}
+	if got := s.searchNames(alice, "archive final"); !reflect.DeepEqual(got, []string{"final.txt"}) {
+		t.Errorf("search for the new path = %v, want final.txt", got)
+	}
+	mustStatus(t, s.putContent(alice, file.UUID, "yearly totals"), http.StatusOK)
+	if got := s.searchNames(alice, "quarterly"); len(got) != 0 {
+		t.Errorf("search for the old content = %v, want nothing", got)
+	}
+	if got := s.searchNames(alice, "totals"); !reflect.DeepEqual(got, []string{"final.txt"}) {
+		t.Errorf("search for the new content = %v, want final.txt", got)
+	}
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+file.UUID, alice, nil), http.StatusOK)
+	if got := s.searchNames(alice, "totals"); len(got) != 0 {
+		t.Errorf("search after a removal = %v, want nothing", got)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		s.upload(alice, "log-"+name+".txt", alice.RootDirUUID, strings.Repeat("entry ", 3))
+	}
+	var all []string
+	query := url.Values{"q": {"entry"}, "limit": {"2"}}
+	for page := 0; ; page++ {
+		if page == 5 {
+			t.Fatal("too many pages")
+		}
+		names, next := s.search(alice, query)
+		all = append(all, names...)
+		if next == 0 {
+			break
+		}
+		query.Set("offset", strconv.Itoa(next))
+	}
+	sort.Strings(all)
+	if want := []string{"log-a.txt", "log-b.txt", "log-c.txt", "log-d.txt", "log-e.txt"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("paged results = %v, want %v", all, want)
+	}
+}
@@ -0,0 +1,188 @@
+package restful
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// headerSharePassword holds the password of a share link. The password can also be sent
+// with HTTP Basic authentication and any username, which lets browsers ask for it.
+const headerSharePassword = "X-Share-Password"
+
+// ShareRequest represents a request to create a share link. expires_at is a time in
+// RFC 3339 format, e.g. 2030-01-02T15:04:05Z.
+type ShareRequest struct {
+	ItemUUID     string    `json:"item_uuid" form:"item_uuid"`
+	ItemType     string    `json:"item_type" form:"item_type"`
+	Password     string    `json:"password" form:"password"`
+	Mode         string    `json:"mode" form:"mode"`
+	MaxDownloads int       `json:"max_downloads" form:"max_downloads"`
+	ExpiresAt    time.Time `json:"expires_at" form:"expires_at"`
+}
+
+// initShareHandler initializes the endpoints managing the share links of a user in g, and
+// the public endpoints used through share links, which need no user.
+func initShareHandler(e *echo.Echo, g *echo.Group, handler *FmanHandler) {
+	g.POST("/shares", handler.CreateShareLink)
+	g.GET("/shares", handler.ListShareLinks)
+	g.DELETE("/shares/:uuid", handler.RevokeShareLink)
+	sg := e.Group("/share/:token")
+	sg.GET("", handler.OpenShareLink)
+	sg.GET("/content", handler.DownloadSharedFile)
+	sg.GET("/dir", handler.ListSharedDirectory)
+	sg.GET("/dir/:uuid", handler.ListSharedDirectory)
+	sg.HEAD("/content", handler.DownloadSharedFile)
+	sg.GET("/file/:uuid/content", handler.DownloadSharedFile)
+	sg.HEAD("/file/:uuid/content", handler.DownloadSharedFile)
+	sg.POST("/file", handler.UploadSharedFile)
+}
+
+// CreateShareLink creates a share link to a file or a directory, and returns it with
+// its token.
+func (h *FmanHandler) CreateShareLink(c echo.Context) error {
+	req := ShareRequest{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	opts := models.ShareLinkOptions{
+		ItemUUID:     req.ItemUUID,
+		ItemType:     req.ItemType,
+		Password:     req.Password,
+		Mode:         req.Mode,
+		MaxDownloads: req.MaxDownloads,
+		ExpiresAt:    req.ExpiresAt,
+	}
+	link, err := h.FmanUsecase.CreateShareLink(authRestful.UserFromContext(c), opts)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusCreated, link)
+}
+
+// ListShareLinks returns the share links of the user, most recently created first.
+func (h *FmanHandler) ListShareLinks(c echo.Context) error {
+	links, err := h.FmanUsecase.ListShareLinks(authRestful.UserFromContext(c))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	if links == nil {
+		links = []models.ShareLink{}
+	}
+	return c.JSON(http.StatusOK, links)
+}
+
+// RevokeShareLink revokes a share link.
+func (h *FmanHandler) RevokeShareLink(c echo.Context) error {
+	if err := h.FmanUsecase.RevokeShareLink(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Revoked share link successfully"})
+}
+
+// OpenShareLink returns a share link with the shared file or directory.
+func (h *FmanHandler) OpenShareLink(c echo.Context) error {
+	item, err := h.FmanUsecase.OpenShareLink(c.Param("token"), sharePassword(c))
+	if err != nil {
+		return shareHTTPError(c, err)
+	}
+	return c.JSON(http.StatusOK, item)
+}
+
+// ListSharedDirectory returns a directory in the subtree shared by a link with a page of its
+// children, or the shared directory itself if no UUID is given. The query params are the
+// same as for ListDirectory.
+func (h *FmanHandler) ListSharedDirectory(c echo.Context) error {
+	opts, err := dirListOptions(c)
+	if err != nil {
+		return err
+	}
+	dir, nextCursor, err := h.FmanUsecase.ListSharedDirectory(c.Param("token"), sharePassword(c), c.Param("uuid"), opts)
+	if err != nil {
+		return shareHTTPError(c, err)
+	}
+	return c.JSON(http.StatusOK, DirListingResponse{Directory: dir, NextCursor: nextCursor})
+}
+
+// DownloadSharedFile streams the content of a file shared by a link in the same way as
+// DownloadFile, or of the shared file itself if no UUID is given. A GET request counts
+// towards the download limit of the link if it reads the content from the start, so a
+// download in ranges is counted once. HEAD requests are not counted.
+func (h *FmanHandler) DownloadSharedFile(c echo.Context) error {
+	req := c.Request()
+	count := req.Method == http.MethodGet && readsFromStart(req.Header)
+	file, content, err := h.FmanUsecase.DownloadSharedFile(c.Param("token"), sharePassword(c), c.Param("uuid"), count)
+	if err != nil {
+		return shareHTTPError(c, err)
+	}
+	defer content.Close()
+	res := c.Response()
+	setFileHeaders(res, file)
+	res.Header().Set("Cache-Control", "private, no-store")
+	http.ServeContent(res, c.Request(), file.Filename, file.UpdatedAt, content)
+	return nil
+}
+
+// UploadSharedFile uploads a new file through a share link, which allows uploads, into the
+// directory given by the form value parent_uuid or the shared directory itself.
+func (h *FmanHandler) UploadSharedFile(c echo.Context) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	expected, err := uploadChecksums(c, file.Header)
+	if err != nil {
+		return err
+	}
+	err = h.FmanUsecase.UploadSharedFile(c.Param("token"), sharePassword(c), c.FormValue("filename"), c.FormValue("parent_uuid"), src,
+		expected)
+	if err != nil {
+		return shareHTTPError(c, err)
+	}
+	return c.JSON(http.StatusOK, Response{Message: "Uploaded file successfully"})
+}
+
+// readsFromStart checks if a request with a header may read a content from its start. This
+// is the case unless the first range of its Range header starts at a later offset. Suffix
+// ranges, e.g. bytes=-500, may cover the whole content, and conditional ranges are served
+// in full if the If-Range condition does not hold, so both are counted as reading from the
+// start. Invalid Range headers are ignored or rejected.
+func readsFromStart(header http.Header) bool {
+	ranges := header.Get("Range")
+	if !strings.HasPrefix(ranges, "bytes=") || header.Get("If-Range") != "" {
+		return true
+	}
+	first := strings.SplitN(strings.TrimPrefix(ranges, "bytes="), ",", 2)[0]
+	start := strings.TrimSpace(strings.SplitN(first, "-", 2)[0])
+	offset, err := strconv.ParseInt(start, 10, 64)
+	return err != nil || offset == 0
+}
+
+// sharePassword returns the password of a share link sent with a request.
+func sharePassword(c echo.Context) string {
+	if password := c.Request().Header.Get(headerSharePassword); password != "" {
+		return password
+	}
+	_, password, _ := c.Request().BasicAuth()
+	return password
+}
+
+// shareHTTPError converts an error of an operation through a share link in the same way as
+// toHTTPError, and asks for the password if it is missing or wrong.
+func shareHTTPError(c echo.Context, err error) error {
+	if models.IsFManErrorCode(err, models.UnauthorizedErrorCode) {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="share", charset="UTF-8"`)
+	}
+	return errUtils.ToHTTPError(err)
+}
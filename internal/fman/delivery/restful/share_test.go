@@ -0,0 +1,290 @@
+package restful
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// share creates a share link with the options of a request and returns it.
+func (s *testServer) share(user testUser, req ShareRequest) models.ShareLink {
+	s.t.Helper()
+	rec := s.request(http.MethodPost, "/fman/shares", user, req)
+	mustStatus(s.t, rec, http.StatusCreated)
+	var link models.ShareLink
+	decodeJSON(s.t, rec, &link)
+	return link
+}
+
+// shareRequest serves an unauthenticated request to a share link with a password, which
+// is not sent if it is empty, and headers.
+func (s *testServer) shareRequest(method, target, password string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if password != "" {
+		req.Header.Set(headerSharePassword, password)
+	}
+	return s.serve(req, testUser{})
+}
+
+func TestShareLinks(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	docs := s.mkdir(alice, "docs", alice.RootDirUUID)
+	sub := s.mkdir(alice, "sub", docs)
+	file := s.upload(alice, "a.txt", sub, "0123456789")
+	other := s.upload(alice, "b.txt", alice.RootDirUUID, "other")
+
+	// A directory link shares its subtree only, and everything else is not found through it.
+	link := s.share(alice, ShareRequest{ItemUUID: docs, ItemType: models.EntryTypeDir})
+	rec := s.shareRequest(http.MethodGet, "/share/"+link.Token, "", nil)
+	mustStatus(t, rec, http.StatusOK)
+	var item models.SharedItem
+	decodeJSON(t, rec, &item)
+	if item.Directory == nil || item.Directory.UUID != docs || item.Link.PasswordHash != "" {
+		t.Errorf("opened item = %+v, want docs", item)
+	}
+	rec = s.shareRequest(http.MethodGet, "/share/"+link.Token+"/dir/"+sub, "", nil)
+	mustStatus(t, rec, http.StatusOK)
+	var res DirListingResponse
+	decodeJSON(t, rec, &res)
+	if len(res.Directory.ListOfFiles) != 1 || res.Directory.ListOfFiles[0].UUID != file.UUID {
+		t.Errorf("shared listing = %+v, want a.txt", res.Directory)
+	}
+	rec = s.shareRequest(http.MethodGet, "/share/"+link.Token+"/file/"+file.UUID+"/content", "", nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "0123456789" {
+		t.Errorf("shared content = %q", rec.Body.String())
+	}
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/file/"+other.UUID+"/content", "", nil), http.StatusNotFound)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/dir/"+alice.RootDirUUID, "", nil), http.StatusNotFound)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/unknown", "", nil), http.StatusNotFound)
+
+	// Links with a password ask for it.
+	protected := s.share(alice, ShareRequest{ItemUUID: other.UUID, ItemType: models.EntryTypeFile, Password: "secret"})
+	rec = s.shareRequest(http.MethodGet, "/share/"+protected.Token+"/content", "", nil)
+	mustStatus(t, rec, http.StatusUnauthorized)
+	if rec.Header().Get("WWW-Authenticate") == "" {
+		t.Error("missing WWW-Authenticate header")
+	}
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+protected.Token+"/content", "wrong", nil), http.StatusUnauthorized)
+	rec = s.shareRequest(http.MethodGet, "/share/"+protected.Token+"/content", "secret", nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "other" {
+		t.Errorf("shared content = %q, want other", rec.Body.String())
+	}
+
+	// Links are listed and revoked by their creator only.
+	rec = s.request(http.MethodGet, "/fman/shares", alice, nil)
+	mustStatus(t, rec, http.StatusOK)
+	var links []models.ShareLink
+	decodeJSON(t, rec, &links)
+	if len(links) != 2 || links[0].UUID != protected.UUID || !links[0].HasPassword {
+		t.Errorf("links = %+v, want the protected link first", links)
+	}
+	bob := s.register("bob")
+	mustStatus(t, s.request(http.MethodDelete, "/fman/shares/"+link.UUID, bob, nil), http.StatusForbidden)
+	mustStatus(t, s.request(http.MethodDelete, "/fman/shares/"+link.UUID, alice, nil), http.StatusOK)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token, "", nil), http.StatusNotFound)
+
+	// Only items the user may share can be shared.
+	mustStatus(t, s.request(http.MethodPost, "/fman/shares", bob, ShareRequest{ItemUUID: docs, ItemType: models.EntryTypeDir}),
+		http.StatusForbidden)
+	mustStatus(t, s.request(http.MethodPost, "/fman/shares", alice, ShareRequest{ItemUUID: other.UUID, ItemType: "link"}),
+		http.StatusBadRequest)
+}
+
+func TestShareLinkExpiry(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "0123456789")
+
+	req := ShareRequest{ItemUUID: file.UUID, ItemType: models.EntryTypeFile, ExpiresAt: time.Now().Add(-time.Minute)}
+	mustStatus(t, s.request(http.MethodPost, "/fman/shares", alice, req), http.StatusBadRequest)
+	req.ExpiresAt = time.Now().Add(time.Minute)
+	link := s.share(alice, req)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/content", "", nil), http.StatusOK)
+	// Let the link expire.
+	expired := time.Now().Add(-time.Second)
+	stored, err := s.repo.ReadShareLinkRecord(link.UUID)
+	if err != nil {
+		t.Fatalf("ReadShareLinkRecord failed: %s", err)
+	}
+	stored.ExpiresAt = &expired
+	if err := s.repo.HardRemoveShareLinkRecord(link.UUID); err != nil {
+		t.Fatalf("HardRemoveShareLinkRecord failed: %s", err)
+	}
+	if err := s.repo.InsertShareLinkRecord(stored); err != nil {
+		t.Fatalf("InsertShareLinkRecord failed: %s", err)
+	}
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/content", "", nil), http.StatusGone)
+}
+
+func TestShareLinkDownloadLimit(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	file := s.upload(alice, "a.txt", alice.RootDirUUID, "0123456789")
+	link := s.share(alice, ShareRequest{ItemUUID: file.UUID, ItemType: models.EntryTypeFile, MaxDownloads: 2})
+	target := "/share/" + link.Token + "/file/" + file.UUID + "/content"
+	assertDownloads := func(want int) {
+		t.Helper()
+		stored, err := s.repo.ReadShareLinkRecord(link.UUID)
+		if err != nil {
+			t.Fatalf("ReadShareLinkRecord failed: %s", err)
+		}
+		if stored.Downloads != want {
+			t.Errorf("downloads = %d, want %d", stored.Downloads, want)
+		}
+	}
+
+	// HEAD requests and later ranges of a download are not counted.
+	rec := s.shareRequest(http.MethodHead, target, "", nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("Content-Length") != "10" {
+		t.Errorf("Content-Length = %q, want 10", rec.Header().Get("Content-Length"))
+	}
+	assertDownloads(0)
+	mustStatus(t, s.shareRequest(http.MethodGet, target, "", http.Header{"Range": {"bytes=0-4"}}), http.StatusPartialContent)
+	assertDownloads(1)
+	rec = s.shareRequest(http.MethodGet, target, "", http.Header{"Range": {"bytes=5-"}})
+	mustStatus(t, rec, http.StatusPartialContent)
+	if rec.Body.String() != "56789" {
+		t.Errorf("range content = %q, want 56789", rec.Body.String())
+	}
+	assertDownloads(1)
+
+	// Suffix ranges may cover the whole content, so they are counted.
+	mustStatus(t, s.shareRequest(http.MethodGet, target, "", http.Header{"Range": {"bytes=-100"}}), http.StatusPartialContent)
+	assertDownloads(2)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/content", "", nil), http.StatusGone)
+	mustStatus(t, s.shareRequest(http.MethodHead, target, "", nil), http.StatusGone)
+	assertDownloads(2)
+}
+
+// shareUpload uploads a file with a name and a content through a share link into the
+// shared directory.
+func (s *testServer) shareUpload(token, filename, content string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	err := w.WriteField("filename", filename)
+	var part io.Writer
+	if err == nil {
+		part, err = w.CreateFormFile("file", filename)
+	}
+	if err == nil {
+		_, err = part.Write([]byte(content))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/share/"+token+"/file", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return s.serve(req, testUser{})
+}
+
+func TestShareLinkUploads(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice, bob := s.register("alice"), s.register("bob")
+	team := s.mkdir(alice, "team", alice.RootDirUUID)
+
+	// Links for uploads need the write permission besides the share permission.
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "share")
+	req := ShareRequest{ItemUUID: team, ItemType: models.EntryTypeDir, Mode: models.ShareModeUpload}
+	mustStatus(t, s.request(http.MethodPost, "/fman/shares", bob, req), http.StatusForbidden)
+	readLink := s.share(bob, ShareRequest{ItemUUID: team, ItemType: models.EntryTypeDir})
+	mustStatus(t, s.shareUpload(readLink.Token, "a.txt", "a"), http.StatusForbidden)
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "write", "share")
+	link := s.share(bob, req)
+
+	// The file is uploaded by the creator of the link and owned by the owner of the
+	// directory. Existing files are not replaced.
+	mustStatus(t, s.shareUpload(link.Token, "a.txt", "a"), http.StatusOK)
+	file, err := s.repo.ReadFileRecordByName("a.txt", team)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	if file.OwnerUUID != alice.UUID {
+		t.Errorf("owner of the uploaded file = %s, want alice", file.OwnerUUID)
+	}
+	mustStatus(t, s.shareUpload(link.Token, "a.txt", "b"), http.StatusConflict)
+
+	// The write permission of the creator is checked again with every upload.
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "share")
+	mustStatus(t, s.shareUpload(link.Token, "b.txt", "b"), http.StatusForbidden)
+	if _, err := s.repo.ReadFileRecordByName("b.txt", team); !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		t.Errorf("b.txt was uploaded without the write permission: %v", err)
+	}
+}
+
+func TestShareLinkReadPermission(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice, bob := s.register("alice"), s.register("bob")
+	team := s.mkdir(alice, "team", alice.RootDirUUID)
+	private := s.mkdir(alice, "private", team)
+	file := s.upload(alice, "a.txt", team, "a")
+	hidden := s.upload(alice, "b.txt", private, "b")
+
+	// Admins cannot share the files of others, as the links are used on their behalf.
+	admin := s.registerAdmin("admin")
+	req := ShareRequest{ItemUUID: team, ItemType: models.EntryTypeDir}
+	mustStatus(t, s.request(http.MethodPost, "/fman/shares", admin, req), http.StatusForbidden)
+
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID, "share")
+	link := s.share(bob, req)
+	fileLink := s.share(bob, ShareRequest{ItemUUID: file.UUID, ItemType: models.EntryTypeFile})
+
+	// Children, which the creator of the link cannot read, are left out and cannot be
+	// downloaded.
+	s.grant(alice, models.EntryTypeDir, private, models.PrincipalUser, bob.UUID)
+	rec := s.shareRequest(http.MethodGet, "/share/"+link.Token+"/dir/"+team, "", nil)
+	mustStatus(t, rec, http.StatusOK)
+	var res DirListingResponse
+	decodeJSON(t, rec, &res)
+	if len(res.Directory.ListOfDirs) != 0 || len(res.Directory.ListOfFiles) != 1 {
+		t.Errorf("shared listing = %+v, want only a.txt", res.Directory)
+	}
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/dir/"+private, "", nil), http.StatusForbidden)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/file/"+hidden.UUID+"/content", "", nil),
+		http.StatusForbidden)
+
+	// The read permission of the creator is checked again with every listing and download.
+	s.grant(alice, models.EntryTypeDir, team, models.PrincipalUser, bob.UUID)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/dir/"+team, "", nil), http.StatusForbidden)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+link.Token+"/file/"+file.UUID+"/content", "", nil),
+		http.StatusForbidden)
+	mustStatus(t, s.shareRequest(http.MethodGet, "/share/"+fileLink.Token+"/content", "", nil), http.StatusForbidden)
+}
+
+func TestReadsFromStart(t *testing.T) {
+	for _, c := range []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{}, true},
+		{http.Header{"Range": {"bytes=0-99"}}, true},
+		{http.Header{"Range": {"bytes= 0-1, 5-"}}, true},
+		{http.Header{"Range": {"bytes=100-"}}, false},
+		{http.Header{"Range": {"bytes=100-199,0-9"}}, false},
+		{http.Header{"Range": {"bytes=-100"}}, true},
+		{http.Header{"Range": {"bytes=100-"}, "If-Range": {`"etag"`}}, true},
+		{http.Header{"Range": {"items=100-"}}, true},
+		{http.Header{"Range": {"bytes=x-"}}, true},
+	} {
+		if got := readsFromStart(c.header); got != c.want {
+			t.Errorf("readsFromStart(%v) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
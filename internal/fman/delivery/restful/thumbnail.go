@@ -0,0 +1,33 @@
+package restful
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+)
+
+// initThumbnailHandler initializes the thumbnail endpoint.
+func initThumbnailHandler(g *echo.Group, handler *FmanHandler) {
+	g.GET("/file/:uuid/thumbnail", handler.ReadThumbnail)
+	g.HEAD("/file/:uuid/thumbnail", handler.ReadThumbnail)
+}
+
+// ReadThumbnail streams a thumbnail of an image file in the size of the query param size,
+// which is small, medium (default) or large. Conditional requests are supported.
+func (h *FmanHandler) ReadThumbnail(c echo.Context) error {
+	thumbnail, content, err := h.FmanUsecase.ReadThumbnail(authRestful.UserFromContext(c), c.Param("uuid"),
+		c.QueryParam("size"))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	defer content.Close()
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, thumbnail.ContentType)
+	// A thumbnail never changes, as a new version of the file gets new thumbnails.
+	res.Header().Set("ETag", fmt.Sprintf(`"%s-%d-%s"`, thumbnail.FileUUID, thumbnail.Version, thumbnail.Size))
+	http.ServeContent(res, c.Request(), "", thumbnail.CreatedAt, content)
+	return nil
+}
@@ -0,0 +1,160 @@
+package restful
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// testImage encodes an image with a width and a height in a format, png or jpeg.
+func testImage(t *testing.T, format string, width, height int) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// thumbnail requests a thumbnail of a file in a size, the default size if size is empty.
+func (s *testServer) thumbnail(user testUser, fileUUID, size string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/fman/file/"+fileUUID+"/thumbnail?size="+size, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return s.serve(req, user)
+}
+
+// waitForThumbnails waits until the thumbnails in all sizes of a version of a file were made.
+func (s *testServer) waitForThumbnails(fileUUID string, version int) {
+	s.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for size := range models.ThumbnailSizes {
+		for {
+			_, err := s.repo.ReadThumbnailRecord(fileUUID, version, size)
+			if err == nil {
+				break
+			}
+			if !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+				s.t.Fatalf("ReadThumbnailRecord failed: %s", err)
+			}
+			if time.Now().After(deadline) {
+				s.t.Fatalf("no %s thumbnail of version %d was made", size, version)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestThumbnails(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	photo := s.upload(alice, "photo.png", alice.RootDirUUID, testImage(t, "png", 600, 300))
+
+	// Images are scaled down to fit the size, keeping their aspect ratio.
+	for _, c := range []struct {
+		size          string
+		width, height int
+	}{
+		{"", 256, 128},
+		{models.ThumbnailSizeSmall, 128, 64},
+		{models.ThumbnailSizeLarge, 512, 256},
+	} {
+		rec := s.thumbnail(alice, photo.UUID, c.size, nil)
+		mustStatus(t, rec, http.StatusOK)
+		if ct := rec.Header().Get(echo.HeaderContentType); ct != "image/png" {
+			t.Errorf("content type of the %q thumbnail = %s, want image/png", c.size, ct)
+		}
+		config, err := png.DecodeConfig(rec.Body)
+		if err != nil {
+			t.Fatalf("invalid %q thumbnail: %s", c.size, err)
+		}
+		if config.Width != c.width || config.Height != c.height {
+			t.Errorf("%q thumbnail is %dx%d, want %dx%d", c.size, config.Width, config.Height, c.width, c.height)
+		}
+	}
+	rec := s.thumbnail(alice, photo.UUID, "", nil)
+	header := http.Header{"If-None-Match": {rec.Header().Get("ETag")}}
+	mustStatus(t, s.thumbnail(alice, photo.UUID, "", header), http.StatusNotModified)
+
+	// Small images are not scaled up, and JPEG images get JPEG thumbnails.
+	small := s.upload(alice, "small.jpg", alice.RootDirUUID, testImage(t, "jpeg", 40, 30))
+	rec = s.thumbnail(alice, small.UUID, models.ThumbnailSizeLarge, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if ct := rec.Header().Get(echo.HeaderContentType); ct != "image/jpeg" {
+		t.Errorf("content type of a JPEG thumbnail = %s, want image/jpeg", ct)
+	}
+	if config, err := jpeg.DecodeConfig(rec.Body); err != nil || config.Width != 40 || config.Height != 30 {
+		t.Errorf("thumbnail of a small image is %+v (%v), want 40x30", config, err)
+	}
+
+	// Other files, broken images and unknown sizes have no thumbnails.
+	notes := s.upload(alice, "notes.txt", alice.RootDirUUID, "notes")
+	broken := s.upload(alice, "broken.png", alice.RootDirUUID, "not an image")
+	mustStatus(t, s.thumbnail(alice, notes.UUID, "", nil), http.StatusNotFound)
+	mustStatus(t, s.thumbnail(alice, broken.UUID, "", nil), http.StatusNotFound)
+	mustStatus(t, s.thumbnail(alice, photo.UUID, "huge", nil), http.StatusBadRequest)
+	mustStatus(t, s.thumbnail(s.register("bob"), photo.UUID, "", nil), http.StatusForbidden)
+}
+
+func TestThumbnailer(t *testing.T) {
+	s := newTestServer(t, usecase.Options{Versioning: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		usecase.NewThumbnailer(s.uc, 1).Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	alice := s.register("alice")
+
+	// Thumbnails are made in the background after an upload, and again after a new version.
+	photo := s.upload(alice, "photo.png", alice.RootDirUUID, testImage(t, "png", 300, 300))
+	s.waitForThumbnails(photo.UUID, 1)
+	mustStatus(t, s.putContent(alice, photo.UUID, testImage(t, "png", 300, 150)), http.StatusOK)
+	s.waitForThumbnails(photo.UUID, 2)
+	// The thumbnails of the old version are not cached anymore.
+	for size := range models.ThumbnailSizes {
+		if _, err := s.repo.ReadThumbnailRecord(photo.UUID, 1, size); !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			t.Errorf("ReadThumbnailRecord of the old %s thumbnail returned %v, want not found", size, err)
+		}
+	}
+	rec := s.thumbnail(alice, photo.UUID, models.ThumbnailSizeSmall, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if config, err := png.DecodeConfig(rec.Body); err != nil || config.Width != 128 || config.Height != 64 {
+		t.Errorf("thumbnail of the new version is %+v (%v), want 128x64", config, err)
+	}
+
+	// The thumbnails are removed together with the file.
+	mustStatus(t, s.request(http.MethodDelete, "/fman/file/"+photo.UUID, alice, nil), http.StatusOK)
+	mustStatus(t, s.request(http.MethodDelete, "/fman/trash", alice, nil), http.StatusOK)
+	if n := s.countStoredFiles(); n != 0 {
+		t.Errorf("%d files stored after removing the image, want 0", n)
+	}
+}
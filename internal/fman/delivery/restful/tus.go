@@ -0,0 +1,163 @@
+package restful
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// Headers and values of the tus resumable upload protocol (https://tus.io/protocols/resumable-upload.html).
+const (
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,expiration,termination"
+	tusOffsetContentType = "application/offset+octet-stream"
+
+	headerTusResumable   = "Tus-Resumable"
+	headerTusVersion     = "Tus-Version"
+	headerTusExtension   = "Tus-Extension"
+	headerUploadLength   = "Upload-Length"
+	headerUploadOffset   = "Upload-Offset"
+	headerUploadMetadata = "Upload-Metadata"
+	headerUploadExpires  = "Upload-Expires"
+
+	// headerFileUUID holds the UUID of the created file once an upload is complete.
+	headerFileUUID = "X-File-UUID"
+)
+
+// initTusHandler initializes the endpoints of resumable uploads.
+func initTusHandler(g *echo.Group, handler *FmanHandler) {
+	tg := g.Group("/uploads", tusResumable)
+	tg.OPTIONS("", handler.TusOptions)
+	tg.OPTIONS("/:uuid", handler.TusOptions)
+	tg.POST("", handler.CreateUpload)
+	tg.HEAD("/:uuid", handler.ReadUpload)
+	tg.PATCH("/:uuid", handler.WriteUploadChunk)
+	tg.DELETE("/:uuid", handler.TerminateUpload)
+}
+
+// tusResumable adds the protocol version to every response, and rejects requests other
+// than OPTIONS for another version of the protocol.
+func tusResumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(headerTusResumable, tusVersion)
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get(headerTusResumable) != tusVersion {
+			c.Response().Header().Set(headerTusVersion, tusVersion)
+			return echo.NewHTTPError(http.StatusPreconditionFailed, "unsupported version of the tus protocol")
+		}
+		return next(c)
+	}
+}
+
+// TusOptions returns the protocol version and extensions supported by the server.
+func (h *FmanHandler) TusOptions(c echo.Context) error {
+	c.Response().Header().Set(headerTusVersion, tusVersion)
+	c.Response().Header().Set(headerTusExtension, tusExtensions)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CreateUpload creates a resumable upload. The name of the file and the UUID of its parent
+// directory are taken from the keys filename and parent_uuid of Upload-Metadata. The parent
+// directory defaults to the root directory of the user.
+func (h *FmanHandler) CreateUpload(c echo.Context) error {
+	length, err := strconv.ParseInt(c.Request().Header.Get(headerUploadLength), 10, 64)
+	if err != nil || length < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Length must be a non-negative integer")
+	}
+	rawMetadata := c.Request().Header.Get(headerUploadMetadata)
+	metadata, err := parseUploadMetadata(rawMetadata)
+	if err != nil {
+		return err
+	}
+	user := authRestful.UserFromContext(c)
+	parentUUID := metadata["parent_uuid"]
+	if parentUUID == "" {
+		parentUUID = user.RootDirUUID
+	}
+	upload, err := h.FmanUsecase.CreateUpload(user, metadata["filename"], parentUUID, length, rawMetadata)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	setUploadHeaders(c, upload)
+	c.Response().Header().Set(echo.HeaderLocation, strings.TrimSuffix(c.Request().URL.Path, "/")+"/"+upload.UUID)
+	return c.NoContent(http.StatusCreated)
+}
+
+// ReadUpload returns the state of a resumable upload in headers.
+func (h *FmanHandler) ReadUpload(c echo.Context) error {
+	upload, err := h.FmanUsecase.ReadUpload(authRestful.UserFromContext(c), c.Param("uuid"))
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	setUploadHeaders(c, upload)
+	c.Response().Header().Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		c.Response().Header().Set(headerUploadMetadata, upload.Metadata)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.NoContent(http.StatusOK)
+}
+
+// WriteUploadChunk writes the request body to a resumable upload at Upload-Offset.
+func (h *FmanHandler) WriteUploadChunk(c echo.Context) error {
+	if c.Request().Header.Get(echo.HeaderContentType) != tusOffsetContentType {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, "Content-Type must be "+tusOffsetContentType)
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload-Offset must be a non-negative integer")
+	}
+	upload, err := h.FmanUsecase.WriteUploadChunk(authRestful.UserFromContext(c), c.Param("uuid"), offset, c.Request().Body)
+	if err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TerminateUpload terminates a resumable upload.
+func (h *FmanHandler) TerminateUpload(c echo.Context) error {
+	if err := h.FmanUsecase.TerminateUpload(authRestful.UserFromContext(c), c.Param("uuid")); err != nil {
+		return errUtils.ToHTTPError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// setUploadHeaders sets the offset, the expiration and, once the upload is complete,
+// the UUID of the created file.
+func setUploadHeaders(c echo.Context, upload models.Upload) {
+	header := c.Response().Header()
+	header.Set(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	header.Set(headerUploadExpires, upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	if upload.FileUUID != "" {
+		header.Set(headerFileUUID, upload.FileUUID)
+	}
+}
+
+// parseUploadMetadata parses an Upload-Metadata header, which consists of comma-separated
+// key-value pairs. Keys and values are separated by a space, and values are base64 encoded.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, " ", 2)
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, echo.NewHTTPError(http.StatusBadRequest, "Upload-Metadata values must be base64 encoded")
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
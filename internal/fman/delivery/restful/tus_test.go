@@ -0,0 +1,203 @@
+package restful
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	"github.com/nvthongswansea/xtreme/pkg/file-utils/s3test"
+)
+
+// tusRequest serves a request of the tus protocol with headers and a body.
+func (s *testServer) tusRequest(method, target string, user testUser, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(headerTusResumable, tusVersion)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return s.serve(req, user)
+}
+
+// createUpload creates a resumable upload of a file with a name and a length in the root
+// directory of a user and returns its location.
+func (s *testServer) createUpload(user testUser, filename string, length int) string {
+	s.t.Helper()
+	rec := s.tusRequest(http.MethodPost, "/fman/uploads", user, http.Header{
+		headerUploadLength:   {strconv.Itoa(length)},
+		headerUploadMetadata: {"filename " + base64.StdEncoding.EncodeToString([]byte(filename))},
+	}, "")
+	mustStatus(s.t, rec, http.StatusCreated)
+	return rec.Header().Get(echo.HeaderLocation)
+}
+
+// writeChunk writes a chunk at an offset of a resumable upload.
+func (s *testServer) writeChunk(user testUser, location string, offset int, chunk string) *httptest.ResponseRecorder {
+	return s.tusRequest(http.MethodPatch, location, user, http.Header{
+		echo.HeaderContentType: {tusOffsetContentType},
+		headerUploadOffset:     {strconv.Itoa(offset)},
+	}, chunk)
+}
+
+func TestResumableUpload(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	location := s.createUpload(alice, "big.bin", 10)
+
+	mustStatus(t, s.writeChunk(alice, location, 0, "01234"), http.StatusNoContent)
+	rec := s.tusRequest(http.MethodHead, location, alice, nil, "")
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Header().Get(headerUploadOffset) != "5" || rec.Header().Get(headerUploadLength) != "10" {
+		t.Errorf("HEAD returned offset %s and length %s, want 5 and 10",
+			rec.Header().Get(headerUploadOffset), rec.Header().Get(headerUploadLength))
+	}
+	mustStatus(t, s.writeChunk(alice, location, 3, "34567"), http.StatusConflict)
+	mustStatus(t, s.writeChunk(s.register("bob"), location, 5, "56789"), http.StatusForbidden)
+
+	rec = s.writeChunk(alice, location, 5, "56789")
+	mustStatus(t, rec, http.StatusNoContent)
+	fileUUID := rec.Header().Get(headerFileUUID)
+	if fileUUID == "" {
+		t.Fatal("no file UUID once the upload is complete")
+	}
+	rec = s.download(alice, fileUUID, nil)
+	mustStatus(t, rec, http.StatusOK)
+	if rec.Body.String() != "0123456789" {
+		t.Errorf("uploaded content = %q, want 0123456789", rec.Body.String())
+	}
+	if got := s.filePath(fileUUID); got != "/big.bin" {
+		t.Errorf("path of the uploaded file = %q, want /big.bin", got)
+	}
+}
+
+func TestResumableUploadRejectsInvalidRequests(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	req := httptest.NewRequest(http.MethodPost, "/fman/uploads", nil)
+	req.Header.Set(headerTusResumable, "0.2.2")
+	req.Header.Set(headerUploadLength, "1")
+	mustStatus(t, s.serve(req, alice), http.StatusPreconditionFailed)
+	rec := s.tusRequest(http.MethodPost, "/fman/uploads", alice, http.Header{headerUploadLength: {"-1"}}, "")
+	mustStatus(t, rec, http.StatusBadRequest)
+	rec = s.tusRequest(http.MethodPost, "/fman/uploads", alice, http.Header{
+		headerUploadLength:   {"1"},
+		headerUploadMetadata: {"filename not-base64!"},
+	}, "")
+	mustStatus(t, rec, http.StatusBadRequest)
+
+	location := s.createUpload(alice, "a.txt", 3)
+	rec = s.tusRequest(http.MethodPatch, location, alice, http.Header{headerUploadOffset: {"0"}}, "abc")
+	mustStatus(t, rec, http.StatusUnsupportedMediaType)
+	mustStatus(t, s.writeChunk(alice, location, 0, "abcd"), http.StatusBadRequest)
+	// The name is taken before the upload is complete.
+	s.upload(alice, "a.txt", alice.RootDirUUID, "x")
+	mustStatus(t, s.writeChunk(alice, location, 0, "abc"), http.StatusConflict)
+}
+
+func TestTerminateAndPurgeUploads(t *testing.T) {
+	s := newTestServer(t, usecase.Options{})
+	alice := s.register("alice")
+	terminated := s.createUpload(alice, "a.txt", 10)
+	mustStatus(t, s.writeChunk(alice, terminated, 0, "01234"), http.StatusNoContent)
+	mustStatus(t, s.tusRequest(http.MethodDelete, terminated, alice, nil, ""), http.StatusNoContent)
+	mustStatus(t, s.tusRequest(http.MethodHead, terminated, alice, nil, ""), http.StatusNotFound)
+
+	expired := s.createUpload(alice, "b.txt", 10)
+	mustStatus(t, s.writeChunk(alice, expired, 0, "01234"), http.StatusNoContent)
+	removed, err := s.uc.PurgeExpiredUploads(time.Now().Add(365 * 24 * time.Hour))
+	if err != nil {
+		t.Fatalf("PurgeExpiredUploads failed: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("purged %d uploads, want 1", removed)
+	}
+	mustStatus(t, s.tusRequest(http.MethodHead, expired, alice, nil, ""), http.StatusNotFound)
+	if n := s.countStoredFiles(); n != 0 {
+		t.Errorf("%d files stored after terminating and purging all uploads, want 0", n)
+	}
+}
+
+func TestResumableUploadToS3(t *testing.T) {
+	srv := s3test.NewServer("access-key", "secret-key", "us-east-1")
+	defer srv.Close()
+	fileOps, err := fileUtils.CreateNewS3FileOperator(fileUtils.S3Config{
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "xtreme",
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "secret-key",
+		PathStyle:       true,
+		PartialDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("CreateNewS3FileOperator failed: %s", err)
+	}
+	s := newTestServerWithStorage(t, usecase.Options{}, fileOps)
+	alice := s.register("alice")
+	location := s.createUpload(alice, "big.bin", 10)
+	mustStatus(t, s.writeChunk(alice, location, 0, "01234"), http.StatusNoContent)
+	if srv.ObjectCount() != 0 {
+		t.Errorf("%d objects stored before the upload is complete, want 0", srv.ObjectCount())
+	}
+	rec := s.writeChunk(alice, location, 5, "56789")
+	mustStatus(t, rec, http.StatusNoContent)
+	if srv.ObjectCount() != 1 {
+		t.Errorf("%d objects stored once the upload is complete, want 1", srv.ObjectCount())
+	}
+	rec = s.download(alice, rec.Header().Get(headerFileUUID), nil)
+	if rec.Body.String() != "0123456789" {
+		t.Errorf("uploaded content = %q, want 0123456789", rec.Body.String())
+	}
+}
+
+func TestResumableUploadEncrypted(t *testing.T) {
+	storageDir := t.TempDir()
+	fileOps, err := fileUtils.CreateNewEncryptedFileOperator(fileUtils.CreateNewLocalFileOperator(storageDir),
+		[]byte(strings.Repeat("k", fileUtils.MasterKeySize)))
+	if err != nil {
+		t.Fatalf("CreateNewEncryptedFileOperator failed: %s", err)
+	}
+	s := newTestServerWithStorage(t, usecase.Options{}, fileOps)
+	s.storageDir = storageDir
+	alice := s.register("alice")
+	location := s.createUpload(alice, "secret.txt", 20)
+	mustStatus(t, s.writeChunk(alice, location, 0, "top secret"), http.StatusNoContent)
+
+	// Neither the partial file nor the complete file is stored as plaintext.
+	assertNoPlaintext := func() {
+		t.Helper()
+		err := filepath.Walk(storageDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			b, err := ioutil.ReadFile(path)
+			if err == nil && strings.Contains(string(b), "secret") {
+				t.Errorf("%s holds plaintext", path)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertNoPlaintext()
+	rec := s.writeChunk(alice, location, 10, " documen")
+	mustStatus(t, rec, http.StatusNoContent)
+	assertNoPlaintext()
+	rec = s.writeChunk(alice, location, 18, "ts")
+	mustStatus(t, rec, http.StatusNoContent)
+	assertNoPlaintext()
+	rec = s.download(alice, rec.Header().Get(headerFileUUID), nil)
+	if rec.Body.String() != "top secret documents" {
+		t.Errorf("uploaded content = %q, want top secret documents", rec.Body.String())
+	}
+}
@@ -0,0 +1,288 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// aclEntryColumns are the columns of acl_entries scanned by scanACLEntry.
+const aclEntryColumns = "item_uuid, item_type, principal_type, principal_uuid, permissions, granted_by, updated_at"
+
+// maxInListSize is the maximum number of parameters in a single IN list. Older SQLite
+// versions accept at most 999 parameters per statement.
+const maxInListSize = 500
+
+// InsertGroupRecord inserts a new group record to DB.
+func (r *sqlRepo) InsertGroupRecord(group models.Group) error {
+	_, err := r.db.Exec(r.q("INSERT INTO user_groups (uuid, name, created_at) VALUES (?, ?, ?)"),
+		group.UUID, group.Name, group.CreatedAt.UTC())
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("group %s already exists", group.Name))
+	}
+	return err
+}
+
+// ReadGroupRecord reads a group record together with its members from DB.
+func (r *sqlRepo) ReadGroupRecord(UUID string) (models.Group, error) {
+	groups, err := r.listGroups("uuid = ?", UUID)
+	if err != nil {
+		return models.Group{}, err
+	}
+	if len(groups) == 0 {
+		return models.Group{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", UUID))
+	}
+	return groups[0], nil
+}
+
+// ListGroupRecords lists the group records, or the group records of a member, together with
+// their members from DB, ordered by name.
+func (r *sqlRepo) ListGroupRecords(memberUUID string) ([]models.Group, error) {
+	if memberUUID == "" {
+		return r.listGroups("TRUE")
+	}
+	return r.listGroups("uuid IN (SELECT group_uuid FROM user_group_members WHERE user_uuid = ?)", memberUUID)
+}
+
+// listGroups lists the group records matching a condition together with their members from
+// DB, ordered by name.
+func (r *sqlRepo) listGroups(cond string, args ...interface{}) ([]models.Group, error) {
+	var groups []models.Group
+	err := r.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(r.q("SELECT uuid, name, created_at FROM user_groups WHERE "+cond+" ORDER BY name"), args...)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			group := models.Group{MemberUUIDs: []string{}}
+			if err := rows.Scan(&group.UUID, &group.Name, &group.CreatedAt); err != nil {
+				rows.Close()
+				return err
+			}
+			groups = append(groups, group)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for i := range groups {
+			rows, err := tx.Query(r.q("SELECT user_uuid FROM user_group_members WHERE group_uuid = ? ORDER BY user_uuid"),
+				groups[i].UUID)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var userUUID string
+				if err := rows.Scan(&userUUID); err != nil {
+					rows.Close()
+					return err
+				}
+				groups[i].MemberUUIDs = append(groups[i].MemberUUIDs, userUUID)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// HardRemoveGroupRecord removes a group record together with its members and access control
+// entries from DB.
+func (r *sqlRepo) HardRemoveGroupRecord(UUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		if err := r.lockGroup(tx, UUID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.q("DELETE FROM acl_entries WHERE principal_type = ? AND principal_uuid = ?"),
+			models.PrincipalGroup, UUID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM user_group_members WHERE group_uuid = ?"), UUID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("DELETE FROM user_groups WHERE uuid = ?"), UUID)
+		return err
+	})
+}
+
+// InsertGroupMemberRecord adds a user to a group in DB.
+func (r *sqlRepo) InsertGroupMemberRecord(groupUUID, userUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		if err := r.lockGroup(tx, groupUUID); err != nil {
+			return err
+		}
+		if err := r.checkPrincipalExists(tx, models.PrincipalUser, userUUID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.q("INSERT INTO user_group_members (group_uuid, user_uuid) VALUES (?, ?)"), groupUUID, userUUID)
+		if err != nil && r.dialect.isUniqueViolation(err) {
+			return models.NewFManError(models.AlreadyExistErrorCode,
+				fmt.Sprintf("user %s is already a member of group %s", userUUID, groupUUID))
+		}
+		return err
+	})
+}
+
+// HardRemoveGroupMemberRecord removes a user from a group in DB.
+func (r *sqlRepo) HardRemoveGroupMemberRecord(groupUUID, userUUID string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM user_group_members WHERE group_uuid = ? AND user_uuid = ?"), groupUUID, userUUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("user %s is not a member of group %s", userUUID, groupUUID))
+}
+
+// lockGroup locks a group record until the end of the transaction, so concurrent
+// transactions cannot remove it.
+func (r *sqlRepo) lockGroup(tx *sql.Tx, UUID string) error {
+	var exists bool
+	err := tx.QueryRow(r.q("SELECT TRUE FROM user_groups WHERE uuid = ?"+r.dialect.lockClause), UUID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", UUID))
+	}
+	return err
+}
+
+// checkPrincipalExists returns an error if a user or a group does not exist. Groups are
+// locked until the end of the transaction.
+func (r *sqlRepo) checkPrincipalExists(tx *sql.Tx, principalType, principalUUID string) error {
+	switch principalType {
+	case models.PrincipalGroup:
+		return r.lockGroup(tx, principalUUID)
+	case models.PrincipalUser:
+		var exists bool
+		err := tx.QueryRow(r.q("SELECT EXISTS (SELECT 1 FROM users WHERE uuid = ?)"), principalUUID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", principalUUID))
+		}
+		return nil
+	}
+	return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown principal type %s", principalType))
+}
+
+// UpsertACLRecord inserts or replaces an access control entry in DB.
+func (r *sqlRepo) UpsertACLRecord(entry models.ACLEntry) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		if err := r.checkPrincipalExists(tx, entry.PrincipalType, entry.PrincipalUUID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.q(`INSERT INTO acl_entries (`+aclEntryColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (item_uuid, principal_type, principal_uuid)
+			DO UPDATE SET permissions = excluded.permissions, granted_by = excluded.granted_by, updated_at = excluded.updated_at`),
+			entry.ItemUUID, entry.ItemType, entry.PrincipalType, entry.PrincipalUUID, int(entry.Permissions), entry.GrantedBy,
+			time.Now().UTC())
+		return err
+	})
+}
+
+// HardRemoveACLRecord removes an access control entry from DB.
+func (r *sqlRepo) HardRemoveACLRecord(itemUUID, principalType, principalUUID string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM acl_entries WHERE item_uuid = ? AND principal_type = ? AND principal_uuid = ?"),
+		itemUUID, principalType, principalUUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("%s %s has no access control entry on %s", principalType, principalUUID, itemUUID))
+}
+
+// ListACLRecords lists the access control entries on the given items from DB.
+func (r *sqlRepo) ListACLRecords(itemUUIDs []string) ([]models.ACLEntry, error) {
+	return r.listACLEntriesIn("item_uuid", itemUUIDs)
+}
+
+// ListPrincipalACLRecords lists the access control entries of the given users and groups
+// from DB.
+func (r *sqlRepo) ListPrincipalACLRecords(principalUUIDs []string) ([]models.ACLEntry, error) {
+	return r.listACLEntriesIn("principal_uuid", principalUUIDs)
+}
+
+// listACLEntriesIn lists the access control entries whose column holds one of the given
+// values from DB. Long lists of values are split into several queries.
+func (r *sqlRepo) listACLEntriesIn(column string, values []string) ([]models.ACLEntry, error) {
+	var entries []models.ACLEntry
+	for start := 0; start < len(values); start += maxInListSize {
+		end := start + maxInListSize
+		if end > len(values) {
+			end = len(values)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, value := range values[start:end] {
+			args = append(args, value)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+		batch, err := r.listACLEntries("SELECT "+aclEntryColumns+" FROM acl_entries WHERE "+column+" IN ("+placeholders+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, batch...)
+	}
+	return entries, nil
+}
+
+// ListSubtreeACLRecords lists the access control entries on a directory record and on the
+// records in its subtree from DB.
+func (r *sqlRepo) ListSubtreeACLRecords(dirUUID string) ([]models.ACLEntry, error) {
+	return r.listACLEntries(subtreeCTE+"SELECT "+aclEntryColumns+` FROM acl_entries
+		WHERE item_uuid IN (SELECT uuid FROM subtree)
+		OR item_uuid IN (SELECT uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree))`, dirUUID)
+}
+
+// listACLEntries lists the access control entries selected by a query.
+func (r *sqlRepo) listACLEntries(query string, args ...interface{}) ([]models.ACLEntry, error) {
+	rows, err := r.db.Query(r.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []models.ACLEntry
+	for rows.Next() {
+		var entry models.ACLEntry
+		var permissions int
+		err := rows.Scan(&entry.ItemUUID, &entry.ItemType, &entry.PrincipalType, &entry.PrincipalUUID, &permissions,
+			&entry.GrantedBy, &entry.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Permissions = models.Permissions(permissions)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// ListAncestorDirUUIDs lists the UUIDs of a directory record and its ancestors from DB,
+// nearest first.
+func (r *sqlRepo) ListAncestorDirUUIDs(dirUUID string) ([]string, error) {
+	rows, err := r.db.Query(r.q(`WITH RECURSIVE ancestors(uuid, depth) AS (
+			SELECT CAST(? AS TEXT), 0
+			UNION ALL
+			SELECT d.parent_uuid, a.depth + 1 FROM directories d JOIN ancestors a ON d.uuid = a.uuid
+			WHERE d.parent_uuid IS NOT NULL
+		)
+		SELECT uuid FROM ancestors ORDER BY depth`), dirUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var dirUUIDs []string
+	for rows.Next() {
+		var UUID string
+		if err := rows.Scan(&UUID); err != nil {
+			return nil, err
+		}
+		dirUUIDs = append(dirUUIDs, UUID)
+	}
+	return dirUUIDs, rows.Err()
+}
@@ -0,0 +1,144 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// lockItem returns an error if a file/dir, which is not soft-removed, does not exist. The
+// file/dir is locked until the end of the transaction, so it cannot be removed together
+// with its attributes before the transaction adds attributes to it.
+func (r *sqlRepo) lockItem(tx *sql.Tx, item models.ItemRef) error {
+	table := "files"
+	if item.ItemType == models.EntryTypeDir {
+		table = "directories"
+	}
+	var exists bool
+	err := tx.QueryRow(r.q("SELECT TRUE FROM "+table+" WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), item.UUID).
+		Scan(&exists)
+	if err == sql.ErrNoRows {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s %s does not exist", item.ItemType, item.UUID))
+	}
+	return err
+}
+
+// InsertTagRecords adds a tag to files/dirs in DB, which do not carry it yet.
+func (r *sqlRepo) InsertTagRecords(items []models.ItemRef, tag string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(r.q(`INSERT INTO item_tags (item_uuid, item_type, tag, search_tag) VALUES (?, ?, ?, ?)
+			ON CONFLICT (item_uuid, tag) DO NOTHING`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, item := range items {
+			if err := r.lockItem(tx, item); err != nil {
+				return err
+			}
+			if _, err := stmt.Exec(item.UUID, item.ItemType, tag, searchName(tag)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// HardRemoveTagRecord removes a tag from a file/dir in DB.
+func (r *sqlRepo) HardRemoveTagRecord(itemUUID, tag string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM item_tags WHERE item_uuid = ? AND tag = ?"), itemUUID, tag)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("%s has no tag %s", itemUUID, tag))
+}
+
+// ListTagRecords lists the tags of a file/dir from DB, ordered by tag.
+func (r *sqlRepo) ListTagRecords(itemUUID string) ([]string, error) {
+	rows, err := r.db.Query(r.q("SELECT tag FROM item_tags WHERE item_uuid = ? ORDER BY tag"), itemUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// UpsertMetadataRecord sets a metadata key of a file/dir in DB, replacing the value of an
+// existing key.
+func (r *sqlRepo) UpsertMetadataRecord(item models.ItemRef, entry models.MetadataEntry) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		if err := r.lockItem(tx, item); err != nil {
+			return err
+		}
+		_, err := tx.Exec(r.q(`INSERT INTO item_metadata (item_uuid, item_type, meta_key, meta_value, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (item_uuid, meta_key) DO UPDATE SET meta_value = excluded.meta_value, updated_at = excluded.updated_at`),
+			item.UUID, item.ItemType, entry.Key, entry.Value, entry.UpdatedAt.UTC())
+		return err
+	})
+}
+
+// HardRemoveMetadataRecord removes a metadata key of a file/dir from DB.
+func (r *sqlRepo) HardRemoveMetadataRecord(itemUUID, key string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM item_metadata WHERE item_uuid = ? AND meta_key = ?"), itemUUID, key)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("%s has no metadata key %s", itemUUID, key))
+}
+
+// ListMetadataRecords lists the metadata of a file/dir from DB, ordered by key.
+func (r *sqlRepo) ListMetadataRecords(itemUUID string) ([]models.MetadataEntry, error) {
+	rows, err := r.db.Query(r.q("SELECT meta_key, meta_value, updated_at FROM item_metadata WHERE item_uuid = ? ORDER BY meta_key"),
+		itemUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []models.MetadataEntry
+	for rows.Next() {
+		var entry models.MetadataEntry
+		if err := rows.Scan(&entry.Key, &entry.Value, &entry.UpdatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// CopyAttributeRecords copies the tags and the metadata of a file/dir to another file/dir in
+// DB. Attributes, which the other file/dir already has, are kept.
+func (r *sqlRepo) CopyAttributeRecords(srcUUID, dstUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(r.q(`INSERT INTO item_tags (item_uuid, item_type, tag, search_tag)
+			SELECT CAST(? AS TEXT), item_type, tag, search_tag FROM item_tags WHERE item_uuid = ?
+			ON CONFLICT (item_uuid, tag) DO NOTHING`), dstUUID, srcUUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(`INSERT INTO item_metadata (item_uuid, item_type, meta_key, meta_value, updated_at)
+			SELECT CAST(? AS TEXT), item_type, meta_key, meta_value, updated_at FROM item_metadata WHERE item_uuid = ?
+			ON CONFLICT (item_uuid, meta_key) DO NOTHING`), dstUUID, srcUUID)
+		return err
+	})
+}
+
+// removeAttributes removes the tags and the metadata of files/dirs from DB with a DELETE
+// statement, which has a %s in place of the table, e.g. "DELETE FROM %s WHERE item_uuid = ?".
+func (r *sqlRepo) removeAttributes(tx *sql.Tx, stmt string, args ...interface{}) error {
+	for _, table := range []string{"item_tags", "item_metadata"} {
+		if _, err := tx.Exec(r.q(fmt.Sprintf(stmt, table)), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
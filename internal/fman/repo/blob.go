@@ -0,0 +1,100 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// acquireBlob adds a reference to a blob in DB, and inserts the blob if it does not exist
+// yet. A blob without a storage key must already exist. It returns the referenced blob.
+func (r *sqlRepo) acquireBlob(tx *sql.Tx, blob models.Blob) (models.Blob, error) {
+	if blob.StorageKey == "" {
+		res, err := tx.Exec(r.q("UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = ?"), blob.Hash)
+		if err != nil {
+			return models.Blob{}, err
+		}
+		if err := checkAffected(res, fmt.Sprintf("content %s does not exist", blob.Hash)); err != nil {
+			return models.Blob{}, err
+		}
+	} else {
+		// The upsert also waits for a concurrent release of the same blob, and inserts
+		// it again if the release removed it. A blob stored before MD5 digests were
+		// computed gets the digest of the new copy.
+		_, err := tx.Exec(r.q(`INSERT INTO blobs (hash, md5, storage_key, real_path, size, stored_size, ref_count, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, 1, ?)
+			ON CONFLICT (hash) DO UPDATE SET ref_count = blobs.ref_count + 1,
+				md5 = CASE WHEN blobs.md5 = '' THEN excluded.md5 ELSE blobs.md5 END`),
+			blob.Hash, blob.MD5, blob.StorageKey, blob.RealPath, blob.Size, blob.StoredSize, time.Now().UTC())
+		if err != nil {
+			return models.Blob{}, err
+		}
+	}
+	var stored models.Blob
+	err := tx.QueryRow(r.q("SELECT hash, md5, storage_key, real_path, size, stored_size, created_at FROM blobs WHERE hash = ?"),
+		blob.Hash).Scan(&stored.Hash, &stored.MD5, &stored.StorageKey, &stored.RealPath, &stored.Size, &stored.StoredSize,
+		&stored.CreatedAt)
+	return stored, err
+}
+
+// releaseBlobs removes one reference per occurrence of a hash from the blobs in DB, and
+// removes the blobs which are not referenced anymore together with their search index.
+// It returns the removed blobs.
+func (r *sqlRepo) releaseBlobs(tx *sql.Tx, hashes []string) ([]models.Blob, error) {
+	counts := make(map[string]int64)
+	for _, hash := range hashes {
+		counts[hash]++
+	}
+	// Blobs are locked in the same order by every transaction.
+	sorted := make([]string, 0, len(counts))
+	for hash := range counts {
+		sorted = append(sorted, hash)
+	}
+	sort.Strings(sorted)
+	var removed []models.Blob
+	for _, hash := range sorted {
+		_, err := tx.Exec(r.q("UPDATE blobs SET ref_count = ref_count - ? WHERE hash = ?"), counts[hash], hash)
+		if err != nil {
+			return nil, err
+		}
+		var blob models.Blob
+		err = tx.QueryRow(r.q("SELECT hash, storage_key, real_path, size, created_at FROM blobs WHERE hash = ? AND ref_count <= 0"), hash).
+			Scan(&blob.Hash, &blob.StorageKey, &blob.RealPath, &blob.Size, &blob.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM blobs WHERE hash = ?"), hash); err != nil {
+			return nil, err
+		}
+		if err := r.removeContentIndex(tx, hash); err != nil {
+			return nil, err
+		}
+		removed = append(removed, blob)
+	}
+	return removed, nil
+}
+
+// ListStorageKeys lists the names in the storage of the contents of all blobs and of the
+// images of all thumbnails from DB, ordered by name.
+func (r *sqlRepo) ListStorageKeys() ([]string, error) {
+	rows, err := r.db.Query("SELECT storage_key FROM blobs UNION SELECT storage_key FROM thumbnails ORDER BY 1")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
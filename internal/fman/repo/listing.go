@@ -0,0 +1,377 @@
+package repo
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// Kinds of listing entries. Directories are listed before files.
+const (
+	dirEntryKind  = 0
+	fileEntryKind = 1
+)
+
+// maxDigitRunLen is the maximum length of a digit run which is ordered numerically
+// by naturalSortKey. Longer runs are ordered as if they had this length.
+const maxDigitRunLen = 99
+
+// naturalSortKey returns a key of a name whose bytewise order is the natural,
+// case-insensitive order of names (e.g. a2 < A3 < a10). Each run of digits is
+// stripped of leading zeros and prefixed with its 2-digit length.
+func naturalSortKey(name string) string {
+	var sb strings.Builder
+	runes := []rune(strings.ToLower(name))
+	for i := 0; i < len(runes); {
+		if !unicode.IsDigit(runes[i]) || runes[i] > unicode.MaxASCII {
+			sb.WriteRune(runes[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && runes[j] <= unicode.MaxASCII && unicode.IsDigit(runes[j]) {
+			j++
+		}
+		digits := strings.TrimLeft(string(runes[i:j]), "0")
+		if digits == "" {
+			digits = "0"
+		}
+		runLen := len(digits)
+		if runLen > maxDigitRunLen {
+			runLen = maxDigitRunLen
+		}
+		fmt.Fprintf(&sb, "%02d%s", runLen, digits)
+		i = j
+	}
+	return sb.String()
+}
+
+// listCursor points right after the last entry of a listing page.
+type listCursor struct {
+	SortBy string `json:"s"`
+	Desc   bool   `json:"d"`
+	Kind   int    `json:"k"`
+	Value  string `json:"v"`
+	UUID   string `json:"u"`
+}
+
+// encodeListCursor returns an opaque cursor pointing right after an entry.
+func encodeListCursor(opts models.DirListOptions, kind int, value, UUID string) string {
+	b, _ := json.Marshal(listCursor{
+		SortBy: opts.SortBy,
+		Desc:   opts.Desc,
+		Kind:   kind,
+		Value:  value,
+		UUID:   UUID,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeListCursor decodes a cursor, which must have been returned for the same sort options.
+func decodeListCursor(opts models.DirListOptions) (listCursor, error) {
+	var cursor listCursor
+	invalidErr := models.NewFManError(models.InvalidArgumentErrorCode, "invalid cursor")
+	b, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+	if err != nil {
+		return cursor, invalidErr
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return cursor, invalidErr
+	}
+	if cursor.SortBy != opts.SortBy || cursor.Desc != opts.Desc ||
+		(cursor.Kind != dirEntryKind && cursor.Kind != fileEntryKind) {
+		return cursor, invalidErr
+	}
+	if _, err := cursorSortValue(cursor); err != nil {
+		return cursor, invalidErr
+	}
+	return cursor, nil
+}
+
+// cursorSortValue converts the sort value of a cursor to the type of its sort key.
+func cursorSortValue(cursor listCursor) (interface{}, error) {
+	switch cursor.SortBy {
+	case models.SortBySize:
+		return strconv.ParseInt(cursor.Value, 10, 64)
+	case models.SortByCreatedAt, models.SortByUpdatedAt:
+		t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+		return t.UTC(), err
+	default:
+		return cursor.Value, nil
+	}
+}
+
+// entrySortValue returns the sort value of a listing entry as stored in a cursor.
+func entrySortValue(sortBy, name string, size int64, createdAt, updatedAt time.Time) string {
+	switch sortBy {
+	case models.SortBySize:
+		return strconv.FormatInt(size, 10)
+	case models.SortByCreatedAt:
+		return createdAt.UTC().Format(time.RFC3339Nano)
+	case models.SortByUpdatedAt:
+		return updatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		return naturalSortKey(name)
+	}
+}
+
+// normalizeListOptions fills default values of listing options and validates them.
+func normalizeListOptions(opts models.DirListOptions) (models.DirListOptions, error) {
+	if opts.SortBy == "" {
+		opts.SortBy = models.SortByName
+	}
+	switch opts.SortBy {
+	case models.SortByName, models.SortBySize, models.SortByCreatedAt, models.SortByUpdatedAt:
+	default:
+		return opts, models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("cannot sort by %s", opts.SortBy))
+	}
+	switch opts.Type {
+	case "", models.EntryTypeFile, models.EntryTypeDir:
+	default:
+		return opts, models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown entry type %s", opts.Type))
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = models.DefaultListLimit
+	}
+	if opts.Limit > models.MaxListLimit {
+		opts.Limit = models.MaxListLimit
+	}
+	return opts, nil
+}
+
+// nameKeyRange returns the range [lower, upper) of the sort keys of the names, which start
+// with a prefix, for an index scan. Only the digit run at the end of the prefix may continue
+// in a name, so it is left out of the range. It returns false if the range would hold all
+// keys, and an empty upper bound if the range has no end.
+func nameKeyRange(prefix string) (string, string, bool) {
+	prefix = strings.TrimRightFunc(prefix, func(r rune) bool {
+		return r <= unicode.MaxASCII && unicode.IsDigit(r)
+	})
+	if prefix == "" {
+		return "", "", false
+	}
+	lower := naturalSortKey(prefix)
+	// The upper bound is the lower one with its last rune incremented, as the bytewise order
+	// of UTF-8 is the order of the runes.
+	runes := []rune(lower)
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == unicode.MaxRune {
+			continue
+		}
+		next := runes[i] + 1
+		if next >= 0xD800 && next <= 0xDFFF {
+			// Skip the surrogates, which cannot be encoded.
+			next = 0xE000
+		}
+		return lower, string(append(runes[:i:i], next)), true
+	}
+	return lower, "", true
+}
+
+// listBranch builds the query of one entry kind for sqlRepo.ListDirRecord, which reads a
+// page and one more entry in the order of the listing. It returns false if no entry of the
+// kind can be in the page.
+func listBranch(kind int, opts models.DirListOptions, cursor *listCursor, parentUUID string) (string, []interface{}, bool) {
+	if (kind == dirEntryKind && opts.Type == models.EntryTypeFile) ||
+		(kind == fileEntryKind && opts.Type == models.EntryTypeDir) {
+		return "", nil, false
+	}
+	// All directories come before files.
+	if cursor != nil && cursor.Kind > kind {
+		return "", nil, false
+	}
+	table, nameCol, realPathCol, sizeCol, hashCol, md5Col, typeCol := "directories", "dirname", "''", "0", "''", "''", "''"
+	storedSizeCol := "0"
+	versionCols := "0 AS version, 0 AS max_versions, 0 AS max_version_age"
+	if kind == fileEntryKind {
+		table, nameCol, realPathCol, sizeCol, hashCol, typeCol = "files", "filename", "real_path", "file_size", "content_hash",
+			"content_type"
+		md5Col = "COALESCE((SELECT b.md5 FROM blobs b WHERE b.hash = content_hash), '')"
+		storedSizeCol = "COALESCE((SELECT b.stored_size FROM blobs b WHERE b.hash = content_hash), 0)"
+		versionCols = "version, max_versions, max_version_age"
+	}
+	sortCol := map[string]string{
+		models.SortByName:      "name_key",
+		models.SortBySize:      sizeCol,
+		models.SortByCreatedAt: "created_at",
+		models.SortByUpdatedAt: "updated_at",
+	}[opts.SortBy]
+	query := fmt.Sprintf(`SELECT %d AS kind, uuid, %s AS name, path, %s AS real_path, owner_uuid, %s AS file_size, %s AS stored_size,
+		%s AS content_hash, %s AS content_md5, %s AS content_type, %s, created_at, updated_at, %s AS sort_value FROM %s
+		WHERE parent_uuid = ? AND is_deleted = FALSE`,
+		kind, nameCol, realPathCol, sizeCol, storedSizeCol, hashCol, md5Col, typeCol, versionCols, sortCol, table)
+	args := []interface{}{parentUUID}
+	if opts.NamePrefix != "" {
+		// The range of the sort keys lets the index of the names narrow down the entries,
+		// which are then matched case-sensitively.
+		if lower, upper, ok := nameKeyRange(opts.NamePrefix); ok {
+			query += " AND name_key >= ?"
+			args = append(args, lower)
+			if upper != "" {
+				query += " AND name_key < ?"
+				args = append(args, upper)
+			}
+		}
+		query += fmt.Sprintf(" AND substr(%s, 1, ?) = ?", nameCol)
+		args = append(args, len([]rune(opts.NamePrefix)), opts.NamePrefix)
+	}
+	op, order := ">", "ASC"
+	if opts.Desc {
+		op, order = "<", "DESC"
+	}
+	if cursor != nil && cursor.Kind == kind {
+		value, _ := cursorSortValue(*cursor)
+		query += fmt.Sprintf(" AND (%s %s ? OR (%s = ? AND uuid %s ?))", sortCol, op, sortCol, op)
+		args = append(args, value, value, cursor.UUID)
+	}
+	// Each branch is limited on its own, so the page is read from the index of the sort key
+	// instead of sorting all children. The branch is wrapped, as neither SQLite nor PostgreSQL
+	// take ORDER BY and LIMIT in a part of UNION ALL as such.
+	query = fmt.Sprintf("SELECT * FROM (%s ORDER BY sort_value %s, uuid %s LIMIT ?) %s_entries", query, order, order, table)
+	args = append(args, opts.Limit+1)
+	return query, args, true
+}
+
+// ListDirRecord reads a directory record, which is not soft-removed, from DB together
+// with a page of its children. It returns the cursor of the next page, which is
+// empty if this is the last page.
+func (r *sqlRepo) ListDirRecord(UUID string, opts models.DirListOptions) (models.Directory, string, error) {
+	opts, err := normalizeListOptions(opts)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	var cursor *listCursor
+	if opts.Cursor != "" {
+		c, err := decodeListCursor(opts)
+		if err != nil {
+			return models.Directory{}, "", err
+		}
+		cursor = &c
+	}
+	dir, err := r.ReadDirRecord(UUID)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	var branches []string
+	var args []interface{}
+	for _, kind := range []int{dirEntryKind, fileEntryKind} {
+		branch, branchArgs, ok := listBranch(kind, opts, cursor, UUID)
+		if ok {
+			branches = append(branches, branch)
+			args = append(args, branchArgs...)
+		}
+	}
+	if len(branches) == 0 {
+		return dir, "", nil
+	}
+	order := "ASC"
+	if opts.Desc {
+		order = "DESC"
+	}
+	query := fmt.Sprintf(`SELECT kind, uuid, name, path, real_path, owner_uuid, file_size, stored_size, content_hash, content_md5,
+		content_type, version, max_versions, max_version_age, created_at, updated_at FROM (%s) entries ORDER BY kind ASC, sort_value %s, uuid %s LIMIT ?`,
+		strings.Join(branches, " UNION ALL "), order, order)
+	// Fetch one more entry to know if there is a next page.
+	args = append(args, opts.Limit+1)
+	rows, err := r.db.Query(r.q(query), args...)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	defer rows.Close()
+	var nextCursor, lastCursor string
+	count := 0
+	for rows.Next() {
+		if count == opts.Limit {
+			nextCursor = lastCursor
+			break
+		}
+		var kind int
+		var entryUUID, name, entryPath, realPath, ownerUUID, contentHash, contentMD5, contentType string
+		var size, storedSize, maxVersionAge int64
+		var version, maxVersions int
+		var createdAt, updatedAt time.Time
+		err := rows.Scan(&kind, &entryUUID, &name, &entryPath, &realPath, &ownerUUID, &size, &storedSize, &contentHash, &contentMD5,
+			&contentType, &version, &maxVersions, &maxVersionAge, &createdAt, &updatedAt)
+		if err != nil {
+			return models.Directory{}, "", err
+		}
+		count++
+		lastCursor = encodeListCursor(opts, kind, entrySortValue(opts.SortBy, name, size, createdAt, updatedAt), entryUUID)
+		if kind == dirEntryKind {
+			dir.ListOfDirs = append(dir.ListOfDirs, models.Directory{
+				UUID:       entryUUID,
+				Dirname:    name,
+				Path:       entryPath,
+				ParentUUID: UUID,
+				OwnerUUID:  ownerUUID,
+				CreatedAt:  createdAt,
+				UpdatedAt:  updatedAt,
+			})
+		} else {
+			dir.ListOfFiles = append(dir.ListOfFiles, models.File{
+				UUID:        entryUUID,
+				Filename:    name,
+				Path:        entryPath,
+				RealPath:    realPath,
+				ParentUUID:  UUID,
+				OwnerUUID:   ownerUUID,
+				FileSize:    uint64(size),
+				StoredSize:  uint64(storedSize),
+				ContentHash: contentHash,
+				ContentMD5:  contentMD5,
+				ContentType: contentType,
+				Version:     version,
+				VersionPolicy: models.VersionPolicy{
+					MaxVersions: maxVersions,
+					MaxAge:      time.Duration(maxVersionAge) * time.Second,
+				},
+				CreatedAt: createdAt,
+				UpdatedAt: updatedAt,
+			})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return models.Directory{}, "", err
+	}
+	return dir, nextCursor, nil
+}
+
+// backfillNameKeys fills the name_key column of existing files and directories.
+func backfillNameKeys(tx *sql.Tx, d dialect) error {
+	for _, table := range []struct{ name, nameCol string }{
+		{"directories", "dirname"},
+		{"files", "filename"},
+	} {
+		rows, err := tx.Query(fmt.Sprintf("SELECT uuid, %s FROM %s", table.nameCol, table.name))
+		if err != nil {
+			return err
+		}
+		keys := make(map[string]string)
+		for rows.Next() {
+			var entryUUID, name string
+			if err := rows.Scan(&entryUUID, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			keys[entryUUID] = naturalSortKey(name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for entryUUID, key := range keys {
+			_, err := tx.Exec(rebind(d, fmt.Sprintf("UPDATE %s SET name_key = ? WHERE uuid = ?", table.name)), key, entryUUID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
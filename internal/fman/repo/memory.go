@@ -0,0 +1,2102 @@
+package repo
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// fileRecord holds a file record stored in memory together with its versions, oldest first,
+// and the thumbnails of its versions.
+type fileRecord struct {
+	file       models.File
+	versions   []models.FileVersion
+	thumbnails []models.Thumbnail
+	isDeleted  bool
+	trashUUID  string
+}
+
+// dirRecord holds a directory record stored in memory.
+type dirRecord struct {
+	dir        models.Directory
+	isDeleted  bool
+	trashUUID  string
+	quotaBytes int64
+}
+
+// blobRecord holds a blob stored in memory together with the number of file versions
+// referencing it.
+type blobRecord struct {
+	blob     models.Blob
+	refCount int
+}
+
+// searchContent holds the index of the text of a content stored in memory.
+type searchContent struct {
+	words       string
+	frequencies map[string]int
+}
+
+// itemAttributes holds the tags and the metadata of a file/dir stored in memory.
+type itemAttributes struct {
+	itemType string
+	tags     map[string]bool
+	metadata map[string]models.MetadataEntry
+}
+
+// aclKey identifies an access control entry stored in memory.
+type aclKey struct {
+	itemUUID      string
+	principalType string
+	principalUUID string
+}
+
+// FManMemoryRepo provides file manager repositories stored in memory. It is safe
+// for concurrent use and follows the same semantics as FManSQLiteRepo, but nothing
+// survives a restart.
+type FManMemoryRepo struct {
+	mu       sync.RWMutex
+	files    map[string]*fileRecord
+	dirs     map[string]*dirRecord
+	trash    map[string]*models.TrashEntry
+	uploads  map[string]*models.Upload
+	blobs    map[string]*blobRecord
+	users    map[string]*models.User
+	quotas   map[string]*models.Quota
+	reserved map[string]*models.QuotaReservation
+	tokens   map[string]*models.RefreshToken
+	shares   map[string]*models.ShareLink
+	groups   map[string]*models.Group
+	acl      map[aclKey]*models.ACLEntry
+	search   map[string]*searchContent
+	attrs    map[string]*itemAttributes
+}
+
+// NewFManMemoryRepo returns a new FManMemoryRepo containing only the root directory.
+func NewFManMemoryRepo() *FManMemoryRepo {
+	now := time.Now().UTC()
+	return &FManMemoryRepo{
+		files:    make(map[string]*fileRecord),
+		trash:    make(map[string]*models.TrashEntry),
+		uploads:  make(map[string]*models.Upload),
+		blobs:    make(map[string]*blobRecord),
+		users:    make(map[string]*models.User),
+		quotas:   make(map[string]*models.Quota),
+		reserved: make(map[string]*models.QuotaReservation),
+		tokens:   make(map[string]*models.RefreshToken),
+		shares:   make(map[string]*models.ShareLink),
+		groups:   make(map[string]*models.Group),
+		acl:      make(map[aclKey]*models.ACLEntry),
+		search:   make(map[string]*searchContent),
+		attrs:    make(map[string]*itemAttributes),
+		dirs: map[string]*dirRecord{
+			models.RootDirUUID: {
+				dir: models.Directory{
+					UUID:      models.RootDirUUID,
+					Path:      models.RootDirPath,
+					CreatedAt: now,
+					UpdatedAt: now,
+				},
+			},
+		},
+	}
+}
+
+// InsertFileRecord inserts a new file record to memory together with its first version, adds
+// a reference to the blob of its content and counts its size as used by the owner.
+func (m *FManMemoryRepo) InsertFileRecord(UUID, filename, parentUUID, ownerUUID, contentType string, blob models.Blob) (models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[UUID]; ok {
+		return models.Blob{}, models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("file %s already exists", UUID))
+	}
+	parent, err := m.readParent(parentUUID)
+	if err != nil {
+		return models.Blob{}, err
+	}
+	if err := m.checkNameAvailable(filename, parentUUID, ""); err != nil {
+		return models.Blob{}, err
+	}
+	stored, err := m.acquireBlob(blob)
+	if err != nil {
+		return models.Blob{}, err
+	}
+	now := time.Now().UTC()
+	m.files[UUID] = &fileRecord{
+		file: models.File{
+			UUID:        UUID,
+			Filename:    filename,
+			Path:        path.Join(parent.Path, filename),
+			RealPath:    stored.RealPath,
+			ParentUUID:  parentUUID,
+			OwnerUUID:   ownerUUID,
+			FileSize:    uint64(stored.Size),
+			StoredSize:  uint64(stored.StoredSize),
+			ContentHash: stored.Hash,
+			ContentMD5:  stored.MD5,
+			ContentType: contentType,
+			StorageKey:  stored.StorageKey,
+			Version:     1,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		versions: []models.FileVersion{{
+			FileUUID:    UUID,
+			Version:     1,
+			FileSize:    uint64(stored.Size),
+			ContentHash: stored.Hash,
+			ContentType: contentType,
+			UploadedBy:  ownerUUID,
+			CreatedAt:   now,
+		}},
+	}
+	m.addUsage(ownerUUID, stored.Size)
+	return stored, nil
+}
+
+// ReadFileRecord reads a file record, which is not soft-removed, from memory.
+func (m *FManMemoryRepo) ReadFileRecord(UUID string) (models.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.files[UUID]
+	if !ok || record.isDeleted {
+		return models.File{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+	}
+	return record.file, nil
+}
+
+// ReadFileRecordByName reads a file record, which is not soft-removed, with a given name
+// in a parent directory from memory.
+func (m *FManMemoryRepo) ReadFileRecordByName(filename, parentUUID string) (models.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, record := range m.files {
+		if !record.isDeleted && record.file.ParentUUID == parentUUID && record.file.Filename == filename {
+			return record.file, nil
+		}
+	}
+	return models.File{}, models.NewFManError(models.NotFoundErrorCode,
+		fmt.Sprintf("file %s does not exist in the desired location", filename))
+}
+
+// UpdateFileRecord renames and/or moves a file record in memory.
+func (m *FManMemoryRepo) UpdateFileRecord(UUID, filename, parentUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[UUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+	}
+	parent, err := m.readParent(parentUUID)
+	if err != nil {
+		return err
+	}
+	if err := m.checkNameAvailable(filename, parentUUID, UUID); err != nil {
+		return err
+	}
+	record.file.Filename = filename
+	record.file.ParentUUID = parentUUID
+	record.file.Path = path.Join(parent.Path, filename)
+	record.file.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// SoftRemoveFileRecord flags a file record as deleted in memory and records it in the recycle bin.
+func (m *FManMemoryRepo) SoftRemoveFileRecord(UUID, trashUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[UUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+	}
+	if _, ok := m.trash[trashUUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("recycle bin entry %s already exists", trashUUID))
+	}
+	now := time.Now().UTC()
+	m.trash[trashUUID] = &models.TrashEntry{
+		UUID:               trashUUID,
+		ItemUUID:           UUID,
+		ItemType:           models.EntryTypeFile,
+		Name:               record.file.Filename,
+		OriginalParentUUID: record.file.ParentUUID,
+		OriginalPath:       record.file.Path,
+		OwnerUUID:          record.file.OwnerUUID,
+		DeletedAt:          now,
+	}
+	record.isDeleted = true
+	record.trashUUID = trashUUID
+	record.file.UpdatedAt = now
+	return nil
+}
+
+// HardRemoveFileRecord removes a file record, either soft-removed or not, together with its
+// versions from memory, and releases their references to the blobs of their content.
+func (m *FManMemoryRepo) HardRemoveFileRecord(UUID string) ([]models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[UUID]
+	if !ok {
+		return nil, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+	}
+	delete(m.files, UUID)
+	m.removeACLEntries(UUID)
+	delete(m.attrs, UUID)
+	m.addUsage(record.file.OwnerUUID, -record.versionsSize())
+	return append(m.releaseBlobs(record.versionHashes()), record.removeThumbnails(0)...), nil
+}
+
+// InsertDirRecord inserts a new directory record to memory.
+func (m *FManMemoryRepo) InsertDirRecord(UUID, dirname, parentUUID, ownerUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.dirs[UUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("directory %s already exists", UUID))
+	}
+	parent, err := m.readParent(parentUUID)
+	if err != nil {
+		return err
+	}
+	if err := m.checkNameAvailable(dirname, parentUUID, ""); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	m.dirs[UUID] = &dirRecord{
+		dir: models.Directory{
+			UUID:       UUID,
+			Dirname:    dirname,
+			Path:       path.Join(parent.Path, dirname),
+			ParentUUID: parentUUID,
+			OwnerUUID:  ownerUUID,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+	}
+	return nil
+}
+
+// ReadDirRecord reads a directory record, which is not soft-removed, from memory.
+func (m *FManMemoryRepo) ReadDirRecord(UUID string) (models.Directory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.dirs[UUID]
+	if !ok || record.isDeleted {
+		return models.Directory{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+	}
+	return record.dir, nil
+}
+
+// ReadDirRecordByName reads a directory record, which is not soft-removed, with a given name
+// in a parent directory from memory.
+func (m *FManMemoryRepo) ReadDirRecordByName(dirname, parentUUID string) (models.Directory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, record := range m.dirs {
+		if !record.isDeleted && record.dir.ParentUUID == parentUUID && record.dir.Dirname == dirname {
+			return record.dir, nil
+		}
+	}
+	return models.Directory{}, models.NewFManError(models.NotFoundErrorCode,
+		fmt.Sprintf("directory %s does not exist in the desired location", dirname))
+}
+
+// UpdateDirRecord renames and/or moves a directory record in memory, and rewrites
+// the paths of all its descendants.
+func (m *FManMemoryRepo) UpdateDirRecord(UUID, dirname, parentUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dirs[UUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+	}
+	if record.dir.ParentUUID == "" {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be updated")
+	}
+	parent, err := m.readParent(parentUUID)
+	if err != nil {
+		return err
+	}
+	if m.isDescendant(parentUUID, UUID) {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "a directory cannot be moved into itself or its descendants")
+	}
+	if err := m.checkNameAvailable(dirname, parentUUID, UUID); err != nil {
+		return err
+	}
+	oldPath := record.dir.Path
+	newPath := path.Join(parent.Path, dirname)
+	record.dir.Dirname = dirname
+	record.dir.ParentUUID = parentUUID
+	record.dir.Path = newPath
+	record.dir.UpdatedAt = time.Now().UTC()
+	m.rewriteSubtreePaths(UUID, oldPath, newPath)
+	return nil
+}
+
+// SoftRemoveDirRecord flags a directory record and all its descendants as deleted in memory,
+// and records the subtree in the recycle bin.
+func (m *FManMemoryRepo) SoftRemoveDirRecord(UUID, trashUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dirs[UUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+	}
+	if record.dir.ParentUUID == "" {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+	}
+	if _, ok := m.trash[trashUUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("recycle bin entry %s already exists", trashUUID))
+	}
+	now := time.Now().UTC()
+	m.trash[trashUUID] = &models.TrashEntry{
+		UUID:               trashUUID,
+		ItemUUID:           UUID,
+		ItemType:           models.EntryTypeDir,
+		Name:               record.dir.Dirname,
+		OriginalParentUUID: record.dir.ParentUUID,
+		OriginalPath:       record.dir.Path,
+		OwnerUUID:          record.dir.OwnerUUID,
+		DeletedAt:          now,
+	}
+	// Descendants which are already in the recycle bin keep their own entries.
+	for _, child := range m.files {
+		if !child.isDeleted && m.isDescendant(child.file.ParentUUID, UUID) {
+			child.isDeleted = true
+			child.trashUUID = trashUUID
+		}
+	}
+	for _, child := range m.dirs {
+		if !child.isDeleted && m.isDescendant(child.dir.UUID, UUID) {
+			child.isDeleted = true
+			child.trashUUID = trashUUID
+		}
+	}
+	record.dir.UpdatedAt = now
+	return nil
+}
+
+// HardRemoveDirRecord removes an empty directory record, either soft-removed or not, from memory.
+func (m *FManMemoryRepo) HardRemoveDirRecord(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dirs[UUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+	}
+	if record.dir.ParentUUID == "" {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+	}
+	for _, child := range m.files {
+		if child.file.ParentUUID == UUID {
+			return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("directory %s is not empty", UUID))
+		}
+	}
+	for _, child := range m.dirs {
+		if child.dir.ParentUUID == UUID {
+			return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("directory %s is not empty", UUID))
+		}
+	}
+	delete(m.dirs, UUID)
+	m.removeACLEntries(UUID)
+	delete(m.attrs, UUID)
+	return nil
+}
+
+// IsNameExist checks if a file/dir, which is not soft-removed, with a given name exists
+// in a parent directory.
+func (m *FManMemoryRepo) IsNameExist(filename, parentUUID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.checkNameAvailable(filename, parentUUID, "") != nil, nil
+}
+
+// IsParentUUIDExist checks if a directory, which is not soft-removed, exists with a given UUID.
+func (m *FManMemoryRepo) IsParentUUIDExist(parentUUID string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.dirs[parentUUID]
+	return ok && !record.isDeleted, nil
+}
+
+// readParent returns a parent directory, which is not soft-removed.
+// The caller must hold m.mu.
+func (m *FManMemoryRepo) readParent(parentUUID string) (models.Directory, error) {
+	record, ok := m.dirs[parentUUID]
+	if !ok || record.isDeleted {
+		return models.Directory{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("parent UUID (%s) does not exist", parentUUID))
+	}
+	return record.dir, nil
+}
+
+// checkNameAvailable returns an error if a file/dir other than exceptUUID already
+// takes a name in a parent directory. The caller must hold m.mu.
+func (m *FManMemoryRepo) checkNameAvailable(name, parentUUID, exceptUUID string) error {
+	for _, record := range m.files {
+		if !record.isDeleted && record.file.ParentUUID == parentUUID && record.file.Filename == name &&
+			record.file.UUID != exceptUUID {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", name))
+		}
+	}
+	for _, record := range m.dirs {
+		if !record.isDeleted && record.dir.ParentUUID == parentUUID && record.dir.Dirname == name &&
+			record.dir.UUID != exceptUUID {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", name))
+		}
+	}
+	return nil
+}
+
+// isDescendant checks if a directory is ancestorUUID itself or lies beneath it.
+// The caller must hold m.mu.
+func (m *FManMemoryRepo) isDescendant(dirUUID, ancestorUUID string) bool {
+	for dirUUID != "" {
+		if dirUUID == ancestorUUID {
+			return true
+		}
+		record, ok := m.dirs[dirUUID]
+		if !ok {
+			return false
+		}
+		dirUUID = record.dir.ParentUUID
+	}
+	return false
+}
+
+// rewriteSubtreePaths replaces the old path prefix of every descendant of a directory,
+// either soft-removed or not, with the new one. The caller must hold m.mu.
+func (m *FManMemoryRepo) rewriteSubtreePaths(dirUUID, oldPath, newPath string) {
+	for _, child := range m.dirs {
+		if child.dir.UUID != dirUUID && m.isDescendant(child.dir.ParentUUID, dirUUID) {
+			child.dir.Path = newPath + strings.TrimPrefix(child.dir.Path, oldPath)
+		}
+	}
+	for _, child := range m.files {
+		if m.isDescendant(child.file.ParentUUID, dirUUID) {
+			child.file.Path = newPath + strings.TrimPrefix(child.file.Path, oldPath)
+		}
+	}
+}
+
+// ReadTrashRecord reads a recycle bin entry from memory.
+func (m *FManMemoryRepo) ReadTrashRecord(UUID string) (models.TrashEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.trash[UUID]
+	if !ok {
+		return models.TrashEntry{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("recycle bin entry %s does not exist", UUID))
+	}
+	return *entry, nil
+}
+
+// ListTrashRecords lists the recycle bin entries of an owner, which were deleted before a given
+// time, from memory. An empty owner lists the entries of all owners, a zero time lists all entries.
+func (m *FManMemoryRepo) ListTrashRecords(ownerUUID string, deletedBefore time.Time) ([]models.TrashEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []models.TrashEntry
+	for _, entry := range m.trash {
+		if ownerUUID != "" && entry.OwnerUUID != ownerUUID {
+			continue
+		}
+		if deletedBefore.IsZero() || entry.DeletedAt.Before(deletedBefore) {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if c := compareTimes(entries[i].DeletedAt, entries[j].DeletedAt); c != 0 {
+			return c > 0
+		}
+		return entries[i].UUID < entries[j].UUID
+	})
+	return entries, nil
+}
+
+// RestoreTrashRecord restores the file/dir of a recycle bin entry with a name into a parent
+// directory in memory, rewrites the paths of restored descendants, and removes the entry.
+func (m *FManMemoryRepo) RestoreTrashRecord(UUID, name, parentUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.trash[UUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("recycle bin entry %s does not exist", UUID))
+	}
+	parent, err := m.readParent(parentUUID)
+	if err != nil {
+		return err
+	}
+	if err := m.checkNameAvailable(name, parentUUID, ""); err != nil {
+		return err
+	}
+	newPath := path.Join(parent.Path, name)
+	now := time.Now().UTC()
+	if entry.ItemType == models.EntryTypeFile {
+		record, ok := m.files[entry.ItemUUID]
+		if !ok || record.trashUUID != UUID {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", entry.ItemUUID))
+		}
+		record.isDeleted = false
+		record.trashUUID = ""
+		record.file.Filename = name
+		record.file.ParentUUID = parentUUID
+		record.file.Path = newPath
+		record.file.UpdatedAt = now
+	} else {
+		record, ok := m.dirs[entry.ItemUUID]
+		if !ok || record.trashUUID != UUID {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", entry.ItemUUID))
+		}
+		if m.isDescendant(parentUUID, entry.ItemUUID) {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "a directory cannot be moved into itself or its descendants")
+		}
+		for _, child := range m.files {
+			if child.trashUUID == UUID && m.isDescendant(child.file.ParentUUID, entry.ItemUUID) {
+				child.isDeleted = false
+				child.trashUUID = ""
+			}
+		}
+		for _, child := range m.dirs {
+			if child.trashUUID == UUID && m.isDescendant(child.dir.UUID, entry.ItemUUID) {
+				child.isDeleted = false
+				child.trashUUID = ""
+			}
+		}
+		oldPath := record.dir.Path
+		record.dir.Dirname = name
+		record.dir.ParentUUID = parentUUID
+		record.dir.Path = newPath
+		record.dir.UpdatedAt = now
+		m.rewriteSubtreePaths(entry.ItemUUID, oldPath, newPath)
+	}
+	delete(m.trash, UUID)
+	return nil
+}
+
+// HardRemoveTrashRecord removes a recycle bin entry together with the records of its file/dir
+// and all descendants from memory. It returns the blobs which are not referenced anymore.
+func (m *FManMemoryRepo) HardRemoveTrashRecord(UUID string) ([]models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.trash[UUID]
+	if !ok {
+		return nil, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("recycle bin entry %s does not exist", UUID))
+	}
+	delete(m.trash, UUID)
+	if entry.ItemType == models.EntryTypeFile {
+		record, ok := m.files[entry.ItemUUID]
+		if !ok || record.trashUUID != UUID {
+			return nil, nil
+		}
+		delete(m.files, entry.ItemUUID)
+		m.removeACLEntries(entry.ItemUUID)
+		delete(m.attrs, entry.ItemUUID)
+		m.addUsage(record.file.OwnerUUID, -record.versionsSize())
+		return append(m.releaseBlobs(record.versionHashes()), record.removeThumbnails(0)...), nil
+	}
+	// Entries of descendants which were moved to the recycle bin on their own go
+	// together with the subtree.
+	var fileUUIDs, contentHashes []string
+	var images []models.Blob
+	for fileUUID, child := range m.files {
+		if m.isDescendant(child.file.ParentUUID, entry.ItemUUID) {
+			delete(m.trash, child.trashUUID)
+			fileUUIDs = append(fileUUIDs, fileUUID)
+			contentHashes = append(contentHashes, child.versionHashes()...)
+			images = append(images, child.removeThumbnails(0)...)
+			m.addUsage(child.file.OwnerUUID, -child.versionsSize())
+		}
+	}
+	for _, fileUUID := range fileUUIDs {
+		delete(m.files, fileUUID)
+		m.removeACLEntries(fileUUID)
+		delete(m.attrs, fileUUID)
+	}
+	var dirUUIDs []string
+	for dirUUID, child := range m.dirs {
+		if m.isDescendant(dirUUID, entry.ItemUUID) {
+			delete(m.trash, child.trashUUID)
+			dirUUIDs = append(dirUUIDs, dirUUID)
+		}
+	}
+	for _, dirUUID := range dirUUIDs {
+		delete(m.dirs, dirUUID)
+		m.removeACLEntries(dirUUID)
+		delete(m.attrs, dirUUID)
+	}
+	return append(m.releaseBlobs(contentHashes), images...), nil
+}
+
+// acquireBlob adds a reference to a blob in memory, and inserts the blob if it does not
+// exist yet. A blob without a storage key must already exist. It returns the referenced blob.
+// The caller must hold the write lock.
+func (m *FManMemoryRepo) acquireBlob(blob models.Blob) (models.Blob, error) {
+	record, ok := m.blobs[blob.Hash]
+	if !ok {
+		if blob.StorageKey == "" {
+			return models.Blob{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("content %s does not exist", blob.Hash))
+		}
+		blob.CreatedAt = time.Now().UTC()
+		record = &blobRecord{blob: blob}
+		m.blobs[blob.Hash] = record
+	}
+	if record.blob.MD5 == "" {
+		// The blob was stored before MD5 digests were computed.
+		record.blob.MD5 = blob.MD5
+	}
+	record.refCount++
+	return record.blob, nil
+}
+
+// ListStorageKeys lists the names in the storage of the contents of all blobs and of the
+// images of all thumbnails from memory, ordered by name.
+func (m *FManMemoryRepo) ListStorageKeys() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []string
+	for _, record := range m.blobs {
+		keys = append(keys, record.blob.StorageKey)
+	}
+	for _, record := range m.files {
+		for _, t := range record.thumbnails {
+			keys = append(keys, t.StorageKey)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// releaseBlobs removes one reference per occurrence of a hash from the blobs in memory, and
+// removes the blobs which are not referenced anymore together with their search index. It
+// returns the removed blobs, ordered by their hashes. The caller must hold the write lock.
+func (m *FManMemoryRepo) releaseBlobs(hashes []string) []models.Blob {
+	var removed []models.Blob
+	for _, hash := range hashes {
+		record, ok := m.blobs[hash]
+		if !ok {
+			continue
+		}
+		record.refCount--
+		if record.refCount <= 0 {
+			delete(m.blobs, hash)
+			delete(m.search, hash)
+			removed = append(removed, record.blob)
+		}
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].Hash < removed[j].Hash })
+	return removed
+}
+
+// InsertUploadRecord inserts a new resumable upload record to memory.
+func (m *FManMemoryRepo) InsertUploadRecord(upload models.Upload) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.uploads[upload.UUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("upload %s already exists", upload.UUID))
+	}
+	upload.CreatedAt = upload.CreatedAt.UTC()
+	upload.ExpiresAt = upload.ExpiresAt.UTC()
+	m.uploads[upload.UUID] = &upload
+	return nil
+}
+
+// ReadUploadRecord reads a resumable upload record from memory.
+func (m *FManMemoryRepo) ReadUploadRecord(UUID string) (models.Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upload, ok := m.uploads[UUID]
+	if !ok {
+		return models.Upload{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("upload %s does not exist", UUID))
+	}
+	return *upload, nil
+}
+
+// UpdateUploadOffset sets the number of received bytes of an incomplete upload in memory, if
+// it is still at oldOffset.
+func (m *FManMemoryRepo) UpdateUploadOffset(UUID string, oldOffset, offset int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[UUID]
+	if !ok || upload.FileUUID != "" {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("incomplete upload %s does not exist", UUID))
+	}
+	if upload.Offset != oldOffset {
+		return models.NewFManError(models.ConflictErrorCode, fmt.Sprintf("upload %s is at offset %d", UUID, upload.Offset))
+	}
+	upload.Offset = offset
+	return nil
+}
+
+// CompleteUploadRecord marks an incomplete upload as complete in memory.
+func (m *FManMemoryRepo) CompleteUploadRecord(UUID, fileUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[UUID]
+	if !ok || upload.FileUUID != "" {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("incomplete upload %s does not exist", UUID))
+	}
+	upload.FileUUID = fileUUID
+	return nil
+}
+
+// HardRemoveUploadRecord removes a resumable upload record from memory.
+func (m *FManMemoryRepo) HardRemoveUploadRecord(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.uploads[UUID]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("upload %s does not exist", UUID))
+	}
+	delete(m.uploads, UUID)
+	return nil
+}
+
+// ListExpiredUploadRecords lists the resumable upload records, which expired before a given time, from memory.
+func (m *FManMemoryRepo) ListExpiredUploadRecords(before time.Time) ([]models.Upload, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var uploads []models.Upload
+	for _, upload := range m.uploads {
+		if upload.ExpiresAt.Before(before) {
+			uploads = append(uploads, *upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if c := compareTimes(uploads[i].ExpiresAt, uploads[j].ExpiresAt); c != 0 {
+			return c < 0
+		}
+		return uploads[i].UUID < uploads[j].UUID
+	})
+	return uploads, nil
+}
+
+// memListEntry holds a child of a directory in a listing of FManMemoryRepo.
+type memListEntry struct {
+	kind      int
+	uuid      string
+	nameKey   string
+	size      int64
+	createdAt time.Time
+	updatedAt time.Time
+	file      models.File
+	dir       models.Directory
+}
+
+// compareListEntries compares two listing entries by kind, then a sort key, then UUID.
+func compareListEntries(a, b memListEntry, opts models.DirListOptions) int {
+	if a.kind != b.kind {
+		return a.kind - b.kind
+	}
+	c := 0
+	switch opts.SortBy {
+	case models.SortBySize:
+		if a.size < b.size {
+			c = -1
+		} else if a.size > b.size {
+			c = 1
+		}
+	case models.SortByCreatedAt:
+		c = compareTimes(a.createdAt, b.createdAt)
+	case models.SortByUpdatedAt:
+		c = compareTimes(a.updatedAt, b.updatedAt)
+	default:
+		c = strings.Compare(a.nameKey, b.nameKey)
+	}
+	if c == 0 {
+		c = strings.Compare(a.uuid, b.uuid)
+	}
+	if opts.Desc {
+		c = -c
+	}
+	return c
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ListDirRecord reads a directory record, which is not soft-removed, from memory together
+// with a page of its children. It returns the cursor of the next page, which is
+// empty if this is the last page.
+func (m *FManMemoryRepo) ListDirRecord(UUID string, opts models.DirListOptions) (models.Directory, string, error) {
+	opts, err := normalizeListOptions(opts)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	var cursor *memListEntry
+	if opts.Cursor != "" {
+		c, err := decodeListCursor(opts)
+		if err != nil {
+			return models.Directory{}, "", err
+		}
+		value, _ := cursorSortValue(c)
+		cursor = &memListEntry{kind: c.Kind, uuid: c.UUID}
+		switch v := value.(type) {
+		case int64:
+			cursor.size = v
+		case time.Time:
+			cursor.createdAt, cursor.updatedAt = v, v
+		case string:
+			cursor.nameKey = v
+		}
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.dirs[UUID]
+	if !ok || record.isDeleted {
+		return models.Directory{}, "", models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+	}
+	dir := record.dir
+	var entries []memListEntry
+	if opts.Type != models.EntryTypeFile {
+		for _, child := range m.dirs {
+			if child.isDeleted || child.dir.ParentUUID != UUID || !strings.HasPrefix(child.dir.Dirname, opts.NamePrefix) {
+				continue
+			}
+			entries = append(entries, memListEntry{
+				kind:      dirEntryKind,
+				uuid:      child.dir.UUID,
+				nameKey:   naturalSortKey(child.dir.Dirname),
+				createdAt: child.dir.CreatedAt,
+				updatedAt: child.dir.UpdatedAt,
+				dir:       child.dir,
+			})
+		}
+	}
+	if opts.Type != models.EntryTypeDir {
+		for _, child := range m.files {
+			if child.isDeleted || child.file.ParentUUID != UUID || !strings.HasPrefix(child.file.Filename, opts.NamePrefix) {
+				continue
+			}
+			entries = append(entries, memListEntry{
+				kind:      fileEntryKind,
+				uuid:      child.file.UUID,
+				nameKey:   naturalSortKey(child.file.Filename),
+				size:      int64(child.file.FileSize),
+				createdAt: child.file.CreatedAt,
+				updatedAt: child.file.UpdatedAt,
+				file:      child.file,
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareListEntries(entries[i], entries[j], opts) < 0
+	})
+	var nextCursor, lastCursor string
+	count := 0
+	for _, entry := range entries {
+		if cursor != nil && compareListEntries(entry, *cursor, opts) <= 0 {
+			continue
+		}
+		if count == opts.Limit {
+			nextCursor = lastCursor
+			break
+		}
+		count++
+		name := entry.file.Filename
+		if entry.kind == dirEntryKind {
+			name = entry.dir.Dirname
+			dir.ListOfDirs = append(dir.ListOfDirs, entry.dir)
+		} else {
+			dir.ListOfFiles = append(dir.ListOfFiles, entry.file)
+		}
+		lastCursor = encodeListCursor(opts, entry.kind,
+			entrySortValue(opts.SortBy, name, entry.size, entry.createdAt, entry.updatedAt), entry.uuid)
+	}
+	return dir, nextCursor, nil
+}
+
+// versionHashes returns the hashes of the content of all versions of a file record.
+func (f *fileRecord) versionHashes() []string {
+	hashes := make([]string, 0, len(f.versions))
+	for _, version := range f.versions {
+		hashes = append(hashes, version.ContentHash)
+	}
+	return hashes
+}
+
+// versionsSize returns the sum of the sizes of all versions of a file record.
+func (f *fileRecord) versionsSize() int64 {
+	var size int64
+	for _, version := range f.versions {
+		size += int64(version.FileSize)
+	}
+	return size
+}
+
+// removeThumbnails removes the thumbnails of all versions of a file record except one, and
+// returns their images as blobs. A version of 0 keeps no thumbnails.
+func (f *fileRecord) removeThumbnails(keepVersion int) []models.Blob {
+	var kept []models.Thumbnail
+	var images []models.Blob
+	for _, t := range f.thumbnails {
+		if t.Version == keepVersion {
+			kept = append(kept, t)
+			continue
+		}
+		images = append(images, models.Blob{StorageKey: t.StorageKey, RealPath: t.RealPath, Size: t.FileSize})
+	}
+	f.thumbnails = kept
+	return images
+}
+
+// readVersion completes a version of a file record with its blob. The caller must hold
+// the read lock.
+func (m *FManMemoryRepo) readVersion(record *fileRecord, version models.FileVersion) models.FileVersion {
+	if blob, ok := m.blobs[version.ContentHash]; ok {
+		version.ContentMD5 = blob.blob.MD5
+		version.StoredSize = uint64(blob.blob.StoredSize)
+		version.StorageKey = blob.blob.StorageKey
+		version.RealPath = blob.blob.RealPath
+	}
+	version.IsCurrent = version.Version == record.file.Version
+	return version
+}
+
+// InsertVersionRecord adds a new version to a file record, which is not soft-removed, in
+// memory, and makes it the current content of the file. Its size is counted as used by the
+// owner of the file.
+func (m *FManMemoryRepo) InsertVersionRecord(fileUUID string, blob models.Blob, contentType, uploadedBy string) (models.FileVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[fileUUID]
+	if !ok || record.isDeleted {
+		return models.FileVersion{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+	}
+	stored, err := m.acquireBlob(blob)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	now := time.Now().UTC()
+	version := models.FileVersion{
+		FileUUID:    fileUUID,
+		Version:     record.file.Version + 1,
+		FileSize:    uint64(stored.Size),
+		ContentHash: stored.Hash,
+		ContentType: contentType,
+		UploadedBy:  uploadedBy,
+		CreatedAt:   now,
+	}
+	record.versions = append(record.versions, version)
+	record.file.Version = version.Version
+	record.file.ContentHash = stored.Hash
+	record.file.ContentMD5 = stored.MD5
+	record.file.ContentType = contentType
+	record.file.StorageKey = stored.StorageKey
+	record.file.RealPath = stored.RealPath
+	record.file.FileSize = uint64(stored.Size)
+	record.file.StoredSize = uint64(stored.StoredSize)
+	record.file.UpdatedAt = now
+	m.addUsage(record.file.OwnerUUID, stored.Size)
+	return m.readVersion(record, version), nil
+}
+
+// ReadVersionRecord reads a version of a file record, which is not soft-removed, from memory.
+func (m *FManMemoryRepo) ReadVersionRecord(fileUUID string, version int) (models.FileVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.files[fileUUID]
+	if ok && !record.isDeleted {
+		for _, v := range record.versions {
+			if v.Version == version {
+				return m.readVersion(record, v), nil
+			}
+		}
+	}
+	return models.FileVersion{}, models.NewFManError(models.NotFoundErrorCode,
+		fmt.Sprintf("version %d of file %s does not exist", version, fileUUID))
+}
+
+// ListVersionRecords lists all versions of a file record, which is not soft-removed, from
+// memory, newest first.
+func (m *FManMemoryRepo) ListVersionRecords(fileUUID string) ([]models.FileVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, ok := m.files[fileUUID]
+	if !ok || record.isDeleted {
+		return nil, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+	}
+	versions := make([]models.FileVersion, 0, len(record.versions))
+	for i := len(record.versions) - 1; i >= 0; i-- {
+		versions = append(versions, m.readVersion(record, record.versions[i]))
+	}
+	return versions, nil
+}
+
+// HardRemoveVersionRecords removes old versions of a file record, which is not soft-removed,
+// from memory, releases their references to the blobs of their content and no longer counts
+// their sizes as used by the owner of the file.
+func (m *FManMemoryRepo) HardRemoveVersionRecords(fileUUID string, versions []int) ([]models.Blob, error) {
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[fileUUID]
+	if !ok || record.isDeleted {
+		return nil, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+	}
+	remove := make(map[int]bool, len(versions))
+	for _, version := range versions {
+		if version == record.file.Version {
+			return nil, models.NewFManError(models.InvalidArgumentErrorCode,
+				fmt.Sprintf("current version %d of file %s cannot be removed", version, fileUUID))
+		}
+		remove[version] = true
+	}
+	var kept []models.FileVersion
+	var contentHashes []string
+	var removedSize int64
+	for _, version := range record.versions {
+		if remove[version.Version] {
+			contentHashes = append(contentHashes, version.ContentHash)
+			removedSize += int64(version.FileSize)
+		} else {
+			kept = append(kept, version)
+		}
+	}
+	if len(contentHashes) != len(remove) {
+		return nil, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("some versions of file %s do not exist", fileUUID))
+	}
+	record.versions = kept
+	m.addUsage(record.file.OwnerUUID, -removedSize)
+	return m.releaseBlobs(contentHashes), nil
+}
+
+// UpdateVersionPolicy sets the version policy of a file record, which is not soft-removed, in memory.
+func (m *FManMemoryRepo) UpdateVersionPolicy(fileUUID string, policy models.VersionPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[fileUUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+	}
+	// The policy is stored with the same precision as in DB.
+	policy.MaxAge = policy.MaxAge.Truncate(time.Second)
+	record.file.VersionPolicy = policy
+	return nil
+}
+
+// ListVersionedFileRecords lists the UUIDs of the file records, which are not soft-removed
+// and have old versions, from memory.
+func (m *FManMemoryRepo) ListVersionedFileRecords() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var fileUUIDs []string
+	for fileUUID, record := range m.files {
+		if !record.isDeleted && len(record.versions) > 1 {
+			fileUUIDs = append(fileUUIDs, fileUUID)
+		}
+	}
+	sort.Strings(fileUUIDs)
+	return fileUUIDs, nil
+}
+
+// InsertUserRecord inserts a new user record to memory together with its root directory.
+func (m *FManMemoryRepo) InsertUserRecord(user models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.dirs[user.RootDirUUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("directory %s already exists", user.RootDirUUID))
+	}
+	for _, existing := range m.users {
+		if existing.UUID == user.UUID || existing.Username == user.Username {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("user %s already exists", user.Username))
+		}
+	}
+	now := time.Now().UTC()
+	m.dirs[user.RootDirUUID] = &dirRecord{
+		dir: models.Directory{
+			UUID:      user.RootDirUUID,
+			Path:      models.RootDirPath,
+			OwnerUUID: user.UUID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	m.users[user.UUID] = &user
+	m.quotas[user.UUID] = &models.Quota{}
+	return nil
+}
+
+// ReadUserRecord reads a user record from memory.
+func (m *FManMemoryRepo) ReadUserRecord(UUID string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	user, ok := m.users[UUID]
+	if !ok {
+		return models.User{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", UUID))
+	}
+	return *user, nil
+}
+
+// ReadUserRecordByName reads a user record with a given username from memory.
+func (m *FManMemoryRepo) ReadUserRecordByName(username string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.Username == username {
+			return *user, nil
+		}
+	}
+	return models.User{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", username))
+}
+
+// InsertRefreshTokenRecord inserts a new refresh token record to memory.
+func (m *FManMemoryRepo) InsertRefreshTokenRecord(token models.RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tokens[token.UUID]; ok {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("refresh token %s already exists", token.UUID))
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	m.tokens[token.UUID] = &token
+	return nil
+}
+
+// HardRemoveRefreshTokenRecord removes a refresh token record from memory and returns it.
+func (m *FManMemoryRepo) HardRemoveRefreshTokenRecord(UUID string) (models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.tokens[UUID]
+	if !ok {
+		return models.RefreshToken{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("refresh token %s does not exist", UUID))
+	}
+	delete(m.tokens, UUID)
+	return *token, nil
+}
+
+// HardRemoveExpiredRefreshTokenRecords removes the refresh token records, which expired before
+// a given time, from memory.
+func (m *FManMemoryRepo) HardRemoveExpiredRefreshTokenRecords(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	removed := 0
+	for UUID, token := range m.tokens {
+		if token.ExpiresAt.Before(before) {
+			delete(m.tokens, UUID)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// addUsage adds a number of bytes, which may be negative, to the bytes used by a user in
+// memory. Records without an owner, or whose owner is not a user, are not counted. The
+// caller must hold the write lock.
+func (m *FManMemoryRepo) addUsage(userUUID string, delta int64) {
+	if quota, ok := m.quotas[userUUID]; ok {
+		quota.UsedBytes += delta
+	}
+}
+
+// ReadUserQuotaRecord reads the quota of a user and the bytes used by the user from memory.
+func (m *FManMemoryRepo) ReadUserQuotaRecord(userUUID string) (models.Quota, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	quota, ok := m.quotas[userUUID]
+	if !ok {
+		return models.Quota{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", userUUID))
+	}
+	return *quota, nil
+}
+
+// UpdateUserQuotaLimit sets the quota of a user in memory.
+func (m *FManMemoryRepo) UpdateUserQuotaLimit(userUUID string, limit int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quota, ok := m.quotas[userUUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", userUUID))
+	}
+	quota.LimitBytes = limit
+	return nil
+}
+
+// UpdateDirQuotaLimit sets the quota of a directory record, which is not soft-removed, in memory.
+func (m *FManMemoryRepo) UpdateDirQuotaLimit(dirUUID string, limit int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.dirs[dirUUID]
+	if !ok || record.isDeleted {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", dirUUID))
+	}
+	if record.dir.ParentUUID == "" {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot have a quota")
+	}
+	record.quotaBytes = limit
+	return nil
+}
+
+// ListAncestorDirQuotaRecords lists the quotas of a directory record and its ancestors from
+// memory, nearest first.
+func (m *FManMemoryRepo) ListAncestorDirQuotaRecords(dirUUID string) ([]models.DirQuota, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var quotas []models.DirQuota
+	for dirUUID != "" {
+		record, ok := m.dirs[dirUUID]
+		if !ok {
+			break
+		}
+		if record.quotaBytes > 0 && !record.isDeleted {
+			quotas = append(quotas, m.dirQuota(record))
+		}
+		dirUUID = record.dir.ParentUUID
+	}
+	return quotas, nil
+}
+
+// ListOwnerDirQuotaRecords lists the quotas of an owner's directory records from memory,
+// ordered by path.
+func (m *FManMemoryRepo) ListOwnerDirQuotaRecords(ownerUUID string) ([]models.DirQuota, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var quotas []models.DirQuota
+	for _, record := range m.dirs {
+		if record.dir.OwnerUUID == ownerUUID && record.quotaBytes > 0 && !record.isDeleted {
+			quotas = append(quotas, m.dirQuota(record))
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].Path < quotas[j].Path })
+	return quotas, nil
+}
+
+// ReserveQuotaRecord inserts a reservation of bytes of the quotas of an owner and of a
+// directory record and its ancestors to memory.
+func (m *FManMemoryRepo) ReserveQuotaRecord(reservation models.QuotaReservation, defaultLimit int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for UUID, r := range m.reserved {
+		if !r.ExpiresAt.After(now) {
+			delete(m.reserved, UUID)
+		}
+	}
+	available := int64(-1)
+	for dirUUID := reservation.DirUUID; dirUUID != ""; {
+		record, ok := m.dirs[dirUUID]
+		if !ok {
+			break
+		}
+		if record.quotaBytes > 0 && !record.isDeleted {
+			quota := m.dirQuota(record)
+			for _, r := range m.reserved {
+				if m.isDescendant(r.DirUUID, dirUUID) {
+					quota.UsedBytes += r.Size
+				}
+			}
+			available = minAvailable(available, quota.LimitBytes-quota.UsedBytes)
+		}
+		dirUUID = record.dir.ParentUUID
+	}
+	if quota, ok := m.quotas[reservation.OwnerUUID]; ok {
+		limit := quota.LimitBytes
+		if limit == 0 {
+			limit = defaultLimit
+		}
+		if limit > 0 {
+			used := quota.UsedBytes
+			for _, r := range m.reserved {
+				if r.OwnerUUID == reservation.OwnerUUID {
+					used += r.Size
+				}
+			}
+			available = minAvailable(available, limit-used)
+		}
+	}
+	if available >= 0 && reservation.Size > available {
+		return quotaExceeded(available)
+	}
+	m.reserved[reservation.UUID] = &reservation
+	return nil
+}
+
+// RemoveQuotaReservationRecord removes a reservation from memory.
+func (m *FManMemoryRepo) RemoveQuotaReservationRecord(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.reserved, UUID)
+	return nil
+}
+
+// dirQuota returns the quota of a directory record together with the bytes used by the
+// files in its subtree, which are not soft-removed. The caller must hold the read lock.
+func (m *FManMemoryRepo) dirQuota(record *dirRecord) models.DirQuota {
+	quota := models.DirQuota{
+		DirUUID: record.dir.UUID,
+		Path:    record.dir.Path,
+		Quota:   models.Quota{LimitBytes: record.quotaBytes},
+	}
+	for _, file := range m.files {
+		if !file.isDeleted && m.isDescendant(file.file.ParentUUID, record.dir.UUID) {
+			quota.UsedBytes += file.versionsSize()
+		}
+	}
+	return quota
+}
+
+// RecomputeUsageRecords recounts the bytes used by every user in memory.
+func (m *FManMemoryRepo) RecomputeUsageRecords() ([]models.UsageCorrection, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used := make(map[string]int64)
+	for _, record := range m.files {
+		used[record.file.OwnerUUID] += record.versionsSize()
+	}
+	var corrections []models.UsageCorrection
+	for userUUID, quota := range m.quotas {
+		if quota.UsedBytes != used[userUUID] {
+			corrections = append(corrections, models.UsageCorrection{
+				UserUUID:     userUUID,
+				OldUsedBytes: quota.UsedBytes,
+				NewUsedBytes: used[userUUID],
+			})
+			quota.UsedBytes = used[userUUID]
+		}
+	}
+	sort.Slice(corrections, func(i, j int) bool { return corrections[i].UserUUID < corrections[j].UserUUID })
+	return corrections, nil
+}
+
+// ReadStorageStatsRecord counts the stored contents in memory.
+func (m *FManMemoryRepo) ReadStorageStatsRecord() (models.StorageStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var stats models.StorageStats
+	for _, record := range m.blobs {
+		stats.Blobs++
+		if record.blob.StoredSize < record.blob.Size {
+			stats.CompressedBlobs++
+		}
+		stats.LogicalBytes += record.blob.Size
+		stats.StoredBytes += record.blob.StoredSize
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.StoredBytes
+	return stats, nil
+}
+
+// InsertShareLinkRecord inserts a new share link record to memory.
+func (m *FManMemoryRepo) InsertShareLinkRecord(link models.ShareLink) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.shares {
+		if existing.UUID == link.UUID || existing.Token == link.Token {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("share link %s already exists", link.UUID))
+		}
+	}
+	if link.ExpiresAt != nil {
+		expiresAt := link.ExpiresAt.UTC()
+		link.ExpiresAt = &expiresAt
+	}
+	link.CreatedAt = link.CreatedAt.UTC()
+	link.HasPassword = link.PasswordHash != ""
+	m.shares[link.UUID] = &link
+	return nil
+}
+
+// ReadShareLinkRecord reads a share link record from memory.
+func (m *FManMemoryRepo) ReadShareLinkRecord(UUID string) (models.ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	link, ok := m.shares[UUID]
+	if !ok {
+		return models.ShareLink{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("share link %s does not exist", UUID))
+	}
+	return *link, nil
+}
+
+// ReadShareLinkRecordByToken reads a share link record with a given token from memory.
+func (m *FManMemoryRepo) ReadShareLinkRecordByToken(token string) (models.ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, link := range m.shares {
+		if link.Token == token {
+			return *link, nil
+		}
+	}
+	return models.ShareLink{}, models.NewFManError(models.NotFoundErrorCode, "share link does not exist")
+}
+
+// ListShareLinkRecords lists the share link records of an owner from memory, most recently
+// created first.
+func (m *FManMemoryRepo) ListShareLinkRecords(ownerUUID string) ([]models.ShareLink, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var links []models.ShareLink
+	for _, link := range m.shares {
+		if link.OwnerUUID == ownerUUID {
+			links = append(links, *link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if c := compareTimes(links[i].CreatedAt, links[j].CreatedAt); c != 0 {
+			return c > 0
+		}
+		return links[i].UUID < links[j].UUID
+	})
+	return links, nil
+}
+
+// CountShareLinkDownload increments the number of downloads of a share link in memory,
+// unless it reached its download limit.
+func (m *FManMemoryRepo) CountShareLinkDownload(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	link, ok := m.shares[UUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("share link %s does not exist", UUID))
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		return models.NewFManError(models.ExpiredErrorCode, "share link reached its download limit")
+	}
+	link.Downloads++
+	return nil
+}
+
+// HardRemoveShareLinkRecord removes a share link record from memory.
+func (m *FManMemoryRepo) HardRemoveShareLinkRecord(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.shares[UUID]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("share link %s does not exist", UUID))
+	}
+	delete(m.shares, UUID)
+	return nil
+}
+
+// InsertGroupRecord inserts a new group record to memory.
+func (m *FManMemoryRepo) InsertGroupRecord(group models.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.groups {
+		if existing.UUID == group.UUID || existing.Name == group.Name {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("group %s already exists", group.Name))
+		}
+	}
+	group.MemberUUIDs = []string{}
+	group.CreatedAt = group.CreatedAt.UTC()
+	m.groups[group.UUID] = &group
+	return nil
+}
+
+// ReadGroupRecord reads a group record together with its members from memory.
+func (m *FManMemoryRepo) ReadGroupRecord(UUID string) (models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	group, ok := m.groups[UUID]
+	if !ok {
+		return models.Group{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", UUID))
+	}
+	return copyGroup(group), nil
+}
+
+// ListGroupRecords lists the group records, or the group records of a member, together with
+// their members from memory, ordered by name.
+func (m *FManMemoryRepo) ListGroupRecords(memberUUID string) ([]models.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var groups []models.Group
+	for _, group := range m.groups {
+		if memberUUID == "" || containsString(group.MemberUUIDs, memberUUID) {
+			groups = append(groups, copyGroup(group))
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups, nil
+}
+
+// HardRemoveGroupRecord removes a group record together with its members and access control
+// entries from memory.
+func (m *FManMemoryRepo) HardRemoveGroupRecord(UUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.groups[UUID]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", UUID))
+	}
+	delete(m.groups, UUID)
+	for key := range m.acl {
+		if key.principalType == models.PrincipalGroup && key.principalUUID == UUID {
+			delete(m.acl, key)
+		}
+	}
+	return nil
+}
+
+// InsertGroupMemberRecord adds a user to a group in memory.
+func (m *FManMemoryRepo) InsertGroupMemberRecord(groupUUID, userUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	group, ok := m.groups[groupUUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", groupUUID))
+	}
+	if _, ok := m.users[userUUID]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", userUUID))
+	}
+	if containsString(group.MemberUUIDs, userUUID) {
+		return models.NewFManError(models.AlreadyExistErrorCode,
+			fmt.Sprintf("user %s is already a member of group %s", userUUID, groupUUID))
+	}
+	group.MemberUUIDs = append(group.MemberUUIDs, userUUID)
+	sort.Strings(group.MemberUUIDs)
+	return nil
+}
+
+// HardRemoveGroupMemberRecord removes a user from a group in memory.
+func (m *FManMemoryRepo) HardRemoveGroupMemberRecord(groupUUID, userUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if group, ok := m.groups[groupUUID]; ok {
+		for i, memberUUID := range group.MemberUUIDs {
+			if memberUUID == userUUID {
+				group.MemberUUIDs = append(group.MemberUUIDs[:i], group.MemberUUIDs[i+1:]...)
+				return nil
+			}
+		}
+	}
+	return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s is not a member of group %s", userUUID, groupUUID))
+}
+
+// copyGroup returns a copy of a group record, which does not share its members with the
+// record. The caller must hold the read lock.
+func copyGroup(group *models.Group) models.Group {
+	copied := *group
+	copied.MemberUUIDs = append([]string{}, group.MemberUUIDs...)
+	return copied
+}
+
+// containsString checks if a string is in a slice.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UpsertACLRecord inserts or replaces an access control entry in memory.
+func (m *FManMemoryRepo) UpsertACLRecord(entry models.ACLEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch entry.PrincipalType {
+	case models.PrincipalGroup:
+		if _, ok := m.groups[entry.PrincipalUUID]; !ok {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("group %s does not exist", entry.PrincipalUUID))
+		}
+	case models.PrincipalUser:
+		if _, ok := m.users[entry.PrincipalUUID]; !ok {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", entry.PrincipalUUID))
+		}
+	default:
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown principal type %s", entry.PrincipalType))
+	}
+	entry.UpdatedAt = time.Now().UTC()
+	m.acl[aclKey{entry.ItemUUID, entry.PrincipalType, entry.PrincipalUUID}] = &entry
+	return nil
+}
+
+// HardRemoveACLRecord removes an access control entry from memory.
+func (m *FManMemoryRepo) HardRemoveACLRecord(itemUUID, principalType, principalUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := aclKey{itemUUID, principalType, principalUUID}
+	if _, ok := m.acl[key]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("%s %s has no access control entry on %s", principalType, principalUUID, itemUUID))
+	}
+	delete(m.acl, key)
+	return nil
+}
+
+// removeACLEntries removes the access control entries on an item from memory. The caller
+// must hold the write lock.
+func (m *FManMemoryRepo) removeACLEntries(itemUUID string) {
+	for key := range m.acl {
+		if key.itemUUID == itemUUID {
+			delete(m.acl, key)
+		}
+	}
+}
+
+// ListACLRecords lists the access control entries on the given items from memory.
+func (m *FManMemoryRepo) ListACLRecords(itemUUIDs []string) ([]models.ACLEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []models.ACLEntry
+	for _, entry := range m.acl {
+		if containsString(itemUUIDs, entry.ItemUUID) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// ListSubtreeACLRecords lists the access control entries on a directory record and on the
+// records in its subtree from memory.
+func (m *FManMemoryRepo) ListSubtreeACLRecords(dirUUID string) ([]models.ACLEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []models.ACLEntry
+	for _, entry := range m.acl {
+		if entry.ItemType == models.EntryTypeFile {
+			if file, ok := m.files[entry.ItemUUID]; ok && m.isDescendant(file.file.ParentUUID, dirUUID) {
+				entries = append(entries, *entry)
+			}
+		} else if m.isDescendant(entry.ItemUUID, dirUUID) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// ListPrincipalACLRecords lists the access control entries of the given users and groups
+// from memory.
+func (m *FManMemoryRepo) ListPrincipalACLRecords(principalUUIDs []string) ([]models.ACLEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []models.ACLEntry
+	for _, entry := range m.acl {
+		if containsString(principalUUIDs, entry.PrincipalUUID) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// ListAncestorDirUUIDs lists the UUIDs of a directory record and its ancestors from memory,
+// nearest first.
+func (m *FManMemoryRepo) ListAncestorDirUUIDs(dirUUID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	dirUUIDs := []string{dirUUID}
+	for {
+		record, ok := m.dirs[dirUUID]
+		if !ok || record.dir.ParentUUID == "" {
+			return dirUUIDs, nil
+		}
+		dirUUID = record.dir.ParentUUID
+		dirUUIDs = append(dirUUIDs, dirUUID)
+	}
+}
+
+// IsContentIndexed checks if the text of a content is indexed in memory.
+func (m *FManMemoryRepo) IsContentIndexed(contentHash string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.search[contentHash]
+	return ok, nil
+}
+
+// IndexContentRecord indexes the text of a content in memory, unless it is already indexed
+// or its blob does not exist anymore.
+func (m *FManMemoryRepo) IndexContentRecord(contentHash, text string) error {
+	words := models.SearchTokens(text)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[contentHash]; !ok {
+		return nil
+	}
+	if _, ok := m.search[contentHash]; ok {
+		return nil
+	}
+	m.search[contentHash] = &searchContent{
+		words:       searchWords(words),
+		frequencies: contentTermFrequencies(words),
+	}
+	return nil
+}
+
+// ListUnindexedFileRecords lists the file records, which are not soft-removed and whose
+// content is not indexed, from memory.
+func (m *FManMemoryRepo) ListUnindexedFileRecords() ([]models.File, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var files []models.File
+	for _, record := range m.files {
+		if _, ok := m.search[record.file.ContentHash]; !ok && !record.isDeleted {
+			files = append(files, record.file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].UUID < files[j].UUID })
+	return files, nil
+}
+
+// memSearchItem holds a file or a directory considered by SearchRecords.
+type memSearchItem struct {
+	hit         models.SearchHit
+	name        string
+	path        string
+	parentUUID  string
+	ownerUUID   string
+	size        int64
+	updatedAt   time.Time
+	contentHash string
+}
+
+// SearchRecords finds the files/dirs matching a query in memory, scored in the same way as
+// by FManSQLiteRepo.
+func (m *FManMemoryRepo) SearchRecords(query models.SearchQuery) ([]models.SearchHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var items []memSearchItem
+	for _, record := range m.dirs {
+		if !record.isDeleted && record.dir.ParentUUID != "" {
+			items = append(items, memSearchItem{
+				hit:        models.SearchHit{ItemType: models.EntryTypeDir, UUID: record.dir.UUID},
+				name:       record.dir.Dirname,
+				path:       record.dir.Path,
+				parentUUID: record.dir.ParentUUID,
+				ownerUUID:  record.dir.OwnerUUID,
+				updatedAt:  record.dir.UpdatedAt,
+			})
+		}
+	}
+	for _, record := range m.files {
+		if !record.isDeleted {
+			items = append(items, memSearchItem{
+				hit:         models.SearchHit{ItemType: models.EntryTypeFile, UUID: record.file.UUID},
+				name:        record.file.Filename,
+				path:        record.file.Path,
+				parentUUID:  record.file.ParentUUID,
+				ownerUUID:   record.file.OwnerUUID,
+				size:        int64(record.file.FileSize),
+				updatedAt:   record.file.UpdatedAt,
+				contentHash: record.file.ContentHash,
+			})
+		}
+	}
+	var hits []models.SearchHit
+	for _, item := range items {
+		if !m.matchesSearchFilters(item, query) {
+			continue
+		}
+		matched := true
+		for _, term := range query.Terms {
+			score := m.searchTermScore(item, term)
+			if score == 0 {
+				matched = false
+				break
+			}
+			item.hit.Score += score
+		}
+		if matched {
+			hits = append(hits, item.hit)
+		}
+	}
+	paths := make(map[string]string, len(items))
+	for _, item := range items {
+		paths[item.hit.UUID] = item.path
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if paths[a.UUID] != paths[b.UUID] {
+			return paths[a.UUID] < paths[b.UUID]
+		}
+		return a.UUID < b.UUID
+	})
+	if query.Offset >= len(hits) {
+		return nil, nil
+	}
+	hits = hits[query.Offset:]
+	if len(hits) > query.Limit {
+		hits = hits[:query.Limit]
+	}
+	return hits, nil
+}
+
+// matchesSearchFilters checks if a file/dir matches the filters of a search query. The
+// caller must hold m.mu.
+func (m *FManMemoryRepo) matchesSearchFilters(item memSearchItem, query models.SearchQuery) bool {
+	if len(query.ScopeUUIDs) > 0 {
+		inScope := false
+		for _, UUID := range query.ScopeUUIDs {
+			if item.hit.UUID == UUID || m.isDescendant(item.parentUUID, UUID) {
+				inScope = true
+				break
+			}
+		}
+		if !inScope {
+			return false
+		}
+	}
+	switch {
+	case query.UnderDirUUID != "" && !m.isDescendant(item.parentUUID, query.UnderDirUUID):
+		return false
+	case query.Type != "" && item.hit.ItemType != query.Type:
+		return false
+	case query.MinSize > 0 && item.size < query.MinSize:
+		return false
+	case query.MaxSize > 0 && (item.hit.ItemType != models.EntryTypeFile || item.size > query.MaxSize):
+		return false
+	case !query.UpdatedAfter.IsZero() && item.updatedAt.Before(query.UpdatedAfter):
+		return false
+	case !query.UpdatedBefore.IsZero() && !item.updatedAt.Before(query.UpdatedBefore):
+		return false
+	case query.OwnerUUID != "" && item.ownerUUID != query.OwnerUUID:
+		return false
+	case query.Tag != "" && (m.attrs[item.hit.UUID] == nil || !m.attrs[item.hit.UUID].tags[query.Tag]):
+		return false
+	}
+	return true
+}
+
+// searchTermScore returns the score of a search term on a file/dir, which is zero if the
+// term does not match. The caller must hold m.mu.
+func (m *FManMemoryRepo) searchTermScore(item memSearchItem, term models.SearchTerm) int {
+	score := 0
+	needle := searchTermNeedle(term)
+	if strings.Contains(searchName(item.name), needle) {
+		score += nameMatchScore
+	}
+	if attrs, ok := m.attrs[item.hit.UUID]; ok {
+		for tag := range attrs.tags {
+			if strings.Contains(searchName(tag), needle) {
+				score += tagMatchScore
+				break
+			}
+		}
+	}
+	inPath := true
+	for _, word := range term.Words {
+		if !strings.Contains(strings.ToLower(item.path), word) {
+			inPath = false
+			break
+		}
+	}
+	if inPath {
+		score += pathMatchScore
+	}
+	content, ok := m.search[item.contentHash]
+	if !ok {
+		return score
+	}
+	switch {
+	case len(term.Words) == 1 && !term.Prefix:
+		score += content.frequencies[term.Words[0]]
+	case len(term.Words) == 1:
+		best := 0
+		for word, frequency := range content.frequencies {
+			if strings.HasPrefix(word, term.Words[0]) && frequency > best {
+				best = frequency
+			}
+		}
+		score += best
+	default:
+		for _, word := range phraseWords(term) {
+			if content.frequencies[word] == 0 {
+				return score
+			}
+		}
+		if strings.Contains(content.words, needle) {
+			score += contentPhraseScore
+		}
+	}
+	return score
+}
+
+// checkItemExists returns an error if a file/dir, which is not soft-removed, does not exist in
+// memory. The caller must hold m.mu.
+func (m *FManMemoryRepo) checkItemExists(item models.ItemRef) error {
+	exists := false
+	if item.ItemType == models.EntryTypeDir {
+		record, ok := m.dirs[item.UUID]
+		exists = ok && !record.isDeleted
+	} else {
+		record, ok := m.files[item.UUID]
+		exists = ok && !record.isDeleted
+	}
+	if !exists {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s %s does not exist", item.ItemType, item.UUID))
+	}
+	return nil
+}
+
+// itemAttributes returns the attributes of a file/dir in memory, which are created if the
+// file/dir has none yet. The caller must hold the write lock.
+func (m *FManMemoryRepo) itemAttributes(item models.ItemRef) *itemAttributes {
+	attrs, ok := m.attrs[item.UUID]
+	if !ok {
+		attrs = &itemAttributes{
+			itemType: item.ItemType,
+			tags:     make(map[string]bool),
+			metadata: make(map[string]models.MetadataEntry),
+		}
+		m.attrs[item.UUID] = attrs
+	}
+	return attrs
+}
+
+// InsertTagRecords adds a tag to files/dirs in memory, which do not carry it yet.
+func (m *FManMemoryRepo) InsertTagRecords(items []models.ItemRef, tag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range items {
+		if err := m.checkItemExists(item); err != nil {
+			return err
+		}
+	}
+	for _, item := range items {
+		m.itemAttributes(item).tags[tag] = true
+	}
+	return nil
+}
+
+// HardRemoveTagRecord removes a tag from a file/dir in memory.
+func (m *FManMemoryRepo) HardRemoveTagRecord(itemUUID, tag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attrs, ok := m.attrs[itemUUID]
+	if !ok || !attrs.tags[tag] {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s has no tag %s", itemUUID, tag))
+	}
+	delete(attrs.tags, tag)
+	return nil
+}
+
+// ListTagRecords lists the tags of a file/dir from memory, ordered by tag.
+func (m *FManMemoryRepo) ListTagRecords(itemUUID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attrs, ok := m.attrs[itemUUID]
+	if !ok {
+		return nil, nil
+	}
+	var tags []string
+	for tag := range attrs.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// UpsertMetadataRecord sets a metadata key of a file/dir in memory, replacing the value of
+// an existing key.
+func (m *FManMemoryRepo) UpsertMetadataRecord(item models.ItemRef, entry models.MetadataEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkItemExists(item); err != nil {
+		return err
+	}
+	entry.UpdatedAt = entry.UpdatedAt.UTC()
+	m.itemAttributes(item).metadata[entry.Key] = entry
+	return nil
+}
+
+// HardRemoveMetadataRecord removes a metadata key of a file/dir from memory.
+func (m *FManMemoryRepo) HardRemoveMetadataRecord(itemUUID, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	attrs, ok := m.attrs[itemUUID]
+	if !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s has no metadata key %s", itemUUID, key))
+	}
+	if _, ok := attrs.metadata[key]; !ok {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s has no metadata key %s", itemUUID, key))
+	}
+	delete(attrs.metadata, key)
+	return nil
+}
+
+// ListMetadataRecords lists the metadata of a file/dir from memory, ordered by key.
+func (m *FManMemoryRepo) ListMetadataRecords(itemUUID string) ([]models.MetadataEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attrs, ok := m.attrs[itemUUID]
+	if !ok {
+		return nil, nil
+	}
+	var entries []models.MetadataEntry
+	for _, entry := range attrs.metadata {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// CopyAttributeRecords copies the tags and the metadata of a file/dir to another file/dir in
+// memory. Attributes, which the other file/dir already has, are kept.
+func (m *FManMemoryRepo) CopyAttributeRecords(srcUUID, dstUUID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	src, ok := m.attrs[srcUUID]
+	if !ok {
+		return nil
+	}
+	dst := m.itemAttributes(models.ItemRef{ItemType: src.itemType, UUID: dstUUID})
+	for tag := range src.tags {
+		dst.tags[tag] = true
+	}
+	for key, entry := range src.metadata {
+		if _, ok := dst.metadata[key]; !ok {
+			dst.metadata[key] = entry
+		}
+	}
+	return nil
+}
+
+// InsertThumbnailRecord inserts a thumbnail of the current version of a file record, which
+// is not soft-removed, to memory, unless it already exists. It returns the stored thumbnail.
+func (m *FManMemoryRepo) InsertThumbnailRecord(thumbnail models.Thumbnail) (models.Thumbnail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[thumbnail.FileUUID]
+	if !ok || record.isDeleted {
+		return models.Thumbnail{}, models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("file %s does not exist", thumbnail.FileUUID))
+	}
+	if thumbnail.Version != record.file.Version {
+		return models.Thumbnail{}, models.NewFManError(models.ConflictErrorCode,
+			fmt.Sprintf("version %d of file %s is not the current version", thumbnail.Version, thumbnail.FileUUID))
+	}
+	for _, t := range record.thumbnails {
+		if t.Version == thumbnail.Version && t.Size == thumbnail.Size {
+			return t, nil
+		}
+	}
+	thumbnail.CreatedAt = time.Now().UTC()
+	record.thumbnails = append(record.thumbnails, thumbnail)
+	return thumbnail, nil
+}
+
+// ReadThumbnailRecord reads a thumbnail of a version of a file record from memory.
+func (m *FManMemoryRepo) ReadThumbnailRecord(fileUUID string, version int, size string) (models.Thumbnail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if record, ok := m.files[fileUUID]; ok {
+		for _, t := range record.thumbnails {
+			if t.Version == version && t.Size == size {
+				return t, nil
+			}
+		}
+	}
+	return models.Thumbnail{}, models.NewFManError(models.NotFoundErrorCode,
+		fmt.Sprintf("%s thumbnail of version %d of file %s does not exist", size, version, fileUUID))
+}
+
+// HardRemoveStaleThumbnailRecords removes the thumbnails of the versions of a file record
+// other than the current one from memory, and returns their images as blobs.
+func (m *FManMemoryRepo) HardRemoveStaleThumbnailRecords(fileUUID string) ([]models.Blob, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.files[fileUUID]
+	if !ok {
+		return nil, nil
+	}
+	return record.removeThumbnails(record.file.Version), nil
+}
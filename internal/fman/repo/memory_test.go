@@ -0,0 +1,14 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/fman/repo/repotest"
+)
+
+func TestFManMemoryRepo(t *testing.T) {
+	repotest.RunConformanceTests(t, func(t *testing.T) repotest.Repository {
+		return repo.NewFManMemoryRepo()
+	})
+}
@@ -0,0 +1,176 @@
+package repo
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//go:embed migrations
+var migrationFS embed.FS
+
+// migration holds a single schema migration.
+type migration struct {
+	// Version of the migration, parsed from the filename prefix (e.g. 0001_xxx.sql).
+	version int
+
+	// Name of the migration file.
+	name string
+
+	// SQL statements of the migration.
+	stmts string
+
+	// fn is run instead of stmts by migrations which cannot be expressed in SQL
+	// (e.g. data backfills computed in Go).
+	fn func(tx *sql.Tx, d dialect) error
+}
+
+// goMigrations holds the migrations written in Go. They are shared by all dialects
+// and ordered together with the SQL migrations, so their versions must not collide.
+var goMigrations = []migration{
+	{version: 3, name: "0003_backfill_name_keys", fn: backfillNameKeys},
+	{version: 13, name: "0013_backfill_search_names", fn: backfillSearchNames},
+}
+
+// loadMigrations loads all migrations of a dialect (e.g. sqlite) from the embedded
+// migrations directory together with goMigrations, ordered by their versions.
+func loadMigrations(dialect string) ([]migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := fs.ReadDir(migrationFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		prefix := strings.SplitN(entry.Name(), "_", 2)[0]
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version prefix", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, entry.Name())
+		}
+		seen[version] = entry.Name()
+		stmts, err := fs.ReadFile(migrationFS, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{
+			version: version,
+			name:    entry.Name(),
+			stmts:   string(stmts),
+		})
+	}
+	for _, m := range goMigrations {
+		if other, ok := seen[m.version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, m.name)
+		}
+		seen[m.version] = m.name
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// runMigrations applies all pending migrations of a dialect in order. Each migration
+// runs in its own transaction together with the record of its version in the
+// schema_migrations table, so a failed migration leaves the schema at the last
+// successfully applied version.
+func runMigrations(db *sql.DB, d dialect) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "repo",
+		"Operation": "runMigrations",
+		"dialect":   d.name,
+	})
+	migrations, err := loadMigrations(d.name)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		// Another process sharing the database may have applied the migration
+		// in the meantime, so check again while holding the lock.
+		if d.lockMigrationsStmt != "" {
+			if _, err := tx.Exec(d.lockMigrationsStmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		var isApplied bool
+		err = tx.QueryRow(rebind(d, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)"), m.version).
+			Scan(&isApplied)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if isApplied {
+			tx.Rollback()
+			continue
+		}
+		logger.Infof("Applying migration %s", m.name)
+		if m.fn != nil {
+			err = m.fn(tx, d)
+		} else {
+			_, err = tx.Exec(m.stmts)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+		_, err = tx.Exec(rebind(d, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			m.version, m.name, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("recording migration %s failed: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+CREATE TABLE directories (
+    uuid        TEXT PRIMARY KEY,
+    dirname     TEXT NOT NULL,
+    path        TEXT NOT NULL,
+    parent_uuid TEXT REFERENCES directories (uuid),
+    is_deleted  BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_directories_parent_uuid ON directories (parent_uuid);
+
+CREATE UNIQUE INDEX uq_directories_parent_uuid_dirname ON directories (parent_uuid, dirname)
+    WHERE is_deleted = FALSE;
+
+CREATE TABLE files (
+    uuid        TEXT PRIMARY KEY,
+    filename    TEXT NOT NULL,
+    path        TEXT NOT NULL,
+    real_path   TEXT NOT NULL,
+    parent_uuid TEXT NOT NULL REFERENCES directories (uuid),
+    file_size   BIGINT NOT NULL,
+    is_deleted  BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMPTZ NOT NULL,
+    updated_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_files_parent_uuid ON files (parent_uuid);
+
+CREATE UNIQUE INDEX uq_files_parent_uuid_filename ON files (parent_uuid, filename)
+    WHERE is_deleted = FALSE;
+
+-- Bootstrap the root directory.
+INSERT INTO directories (uuid, dirname, path, parent_uuid, is_deleted, created_at, updated_at)
+VALUES ('00000000-0000-0000-0000-000000000000', '', '/', NULL, FALSE, NOW(), NOW());
@@ -0,0 +1,18 @@
+CREATE TABLE trash_entries (
+    uuid                 TEXT PRIMARY KEY,
+    item_uuid            TEXT NOT NULL,
+    item_type            TEXT NOT NULL,
+    name                 TEXT NOT NULL,
+    original_parent_uuid TEXT NOT NULL,
+    original_path        TEXT NOT NULL,
+    deleted_at           TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_trash_entries_deleted_at ON trash_entries (deleted_at);
+
+-- trash_uuid holds the recycle bin entry a soft-removed record belongs to.
+ALTER TABLE directories ADD COLUMN trash_uuid TEXT;
+ALTER TABLE files ADD COLUMN trash_uuid TEXT;
+
+CREATE INDEX idx_directories_trash_uuid ON directories (trash_uuid);
+CREATE INDEX idx_files_trash_uuid ON files (trash_uuid);
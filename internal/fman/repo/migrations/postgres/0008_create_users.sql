@@ -0,0 +1,33 @@
+-- root_dir_uuid is the root directory of the user, which has no parent and is created
+-- together with the user.
+CREATE TABLE users (
+    uuid          TEXT PRIMARY KEY,
+    username      TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    root_dir_uuid TEXT NOT NULL REFERENCES directories (uuid),
+    is_admin      BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ NOT NULL,
+    updated_at    TIMESTAMPTZ NOT NULL
+);
+
+-- refresh_tokens holds the refresh tokens which are not used or revoked yet.
+CREATE TABLE refresh_tokens (
+    uuid       TEXT PRIMARY KEY,
+    user_uuid  TEXT NOT NULL REFERENCES users (uuid),
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_refresh_tokens_user_uuid ON refresh_tokens (user_uuid);
+CREATE INDEX idx_refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+-- owner_uuid is the user owning a record. Records created before users existed have
+-- no owner and are only accessible to admins.
+ALTER TABLE directories ADD COLUMN owner_uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN owner_uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE trash_entries ADD COLUMN owner_uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE uploads ADD COLUMN owner_uuid TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_directories_owner_uuid ON directories (owner_uuid);
+CREATE INDEX idx_files_owner_uuid ON files (owner_uuid);
+CREATE INDEX idx_trash_entries_owner_uuid ON trash_entries (owner_uuid);
@@ -0,0 +1,31 @@
+-- user_groups holds groups of users, which can be granted permissions together.
+CREATE TABLE user_groups (
+    uuid       TEXT PRIMARY KEY,
+    name       TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_group_members (
+    group_uuid TEXT NOT NULL REFERENCES user_groups (uuid),
+    user_uuid  TEXT NOT NULL REFERENCES users (uuid),
+    PRIMARY KEY (group_uuid, user_uuid)
+);
+
+CREATE INDEX idx_user_group_members_user_uuid ON user_group_members (user_uuid);
+
+-- item_uuid is not a foreign key, as it references either a file or a directory. Entries
+-- are removed together with their items. principal_uuid references either a user or a
+-- group, depending on principal_type. permissions is a bit set of models.Permissions,
+-- where 0 revokes the permissions inherited from the ancestors of the item.
+CREATE TABLE acl_entries (
+    item_uuid      TEXT NOT NULL,
+    item_type      TEXT NOT NULL,
+    principal_type TEXT NOT NULL,
+    principal_uuid TEXT NOT NULL,
+    permissions    INTEGER NOT NULL,
+    granted_by     TEXT NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (item_uuid, principal_type, principal_uuid)
+);
+
+CREATE INDEX idx_acl_entries_principal_uuid ON acl_entries (principal_uuid);
@@ -0,0 +1,26 @@
+-- search_name holds the lowercase words of a name, filled by the application, e.g.
+-- " annual report 2021 pdf ". Every word is surrounded by spaces, so words and phrases
+-- are matched with LIKE.
+ALTER TABLE directories ADD COLUMN search_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN search_name TEXT NOT NULL DEFAULT '';
+
+-- search_contents holds the lowercase words of the indexed text of a content in the same
+-- form as search_name. content_hash is not a foreign key, so the index can be filled after
+-- a blob is inserted. Entries are removed together with their blobs.
+CREATE TABLE search_contents (
+    content_hash TEXT PRIMARY KEY,
+    words        TEXT NOT NULL,
+    indexed_at   TIMESTAMPTZ NOT NULL
+);
+
+-- search_terms holds the number of occurrences of each word in an indexed content, capped
+-- by the application. term is compared bytewise, the same as in SQLite, so prefixes of
+-- words are matched with ranges.
+CREATE TABLE search_terms (
+    term         TEXT COLLATE "C" NOT NULL,
+    content_hash TEXT NOT NULL,
+    frequency    INTEGER NOT NULL,
+    PRIMARY KEY (term, content_hash)
+);
+
+CREATE INDEX idx_search_terms_content_hash ON search_terms (content_hash);
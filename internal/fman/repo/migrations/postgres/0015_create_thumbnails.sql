@@ -0,0 +1,15 @@
+-- thumbnails holds the scaled-down images of the versions of image files, which are removed
+-- together with their files.
+CREATE TABLE thumbnails (
+    file_uuid    TEXT NOT NULL REFERENCES files (uuid),
+    version      INTEGER NOT NULL,
+    size         TEXT NOT NULL,
+    storage_key  TEXT NOT NULL,
+    real_path    TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    width        INTEGER NOT NULL,
+    height       INTEGER NOT NULL,
+    file_size    BIGINT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (file_uuid, version, size)
+);
@@ -0,0 +1,12 @@
+-- name_key holds the natural sort key of a name, filled by the application.
+ALTER TABLE directories ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN name_key TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_directories_parent_uuid_name_key ON directories (parent_uuid, name_key, uuid);
+CREATE INDEX idx_directories_parent_uuid_created_at ON directories (parent_uuid, created_at, uuid);
+CREATE INDEX idx_directories_parent_uuid_updated_at ON directories (parent_uuid, updated_at, uuid);
+
+CREATE INDEX idx_files_parent_uuid_name_key ON files (parent_uuid, name_key, uuid);
+CREATE INDEX idx_files_parent_uuid_file_size ON files (parent_uuid, file_size, uuid);
+CREATE INDEX idx_files_parent_uuid_created_at ON files (parent_uuid, created_at, uuid);
+CREATE INDEX idx_files_parent_uuid_updated_at ON files (parent_uuid, updated_at, uuid);
@@ -0,0 +1,15 @@
+-- parent_uuid is not a foreign key, as the directory may be removed while the upload
+-- is in progress. It is validated again when the upload completes.
+CREATE TABLE uploads (
+    uuid          TEXT PRIMARY KEY,
+    filename      TEXT NOT NULL,
+    parent_uuid   TEXT NOT NULL,
+    upload_length INTEGER NOT NULL,
+    upload_offset INTEGER NOT NULL,
+    metadata      TEXT NOT NULL,
+    file_uuid     TEXT NOT NULL DEFAULT '',
+    created_at    DATETIME NOT NULL,
+    expires_at    DATETIME NOT NULL
+);
+
+CREATE INDEX idx_uploads_expires_at ON uploads (expires_at);
@@ -0,0 +1,21 @@
+-- blobs holds each distinct content once, keyed by its SHA-256 hash. ref_count is the
+-- number of file records referencing a blob, soft-removed ones included.
+CREATE TABLE blobs (
+    hash        TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL,
+    real_path   TEXT NOT NULL,
+    size        INTEGER NOT NULL,
+    ref_count   INTEGER NOT NULL,
+    created_at  DATETIME NOT NULL
+);
+
+ALTER TABLE files ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_files_content_hash ON files (content_hash);
+
+-- Content stored before deduplication is kept under the file UUID, so every existing
+-- file gets a blob of its own.
+INSERT INTO blobs (hash, storage_key, real_path, size, ref_count, created_at)
+SELECT 'legacy:' || uuid, uuid, real_path, file_size, 1, created_at FROM files;
+
+UPDATE files SET content_hash = 'legacy:' || uuid;
@@ -0,0 +1,25 @@
+-- file_versions holds every version of the content of a file, the current one included.
+-- From now on blobs are referenced by versions instead of files, so blobs.ref_count is
+-- the number of versions referencing a blob.
+CREATE TABLE file_versions (
+    file_uuid    TEXT NOT NULL,
+    version      INTEGER NOT NULL,
+    content_hash TEXT NOT NULL,
+    file_size    INTEGER NOT NULL,
+    uploaded_by  TEXT NOT NULL DEFAULT '',
+    created_at   DATETIME NOT NULL,
+    PRIMARY KEY (file_uuid, version)
+);
+
+CREATE INDEX idx_file_versions_content_hash ON file_versions (content_hash);
+
+-- version is the number of the current version. max_versions and max_version_age (in
+-- seconds) limit the retained old versions of a file, zero falls back to the global policy.
+ALTER TABLE files ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE files ADD COLUMN max_versions INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE files ADD COLUMN max_version_age INTEGER NOT NULL DEFAULT 0;
+
+-- The content of every existing file becomes its first version, which takes over the
+-- reference of the file to its blob.
+INSERT INTO file_versions (file_uuid, version, content_hash, file_size, created_at)
+SELECT uuid, 1, content_hash, file_size, updated_at FROM files;
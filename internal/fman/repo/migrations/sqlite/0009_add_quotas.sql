@@ -0,0 +1,29 @@
+-- used_bytes is the sum of the sizes of all versions of the files owned by a user, the
+-- recycle bin included. It is updated together with the versions. quota_bytes limits
+-- used_bytes, zero falls back to the default quota.
+ALTER TABLE users ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN used_bytes INTEGER NOT NULL DEFAULT 0;
+
+-- quota_bytes limits the sizes of all versions of the files in the subtree of a directory,
+-- zero means no limit.
+ALTER TABLE directories ADD COLUMN quota_bytes INTEGER NOT NULL DEFAULT 0;
+
+UPDATE users SET used_bytes = (
+    SELECT COALESCE(SUM(v.file_size), 0) FROM file_versions v JOIN files f ON f.uuid = v.file_uuid
+    WHERE f.owner_uuid = users.uuid
+);
+
+-- Reservations hold bytes of the quotas of an owner and of a directory and its ancestors
+-- while files are added. dir_uuid is not a foreign key, as the directory may be removed
+-- while the files are added. Reservations, which were not removed, e.g. by a crashed
+-- server, are ignored after expires_at.
+CREATE TABLE quota_reservations (
+    uuid       TEXT PRIMARY KEY,
+    owner_uuid TEXT NOT NULL,
+    dir_uuid   TEXT NOT NULL,
+    size       INTEGER NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_quota_reservations_owner_uuid ON quota_reservations (owner_uuid);
+CREATE INDEX idx_quota_reservations_dir_uuid ON quota_reservations (dir_uuid);
@@ -0,0 +1,19 @@
+-- item_uuid is not a foreign key, as the shared file/dir may be removed while the link
+-- exists. Links to removed items cannot be used anymore.
+-- password_hash is empty for links without a password, expires_at is NULL for links
+-- which do not expire, and max_downloads is 0 for links without a download limit.
+CREATE TABLE share_links (
+    uuid          TEXT PRIMARY KEY,
+    token         TEXT NOT NULL UNIQUE,
+    item_uuid     TEXT NOT NULL,
+    item_type     TEXT NOT NULL,
+    owner_uuid    TEXT NOT NULL,
+    password_hash TEXT NOT NULL DEFAULT '',
+    mode          TEXT NOT NULL,
+    max_downloads INTEGER NOT NULL DEFAULT 0,
+    downloads     INTEGER NOT NULL DEFAULT 0,
+    expires_at    DATETIME,
+    created_at    DATETIME NOT NULL
+);
+
+CREATE INDEX idx_share_links_owner_uuid ON share_links (owner_uuid);
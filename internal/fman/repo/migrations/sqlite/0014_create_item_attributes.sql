@@ -0,0 +1,21 @@
+-- item_uuid is not a foreign key, as it references either a file or a directory. Tags and
+-- metadata are removed together with their items. tag is lowercase, and search_tag holds
+-- its words in the same form as search_name.
+CREATE TABLE item_tags (
+    item_uuid  TEXT NOT NULL,
+    item_type  TEXT NOT NULL,
+    tag        TEXT NOT NULL,
+    search_tag TEXT NOT NULL,
+    PRIMARY KEY (item_uuid, tag)
+);
+
+CREATE INDEX idx_item_tags_tag ON item_tags (tag);
+
+CREATE TABLE item_metadata (
+    item_uuid  TEXT NOT NULL,
+    item_type  TEXT NOT NULL,
+    meta_key   TEXT NOT NULL,
+    meta_value TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (item_uuid, meta_key)
+);
@@ -0,0 +1,4 @@
+-- content_type is the MIME type of the content of a version detected on upload, and the one
+-- of the current version for files. It stays empty for content uploaded before.
+ALTER TABLE file_versions ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+ALTER TABLE files ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
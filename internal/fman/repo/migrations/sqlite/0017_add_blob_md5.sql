@@ -0,0 +1,3 @@
+-- md5 is the hex-encoded MD5 digest of the content of a blob, next to its SHA-256 hash. It
+-- stays empty for content stored before, until the same content is stored again.
+ALTER TABLE blobs ADD COLUMN md5 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+-- stored_size is the number of bytes the content of a blob takes up in the storage, which
+-- is less than its size if it is compressed. Content stored before was stored as it is.
+ALTER TABLE blobs ADD COLUMN stored_size INTEGER NOT NULL DEFAULT 0;
+
+UPDATE blobs SET stored_size = size;
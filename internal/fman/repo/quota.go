@@ -0,0 +1,297 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// addUsage adds numbers of bytes, which may be negative, to the bytes used by users in DB.
+// Records without an owner, or whose owner is not a user, are not counted.
+func (r *sqlRepo) addUsage(tx *sql.Tx, usage map[string]int64) error {
+	// Users are locked in the same order by every transaction.
+	userUUIDs := make([]string, 0, len(usage))
+	for userUUID, delta := range usage {
+		if userUUID != "" && delta != 0 {
+			userUUIDs = append(userUUIDs, userUUID)
+		}
+	}
+	sort.Strings(userUUIDs)
+	for _, userUUID := range userUUIDs {
+		_, err := tx.Exec(r.q("UPDATE users SET used_bytes = used_bytes + ? WHERE uuid = ?"), usage[userUUID], userUUID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadUserQuotaRecord reads the quota of a user and the bytes used by the user from DB.
+func (r *sqlRepo) ReadUserQuotaRecord(userUUID string) (models.Quota, error) {
+	var quota models.Quota
+	err := r.db.QueryRow(r.q("SELECT quota_bytes, used_bytes FROM users WHERE uuid = ?"), userUUID).
+		Scan(&quota.LimitBytes, &quota.UsedBytes)
+	if err == sql.ErrNoRows {
+		return models.Quota{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("user %s does not exist", userUUID))
+	}
+	return quota, err
+}
+
+// UpdateUserQuotaLimit sets the quota of a user in DB.
+func (r *sqlRepo) UpdateUserQuotaLimit(userUUID string, limit int64) error {
+	res, err := r.db.Exec(r.q("UPDATE users SET quota_bytes = ? WHERE uuid = ?"), limit, userUUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("user %s does not exist", userUUID))
+}
+
+// UpdateDirQuotaLimit sets the quota of a directory record, which is not soft-removed, in DB.
+func (r *sqlRepo) UpdateDirQuotaLimit(dirUUID string, limit int64) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var parentUUID sql.NullString
+		err := tx.QueryRow(r.q("SELECT parent_uuid FROM directories WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), dirUUID).
+			Scan(&parentUUID)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", dirUUID))
+		}
+		if err != nil {
+			return err
+		}
+		if !parentUUID.Valid {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot have a quota")
+		}
+		_, err = tx.Exec(r.q("UPDATE directories SET quota_bytes = ? WHERE uuid = ?"), limit, dirUUID)
+		return err
+	})
+}
+
+// ListAncestorDirQuotaRecords lists the quotas of a directory record and its ancestors from
+// DB, nearest first.
+func (r *sqlRepo) ListAncestorDirQuotaRecords(dirUUID string) ([]models.DirQuota, error) {
+	return r.listDirQuotas(ancestorDirQuotasQuery, dirUUID)
+}
+
+// ancestorDirQuotasQuery selects the quotas of a directory and its ancestors, which have
+// one, nearest first.
+const ancestorDirQuotasQuery = `WITH RECURSIVE ancestors(uuid, depth) AS (
+		SELECT CAST(? AS TEXT), 0
+		UNION ALL
+		SELECT d.parent_uuid, a.depth + 1 FROM directories d JOIN ancestors a ON d.uuid = a.uuid
+		WHERE d.parent_uuid IS NOT NULL
+	)
+	SELECT d.uuid, d.path, d.quota_bytes FROM directories d JOIN ancestors a ON a.uuid = d.uuid
+	WHERE d.quota_bytes > 0 AND d.is_deleted = FALSE ORDER BY a.depth`
+
+// ListOwnerDirQuotaRecords lists the quotas of an owner's directory records from DB, ordered
+// by path.
+func (r *sqlRepo) ListOwnerDirQuotaRecords(ownerUUID string) ([]models.DirQuota, error) {
+	return r.listDirQuotas(`SELECT uuid, path, quota_bytes FROM directories
+		WHERE owner_uuid = ? AND quota_bytes > 0 AND is_deleted = FALSE ORDER BY path`, ownerUUID)
+}
+
+// listDirQuotas lists the directories with a quota selected by a query together with the
+// bytes used by the files in their subtrees.
+func (r *sqlRepo) listDirQuotas(query string, args ...interface{}) ([]models.DirQuota, error) {
+	var quotas []models.DirQuota
+	err := r.withTx(func(tx *sql.Tx) error {
+		var err error
+		if quotas, err = r.readDirQuotas(tx, query, args...); err != nil {
+			return err
+		}
+		for i := range quotas {
+			if quotas[i].UsedBytes, err = r.dirUsedBytes(tx, quotas[i].DirUUID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// readDirQuotas reads the directories with a quota selected by a query, without the bytes
+// used.
+func (r *sqlRepo) readDirQuotas(tx *sql.Tx, query string, args ...interface{}) ([]models.DirQuota, error) {
+	rows, err := tx.Query(r.q(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var quotas []models.DirQuota
+	for rows.Next() {
+		var quota models.DirQuota
+		if err := rows.Scan(&quota.DirUUID, &quota.Path, &quota.LimitBytes); err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, rows.Err()
+}
+
+// dirUsedBytes returns the bytes used by the files in the subtree of a directory, which are
+// not soft-removed.
+func (r *sqlRepo) dirUsedBytes(tx *sql.Tx, dirUUID string) (int64, error) {
+	var used int64
+	err := tx.QueryRow(r.q(subtreeCTE+`SELECT COALESCE(SUM(v.file_size), 0)
+		FROM file_versions v JOIN files f ON f.uuid = v.file_uuid
+		WHERE f.parent_uuid IN (SELECT uuid FROM subtree) AND f.is_deleted = FALSE`), dirUUID).
+		Scan(&used)
+	return used, err
+}
+
+// ReserveQuotaRecord inserts a reservation of bytes of the quotas of an owner and of a
+// directory record and its ancestors to DB.
+func (r *sqlRepo) ReserveQuotaRecord(reservation models.QuotaReservation, defaultLimit int64) error {
+	now := time.Now().UTC()
+	return r.withTx(func(tx *sql.Tx) error {
+		available := int64(-1)
+		dirQuotas, err := r.readDirQuotas(tx, ancestorDirQuotasQuery, reservation.DirUUID)
+		if err != nil {
+			return err
+		}
+		// The directories are locked before the owner, as by inserting files, and in the
+		// same order by every transaction.
+		sort.Slice(dirQuotas, func(i, j int) bool { return dirQuotas[i].DirUUID < dirQuotas[j].DirUUID })
+		for _, dirQuota := range dirQuotas {
+			var limit int64
+			err := tx.QueryRow(r.q("SELECT quota_bytes FROM directories WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause),
+				dirQuota.DirUUID).Scan(&limit)
+			if err == sql.ErrNoRows || (err == nil && limit <= 0) {
+				// The quota was removed in the meantime.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			used, err := r.dirUsedBytes(tx, dirQuota.DirUUID)
+			if err != nil {
+				return err
+			}
+			var reserved int64
+			err = tx.QueryRow(r.q(subtreeCTE+`SELECT COALESCE(SUM(size), 0) FROM quota_reservations
+				WHERE dir_uuid IN (SELECT uuid FROM subtree) AND expires_at > ?`), dirQuota.DirUUID, now).
+				Scan(&reserved)
+			if err != nil {
+				return err
+			}
+			available = minAvailable(available, limit-used-reserved)
+		}
+		var limit, used int64
+		err = tx.QueryRow(r.q("SELECT quota_bytes, used_bytes FROM users WHERE uuid = ?"+r.dialect.lockClause), reservation.OwnerUUID).
+			Scan(&limit, &used)
+		switch {
+		case err == sql.ErrNoRows:
+			// Records created before users existed are not counted.
+		case err != nil:
+			return err
+		default:
+			if limit == 0 {
+				limit = defaultLimit
+			}
+			if limit > 0 {
+				var reserved int64
+				err := tx.QueryRow(r.q("SELECT COALESCE(SUM(size), 0) FROM quota_reservations WHERE owner_uuid = ? AND expires_at > ?"),
+					reservation.OwnerUUID, now).Scan(&reserved)
+				if err != nil {
+					return err
+				}
+				available = minAvailable(available, limit-used-reserved)
+			}
+		}
+		if available >= 0 && reservation.Size > available {
+			return quotaExceeded(available)
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM quota_reservations WHERE expires_at <= ?"), now); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("INSERT INTO quota_reservations (uuid, owner_uuid, dir_uuid, size, expires_at) VALUES (?, ?, ?, ?, ?)"),
+			reservation.UUID, reservation.OwnerUUID, reservation.DirUUID, reservation.Size, reservation.ExpiresAt.UTC())
+		return err
+	})
+}
+
+// RemoveQuotaReservationRecord removes a reservation from DB.
+func (r *sqlRepo) RemoveQuotaReservationRecord(UUID string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM quota_reservations WHERE uuid = ?"), UUID)
+	return err
+}
+
+// minAvailable returns the smaller of two numbers of available bytes, where -1 means no
+// limit. Negative numbers of a quota, which is already exceeded, count as zero.
+func minAvailable(available, n int64) int64 {
+	if n < 0 {
+		n = 0
+	}
+	if available < 0 || n < available {
+		return n
+	}
+	return available
+}
+
+// quotaExceeded returns the error of a reservation, which does not fit into the available
+// bytes of a quota.
+func quotaExceeded(available int64) error {
+	return models.NewFManError(models.QuotaExceededErrorCode, fmt.Sprintf("storage quota exceeded, %d bytes available", available))
+}
+
+// RecomputeUsageRecords recounts the bytes used by every user in DB.
+func (r *sqlRepo) RecomputeUsageRecords() ([]models.UsageCorrection, error) {
+	var corrections []models.UsageCorrection
+	err := r.withTx(func(tx *sql.Tx) error {
+		// Counting waits for transactions which already changed the bytes used, and the
+		// changes of later transactions are added to the recounted bytes.
+		if _, err := tx.Exec(r.q("SELECT uuid FROM users ORDER BY uuid" + r.dialect.lockClause)); err != nil {
+			return err
+		}
+		rows, err := tx.Query(r.q(`SELECT u.uuid, u.used_bytes, COALESCE(SUM(v.file_size), 0)
+			FROM users u LEFT JOIN files f ON f.owner_uuid = u.uuid LEFT JOIN file_versions v ON v.file_uuid = f.uuid
+			GROUP BY u.uuid, u.used_bytes ORDER BY u.uuid`))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var c models.UsageCorrection
+			if err := rows.Scan(&c.UserUUID, &c.OldUsedBytes, &c.NewUsedBytes); err != nil {
+				rows.Close()
+				return err
+			}
+			if c.OldUsedBytes != c.NewUsedBytes {
+				corrections = append(corrections, c)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, c := range corrections {
+			_, err := tx.Exec(r.q("UPDATE users SET used_bytes = ? WHERE uuid = ?"), c.NewUsedBytes, c.UserUUID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return corrections, nil
+}
+
+// ReadStorageStatsRecord counts the stored contents in DB.
+func (r *sqlRepo) ReadStorageStatsRecord() (models.StorageStats, error) {
+	var stats models.StorageStats
+	err := r.db.QueryRow(r.q(`SELECT COUNT(*), COALESCE(SUM(CASE WHEN stored_size < size THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(size), 0), COALESCE(SUM(stored_size), 0) FROM blobs`)).
+		Scan(&stats.Blobs, &stats.CompressedBlobs, &stats.LogicalBytes, &stats.StoredBytes)
+	if err != nil {
+		return models.StorageStats{}, err
+	}
+	stats.SavedBytes = stats.LogicalBytes - stats.StoredBytes
+	return stats, nil
+}
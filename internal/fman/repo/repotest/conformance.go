@@ -0,0 +1,1662 @@
+// Package repotest provides a conformance test suite which every implementation of
+// the fman repository interfaces is expected to pass. An implementation runs it from
+// its own test file, e.g.:
+//
+//	func TestFManMemoryRepo(t *testing.T) {
+//		repotest.RunConformanceTests(t, func(t *testing.T) repotest.Repository {
+//			return repo.NewFManMemoryRepo()
+//		})
+//	}
+package repotest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/auth"
+	"github.com/nvthongswansea/xtreme/internal/fman"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// Repository is the set of repository interfaces under test.
+type Repository interface {
+	fman.FManFileDBRepo
+	fman.FManDirDBRepo
+	fman.FManValidateDBRepo
+	fman.FManTrashDBRepo
+	fman.FManUploadDBRepo
+	fman.FManVersionDBRepo
+	fman.FManQuotaDBRepo
+	fman.FManShareDBRepo
+	fman.FManACLDBRepo
+	fman.FManSearchDBRepo
+	fman.FManAttributeDBRepo
+	fman.FManThumbnailDBRepo
+	auth.AuthUserDBRepo
+	auth.AuthTokenDBRepo
+}
+
+// testOwnerUUID is the owner of the records inserted by the tests.
+const testOwnerUUID = "owner-1"
+
+// NewRepoFunc returns a new, empty repository which contains only the root directory.
+type NewRepoFunc func(t *testing.T) Repository
+
+// RunConformanceTests runs the conformance test suite against repositories created by newRepo.
+// Each subtest gets its own repository.
+func RunConformanceTests(t *testing.T, newRepo NewRepoFunc) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, r Repository)
+	}{
+		{"RootDirExists", testRootDirExists},
+		{"InsertAndReadFile", testInsertAndReadFile},
+		{"InsertAndReadDir", testInsertAndReadDir},
+		{"ReadNonExistent", testReadNonExistent},
+		{"ParentMustExist", testParentMustExist},
+		{"NameUniquePerParent", testNameUniquePerParent},
+		{"SoftRemoveVisibility", testSoftRemoveVisibility},
+		{"UpdateFile", testUpdateFile},
+		{"UpdateDirRewritesDescendantPaths", testUpdateDirRewritesDescendantPaths},
+		{"UpdateDirWithMultiByteNames", testUpdateDirWithMultiByteNames},
+		{"UpdateDirRejectsCycles", testUpdateDirRejectsCycles},
+		{"HardRemove", testHardRemove},
+		{"RootDirIsProtected", testRootDirIsProtected},
+		{"ListDirNaturalOrder", testListDirNaturalOrder},
+		{"ListDirPagination", testListDirPagination},
+		{"ListDirSortAndFilter", testListDirSortAndFilter},
+		{"TrashAndRestoreFile", testTrashAndRestoreFile},
+		{"TrashAndRestoreDirSubtree", testTrashAndRestoreDirSubtree},
+		{"RestoreConflicts", testRestoreConflicts},
+		{"HardRemoveTrash", testHardRemoveTrash},
+		{"ListTrash", testListTrash},
+		{"UploadRecords", testUploadRecords},
+		{"BlobRefCounts", testBlobRefCounts},
+		{"FileVersions", testFileVersions},
+		{"Users", testUsers},
+		{"RefreshTokens", testRefreshTokens},
+		{"Quotas", testQuotas},
+		{"QuotaReservations", testQuotaReservations},
+		{"ShareLinks", testShareLinks},
+		{"GroupsAndACL", testGroupsAndACL},
+		{"Search", testSearch},
+		{"Attributes", testAttributes},
+		{"Thumbnails", testThumbnails},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newRepo(t))
+		})
+	}
+}
+
+func testRootDirExists(t *testing.T, r Repository) {
+	ok, err := r.IsParentUUIDExist(models.RootDirUUID)
+	mustNotFail(t, err)
+	if !ok {
+		t.Fatal("root directory does not exist")
+	}
+	root, err := r.ReadDirRecord(models.RootDirUUID)
+	mustNotFail(t, err)
+	if root.Path != models.RootDirPath {
+		t.Errorf("root path = %q, want %q", root.Path, models.RootDirPath)
+	}
+	if root.ParentUUID != "" {
+		t.Errorf("root parent UUID = %q, want empty", root.ParentUUID)
+	}
+}
+
+func testInsertAndReadFile(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 42))
+	file, err := r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.UUID != "file-1" || file.Filename != "f.txt" || file.ParentUUID != "dir-a" ||
+		file.OwnerUUID != testOwnerUUID || file.RealPath != "/storage/file-1" || file.FileSize != 42 {
+		t.Errorf("unexpected file record %+v", file)
+	}
+	if file.Path != "/a/f.txt" {
+		t.Errorf("file path = %q, want %q", file.Path, "/a/f.txt")
+	}
+	if file.CreatedAt.IsZero() || file.UpdatedAt.IsZero() {
+		t.Errorf("timestamps are not set: %+v", file)
+	}
+	err = insertFile(r, "file-1", "other.txt", "dir-a", "/storage/file-1", 1)
+	mustFailWithCode(t, err, models.AlreadyExistErrorCode)
+}
+
+func testInsertAndReadDir(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	dir, err := r.ReadDirRecord("dir-b")
+	mustNotFail(t, err)
+	if dir.UUID != "dir-b" || dir.Dirname != "b" || dir.ParentUUID != "dir-a" || dir.OwnerUUID != testOwnerUUID {
+		t.Errorf("unexpected directory record %+v", dir)
+	}
+	if dir.Path != "/a/b" {
+		t.Errorf("directory path = %q, want %q", dir.Path, "/a/b")
+	}
+	dir, err = r.ReadDirRecordByName("b", "dir-a")
+	mustNotFail(t, err)
+	if dir.UUID != "dir-b" || dir.Path != "/a/b" {
+		t.Errorf("unexpected directory record %+v", dir)
+	}
+	_, err = r.ReadDirRecordByName("b", models.RootDirUUID)
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	ok, err := r.IsParentUUIDExist("dir-b")
+	mustNotFail(t, err)
+	if !ok {
+		t.Error("dir-b should be a valid parent")
+	}
+}
+
+func testReadNonExistent(t *testing.T, r Repository) {
+	_, err := r.ReadFileRecord("missing")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	_, err = r.ReadDirRecord("missing")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	mustFailWithCode(t, r.SoftRemoveFileRecord("missing", "trash-1"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.SoftRemoveDirRecord("missing", "trash-2"), models.NotFoundErrorCode)
+	mustFailWithCode(t, hardRemoveFile(r, "missing"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.HardRemoveDirRecord("missing"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.UpdateFileRecord("missing", "x", models.RootDirUUID), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.UpdateDirRecord("missing", "x", models.RootDirUUID), models.NotFoundErrorCode)
+}
+
+func testParentMustExist(t *testing.T, r Repository) {
+	ok, err := r.IsParentUUIDExist("missing")
+	mustNotFail(t, err)
+	if ok {
+		t.Error("missing directory should not be a valid parent")
+	}
+	mustFailWithCode(t, insertFile(r, "file-1", "f.txt", "missing", "/storage/file-1", 1), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.InsertDirRecord("dir-a", "a", "missing", testOwnerUUID), models.NotFoundErrorCode)
+	// A file is not a valid parent.
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", models.RootDirUUID, "/storage/file-1", 1))
+	mustFailWithCode(t, r.InsertDirRecord("dir-a", "a", "file-1", testOwnerUUID), models.NotFoundErrorCode)
+}
+
+func testNameUniquePerParent(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "x", models.RootDirUUID, "/storage/file-1", 1))
+	// Files and directories share the same namespace in a parent.
+	mustFailWithCode(t, insertFile(r, "file-2", "x", models.RootDirUUID, "/storage/file-2", 1), models.AlreadyExistErrorCode)
+	mustFailWithCode(t, r.InsertDirRecord("dir-x", "x", models.RootDirUUID, testOwnerUUID), models.AlreadyExistErrorCode)
+	mustFailWithCode(t, insertFile(r, "file-2", "a", models.RootDirUUID, "/storage/file-2", 1), models.AlreadyExistErrorCode)
+	// The same name is fine in another parent.
+	mustNotFail(t, insertFile(r, "file-2", "x", "dir-a", "/storage/file-2", 1))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "a", "dir-a", testOwnerUUID))
+
+	for _, tc := range []struct {
+		name, parentUUID string
+		want             bool
+	}{
+		{"x", models.RootDirUUID, true},
+		{"a", models.RootDirUUID, true},
+		{"a", "dir-a", true},
+		{"y", models.RootDirUUID, false},
+		{"x", "dir-b", false},
+	} {
+		got, err := r.IsNameExist(tc.name, tc.parentUUID)
+		mustNotFail(t, err)
+		if got != tc.want {
+			t.Errorf("IsNameExist(%q, %q) = %v, want %v", tc.name, tc.parentUUID, got, tc.want)
+		}
+	}
+}
+
+func testSoftRemoveVisibility(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	_, err := r.ReadFileRecord("file-1")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	mustFailWithCode(t, r.SoftRemoveFileRecord("file-1", "trash-2"), models.NotFoundErrorCode)
+	ok, err := r.IsNameExist("f.txt", "dir-a")
+	mustNotFail(t, err)
+	if ok {
+		t.Error("soft-removed file name should be available")
+	}
+	mustNotFail(t, insertFile(r, "file-2", "f.txt", "dir-a", "/storage/file-2", 1))
+
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-3"))
+	_, err = r.ReadDirRecord("dir-a")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	ok, err = r.IsParentUUIDExist("dir-a")
+	mustNotFail(t, err)
+	if ok {
+		t.Error("soft-removed directory should not be a valid parent")
+	}
+	mustFailWithCode(t, insertFile(r, "file-3", "g.txt", "dir-a", "/storage/file-3", 1), models.NotFoundErrorCode)
+	mustNotFail(t, r.InsertDirRecord("dir-a2", "a", models.RootDirUUID, testOwnerUUID))
+}
+
+func testUpdateFile(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", models.RootDirUUID, "/storage/file-1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "g.txt", "dir-a", "/storage/file-2", 1))
+
+	// Rename in place.
+	mustNotFail(t, r.UpdateFileRecord("file-1", "h.txt", models.RootDirUUID))
+	file, err := r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.Filename != "h.txt" || file.Path != "/h.txt" {
+		t.Errorf("unexpected renamed file %+v", file)
+	}
+	// Keeping the same name is not a conflict with itself.
+	mustNotFail(t, r.UpdateFileRecord("file-1", "h.txt", models.RootDirUUID))
+	// Move into a directory with a conflicting name.
+	mustFailWithCode(t, r.UpdateFileRecord("file-1", "g.txt", "dir-a"), models.AlreadyExistErrorCode)
+	mustFailWithCode(t, r.UpdateFileRecord("file-1", "h.txt", "missing"), models.NotFoundErrorCode)
+	// Move into a directory.
+	mustNotFail(t, r.UpdateFileRecord("file-1", "h.txt", "dir-a"))
+	file, err = r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.ParentUUID != "dir-a" || file.Path != "/a/h.txt" || file.RealPath != "/storage/file-1" {
+		t.Errorf("unexpected moved file %+v", file)
+	}
+}
+
+func testUpdateDirRewritesDescendantPaths(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-c", "c", "dir-b", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "g.txt", "dir-c", "/storage/file-2", 1))
+	mustNotFail(t, r.InsertDirRecord("dir-x", "x", models.RootDirUUID, testOwnerUUID))
+	// A sibling whose name shares a prefix must stay untouched.
+	mustNotFail(t, r.InsertDirRecord("dir-ab", "ab", models.RootDirUUID, testOwnerUUID))
+
+	mustNotFail(t, r.UpdateDirRecord("dir-a", "renamed", "dir-x"))
+
+	wantDirs := map[string]string{
+		"dir-a":  "/x/renamed",
+		"dir-b":  "/x/renamed/b",
+		"dir-c":  "/x/renamed/b/c",
+		"dir-ab": "/ab",
+	}
+	for uuid, want := range wantDirs {
+		dir, err := r.ReadDirRecord(uuid)
+		mustNotFail(t, err)
+		if dir.Path != want {
+			t.Errorf("path of %s = %q, want %q", uuid, dir.Path, want)
+		}
+	}
+	wantFiles := map[string]string{
+		"file-1": "/x/renamed/f.txt",
+		"file-2": "/x/renamed/b/c/g.txt",
+	}
+	for uuid, want := range wantFiles {
+		file, err := r.ReadFileRecord(uuid)
+		mustNotFail(t, err)
+		if file.Path != want {
+			t.Errorf("path of %s = %q, want %q", uuid, file.Path, want)
+		}
+	}
+	mustFailWithCode(t, r.UpdateDirRecord("dir-ab", "x", models.RootDirUUID), models.AlreadyExistErrorCode)
+}
+
+// testUpdateDirWithMultiByteNames checks that the paths of descendants are rewritten by
+// characters, not bytes, when a directory with a multi-byte name is renamed.
+func testUpdateDirWithMultiByteNames(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "Ördner", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "日本語", "dir-a", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "ä.txt", "dir-b", "/storage/file-1", 1))
+
+	mustNotFail(t, r.UpdateDirRecord("dir-a", "Größe", models.RootDirUUID))
+
+	dir, err := r.ReadDirRecord("dir-b")
+	mustNotFail(t, err)
+	if dir.Path != "/Größe/日本語" {
+		t.Errorf("path of dir-b = %q, want %q", dir.Path, "/Größe/日本語")
+	}
+	file, err := r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.Path != "/Größe/日本語/ä.txt" {
+		t.Errorf("path of file-1 = %q, want %q", file.Path, "/Größe/日本語/ä.txt")
+	}
+}
+
+func testUpdateDirRejectsCycles(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-c", "c", "dir-b", testOwnerUUID))
+
+	mustFailWithCode(t, r.UpdateDirRecord("dir-a", "a", "dir-a"), models.InvalidArgumentErrorCode)
+	mustFailWithCode(t, r.UpdateDirRecord("dir-a", "a", "dir-c"), models.InvalidArgumentErrorCode)
+	dir, err := r.ReadDirRecord("dir-a")
+	mustNotFail(t, err)
+	if dir.ParentUUID != models.RootDirUUID || dir.Path != "/a" {
+		t.Errorf("rejected move changed the directory: %+v", dir)
+	}
+	// Moving a descendant up is fine.
+	mustNotFail(t, r.UpdateDirRecord("dir-c", "c", models.RootDirUUID))
+	mustNotFail(t, r.UpdateDirRecord("dir-a", "a", "dir-c"))
+	dir, err = r.ReadDirRecord("dir-b")
+	mustNotFail(t, err)
+	if dir.Path != "/c/a/b" {
+		t.Errorf("path of dir-b = %q, want %q", dir.Path, "/c/a/b")
+	}
+}
+
+func testHardRemove(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+
+	mustFailWithCode(t, r.HardRemoveDirRecord("dir-a"), models.InvalidArgumentErrorCode)
+	// Soft-removed records can be hard-removed as well.
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	mustNotFail(t, hardRemoveFile(r, "file-1"))
+	mustFailWithCode(t, hardRemoveFile(r, "file-1"), models.NotFoundErrorCode)
+	mustNotFail(t, r.HardRemoveDirRecord("dir-a"))
+	_, err := r.ReadDirRecord("dir-a")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	// UUIDs of hard-removed records can be reused.
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+}
+
+func testRootDirIsProtected(t *testing.T, r Repository) {
+	mustFailWithCode(t, r.UpdateDirRecord(models.RootDirUUID, "x", models.RootDirUUID), models.InvalidArgumentErrorCode)
+	mustFailWithCode(t, r.SoftRemoveDirRecord(models.RootDirUUID, "trash-1"), models.InvalidArgumentErrorCode)
+	mustFailWithCode(t, r.HardRemoveDirRecord(models.RootDirUUID), models.InvalidArgumentErrorCode)
+}
+
+func testListDirNaturalOrder(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	for i, name := range []string{"file10.txt", "File2.txt", "file1.txt", "file02b.txt", "a.txt"} {
+		mustNotFail(t, insertFile(r, fmt.Sprintf("file-%d", i), name, "dir-a", "/storage", 1))
+	}
+	mustNotFail(t, r.InsertDirRecord("dir-z", "z", "dir-a", testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "B", "dir-a", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-deleted", "b.txt", "dir-a", "/storage", 1))
+	mustNotFail(t, r.SoftRemoveFileRecord("file-deleted", "trash-1"))
+
+	dir, next, err := r.ListDirRecord("dir-a", models.DirListOptions{})
+	mustNotFail(t, err)
+	if dir.UUID != "dir-a" || dir.Path != "/a" {
+		t.Errorf("unexpected directory %+v", dir)
+	}
+	if next != "" {
+		t.Errorf("next cursor = %q, want empty", next)
+	}
+	assertNames(t, listedNames(dir), []string{"B", "z", "a.txt", "file1.txt", "File2.txt", "file02b.txt", "file10.txt"})
+
+	dir, _, err = r.ListDirRecord("dir-a", models.DirListOptions{Desc: true})
+	mustNotFail(t, err)
+	assertNames(t, listedNames(dir), []string{"z", "B", "file10.txt", "file02b.txt", "File2.txt", "file1.txt", "a.txt"})
+
+	_, _, err = r.ListDirRecord("missing", models.DirListOptions{})
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+}
+
+func testListDirPagination(t *testing.T, r Repository) {
+	var want []string
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("d%d", i)
+		mustNotFail(t, r.InsertDirRecord("dir-"+name, name, models.RootDirUUID, testOwnerUUID))
+		want = append(want, name)
+	}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%d", i)
+		mustNotFail(t, insertFile(r, "file-"+name, name, models.RootDirUUID, "/storage", int64(i%4)))
+		want = append(want, name)
+	}
+	for _, sortBy := range []string{models.SortByName, models.SortBySize, models.SortByCreatedAt, models.SortByUpdatedAt} {
+		for _, desc := range []bool{false, true} {
+			opts := models.DirListOptions{SortBy: sortBy, Desc: desc, Limit: 4}
+			var got []string
+			pages := 0
+			for {
+				dir, next, err := r.ListDirRecord(models.RootDirUUID, opts)
+				mustNotFail(t, err)
+				if n := len(dir.ListOfDirs) + len(dir.ListOfFiles); n > opts.Limit {
+					t.Fatalf("page has %d entries, limit is %d", n, opts.Limit)
+				}
+				got = append(got, listedNames(dir)...)
+				pages++
+				if next == "" {
+					break
+				}
+				if pages > len(want) {
+					t.Fatal("pagination does not terminate")
+				}
+				opts.Cursor = next
+			}
+			if pages != 6 {
+				t.Errorf("sort %s desc %v: got %d pages, want 6", sortBy, desc, pages)
+			}
+			assertSameNames(t, got, want)
+		}
+	}
+	_, _, err := r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Cursor: "garbage"})
+	mustFailWithCode(t, err, models.InvalidArgumentErrorCode)
+	_, next, err := r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Limit: 1})
+	mustNotFail(t, err)
+	// A cursor cannot be reused with other sort options.
+	_, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Limit: 1, Desc: true, Cursor: next})
+	mustFailWithCode(t, err, models.InvalidArgumentErrorCode)
+}
+
+func testListDirSortAndFilter(t *testing.T, r Repository) {
+	mustNotFail(t, insertFile(r, "file-1", "big.bin", models.RootDirUUID, "/storage", 300))
+	mustNotFail(t, insertFile(r, "file-2", "small.bin", models.RootDirUUID, "/storage", 1))
+	mustNotFail(t, insertFile(r, "file-3", "medium.bin", models.RootDirUUID, "/storage", 20))
+	mustNotFail(t, r.InsertDirRecord("dir-1", "bin", models.RootDirUUID, testOwnerUUID))
+
+	dir, _, err := r.ListDirRecord(models.RootDirUUID, models.DirListOptions{SortBy: models.SortBySize})
+	mustNotFail(t, err)
+	assertNames(t, listedNames(dir), []string{"bin", "small.bin", "medium.bin", "big.bin"})
+
+	dir, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Type: models.EntryTypeFile, NamePrefix: "b"})
+	mustNotFail(t, err)
+	assertNames(t, listedNames(dir), []string{"big.bin"})
+
+	// The prefix matches case-sensitively, and a digit run at its end may continue.
+	mustNotFail(t, insertFile(r, "file-4", "Part1.txt", models.RootDirUUID, "/storage", 1))
+	mustNotFail(t, insertFile(r, "file-5", "part12.txt", models.RootDirUUID, "/storage", 1))
+	mustNotFail(t, insertFile(r, "file-6", "part2.txt", models.RootDirUUID, "/storage", 1))
+	mustNotFail(t, insertFile(r, "file-7", "part1.txt", models.RootDirUUID, "/storage", 1))
+	for prefix, want := range map[string][]string{
+		"part1": {"part1.txt", "part12.txt"},
+		"Part":  {"Part1.txt"},
+		"1":     {},
+	} {
+		dir, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{NamePrefix: prefix})
+		mustNotFail(t, err)
+		assertNames(t, listedNames(dir), want)
+	}
+
+	dir, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Type: models.EntryTypeDir})
+	mustNotFail(t, err)
+	assertNames(t, listedNames(dir), []string{"bin"})
+
+	_, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{SortBy: "color"})
+	mustFailWithCode(t, err, models.InvalidArgumentErrorCode)
+	_, _, err = r.ListDirRecord(models.RootDirUUID, models.DirListOptions{Type: "link"})
+	mustFailWithCode(t, err, models.InvalidArgumentErrorCode)
+}
+
+// listedNames returns the names of the listed children, directories first.
+func testTrashAndRestoreFile(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	entry, err := r.ReadTrashRecord("trash-1")
+	mustNotFail(t, err)
+	if entry.ItemUUID != "file-1" || entry.ItemType != models.EntryTypeFile || entry.Name != "f.txt" ||
+		entry.OriginalParentUUID != "dir-a" || entry.OriginalPath != "/a/f.txt" || entry.DeletedAt.IsZero() {
+		t.Errorf("unexpected recycle bin entry %+v", entry)
+	}
+	// Restore with another name into another directory.
+	mustNotFail(t, r.RestoreTrashRecord("trash-1", "g.txt", models.RootDirUUID))
+	file, err := r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.Filename != "g.txt" || file.ParentUUID != models.RootDirUUID || file.Path != "/g.txt" {
+		t.Errorf("unexpected restored file %+v", file)
+	}
+	_, err = r.ReadTrashRecord("trash-1")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	mustFailWithCode(t, r.RestoreTrashRecord("trash-1", "g.txt", models.RootDirUUID), models.NotFoundErrorCode)
+}
+
+func testTrashAndRestoreDirSubtree(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "g.txt", "dir-b", "/storage/file-2", 1))
+	mustNotFail(t, insertFile(r, "file-3", "h.txt", "dir-b", "/storage/file-3", 1))
+	mustNotFail(t, r.InsertDirRecord("dir-x", "x", models.RootDirUUID, testOwnerUUID))
+
+	// file-3 goes to the recycle bin on its own before its ancestor.
+	mustNotFail(t, r.SoftRemoveFileRecord("file-3", "trash-1"))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-2"))
+	for _, uuid := range []string{"dir-a", "dir-b"} {
+		_, err := r.ReadDirRecord(uuid)
+		mustFailWithCode(t, err, models.NotFoundErrorCode)
+	}
+	for _, uuid := range []string{"file-1", "file-2"} {
+		_, err := r.ReadFileRecord(uuid)
+		mustFailWithCode(t, err, models.NotFoundErrorCode)
+	}
+	mustFailWithCode(t, insertFile(r, "file-4", "i.txt", "dir-b", "/storage/file-4", 1), models.NotFoundErrorCode)
+	// The original parent of file-3 is in the recycle bin as well.
+	mustFailWithCode(t, r.RestoreTrashRecord("trash-1", "h.txt", "dir-b"), models.NotFoundErrorCode)
+
+	mustNotFail(t, r.RestoreTrashRecord("trash-2", "restored", "dir-x"))
+	wantDirs := map[string]string{
+		"dir-a": "/x/restored",
+		"dir-b": "/x/restored/b",
+	}
+	for uuid, want := range wantDirs {
+		dir, err := r.ReadDirRecord(uuid)
+		mustNotFail(t, err)
+		if dir.Path != want {
+			t.Errorf("path of %s = %q, want %q", uuid, dir.Path, want)
+		}
+	}
+	wantFiles := map[string]string{
+		"file-1": "/x/restored/f.txt",
+		"file-2": "/x/restored/b/g.txt",
+	}
+	for uuid, want := range wantFiles {
+		file, err := r.ReadFileRecord(uuid)
+		mustNotFail(t, err)
+		if file.Path != want {
+			t.Errorf("path of %s = %q, want %q", uuid, file.Path, want)
+		}
+	}
+	// file-3 stays in the recycle bin until its own entry is restored.
+	_, err := r.ReadFileRecord("file-3")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	mustNotFail(t, r.RestoreTrashRecord("trash-1", "h.txt", "dir-b"))
+	file, err := r.ReadFileRecord("file-3")
+	mustNotFail(t, err)
+	if file.Path != "/x/restored/b/h.txt" {
+		t.Errorf("path of file-3 = %q, want %q", file.Path, "/x/restored/b/h.txt")
+	}
+}
+
+func testRestoreConflicts(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	mustNotFail(t, insertFile(r, "file-2", "f.txt", "dir-a", "/storage/file-2", 1))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-2"))
+	mustNotFail(t, r.InsertDirRecord("dir-a2", "a", models.RootDirUUID, testOwnerUUID))
+
+	mustFailWithCode(t, r.RestoreTrashRecord("trash-2", "a", models.RootDirUUID), models.AlreadyExistErrorCode)
+	mustFailWithCode(t, r.RestoreTrashRecord("trash-2", "b", "missing"), models.NotFoundErrorCode)
+	mustNotFail(t, r.RestoreTrashRecord("trash-2", "b", models.RootDirUUID))
+	// The name of file-1 was taken by file-2 while it was in the recycle bin.
+	mustFailWithCode(t, r.RestoreTrashRecord("trash-1", "f.txt", "dir-a"), models.AlreadyExistErrorCode)
+	mustNotFail(t, r.RestoreTrashRecord("trash-1", "f (1).txt", "dir-a"))
+	file, err := r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.Path != "/b/f (1).txt" {
+		t.Errorf("path of file-1 = %q, want %q", file.Path, "/b/f (1).txt")
+	}
+}
+
+func testHardRemoveTrash(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", "dir-a", "/storage/file-1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "g.txt", "dir-b", "/storage/file-2", 1))
+	mustNotFail(t, insertFile(r, "file-3", "h.txt", models.RootDirUUID, "/storage/file-3", 1))
+	mustNotFail(t, r.SoftRemoveFileRecord("file-2", "trash-1"))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-2"))
+	mustNotFail(t, r.SoftRemoveFileRecord("file-3", "trash-3"))
+
+	blobs, err := r.HardRemoveTrashRecord("trash-2")
+	mustNotFail(t, err)
+	assertSameNames(t, storageKeys(blobs), []string{"file-1", "file-2"})
+	// The entry of file-2 went together with its ancestor.
+	for _, uuid := range []string{"trash-1", "trash-2"} {
+		_, err := r.ReadTrashRecord(uuid)
+		mustFailWithCode(t, err, models.NotFoundErrorCode)
+	}
+	mustFailWithCode(t, hardRemoveFile(r, "file-1"), models.NotFoundErrorCode)
+	mustFailWithCode(t, hardRemoveFile(r, "file-2"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.HardRemoveDirRecord("dir-b"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.HardRemoveDirRecord("dir-a"), models.NotFoundErrorCode)
+
+	blobs, err = r.HardRemoveTrashRecord("trash-3")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), []string{"file-3"})
+	_, err = r.HardRemoveTrashRecord("trash-3")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	entries, err := r.ListTrashRecords("", time.Time{})
+	mustNotFail(t, err)
+	if len(entries) != 0 {
+		t.Errorf("recycle bin should be empty, got %+v", entries)
+	}
+}
+
+func testListTrash(t *testing.T, r Repository) {
+	for i := 1; i <= 3; i++ {
+		mustNotFail(t, insertFile(r, fmt.Sprintf("file-%d", i), fmt.Sprintf("f%d.txt", i), models.RootDirUUID, "/storage", 1))
+		mustNotFail(t, r.SoftRemoveFileRecord(fmt.Sprintf("file-%d", i), fmt.Sprintf("trash-%d", i)))
+		time.Sleep(10 * time.Millisecond)
+	}
+	entries, err := r.ListTrashRecords("", time.Time{})
+	mustNotFail(t, err)
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.UUID)
+	}
+	// Most recently deleted first.
+	assertNames(t, got, []string{"trash-3", "trash-2", "trash-1"})
+
+	if entries[0].OwnerUUID != testOwnerUUID {
+		t.Errorf("owner of entry = %q, want %q", entries[0].OwnerUUID, testOwnerUUID)
+	}
+
+	entries, err = r.ListTrashRecords("", entries[1].DeletedAt)
+	mustNotFail(t, err)
+	if len(entries) != 1 || entries[0].UUID != "trash-1" {
+		t.Errorf("entries deleted before trash-2 = %+v, want only trash-1", entries)
+	}
+
+	// Entries of other owners are not listed.
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, "owner-2"))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-4"))
+	entries, err = r.ListTrashRecords("owner-2", time.Time{})
+	mustNotFail(t, err)
+	if len(entries) != 1 || entries[0].UUID != "trash-4" {
+		t.Errorf("entries of owner-2 = %+v, want only trash-4", entries)
+	}
+	entries, err = r.ListTrashRecords(testOwnerUUID, time.Time{})
+	mustNotFail(t, err)
+	if len(entries) != 3 {
+		t.Errorf("got %d entries of %s, want 3", len(entries), testOwnerUUID)
+	}
+}
+
+func testUploadRecords(t *testing.T, r Repository) {
+	now := time.Now().UTC().Truncate(time.Second)
+	upload := models.Upload{
+		UUID:       "upload-1",
+		Filename:   "f.txt",
+		ParentUUID: models.RootDirUUID,
+		Length:     10,
+		Metadata:   "filename Zi50eHQ=",
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	mustNotFail(t, r.InsertUploadRecord(upload))
+	mustFailWithCode(t, r.InsertUploadRecord(upload), models.AlreadyExistErrorCode)
+	expired := upload
+	expired.UUID = "upload-2"
+	expired.ExpiresAt = now.Add(-time.Minute)
+	mustNotFail(t, r.InsertUploadRecord(expired))
+
+	mustNotFail(t, r.UpdateUploadOffset("upload-1", 0, 4))
+	// A chunk written at an offset, which another one moved on from, is refused.
+	mustFailWithCode(t, r.UpdateUploadOffset("upload-1", 0, 6), models.ConflictErrorCode)
+	mustFailWithCode(t, r.UpdateUploadOffset("upload-9", 0, 4), models.NotFoundErrorCode)
+	got, err := r.ReadUploadRecord("upload-1")
+	mustNotFail(t, err)
+	if got.Offset != 4 || got.Length != 10 || got.Filename != "f.txt" || got.Metadata != upload.Metadata ||
+		got.FileUUID != "" || !got.CreatedAt.Equal(now) || !got.ExpiresAt.Equal(upload.ExpiresAt) {
+		t.Errorf("unexpected upload %+v", got)
+	}
+	mustNotFail(t, r.CompleteUploadRecord("upload-1", "file-1"))
+	got, err = r.ReadUploadRecord("upload-1")
+	mustNotFail(t, err)
+	if got.FileUUID != "file-1" {
+		t.Errorf("file UUID of completed upload = %q, want %q", got.FileUUID, "file-1")
+	}
+	// A complete upload cannot be changed anymore.
+	mustFailWithCode(t, r.UpdateUploadOffset("upload-1", 4, 10), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.CompleteUploadRecord("upload-1", "file-2"), models.NotFoundErrorCode)
+
+	uploads, err := r.ListExpiredUploadRecords(now)
+	mustNotFail(t, err)
+	if len(uploads) != 1 || uploads[0].UUID != "upload-2" {
+		t.Errorf("expired uploads = %+v, want only upload-2", uploads)
+	}
+	mustNotFail(t, r.HardRemoveUploadRecord("upload-2"))
+	mustFailWithCode(t, r.HardRemoveUploadRecord("upload-2"), models.NotFoundErrorCode)
+	_, err = r.ReadUploadRecord("upload-2")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+}
+
+func testBlobRefCounts(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	blob := models.Blob{Hash: "hash-1", StorageKey: "key-1", RealPath: "/storage/key-1", Size: 7, StoredSize: 3}
+	stored, err := r.InsertFileRecord("file-1", "f.txt", models.RootDirUUID, testOwnerUUID, "text/plain", blob)
+	mustNotFail(t, err)
+	if stored.Hash != "hash-1" || stored.StorageKey != "key-1" || stored.Size != 7 {
+		t.Errorf("unexpected blob %+v", stored)
+	}
+	// The same content stored again under another key is deduplicated, and fills in the MD5
+	// digest, which the first copy lacked.
+	stored, err = r.InsertFileRecord("file-2", "g.txt", "dir-a", testOwnerUUID, "text/plain",
+		models.Blob{Hash: "hash-1", MD5: "md5-1", StorageKey: "key-2", RealPath: "/storage/key-2", Size: 7, StoredSize: 7})
+	mustNotFail(t, err)
+	if stored.StorageKey != "key-1" || stored.MD5 != "md5-1" {
+		t.Errorf("unexpected blob %+v", stored)
+	}
+	// A copy references the existing blob by its hash only.
+	_, err = r.InsertFileRecord("file-3", "h.txt", "dir-a", testOwnerUUID, "text/plain", models.Blob{Hash: "hash-1"})
+	mustNotFail(t, err)
+	_, err = r.InsertFileRecord("file-4", "i.txt", "dir-a", testOwnerUUID, "text/plain", models.Blob{Hash: "missing"})
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	file, err := r.ReadFileRecord("file-3")
+	mustNotFail(t, err)
+	if file.ContentHash != "hash-1" || file.ContentMD5 != "md5-1" || file.StorageKey != "key-1" ||
+		file.RealPath != "/storage/key-1" || file.FileSize != 7 || file.StoredSize != 3 {
+		t.Errorf("unexpected file record %+v", file)
+	}
+	dir, _, err := r.ListDirRecord("dir-a", models.DirListOptions{})
+	mustNotFail(t, err)
+	for _, f := range dir.ListOfFiles {
+		if f.ContentHash != "hash-1" || f.ContentMD5 != "md5-1" {
+			t.Errorf("listed file %s has digests %q and %q, want %q and %q", f.UUID, f.ContentHash, f.ContentMD5,
+				"hash-1", "md5-1")
+		}
+		if f.ContentType != "text/plain" {
+			t.Errorf("listed file %s has content type %q, want %q", f.UUID, f.ContentType, "text/plain")
+		}
+		if f.FileSize != 7 || f.StoredSize != 3 {
+			t.Errorf("listed file %s has sizes %d and %d, want %d and %d", f.UUID, f.FileSize, f.StoredSize, 7, 3)
+		}
+	}
+	// Deduplicated content is counted once.
+	stats, err := r.ReadStorageStatsRecord()
+	mustNotFail(t, err)
+	want := models.StorageStats{Blobs: 1, CompressedBlobs: 1, LogicalBytes: 7, StoredBytes: 3, SavedBytes: 4}
+	if stats != want {
+		t.Errorf("storage stats = %+v, want %+v", stats, want)
+	}
+	// A name conflict does not leave a reference behind.
+	_, err = r.InsertFileRecord("file-5", "f.txt", models.RootDirUUID, testOwnerUUID, "text/plain", models.Blob{Hash: "hash-1"})
+	mustFailWithCode(t, err, models.AlreadyExistErrorCode)
+
+	blobs, err := r.HardRemoveFileRecord("file-1")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), nil)
+	// Soft-removed records keep their reference until they are hard-removed.
+	mustNotFail(t, r.SoftRemoveFileRecord("file-2", "trash-1"))
+	blobs, err = r.HardRemoveFileRecord("file-3")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), nil)
+	blobs, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), []string{"key-1"})
+	_, err = r.InsertFileRecord("file-6", "f.txt", models.RootDirUUID, testOwnerUUID, "text/plain", models.Blob{Hash: "hash-1"})
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// References of a whole subtree are released at once.
+	for i := 1; i <= 3; i++ {
+		_, err := r.InsertFileRecord(fmt.Sprintf("file-a%d", i), fmt.Sprintf("f%d.txt", i), "dir-a", testOwnerUUID, "text/plain",
+			models.Blob{Hash: "hash-2", StorageKey: fmt.Sprintf("key-a%d", i), Size: 1})
+		mustNotFail(t, err)
+	}
+	mustNotFail(t, insertFile(r, "file-b", "g.txt", models.RootDirUUID, "/storage/file-b", 1))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-2"))
+	blobs, err = r.HardRemoveTrashRecord("trash-2")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), []string{"key-a1"})
+	mustNotFail(t, hardRemoveFile(r, "file-b"))
+}
+
+func testFileVersions(t *testing.T, r Repository) {
+	mustNotFail(t, insertFile(r, "file-1", "f.txt", models.RootDirUUID, "/storage/file-1", 1))
+	v2, err := r.InsertVersionRecord("file-1",
+		models.Blob{Hash: "hash-2", MD5: "md5-2", StorageKey: "key-2", RealPath: "/storage/key-2", Size: 2, StoredSize: 2},
+		"image/png", "alice")
+	mustNotFail(t, err)
+	if v2.Version != 2 || !v2.IsCurrent || v2.StorageKey != "key-2" || v2.FileSize != 2 || v2.StoredSize != 2 ||
+		v2.ContentType != "image/png" || v2.ContentMD5 != "md5-2" || v2.UploadedBy != "alice" {
+		t.Errorf("unexpected version %+v", v2)
+	}
+	file, err := r.ReadFileRecordByName("f.txt", models.RootDirUUID)
+	mustNotFail(t, err)
+	if file.UUID != "file-1" || file.Version != 2 || file.ContentType != "image/png" || file.ContentMD5 != "md5-2" {
+		t.Errorf("unexpected file record %+v", file)
+	}
+	_, err = r.ReadFileRecordByName("f.txt", "missing")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	// A version may reference the content of an older one by its hash only.
+	v3, err := r.InsertVersionRecord("file-1", models.Blob{Hash: "hash-file-1"}, "text/plain", "")
+	mustNotFail(t, err)
+	if v3.Version != 3 || v3.StorageKey != "file-1" {
+		t.Errorf("unexpected version %+v", v3)
+	}
+	_, err = r.InsertVersionRecord("file-1", models.Blob{Hash: "missing"}, "text/plain", "")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	file, err = r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.Version != 3 || file.ContentHash != "hash-file-1" || file.ContentType != "text/plain" || file.StorageKey != "file-1" ||
+		file.FileSize != 1 {
+		t.Errorf("unexpected file record %+v", file)
+	}
+	versions, err := r.ListVersionRecords("file-1")
+	mustNotFail(t, err)
+	var numbers []string
+	for _, v := range versions {
+		numbers = append(numbers, fmt.Sprintf("%d:%t", v.Version, v.IsCurrent))
+	}
+	assertNames(t, numbers, []string{"3:true", "2:false", "1:false"})
+	v, err := r.ReadVersionRecord("file-1", 2)
+	mustNotFail(t, err)
+	if v.ContentHash != "hash-2" || v.ContentType != "image/png" || v.RealPath != "/storage/key-2" || v.IsCurrent {
+		t.Errorf("unexpected version %+v", v)
+	}
+	_, err = r.ReadVersionRecord("file-1", 4)
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	mustNotFail(t, insertFile(r, "file-2", "g.txt", models.RootDirUUID, "/storage/file-2", 1))
+	fileUUIDs, err := r.ListVersionedFileRecords()
+	mustNotFail(t, err)
+	assertNames(t, fileUUIDs, []string{"file-1"})
+
+	policy := models.VersionPolicy{MaxVersions: 5, MaxAge: 90 * time.Minute}
+	mustNotFail(t, r.UpdateVersionPolicy("file-1", policy))
+	mustFailWithCode(t, r.UpdateVersionPolicy("missing", policy), models.NotFoundErrorCode)
+	file, err = r.ReadFileRecord("file-1")
+	mustNotFail(t, err)
+	if file.VersionPolicy != policy {
+		t.Errorf("version policy = %+v, want %+v", file.VersionPolicy, policy)
+	}
+	dir, _, err := r.ListDirRecord(models.RootDirUUID, models.DirListOptions{})
+	mustNotFail(t, err)
+	for _, f := range dir.ListOfFiles {
+		if f.UUID == "file-1" && (f.Version != 3 || f.VersionPolicy != policy) {
+			t.Errorf("unexpected listed file %+v", f)
+		}
+	}
+
+	_, err = r.HardRemoveVersionRecords("file-1", []int{2, 3})
+	mustFailWithCode(t, err, models.InvalidArgumentErrorCode)
+	_, err = r.HardRemoveVersionRecords("file-1", []int{4})
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	// The content of version 1 is still referenced by the current version.
+	blobs, err := r.HardRemoveVersionRecords("file-1", []int{1})
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), nil)
+	blobs, err = r.HardRemoveVersionRecords("file-1", []int{2})
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(blobs), []string{"key-2"})
+	fileUUIDs, err = r.ListVersionedFileRecords()
+	mustNotFail(t, err)
+	assertNames(t, fileUUIDs, nil)
+
+	// Versions of soft-removed files do not exist, but are released with the file.
+	_, err = r.InsertVersionRecord("file-1", models.Blob{Hash: "hash-4", StorageKey: "key-4", Size: 4}, "text/plain", "")
+	mustNotFail(t, err)
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	_, err = r.ListVersionRecords("file-1")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	_, err = r.InsertVersionRecord("file-1", models.Blob{Hash: "hash-4"}, "text/plain", "")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	blobs, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	assertSameNames(t, storageKeys(blobs), []string{"file-1", "key-4"})
+}
+
+func testUsers(t *testing.T, r Repository) {
+	user := models.User{
+		UUID:         "user-1",
+		Username:     "alice",
+		PasswordHash: "hash",
+		RootDirUUID:  "root-1",
+	}
+	mustNotFail(t, r.InsertUserRecord(user))
+	got, err := r.ReadUserRecord("user-1")
+	mustNotFail(t, err)
+	if got.Username != "alice" || got.PasswordHash != "hash" || got.RootDirUUID != "root-1" || got.IsAdmin ||
+		got.CreatedAt.IsZero() {
+		t.Errorf("unexpected user %+v", got)
+	}
+	got, err = r.ReadUserRecordByName("alice")
+	mustNotFail(t, err)
+	if got.UUID != "user-1" {
+		t.Errorf("UUID of alice = %q, want %q", got.UUID, "user-1")
+	}
+	_, err = r.ReadUserRecord("missing")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	_, err = r.ReadUserRecordByName("bob")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// The root directory of the user is created with it and is protected.
+	root, err := r.ReadDirRecord("root-1")
+	mustNotFail(t, err)
+	if root.Path != models.RootDirPath || root.ParentUUID != "" || root.OwnerUUID != "user-1" {
+		t.Errorf("unexpected root directory %+v", root)
+	}
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", "root-1", "user-1"))
+	dir, err := r.ReadDirRecord("dir-a")
+	mustNotFail(t, err)
+	if dir.Path != "/a" {
+		t.Errorf("directory path = %q, want %q", dir.Path, "/a")
+	}
+	mustFailWithCode(t, r.SoftRemoveDirRecord("root-1", "trash-1"), models.InvalidArgumentErrorCode)
+	mustFailWithCode(t, r.UpdateDirRecord("root-1", "x", models.RootDirUUID), models.InvalidArgumentErrorCode)
+
+	// Usernames are unique.
+	user.UUID = "user-2"
+	user.RootDirUUID = "root-2"
+	mustFailWithCode(t, r.InsertUserRecord(user), models.AlreadyExistErrorCode)
+	_, err = r.ReadDirRecord("root-2")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	user.Username = "bob"
+	user.IsAdmin = true
+	mustNotFail(t, r.InsertUserRecord(user))
+	got, err = r.ReadUserRecordByName("bob")
+	mustNotFail(t, err)
+	if !got.IsAdmin {
+		t.Error("bob should be an admin")
+	}
+}
+
+func testRefreshTokens(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertUserRecord(models.User{UUID: "user-1", Username: "alice", RootDirUUID: "root-1"}))
+	now := time.Now().UTC().Truncate(time.Second)
+	token := models.RefreshToken{
+		UUID:      "token-1",
+		UserUUID:  "user-1",
+		CreatedAt: now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+	mustNotFail(t, r.InsertRefreshTokenRecord(token))
+	mustFailWithCode(t, r.InsertRefreshTokenRecord(token), models.AlreadyExistErrorCode)
+	expired := token
+	expired.UUID = "token-2"
+	expired.ExpiresAt = now.Add(-time.Minute)
+	mustNotFail(t, r.InsertRefreshTokenRecord(expired))
+
+	n, err := r.HardRemoveExpiredRefreshTokenRecords(now)
+	mustNotFail(t, err)
+	if n != 1 {
+		t.Errorf("removed %d expired tokens, want 1", n)
+	}
+	_, err = r.HardRemoveRefreshTokenRecord("token-2")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// A token can be removed only once.
+	got, err := r.HardRemoveRefreshTokenRecord("token-1")
+	mustNotFail(t, err)
+	if got.UserUUID != "user-1" || !got.CreatedAt.Equal(now) || !got.ExpiresAt.Equal(token.ExpiresAt) {
+		t.Errorf("unexpected token %+v", got)
+	}
+	_, err = r.HardRemoveRefreshTokenRecord("token-1")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+}
+
+func testQuotas(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertUserRecord(models.User{UUID: "user-1", Username: "alice", RootDirUUID: "root-1"}))
+	assertUsed := func(want int64) {
+		t.Helper()
+		quota, err := r.ReadUserQuotaRecord("user-1")
+		mustNotFail(t, err)
+		if quota.UsedBytes != want {
+			t.Errorf("used bytes = %d, want %d", quota.UsedBytes, want)
+		}
+	}
+	assertUsed(0)
+	mustNotFail(t, r.UpdateUserQuotaLimit("user-1", 1000))
+	quota, err := r.ReadUserQuotaRecord("user-1")
+	mustNotFail(t, err)
+	if quota.LimitBytes != 1000 {
+		t.Errorf("limit = %d, want 1000", quota.LimitBytes)
+	}
+	mustFailWithCode(t, r.UpdateUserQuotaLimit("missing", 1000), models.NotFoundErrorCode)
+	_, err = r.ReadUserQuotaRecord("missing")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// Every version is counted, copies and shared content included, until it is removed.
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", "root-1", "user-1"))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", "user-1"))
+	_, err = r.InsertFileRecord("file-1", "f1", "dir-b", "user-1", "text/plain", models.Blob{Hash: "h1", StorageKey: "k1", Size: 100})
+	mustNotFail(t, err)
+	_, err = r.InsertFileRecord("file-2", "f2", "root-1", "user-1", "text/plain", models.Blob{Hash: "h1"})
+	mustNotFail(t, err)
+	assertUsed(200)
+	_, err = r.InsertVersionRecord("file-1", models.Blob{Hash: "h2", StorageKey: "k2", Size: 30}, "text/plain", "user-1")
+	mustNotFail(t, err)
+	assertUsed(230)
+	_, err = r.HardRemoveVersionRecords("file-1", []int{1})
+	mustNotFail(t, err)
+	assertUsed(130)
+
+	// Directory quotas count the files in their subtrees outside of the recycle bin.
+	mustFailWithCode(t, r.UpdateDirQuotaLimit("root-1", 10), models.InvalidArgumentErrorCode)
+	mustFailWithCode(t, r.UpdateDirQuotaLimit("missing", 10), models.NotFoundErrorCode)
+	mustNotFail(t, r.UpdateDirQuotaLimit("dir-a", 500))
+	mustNotFail(t, r.UpdateDirQuotaLimit("dir-b", 50))
+	quotas, err := r.ListAncestorDirQuotaRecords("dir-b")
+	mustNotFail(t, err)
+	if len(quotas) != 2 || quotas[0].DirUUID != "dir-b" || quotas[0].LimitBytes != 50 || quotas[0].UsedBytes != 30 ||
+		quotas[1].DirUUID != "dir-a" || quotas[1].Path != "/a" || quotas[1].UsedBytes != 30 {
+		t.Errorf("unexpected ancestor quotas %+v", quotas)
+	}
+	quotas, err = r.ListOwnerDirQuotaRecords("user-1")
+	mustNotFail(t, err)
+	if len(quotas) != 2 || quotas[0].DirUUID != "dir-a" || quotas[1].DirUUID != "dir-b" {
+		t.Errorf("unexpected owner quotas %+v", quotas)
+	}
+	mustNotFail(t, r.SoftRemoveFileRecord("file-1", "trash-1"))
+	quotas, err = r.ListAncestorDirQuotaRecords("dir-b")
+	mustNotFail(t, err)
+	if len(quotas) != 2 || quotas[0].UsedBytes != 0 {
+		t.Errorf("unexpected ancestor quotas %+v", quotas)
+	}
+	assertUsed(130)
+	mustNotFail(t, r.UpdateDirQuotaLimit("dir-b", 0))
+	quotas, err = r.ListAncestorDirQuotaRecords("dir-b")
+	mustNotFail(t, err)
+	if len(quotas) != 1 || quotas[0].DirUUID != "dir-a" {
+		t.Errorf("unexpected ancestor quotas %+v", quotas)
+	}
+
+	_, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	assertUsed(100)
+	_, err = r.HardRemoveFileRecord("file-2")
+	mustNotFail(t, err)
+	assertUsed(0)
+
+	corrections, err := r.RecomputeUsageRecords()
+	mustNotFail(t, err)
+	if len(corrections) != 0 {
+		t.Errorf("unexpected corrections %+v", corrections)
+	}
+}
+
+func testQuotaReservations(t *testing.T, r Repository) {
+	mustNotFail(t, r.InsertUserRecord(models.User{UUID: "user-1", Username: "alice", RootDirUUID: "root-1"}))
+	mustNotFail(t, r.UpdateUserQuotaLimit("user-1", 1000))
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", "root-1", "user-1"))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", "user-1"))
+	_, err := r.InsertFileRecord("file-1", "f1", "dir-a", "user-1", "text/plain", models.Blob{Hash: "h1", StorageKey: "k1", Size: 400})
+	mustNotFail(t, err)
+	expiresAt := time.Now().Add(time.Hour)
+	reserve := func(UUID, ownerUUID, dirUUID string, size, defaultLimit int64) error {
+		return r.ReserveQuotaRecord(models.QuotaReservation{
+			UUID:      UUID,
+			OwnerUUID: ownerUUID,
+			DirUUID:   dirUUID,
+			Size:      size,
+			ExpiresAt: expiresAt,
+		}, defaultLimit)
+	}
+
+	// Reservations count towards the quota of the owner besides the bytes used.
+	mustNotFail(t, reserve("res-1", "user-1", "dir-b", 500, 0))
+	mustFailWithCode(t, reserve("res-2", "user-1", "dir-b", 101, 0), models.QuotaExceededErrorCode)
+	mustNotFail(t, reserve("res-2", "user-1", "dir-b", 100, 0))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-1"))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-1"))
+	mustNotFail(t, reserve("res-3", "user-1", "dir-b", 500, 0))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-2"))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-3"))
+
+	// They count towards the quotas of the directory and its ancestors as well.
+	mustNotFail(t, r.UpdateDirQuotaLimit("dir-a", 450))
+	mustNotFail(t, reserve("res-4", "user-1", "dir-b", 30, 0))
+	mustFailWithCode(t, reserve("res-5", "user-1", "dir-a", 21, 0), models.QuotaExceededErrorCode)
+	mustNotFail(t, reserve("res-5", "user-1", "dir-a", 20, 0))
+	mustNotFail(t, reserve("res-6", "user-1", "root-1", 500, 0))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-4"))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-5"))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-6"))
+	mustNotFail(t, r.UpdateDirQuotaLimit("dir-a", 0))
+
+	// A zero limit falls back to the default, and owners which are not users are limited
+	// by the directories only.
+	mustNotFail(t, r.UpdateUserQuotaLimit("user-1", 0))
+	mustFailWithCode(t, reserve("res-7", "user-1", "dir-b", 101, 500), models.QuotaExceededErrorCode)
+	mustNotFail(t, reserve("res-7", "user-1", "dir-b", 5000, 0))
+	mustNotFail(t, reserve("res-8", testOwnerUUID, "dir-b", 5000, 500))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-7"))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-8"))
+
+	// Expired reservations are dropped.
+	mustNotFail(t, r.UpdateUserQuotaLimit("user-1", 1000))
+	err = r.ReserveQuotaRecord(models.QuotaReservation{
+		UUID:      "res-9",
+		OwnerUUID: "user-1",
+		DirUUID:   "dir-b",
+		Size:      600,
+		ExpiresAt: time.Now().Add(-time.Second),
+	}, 0)
+	mustNotFail(t, err)
+	mustNotFail(t, reserve("res-10", "user-1", "dir-b", 600, 0))
+	mustNotFail(t, r.RemoveQuotaReservationRecord("res-10"))
+
+	// Concurrent reservations cannot exceed a quota together.
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = reserve(fmt.Sprintf("res-c%d", i), "user-1", "dir-b", 100, 0)
+		}(i)
+	}
+	wg.Wait()
+	reserved := 0
+	for _, err := range errs {
+		if err == nil {
+			reserved++
+		} else if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+			t.Errorf("unexpected error %v", err)
+		}
+	}
+	if reserved != 6 {
+		t.Errorf("%d concurrent reservations succeeded, want 6", reserved)
+	}
+}
+
+// insertFile inserts a file record whose content is a blob of its own, stored under the UUID of the file.
+func insertFile(r Repository, UUID, filename, parentUUID, realPath string, fileSize int64) error {
+	_, err := r.InsertFileRecord(UUID, filename, parentUUID, testOwnerUUID, "text/plain",
+		models.Blob{Hash: "hash-" + UUID, StorageKey: UUID, RealPath: realPath, Size: fileSize, StoredSize: fileSize})
+	return err
+}
+
+// hardRemoveFile hard-removes a file record and ignores the released blobs.
+func hardRemoveFile(r Repository, UUID string) error {
+	_, err := r.HardRemoveFileRecord(UUID)
+	return err
+}
+
+// storageKeys returns the storage keys of blobs.
+func storageKeys(blobs []models.Blob) []string {
+	var keys []string
+	for _, blob := range blobs {
+		keys = append(keys, blob.StorageKey)
+	}
+	return keys
+}
+
+func listedNames(dir models.Directory) []string {
+	var names []string
+	for _, d := range dir.ListOfDirs {
+		names = append(names, d.Dirname)
+	}
+	for _, f := range dir.ListOfFiles {
+		names = append(names, f.Filename)
+	}
+	return names
+}
+
+func assertNames(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+}
+
+// assertSameNames checks if got contains the names of want exactly once, in any order.
+func assertSameNames(t *testing.T, got, want []string) {
+	t.Helper()
+	got = append([]string(nil), got...)
+	want = append([]string(nil), want...)
+	sort.Strings(got)
+	sort.Strings(want)
+	assertNames(t, got, want)
+}
+
+func mustNotFail(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func mustFailWithCode(t *testing.T, err error, code int) {
+	t.Helper()
+	if !models.IsFManErrorCode(err, code) {
+		t.Fatalf("expected FManError with code %d, got %v", code, err)
+	}
+}
+
+func testShareLinks(t *testing.T, r Repository) {
+	now := time.Now().UTC().Truncate(time.Second)
+	expiresAt := now.Add(time.Hour)
+	link := models.ShareLink{
+		UUID:         "share-1",
+		Token:        "token-1",
+		ItemUUID:     "file-1",
+		ItemType:     models.EntryTypeFile,
+		OwnerUUID:    testOwnerUUID,
+		PasswordHash: "hash",
+		Mode:         models.ShareModeRead,
+		MaxDownloads: 2,
+		ExpiresAt:    &expiresAt,
+		CreatedAt:    now,
+	}
+	mustNotFail(t, r.InsertShareLinkRecord(link))
+	mustFailWithCode(t, r.InsertShareLinkRecord(link), models.AlreadyExistErrorCode)
+	// Tokens are unique as well.
+	taken := link
+	taken.UUID = "share-2"
+	mustFailWithCode(t, r.InsertShareLinkRecord(taken), models.AlreadyExistErrorCode)
+	other := models.ShareLink{
+		UUID:      "share-2",
+		Token:     "token-2",
+		ItemUUID:  models.RootDirUUID,
+		ItemType:  models.EntryTypeDir,
+		OwnerUUID: testOwnerUUID,
+		Mode:      models.ShareModeUpload,
+		CreatedAt: now.Add(time.Minute),
+	}
+	mustNotFail(t, r.InsertShareLinkRecord(other))
+
+	got, err := r.ReadShareLinkRecordByToken("token-1")
+	mustNotFail(t, err)
+	if got.UUID != "share-1" || got.ItemUUID != "file-1" || got.ItemType != models.EntryTypeFile || got.Mode != models.ShareModeRead ||
+		got.PasswordHash != "hash" || !got.HasPassword || got.MaxDownloads != 2 || got.Downloads != 0 ||
+		got.ExpiresAt == nil || !got.ExpiresAt.Equal(expiresAt) || !got.CreatedAt.Equal(now) {
+		t.Errorf("unexpected share link %+v", got)
+	}
+	got, err = r.ReadShareLinkRecord("share-2")
+	mustNotFail(t, err)
+	if got.Token != "token-2" || got.HasPassword || got.ExpiresAt != nil || got.MaxDownloads != 0 {
+		t.Errorf("unexpected share link %+v", got)
+	}
+	_, err = r.ReadShareLinkRecordByToken("token-3")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	links, err := r.ListShareLinkRecords(testOwnerUUID)
+	mustNotFail(t, err)
+	if len(links) != 2 || links[0].UUID != "share-2" || links[1].UUID != "share-1" {
+		t.Errorf("share links = %+v, want share-2, share-1", links)
+	}
+	links, err = r.ListShareLinkRecords("owner-2")
+	mustNotFail(t, err)
+	if len(links) != 0 {
+		t.Errorf("share links of owner-2 = %+v, want none", links)
+	}
+
+	// Downloads are counted up to the limit.
+	mustNotFail(t, r.CountShareLinkDownload("share-1"))
+	mustNotFail(t, r.CountShareLinkDownload("share-1"))
+	mustFailWithCode(t, r.CountShareLinkDownload("share-1"), models.ExpiredErrorCode)
+	got, err = r.ReadShareLinkRecord("share-1")
+	mustNotFail(t, err)
+	if got.Downloads != 2 {
+		t.Errorf("downloads = %d, want 2", got.Downloads)
+	}
+	for i := 0; i < 3; i++ {
+		mustNotFail(t, r.CountShareLinkDownload("share-2"))
+	}
+	mustFailWithCode(t, r.CountShareLinkDownload("share-3"), models.NotFoundErrorCode)
+
+	mustNotFail(t, r.HardRemoveShareLinkRecord("share-1"))
+	mustFailWithCode(t, r.HardRemoveShareLinkRecord("share-1"), models.NotFoundErrorCode)
+	_, err = r.ReadShareLinkRecordByToken("token-1")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+}
+
+func testGroupsAndACL(t *testing.T, r Repository) {
+	for i, username := range []string{"alice", "bob"} {
+		user := models.User{
+			UUID:         fmt.Sprintf("user-%d", i+1),
+			Username:     username,
+			PasswordHash: "hash",
+			RootDirUUID:  fmt.Sprintf("root-%d", i+1),
+		}
+		mustNotFail(t, r.InsertUserRecord(user))
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	mustNotFail(t, r.InsertGroupRecord(models.Group{UUID: "group-1", Name: "staff", CreatedAt: now}))
+	mustFailWithCode(t, r.InsertGroupRecord(models.Group{UUID: "group-2", Name: "staff", CreatedAt: now}), models.AlreadyExistErrorCode)
+	mustNotFail(t, r.InsertGroupRecord(models.Group{UUID: "group-2", Name: "admins", CreatedAt: now}))
+	mustNotFail(t, r.InsertGroupMemberRecord("group-1", "user-2"))
+	mustNotFail(t, r.InsertGroupMemberRecord("group-1", "user-1"))
+	mustFailWithCode(t, r.InsertGroupMemberRecord("group-1", "user-1"), models.AlreadyExistErrorCode)
+	mustFailWithCode(t, r.InsertGroupMemberRecord("group-1", "user-3"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.InsertGroupMemberRecord("group-3", "user-1"), models.NotFoundErrorCode)
+
+	group, err := r.ReadGroupRecord("group-1")
+	mustNotFail(t, err)
+	if group.Name != "staff" || !group.CreatedAt.Equal(now) {
+		t.Errorf("unexpected group %+v", group)
+	}
+	assertNames(t, group.MemberUUIDs, []string{"user-1", "user-2"})
+	_, err = r.ReadGroupRecord("group-3")
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	groups, err := r.ListGroupRecords("")
+	mustNotFail(t, err)
+	if len(groups) != 2 || groups[0].Name != "admins" || groups[1].Name != "staff" {
+		t.Errorf("groups = %+v, want admins, staff", groups)
+	}
+	groups, err = r.ListGroupRecords("user-2")
+	mustNotFail(t, err)
+	if len(groups) != 1 || groups[0].UUID != "group-1" {
+		t.Errorf("groups of user-2 = %+v, want group-1", groups)
+	}
+	mustNotFail(t, r.HardRemoveGroupMemberRecord("group-1", "user-2"))
+	mustFailWithCode(t, r.HardRemoveGroupMemberRecord("group-1", "user-2"), models.NotFoundErrorCode)
+
+	// dir-a/dir-b/file-1, dir-a/file-2
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-b", "b", "dir-a", testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f1", "dir-b", "/f1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "f2", "dir-a", "/f2", 1))
+	dirUUIDs, err := r.ListAncestorDirUUIDs("dir-b")
+	mustNotFail(t, err)
+	assertNames(t, dirUUIDs, []string{"dir-b", "dir-a", models.RootDirUUID})
+
+	entry := func(itemUUID, itemType, principalType, principalUUID string, perms models.Permissions) models.ACLEntry {
+		return models.ACLEntry{
+			ItemUUID:      itemUUID,
+			ItemType:      itemType,
+			PrincipalType: principalType,
+			PrincipalUUID: principalUUID,
+			Permissions:   perms,
+			GrantedBy:     testOwnerUUID,
+		}
+	}
+	mustNotFail(t, r.UpsertACLRecord(entry("dir-a", models.EntryTypeDir, models.PrincipalGroup, "group-1", models.PermRead)))
+	mustNotFail(t, r.UpsertACLRecord(entry("dir-b", models.EntryTypeDir, models.PrincipalUser, "user-1", models.PermRead)))
+	mustNotFail(t, r.UpsertACLRecord(entry("file-1", models.EntryTypeFile, models.PrincipalUser, "user-2", 0)))
+	mustNotFail(t, r.UpsertACLRecord(entry("file-2", models.EntryTypeFile, models.PrincipalGroup, "group-2", models.PermAll)))
+	// Upserting replaces the permissions.
+	mustNotFail(t, r.UpsertACLRecord(entry("dir-b", models.EntryTypeDir, models.PrincipalUser, "user-1", models.PermRead|models.PermWrite)))
+	mustFailWithCode(t, r.UpsertACLRecord(entry("dir-a", models.EntryTypeDir, models.PrincipalUser, "user-3", models.PermRead)),
+		models.NotFoundErrorCode)
+	mustFailWithCode(t, r.UpsertACLRecord(entry("dir-a", models.EntryTypeDir, models.PrincipalGroup, "group-3", models.PermRead)),
+		models.NotFoundErrorCode)
+
+	entries, err := r.ListACLRecords([]string{"dir-b", "file-1"})
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), []string{"dir-b:user-1:3", "file-1:user-2:0"})
+	entries, err = r.ListSubtreeACLRecords("dir-b")
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), []string{"dir-b:user-1:3", "file-1:user-2:0"})
+	entries, err = r.ListSubtreeACLRecords("dir-a")
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), []string{"dir-a:group-1:1", "dir-b:user-1:3", "file-1:user-2:0", "file-2:group-2:31"})
+	entries, err = r.ListPrincipalACLRecords([]string{"user-1", "group-1"})
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), []string{"dir-a:group-1:1", "dir-b:user-1:3"})
+
+	mustNotFail(t, r.HardRemoveACLRecord("dir-b", models.PrincipalUser, "user-1"))
+	mustFailWithCode(t, r.HardRemoveACLRecord("dir-b", models.PrincipalUser, "user-1"), models.NotFoundErrorCode)
+	// Entries go together with their groups and items.
+	mustNotFail(t, r.HardRemoveGroupRecord("group-2"))
+	mustFailWithCode(t, r.HardRemoveGroupRecord("group-2"), models.NotFoundErrorCode)
+	mustNotFail(t, hardRemoveFile(r, "file-1"))
+	entries, err = r.ListSubtreeACLRecords(models.RootDirUUID)
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), []string{"dir-a:group-1:1"})
+	mustNotFail(t, hardRemoveFile(r, "file-2"))
+	mustNotFail(t, r.HardRemoveDirRecord("dir-b"))
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-1"))
+	_, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	entries, err = r.ListPrincipalACLRecords([]string{"user-1", "user-2", "group-1"})
+	mustNotFail(t, err)
+	assertSameNames(t, aclEntryNames(entries), nil)
+}
+
+// aclEntryNames returns the access control entries as item:principal:permissions.
+func aclEntryNames(entries []models.ACLEntry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, fmt.Sprintf("%s:%s:%d", e.ItemUUID, e.PrincipalUUID, e.Permissions))
+	}
+	return names
+}
+
+func testSearch(t *testing.T, r Repository) {
+	// docs/Annual-Report 2021.txt, docs/archive/notes.md, photos/report.png
+	mustNotFail(t, r.InsertDirRecord("dir-docs", "docs", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-archive", "archive", "dir-docs", testOwnerUUID))
+	mustNotFail(t, r.InsertDirRecord("dir-photos", "photos", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-report", "Annual-Report 2021.txt", "dir-docs", "/r", 100))
+	mustNotFail(t, insertFile(r, "file-notes", "notes.md", "dir-archive", "/n", 10))
+	mustNotFail(t, insertFile(r, "file-photo", "report.png", "dir-photos", "/p", 5000))
+	mustNotFail(t, r.IndexContentRecord("hash-file-notes", "Quarterly numbers: the annual report is late. Report, REPORT!"))
+	// Indexing twice or without a blob does nothing.
+	mustNotFail(t, r.IndexContentRecord("hash-file-notes", "other words"))
+	mustNotFail(t, r.IndexContentRecord("hash-missing", "orphan"))
+	for hash, want := range map[string]bool{"hash-file-notes": true, "hash-file-report": false, "hash-missing": false} {
+		indexed, err := r.IsContentIndexed(hash)
+		mustNotFail(t, err)
+		if indexed != want {
+			t.Errorf("IsContentIndexed(%s) = %v, want %v", hash, indexed, want)
+		}
+	}
+	files, err := r.ListUnindexedFileRecords()
+	mustNotFail(t, err)
+	var unindexed []string
+	for _, file := range files {
+		unindexed = append(unindexed, file.UUID)
+	}
+	assertNames(t, unindexed, []string{"file-photo", "file-report"})
+
+	search := func(query string, opts models.SearchOptions, scopeUUIDs ...string) []string {
+		t.Helper()
+		if opts.Limit == 0 {
+			opts.Limit = 10
+		}
+		hits, err := r.SearchRecords(models.SearchQuery{
+			SearchOptions: opts,
+			Terms:         models.ParseSearchQuery(query),
+			ScopeUUIDs:    scopeUUIDs,
+		})
+		mustNotFail(t, err)
+		var UUIDs []string
+		for _, hit := range hits {
+			UUIDs = append(UUIDs, hit.UUID)
+		}
+		return UUIDs
+	}
+	// Names match whole words, paths contain the words, and contents count their occurrences.
+	assertNames(t, search("report", models.SearchOptions{}), []string{"file-report", "file-photo", "file-notes"})
+	assertNames(t, search("Annual REPORT", models.SearchOptions{}), []string{"file-report", "file-notes"})
+	assertNames(t, search(`"annual report"`, models.SearchOptions{}), []string{"file-report", "file-notes"})
+	// Phrases need not follow each other in paths.
+	assertNames(t, search(`"report annual"`, models.SearchOptions{}), []string{"file-report"})
+	assertNames(t, search("quarterly-num*", models.SearchOptions{}), []string{"file-notes"})
+	assertNames(t, search("rep*", models.SearchOptions{}), []string{"file-report", "file-photo", "file-notes"})
+	assertNames(t, search("repo", models.SearchOptions{}), []string{"file-report", "file-photo"})
+	assertNames(t, search("archive", models.SearchOptions{}), []string{"dir-archive", "file-notes"})
+	assertNames(t, search("docs", models.SearchOptions{}), []string{"dir-docs", "file-report", "dir-archive", "file-notes"})
+	// Without terms, everything is found.
+	assertNames(t, search("", models.SearchOptions{}),
+		[]string{"dir-docs", "file-report", "dir-archive", "file-notes", "dir-photos", "file-photo"})
+
+	// Filters
+	now := time.Now().UTC()
+	assertNames(t, search("report", models.SearchOptions{Type: models.EntryTypeDir}), nil)
+	assertNames(t, search("report", models.SearchOptions{MinSize: 50}), []string{"file-report", "file-photo"})
+	assertNames(t, search("report", models.SearchOptions{MaxSize: 100}), []string{"file-report", "file-notes"})
+	assertNames(t, search("archive", models.SearchOptions{MaxSize: 100}), []string{"file-notes"})
+	assertNames(t, search("report", models.SearchOptions{UnderDirUUID: "dir-docs"}), []string{"file-report", "file-notes"})
+	assertNames(t, search("docs", models.SearchOptions{UnderDirUUID: "dir-docs"}), []string{"file-report", "dir-archive", "file-notes"})
+	assertNames(t, search("report", models.SearchOptions{OwnerUUID: "owner-2"}), nil)
+	assertNames(t, search("report", models.SearchOptions{UpdatedAfter: now.Add(time.Hour)}), nil)
+	assertNames(t, search("report", models.SearchOptions{UpdatedBefore: now.Add(-time.Hour)}), nil)
+	assertNames(t, search("report", models.SearchOptions{UpdatedAfter: now.Add(-time.Hour), UpdatedBefore: now.Add(time.Hour)}),
+		[]string{"file-report", "file-photo", "file-notes"})
+	assertNames(t, search("report", models.SearchOptions{}, "dir-photos"), []string{"file-photo"})
+	assertNames(t, search("report", models.SearchOptions{}, "file-notes", "dir-photos"), []string{"file-photo", "file-notes"})
+	assertNames(t, search("report", models.SearchOptions{Limit: 1, Offset: 1}), []string{"file-photo"})
+	assertNames(t, search("report", models.SearchOptions{Offset: 3}), nil)
+
+	// Names and paths follow renames, soft-removed records are not found, and the index of
+	// a content goes together with its blob.
+	mustNotFail(t, r.UpdateFileRecord("file-report", "budget.txt", "dir-docs"))
+	mustNotFail(t, r.UpdateDirRecord("dir-docs", "papers", models.RootDirUUID))
+	assertNames(t, search("budget", models.SearchOptions{}), []string{"file-report"})
+	assertNames(t, search("annual", models.SearchOptions{}), []string{"file-notes"})
+	assertNames(t, search("papers", models.SearchOptions{}), []string{"dir-docs", "dir-archive", "file-notes", "file-report"})
+	mustNotFail(t, r.SoftRemoveFileRecord("file-photo", "trash-1"))
+	assertNames(t, search("report", models.SearchOptions{}), []string{"file-notes"})
+	mustNotFail(t, hardRemoveFile(r, "file-notes"))
+	indexed, err := r.IsContentIndexed("hash-file-notes")
+	mustNotFail(t, err)
+	if indexed {
+		t.Error("index of a removed content still exists")
+	}
+}
+
+func testAttributes(t *testing.T, r Repository) {
+	// a/f1, f2
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f1", "dir-a", "/f1", 1))
+	mustNotFail(t, insertFile(r, "file-2", "f2", models.RootDirUUID, "/f2", 1))
+	file1 := models.ItemRef{ItemType: models.EntryTypeFile, UUID: "file-1"}
+	file2 := models.ItemRef{ItemType: models.EntryTypeFile, UUID: "file-2"}
+	dirA := models.ItemRef{ItemType: models.EntryTypeDir, UUID: "dir-a"}
+	missing := models.ItemRef{ItemType: models.EntryTypeFile, UUID: "file-3"}
+
+	mustNotFail(t, r.InsertTagRecords([]models.ItemRef{file1, dirA}, "q3-report"))
+	// Tagging twice does nothing, and nothing is tagged if a file/dir does not exist.
+	mustNotFail(t, r.InsertTagRecords([]models.ItemRef{file1}, "q3-report"))
+	mustFailWithCode(t, r.InsertTagRecords([]models.ItemRef{file2, missing}, "q3-report"), models.NotFoundErrorCode)
+	mustFailWithCode(t, r.InsertTagRecords([]models.ItemRef{{ItemType: models.EntryTypeDir, UUID: "file-2"}}, "q3-report"),
+		models.NotFoundErrorCode)
+	mustNotFail(t, r.InsertTagRecords([]models.ItemRef{file1}, "alpha"))
+	tags, err := r.ListTagRecords("file-1")
+	mustNotFail(t, err)
+	assertNames(t, tags, []string{"alpha", "q3-report"})
+	tags, err = r.ListTagRecords("file-2")
+	mustNotFail(t, err)
+	assertNames(t, tags, nil)
+	mustNotFail(t, r.HardRemoveTagRecord("file-1", "alpha"))
+	mustFailWithCode(t, r.HardRemoveTagRecord("file-1", "alpha"), models.NotFoundErrorCode)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	mustNotFail(t, r.UpsertMetadataRecord(file1, models.MetadataEntry{Key: "author", Value: "alice", UpdatedAt: now}))
+	mustNotFail(t, r.UpsertMetadataRecord(file1, models.MetadataEntry{Key: "Status", Value: "draft", UpdatedAt: now}))
+	// Upserting replaces the value.
+	mustNotFail(t, r.UpsertMetadataRecord(file1, models.MetadataEntry{Key: "author", Value: "bob", UpdatedAt: now}))
+	mustFailWithCode(t, r.UpsertMetadataRecord(missing, models.MetadataEntry{Key: "author", Value: "bob", UpdatedAt: now}),
+		models.NotFoundErrorCode)
+	metadata, err := r.ListMetadataRecords("file-1")
+	mustNotFail(t, err)
+	assertNames(t, metadataNames(metadata), []string{"Status=draft", "author=bob"})
+	if !metadata[0].UpdatedAt.Equal(now) {
+		t.Errorf("metadata updated at %v, want %v", metadata[0].UpdatedAt, now)
+	}
+	mustNotFail(t, r.HardRemoveMetadataRecord("file-1", "Status"))
+	mustFailWithCode(t, r.HardRemoveMetadataRecord("file-1", "Status"), models.NotFoundErrorCode)
+
+	// Copies keep their own attributes.
+	mustNotFail(t, r.UpsertMetadataRecord(file2, models.MetadataEntry{Key: "author", Value: "carol", UpdatedAt: now}))
+	mustNotFail(t, r.CopyAttributeRecords("file-1", "file-2"))
+	tags, err = r.ListTagRecords("file-2")
+	mustNotFail(t, err)
+	assertNames(t, tags, []string{"q3-report"})
+	metadata, err = r.ListMetadataRecords("file-2")
+	mustNotFail(t, err)
+	assertNames(t, metadataNames(metadata), []string{"author=carol"})
+
+	// Tags are found by the search, and filter it.
+	search := func(query string, opts models.SearchOptions) []string {
+		t.Helper()
+		opts.Limit = 10
+		hits, err := r.SearchRecords(models.SearchQuery{SearchOptions: opts, Terms: models.ParseSearchQuery(query)})
+		mustNotFail(t, err)
+		var UUIDs []string
+		for _, hit := range hits {
+			UUIDs = append(UUIDs, hit.UUID)
+		}
+		return UUIDs
+	}
+	assertNames(t, search("report", models.SearchOptions{}), []string{"dir-a", "file-1", "file-2"})
+	assertNames(t, search("q3-rep*", models.SearchOptions{}), []string{"dir-a", "file-1", "file-2"})
+	assertNames(t, search("", models.SearchOptions{Tag: "q3-report"}), []string{"dir-a", "file-1", "file-2"})
+	assertNames(t, search("f1", models.SearchOptions{Tag: "q3-report"}), []string{"file-1"})
+	assertNames(t, search("", models.SearchOptions{Tag: "q3"}), nil)
+
+	// Attributes go together with their files/dirs.
+	mustNotFail(t, hardRemoveFile(r, "file-2"))
+	tags, err = r.ListTagRecords("file-2")
+	mustNotFail(t, err)
+	assertNames(t, tags, nil)
+	metadata, err = r.ListMetadataRecords("file-2")
+	mustNotFail(t, err)
+	assertNames(t, metadataNames(metadata), nil)
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-1"))
+	mustFailWithCode(t, r.InsertTagRecords([]models.ItemRef{file1}, "beta"), models.NotFoundErrorCode)
+	assertNames(t, search("", models.SearchOptions{Tag: "q3-report"}), nil)
+	_, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	for _, UUID := range []string{"dir-a", "file-1"} {
+		tags, err = r.ListTagRecords(UUID)
+		mustNotFail(t, err)
+		assertNames(t, tags, nil)
+		metadata, err = r.ListMetadataRecords(UUID)
+		mustNotFail(t, err)
+		assertNames(t, metadataNames(metadata), nil)
+	}
+}
+
+// metadataNames returns metadata entries as key=value.
+func metadataNames(entries []models.MetadataEntry) []string {
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Key+"="+e.Value)
+	}
+	return names
+}
+
+func testThumbnails(t *testing.T, r Repository) {
+	// a/f1, f2
+	mustNotFail(t, r.InsertDirRecord("dir-a", "a", models.RootDirUUID, testOwnerUUID))
+	mustNotFail(t, insertFile(r, "file-1", "f1.png", "dir-a", "/f1", 100))
+	mustNotFail(t, insertFile(r, "file-2", "f2.png", models.RootDirUUID, "/f2", 100))
+	thumbnail := func(fileUUID string, version int, size string) models.Thumbnail {
+		key := fmt.Sprintf("thumb-%s-%d-%s", fileUUID, version, size)
+		return models.Thumbnail{FileUUID: fileUUID, Version: version, Size: size, StorageKey: key, RealPath: "/" + key,
+			ContentType: "image/png", Width: 16, Height: 8, FileSize: 42}
+	}
+
+	stored, err := r.InsertThumbnailRecord(thumbnail("file-1", 1, models.ThumbnailSizeSmall))
+	mustNotFail(t, err)
+	if stored.StorageKey != "thumb-file-1-1-small" || stored.Width != 16 || stored.FileSize != 42 || stored.CreatedAt.IsZero() {
+		t.Errorf("got thumbnail %+v", stored)
+	}
+	// An existing thumbnail is kept.
+	other := thumbnail("file-1", 1, models.ThumbnailSizeSmall)
+	other.StorageKey = "other"
+	stored, err = r.InsertThumbnailRecord(other)
+	mustNotFail(t, err)
+	if stored.StorageKey != "thumb-file-1-1-small" {
+		t.Errorf("got storage key %s, want thumb-file-1-1-small", stored.StorageKey)
+	}
+	read, err := r.ReadThumbnailRecord("file-1", 1, models.ThumbnailSizeSmall)
+	mustNotFail(t, err)
+	if read.StorageKey != stored.StorageKey || read.ContentType != "image/png" || read.Height != 8 {
+		t.Errorf("got thumbnail %+v, want %+v", read, stored)
+	}
+	_, err = r.ReadThumbnailRecord("file-1", 1, models.ThumbnailSizeLarge)
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	// Only the current version gets thumbnails.
+	_, err = r.InsertThumbnailRecord(thumbnail("file-1", 2, models.ThumbnailSizeSmall))
+	mustFailWithCode(t, err, models.ConflictErrorCode)
+	_, err = r.InsertThumbnailRecord(thumbnail("file-3", 1, models.ThumbnailSizeSmall))
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// A new version makes the thumbnails of the old one stale.
+	removed, err := r.HardRemoveStaleThumbnailRecords("file-1")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(removed), nil)
+	_, err = r.InsertVersionRecord("file-1", models.Blob{Hash: "hash-v2", StorageKey: "v2", Size: 200}, "text/plain", testOwnerUUID)
+	mustNotFail(t, err)
+	_, err = r.InsertThumbnailRecord(thumbnail("file-1", 2, models.ThumbnailSizeSmall))
+	mustNotFail(t, err)
+	removed, err = r.HardRemoveStaleThumbnailRecords("file-1")
+	mustNotFail(t, err)
+	assertNames(t, storageKeys(removed), []string{"thumb-file-1-1-small"})
+	_, err = r.ReadThumbnailRecord("file-1", 1, models.ThumbnailSizeSmall)
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+
+	// Thumbnails go together with their files.
+	_, err = r.InsertThumbnailRecord(thumbnail("file-2", 1, models.ThumbnailSizeMedium))
+	mustNotFail(t, err)
+	// All contents and thumbnails are in the storage, e.g. to rotate their keys.
+	keys, err := r.ListStorageKeys()
+	mustNotFail(t, err)
+	assertNames(t, keys, []string{"file-1", "file-2", "thumb-file-1-2-small", "thumb-file-2-1-medium", "v2"})
+	removed, err = r.HardRemoveFileRecord("file-2")
+	mustNotFail(t, err)
+	assertSameNames(t, storageKeys(removed), []string{"file-2", "thumb-file-2-1-medium"})
+	mustNotFail(t, r.SoftRemoveDirRecord("dir-a", "trash-1"))
+	_, err = r.InsertThumbnailRecord(thumbnail("file-1", 2, models.ThumbnailSizeLarge))
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	removed, err = r.HardRemoveTrashRecord("trash-1")
+	mustNotFail(t, err)
+	assertSameNames(t, storageKeys(removed), []string{"file-1", "v2", "thumb-file-1-2-small"})
+	_, err = r.ReadThumbnailRecord("file-1", 2, models.ThumbnailSizeSmall)
+	mustFailWithCode(t, err, models.NotFoundErrorCode)
+	keys, err = r.ListStorageKeys()
+	mustNotFail(t, err)
+	assertNames(t, keys, nil)
+}
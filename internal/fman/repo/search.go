@@ -0,0 +1,330 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// Scores of the matches of a search term. A name or a tag matching a term outweighs a path
+// or a content containing it.
+const (
+	nameMatchScore     = 10
+	tagMatchScore      = 8
+	pathMatchScore     = 3
+	contentPhraseScore = 5
+
+	// maxTermFrequency caps the number of occurrences of a word in a content, which are
+	// counted by the search, so long texts do not outweigh names.
+	maxTermFrequency = 5
+)
+
+// prefixUpperBound is appended to a prefix to get the least string, which is bytewise greater
+// than all strings starting with the prefix, except those continuing with this character.
+const prefixUpperBound = "\U0010FFFF"
+
+// searchWords joins words in the form stored in search_name and search_contents, where
+// every word is surrounded by spaces. It returns an empty string if there are no words.
+func searchWords(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	return " " + strings.Join(words, " ") + " "
+}
+
+// searchName returns the words of a name in the form stored in search_name.
+func searchName(name string) string {
+	return searchWords(models.SearchTokens(name))
+}
+
+// searchTermNeedle returns the substring, which the words of a search term contain in the
+// form stored in search_name and search_contents if the term matches.
+func searchTermNeedle(term models.SearchTerm) string {
+	words := " " + strings.Join(term.Words, " ")
+	if term.Prefix {
+		return words
+	}
+	return words + " "
+}
+
+// searchTermPattern returns the LIKE pattern matching the words of a search term in the
+// form stored in search_name and search_contents.
+func searchTermPattern(term models.SearchTerm) string {
+	return "%" + searchTermNeedle(term) + "%"
+}
+
+// phraseWords returns the distinct words, which a content must contain as whole words to
+// contain a phrase. The last word of a prefix phrase may be incomplete.
+func phraseWords(term models.SearchTerm) []string {
+	words := term.Words
+	if term.Prefix {
+		words = words[:len(words)-1]
+	}
+	var distinct []string
+	for _, word := range words {
+		if !containsString(distinct, word) {
+			distinct = append(distinct, word)
+		}
+	}
+	return distinct
+}
+
+// contentTermFrequencies counts the occurrences of words in a text, capped at
+// maxTermFrequency.
+func contentTermFrequencies(words []string) map[string]int {
+	frequencies := make(map[string]int)
+	for _, word := range words {
+		if frequencies[word] < maxTermFrequency {
+			frequencies[word]++
+		}
+	}
+	return frequencies
+}
+
+// IsContentIndexed checks if the text of a content is indexed in DB.
+func (r *sqlRepo) IsContentIndexed(contentHash string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(r.q("SELECT EXISTS (SELECT 1 FROM search_contents WHERE content_hash = ?)"), contentHash).Scan(&exists)
+	return exists, err
+}
+
+// IndexContentRecord indexes the text of a content in DB, unless it is already indexed or
+// its blob does not exist anymore.
+func (r *sqlRepo) IndexContentRecord(contentHash, text string) error {
+	words := models.SearchTokens(text)
+	return r.withTx(func(tx *sql.Tx) error {
+		// The blob is locked, so it cannot be released before its index is complete.
+		var exists bool
+		err := tx.QueryRow(r.q("SELECT TRUE FROM blobs WHERE hash = ?"+r.dialect.lockClause), contentHash).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(r.q(`INSERT INTO search_contents (content_hash, words, indexed_at) VALUES (?, ?, ?)
+			ON CONFLICT (content_hash) DO NOTHING`), contentHash, searchWords(words), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+			return err
+		}
+		stmt, err := tx.Prepare(r.q("INSERT INTO search_terms (term, content_hash, frequency) VALUES (?, ?, ?)"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for term, frequency := range contentTermFrequencies(words) {
+			if _, err := stmt.Exec(term, contentHash, frequency); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// removeContentIndex removes the index of a content from DB.
+func (r *sqlRepo) removeContentIndex(tx *sql.Tx, contentHash string) error {
+	if _, err := tx.Exec(r.q("DELETE FROM search_terms WHERE content_hash = ?"), contentHash); err != nil {
+		return err
+	}
+	_, err := tx.Exec(r.q("DELETE FROM search_contents WHERE content_hash = ?"), contentHash)
+	return err
+}
+
+// ListUnindexedFileRecords lists the file records, which are not soft-removed and whose
+// content is not indexed, from DB.
+func (r *sqlRepo) ListUnindexedFileRecords() ([]models.File, error) {
+	rows, err := r.db.Query(r.q(`SELECT ` + fileColumns + ` FROM files f JOIN blobs b ON b.hash = f.content_hash
+		WHERE f.is_deleted = FALSE AND NOT EXISTS (SELECT 1 FROM search_contents c WHERE c.content_hash = f.content_hash)
+		ORDER BY f.uuid`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var files []models.File
+	for rows.Next() {
+		file, err := scanFile(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// searchItems selects the files and directories, which are not soft-removed, except root
+// directories, with the columns used by the search.
+const searchItems = `SELECT 'file' AS item_type, uuid, path, parent_uuid, owner_uuid, file_size AS size, updated_at,
+		content_hash, search_name FROM files WHERE is_deleted = FALSE
+	UNION ALL
+	SELECT 'dir', uuid, path, parent_uuid, owner_uuid, 0, updated_at, '', search_name FROM directories
+		WHERE is_deleted = FALSE AND parent_uuid IS NOT NULL`
+
+// SearchRecords finds the files/dirs matching a query in DB. Every term gets a score of its
+// own, which is positive if the term matches, and the scores of all terms are summed up.
+func (r *sqlRepo) SearchRecords(query models.SearchQuery) ([]models.SearchHit, error) {
+	// Without terms, all files/dirs match with a score of zero.
+	scores, columns, matched := []string{"0 AS s"}, []string{"s"}, []string{"TRUE"}
+	var ctes, conds []string
+	var args []interface{}
+	if len(query.ScopeUUIDs) > 0 {
+		seeds := make([]string, len(query.ScopeUUIDs))
+		for i, UUID := range query.ScopeUUIDs {
+			seeds[i] = "SELECT CAST(? AS TEXT) AS uuid"
+			args = append(args, UUID)
+		}
+		ctes = append(ctes, `scope(uuid) AS (
+			SELECT uuid FROM (`+strings.Join(seeds, " UNION ALL ")+`) seeds
+			UNION
+			SELECT d.uuid FROM directories d JOIN scope s ON d.parent_uuid = s.uuid
+		)`)
+		conds = append(conds, "(i.uuid IN (SELECT uuid FROM scope) OR i.parent_uuid IN (SELECT uuid FROM scope))")
+	}
+	if query.UnderDirUUID != "" {
+		ctes = append(ctes, `under(uuid) AS (
+			SELECT CAST(? AS TEXT)
+			UNION ALL
+			SELECT d.uuid FROM directories d JOIN under u ON d.parent_uuid = u.uuid
+		)`)
+		args = append(args, query.UnderDirUUID)
+		conds = append(conds, "i.parent_uuid IN (SELECT uuid FROM under)")
+	}
+	for i, term := range query.Terms {
+		score, scoreArgs := searchTermScore(term)
+		column := "s" + strconv.Itoa(i)
+		scores = append(scores, score+" AS "+column)
+		columns = append(columns, column)
+		matched = append(matched, column+" > 0")
+		args = append(args, scoreArgs...)
+	}
+	if query.Tag != "" {
+		conds = append(conds, "EXISTS (SELECT 1 FROM item_tags g WHERE g.item_uuid = i.uuid AND g.tag = ?)")
+		args = append(args, query.Tag)
+	}
+	if query.Type != "" {
+		conds = append(conds, "i.item_type = ?")
+		args = append(args, query.Type)
+	}
+	if query.MinSize > 0 {
+		conds = append(conds, "i.size >= ?")
+		args = append(args, query.MinSize)
+	}
+	if query.MaxSize > 0 {
+		conds = append(conds, "i.item_type = 'file' AND i.size <= ?")
+		args = append(args, query.MaxSize)
+	}
+	if !query.UpdatedAfter.IsZero() {
+		conds = append(conds, "i.updated_at >= ?")
+		args = append(args, query.UpdatedAfter.UTC())
+	}
+	if !query.UpdatedBefore.IsZero() {
+		conds = append(conds, "i.updated_at < ?")
+		args = append(args, query.UpdatedBefore.UTC())
+	}
+	if query.OwnerUUID != "" {
+		conds = append(conds, "i.owner_uuid = ?")
+		args = append(args, query.OwnerUUID)
+	}
+	stmt := ""
+	if len(ctes) > 0 {
+		stmt = "WITH RECURSIVE " + strings.Join(ctes, ", ") + " "
+	}
+	conds = append(conds, "TRUE")
+	stmt += fmt.Sprintf(`SELECT item_type, uuid, %s AS score FROM (
+			SELECT i.item_type, i.uuid, i.path, %s FROM (%s) i WHERE %s
+		) r WHERE %s ORDER BY score DESC, path, uuid LIMIT ? OFFSET ?`,
+		strings.Join(columns, " + "), strings.Join(scores, ", "), searchItems, strings.Join(conds, " AND "), strings.Join(matched, " AND "))
+	args = append(args, query.Limit, query.Offset)
+	rows, err := r.db.Query(r.q(stmt), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hits []models.SearchHit
+	for rows.Next() {
+		var hit models.SearchHit
+		if err := rows.Scan(&hit.ItemType, &hit.UUID, &hit.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// searchTermScore returns an expression scoring a search term on an item i of searchItems
+// together with its parameters.
+func searchTermScore(term models.SearchTerm) (string, []interface{}) {
+	score := fmt.Sprintf(`CASE WHEN i.search_name LIKE ? THEN %d ELSE 0 END
+		+ CASE WHEN EXISTS (SELECT 1 FROM item_tags g WHERE g.item_uuid = i.uuid AND g.search_tag LIKE ?) THEN %d ELSE 0 END`,
+		nameMatchScore, tagMatchScore)
+	args := []interface{}{searchTermPattern(term), searchTermPattern(term)}
+	pathConds := make([]string, len(term.Words))
+	for i, word := range term.Words {
+		pathConds[i] = "LOWER(i.path) LIKE ?"
+		args = append(args, "%"+word+"%")
+	}
+	score += fmt.Sprintf(" + CASE WHEN %s THEN %d ELSE 0 END", strings.Join(pathConds, " AND "), pathMatchScore)
+	switch {
+	case len(term.Words) == 1 && !term.Prefix:
+		score += ` + COALESCE((SELECT t.frequency FROM search_terms t
+			WHERE t.content_hash = i.content_hash AND t.term = ?), 0)`
+		args = append(args, term.Words[0])
+	case len(term.Words) == 1:
+		score += ` + COALESCE((SELECT MAX(t.frequency) FROM search_terms t
+			WHERE t.content_hash = i.content_hash AND t.term >= ? AND t.term < ?), 0)`
+		args = append(args, term.Words[0], term.Words[0]+prefixUpperBound)
+	default:
+		// Only contents containing all whole words of a phrase are scanned for the phrase.
+		distinct := phraseWords(term)
+		for _, word := range distinct {
+			args = append(args, word)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(distinct)), ", ")
+		score += fmt.Sprintf(` + CASE WHEN (SELECT COUNT(*) FROM search_terms t
+				WHERE t.content_hash = i.content_hash AND t.term IN (%s)) = %d
+			THEN CASE WHEN EXISTS (SELECT 1 FROM search_contents c WHERE c.content_hash = i.content_hash AND c.words LIKE ?)
+				THEN %d ELSE 0 END
+			ELSE 0 END`, placeholders, len(distinct), contentPhraseScore)
+		args = append(args, searchTermPattern(term))
+	}
+	return "(" + score + ")", args
+}
+
+// backfillSearchNames fills the search_name column of existing files and directories.
+func backfillSearchNames(tx *sql.Tx, d dialect) error {
+	for _, table := range []struct{ name, nameCol string }{
+		{"directories", "dirname"},
+		{"files", "filename"},
+	} {
+		rows, err := tx.Query(fmt.Sprintf("SELECT uuid, %s FROM %s", table.nameCol, table.name))
+		if err != nil {
+			return err
+		}
+		names := make(map[string]string)
+		for rows.Next() {
+			var entryUUID, name string
+			if err := rows.Scan(&entryUUID, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			names[entryUUID] = searchName(name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for entryUUID, name := range names {
+			_, err := tx.Exec(rebind(d, fmt.Sprintf("UPDATE %s SET search_name = ? WHERE uuid = ?", table.name)), name, entryUUID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// shareLinkColumns are the columns of share_links scanned by scanShareLink.
+const shareLinkColumns = `uuid, token, item_uuid, item_type, owner_uuid, password_hash, mode, max_downloads, downloads,
+	expires_at, created_at`
+
+// InsertShareLinkRecord inserts a new share link record to DB.
+func (r *sqlRepo) InsertShareLinkRecord(link models.ShareLink) error {
+	var expiresAt sql.NullTime
+	if link.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: link.ExpiresAt.UTC(), Valid: true}
+	}
+	_, err := r.db.Exec(r.q(`INSERT INTO share_links (`+shareLinkColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		link.UUID, link.Token, link.ItemUUID, link.ItemType, link.OwnerUUID, link.PasswordHash, link.Mode, link.MaxDownloads,
+		link.Downloads, expiresAt, link.CreatedAt.UTC())
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("share link %s already exists", link.UUID))
+	}
+	return err
+}
+
+// ReadShareLinkRecord reads a share link record from DB.
+func (r *sqlRepo) ReadShareLinkRecord(UUID string) (models.ShareLink, error) {
+	return r.readShareLink(fmt.Sprintf("share link %s does not exist", UUID), "uuid = ?", UUID)
+}
+
+// ReadShareLinkRecordByToken reads a share link record with a given token from DB.
+func (r *sqlRepo) ReadShareLinkRecordByToken(token string) (models.ShareLink, error) {
+	// The token is secret, so it is not part of the message.
+	return r.readShareLink("share link does not exist", "token = ?", token)
+}
+
+// readShareLink reads the share link record matching a condition from DB. A missing record
+// is converted to FManError with a message.
+func (r *sqlRepo) readShareLink(notFoundMsg, cond string, args ...interface{}) (models.ShareLink, error) {
+	link, err := scanShareLink(r.db.QueryRow(r.q("SELECT "+shareLinkColumns+" FROM share_links WHERE "+cond), args...).Scan)
+	if err == sql.ErrNoRows {
+		return models.ShareLink{}, models.NewFManError(models.NotFoundErrorCode, notFoundMsg)
+	}
+	return link, err
+}
+
+// ListShareLinkRecords lists the share link records of an owner from DB, most recently
+// created first.
+func (r *sqlRepo) ListShareLinkRecords(ownerUUID string) ([]models.ShareLink, error) {
+	rows, err := r.db.Query(r.q("SELECT "+shareLinkColumns+" FROM share_links WHERE owner_uuid = ? ORDER BY created_at DESC, uuid"),
+		ownerUUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var links []models.ShareLink
+	for rows.Next() {
+		link, err := scanShareLink(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// CountShareLinkDownload increments the number of downloads of a share link in DB, unless
+// it reached its download limit.
+func (r *sqlRepo) CountShareLinkDownload(UUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var maxDownloads, downloads int
+		err := tx.QueryRow(r.q("SELECT max_downloads, downloads FROM share_links WHERE uuid = ?"+r.dialect.lockClause), UUID).
+			Scan(&maxDownloads, &downloads)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("share link %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		if maxDownloads > 0 && downloads >= maxDownloads {
+			return models.NewFManError(models.ExpiredErrorCode, "share link reached its download limit")
+		}
+		_, err = tx.Exec(r.q("UPDATE share_links SET downloads = downloads + 1 WHERE uuid = ?"), UUID)
+		return err
+	})
+}
+
+// HardRemoveShareLinkRecord removes a share link record from DB.
+func (r *sqlRepo) HardRemoveShareLinkRecord(UUID string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM share_links WHERE uuid = ?"), UUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("share link %s does not exist", UUID))
+}
+
+// scanShareLink scans a share link selected with shareLinkColumns.
+func scanShareLink(scan func(dest ...interface{}) error) (models.ShareLink, error) {
+	var link models.ShareLink
+	var expiresAt sql.NullTime
+	err := scan(&link.UUID, &link.Token, &link.ItemUUID, &link.ItemType, &link.OwnerUUID, &link.PasswordHash, &link.Mode,
+		&link.MaxDownloads, &link.Downloads, &expiresAt, &link.CreatedAt)
+	if err != nil {
+		return models.ShareLink{}, err
+	}
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	link.HasPassword = link.PasswordHash != ""
+	return link, nil
+}
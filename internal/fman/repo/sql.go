@@ -0,0 +1,535 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// dialect holds the differences between the SQL databases supported by sqlRepo.
+type dialect struct {
+	// Name of the dialect, which is also the directory of its migrations.
+	name string
+
+	// numberedParams is true if the database uses $1, $2, ... instead of ? as placeholders.
+	numberedParams bool
+
+	// lockClause is appended to SELECT statements which read a row that will be
+	// modified, or whose existence the transaction relies on.
+	lockClause string
+
+	// lockMigrationsStmt locks the schema_migrations table until the end of the
+	// transaction. It is empty if transactions already lock the whole database.
+	lockMigrationsStmt string
+
+	// isUniqueViolation checks if an error is caused by a unique/primary key constraint.
+	isUniqueViolation func(err error) bool
+}
+
+// sqlRepo implements file manager repositories on top of database/sql. Queries are
+// written with ? placeholders and rebound for the dialect in use.
+type sqlRepo struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// Close closes the underlying database.
+func (r *sqlRepo) Close() error {
+	return r.db.Close()
+}
+
+// InsertFileRecord inserts a new file record to DB together with its first version, adds
+// a reference to the blob of its content and counts its size as used by the owner.
+func (r *sqlRepo) InsertFileRecord(UUID, filename, parentUUID, ownerUUID, contentType string, blob models.Blob) (models.Blob, error) {
+	var stored models.Blob
+	err := r.withTx(func(tx *sql.Tx) error {
+		parentPath, err := r.readParentPath(tx, parentUUID)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNameAvailable(tx, filename, parentUUID, ""); err != nil {
+			return err
+		}
+		stored, err = r.acquireBlob(tx, blob)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		_, err = tx.Exec(r.q(`INSERT INTO files (uuid, filename, name_key, search_name, path, real_path, parent_uuid, owner_uuid, file_size,
+			content_hash, content_type, version, is_deleted, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 1, FALSE, ?, ?)`),
+			UUID, filename, naturalSortKey(filename), searchName(filename), path.Join(parentPath, filename), stored.RealPath, parentUUID, ownerUUID,
+			stored.Size, stored.Hash, contentType, now, now)
+		if err != nil {
+			return r.convertErr(err)
+		}
+		_, err = tx.Exec(r.q(`INSERT INTO file_versions (file_uuid, version, content_hash, content_type, file_size, uploaded_by,
+			created_at) VALUES (?, 1, ?, ?, ?, ?, ?)`), UUID, stored.Hash, contentType, stored.Size, ownerUUID, now)
+		if err != nil {
+			return err
+		}
+		return r.addUsage(tx, map[string]int64{ownerUUID: stored.Size})
+	})
+	if err != nil {
+		return models.Blob{}, err
+	}
+	return stored, nil
+}
+
+// ReadFileRecord reads a file record, which is not soft-removed, from DB.
+func (r *sqlRepo) ReadFileRecord(UUID string) (models.File, error) {
+	return r.readFile(fmt.Sprintf("file %s does not exist", UUID), "f.uuid = ?", UUID)
+}
+
+// ReadFileRecordByName reads a file record, which is not soft-removed, with a given name
+// in a parent directory from DB.
+func (r *sqlRepo) ReadFileRecordByName(filename, parentUUID string) (models.File, error) {
+	return r.readFile(fmt.Sprintf("file %s does not exist in the desired location", filename),
+		"f.parent_uuid = ? AND f.filename = ?", parentUUID, filename)
+}
+
+// readFile reads the file record, which is not soft-removed, matching a condition from DB.
+// A missing record is converted to FManError with a message.
+func (r *sqlRepo) readFile(notFoundMsg, cond string, args ...interface{}) (models.File, error) {
+	file, err := scanFile(r.db.QueryRow(r.q(`SELECT `+fileColumns+`
+		FROM files f JOIN blobs b ON b.hash = f.content_hash WHERE `+cond+` AND f.is_deleted = FALSE`), args...).Scan)
+	if err == sql.ErrNoRows {
+		return models.File{}, models.NewFManError(models.NotFoundErrorCode, notFoundMsg)
+	}
+	return file, err
+}
+
+// fileColumns are the columns of files f joined with blobs b, which are scanned by scanFile.
+const fileColumns = `f.uuid, f.filename, f.path, f.real_path, f.parent_uuid, f.owner_uuid, f.file_size, b.stored_size,
+	f.content_hash, b.md5, f.content_type, b.storage_key, f.version, f.max_versions, f.max_version_age, f.created_at,
+	f.updated_at`
+
+// scanFile scans a file record selected with fileColumns.
+func scanFile(scan func(dest ...interface{}) error) (models.File, error) {
+	var file models.File
+	var fileSize, storedSize, maxVersionAge int64
+	err := scan(&file.UUID, &file.Filename, &file.Path, &file.RealPath, &file.ParentUUID, &file.OwnerUUID, &fileSize,
+		&storedSize, &file.ContentHash, &file.ContentMD5, &file.ContentType, &file.StorageKey, &file.Version,
+		&file.VersionPolicy.MaxVersions, &maxVersionAge, &file.CreatedAt, &file.UpdatedAt)
+	if err != nil {
+		return models.File{}, err
+	}
+	file.FileSize = uint64(fileSize)
+	file.StoredSize = uint64(storedSize)
+	file.VersionPolicy.MaxAge = time.Duration(maxVersionAge) * time.Second
+	return file, nil
+}
+
+// UpdateFileRecord renames and/or moves a file record in DB.
+func (r *sqlRepo) UpdateFileRecord(UUID, filename, parentUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(r.q("SELECT TRUE FROM files WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), UUID).
+			Scan(&exists)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		parentPath, err := r.readParentPath(tx, parentUUID)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNameAvailable(tx, filename, parentUUID, UUID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(`UPDATE files SET filename = ?, name_key = ?, search_name = ?, parent_uuid = ?, path = ?, updated_at = ?
+			WHERE uuid = ?`),
+			filename, naturalSortKey(filename), searchName(filename), parentUUID, path.Join(parentPath, filename), time.Now().UTC(), UUID)
+		return r.convertErr(err)
+	})
+}
+
+// SoftRemoveFileRecord flags a file record as deleted in DB and records it in the recycle bin.
+func (r *sqlRepo) SoftRemoveFileRecord(UUID, trashUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var filename, filePath, parentUUID, ownerUUID string
+		err := tx.QueryRow(r.q("SELECT filename, path, parent_uuid, owner_uuid FROM files WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), UUID).
+			Scan(&filename, &filePath, &parentUUID, &ownerUUID)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if err := r.insertTrashEntry(tx, trashUUID, UUID, models.EntryTypeFile, filename, parentUUID, filePath, ownerUUID, now); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("UPDATE files SET is_deleted = TRUE, trash_uuid = ?, updated_at = ? WHERE uuid = ?"),
+			trashUUID, now, UUID)
+		return err
+	})
+}
+
+// HardRemoveFileRecord removes a file record, either soft-removed or not, together with its
+// versions from DB, and releases their references to the blobs of their content.
+func (r *sqlRepo) HardRemoveFileRecord(UUID string) ([]models.Blob, error) {
+	var removed []models.Blob
+	err := r.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(r.q("SELECT TRUE FROM files WHERE uuid = ?"+r.dialect.lockClause), UUID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		images, err := r.removeThumbnails(tx, "", "?", "", UUID)
+		if err != nil {
+			return err
+		}
+		contentHashes, err := r.removeFileVersions(tx, "", "?", UUID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM acl_entries WHERE item_uuid = ?"), UUID); err != nil {
+			return err
+		}
+		if err := r.removeAttributes(tx, "DELETE FROM %s WHERE item_uuid = ?", UUID); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM files WHERE uuid = ?"), UUID); err != nil {
+			return err
+		}
+		if removed, err = r.releaseBlobs(tx, contentHashes); err != nil {
+			return err
+		}
+		removed = append(removed, images...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// InsertDirRecord inserts a new directory record to DB.
+func (r *sqlRepo) InsertDirRecord(UUID, dirname, parentUUID, ownerUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		parentPath, err := r.readParentPath(tx, parentUUID)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNameAvailable(tx, dirname, parentUUID, ""); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		_, err = tx.Exec(r.q(`INSERT INTO directories (uuid, dirname, name_key, search_name, path, parent_uuid, owner_uuid, is_deleted,
+			created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, FALSE, ?, ?)`),
+			UUID, dirname, naturalSortKey(dirname), searchName(dirname), path.Join(parentPath, dirname), parentUUID, ownerUUID, now, now)
+		return r.convertErr(err)
+	})
+}
+
+// ReadDirRecord reads a directory record, which is not soft-removed, from DB.
+func (r *sqlRepo) ReadDirRecord(UUID string) (models.Directory, error) {
+	return r.readDir(fmt.Sprintf("directory %s does not exist", UUID), "uuid = ?", UUID)
+}
+
+// ReadDirRecordByName reads a directory record, which is not soft-removed, with a given name
+// in a parent directory from DB.
+func (r *sqlRepo) ReadDirRecordByName(dirname, parentUUID string) (models.Directory, error) {
+	return r.readDir(fmt.Sprintf("directory %s does not exist in the desired location", dirname),
+		"parent_uuid = ? AND dirname = ?", parentUUID, dirname)
+}
+
+// readDir reads the directory record, which is not soft-removed, matching a condition from DB.
+// A missing record is converted to FManError with a message.
+func (r *sqlRepo) readDir(notFoundMsg, cond string, args ...interface{}) (models.Directory, error) {
+	var dir models.Directory
+	var parentUUID sql.NullString
+	err := r.db.QueryRow(r.q(`SELECT uuid, dirname, path, parent_uuid, owner_uuid, created_at, updated_at
+		FROM directories WHERE `+cond+` AND is_deleted = FALSE`), args...).
+		Scan(&dir.UUID, &dir.Dirname, &dir.Path, &parentUUID, &dir.OwnerUUID, &dir.CreatedAt, &dir.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.Directory{}, models.NewFManError(models.NotFoundErrorCode, notFoundMsg)
+	}
+	if err != nil {
+		return models.Directory{}, err
+	}
+	dir.ParentUUID = parentUUID.String
+	return dir, nil
+}
+
+// UpdateDirRecord renames and/or moves a directory record in DB, and rewrites
+// the paths of all its descendants.
+func (r *sqlRepo) UpdateDirRecord(UUID, dirname, parentUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var oldPath string
+		var oldParentUUID sql.NullString
+		err := tx.QueryRow(r.q("SELECT path, parent_uuid FROM directories WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), UUID).
+			Scan(&oldPath, &oldParentUUID)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		if !oldParentUUID.Valid {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be updated")
+		}
+		parentPath, err := r.readParentPath(tx, parentUUID)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNotInSubtree(tx, parentUUID, UUID); err != nil {
+			return err
+		}
+		if err := r.checkNameAvailable(tx, dirname, parentUUID, UUID); err != nil {
+			return err
+		}
+		newPath := path.Join(parentPath, dirname)
+		now := time.Now().UTC()
+		_, err = tx.Exec(r.q(`UPDATE directories SET dirname = ?, name_key = ?, search_name = ?, parent_uuid = ?, path = ?,
+			updated_at = ? WHERE uuid = ?`),
+			dirname, naturalSortKey(dirname), searchName(dirname), parentUUID, newPath, now, UUID)
+		if err != nil {
+			return r.convertErr(err)
+		}
+		return r.rewriteSubtreePaths(tx, UUID, oldPath, newPath)
+	})
+}
+
+// SoftRemoveDirRecord flags a directory record and all its descendants as deleted in DB,
+// and records the subtree in the recycle bin.
+func (r *sqlRepo) SoftRemoveDirRecord(UUID, trashUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var dirname, dirPath, ownerUUID string
+		var parentUUID sql.NullString
+		err := tx.QueryRow(r.q(`SELECT dirname, path, parent_uuid, owner_uuid FROM directories
+			WHERE uuid = ? AND is_deleted = FALSE`+r.dialect.lockClause), UUID).
+			Scan(&dirname, &dirPath, &parentUUID, &ownerUUID)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		if !parentUUID.Valid {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+		}
+		now := time.Now().UTC()
+		err = r.insertTrashEntry(tx, trashUUID, UUID, models.EntryTypeDir, dirname, parentUUID.String, dirPath, ownerUUID, now)
+		if err != nil {
+			return err
+		}
+		// Descendants which are already in the recycle bin keep their own entries.
+		_, err = tx.Exec(r.q(subtreeCTE+`UPDATE files SET is_deleted = TRUE, trash_uuid = ?
+			WHERE parent_uuid IN (SELECT uuid FROM subtree) AND is_deleted = FALSE`), UUID, trashUUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(subtreeCTE+`UPDATE directories SET is_deleted = TRUE, trash_uuid = ?
+			WHERE uuid IN (SELECT uuid FROM subtree) AND is_deleted = FALSE`), UUID, trashUUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("UPDATE directories SET updated_at = ? WHERE uuid = ?"), now, UUID)
+		return err
+	})
+}
+
+// HardRemoveDirRecord removes an empty directory record, either soft-removed or not, from DB.
+func (r *sqlRepo) HardRemoveDirRecord(UUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		var parentUUID sql.NullString
+		err := tx.QueryRow(r.q("SELECT parent_uuid FROM directories WHERE uuid = ?"+r.dialect.lockClause), UUID).Scan(&parentUUID)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		if !parentUUID.Valid {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+		}
+		var hasChildren bool
+		err = tx.QueryRow(r.q(`SELECT EXISTS (SELECT 1 FROM files WHERE parent_uuid = ?)
+			OR EXISTS (SELECT 1 FROM directories WHERE parent_uuid = ?)`), UUID, UUID).Scan(&hasChildren)
+		if err != nil {
+			return err
+		}
+		if hasChildren {
+			return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("directory %s is not empty", UUID))
+		}
+		if _, err := tx.Exec(r.q("DELETE FROM acl_entries WHERE item_uuid = ?"), UUID); err != nil {
+			return err
+		}
+		if err := r.removeAttributes(tx, "DELETE FROM %s WHERE item_uuid = ?", UUID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("DELETE FROM directories WHERE uuid = ?"), UUID)
+		return err
+	})
+}
+
+// IsNameExist checks if a file/dir, which is not soft-removed, with a given name exists
+// in a parent directory.
+func (r *sqlRepo) IsNameExist(filename, parentUUID string) (bool, error) {
+	var isExist bool
+	err := r.db.QueryRow(r.q(`SELECT EXISTS (SELECT 1 FROM files WHERE parent_uuid = ? AND filename = ? AND is_deleted = FALSE)
+		OR EXISTS (SELECT 1 FROM directories WHERE parent_uuid = ? AND dirname = ? AND is_deleted = FALSE)`),
+		parentUUID, filename, parentUUID, filename).Scan(&isExist)
+	return isExist, err
+}
+
+// IsParentUUIDExist checks if a directory, which is not soft-removed, exists with a given UUID.
+func (r *sqlRepo) IsParentUUIDExist(parentUUID string) (bool, error) {
+	var isExist bool
+	err := r.db.QueryRow(r.q("SELECT EXISTS (SELECT 1 FROM directories WHERE uuid = ? AND is_deleted = FALSE)"), parentUUID).
+		Scan(&isExist)
+	return isExist, err
+}
+
+// withTx runs fn in a transaction, which is committed if fn succeeds and rolled back otherwise.
+func (r *sqlRepo) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return r.convertErr(tx.Commit())
+}
+
+// readParentPath returns the path of a parent directory, which is not soft-removed.
+// The parent row stays locked until the end of the transaction, so concurrent
+// transactions cannot add a conflicting name to, or remove, the same parent.
+func (r *sqlRepo) readParentPath(tx *sql.Tx, parentUUID string) (string, error) {
+	var parentPath string
+	err := tx.QueryRow(r.q("SELECT path FROM directories WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), parentUUID).
+		Scan(&parentPath)
+	if err == sql.ErrNoRows {
+		return "", models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("parent UUID (%s) does not exist", parentUUID))
+	}
+	return parentPath, err
+}
+
+// checkNameAvailable returns an error if a file/dir other than exceptUUID already
+// takes a name in a parent directory.
+func (r *sqlRepo) checkNameAvailable(tx *sql.Tx, name, parentUUID, exceptUUID string) error {
+	var isExist bool
+	err := tx.QueryRow(r.q(`SELECT EXISTS (SELECT 1 FROM files WHERE parent_uuid = ? AND filename = ? AND is_deleted = FALSE AND uuid <> ?)
+		OR EXISTS (SELECT 1 FROM directories WHERE parent_uuid = ? AND dirname = ? AND is_deleted = FALSE AND uuid <> ?)`),
+		parentUUID, name, exceptUUID, parentUUID, name, exceptUUID).Scan(&isExist)
+	if err != nil {
+		return err
+	}
+	if isExist {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", name))
+	}
+	return nil
+}
+
+// subtreeCTE selects the UUIDs of a directory, given as the first parameter, and of all
+// its descendants as the subtree table.
+const subtreeCTE = `WITH RECURSIVE subtree(uuid) AS (
+		SELECT CAST(? AS TEXT)
+		UNION ALL
+		SELECT d.uuid FROM directories d JOIN subtree s ON d.parent_uuid = s.uuid
+	)
+	`
+
+// checkNotInSubtree returns an error if a directory is dirUUID itself or lies beneath it.
+func (r *sqlRepo) checkNotInSubtree(tx *sql.Tx, parentUUID, dirUUID string) error {
+	// Walk up from the parent to the root.
+	var isInSubtree bool
+	err := tx.QueryRow(r.q(`WITH RECURSIVE ancestors(uuid) AS (
+			SELECT CAST(? AS TEXT)
+			UNION ALL
+			SELECT d.parent_uuid FROM directories d JOIN ancestors a ON d.uuid = a.uuid
+			WHERE d.parent_uuid IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM ancestors WHERE uuid = ?)`), parentUUID, dirUUID).Scan(&isInSubtree)
+	if err != nil {
+		return err
+	}
+	if isInSubtree {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "a directory cannot be moved into itself or its descendants")
+	}
+	return nil
+}
+
+// rewriteSubtreePaths replaces the old path prefix of every descendant of a directory,
+// either soft-removed or not, with the new one.
+func (r *sqlRepo) rewriteSubtreePaths(tx *sql.Tx, dirUUID, oldPath, newPath string) error {
+	// substr() counts characters, not bytes.
+	_, err := tx.Exec(r.q(`WITH RECURSIVE subtree(uuid) AS (
+			SELECT uuid FROM directories WHERE parent_uuid = ?
+			UNION ALL
+			SELECT d.uuid FROM directories d JOIN subtree s ON d.parent_uuid = s.uuid
+		)
+		UPDATE directories SET path = CAST(? AS TEXT) || substr(path, ?)
+		WHERE uuid IN (SELECT uuid FROM subtree)`),
+		dirUUID, newPath, utf8.RuneCountInString(oldPath)+1)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(r.q(subtreeCTE+`UPDATE files SET path = CAST(? AS TEXT) || substr(path, ?)
+		WHERE parent_uuid IN (SELECT uuid FROM subtree)`),
+		dirUUID, newPath, utf8.RuneCountInString(oldPath)+1)
+	return err
+}
+
+// convertErr converts unique/primary key constraint violations to FManError.
+func (r *sqlRepo) convertErr(err error) error {
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, "name already exists in the desired location")
+	}
+	return err
+}
+
+// q rebinds the ? placeholders of a query for the dialect in use.
+func (r *sqlRepo) q(query string) string {
+	return rebind(r.dialect, query)
+}
+
+// rebind replaces ? placeholders outside of string literals with $1, $2, ...
+// if the dialect uses numbered parameters.
+func rebind(d dialect, query string) string {
+	if !d.numberedParams {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	inLiteral := false
+	for _, c := range query {
+		switch {
+		case c == '\'':
+			inLiteral = !inLiteral
+		case c == '?' && !inLiteral:
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// checkAffected returns a not found error if no rows were affected.
+func checkAffected(res sql.Result, notFoundMsg string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.NewFManError(models.NotFoundErrorCode, notFoundMsg)
+	}
+	return nil
+}
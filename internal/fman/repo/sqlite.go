@@ -1,59 +1,44 @@
 package repo
 
-import "github.com/nvthongswansea/xtreme/internal/models"
-
-type FManSQLiteRepo struct{}
-
-// NewFManSQLiteRepo returns a new FManSQLiteRepo.
-func NewFManSQLiteRepo() *FManSQLiteRepo {
-	return &FManSQLiteRepo{}
-}
-
-// InsertFileRecord insert a new file record to SQLite DB.
-func (m *FManSQLiteRepo) InsertFileRecord(UUID, filename, parentUUID, realPath string, fileSize int64) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) ReadFileRecord(UUID string) (models.File, error) {
-	return models.File{}, nil
-}
-
-func (m *FManSQLiteRepo) UpdateFileRecord(filename, parentUUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) SoftRemoveFileRecord(UUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) HardRemoveFileRecord(UUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) InsertDirRecord(UUID, dirname, parentUUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) ReadDirRecord(UUID string) (models.Directory, error) {
-	return models.Directory{}, nil
-}
-
-func (m *FManSQLiteRepo) UpdateDirRecord(filename, parentUUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) SoftRemoveDirRecord(UUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) HardRemoveDirRecord(UUID string) error {
-	return nil
-}
-
-func (m *FManSQLiteRepo) IsNameExist(filename, parentUUID string) (bool, error) {
-	return false, nil
-}
-
-func (m *FManSQLiteRepo) IsParentUUIDExist(parentUUID string) (bool, error) {
-	return true, nil
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDialect is the dialect of SQLite.
+var sqliteDialect = dialect{
+	name:           "sqlite",
+	numberedParams: false,
+	// Transactions already hold the database write lock (see _txlock in NewFManSQLiteRepo).
+	lockClause: "",
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && (sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+	},
+}
+
+// FManSQLiteRepo provides file manager repositories stored in a SQLite database.
+type FManSQLiteRepo struct {
+	sqlRepo
+}
+
+// NewFManSQLiteRepo opens (or creates) the SQLite database at dbPath, applies
+// pending schema migrations and returns a new FManSQLiteRepo.
+func NewFManSQLiteRepo(dbPath string) (*FManSQLiteRepo, error) {
+	// Transactions take the write lock immediately, so concurrent writers
+	// wait on the busy timeout instead of failing on lock upgrade.
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=1&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate", dbPath)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := runMigrations(db, sqliteDialect); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &FManSQLiteRepo{sqlRepo{db: db, dialect: sqliteDialect}}, nil
 }
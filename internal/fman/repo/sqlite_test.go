@@ -0,0 +1,46 @@
+package repo_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/fman/repo/repotest"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+func TestFManSQLiteRepo(t *testing.T) {
+	repotest.RunConformanceTests(t, func(t *testing.T) repotest.Repository {
+		return newSQLiteRepo(t, filepath.Join(t.TempDir(), "xtreme.db"))
+	})
+}
+
+// newSQLiteRepo opens the SQLite database at dbPath, which is closed when the test ends.
+func newSQLiteRepo(t *testing.T, dbPath string) *repo.FManSQLiteRepo {
+	t.Helper()
+	r, err := repo.NewFManSQLiteRepo(dbPath)
+	if err != nil {
+		t.Fatalf("NewFManSQLiteRepo failed: %s", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestFManSQLiteRepoPersistsRecords(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "xtreme.db")
+	r := newSQLiteRepo(t, dbPath)
+	if err := r.InsertDirRecord("dir-a", "a", models.RootDirUUID, "owner-1"); err != nil {
+		t.Fatalf("InsertDirRecord failed: %s", err)
+	}
+	r.Close()
+
+	// Reopening the database applies no migration twice and keeps the records.
+	r = newSQLiteRepo(t, dbPath)
+	dir, err := r.ReadDirRecord("dir-a")
+	if err != nil {
+		t.Fatalf("ReadDirRecord failed: %s", err)
+	}
+	if dir.Path != "/a" {
+		t.Errorf("path of dir-a = %q, want %q", dir.Path, "/a")
+	}
+}
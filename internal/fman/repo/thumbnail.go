@@ -0,0 +1,106 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// thumbnailColumns are the columns read by scanThumbnail.
+const thumbnailColumns = "file_uuid, version, size, storage_key, real_path, content_type, width, height, file_size, created_at"
+
+// scanThumbnail scans a thumbnail selected with thumbnailColumns.
+func scanThumbnail(scan func(dest ...interface{}) error) (models.Thumbnail, error) {
+	var t models.Thumbnail
+	err := scan(&t.FileUUID, &t.Version, &t.Size, &t.StorageKey, &t.RealPath, &t.ContentType, &t.Width, &t.Height,
+		&t.FileSize, &t.CreatedAt)
+	return t, err
+}
+
+// InsertThumbnailRecord inserts a thumbnail of the current version of a file record, which
+// is not soft-removed, to DB, unless it already exists. It returns the stored thumbnail.
+func (r *sqlRepo) InsertThumbnailRecord(thumbnail models.Thumbnail) (models.Thumbnail, error) {
+	var stored models.Thumbnail
+	err := r.withTx(func(tx *sql.Tx) error {
+		// The lock keeps a new version from making the thumbnail stale before it is inserted.
+		current, _, err := r.lockVersionedFile(tx, thumbnail.FileUUID)
+		if err != nil {
+			return err
+		}
+		if thumbnail.Version != current {
+			return models.NewFManError(models.ConflictErrorCode,
+				fmt.Sprintf("version %d of file %s is not the current version", thumbnail.Version, thumbnail.FileUUID))
+		}
+		_, err = tx.Exec(r.q(`INSERT INTO thumbnails (`+thumbnailColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (file_uuid, version, size) DO NOTHING`),
+			thumbnail.FileUUID, thumbnail.Version, thumbnail.Size, thumbnail.StorageKey, thumbnail.RealPath,
+			thumbnail.ContentType, thumbnail.Width, thumbnail.Height, thumbnail.FileSize, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		stored, err = scanThumbnail(tx.QueryRow(r.q("SELECT "+thumbnailColumns+
+			" FROM thumbnails WHERE file_uuid = ? AND version = ? AND size = ?"),
+			thumbnail.FileUUID, thumbnail.Version, thumbnail.Size).Scan)
+		return err
+	})
+	if err != nil {
+		return models.Thumbnail{}, err
+	}
+	return stored, nil
+}
+
+// ReadThumbnailRecord reads a thumbnail of a version of a file record from DB.
+func (r *sqlRepo) ReadThumbnailRecord(fileUUID string, version int, size string) (models.Thumbnail, error) {
+	t, err := scanThumbnail(r.db.QueryRow(r.q("SELECT "+thumbnailColumns+
+		" FROM thumbnails WHERE file_uuid = ? AND version = ? AND size = ?"), fileUUID, version, size).Scan)
+	if err == sql.ErrNoRows {
+		return models.Thumbnail{}, models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("%s thumbnail of version %d of file %s does not exist", size, version, fileUUID))
+	}
+	return t, err
+}
+
+// HardRemoveStaleThumbnailRecords removes the thumbnails of the versions of a file record
+// other than the current one from DB, and returns their images as blobs.
+func (r *sqlRepo) HardRemoveStaleThumbnailRecords(fileUUID string) ([]models.Blob, error) {
+	var removed []models.Blob
+	err := r.withTx(func(tx *sql.Tx) error {
+		var err error
+		removed, err = r.removeThumbnails(tx, "", "?", " AND version <> (SELECT version FROM files WHERE uuid = ?)",
+			fileUUID, fileUUID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// removeThumbnails removes the thumbnails of the files selected by a subquery from DB, and
+// returns their images as blobs. The prefix is put in front of the queries, e.g. subtreeCTE,
+// and the condition is appended to them, e.g. to keep some versions.
+func (r *sqlRepo) removeThumbnails(tx *sql.Tx, prefix, fileUUIDs, condition string, args ...interface{}) ([]models.Blob, error) {
+	where := " WHERE file_uuid IN (" + fileUUIDs + ")" + condition
+	rows, err := tx.Query(r.q(prefix+"SELECT storage_key, real_path, file_size FROM thumbnails"+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var images []models.Blob
+	for rows.Next() {
+		var image models.Blob
+		if err := rows.Scan(&image.StorageKey, &image.RealPath, &image.Size); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(r.q(prefix+"DELETE FROM thumbnails"+where), args...); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
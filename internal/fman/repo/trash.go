@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// insertTrashEntry records a soft-removed file/dir in the recycle bin.
+func (r *sqlRepo) insertTrashEntry(tx *sql.Tx, UUID, itemUUID, itemType, name, parentUUID, itemPath, ownerUUID string,
+	deletedAt time.Time) error {
+	_, err := tx.Exec(r.q(`INSERT INTO trash_entries (uuid, item_uuid, item_type, name, original_parent_uuid, original_path, owner_uuid,
+		deleted_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		UUID, itemUUID, itemType, name, parentUUID, itemPath, ownerUUID, deletedAt)
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("recycle bin entry %s already exists", UUID))
+	}
+	return err
+}
+
+// ReadTrashRecord reads a recycle bin entry from DB.
+func (r *sqlRepo) ReadTrashRecord(UUID string) (models.TrashEntry, error) {
+	return r.readTrashEntry(r.db.QueryRow(r.q(`SELECT uuid, item_uuid, item_type, name, original_parent_uuid, original_path, owner_uuid, deleted_at
+		FROM trash_entries WHERE uuid = ?`), UUID), UUID)
+}
+
+// readTrashEntry scans a recycle bin entry from a row, and converts a missing row to FManError.
+func (r *sqlRepo) readTrashEntry(row *sql.Row, UUID string) (models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := row.Scan(&entry.UUID, &entry.ItemUUID, &entry.ItemType, &entry.Name, &entry.OriginalParentUUID,
+		&entry.OriginalPath, &entry.OwnerUUID, &entry.DeletedAt)
+	if err == sql.ErrNoRows {
+		return models.TrashEntry{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("recycle bin entry %s does not exist", UUID))
+	}
+	return entry, err
+}
+
+// ListTrashRecords lists the recycle bin entries of an owner, which were deleted before a given
+// time, from DB. An empty owner lists the entries of all owners, a zero time lists all entries.
+func (r *sqlRepo) ListTrashRecords(ownerUUID string, deletedBefore time.Time) ([]models.TrashEntry, error) {
+	query := `SELECT uuid, item_uuid, item_type, name, original_parent_uuid, original_path, owner_uuid, deleted_at
+		FROM trash_entries WHERE TRUE`
+	var args []interface{}
+	if ownerUUID != "" {
+		query += " AND owner_uuid = ?"
+		args = append(args, ownerUUID)
+	}
+	if !deletedBefore.IsZero() {
+		query += " AND deleted_at < ?"
+		args = append(args, deletedBefore.UTC())
+	}
+	rows, err := r.db.Query(r.q(query+" ORDER BY deleted_at DESC, uuid"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []models.TrashEntry
+	for rows.Next() {
+		var entry models.TrashEntry
+		err := rows.Scan(&entry.UUID, &entry.ItemUUID, &entry.ItemType, &entry.Name, &entry.OriginalParentUUID,
+			&entry.OriginalPath, &entry.OwnerUUID, &entry.DeletedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// RestoreTrashRecord restores the file/dir of a recycle bin entry with a name into a parent
+// directory in DB, rewrites the paths of restored descendants, and removes the entry.
+func (r *sqlRepo) RestoreTrashRecord(UUID, name, parentUUID string) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		entry, err := r.readTrashEntry(tx.QueryRow(r.q(`SELECT uuid, item_uuid, item_type, name, original_parent_uuid, original_path, owner_uuid, deleted_at
+			FROM trash_entries WHERE uuid = ?`+r.dialect.lockClause), UUID), UUID)
+		if err != nil {
+			return err
+		}
+		parentPath, err := r.readParentPath(tx, parentUUID)
+		if err != nil {
+			return err
+		}
+		if err := r.checkNameAvailable(tx, name, parentUUID, ""); err != nil {
+			return err
+		}
+		newPath := path.Join(parentPath, name)
+		now := time.Now().UTC()
+		if entry.ItemType == models.EntryTypeFile {
+			res, err := tx.Exec(r.q(`UPDATE files SET filename = ?, name_key = ?, search_name = ?, parent_uuid = ?, path = ?,
+				is_deleted = FALSE, trash_uuid = NULL, updated_at = ? WHERE uuid = ? AND trash_uuid = ?`),
+				name, naturalSortKey(name), searchName(name), parentUUID, newPath, now, entry.ItemUUID, UUID)
+			if err != nil {
+				return r.convertErr(err)
+			}
+			if err := checkAffected(res, fmt.Sprintf("file %s does not exist", entry.ItemUUID)); err != nil {
+				return err
+			}
+		} else {
+			var oldPath string
+			err := tx.QueryRow(r.q("SELECT path FROM directories WHERE uuid = ? AND trash_uuid = ?"+r.dialect.lockClause), entry.ItemUUID, UUID).
+				Scan(&oldPath)
+			if err == sql.ErrNoRows {
+				return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("directory %s does not exist", entry.ItemUUID))
+			}
+			if err != nil {
+				return err
+			}
+			if err := r.checkNotInSubtree(tx, parentUUID, entry.ItemUUID); err != nil {
+				return err
+			}
+			// Rename the directory before restoring it, as the old name may be taken by now.
+			_, err = tx.Exec(r.q(`UPDATE directories SET dirname = ?, name_key = ?, search_name = ?, parent_uuid = ?, path = ?,
+				updated_at = ? WHERE uuid = ?`),
+				name, naturalSortKey(name), searchName(name), parentUUID, newPath, now, entry.ItemUUID)
+			if err != nil {
+				return r.convertErr(err)
+			}
+			_, err = tx.Exec(r.q(subtreeCTE+`UPDATE files SET is_deleted = FALSE, trash_uuid = NULL
+				WHERE parent_uuid IN (SELECT uuid FROM subtree) AND trash_uuid = ?`), entry.ItemUUID, UUID)
+			if err != nil {
+				return r.convertErr(err)
+			}
+			_, err = tx.Exec(r.q(subtreeCTE+`UPDATE directories SET is_deleted = FALSE, trash_uuid = NULL
+				WHERE uuid IN (SELECT uuid FROM subtree) AND trash_uuid = ?`), entry.ItemUUID, UUID)
+			if err != nil {
+				return r.convertErr(err)
+			}
+			if err := r.rewriteSubtreePaths(tx, entry.ItemUUID, oldPath, newPath); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(r.q("DELETE FROM trash_entries WHERE uuid = ?"), UUID)
+		return err
+	})
+}
+
+// HardRemoveTrashRecord removes a recycle bin entry together with the records of its file/dir
+// and all descendants from DB. It returns the blobs which are not referenced anymore.
+func (r *sqlRepo) HardRemoveTrashRecord(UUID string) ([]models.Blob, error) {
+	var removed []models.Blob
+	err := r.withTx(func(tx *sql.Tx) error {
+		entry, err := r.readTrashEntry(tx.QueryRow(r.q(`SELECT uuid, item_uuid, item_type, name, original_parent_uuid, original_path, owner_uuid, deleted_at
+			FROM trash_entries WHERE uuid = ?`+r.dialect.lockClause), UUID), UUID)
+		if err != nil {
+			return err
+		}
+		if entry.ItemType == models.EntryTypeFile {
+			images, err := r.removeThumbnails(tx, "", "SELECT uuid FROM files WHERE uuid = ? AND trash_uuid = ?", "",
+				entry.ItemUUID, UUID)
+			if err != nil {
+				return err
+			}
+			contentHashes, err := r.removeFileVersions(tx, "", "SELECT uuid FROM files WHERE uuid = ? AND trash_uuid = ?",
+				entry.ItemUUID, UUID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(r.q("DELETE FROM files WHERE uuid = ? AND trash_uuid = ?"), entry.ItemUUID, UUID); err != nil {
+				return err
+			}
+			_, err = tx.Exec(r.q("DELETE FROM acl_entries WHERE item_uuid = ? AND NOT EXISTS (SELECT 1 FROM files WHERE uuid = ?)"),
+				entry.ItemUUID, entry.ItemUUID)
+			if err != nil {
+				return err
+			}
+			err = r.removeAttributes(tx, "DELETE FROM %s WHERE item_uuid = ? AND NOT EXISTS (SELECT 1 FROM files WHERE uuid = ?)",
+				entry.ItemUUID, entry.ItemUUID)
+			if err != nil {
+				return err
+			}
+			if removed, err = r.releaseBlobs(tx, contentHashes); err != nil {
+				return err
+			}
+			removed = append(removed, images...)
+			_, err = tx.Exec(r.q("DELETE FROM trash_entries WHERE uuid = ?"), UUID)
+			return err
+		}
+		// Entries of descendants which were moved to the recycle bin on their own go
+		// together with the subtree.
+		_, err = tx.Exec(r.q(subtreeCTE+`DELETE FROM trash_entries WHERE uuid = ?
+			OR uuid IN (SELECT trash_uuid FROM directories WHERE uuid IN (SELECT uuid FROM subtree))
+			OR uuid IN (SELECT trash_uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree))`),
+			entry.ItemUUID, UUID)
+		if err != nil {
+			return err
+		}
+		images, err := r.removeThumbnails(tx, subtreeCTE, "SELECT uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree)",
+			"", entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		contentHashes, err := r.removeFileVersions(tx, subtreeCTE, "SELECT uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree)",
+			entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(subtreeCTE+`DELETE FROM acl_entries WHERE item_uuid IN (SELECT uuid FROM subtree)
+			OR item_uuid IN (SELECT uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree))`), entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		err = r.removeAttributes(tx, subtreeCTE+`DELETE FROM %s WHERE item_uuid IN (SELECT uuid FROM subtree)
+			OR item_uuid IN (SELECT uuid FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree))`, entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(subtreeCTE+"DELETE FROM files WHERE parent_uuid IN (SELECT uuid FROM subtree)"), entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		// Foreign keys are checked at the end of the statement, so the whole subtree
+		// can be removed at once.
+		_, err = tx.Exec(r.q(subtreeCTE+"DELETE FROM directories WHERE uuid IN (SELECT uuid FROM subtree)"), entry.ItemUUID)
+		if err != nil {
+			return err
+		}
+		if removed, err = r.releaseBlobs(tx, contentHashes); err != nil {
+			return err
+		}
+		removed = append(removed, images...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
@@ -0,0 +1,96 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// InsertUploadRecord inserts a new resumable upload record to DB.
+func (r *sqlRepo) InsertUploadRecord(upload models.Upload) error {
+	_, err := r.db.Exec(r.q(`INSERT INTO uploads (uuid, filename, parent_uuid, owner_uuid, upload_length, upload_offset, metadata, file_uuid,
+		created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		upload.UUID, upload.Filename, upload.ParentUUID, upload.OwnerUUID, upload.Length, upload.Offset, upload.Metadata,
+		upload.FileUUID, upload.CreatedAt.UTC(), upload.ExpiresAt.UTC())
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("upload %s already exists", upload.UUID))
+	}
+	return err
+}
+
+// ReadUploadRecord reads a resumable upload record from DB.
+func (r *sqlRepo) ReadUploadRecord(UUID string) (models.Upload, error) {
+	var upload models.Upload
+	err := r.db.QueryRow(r.q(`SELECT uuid, filename, parent_uuid, owner_uuid, upload_length, upload_offset, metadata, file_uuid,
+		created_at, expires_at FROM uploads WHERE uuid = ?`), UUID).
+		Scan(&upload.UUID, &upload.Filename, &upload.ParentUUID, &upload.OwnerUUID, &upload.Length, &upload.Offset,
+			&upload.Metadata, &upload.FileUUID, &upload.CreatedAt, &upload.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return models.Upload{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("upload %s does not exist", UUID))
+	}
+	return upload, err
+}
+
+// UpdateUploadOffset sets the number of received bytes of an incomplete upload in DB, if it
+// is still at oldOffset.
+func (r *sqlRepo) UpdateUploadOffset(UUID string, oldOffset, offset int64) error {
+	res, err := r.db.Exec(r.q("UPDATE uploads SET upload_offset = ? WHERE uuid = ? AND file_uuid = '' AND upload_offset = ?"),
+		offset, UUID, oldOffset)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil || affected > 0 {
+		return err
+	}
+	// Tell a missing or complete upload from one at another offset.
+	upload, err := r.ReadUploadRecord(UUID)
+	if err != nil {
+		return err
+	}
+	if upload.FileUUID != "" {
+		return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("incomplete upload %s does not exist", UUID))
+	}
+	return models.NewFManError(models.ConflictErrorCode, fmt.Sprintf("upload %s is at offset %d", UUID, upload.Offset))
+}
+
+// CompleteUploadRecord marks an incomplete upload as complete in DB.
+func (r *sqlRepo) CompleteUploadRecord(UUID, fileUUID string) error {
+	res, err := r.db.Exec(r.q("UPDATE uploads SET file_uuid = ? WHERE uuid = ? AND file_uuid = ''"), fileUUID, UUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("incomplete upload %s does not exist", UUID))
+}
+
+// HardRemoveUploadRecord removes a resumable upload record from DB.
+func (r *sqlRepo) HardRemoveUploadRecord(UUID string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM uploads WHERE uuid = ?"), UUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("upload %s does not exist", UUID))
+}
+
+// ListExpiredUploadRecords lists the resumable upload records, which expired before a given time, from DB.
+func (r *sqlRepo) ListExpiredUploadRecords(before time.Time) ([]models.Upload, error) {
+	rows, err := r.db.Query(r.q(`SELECT uuid, filename, parent_uuid, owner_uuid, upload_length, upload_offset, metadata, file_uuid,
+		created_at, expires_at FROM uploads WHERE expires_at < ? ORDER BY expires_at, uuid`), before.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var uploads []models.Upload
+	for rows.Next() {
+		var upload models.Upload
+		err := rows.Scan(&upload.UUID, &upload.Filename, &upload.ParentUUID, &upload.OwnerUUID, &upload.Length, &upload.Offset,
+			&upload.Metadata, &upload.FileUUID, &upload.CreatedAt, &upload.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
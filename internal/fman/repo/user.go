@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// InsertUserRecord inserts a new user record to DB together with its root directory.
+func (r *sqlRepo) InsertUserRecord(user models.User) error {
+	return r.withTx(func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		_, err := tx.Exec(r.q(`INSERT INTO directories (uuid, dirname, name_key, path, parent_uuid, owner_uuid, is_deleted, created_at, updated_at)
+			VALUES (?, '', '', ?, NULL, ?, FALSE, ?, ?)`),
+			user.RootDirUUID, models.RootDirPath, user.UUID, now, now)
+		if err != nil && r.dialect.isUniqueViolation(err) {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("directory %s already exists", user.RootDirUUID))
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(`INSERT INTO users (uuid, username, password_hash, root_dir_uuid, is_admin, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			user.UUID, user.Username, user.PasswordHash, user.RootDirUUID, user.IsAdmin, now, now)
+		if err != nil && r.dialect.isUniqueViolation(err) {
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("user %s already exists", user.Username))
+		}
+		return err
+	})
+}
+
+// ReadUserRecord reads a user record from DB.
+func (r *sqlRepo) ReadUserRecord(UUID string) (models.User, error) {
+	return r.readUser(fmt.Sprintf("user %s does not exist", UUID), "uuid = ?", UUID)
+}
+
+// ReadUserRecordByName reads a user record with a given username from DB.
+func (r *sqlRepo) ReadUserRecordByName(username string) (models.User, error) {
+	return r.readUser(fmt.Sprintf("user %s does not exist", username), "username = ?", username)
+}
+
+// readUser reads the user record matching a condition from DB. A missing record is
+// converted to FManError with a message.
+func (r *sqlRepo) readUser(notFoundMsg, cond string, args ...interface{}) (models.User, error) {
+	var user models.User
+	err := r.db.QueryRow(r.q(`SELECT uuid, username, password_hash, root_dir_uuid, is_admin, created_at, updated_at
+		FROM users WHERE `+cond), args...).
+		Scan(&user.UUID, &user.Username, &user.PasswordHash, &user.RootDirUUID, &user.IsAdmin, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return models.User{}, models.NewFManError(models.NotFoundErrorCode, notFoundMsg)
+	}
+	return user, err
+}
+
+// InsertRefreshTokenRecord inserts a new refresh token record to DB.
+func (r *sqlRepo) InsertRefreshTokenRecord(token models.RefreshToken) error {
+	_, err := r.db.Exec(r.q("INSERT INTO refresh_tokens (uuid, user_uuid, created_at, expires_at) VALUES (?, ?, ?, ?)"),
+		token.UUID, token.UserUUID, token.CreatedAt.UTC(), token.ExpiresAt.UTC())
+	if err != nil && r.dialect.isUniqueViolation(err) {
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("refresh token %s already exists", token.UUID))
+	}
+	return err
+}
+
+// HardRemoveRefreshTokenRecord removes a refresh token record from DB and returns it.
+func (r *sqlRepo) HardRemoveRefreshTokenRecord(UUID string) (models.RefreshToken, error) {
+	var token models.RefreshToken
+	err := r.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(r.q("SELECT uuid, user_uuid, created_at, expires_at FROM refresh_tokens WHERE uuid = ?"+r.dialect.lockClause), UUID).
+			Scan(&token.UUID, &token.UserUUID, &token.CreatedAt, &token.ExpiresAt)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("refresh token %s does not exist", UUID))
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q("DELETE FROM refresh_tokens WHERE uuid = ?"), UUID)
+		return err
+	})
+	if err != nil {
+		return models.RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// HardRemoveExpiredRefreshTokenRecords removes the refresh token records, which expired before
+// a given time, from DB.
+func (r *sqlRepo) HardRemoveExpiredRefreshTokenRecords(before time.Time) (int, error) {
+	res, err := r.db.Exec(r.q("DELETE FROM refresh_tokens WHERE expires_at < ?"), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,273 @@
+package repo
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// removeFileVersions removes the versions of the files selected by a subquery from DB,
+// and returns the hashes of their content, one per version. Their sizes are no longer
+// counted as used by the owners of the files. The prefix is put in front of the queries,
+// e.g. subtreeCTE.
+func (r *sqlRepo) removeFileVersions(tx *sql.Tx, prefix, fileUUIDs string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(r.q(prefix+`SELECT v.content_hash, v.file_size, f.owner_uuid
+		FROM file_versions v JOIN files f ON f.uuid = v.file_uuid WHERE v.file_uuid IN (`+fileUUIDs+")"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var contentHashes []string
+	usage := make(map[string]int64)
+	for rows.Next() {
+		var contentHash, ownerUUID string
+		var fileSize int64
+		if err := rows.Scan(&contentHash, &fileSize, &ownerUUID); err != nil {
+			return nil, err
+		}
+		contentHashes = append(contentHashes, contentHash)
+		usage[ownerUUID] -= fileSize
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(r.q(prefix+"DELETE FROM file_versions WHERE file_uuid IN ("+fileUUIDs+")"), args...)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.addUsage(tx, usage); err != nil {
+		return nil, err
+	}
+	return contentHashes, nil
+}
+
+// lockVersionedFile locks a file record, which is not soft-removed, and returns the
+// number of its current version and its owner.
+func (r *sqlRepo) lockVersionedFile(tx *sql.Tx, fileUUID string) (int, string, error) {
+	var current int
+	var ownerUUID string
+	err := tx.QueryRow(r.q("SELECT version, owner_uuid FROM files WHERE uuid = ? AND is_deleted = FALSE"+r.dialect.lockClause), fileUUID).
+		Scan(&current, &ownerUUID)
+	if err == sql.ErrNoRows {
+		return 0, "", models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+	}
+	return current, ownerUUID, err
+}
+
+// InsertVersionRecord adds a new version to a file record, which is not soft-removed, in
+// DB, and makes it the current content of the file. Its size is counted as used by the
+// owner of the file.
+func (r *sqlRepo) InsertVersionRecord(fileUUID string, blob models.Blob, contentType, uploadedBy string) (models.FileVersion, error) {
+	var version models.FileVersion
+	err := r.withTx(func(tx *sql.Tx) error {
+		current, ownerUUID, err := r.lockVersionedFile(tx, fileUUID)
+		if err != nil {
+			return err
+		}
+		stored, err := r.acquireBlob(tx, blob)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		version = models.FileVersion{
+			FileUUID:    fileUUID,
+			Version:     current + 1,
+			FileSize:    uint64(stored.Size),
+			StoredSize:  uint64(stored.StoredSize),
+			ContentHash: stored.Hash,
+			ContentMD5:  stored.MD5,
+			ContentType: contentType,
+			StorageKey:  stored.StorageKey,
+			RealPath:    stored.RealPath,
+			UploadedBy:  uploadedBy,
+			CreatedAt:   now,
+			IsCurrent:   true,
+		}
+		_, err = tx.Exec(r.q(`INSERT INTO file_versions (file_uuid, version, content_hash, content_type, file_size, uploaded_by,
+			created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`), fileUUID, version.Version, stored.Hash, contentType, stored.Size, uploadedBy, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(r.q(`UPDATE files SET version = ?, content_hash = ?, content_type = ?, file_size = ?, real_path = ?,
+			updated_at = ? WHERE uuid = ?`), version.Version, stored.Hash, contentType, stored.Size, stored.RealPath, now, fileUUID)
+		if err != nil {
+			return err
+		}
+		return r.addUsage(tx, map[string]int64{ownerUUID: stored.Size})
+	})
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	return version, nil
+}
+
+// versionColumns are the columns read by scanVersion.
+const versionColumns = `v.file_uuid, v.version, v.file_size, b.stored_size, v.content_hash, b.md5, v.content_type,
+	b.storage_key, b.real_path, v.uploaded_by, v.created_at, f.version`
+
+// versionTables joins a version with its file, which is not soft-removed, and its blob.
+const versionTables = `file_versions v JOIN files f ON f.uuid = v.file_uuid AND f.is_deleted = FALSE
+	JOIN blobs b ON b.hash = v.content_hash`
+
+// scanVersion scans a version selected with versionColumns.
+func scanVersion(scan func(dest ...interface{}) error) (models.FileVersion, error) {
+	var version models.FileVersion
+	var fileSize, storedSize int64
+	var current int
+	err := scan(&version.FileUUID, &version.Version, &fileSize, &storedSize, &version.ContentHash, &version.ContentMD5,
+		&version.ContentType, &version.StorageKey, &version.RealPath, &version.UploadedBy, &version.CreatedAt, &current)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	version.FileSize = uint64(fileSize)
+	version.StoredSize = uint64(storedSize)
+	version.IsCurrent = version.Version == current
+	return version, nil
+}
+
+// ReadVersionRecord reads a version of a file record, which is not soft-removed, from DB.
+func (r *sqlRepo) ReadVersionRecord(fileUUID string, version int) (models.FileVersion, error) {
+	v, err := scanVersion(r.db.QueryRow(r.q("SELECT "+versionColumns+" FROM "+versionTables+
+		" WHERE v.file_uuid = ? AND v.version = ?"), fileUUID, version).Scan)
+	if err == sql.ErrNoRows {
+		return models.FileVersion{}, models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("version %d of file %s does not exist", version, fileUUID))
+	}
+	return v, err
+}
+
+// ListVersionRecords lists all versions of a file record, which is not soft-removed, from
+// DB, newest first.
+func (r *sqlRepo) ListVersionRecords(fileUUID string) ([]models.FileVersion, error) {
+	var versions []models.FileVersion
+	err := r.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(r.q("SELECT TRUE FROM files WHERE uuid = ? AND is_deleted = FALSE"), fileUUID).Scan(&exists)
+		if err == sql.ErrNoRows {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("file %s does not exist", fileUUID))
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Query(r.q("SELECT "+versionColumns+" FROM "+versionTables+
+			" WHERE v.file_uuid = ? ORDER BY v.version DESC"), fileUUID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			version, err := scanVersion(rows.Scan)
+			if err != nil {
+				return err
+			}
+			versions = append(versions, version)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// HardRemoveVersionRecords removes old versions of a file record, which is not soft-removed,
+// from DB, releases their references to the blobs of their content and no longer counts
+// their sizes as used by the owner of the file.
+func (r *sqlRepo) HardRemoveVersionRecords(fileUUID string, versions []int) ([]models.Blob, error) {
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	var removed []models.Blob
+	err := r.withTx(func(tx *sql.Tx) error {
+		current, ownerUUID, err := r.lockVersionedFile(tx, fileUUID)
+		if err != nil {
+			return err
+		}
+		sorted := append([]int(nil), versions...)
+		sort.Ints(sorted)
+		placeholders := make([]string, 0, len(sorted))
+		args := []interface{}{fileUUID}
+		for i, version := range sorted {
+			if version == current {
+				return models.NewFManError(models.InvalidArgumentErrorCode,
+					fmt.Sprintf("current version %d of file %s cannot be removed", version, fileUUID))
+			}
+			if i > 0 && version == sorted[i-1] {
+				continue
+			}
+			placeholders = append(placeholders, "?")
+			args = append(args, version)
+		}
+		query := "SELECT content_hash, file_size FROM file_versions WHERE file_uuid = ? AND version IN (" + strings.Join(placeholders, ", ") + ")"
+		rows, err := tx.Query(r.q(query), args...)
+		if err != nil {
+			return err
+		}
+		var contentHashes []string
+		var removedSize int64
+		for rows.Next() {
+			var contentHash string
+			var fileSize int64
+			if err := rows.Scan(&contentHash, &fileSize); err != nil {
+				rows.Close()
+				return err
+			}
+			contentHashes = append(contentHashes, contentHash)
+			removedSize += fileSize
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(contentHashes) != len(placeholders) {
+			return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("some versions of file %s do not exist", fileUUID))
+		}
+		query = "DELETE FROM file_versions WHERE file_uuid = ? AND version IN (" + strings.Join(placeholders, ", ") + ")"
+		if _, err := tx.Exec(r.q(query), args...); err != nil {
+			return err
+		}
+		if err := r.addUsage(tx, map[string]int64{ownerUUID: -removedSize}); err != nil {
+			return err
+		}
+		removed, err = r.releaseBlobs(tx, contentHashes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// UpdateVersionPolicy sets the version policy of a file record, which is not soft-removed, in DB.
+func (r *sqlRepo) UpdateVersionPolicy(fileUUID string, policy models.VersionPolicy) error {
+	res, err := r.db.Exec(r.q("UPDATE files SET max_versions = ?, max_version_age = ? WHERE uuid = ? AND is_deleted = FALSE"),
+		policy.MaxVersions, int64(policy.MaxAge/time.Second), fileUUID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res, fmt.Sprintf("file %s does not exist", fileUUID))
+}
+
+// ListVersionedFileRecords lists the UUIDs of the file records, which are not soft-removed
+// and have old versions, from DB.
+func (r *sqlRepo) ListVersionedFileRecords() ([]string, error) {
+	rows, err := r.db.Query(r.q(`SELECT f.uuid FROM files f JOIN file_versions v ON v.file_uuid = f.uuid
+		WHERE f.is_deleted = FALSE GROUP BY f.uuid HAVING COUNT(*) > 1 ORDER BY f.uuid`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fileUUIDs []string
+	for rows.Next() {
+		var fileUUID string
+		if err := rows.Scan(&fileUUID); err != nil {
+			return nil, err
+		}
+		fileUUIDs = append(fileUUIDs, fileUUID)
+	}
+	return fileUUIDs, rows.Err()
+}
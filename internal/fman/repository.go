@@ -1,42 +1,77 @@
 package fman
 
-import "github.com/nvthongswansea/xtreme/internal/models"
+import (
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
 
 // FManFileDBRepo provides an interface for operations on file in the database.
 type FManFileDBRepo interface {
-	// InsertFileRecord inserts a file record to db.
-	InsertFileRecord(UUID, filename, parentUUID, realPath string, fileSize int64) error
+	// InsertFileRecord inserts a file record owned by a user to db together with its first
+	// version, which is uploaded by the owner and references the blob of its content of a
+	// MIME type.
+	// If a blob with the same hash already exists, its reference count is incremented and
+	// the existing blob is returned, so the caller must remove its own copy of the content.
+	// Otherwise the given blob is inserted with a reference count of one. A blob without
+	// a storage key must already exist, e.g. when a file is copied.
+	InsertFileRecord(UUID, filename, parentUUID, ownerUUID, contentType string, blob models.Blob) (models.Blob, error)
 
 	// ReadFileRecord reads a file record from the db with a given UUID.
+	// Soft-removed records are treated as not existing.
 	ReadFileRecord(UUID string) (models.File, error)
 
-	// UpdateFileRecord updates a file record in the db.
-	UpdateFileRecord(filename, parentUUID string) error
+	// ReadFileRecordByName reads a file record, which is not soft-removed, with a given name
+	// in a parent directory from the db.
+	ReadFileRecordByName(filename, parentUUID string) (models.File, error)
+
+	// UpdateFileRecord updates name and parent directory of a file record in the db.
+	UpdateFileRecord(UUID, filename, parentUUID string) error
+
+	// SoftRemoveFileRecord flags a file record as deleted file, e.g. set `is_deleted`
+	// field to true, and records it in the recycle bin as the entry trashUUID.
+	SoftRemoveFileRecord(UUID, trashUUID string) error
 
-	// SoftRemoveFileRecord flags a file record as deleted file.
-	// e.g. set `is_deleted` field to true.
-	SoftRemoveFileRecord(UUID string) error
+	// HardRemoveFileRecord removes a file record together with all its versions completely
+	// from the db, and releases their references to the blobs of their content. It returns
+	// the blobs which are not referenced anymore, whose content must be removed from the storage.
+	HardRemoveFileRecord(UUID string) ([]models.Blob, error)
 
-	// HardRemoveFileRecord removes a file record completely from the db.
-	HardRemoveFileRecord(UUID string) error
+	// ListStorageKeys lists the names in the storage of the contents of all blobs and of the
+	// images of all thumbnails, ordered by name.
+	ListStorageKeys() ([]string, error)
 }
 
 // FManDirDBRepo provides an interface for operations on directory/folder in the database.
 type FManDirDBRepo interface {
-	// InsertDirRecord inserts a directory/folder record to db.
-	InsertDirRecord(UUID, dirname, parentUUID string) error
+	// InsertDirRecord inserts a directory/folder record owned by a user to db.
+	InsertDirRecord(UUID, dirname, parentUUID, ownerUUID string) error
 
 	// ReadDirRecord reads a directory/folder record from the db with a given UUID.
+	// Soft-removed records are treated as not existing.
 	ReadDirRecord(UUID string) (models.Directory, error)
 
-	// UpdateDirRecord updates a directory/folder record in the db.
-	UpdateDirRecord(filename, parentUUID string) error
+	// ReadDirRecordByName reads a directory/folder record, which is not soft-removed, with a
+	// given name in a parent directory from the db.
+	ReadDirRecordByName(dirname, parentUUID string) (models.Directory, error)
+
+	// ListDirRecord reads a directory/folder record together with a page of its children,
+	// which are not soft-removed. It returns the cursor of the next page, which is empty
+	// if this is the last page.
+	ListDirRecord(UUID string, opts models.DirListOptions) (models.Directory, string, error)
+
+	// UpdateDirRecord updates name and parent directory of a directory/folder record in the db.
+	// Paths of all descendants are updated accordingly. A directory cannot be moved into
+	// itself or one of its descendants. Root directories cannot be updated.
+	UpdateDirRecord(UUID, dirname, parentUUID string) error
 
-	// SoftRemoveDirRecord flags a directory/folder record as deleted file.
-	// e.g. set `is_deleted` field to true.
-	SoftRemoveDirRecord(UUID string) error
+	// SoftRemoveDirRecord flags a directory/folder record and all its descendants as deleted,
+	// e.g. set `is_deleted` field to true, and records the subtree in the recycle bin as
+	// the entry trashUUID. Descendants which are already in the recycle bin keep their own entries.
+	SoftRemoveDirRecord(UUID, trashUUID string) error
 
 	// HardRemoveDirRecord removes a directory/folder record completely from the db.
+	// Root directories cannot be removed.
 	HardRemoveDirRecord(UUID string) error
 }
 
@@ -48,3 +83,266 @@ type FManValidateDBRepo interface {
 	// IsParentUUIDExist checks if a parent UUID exists.
 	IsParentUUIDExist(parentUUID string) (bool, error)
 }
+
+// FManTrashDBRepo provides an interface for operations on the recycle bin in the database.
+type FManTrashDBRepo interface {
+	// ReadTrashRecord reads a recycle bin entry from the db with a given UUID.
+	ReadTrashRecord(UUID string) (models.TrashEntry, error)
+
+	// ListTrashRecords lists the recycle bin entries of an owner which were deleted before a
+	// given time, most recently deleted first. An empty owner lists the entries of all owners,
+	// a zero time lists all entries.
+	ListTrashRecords(ownerUUID string, deletedBefore time.Time) ([]models.TrashEntry, error)
+
+	// RestoreTrashRecord restores the file/dir of a recycle bin entry with a name into a parent
+	// directory, and removes the entry. Descendants of a directory which were moved to the
+	// recycle bin together with it are restored as well.
+	RestoreTrashRecord(UUID, name, parentUUID string) error
+
+	// HardRemoveTrashRecord removes a recycle bin entry together with the records of its
+	// file/dir and all descendants completely from the db, including entries of descendants
+	// which were moved to the recycle bin on their own. The references of the versions of
+	// the removed file records to their blobs are released. It returns the blobs which are
+	// not referenced anymore, whose content must be removed from the storage.
+	HardRemoveTrashRecord(UUID string) ([]models.Blob, error)
+}
+
+// FManUploadDBRepo provides an interface for operations on resumable uploads in the database.
+type FManUploadDBRepo interface {
+	// InsertUploadRecord inserts a resumable upload record to db.
+	InsertUploadRecord(upload models.Upload) error
+
+	// ReadUploadRecord reads a resumable upload record from the db with a given UUID.
+	ReadUploadRecord(UUID string) (models.Upload, error)
+
+	// UpdateUploadOffset sets the number of received bytes of an incomplete upload in the db,
+	// if it is still at oldOffset. Otherwise, e.g. if another server wrote a chunk at the same
+	// offset first, it fails with ConflictErrorCode.
+	UpdateUploadOffset(UUID string, oldOffset, offset int64) error
+
+	// CompleteUploadRecord marks an incomplete upload as complete with the UUID of the created file.
+	CompleteUploadRecord(UUID, fileUUID string) error
+
+	// HardRemoveUploadRecord removes a resumable upload record completely from the db.
+	HardRemoveUploadRecord(UUID string) error
+
+	// ListExpiredUploadRecords lists the resumable upload records which expired before a given time.
+	ListExpiredUploadRecords(before time.Time) ([]models.Upload, error)
+}
+
+// FManVersionDBRepo provides an interface for operations on versions of files in the database.
+// Versions of soft-removed files are treated as not existing.
+type FManVersionDBRepo interface {
+	// InsertVersionRecord adds a new version of a MIME type to a file record in db, which
+	// becomes the current content of the file. The blob is referenced in the same way as by
+	// InsertFileRecord.
+	InsertVersionRecord(fileUUID string, blob models.Blob, contentType, uploadedBy string) (models.FileVersion, error)
+
+	// ReadVersionRecord reads a version of a file record from the db.
+	ReadVersionRecord(fileUUID string, version int) (models.FileVersion, error)
+
+	// ListVersionRecords lists all versions of a file record, newest first.
+	ListVersionRecords(fileUUID string) ([]models.FileVersion, error)
+
+	// HardRemoveVersionRecords removes old versions of a file record completely from the db.
+	// The current version cannot be removed. It returns the blobs which are not referenced
+	// anymore, whose content must be removed from the storage.
+	HardRemoveVersionRecords(fileUUID string, versions []int) ([]models.Blob, error)
+
+	// UpdateVersionPolicy sets the policy limiting the retained old versions of a file record.
+	UpdateVersionPolicy(fileUUID string, policy models.VersionPolicy) error
+
+	// ListVersionedFileRecords lists the UUIDs of the file records which have old versions.
+	ListVersionedFileRecords() ([]string, error)
+}
+
+// FManQuotaDBRepo provides an interface for operations on storage quotas in the database.
+// The bytes used by a user are counted whenever versions of the user's files are inserted
+// or removed, in the same transaction.
+type FManQuotaDBRepo interface {
+	// ReadUserQuotaRecord reads the quota of a user and the bytes used by the versions of the
+	// user's files, the recycle bin included, from the db. A zero limit falls back to the
+	// default quota.
+	ReadUserQuotaRecord(userUUID string) (models.Quota, error)
+
+	// UpdateUserQuotaLimit sets the quota of a user in the db.
+	UpdateUserQuotaLimit(userUUID string, limit int64) error
+
+	// UpdateDirQuotaLimit sets the quota of a directory/folder, which is not soft-removed,
+	// in the db. Zero removes the quota. Root directories cannot have a quota.
+	UpdateDirQuotaLimit(dirUUID string, limit int64) error
+
+	// ListAncestorDirQuotaRecords lists the quotas of a directory/folder and its ancestors,
+	// which have one, nearest first. The bytes used are the sizes of all versions of the
+	// files in their subtrees, which are not soft-removed.
+	ListAncestorDirQuotaRecords(dirUUID string) ([]models.DirQuota, error)
+
+	// ListOwnerDirQuotaRecords lists the quotas of an owner's directories/folders, which are
+	// not soft-removed and have one, ordered by path. The bytes used are counted in the same
+	// way as by ListAncestorDirQuotaRecords.
+	ListOwnerDirQuotaRecords(ownerUUID string) ([]models.DirQuota, error)
+
+	// ReserveQuotaRecord inserts a reservation of bytes of the quotas of an owner and of a
+	// directory/folder and its ancestors to the db, which holds them until the files taking
+	// them up are counted as used. The bytes must fit into every quota besides the bytes used
+	// and the reservations, which have not expired; otherwise it fails with
+	// QuotaExceededErrorCode. A zero limit of the owner falls back to defaultLimit, where zero
+	// means no limit, and owners which are not users only have the quotas of the directories.
+	// The quotas are checked and reserved at once, so concurrent reservations cannot exceed
+	// them together.
+	ReserveQuotaRecord(reservation models.QuotaReservation, defaultLimit int64) error
+
+	// RemoveQuotaReservationRecord removes a reservation from the db, once the files taking
+	// up its bytes are counted as used or could not be added. Removing a reservation, which
+	// does not exist (e.g. it expired), is not an error.
+	RemoveQuotaReservationRecord(UUID string) error
+
+	// RecomputeUsageRecords recounts the bytes used by every user in the db from the versions
+	// of their files. It returns the corrections of the users whose count was wrong.
+	RecomputeUsageRecords() ([]models.UsageCorrection, error)
+
+	// ReadStorageStatsRecord counts the stored contents in the db, the bytes they take up in
+	// the storage and their total size.
+	ReadStorageStatsRecord() (models.StorageStats, error)
+}
+
+// FManShareDBRepo provides an interface for operations on share links in the database.
+type FManShareDBRepo interface {
+	// InsertShareLinkRecord inserts a share link record to the db. Its token must not be
+	// taken yet.
+	InsertShareLinkRecord(link models.ShareLink) error
+
+	// ReadShareLinkRecord reads a share link record from the db with a given UUID.
+	ReadShareLinkRecord(UUID string) (models.ShareLink, error)
+
+	// ReadShareLinkRecordByToken reads a share link record from the db with a given token.
+	ReadShareLinkRecordByToken(token string) (models.ShareLink, error)
+
+	// ListShareLinkRecords lists the share link records of an owner, most recently created first.
+	ListShareLinkRecords(ownerUUID string) ([]models.ShareLink, error)
+
+	// CountShareLinkDownload counts a file download through a share link in the db. It fails
+	// with ExpiredErrorCode if the link already reached its download limit, so concurrent
+	// downloads cannot exceed it.
+	CountShareLinkDownload(UUID string) error
+
+	// HardRemoveShareLinkRecord removes a share link record completely from the db.
+	HardRemoveShareLinkRecord(UUID string) error
+}
+
+// FManACLDBRepo provides an interface for operations on access control entries and groups
+// in the database. Entries of files/dirs are removed together with their records.
+type FManACLDBRepo interface {
+	// InsertGroupRecord inserts a group record without members to the db. Its name must not
+	// be taken yet.
+	InsertGroupRecord(group models.Group) error
+
+	// ReadGroupRecord reads a group record together with the UUIDs of its members from the
+	// db with a given UUID.
+	ReadGroupRecord(UUID string) (models.Group, error)
+
+	// ListGroupRecords lists the group records together with the UUIDs of their members,
+	// ordered by name. A non-empty member UUID lists only the groups of that user.
+	ListGroupRecords(memberUUID string) ([]models.Group, error)
+
+	// HardRemoveGroupRecord removes a group record together with its memberships and access
+	// control entries completely from the db.
+	HardRemoveGroupRecord(UUID string) error
+
+	// InsertGroupMemberRecord adds an existing user to a group in the db.
+	InsertGroupMemberRecord(groupUUID, userUUID string) error
+
+	// HardRemoveGroupMemberRecord removes a user from a group in the db.
+	HardRemoveGroupMemberRecord(groupUUID, userUUID string) error
+
+	// UpsertACLRecord inserts an access control entry to the db, or replaces the permissions
+	// of the existing entry of the same principal on the same item. The user or group must
+	// exist.
+	UpsertACLRecord(entry models.ACLEntry) error
+
+	// HardRemoveACLRecord removes the access control entry of a principal on an item
+	// completely from the db.
+	HardRemoveACLRecord(itemUUID, principalType, principalUUID string) error
+
+	// ListACLRecords lists the access control entries on the given items.
+	ListACLRecords(itemUUIDs []string) ([]models.ACLEntry, error)
+
+	// ListSubtreeACLRecords lists the access control entries on a directory/folder and on
+	// all directories/folders and files in its subtree, either soft-removed or not.
+	ListSubtreeACLRecords(dirUUID string) ([]models.ACLEntry, error)
+
+	// ListPrincipalACLRecords lists the access control entries of the given users and groups.
+	ListPrincipalACLRecords(principalUUIDs []string) ([]models.ACLEntry, error)
+
+	// ListAncestorDirUUIDs lists the UUIDs of a directory/folder and its ancestors, nearest first.
+	ListAncestorDirUUIDs(dirUUID string) ([]string, error)
+}
+
+// FManSearchDBRepo provides an interface for operations on the search index in the database.
+// Names and paths of files/dirs are searched directly in their records, so they are always
+// up to date. The text of contents is indexed by the hashes of their blobs, and removed from
+// the index together with the blobs.
+type FManSearchDBRepo interface {
+	// IsContentIndexed checks if the text of the content with a given hash is indexed.
+	IsContentIndexed(contentHash string) (bool, error)
+
+	// IndexContentRecord indexes the text of the content with a given hash in the db. It
+	// does nothing if the content is already indexed or its blob does not exist anymore.
+	IndexContentRecord(contentHash, text string) error
+
+	// ListUnindexedFileRecords lists the file records, which are not soft-removed and whose
+	// content is not indexed.
+	ListUnindexedFileRecords() ([]models.File, error)
+
+	// SearchRecords finds the files/dirs, which are not soft-removed and match all terms and
+	// filters of a query, except root directories. Names match words, paths contain them,
+	// and contents match words and phrases of indexed text. Results are ordered by their
+	// scores, best first, and then by path.
+	SearchRecords(query models.SearchQuery) ([]models.SearchHit, error)
+}
+
+// FManAttributeDBRepo provides an interface to manipulate the tags and the key/value
+// metadata of files and directories/folders in the db.
+type FManAttributeDBRepo interface {
+	// InsertTagRecords adds a tag to files/dirs in the db, skipping those which already
+	// carry it. All files/dirs must exist.
+	InsertTagRecords(items []models.ItemRef, tag string) error
+
+	// HardRemoveTagRecord removes a tag from a file/dir completely from the db.
+	HardRemoveTagRecord(itemUUID, tag string) error
+
+	// ListTagRecords lists the tags of a file/dir, ordered by tag.
+	ListTagRecords(itemUUID string) ([]string, error)
+
+	// UpsertMetadataRecord sets a metadata key of a file/dir in the db, replacing the value
+	// of an existing key. The file/dir must exist.
+	UpsertMetadataRecord(item models.ItemRef, entry models.MetadataEntry) error
+
+	// HardRemoveMetadataRecord removes a metadata key of a file/dir completely from the db.
+	HardRemoveMetadataRecord(itemUUID, key string) error
+
+	// ListMetadataRecords lists the metadata of a file/dir, ordered by key.
+	ListMetadataRecords(itemUUID string) ([]models.MetadataEntry, error)
+
+	// CopyAttributeRecords copies the tags and the metadata of a file/dir to another file/dir
+	// of the same type, e.g. a copy of it, in the db.
+	CopyAttributeRecords(srcUUID, dstUUID string) error
+}
+
+// FManThumbnailDBRepo provides an interface for operations on the thumbnails of files in the
+// database. Thumbnails are removed together with their files, and the images of the removed
+// thumbnails are returned as blobs by the operations removing files.
+type FManThumbnailDBRepo interface {
+	// InsertThumbnailRecord inserts a thumbnail of the current version of a file, which is
+	// not soft-removed, to the db. If the thumbnail already exists, the existing one is
+	// returned, so the caller must remove its own image from the storage.
+	InsertThumbnailRecord(thumbnail models.Thumbnail) (models.Thumbnail, error)
+
+	// ReadThumbnailRecord reads a thumbnail of a version of a file from the db.
+	ReadThumbnailRecord(fileUUID string, version int, size string) (models.Thumbnail, error)
+
+	// HardRemoveStaleThumbnailRecords removes the thumbnails of the versions of a file other
+	// than the current one completely from the db. It returns their images as blobs, which
+	// must be removed from the storage.
+	HardRemoveStaleThumbnailRecords(fileUUID string) ([]models.Blob, error)
+}
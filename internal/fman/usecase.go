@@ -1,26 +1,297 @@
 package fman
 
 import (
+	"context"
 	"io"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
 )
 
 // FmanUsecase provides an interface for interacting with file.
+//
+// All operations except the purges are done by a user, who must own the uploads and recycle
+// bin entries involved unless being an admin. Files/dirs are accessible to their owner, to
+// admins, and to the users and groups granted permissions by access control entries (see
+// models.ACLEntry). Reading needs the read permission, changing content, renaming and
+// creating files/dirs in a directory the write permission, removing and moving files/dirs
+// away the delete permission, creating share links the share permission, and changing the
+// access control entries and quotas the manage permission. Removing a directory needs the
+// delete permission on its whole subtree. Created files/dirs are owned by the owner of the
+// directory they are created in, and files/dirs cannot be moved to a directory of another
+// owner except by admins.
+//
+// The sizes of all versions of a user's files, the recycle bin included, count towards the
+// quota of the user, and the files in the subtree of a directory with a quota count towards
+// the quota of the directory. Uploads, copies and new versions, which would exceed a quota,
+// fail with QuotaExceededErrorCode. Their bytes are reserved from the quotas until the files
+// are inserted, so concurrent uploads cannot exceed a quota together.
+//
+// Share links give anyone holding their token access to a file or a directory subtree
+// without a user. The operations through a link take its token and password instead.
 type FmanUsecase interface {
-	// Update a file.
-	UploadFile(filename, parentUUID string, contentReader io.Reader) error
+	// Update a file. With versioning, the content of an upload with the name of an existing
+	// file becomes a new version of it. The MIME type of the content is detected and must be
+	// allowed in the parent directory. Its digests are computed while it is stored, and an
+	// upload whose digests differ from the expected checksums is rejected and removed.
+	UploadFile(user models.User, filename, parentUUID string, contentReader io.Reader, expected models.Checksums) error
+
+	// Replace the content of a file, which must have the expected checksums. With versioning,
+	// the old content is retained as a version as long as the version policy allows. Return
+	// the new version.
+	UpdateFileContent(user models.User, fileUUID string, contentReader io.Reader,
+		expected models.Checksums) (models.FileVersion, error)
+
+	// List all versions of a file, newest first.
+	ListFileVersions(user models.User, fileUUID string) ([]models.FileVersion, error)
+
+	// Download a version of a file. Return the file record as it was with the version as its
+	// content, and the content, which must be closed after reading.
+	DownloadFileVersion(user models.User, fileUUID string, version int) (models.File, io.ReadSeekCloser, error)
+
+	// Make the content of an old version of a file current again by adding it as a new
+	// version. Return the new version.
+	RestoreFileVersion(user models.User, fileUUID string, version int) (models.FileVersion, error)
+
+	// Set the policy limiting the retained old versions of a file. Zero fields fall back
+	// to the global policy.
+	SetFileVersionPolicy(user models.User, fileUUID string, policy models.VersionPolicy) error
+
+	// Remove the old versions of all files, which are not retained by the version policy at
+	// a given time. Return the number of removed versions.
+	PurgeFileVersions(now time.Time) (int, error)
+
+	// Create a resumable upload of a file with a given length in bytes. metadata is kept as
+	// it is for the client.
+	CreateUpload(user models.User, filename, parentUUID string, length int64, metadata string) (models.Upload, error)
+
+	// Read the state of a resumable upload.
+	ReadUpload(user models.User, uploadUUID string) (models.Upload, error)
+
+	// Write a chunk of a resumable upload starting at offset, which must be the number of
+	// bytes received so far. The file is created once the whole content is received.
+	WriteUploadChunk(user models.User, uploadUUID string, offset int64, contentReader io.Reader) (models.Upload, error)
+
+	// Terminate a resumable upload and remove the content received so far.
+	TerminateUpload(user models.User, uploadUUID string) error
+
+	// Remove the resumable uploads which expired before a given time. Return the number
+	// of removed uploads.
+	PurgeExpiredUploads(now time.Time) (int, error)
+
+	// Download a file. Return the file record and its content, which must be closed
+	// after reading.
+	DownloadFile(user models.User, fileUUID string) (models.File, io.ReadSeekCloser, error)
+
+	// Download files and directories/folders together with their subtrees as an archive of a
+	// format, models.ArchiveFormatZip, models.ArchiveFormatTar or models.ArchiveFormatTarGz.
+	// The relative structure of the directories and the modification times are kept, and the
+	// files and directories, which the user cannot read, are left out. Return the archive and
+	// its content, which is built while it is read, so it must be closed after reading. Closing
+	// it early or ctx being done, e.g. as the client disconnected, stops building the archive.
+	DownloadArchive(ctx context.Context, user models.User, items []models.ItemRef,
+		format string) (models.Archive, io.ReadCloser, error)
+
+	// Extract an uploaded archive of a format, models.ArchiveFormatZip, models.ArchiveFormatTar
+	// or models.ArchiveFormatTarGz, with a size into a directory/folder. Directories of the
+	// archive are created as CreateNewDirectory does, or extracted into if they exist, and
+	// files are added as UploadFile does. Entries, which are neither files nor directories,
+	// e.g. symbolic links, are skipped. An archive with paths leading outside of the directory,
+	// or exceeding the extraction limits, is rejected before anything is extracted. Either
+	// the whole archive is extracted, or the files and directories created so far are
+	// removed again. Return the result of the extraction.
+	ExtractArchive(user models.User, parentUUID string, archive io.ReaderAt, size int64, format string) (models.Extraction, error)
 
-	// Copy a file to a new location.
-	CopyFile(srcUUID, dstParentUUID string) error
+	// Copy a file to a new location. The copy shares the content and has the tags and the
+	// metadata of the source file.
+	CopyFile(user models.User, srcUUID, dstParentUUID string) error
+
+	// Copy a directory/folder together with its subtree to a new location. Either the whole
+	// subtree is copied, or everything copied so far is removed again. The copied files share
+	// the content of their sources, and the copies have the tags and the metadata of their
+	// sources. progress, if not nil, is called after each copied file.
+	CopyDirectory(user models.User, srcUUID, dstParentUUID string, progress models.ProgressFunc) error
 
 	// Create a new directory/folder.
-	CreateNewDirectory(dirname, parentUUID string) error
+	CreateNewDirectory(user models.User, dirname, parentUUID string) error
+
+	// List a page of a directory/folder's children. Return the directory with its
+	// children and the cursor of the next page.
+	ListDirectory(user models.User, dirUUID string, opts models.DirListOptions) (models.Directory, string, error)
+
+	// Move and/or rename a file. An empty newName keeps the current name, an empty
+	// dstParentUUID keeps the current parent directory.
+	MoveFile(user models.User, fileUUID, newName, dstParentUUID string) error
+
+	// Move and/or rename a directory/folder together with its subtree. An empty newName
+	// keeps the current name, an empty dstParentUUID keeps the current parent directory.
+	MoveDirectory(user models.User, dirUUID, newName, dstParentUUID string) error
 
-	// Move a file.
-	MoveFile()
+	// Remove a file permanently together with its content, unless other files share it.
+	RemoveFile(user models.User, fileUUID string) error
 
-	// Remove a file.
-	RemoveFile()
+	// Remove a directory/folder permanently together with its subtree and the content of
+	// its files, which is not shared with other files. progress, if not nil, is called after
+	// each piece of content is removed.
+	RemoveDirectory(user models.User, dirUUID string, progress models.ProgressFunc) error
 
 	// Move a file to recycle bin.
-	MoveFileToRecyleBin()
+	MoveFileToRecyleBin(user models.User, fileUUID string) error
+
+	// Move a directory/folder together with its subtree to recycle bin.
+	MoveDirectoryToRecycleBin(user models.User, dirUUID string) error
+
+	// List the entries of the user's recycle bin, most recently deleted first.
+	ListRecycleBin(user models.User) ([]models.TrashEntry, error)
+
+	// Restore an entry of recycle bin to its original or another location.
+	RestoreFromRecycleBin(user models.User, trashUUID string, opts models.RestoreOptions) error
+
+	// Remove an entry of recycle bin permanently together with the content of its files.
+	RemoveFromRecycleBin(user models.User, trashUUID string) error
+
+	// Remove all entries of the user's recycle bin permanently.
+	EmptyRecycleBin(user models.User) error
+
+	// Remove the entries of all recycle bins, which were deleted before a given time, permanently.
+	// Return the number of removed entries.
+	PurgeRecycleBin(deletedBefore time.Time) (int, error)
+
+	// Read the quota and the bytes used of a user together with the quotas of the user's
+	// directories. An empty userUUID reads the usage of the user itself.
+	ReadUsage(user models.User, userUUID string) (models.Usage, error)
+
+	// Set the quota of a user in bytes. Zero falls back to the default quota. Only admins
+	// can set quotas of users.
+	SetUserQuota(user models.User, userUUID string, limit int64) error
+
+	// Set the quota of a directory/folder in bytes, which limits the bytes used by the files
+	// in its subtree. Zero removes the quota.
+	SetDirectoryQuota(user models.User, dirUUID string, limit int64) error
+
+	// Recount the bytes used by every user from the versions of their files, e.g. if the
+	// counts drifted. Return the corrections of the users whose count was wrong.
+	RecomputeUsage() ([]models.UsageCorrection, error)
+
+	// Read how many contents are stored, their total size and the bytes they take up in the
+	// storage, which compression saves on. Only admins can read the stats.
+	ReadStorageStats(user models.User) (models.StorageStats, error)
+
+	// Create a share link to a file or a directory/folder together with its subtree. The user
+	// needs the share permission on the item, and for links which allow uploads also the
+	// write permission. The permissions are those of the user without admin rights, as the
+	// link is used on behalf of the user.
+	CreateShareLink(user models.User, opts models.ShareLinkOptions) (models.ShareLink, error)
+
+	// List the share links created by the user, most recently created first.
+	ListShareLinks(user models.User) ([]models.ShareLink, error)
+
+	// Revoke a share link, which cannot be used anymore afterwards.
+	RevokeShareLink(user models.User, linkUUID string) error
+
+	// Open a share link with its token and password, which is ignored for links without a
+	// password. Return the link with the shared file/dir.
+	OpenShareLink(token, password string) (models.SharedItem, error)
+
+	// List a page of the children of a directory/folder in the subtree shared by a link.
+	// An empty dirUUID lists the shared directory itself. The directory is listed as by the
+	// creator of the link, who must still have the read permission on it, and children the
+	// creator cannot read are left out.
+	ListSharedDirectory(token, password, dirUUID string, opts models.DirListOptions) (models.Directory, string, error)
+
+	// Download a file shared by a link, either itself or in a shared subtree. An empty fileUUID
+	// downloads the shared file itself, and the creator of the link must still have the read
+	// permission on the file. The download counts towards the download limit of the
+	// link if count is true, which the caller sets once per download, e.g. not for the later
+	// parts of a download in ranges. Return the file record and its content, which must be
+	// closed after reading.
+	DownloadSharedFile(token, password, fileUUID string, count bool) (models.File, io.ReadSeekCloser, error)
+
+	// Upload a new file into a directory/folder in the subtree shared by a link, which allows
+	// uploads. An empty parentUUID uploads into the shared directory itself. The upload is
+	// made by the creator of the link, who must still have the write permission on the
+	// directory. The file is owned by the owner of the directory, and existing files cannot
+	// be replaced. The content must have the expected checksums.
+	UploadSharedFile(token, password, filename, parentUUID string, contentReader io.Reader,
+		expected models.Checksums) error
+
+	// List the access control entries on a file or a directory/folder, user entries first.
+	ListACL(user models.User, itemType, itemUUID string) ([]models.ACLEntry, error)
+
+	// Grant permissions on a file or a directory/folder to a user or a group, replacing the
+	// permissions of an existing entry of the same user or group. No permissions revoke the
+	// permissions inherited from the ancestors of the file/dir.
+	SetACLEntry(user models.User, entry models.ACLEntry) error
+
+	// Remove the access control entry of a user or a group on a file or a directory/folder.
+	RemoveACLEntry(user models.User, itemType, itemUUID, principalType, principalUUID string) error
+
+	// Read the permissions of the user on a file or a directory/folder.
+	ReadPermissions(user models.User, itemType, itemUUID string) (models.Permissions, error)
+
+	// List the files/dirs of other owners, which are readable by the user through access
+	// control entries of the user or the user's groups on them, ordered by path.
+	ListSharedWithMe(user models.User) ([]models.SharedEntry, error)
+
+	// Create a new group without members. Only admins can manage groups.
+	CreateGroup(user models.User, name string) (models.Group, error)
+
+	// List all groups if the user is an admin, or the groups of the user otherwise, ordered
+	// by name.
+	ListGroups(user models.User) ([]models.Group, error)
+
+	// Remove a group together with its access control entries.
+	RemoveGroup(user models.User, groupUUID string) error
+
+	// Add a user to a group.
+	AddGroupMember(user models.User, groupUUID, userUUID string) error
+
+	// Remove a user from a group.
+	RemoveGroupMember(user models.User, groupUUID, userUUID string) error
+
+	// Search the files/dirs readable by the user by their names, tags, paths and the text of
+	// text-like files, ranked by relevance. Words in double quotes form a phrase, and a
+	// trailing * matches a prefix. An empty query with a tag filter finds all files/dirs
+	// carrying the tag. Return a page of results and the offset of the next page, which is
+	// zero on the last page.
+	Search(user models.User, query string, opts models.SearchOptions) ([]models.SearchResult, int, error)
+
+	// Index the text of text-like files, whose content is not indexed yet, e.g. files stored
+	// before the search existed. Return the number of indexed contents.
+	ReindexSearch() (int, error)
+
+	// List the tags of a file or a directory/folder, ordered by tag.
+	ListTags(user models.User, itemType, itemUUID string) ([]string, error)
+
+	// Add a tag to a file or a directory/folder. Tags are lowercase, so tags differing only
+	// in case are the same.
+	AddTag(user models.User, itemType, itemUUID, tag string) error
+
+	// Add a tag to several files and directories/folders at once. Nothing is tagged unless
+	// the user may tag all of them.
+	TagItems(user models.User, tag string, items []models.ItemRef) error
+
+	// Remove a tag from a file or a directory/folder.
+	RemoveTag(user models.User, itemType, itemUUID, tag string) error
+
+	// List the key/value metadata of a file or a directory/folder, ordered by key.
+	ListMetadata(user models.User, itemType, itemUUID string) ([]models.MetadataEntry, error)
+
+	// Set a metadata key of a file or a directory/folder, replacing the value of an existing
+	// key.
+	SetMetadata(user models.User, itemType, itemUUID, key, value string) error
+
+	// Remove a metadata key of a file or a directory/folder.
+	RemoveMetadata(user models.User, itemType, itemUUID, key string) error
+
+	// Read a thumbnail of a size (one of models.ThumbnailSizes, empty for the default) of
+	// the current version of a PNG, JPEG or GIF file. Thumbnails are made in the background
+	// after an upload, or right away if they do not exist yet. Return the thumbnail and its
+	// image, which must be closed after reading.
+	ReadThumbnail(user models.User, fileUUID, size string) (models.Thumbnail, io.ReadSeekCloser, error)
+
+	// Encrypt the data keys of all stored contents and thumbnails with the current master key
+	// of an encrypted storage, e.g. after the master key was replaced, without rewriting the
+	// contents. Return the number of re-encrypted data keys.
+	RotateMasterKey() (int, error)
 }
@@ -0,0 +1,366 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// authorize checks if a user may access a record owned by ownerUUID, e.g. an upload or a
+// recycle bin entry. Admins may access everything, other users only what they own. Records
+// without an owner, which were created before users existed, are only accessible to admins.
+// Access to files/dirs is checked against their access control entries instead.
+func authorize(logger *log.Entry, user models.User, ownerUUID string) error {
+	if err := authenticated(logger, user); err != nil {
+		return err
+	}
+	if user.IsAdmin || ownerUUID == user.UUID {
+		return nil
+	}
+	logger.Infof("[-USER-] user %s is not allowed to access records of owner %q", user.UUID, ownerUUID)
+	return models.NewFManError(models.ForbiddenErrorCode, "access denied")
+}
+
+// authorizeAdmin checks if a user is an admin.
+func authorizeAdmin(logger *log.Entry, user models.User) error {
+	if err := authenticated(logger, user); err != nil {
+		return err
+	}
+	if user.IsAdmin {
+		return nil
+	}
+	logger.Infof("[-USER-] user %s is not an admin", user.UUID)
+	return models.NewFManError(models.ForbiddenErrorCode, "access denied")
+}
+
+// authenticated checks if a user is set, e.g. for operations only on the user's own records.
+func authenticated(logger *log.Entry, user models.User) error {
+	if user.UUID == "" {
+		logger.Info("[-USER-] user is not authenticated")
+		return models.NewFManError(models.UnauthorizedErrorCode, "user is not authenticated")
+	}
+	return nil
+}
+
+// principalSet holds a user together with the groups of the user, whose access control
+// entries apply to the user.
+type principalSet struct {
+	userUUID   string
+	groupUUIDs map[string]bool
+}
+
+// resolve returns the permissions granted to the principals by the access control entries on
+// a single item. An entry for the user overrides the entries for the user's groups, which
+// are combined otherwise. ok is false if none of the entries applies.
+func (p principalSet) resolve(entries []models.ACLEntry) (perms models.Permissions, ok bool) {
+	for _, entry := range entries {
+		switch {
+		case entry.PrincipalType == models.PrincipalUser && entry.PrincipalUUID == p.userUUID:
+			return entry.Permissions, true
+		case entry.PrincipalType == models.PrincipalGroup && p.groupUUIDs[entry.PrincipalUUID]:
+			perms |= entry.Permissions
+			ok = true
+		}
+	}
+	return perms, ok
+}
+
+// principals returns the principals whose access control entries apply to a user.
+func (u *FManLocalUsecase) principals(logger *log.Entry, user models.User) (principalSet, error) {
+	groups, err := u.dbACLRepo.ListGroupRecords(user.UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListGroupRecords", err)
+		return principalSet{}, err
+	}
+	p := principalSet{userUUID: user.UUID, groupUUIDs: make(map[string]bool, len(groups))}
+	for _, group := range groups {
+		p.groupUUIDs[group.UUID] = true
+	}
+	return p, nil
+}
+
+// aclEntriesByItem groups access control entries by the UUIDs of their items.
+func aclEntriesByItem(entries []models.ACLEntry) map[string][]models.ACLEntry {
+	byItem := make(map[string][]models.ACLEntry)
+	for _, entry := range entries {
+		byItem[entry.ItemUUID] = append(byItem[entry.ItemUUID], entry)
+	}
+	return byItem
+}
+
+// permissions returns the permissions of a user on a file/dir owned by ownerUUID. The file
+// is given by its UUID and parent, a directory by its UUID alone. Admins and the owner have
+// all permissions, other users those of the nearest access control entries which apply to
+// them, starting at the file/dir and walking up to the root.
+func (u *FManLocalUsecase) permissions(logger *log.Entry, user models.User, ownerUUID, fileUUID, dirUUID string) (models.Permissions, error) {
+	if user.IsAdmin || ownerUUID == user.UUID {
+		return models.PermAll, nil
+	}
+	principals, err := u.principals(logger, user)
+	if err != nil {
+		return 0, err
+	}
+	itemUUIDs, err := u.dbACLRepo.ListAncestorDirUUIDs(dirUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListAncestorDirUUIDs", err)
+		return 0, err
+	}
+	if fileUUID != "" {
+		itemUUIDs = append([]string{fileUUID}, itemUUIDs...)
+	}
+	entries, err := u.dbACLRepo.ListACLRecords(itemUUIDs)
+	if err != nil {
+		errUtils.LogErr(logger, "ListACLRecords", err)
+		return 0, err
+	}
+	byItem := aclEntriesByItem(entries)
+	for _, itemUUID := range itemUUIDs {
+		if perms, ok := principals.resolve(byItem[itemUUID]); ok {
+			return perms, nil
+		}
+	}
+	return 0, nil
+}
+
+// authorizePermission checks if a user has a permission on a file/dir, given in the same way
+// as to permissions, and returns all permissions of the user on it.
+func (u *FManLocalUsecase) authorizePermission(logger *log.Entry, user models.User, perm models.Permissions,
+	ownerUUID, fileUUID, dirUUID string) (models.Permissions, error) {
+	if err := authenticated(logger, user); err != nil {
+		return 0, err
+	}
+	perms, err := u.permissions(logger, user, ownerUUID, fileUUID, dirUUID)
+	if err != nil {
+		return 0, err
+	}
+	if !perms.Has(perm) {
+		itemUUID := fileUUID
+		if itemUUID == "" {
+			itemUUID = dirUUID
+		}
+		logger.Infof("[-USER-] user %s lacks %s permission on %s", user.UUID, strings.Join(perm.Names(), "/"), itemUUID)
+		return 0, models.NewFManError(models.ForbiddenErrorCode, "access denied")
+	}
+	return perms, nil
+}
+
+// authorizeFile checks if a user has a permission on a file.
+func (u *FManLocalUsecase) authorizeFile(logger *log.Entry, user models.User, file models.File, perm models.Permissions) error {
+	_, err := u.authorizePermission(logger, user, perm, file.OwnerUUID, file.UUID, file.ParentUUID)
+	return err
+}
+
+// authorizeDir checks if a user has a permission on a directory.
+func (u *FManLocalUsecase) authorizeDir(logger *log.Entry, user models.User, dir models.Directory, perm models.Permissions) error {
+	_, err := u.authorizePermission(logger, user, perm, dir.OwnerUUID, "", dir.UUID)
+	return err
+}
+
+// authorizeSameOwner checks if a file/dir owned by ownerUUID may be placed into a parent
+// directory by a user. Only admins may move files/dirs to a directory of another owner, as
+// they would stay owned by their owner outside of the owner's directories otherwise.
+func authorizeSameOwner(logger *log.Entry, user models.User, ownerUUID string, parent models.Directory) error {
+	if user.IsAdmin || ownerUUID == parent.OwnerUUID {
+		return nil
+	}
+	logger.Infof("[-USER-] records of owner %q cannot be moved to directory %s of owner %q", ownerUUID, parent.UUID, parent.OwnerUUID)
+	return models.NewFManError(models.ForbiddenErrorCode, "files and directories cannot be moved to a directory of another owner")
+}
+
+// readFile reads a file record, on which a user has a permission.
+func (u *FManLocalUsecase) readFile(logger *log.Entry, user models.User, fileUUID string, perm models.Permissions) (models.File, error) {
+	file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadFileRecord", err)
+		return models.File{}, err
+	}
+	if err := u.authorizeFile(logger, user, file, perm); err != nil {
+		return models.File{}, err
+	}
+	return file, nil
+}
+
+// readDir reads a directory record, on which a user has a permission.
+func (u *FManLocalUsecase) readDir(logger *log.Entry, user models.User, dirUUID string, perm models.Permissions) (models.Directory, error) {
+	dir, err := u.dbDirRepo.ReadDirRecord(dirUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadDirRecord", err)
+		return models.Directory{}, err
+	}
+	if err := u.authorizeDir(logger, user, dir, perm); err != nil {
+		return models.Directory{}, err
+	}
+	return dir, nil
+}
+
+// readDirPermissions reads a directory record, on which a user has a permission, and returns
+// all permissions of the user on it.
+func (u *FManLocalUsecase) readDirPermissions(logger *log.Entry, user models.User, dirUUID string, perm models.Permissions) (models.Permissions, error) {
+	dir, err := u.dbDirRepo.ReadDirRecord(dirUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadDirRecord", err)
+		return 0, err
+	}
+	return u.authorizePermission(logger, user, perm, dir.OwnerUUID, "", dir.UUID)
+}
+
+// readParentDir reads the record of a directory, on which a user has a permission, to place
+// a file/dir into.
+func (u *FManLocalUsecase) readParentDir(logger *log.Entry, user models.User, parentUUID string, perm models.Permissions) (models.Directory, error) {
+	dir, err := u.dbDirRepo.ReadDirRecord(parentUUID)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		logger.Infof("[-USER-] parent UUID (%s) does not exist", parentUUID)
+		return models.Directory{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("parent UUID (%s) does not exist", parentUUID))
+	}
+	if err != nil {
+		errUtils.LogErr(logger, "ReadDirRecord", err)
+		return models.Directory{}, err
+	}
+	if err := u.authorizeDir(logger, user, dir, perm); err != nil {
+		return models.Directory{}, err
+	}
+	return dir, nil
+}
+
+// subtreePermissions returns the permissions of a user on the directories and files of a
+// subtree returned by walkSubtree, keyed by their UUIDs, where the user has rootPerms on the
+// root. Admins and the owner of the root have all permissions on the whole subtree.
+func (u *FManLocalUsecase) subtreePermissions(logger *log.Entry, user models.User, rootPerms models.Permissions,
+	dirs []models.Directory, files []models.File) (map[string]models.Permissions, error) {
+	perms := make(map[string]models.Permissions, len(dirs)+len(files))
+	if user.IsAdmin || dirs[0].OwnerUUID == user.UUID {
+		for _, dir := range dirs {
+			perms[dir.UUID] = models.PermAll
+		}
+		for _, file := range files {
+			perms[file.UUID] = models.PermAll
+		}
+		return perms, nil
+	}
+	principals, err := u.principals(logger, user)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := u.dbACLRepo.ListSubtreeACLRecords(dirs[0].UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListSubtreeACLRecords", err)
+		return nil, err
+	}
+	byItem := aclEntriesByItem(entries)
+	// Parents come before their children, so their permissions are known already.
+	inherit := func(itemUUID, ownerUUID, parentUUID string) models.Permissions {
+		if ownerUUID == user.UUID {
+			return models.PermAll
+		}
+		if p, ok := principals.resolve(byItem[itemUUID]); ok {
+			return p
+		}
+		return perms[parentUUID]
+	}
+	perms[dirs[0].UUID] = rootPerms
+	for _, dir := range dirs[1:] {
+		perms[dir.UUID] = inherit(dir.UUID, dir.OwnerUUID, dir.ParentUUID)
+	}
+	for _, file := range files {
+		perms[file.UUID] = inherit(file.UUID, file.OwnerUUID, file.ParentUUID)
+	}
+	return perms, nil
+}
+
+// hideUnreadableChildren removes the children of a listed directory, on which a user has
+// dirPerms, from the listing unless the user has the read permission on them.
+func (u *FManLocalUsecase) hideUnreadableChildren(logger *log.Entry, user models.User, dirPerms models.Permissions,
+	dir *models.Directory) error {
+	if user.IsAdmin {
+		return nil
+	}
+	// Children owned by the user are always readable, so entries are only needed for the others.
+	var childUUIDs []string
+	for _, file := range dir.ListOfFiles {
+		if file.OwnerUUID != user.UUID {
+			childUUIDs = append(childUUIDs, file.UUID)
+		}
+	}
+	for _, child := range dir.ListOfDirs {
+		if child.OwnerUUID != user.UUID {
+			childUUIDs = append(childUUIDs, child.UUID)
+		}
+	}
+	if len(childUUIDs) == 0 {
+		return nil
+	}
+	principals, err := u.principals(logger, user)
+	if err != nil {
+		return err
+	}
+	entries, err := u.dbACLRepo.ListACLRecords(childUUIDs)
+	if err != nil {
+		errUtils.LogErr(logger, "ListACLRecords", err)
+		return err
+	}
+	byItem := aclEntriesByItem(entries)
+	readable := func(itemUUID, ownerUUID string) bool {
+		if ownerUUID == user.UUID {
+			return true
+		}
+		if perms, ok := principals.resolve(byItem[itemUUID]); ok {
+			return perms.Has(models.PermRead)
+		}
+		return dirPerms.Has(models.PermRead)
+	}
+	files := dir.ListOfFiles[:0]
+	for _, file := range dir.ListOfFiles {
+		if readable(file.UUID, file.OwnerUUID) {
+			files = append(files, file)
+		}
+	}
+	dir.ListOfFiles = files
+	dirs := dir.ListOfDirs[:0]
+	for _, child := range dir.ListOfDirs {
+		if readable(child.UUID, child.OwnerUUID) {
+			dirs = append(dirs, child)
+		}
+	}
+	dir.ListOfDirs = dirs
+	return nil
+}
+
+// authorizeSubtree checks if a user, who has a permission on a directory, has it on every
+// directory and file in its subtree as well.
+func (u *FManLocalUsecase) authorizeSubtree(logger *log.Entry, user models.User, dir models.Directory, perm models.Permissions) error {
+	if user.IsAdmin || dir.OwnerUUID == user.UUID {
+		return nil
+	}
+	dirs, files, err := u.walkSubtree(dir.UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "walkSubtree", err)
+		return err
+	}
+	perms, err := u.subtreePermissions(logger, user, perm, dirs, files)
+	if err != nil {
+		return err
+	}
+	for itemUUID, p := range perms {
+		if !p.Has(perm) {
+			logger.Infof("[-USER-] user %s lacks %s permission on %s in the subtree", user.UUID, strings.Join(perm.Names(), "/"), itemUUID)
+			return models.NewFManError(models.ForbiddenErrorCode, "access denied")
+		}
+	}
+	return nil
+}
+
+// readTrashEntry reads a recycle bin entry, which a user may access.
+func (u *FManLocalUsecase) readTrashEntry(logger *log.Entry, user models.User, trashUUID string) (models.TrashEntry, error) {
+	entry, err := u.dbTrashRepo.ReadTrashRecord(trashUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadTrashRecord", err)
+		return models.TrashEntry{}, err
+	}
+	if err := authorize(logger, user, entry.OwnerUUID); err != nil {
+		return models.TrashEntry{}, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,326 @@
+package usecase
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// groupNamePattern matches valid group names.
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func (u *FManLocalUsecase) ListACL(user models.User, itemType, itemUUID string) ([]models.ACLEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListACL",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+	})
+	logger.Debug("Start listing access control entries")
+	defer logger.Debug("Finish listing access control entries")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermManage); err != nil {
+		return nil, err
+	}
+	entries, err := u.dbACLRepo.ListACLRecords([]string{itemUUID})
+	if err != nil {
+		errUtils.LogErr(logger, "ListACLRecords", err)
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].PrincipalType != entries[j].PrincipalType {
+			return entries[i].PrincipalType > entries[j].PrincipalType
+		}
+		return entries[i].PrincipalUUID < entries[j].PrincipalUUID
+	})
+	return entries, nil
+}
+
+func (u *FManLocalUsecase) SetACLEntry(user models.User, entry models.ACLEntry) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "SetACLEntry",
+		"itemType":      entry.ItemType,
+		"itemUUID":      entry.ItemUUID,
+		"principalType": entry.PrincipalType,
+		"principalUUID": entry.PrincipalUUID,
+		"permissions":   entry.Permissions.Names(),
+	})
+	logger.Debug("Start setting access control entry")
+	defer logger.Debug("Finish setting access control entry")
+	switch entry.PrincipalType {
+	case models.PrincipalUser, models.PrincipalGroup:
+	default:
+		logger.Infof("[-USER-] unknown principal type %s", entry.PrincipalType)
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown principal type %s", entry.PrincipalType))
+	}
+	if entry.Permissions&^models.PermAll != 0 {
+		logger.Infof("[-USER-] unknown permissions %d", entry.Permissions)
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown permissions %d", entry.Permissions))
+	}
+	if _, err := u.readItemPermissions(logger, user, entry.ItemType, entry.ItemUUID, models.PermManage); err != nil {
+		return err
+	}
+	entry.GrantedBy = user.UUID
+	if err := u.dbACLRepo.UpsertACLRecord(entry); err != nil {
+		errUtils.LogErr(logger, "UpsertACLRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveACLEntry(user models.User, itemType, itemUUID, principalType, principalUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "RemoveACLEntry",
+		"itemType":      itemType,
+		"itemUUID":      itemUUID,
+		"principalType": principalType,
+		"principalUUID": principalUUID,
+	})
+	logger.Debug("Start removing access control entry")
+	defer logger.Debug("Finish removing access control entry")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermManage); err != nil {
+		return err
+	}
+	if err := u.dbACLRepo.HardRemoveACLRecord(itemUUID, principalType, principalUUID); err != nil {
+		errUtils.LogErr(logger, "HardRemoveACLRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) ReadPermissions(user models.User, itemType, itemUUID string) (models.Permissions, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ReadPermissions",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+	})
+	logger.Debug("Start reading permissions")
+	defer logger.Debug("Finish reading permissions")
+	return u.readItemPermissions(logger, user, itemType, itemUUID, 0)
+}
+
+func (u *FManLocalUsecase) ListSharedWithMe(user models.User) ([]models.SharedEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListSharedWithMe",
+	})
+	logger.Debug("Start listing items shared with user")
+	defer logger.Debug("Finish listing items shared with user")
+	if err := authenticated(logger, user); err != nil {
+		return nil, err
+	}
+	principals, err := u.principals(logger, user)
+	if err != nil {
+		return nil, err
+	}
+	principalUUIDs := []string{user.UUID}
+	for groupUUID := range principals.groupUUIDs {
+		principalUUIDs = append(principalUUIDs, groupUUID)
+	}
+	entries, err := u.dbACLRepo.ListPrincipalACLRecords(principalUUIDs)
+	if err != nil {
+		errUtils.LogErr(logger, "ListPrincipalACLRecords", err)
+		return nil, err
+	}
+	shared := []models.SharedEntry{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if seen[entry.ItemUUID] {
+			continue
+		}
+		seen[entry.ItemUUID] = true
+		item, ok, err := u.readSharedEntry(logger, user, entry)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			shared = append(shared, item)
+		}
+	}
+	sort.Slice(shared, func(i, j int) bool { return sharedEntryPath(shared[i]) < sharedEntryPath(shared[j]) })
+	return shared, nil
+}
+
+func (u *FManLocalUsecase) CreateGroup(user models.User, name string) (models.Group, error) {
+	// Generate a new UUID.
+	newGroupUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "CreateGroup",
+		"groupUUID": newGroupUUID,
+		"name":      name,
+	})
+	logger.Debug("Start creating group")
+	defer logger.Debug("Finish creating group")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return models.Group{}, err
+	}
+	if !groupNamePattern.MatchString(name) {
+		logger.Infof("[-USER-] %q is not a valid group name", name)
+		return models.Group{}, models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("%q is not a valid group name", name))
+	}
+	group := models.Group{
+		UUID:        newGroupUUID,
+		Name:        name,
+		MemberUUIDs: []string{},
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := u.dbACLRepo.InsertGroupRecord(group); err != nil {
+		errUtils.LogErr(logger, "InsertGroupRecord", err)
+		return models.Group{}, err
+	}
+	return group, nil
+}
+
+func (u *FManLocalUsecase) ListGroups(user models.User) ([]models.Group, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListGroups",
+	})
+	logger.Debug("Start listing groups")
+	defer logger.Debug("Finish listing groups")
+	if err := authenticated(logger, user); err != nil {
+		return nil, err
+	}
+	// Admins see all groups, other users only their own.
+	memberUUID := user.UUID
+	if user.IsAdmin {
+		memberUUID = ""
+	}
+	groups, err := u.dbACLRepo.ListGroupRecords(memberUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListGroupRecords", err)
+		return nil, err
+	}
+	return groups, nil
+}
+
+func (u *FManLocalUsecase) RemoveGroup(user models.User, groupUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveGroup",
+		"groupUUID": groupUUID,
+	})
+	logger.Debug("Start removing group")
+	defer logger.Debug("Finish removing group")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return err
+	}
+	if err := u.dbACLRepo.HardRemoveGroupRecord(groupUUID); err != nil {
+		errUtils.LogErr(logger, "HardRemoveGroupRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) AddGroupMember(user models.User, groupUUID, userUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "AddGroupMember",
+		"groupUUID": groupUUID,
+		"userUUID":  userUUID,
+	})
+	logger.Debug("Start adding group member")
+	defer logger.Debug("Finish adding group member")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return err
+	}
+	if err := u.dbACLRepo.InsertGroupMemberRecord(groupUUID, userUUID); err != nil {
+		errUtils.LogErr(logger, "InsertGroupMemberRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveGroupMember(user models.User, groupUUID, userUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveGroupMember",
+		"groupUUID": groupUUID,
+		"userUUID":  userUUID,
+	})
+	logger.Debug("Start removing group member")
+	defer logger.Debug("Finish removing group member")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return err
+	}
+	if err := u.dbACLRepo.HardRemoveGroupMemberRecord(groupUUID, userUUID); err != nil {
+		errUtils.LogErr(logger, "HardRemoveGroupMemberRecord", err)
+		return err
+	}
+	return nil
+}
+
+// readItemPermissions reads the record of a file or a directory, on which a user has a
+// permission, and returns all permissions of the user on it.
+func (u *FManLocalUsecase) readItemPermissions(logger *log.Entry, user models.User, itemType, itemUUID string,
+	perm models.Permissions) (models.Permissions, error) {
+	switch itemType {
+	case models.EntryTypeFile:
+		file, err := u.dbFileRepo.ReadFileRecord(itemUUID)
+		if err != nil {
+			errUtils.LogErr(logger, "ReadFileRecord", err)
+			return 0, err
+		}
+		return u.authorizePermission(logger, user, perm, file.OwnerUUID, file.UUID, file.ParentUUID)
+	case models.EntryTypeDir:
+		return u.readDirPermissions(logger, user, itemUUID, perm)
+	}
+	logger.Infof("[-USER-] unknown item type %s", itemType)
+	return 0, models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown item type %s", itemType))
+}
+
+// readSharedEntry reads the file/dir of an access control entry, which applies to a user,
+// together with the user's permissions on it. ok is false if the item is owned by the user,
+// in the recycle bin, or not readable by the user because of another entry.
+func (u *FManLocalUsecase) readSharedEntry(logger *log.Entry, user models.User, entry models.ACLEntry) (models.SharedEntry, bool, error) {
+	item := models.SharedEntry{ItemType: entry.ItemType}
+	var ownerUUID, fileUUID, dirUUID string
+	if entry.ItemType == models.EntryTypeFile {
+		file, err := u.dbFileRepo.ReadFileRecord(entry.ItemUUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			return models.SharedEntry{}, false, nil
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadFileRecord", err)
+			return models.SharedEntry{}, false, err
+		}
+		item.File = &file
+		ownerUUID, fileUUID, dirUUID = file.OwnerUUID, file.UUID, file.ParentUUID
+	} else {
+		dir, err := u.dbDirRepo.ReadDirRecord(entry.ItemUUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			return models.SharedEntry{}, false, nil
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadDirRecord", err)
+			return models.SharedEntry{}, false, err
+		}
+		item.Directory = &dir
+		ownerUUID, dirUUID = dir.OwnerUUID, dir.UUID
+	}
+	if ownerUUID == user.UUID {
+		return models.SharedEntry{}, false, nil
+	}
+	perms, err := u.permissions(logger, user, ownerUUID, fileUUID, dirUUID)
+	if err != nil {
+		return models.SharedEntry{}, false, err
+	}
+	item.Permissions = perms
+	return item, perms.Has(models.PermRead), nil
+}
+
+// sharedEntryPath returns the path of the file/dir of a shared entry.
+func sharedEntryPath(item models.SharedEntry) string {
+	if item.File != nil {
+		return item.File.Path
+	}
+	return item.Directory.Path
+}
@@ -0,0 +1,319 @@
+package usecase
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultArchiveName is the name of an archive, without extension, of several files/dirs or
+// of a root directory.
+const defaultArchiveName = "download"
+
+// archiveEntry is a file or a directory in an archive.
+type archiveEntry struct {
+	// path of the entry relative to the root of the archive, separated by slashes.
+	path    string
+	isDir   bool
+	modTime time.Time
+	// file is the file of an entry, which is not a directory.
+	file models.File
+}
+
+func (u *FManLocalUsecase) DownloadArchive(ctx context.Context, user models.User, items []models.ItemRef,
+	format string) (models.Archive, io.ReadCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "DownloadArchive",
+		"items":     len(items),
+		"format":    format,
+	})
+	logger.Debug("Start downloading archive")
+	defer logger.Debug("Finish downloading archive")
+	if !models.IsArchiveFormat(format) {
+		logger.Infof("[-USER-] unknown archive format %s", format)
+		return models.Archive{}, nil, models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("unknown archive format %s", format))
+	}
+	if len(items) == 0 || len(items) > models.MaxArchiveItems {
+		logger.Infof("[-USER-] %d items cannot be archived at once", len(items))
+		return models.Archive{}, nil, models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("between 1 and %d items can be archived at once", models.MaxArchiveItems))
+	}
+	entries, name, err := u.archiveEntries(logger, user, items)
+	if err != nil {
+		return models.Archive{}, nil, err
+	}
+	archive := models.Archive{Name: name + "." + format, Format: format}
+	for _, entry := range entries {
+		if entry.isDir {
+			archive.Dirs++
+		} else {
+			archive.Files++
+			archive.Bytes += entry.file.FileSize
+		}
+	}
+	logger.Debugf("Archiving %d files and %d directories", archive.Files, archive.Dirs)
+	// The archive is built while it is read, so it is never staged as a whole. Closing the
+	// reader or ctx being done makes the next write fail, which stops building it. The reader
+	// then gets the error of ctx.
+	pr, pw := io.Pipe()
+	go func() {
+		err := u.writeArchive(logger, format, entries, &contextWriter{ctx: ctx, w: pw})
+		switch {
+		case err == nil:
+		case errors.Is(err, io.ErrClosedPipe) || ctx.Err() != nil:
+			logger.Info("[-USER-] download of the archive was cancelled")
+		default:
+			logger.Errorf("[-INTERNAL-] Writing the archive failed with error %s", err.Error())
+		}
+		pw.CloseWithError(err)
+	}()
+	return archive, pr, nil
+}
+
+// contextWriter writes to another writer until a context is done.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// archiveEntries returns the entries of an archive of files and directories, on which a user
+// has the read permission, together with the name of the archive without extension. A
+// directory is archived with the part of its subtree, which the user can read, keeping its
+// structure, and the content of a root directory is put at the top of the archive. Items of
+// the same name get numbered names, e.g. "report (1).pdf".
+func (u *FManLocalUsecase) archiveEntries(logger *log.Entry, user models.User,
+	items []models.ItemRef) ([]archiveEntry, string, error) {
+	var entries []archiveEntry
+	name := defaultArchiveName
+	// The names taken at the top of the archive.
+	taken := make(map[string]bool)
+	topLevelName := func(name string, isDir bool) string {
+		candidate := name
+		for i := 1; taken[candidate]; i++ {
+			candidate = numberedName(name, i, isDir)
+		}
+		taken[candidate] = true
+		return candidate
+	}
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		if seen[item.UUID] {
+			continue
+		}
+		seen[item.UUID] = true
+		switch item.ItemType {
+		case models.EntryTypeFile:
+			file, err := u.readFile(logger, user, item.UUID, models.PermRead)
+			if err != nil {
+				return nil, "", err
+			}
+			entries = append(entries, archiveEntry{path: topLevelName(file.Filename, false), modTime: file.UpdatedAt, file: file})
+			name = file.Filename
+		case models.EntryTypeDir:
+			if _, err := u.readDir(logger, user, item.UUID, models.PermRead); err != nil {
+				return nil, "", err
+			}
+			dirs, files, err := u.walkSubtree(item.UUID)
+			if err != nil {
+				errUtils.LogErr(logger, "walkSubtree", err)
+				return nil, "", err
+			}
+			dirs, files, err = u.readableSubtree(logger, user, dirs, files)
+			if err != nil {
+				return nil, "", err
+			}
+			// Map the UUIDs of the directories to their paths in the archive.
+			paths := map[string]string{dirs[0].ParentUUID: ""}
+			name = dirs[0].Dirname
+			if name == "" {
+				paths[dirs[0].UUID] = ""
+				dirs = dirs[1:]
+				name = defaultArchiveName
+			}
+			for _, dir := range dirs {
+				dirPath := path.Join(paths[dir.ParentUUID], dir.Dirname)
+				if paths[dir.ParentUUID] == "" {
+					dirPath = topLevelName(dir.Dirname, true)
+				}
+				paths[dir.UUID] = dirPath
+				entries = append(entries, archiveEntry{path: dirPath, isDir: true, modTime: dir.UpdatedAt})
+			}
+			for _, file := range files {
+				filePath := path.Join(paths[file.ParentUUID], file.Filename)
+				if paths[file.ParentUUID] == "" {
+					filePath = topLevelName(file.Filename, false)
+				}
+				entries = append(entries, archiveEntry{path: filePath, modTime: file.UpdatedAt, file: file})
+			}
+		default:
+			logger.Infof("[-USER-] unknown item type %s", item.ItemType)
+			return nil, "", models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown item type %s", item.ItemType))
+		}
+	}
+	if len(seen) > 1 {
+		name = defaultArchiveName
+	}
+	return entries, name, nil
+}
+
+// writeArchive writes an archive of a format with entries to w. The content of the files is
+// read from the storage while the archive is written.
+func (u *FManLocalUsecase) writeArchive(logger *log.Entry, format string, entries []archiveEntry, w io.Writer) error {
+	switch format {
+	case models.ArchiveFormatZip:
+		return u.writeZip(logger, entries, w)
+	case models.ArchiveFormatTar:
+		return u.writeTar(logger, entries, w)
+	default:
+		gz := gzip.NewWriter(w)
+		if err := u.writeTar(logger, entries, gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+}
+
+// writeZip writes a ZIP archive with entries to w. Sizes are written after the content, and
+// ZIP64 records are added for content and archives too large for ZIP.
+func (u *FManLocalUsecase) writeZip(logger *log.Entry, entries []archiveEntry, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range entries {
+		entry, ok, err := u.readArchiveEntry(logger, entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		header := &zip.FileHeader{Name: entry.path, Modified: entry.modTime.UTC(), Method: zip.Deflate}
+		if entry.isDir {
+			header.Name += "/"
+			header.SetMode(os.ModeDir | 0755)
+		} else {
+			header.SetMode(0644)
+			if isCompressedContent(entry.file.ContentType) {
+				header.Method = zip.Store
+			}
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if !entry.isDir {
+			if err := u.copyFileContent(fw, entry.file); err != nil {
+				return err
+			}
+		}
+	}
+	return zw.Close()
+}
+
+// writeTar writes a tar archive with entries to w.
+func (u *FManLocalUsecase) writeTar(logger *log.Entry, entries []archiveEntry, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		entry, ok, err := u.readArchiveEntry(logger, entry)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		header := &tar.Header{
+			Name:     entry.path,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(entry.file.FileSize),
+			ModTime:  entry.modTime,
+		}
+		if entry.isDir {
+			header.Name += "/"
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !entry.isDir {
+			if err := u.copyFileContent(tw, entry.file); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// readArchiveEntry reads the record of the file of an archive entry right before it is
+// written, as listed files lack the name of their content in the storage, and the content
+// may have changed since the entries were collected. ok is false if the file was removed in
+// the meantime.
+func (u *FManLocalUsecase) readArchiveEntry(logger *log.Entry, entry archiveEntry) (archiveEntry, bool, error) {
+	if entry.isDir {
+		return entry, true, nil
+	}
+	file, err := u.dbFileRepo.ReadFileRecord(entry.file.UUID)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		logger.Debugf("Skipping file %s, which was removed", entry.file.UUID)
+		return archiveEntry{}, false, nil
+	}
+	if err != nil {
+		errUtils.LogErr(logger, "ReadFileRecord", err)
+		return archiveEntry{}, false, err
+	}
+	entry.file, entry.modTime = file, file.UpdatedAt
+	return entry, true, nil
+}
+
+// copyFileContent writes the content of a file to w. The content must have the size of the
+// file, as the size may be written before it.
+func (u *FManLocalUsecase) copyFileContent(w io.Writer, file models.File) error {
+	rc, err := u.fileOps.ReadFile(file.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if _, err := io.CopyN(w, rc, int64(file.FileSize)); err == io.EOF {
+		return fmt.Errorf("content of file %s is shorter than %d bytes", file.UUID, file.FileSize)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// isCompressedContent checks if the content of a MIME type is compressed already, so it is
+// stored in a ZIP archive as it is.
+func isCompressedContent(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-rar-compressed",
+		"application/ogg", "application/pdf", "audio/mpeg", "font/woff", "font/woff2",
+		"image/gif", "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "video/")
+}
@@ -0,0 +1,232 @@
+package usecase
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// tagPattern matches valid tags after normalizeTag.
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}._:+#-]{1,64}$`)
+
+// metadataKeyPattern matches valid metadata keys.
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// normalizeTag returns a tag in the form stored, so tags differing only in case are the same.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// validateTag normalizes a tag and checks if it is valid.
+func validateTag(logger *log.Entry, tag string) (string, error) {
+	tag = normalizeTag(tag)
+	if !tagPattern.MatchString(tag) {
+		logger.Infof("[-USER-] %q is not a valid tag", tag)
+		return "", models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("%q is not a valid tag", tag))
+	}
+	return tag, nil
+}
+
+func (u *FManLocalUsecase) ListTags(user models.User, itemType, itemUUID string) ([]string, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListTags",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+	})
+	logger.Debug("Start listing tags")
+	defer logger.Debug("Finish listing tags")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermRead); err != nil {
+		return nil, err
+	}
+	tags, err := u.dbAttributeRepo.ListTagRecords(itemUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListTagRecords", err)
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (u *FManLocalUsecase) AddTag(user models.User, itemType, itemUUID, tag string) error {
+	return u.TagItems(user, tag, []models.ItemRef{{ItemType: itemType, UUID: itemUUID}})
+}
+
+func (u *FManLocalUsecase) TagItems(user models.User, tag string, items []models.ItemRef) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "TagItems",
+		"tag":       tag,
+		"items":     len(items),
+	})
+	logger.Debug("Start tagging items")
+	defer logger.Debug("Finish tagging items")
+	tag, err := validateTag(logger, tag)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 || len(items) > models.MaxBulkTagItems {
+		logger.Infof("[-USER-] %d items cannot be tagged at once", len(items))
+		return models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("between 1 and %d items can be tagged at once", models.MaxBulkTagItems))
+	}
+	// Nothing is tagged unless all items can be tagged.
+	var tagged []models.ItemRef
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		if seen[item.UUID] {
+			continue
+		}
+		seen[item.UUID] = true
+		if _, err := u.readItemPermissions(logger, user, item.ItemType, item.UUID, models.PermWrite); err != nil {
+			return err
+		}
+		tags, err := u.dbAttributeRepo.ListTagRecords(item.UUID)
+		if err != nil {
+			errUtils.LogErr(logger, "ListTagRecords", err)
+			return err
+		}
+		// The tags are sorted.
+		if i := sort.SearchStrings(tags, tag); i < len(tags) && tags[i] == tag {
+			continue
+		}
+		if len(tags) >= models.MaxTagsPerItem {
+			logger.Infof("[-USER-] %s already has %d tags", item.UUID, len(tags))
+			return models.NewFManError(models.InvalidArgumentErrorCode,
+				fmt.Sprintf("%s %s cannot have more than %d tags", item.ItemType, item.UUID, models.MaxTagsPerItem))
+		}
+		tagged = append(tagged, item)
+	}
+	if len(tagged) == 0 {
+		return nil
+	}
+	if err := u.dbAttributeRepo.InsertTagRecords(tagged, tag); err != nil {
+		errUtils.LogErr(logger, "InsertTagRecords", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveTag(user models.User, itemType, itemUUID, tag string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveTag",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+		"tag":       tag,
+	})
+	logger.Debug("Start removing tag")
+	defer logger.Debug("Finish removing tag")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermWrite); err != nil {
+		return err
+	}
+	if err := u.dbAttributeRepo.HardRemoveTagRecord(itemUUID, normalizeTag(tag)); err != nil {
+		errUtils.LogErr(logger, "HardRemoveTagRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) ListMetadata(user models.User, itemType, itemUUID string) ([]models.MetadataEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListMetadata",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+	})
+	logger.Debug("Start listing metadata")
+	defer logger.Debug("Finish listing metadata")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermRead); err != nil {
+		return nil, err
+	}
+	entries, err := u.dbAttributeRepo.ListMetadataRecords(itemUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListMetadataRecords", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (u *FManLocalUsecase) SetMetadata(user models.User, itemType, itemUUID, key, value string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "SetMetadata",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+		"key":       key,
+	})
+	logger.Debug("Start setting metadata")
+	defer logger.Debug("Finish setting metadata")
+	if !metadataKeyPattern.MatchString(key) {
+		logger.Infof("[-USER-] %q is not a valid metadata key", key)
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("%q is not a valid metadata key", key))
+	}
+	if len(value) > models.MaxMetadataValueLength {
+		logger.Infof("[-USER-] metadata value of %d bytes is too long", len(value))
+		return models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("metadata values must not be longer than %d bytes", models.MaxMetadataValueLength))
+	}
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermWrite); err != nil {
+		return err
+	}
+	entries, err := u.dbAttributeRepo.ListMetadataRecords(itemUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListMetadataRecords", err)
+		return err
+	}
+	if len(entries) >= models.MaxMetadataPerItem && !hasMetadataKey(entries, key) {
+		logger.Infof("[-USER-] %s already has %d metadata keys", itemUUID, len(entries))
+		return models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("%s %s cannot have more than %d metadata keys", itemType, itemUUID, models.MaxMetadataPerItem))
+	}
+	entry := models.MetadataEntry{Key: key, Value: value, UpdatedAt: time.Now()}
+	if err := u.dbAttributeRepo.UpsertMetadataRecord(models.ItemRef{ItemType: itemType, UUID: itemUUID}, entry); err != nil {
+		errUtils.LogErr(logger, "UpsertMetadataRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveMetadata(user models.User, itemType, itemUUID, key string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveMetadata",
+		"itemType":  itemType,
+		"itemUUID":  itemUUID,
+		"key":       key,
+	})
+	logger.Debug("Start removing metadata")
+	defer logger.Debug("Finish removing metadata")
+	if _, err := u.readItemPermissions(logger, user, itemType, itemUUID, models.PermWrite); err != nil {
+		return err
+	}
+	if err := u.dbAttributeRepo.HardRemoveMetadataRecord(itemUUID, key); err != nil {
+		errUtils.LogErr(logger, "HardRemoveMetadataRecord", err)
+		return err
+	}
+	return nil
+}
+
+// hasMetadataKey checks if metadata entries contain a key.
+func hasMetadataKey(entries []models.MetadataEntry, key string) bool {
+	for _, entry := range entries {
+		if entry.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// copyAttributes copies the tags and the metadata of a file/dir to its copy.
+func (u *FManLocalUsecase) copyAttributes(logger *log.Entry, srcUUID, dstUUID string) error {
+	if err := u.dbAttributeRepo.CopyAttributeRecords(srcUUID, dstUUID); err != nil {
+		errUtils.LogErr(logger, "CopyAttributeRecords", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// sniffLen is the number of bytes at the start of a content, which its MIME type is detected
+// from. http.DetectContentType considers at most that many.
+const sniffLen = 512
+
+// executableSignatures are the magic numbers of executables, which http.DetectContentType
+// does not know, so they can be blocked.
+var executableSignatures = []struct {
+	magic       string
+	contentType string
+}{
+	{"MZ", "application/x-msdownload"},
+	{"\x7fELF", "application/x-executable"},
+	{"\xfe\xed\xfa\xce", "application/x-mach-binary"},
+	{"\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+}
+
+// signatureTypes are the MIME types of binary formats, which are detected by their magic
+// numbers. A content without the magic number is not of such a type, whatever its name says.
+var signatureTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/postscript":        true,
+	"application/ogg":               true,
+	"application/wasm":              true,
+	"application/x-gzip":            true,
+	"application/gzip":              true,
+	"application/zip":               true,
+	"application/x-rar-compressed":  true,
+	"application/vnd.ms-fontobject": true,
+	"application/x-msdownload":      true,
+	"application/x-executable":      true,
+	"application/x-mach-binary":     true,
+	"image/bmp":                     true,
+	"image/gif":                     true,
+	"image/jpeg":                    true,
+	"image/png":                     true,
+	"image/webp":                    true,
+	"image/x-icon":                  true,
+	"audio/aiff":                    true,
+	"audio/basic":                   true,
+	"audio/midi":                    true,
+	"audio/mpeg":                    true,
+	"audio/wave":                    true,
+	"video/avi":                     true,
+	"video/mp4":                     true,
+	"video/webm":                    true,
+	"font/collection":               true,
+	"font/otf":                      true,
+	"font/ttf":                      true,
+	"font/woff":                     true,
+	"font/woff2":                    true,
+}
+
+// detectContentType detects the MIME type of a content with a name from its first bytes.
+// The type given by the extension of the name is only used to refine a generic type found
+// in the content, e.g. text/css for text/plain or a document format for application/zip, so
+// a misleading name cannot disguise the content.
+func detectContentType(filename string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if sniffed == "application/octet-stream" {
+		for _, signature := range executableSignatures {
+			if bytes.HasPrefix(head, []byte(signature.magic)) {
+				return signature.contentType
+			}
+		}
+	}
+	byExtension := mime.TypeByExtension(path.Ext(filename))
+	if byExtension == "" || !refinesContentType(sniffed, byExtension) {
+		return sniffed
+	}
+	return byExtension
+}
+
+// refinesContentType checks if the type given by an extension is a more specific type of
+// the content of a sniffed type.
+func refinesContentType(sniffed, byExtension string) bool {
+	sniffedType, _, err := mime.ParseMediaType(sniffed)
+	if err != nil {
+		return false
+	}
+	extensionType, _, err := mime.ParseMediaType(byExtension)
+	if err != nil || signatureTypes[extensionType] {
+		return false
+	}
+	switch sniffedType {
+	case "text/plain":
+		return isTextType(extensionType)
+	case "application/octet-stream", "application/zip":
+		return !isTextType(extensionType)
+	default:
+		return false
+	}
+}
+
+// isTextType checks if a MIME type without parameters is a type of text.
+func isTextType(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/javascript", "application/ecmascript", "application/xml", "application/x-sh":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// checkContentType detects the MIME type of a content uploaded with a name into the
+// directory with a path, and rejects it if the content type policy of the directory does not
+// allow it. As the start of the content is read for that, it returns a reader of the whole
+// content together with the type.
+func (u *FManLocalUsecase) checkContentType(logger *log.Entry, filename, dirPath string,
+	contentReader io.Reader) (string, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(contentReader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		logger.Errorf("[-INTERNAL-] Reading the content failed with error %s", err.Error())
+		return "", nil, err
+	}
+	head = head[:n]
+	contentType := detectContentType(filename, head)
+	logger.Debugf("Detected content type %s", contentType)
+	if !u.contentTypePolicy(dirPath).Allows(contentType) {
+		logger.Infof("[-USER-] content type %s is not allowed in %s", contentType, dirPath)
+		return "", nil, models.NewFManError(models.UnsupportedMediaTypeErrorCode,
+			fmt.Sprintf("content type %s is not allowed in %s", contentType, dirPath))
+	}
+	return contentType, io.MultiReader(bytes.NewReader(head), contentReader), nil
+}
+
+// contentTypePolicy returns the content type policy in effect for the directory with a path.
+// The policy of the deepest directory, which has one, applies to its whole subtree, and the
+// global policy applies elsewhere.
+func (u *FManLocalUsecase) contentTypePolicy(dirPath string) models.ContentTypePolicy {
+	policy, depth := u.opts.ContentTypePolicy, -1
+	for policyPath, dirPolicy := range u.opts.DirContentTypePolicies {
+		inSubtree := dirPath == policyPath || strings.HasPrefix(dirPath, strings.TrimSuffix(policyPath, "/")+"/")
+		if inSubtree && len(policyPath) > depth {
+			policy, depth = dirPolicy, len(policyPath)
+		}
+	}
+	return policy
+}
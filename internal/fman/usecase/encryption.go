@@ -0,0 +1,40 @@
+package usecase
+
+import (
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func (u *FManLocalUsecase) RotateMasterKey() (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RotateMasterKey",
+	})
+	logger.Debug("Start rotating master key")
+	defer logger.Debug("Finish rotating master key")
+	rotator, ok := fileUtils.FindKeyRotator(u.fileOps)
+	if !ok {
+		logger.Error("[-INTERNAL-] storage is not encrypted")
+		return 0, models.NewFManError(models.InternalErrorCode, "storage is not encrypted")
+	}
+	storageKeys, err := u.dbFileRepo.ListStorageKeys()
+	if err != nil {
+		errUtils.LogErr(logger, "ListStorageKeys", err)
+		return 0, err
+	}
+	rewrapped := 0
+	for _, storageKey := range storageKeys {
+		ok, err := rotator.RewrapDataKey(storageKey)
+		if err != nil {
+			// The data keys rewrapped so far stay valid, so the rotation can be run again.
+			logger.Errorf("[-INTERNAL-] RewrapDataKey of %s failed with error %s", storageKey, err.Error())
+			return rewrapped, err
+		}
+		if ok {
+			rewrapped++
+		}
+	}
+	return rewrapped, nil
+}
@@ -0,0 +1,387 @@
+package usecase
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultExtractionLimits limit the extraction of uploaded archives if no limits are given.
+var DefaultExtractionLimits = models.ExtractionLimits{
+	MaxEntries: 10000,
+	MaxBytes:   10 << 30,
+	MaxRatio:   100,
+}
+
+// extractEntry is an entry of an uploaded archive.
+type extractEntry struct {
+	// name of the entry in the archive.
+	name string
+	// path of the entry relative to the directory the archive is extracted into, separated
+	// by slashes. It is empty for the directory itself, e.g. for the entry "./".
+	path  string
+	isDir bool
+	// skipped is true for entries, which are neither files nor directories, e.g. symbolic
+	// links. Their path is not validated, as nothing is created for them.
+	skipped bool
+	// metadata is true for skipped entries, which only hold metadata of the archive, e.g.
+	// PAX global headers, so they are not reported.
+	metadata bool
+	// size of the content of the entry, which is read through even if it is skipped.
+	size uint64
+	// open opens the content of a file entry.
+	open func() (io.ReadCloser, error)
+}
+
+func (u *FManLocalUsecase) ExtractArchive(user models.User, parentUUID string, archive io.ReaderAt, size int64,
+	format string) (models.Extraction, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "ExtractArchive",
+		"parentUUID": parentUUID,
+		"format":     format,
+		"size":       size,
+	})
+	logger.Debug("Start extracting archive")
+	defer logger.Debug("Finish extracting archive")
+	if !models.IsArchiveFormat(format) {
+		logger.Infof("[-USER-] unknown archive format %s", format)
+		return models.Extraction{}, models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("unknown archive format %s", format))
+	}
+	parent, err := u.readParentDir(logger, user, parentUUID, models.PermWrite)
+	if err != nil {
+		return models.Extraction{}, err
+	}
+	bytes, err := u.scanArchive(logger, archive, size, format)
+	if err != nil {
+		return models.Extraction{}, err
+	}
+	// The extracted files are owned by the owner of the directory, and the limits of the
+	// quota are checked again while each of them is stored.
+	if err := u.checkQuota(logger, parent.OwnerUUID, parentUUID, int64(bytes)); err != nil {
+		return models.Extraction{}, err
+	}
+
+	result := models.Extraction{Format: format}
+	// Everything created so far, which is removed again if the extraction fails midway. New
+	// versions of existing files are kept, as their previous content is retained anyway.
+	var createdDirs, createdFiles []string
+	rollback := func() {
+		logger.Debugf("Rolling back %d files and %d directories", len(createdFiles), len(createdDirs))
+		for i := len(createdFiles) - 1; i >= 0; i-- {
+			blobs, err := u.dbFileRepo.HardRemoveFileRecord(createdFiles[i])
+			if err != nil {
+				logger.Errorf("[-INTERNAL-] HardRemoveFileRecord of %s failed with error %s", createdFiles[i], err.Error())
+			}
+			u.removeContents(logger, blobs)
+		}
+		// Descendants are created after their parents, so they are removed before them.
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			if err := u.dbDirRepo.HardRemoveDirRecord(createdDirs[i]); err != nil {
+				logger.Errorf("[-INTERNAL-] HardRemoveDirRecord of %s failed with error %s", createdDirs[i], err.Error())
+			}
+		}
+	}
+	// Map the paths of the directories in the archive to their UUIDs.
+	dirUUIDs := map[string]string{"": parentUUID}
+	var extractDir func(dirPath string) (string, error)
+	extractDir = func(dirPath string) (string, error) {
+		if dirUUID, ok := dirUUIDs[dirPath]; ok {
+			return dirUUID, nil
+		}
+		parentUUID, err := extractDir(archiveParent(dirPath))
+		if err != nil {
+			return "", err
+		}
+		dirUUID, created, err := u.extractDirectory(logger, user, path.Base(dirPath), parentUUID)
+		if err != nil {
+			return "", err
+		}
+		if created {
+			createdDirs = append(createdDirs, dirUUID)
+			result.Dirs++
+		}
+		dirUUIDs[dirPath] = dirUUID
+		return dirUUID, nil
+	}
+	err = walkArchive(logger, archive, size, format, func(entry extractEntry) error {
+		switch {
+		case entry.metadata:
+			return nil
+		case entry.skipped:
+			logger.Debugf("Skipping entry %s, which is neither a file nor a directory", entry.name)
+			result.Skipped = append(result.Skipped, entry.name)
+			return nil
+		case entry.isDir:
+			_, err := extractDir(entry.path)
+			return err
+		}
+		dirUUID, err := extractDir(archiveParent(entry.path))
+		if err != nil {
+			return err
+		}
+		content, err := entry.open()
+		if err != nil {
+			return invalidArchive(logger, format, err)
+		}
+		defer content.Close()
+		contentReader := &entryReader{r: content}
+		newFileUUID := u.uuidGen.NewUUID()
+		fileUUID, err := u.saveNewFile(logger.WithField("entry", entry.name), user, newFileUUID, path.Base(entry.path),
+			dirUUID, contentReader, models.Checksums{})
+		if contentReader.err != nil {
+			// The content of the entry is corrupted, e.g. its checksum does not match.
+			return invalidArchive(logger, format, contentReader.err)
+		}
+		if err != nil {
+			return err
+		}
+		if fileUUID == newFileUUID {
+			createdFiles = append(createdFiles, newFileUUID)
+		}
+		result.Files++
+		result.Bytes += entry.size
+		return nil
+	})
+	if err != nil {
+		rollback()
+		return models.Extraction{}, err
+	}
+	logger.Debugf("Extracted %d files and %d directories", result.Files, result.Dirs)
+	return result, nil
+}
+
+// scanArchive reads the entries of an uploaded archive without extracting them and checks
+// them against the extraction limits, which count the content of the skipped entries too.
+// Every entry must have a path inside the directory the archive is extracted into, and no
+// path may be taken by both a file and a directory or by two files. It returns the total size
+// of the files.
+func (u *FManLocalUsecase) scanArchive(logger *log.Entry, archive io.ReaderAt, size int64, format string) (uint64, error) {
+	limits := u.opts.ExtractionLimits
+	var entries int
+	var total, bytes uint64
+	// Map the paths in the archive to whether they are taken by a directory.
+	taken := make(map[string]bool)
+	err := walkArchive(logger, archive, size, format, func(entry extractEntry) error {
+		entries++
+		if entries > limits.MaxEntries {
+			logger.Infof("[-USER-] archive has more than %d entries", limits.MaxEntries)
+			return models.NewFManError(models.TooLargeErrorCode,
+				fmt.Sprintf("archive has more than %d entries", limits.MaxEntries))
+		}
+		// Sizes are compared before they are added up any further, so the sum cannot overflow.
+		total += entry.size
+		if entry.size > uint64(limits.MaxBytes) || total > uint64(limits.MaxBytes) {
+			logger.Infof("[-USER-] content of archive is larger than %d bytes", limits.MaxBytes)
+			return models.NewFManError(models.TooLargeErrorCode,
+				fmt.Sprintf("content of archive is larger than %d bytes", limits.MaxBytes))
+		}
+		if entry.skipped || entry.path == "" {
+			return nil
+		}
+		for dirPath := archiveParent(entry.path); dirPath != ""; dirPath = archiveParent(dirPath) {
+			if isDir, ok := taken[dirPath]; ok && !isDir {
+				return archiveConflict(logger, dirPath)
+			}
+			taken[dirPath] = true
+		}
+		if isDir, ok := taken[entry.path]; ok && !(isDir && entry.isDir) {
+			return archiveConflict(logger, entry.path)
+		}
+		taken[entry.path] = entry.isDir
+		if !entry.isDir {
+			bytes += entry.size
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if float64(total) > float64(size)*limits.MaxRatio {
+		logger.Infof("[-USER-] content of archive is more than %g times as large as the archive", limits.MaxRatio)
+		return 0, models.NewFManError(models.TooLargeErrorCode,
+			fmt.Sprintf("content of archive is more than %g times as large as the archive", limits.MaxRatio))
+	}
+	logger.Debugf("Archive has %d entries with %d bytes of files", entries, bytes)
+	return bytes, nil
+}
+
+// extractDirectory returns the UUID of the directory with a name in a parent directory, which
+// an archive is extracted into. An existing directory needs the write permission, otherwise
+// a new directory is created as CreateNewDirectory does, and created is true.
+func (u *FManLocalUsecase) extractDirectory(logger *log.Entry, user models.User, dirname, parentUUID string) (string, bool, error) {
+	dir, err := u.dbDirRepo.ReadDirRecordByName(dirname, parentUUID)
+	if err == nil {
+		if err := u.authorizeDir(logger, user, dir, models.PermWrite); err != nil {
+			return "", false, err
+		}
+		return dir.UUID, false, nil
+	}
+	if !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		errUtils.LogErr(logger, "ReadDirRecordByName", err)
+		return "", false, err
+	}
+	newDirUUID := u.uuidGen.NewUUID()
+	if err := u.createDirectory(logger, user, newDirUUID, dirname, parentUUID); err != nil {
+		return "", false, err
+	}
+	return newDirUUID, true, nil
+}
+
+// walkArchive calls fn with the entries of an archive of a format in their order. The content
+// of an entry can only be read during its call. Errors reading the archive are logged and
+// returned as FManError, and errors of fn are returned as they are.
+func walkArchive(logger *log.Entry, archive io.ReaderAt, size int64, format string, fn func(entry extractEntry) error) error {
+	if format == models.ArchiveFormatZip {
+		zr, err := zip.NewReader(archive, size)
+		if err != nil {
+			return invalidArchive(logger, format, err)
+		}
+		for _, f := range zr.File {
+			mode := f.Mode()
+			entry := extractEntry{
+				name:    f.Name,
+				isDir:   mode.IsDir(),
+				skipped: !mode.IsDir() && !mode.IsRegular(),
+				size:    f.UncompressedSize64,
+				open:    f.Open,
+			}
+			if err := fillArchivePath(logger, &entry); err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var r io.Reader = io.NewSectionReader(archive, 0, size)
+	if format == models.ArchiveFormatTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return invalidArchive(logger, format, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return invalidArchive(logger, format, err)
+		}
+		entry := extractEntry{
+			name:     header.Name,
+			isDir:    header.Typeflag == tar.TypeDir,
+			skipped:  header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg,
+			metadata: header.Typeflag == tar.TypeXGlobalHeader,
+			size:     uint64(header.Size),
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(tr), nil
+			},
+		}
+		if err := fillArchivePath(logger, &entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// fillArchivePath sets the path of an entry, which is not skipped, from its name. A name
+// leading outside of the directory the archive is extracted into, e.g. "../x", "/etc/x" or
+// "C:\x", is rejected, as is a name with segments, which are not valid names.
+func fillArchivePath(logger *log.Entry, entry *extractEntry) error {
+	if entry.skipped {
+		return nil
+	}
+	name := entry.name
+	if strings.HasPrefix(name, "/") || strings.Contains(name, `\`) || isDriveLetter(name) {
+		return unsafeArchivePath(logger, name)
+	}
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return unsafeArchivePath(logger, name)
+		}
+		if err := validateName(segment); err != nil {
+			return unsafeArchivePath(logger, name)
+		}
+		segments = append(segments, segment)
+	}
+	entry.path = strings.Join(segments, "/")
+	if entry.path == "" && !entry.isDir {
+		return unsafeArchivePath(logger, name)
+	}
+	return nil
+}
+
+// isDriveLetter checks if a name starts with a Windows drive letter, e.g. "C:".
+func isDriveLetter(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0] | 0x20
+	return c >= 'a' && c <= 'z'
+}
+
+// archiveParent returns the path of the parent directory of a path in an archive, which is
+// empty for the directory the archive is extracted into.
+func archiveParent(entryPath string) string {
+	if i := strings.LastIndex(entryPath, "/"); i >= 0 {
+		return entryPath[:i]
+	}
+	return ""
+}
+
+// unsafeArchivePath logs and returns the error of an entry of an archive, which cannot be
+// extracted under its name.
+func unsafeArchivePath(logger *log.Entry, name string) error {
+	logger.Infof("[-USER-] archive entry %q has an unsafe path", name)
+	return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("archive entry %q has an unsafe path", name))
+}
+
+// archiveConflict logs and returns the error of a path in an archive, which is taken by more
+// than one file or by both a file and a directory.
+func archiveConflict(logger *log.Entry, entryPath string) error {
+	logger.Infof("[-USER-] %s occurs more than once in the archive", entryPath)
+	return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("%s occurs more than once in the archive", entryPath))
+}
+
+// invalidArchive logs and returns the error of an archive, which cannot be read.
+func invalidArchive(logger *log.Entry, format string, err error) error {
+	logger.Infof("[-USER-] invalid %s archive: %s", format, err.Error())
+	return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("invalid %s archive: %s", format, err.Error()))
+}
+
+// entryReader remembers the error of reading the content of an archive entry, which tells a
+// corrupted archive apart from failures of the storage.
+type entryReader struct {
+	r   io.Reader
+	err error
+}
+
+func (e *entryReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// tarArchive builds a tar archive of regular files, whose contents are keyed by their names.
+// Names ending with a slash are directories.
+func tarArchive(t *testing.T, names []string, contents map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		header := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(contents[name]))}
+		if name[len(name)-1] == '/' {
+			header.Mode, header.Typeflag, header.Size = 0755, tar.TypeDir, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(contents[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFillArchivePath(t *testing.T) {
+	logger := log.WithField("test", t.Name())
+	for _, tc := range []struct {
+		name  string
+		isDir bool
+		want  string
+		// unsafe is true for names, which are rejected.
+		unsafe bool
+	}{
+		{name: "a.txt", want: "a.txt"},
+		{name: "./docs//a.txt", want: "docs/a.txt"},
+		{name: "docs/", isDir: true, want: "docs"},
+		{name: "./", isDir: true, want: ""},
+		{name: "../a.txt", unsafe: true},
+		{name: "docs/../../a.txt", unsafe: true},
+		// A ".." leading back into the directory is rejected all the same.
+		{name: "docs/../a.txt", unsafe: true},
+		{name: "/etc/passwd", unsafe: true},
+		{name: `docs\a.txt`, unsafe: true},
+		{name: `..\a.txt`, unsafe: true},
+		{name: "C:/a.txt", unsafe: true},
+		{name: "c:a.txt", unsafe: true},
+		// A file needs a name of its own.
+		{name: "./", unsafe: true},
+	} {
+		entry := extractEntry{name: tc.name, isDir: tc.isDir}
+		err := fillArchivePath(logger, &entry)
+		if tc.unsafe {
+			if !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+				t.Errorf("fillArchivePath of %q returned %v, want an invalid argument error", tc.name, err)
+			}
+			continue
+		}
+		if err != nil || entry.path != tc.want {
+			t.Errorf("fillArchivePath of %q set path %q, %v, want %q", tc.name, entry.path, err, tc.want)
+		}
+	}
+
+	// Skipped entries are not created, so their names are not checked.
+	entry := extractEntry{name: "../link", skipped: true}
+	if err := fillArchivePath(logger, &entry); err != nil || entry.path != "" {
+		t.Errorf("fillArchivePath of a skipped entry set path %q, %v", entry.path, err)
+	}
+}
+
+func TestExtractArchiveRejectsUnsafePaths(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	docs := e.mkdir(t, alice, "docs", alice.RootDirUUID)
+
+	// An unsafe entry after safe ones rejects the whole archive before anything is extracted.
+	for _, unsafe := range []string{"../escaped.txt", "/abs.txt", "sub/../../escaped.txt"} {
+		archive := tarArchive(t, []string{"sub/", "sub/a.txt", unsafe}, map[string]string{"sub/a.txt": "a", unsafe: "x"})
+		_, err := e.uc.ExtractArchive(alice, docs, bytes.NewReader(archive), int64(len(archive)), models.ArchiveFormatTar)
+		if !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+			t.Errorf("ExtractArchive with entry %q returned %v, want an invalid argument error", unsafe, err)
+		}
+	}
+	if _, err := e.repo.ReadDirRecordByName("sub", docs); !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		t.Errorf("ReadDirRecordByName of an entry of a rejected archive returned %v, want a not found error", err)
+	}
+	if got := e.countStoredFiles(t); got != 0 {
+		t.Errorf("%d contents are stored after the archives were rejected, want 0", got)
+	}
+
+	// So do paths taken twice, even if they only meet once the names are cleaned.
+	archive := tarArchive(t, []string{"a.txt", "./a.txt/b.txt"}, map[string]string{"a.txt": "a", "./a.txt/b.txt": "b"})
+	_, err := e.uc.ExtractArchive(alice, docs, bytes.NewReader(archive), int64(len(archive)), models.ArchiveFormatTar)
+	if !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+		t.Errorf("ExtractArchive with a file used as a directory returned %v, want an invalid argument error", err)
+	}
+
+	// Cleaned names of safe entries are extracted below the directory.
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"./sub//a.txt", "sub/./b.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(name))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	extraction, err := e.uc.ExtractArchive(alice, docs, bytes.NewReader(buf.Bytes()), int64(buf.Len()),
+		models.ArchiveFormatZip)
+	if err != nil {
+		t.Fatalf("ExtractArchive failed: %s", err)
+	}
+	if extraction.Files != 2 || extraction.Dirs != 1 {
+		t.Errorf("ExtractArchive extracted %d files and %d directories, want 2 and 1", extraction.Files, extraction.Dirs)
+	}
+	file, err := e.repo.ReadFileRecordByName("a.txt", e.dirUUID(t, "sub", docs))
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	if file.Path != "/docs/sub/a.txt" {
+		t.Errorf("extracted file has path %s, want /docs/sub/a.txt", file.Path)
+	}
+}
@@ -3,8 +3,15 @@ package usecase
 import (
 	"fmt"
 	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
 	"github.com/nvthongswansea/xtreme/internal/fman"
+	"github.com/nvthongswansea/xtreme/internal/models"
 	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
 	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
 	log "github.com/sirupsen/logrus"
@@ -12,26 +19,112 @@ import (
 
 // FManLocalUsecase provides usecase(logic) for file manager on local storage.
 type FManLocalUsecase struct {
-	dbFileRepo fman.FManFileDBRepo
-	dbDirRepo  fman.FManDirDBRepo
-	dbValRepo  fman.FManValidator
-	uuidGen    uuidUtils.UUIDGenerator
-	fileOps    fileUtils.FileSaveReadRemover
+	dbFileRepo      fman.FManFileDBRepo
+	dbDirRepo       fman.FManDirDBRepo
+	dbValRepo       fman.FManValidateDBRepo
+	dbTrashRepo     fman.FManTrashDBRepo
+	dbUploadRepo    fman.FManUploadDBRepo
+	dbVersionRepo   fman.FManVersionDBRepo
+	dbQuotaRepo     fman.FManQuotaDBRepo
+	dbShareRepo     fman.FManShareDBRepo
+	dbACLRepo       fman.FManACLDBRepo
+	dbSearchRepo    fman.FManSearchDBRepo
+	dbAttributeRepo fman.FManAttributeDBRepo
+	dbThumbnailRepo fman.FManThumbnailDBRepo
+	uuidGen         uuidUtils.UUIDGenerator
+	fileOps         fileUtils.FileSaveReadRemover
+	opts            Options
+
+	// thumbnailQueue holds the UUIDs of the image files waiting for a Thumbnailer.
+	thumbnailQueue chan string
+
+	// uploadLocks serialize the chunks of the resumable uploads by their UUIDs. A lock is
+	// only kept while it is held or waited for.
+	uploadLocksMu sync.Mutex
+	uploadLocks   map[string]*uploadLock
+}
+
+// Options holds settings of FManLocalUsecase.
+type Options struct {
+	// UploadExpiration is how long a resumable upload can be continued after its creation.
+	// Zero means DefaultUploadExpiration.
+	UploadExpiration time.Duration
+
+	// MaxUploadSize is the maximum size of a resumable upload in bytes. Zero means no limit.
+	MaxUploadSize int64
+
+	// Versioning enables file versioning. An upload with the name of an existing file then
+	// adds a new version to it instead of failing, and old versions are retained.
+	Versioning bool
+
+	// VersionPolicy limits the retained old versions of files, unless a file has a policy of
+	// its own. Only used with Versioning.
+	VersionPolicy models.VersionPolicy
+
+	// DefaultQuota is the quota in bytes of users without a quota of their own. Zero means
+	// no limit.
+	DefaultQuota int64
+
+	// ContentTypePolicy restricts the MIME types of uploaded content, unless a policy of
+	// DirContentTypePolicies applies.
+	ContentTypePolicy models.ContentTypePolicy
+
+	// DirContentTypePolicies restrict the MIME types of content uploaded into the directories
+	// with the given paths and their subdirectories instead of ContentTypePolicy. The paths
+	// are the same in the trees of all users, e.g. /photos.
+	DirContentTypePolicies map[string]models.ContentTypePolicy
+
+	// ExtractionLimits limit the extraction of uploaded archives. Zero fields mean the ones
+	// of DefaultExtractionLimits.
+	ExtractionLimits models.ExtractionLimits
 }
 
 // NewFManLocalUsecase create a new FManLocalUsecase.
 func NewFManLocalUsecase(dbFileRepo fman.FManFileDBRepo, dbDirRepo fman.FManDirDBRepo, dbValRepo fman.FManValidateDBRepo,
-	uuidGen uuidUtils.UUIDGenerator, fileOps fileUtils.FileSaveReadRemover) *FManLocalUsecase {
+	dbTrashRepo fman.FManTrashDBRepo, dbUploadRepo fman.FManUploadDBRepo, dbVersionRepo fman.FManVersionDBRepo,
+	dbQuotaRepo fman.FManQuotaDBRepo, dbShareRepo fman.FManShareDBRepo, dbACLRepo fman.FManACLDBRepo,
+	dbSearchRepo fman.FManSearchDBRepo, dbAttributeRepo fman.FManAttributeDBRepo, dbThumbnailRepo fman.FManThumbnailDBRepo,
+	uuidGen uuidUtils.UUIDGenerator, fileOps fileUtils.FileSaveReadRemover, opts Options) *FManLocalUsecase {
+	if opts.UploadExpiration <= 0 {
+		opts.UploadExpiration = DefaultUploadExpiration
+	}
+	if opts.ExtractionLimits.MaxEntries <= 0 {
+		opts.ExtractionLimits.MaxEntries = DefaultExtractionLimits.MaxEntries
+	}
+	if opts.ExtractionLimits.MaxBytes <= 0 {
+		opts.ExtractionLimits.MaxBytes = DefaultExtractionLimits.MaxBytes
+	}
+	if opts.ExtractionLimits.MaxRatio <= 0 {
+		opts.ExtractionLimits.MaxRatio = DefaultExtractionLimits.MaxRatio
+	}
+	dirPolicies := make(map[string]models.ContentTypePolicy, len(opts.DirContentTypePolicies))
+	for dirPath, policy := range opts.DirContentTypePolicies {
+		dirPolicies[path.Join(models.RootDirPath, dirPath)] = policy
+	}
+	opts.DirContentTypePolicies = dirPolicies
 	return &FManLocalUsecase{
-		dbFileRepo,
-		dbDirRepo,
-		dbValRepo,
-		uuidGen,
-		fileOps,
+		dbFileRepo:      dbFileRepo,
+		dbDirRepo:       dbDirRepo,
+		dbValRepo:       dbValRepo,
+		dbTrashRepo:     dbTrashRepo,
+		dbUploadRepo:    dbUploadRepo,
+		dbVersionRepo:   dbVersionRepo,
+		dbQuotaRepo:     dbQuotaRepo,
+		dbShareRepo:     dbShareRepo,
+		dbACLRepo:       dbACLRepo,
+		dbSearchRepo:    dbSearchRepo,
+		dbAttributeRepo: dbAttributeRepo,
+		dbThumbnailRepo: dbThumbnailRepo,
+		uuidGen:         uuidGen,
+		fileOps:         fileOps,
+		opts:            opts,
+		thumbnailQueue:  make(chan string, thumbnailQueueSize),
+		uploadLocks:     make(map[string]*uploadLock),
 	}
 }
 
-func (u *FManLocalUsecase) UploadFile(filename, parentUUID string, contentReader io.Reader) error {
+func (u *FManLocalUsecase) UploadFile(user models.User, filename, parentUUID string, contentReader io.Reader,
+	expected models.Checksums) error {
 	// Generate a new UUID.
 	newFileUUID := u.uuidGen.NewUUID()
 	logger := log.WithFields(log.Fields{
@@ -43,50 +136,28 @@ func (u *FManLocalUsecase) UploadFile(filename, parentUUID string, contentReader
 	})
 	logger.Debug("Start uploading file")
 	defer logger.Debug("Finish uploading file")
-	// Validate parent UUID.
-	parentUUIDok, err := u.dbValRepo.IsParentUUIDExist(parentUUID)
-	if err != nil {
-		logger.Errorf("[-INTERNAL-] IsParentUUIDExist failed with error %s", err.Error())
-		return err
-	}
-	if !parentUUIDok {
-		logger.Infof("[-USER-] parent UUID (%s) does not exist", parentUUID)
-		return fmt.Errorf("parent UUID (%s) does not exist", parentUUID)
-	}
-	// Check if the file already exists in a desired location in the db.
-	isExist, err := u.dbValRepo.IsNameExist(filename, parentUUID)
-	if err != nil {
-		logger.Errorf("[-INTERNAL-] IsNameExist failed with error %s", err.Error())
-		return err
-	}
-	if isExist {
-		logger.Infof("[-USER-] %s already exists in the desired location", filename)
-		return fmt.Errorf("%s already exists in the desired location", filename)
-	}
-	// Save file to the local disk.
-	size, realPath, err := u.fileOps.SaveFile(newFileUUID, contentReader)
+	_, err := u.saveNewFile(logger, user, newFileUUID, filename, parentUUID, contentReader, expected)
+	return err
+}
+
+func (u *FManLocalUsecase) DownloadFile(user models.User, fileUUID string) (models.File, io.ReadSeekCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "DownloadFile",
+		"fileUUID":  fileUUID,
+	})
+	logger.Debug("Start downloading file")
+	defer logger.Debug("Finish downloading file")
+	file, err := u.readFile(logger, user, fileUUID, models.PermRead)
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] SaveFile failed with error %s", err.Error())
-		return err
-	}
-	// Insert new file record to the DB.
-	if err := u.dbFileRepo.InsertFileRecord(newFileUUID, filename, parentUUID, realPath, size); err != nil {
-		// If error presents while inserting a new record,
-		// remove the file from the storage.
-		defer func() {
-			logger.Debugf("Removing file %s", newFileUUID)
-			err := u.fileOps.RemoveFile(newFileUUID)
-			if err != nil {
-				logger.Errorf("[-INTERNAL-] RemoveFile failed with error %s", err.Error())
-			}
-		}()
-		logger.Errorf("[-INTERNAL-] InsertFileRecord failed with error %s", err.Error())
-		return err
+		return models.File{}, nil, err
 	}
-	return nil
+	// The content is opened lazily, so ranges can be read without reading the whole file.
+	content := fileUtils.NewFileReadSeeker(u.fileOps, file.StorageKey, int64(file.FileSize))
+	return file, content, nil
 }
 
-func (u *FManLocalUsecase) CopyFile(srcUUID, dstParentUUID string) error {
+func (u *FManLocalUsecase) CopyFile(user models.User, srcUUID, dstParentUUID string) error {
 	// Generate a new UUID for the destination file.
 	newFileUUID := u.uuidGen.NewUUID()
 	logger := log.WithFields(log.Fields{
@@ -99,18 +170,13 @@ func (u *FManLocalUsecase) CopyFile(srcUUID, dstParentUUID string) error {
 	logger.Debug("Start copying file")
 	defer logger.Debug("Finish copying file")
 	// Validate parent UUID.
-	parentUUIDok, err := u.dbValRepo.IsParentUUIDExist(dstParentUUID)
+	dstParent, err := u.readParentDir(logger, user, dstParentUUID, models.PermWrite)
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] IsParentUUIDExist failed with error %s", err.Error())
 		return err
 	}
-	if !parentUUIDok {
-		logger.Infof("[-USER-] parent UUID (%s) does not exist", dstParentUUID)
-	}
 	// Get the source filename.
-	srcFile, err := u.dbFileRepo.ReadFileRecord(srcUUID)
+	srcFile, err := u.readFile(logger, user, srcUUID, models.PermRead)
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] ReadFileRecord failed with error %s", err.Error())
 		return err
 	}
 	// Check if the file already exists in a desired location in the db.
@@ -121,39 +187,20 @@ func (u *FManLocalUsecase) CopyFile(srcUUID, dstParentUUID string) error {
 	}
 	if isExist {
 		logger.Infof("[-USER-] %s already exists in the desired location", srcFile.Filename)
-		return fmt.Errorf("%s already exists in the desired location", srcFile.Filename)
-	}
-	// Get source file pointer to read its content.
-	srcFReadCloser, err := u.fileOps.ReadFile(srcUUID)
-	if err != nil {
-		logger.Errorf("[-INTERNAL-] ReadFile failed with error %s", err.Error())
-		return err
+		return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", srcFile.Filename))
 	}
-	defer srcFReadCloser.Close()
-	// Save the dst file to the disk.
-	size, realPath, err := u.fileOps.SaveFile(newFileUUID, srcFReadCloser)
+	// The copy is owned by the owner of the destination, and counted in full although it
+	// shares the content.
+	release, err := u.reserveQuota(logger, dstParent.OwnerUUID, dstParentUUID, int64(srcFile.FileSize))
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] SaveFile failed with error %s", err.Error())
 		return err
 	}
-	// Insert a new file record to the DB.
-	if err = u.dbFileRepo.InsertFileRecord(newFileUUID, srcFile.Filename, dstParentUUID, realPath, size); err != nil {
-		// If error reprents while inserting a new record,
-		// remove the file from the storage.
-		defer func() {
-			logger.Debugf("Removing file %s", newFileUUID)
-			err := u.fileOps.RemoveFile(newFileUUID)
-			if err != nil {
-				logger.Errorf("[-INTERNAL-] RemoveFile failed with error %s", err.Error())
-			}
-		}()
-		logger.Errorf("[-INTERNAL-] InsertFileRecord failed with error %s", err.Error())
-		return err
-	}
-	return nil
+	defer release()
+	// The copy shares the content of the source file, so nothing is copied in the storage.
+	return u.copyFileRecord(logger, newFileUUID, dstParent.OwnerUUID, srcFile, dstParentUUID)
 }
 
-func (u *FManLocalUsecase) CreateNewDirectory(dirname, parentUUID string) error {
+func (u *FManLocalUsecase) CreateNewDirectory(user models.User, dirname, parentUUID string) error {
 	// Generate a new UUID.
 	newDirUUID := u.uuidGen.NewUUID()
 	logger := log.WithFields(log.Fields{
@@ -164,43 +211,584 @@ func (u *FManLocalUsecase) CreateNewDirectory(dirname, parentUUID string) error
 	})
 	logger.Debug("Start creating a new directory")
 	defer logger.Debug("Finish creating a new directory")
+	return u.createDirectory(logger, user, newDirUUID, dirname, parentUUID)
+}
+
+func (u *FManLocalUsecase) ListDirectory(user models.User, dirUUID string, opts models.DirListOptions) (models.Directory, string, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListDirectory",
+		"dirUUID":   dirUUID,
+	})
+	logger.Debug("Start listing directory")
+	defer logger.Debug("Finish listing directory")
+	perms, err := u.readDirPermissions(logger, user, dirUUID, models.PermRead)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	dir, nextCursor, err := u.dbDirRepo.ListDirRecord(dirUUID, opts)
+	if err != nil {
+		errUtils.LogErr(logger, "ListDirRecord", err)
+		return models.Directory{}, "", err
+	}
+	// The page may become shorter, but the cursor of the next page stays the same.
+	if err := u.hideUnreadableChildren(logger, user, perms, &dir); err != nil {
+		return models.Directory{}, "", err
+	}
+	return dir, nextCursor, nil
+}
+
+func (u *FManLocalUsecase) MoveFile(user models.User, fileUUID, newName, dstParentUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "MoveFile",
+		"fileUUID":      fileUUID,
+		"newName":       newName,
+		"dstParentUUID": dstParentUUID,
+	})
+	logger.Debug("Start moving file")
+	defer logger.Debug("Finish moving file")
+	file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadFileRecord", err)
+		return err
+	}
+	if newName == "" {
+		newName = file.Filename
+	}
+	if dstParentUUID == "" {
+		dstParentUUID = file.ParentUUID
+	}
+	if err := u.authorizeFile(logger, user, file, movePermission(newName, file.Filename, dstParentUUID, file.ParentUUID)); err != nil {
+		return err
+	}
+	if newName == file.Filename && dstParentUUID == file.ParentUUID {
+		return nil
+	}
+	parent, err := u.validateDestination(logger, user, newName, dstParentUUID)
+	if err != nil {
+		return err
+	}
+	if err := authorizeSameOwner(logger, user, file.OwnerUUID, parent); err != nil {
+		return err
+	}
+	if err := u.dbFileRepo.UpdateFileRecord(fileUUID, newName, dstParentUUID); err != nil {
+		errUtils.LogErr(logger, "UpdateFileRecord", err)
+		return err
+	}
+	// A new extension can make the file text-like, or an image.
+	u.indexFileContent(logger, fileUUID)
+	u.queueThumbnails(logger, fileUUID, newName)
+	return nil
+}
+
+func (u *FManLocalUsecase) MoveDirectory(user models.User, dirUUID, newName, dstParentUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "MoveDirectory",
+		"dirUUID":       dirUUID,
+		"newName":       newName,
+		"dstParentUUID": dstParentUUID,
+	})
+	logger.Debug("Start moving directory")
+	defer logger.Debug("Finish moving directory")
+	dir, err := u.dbDirRepo.ReadDirRecord(dirUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadDirRecord", err)
+		return err
+	}
+	if newName == "" {
+		newName = dir.Dirname
+	}
+	if dstParentUUID == "" {
+		dstParentUUID = dir.ParentUUID
+	}
+	if err := u.authorizeDir(logger, user, dir, movePermission(newName, dir.Dirname, dstParentUUID, dir.ParentUUID)); err != nil {
+		return err
+	}
+	if dir.ParentUUID == "" {
+		logger.Info("[-USER-] root directory cannot be moved")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be moved")
+	}
+	if newName == dir.Dirname && dstParentUUID == dir.ParentUUID {
+		return nil
+	}
+	parent, err := u.validateDestination(logger, user, newName, dstParentUUID)
+	if err != nil {
+		return err
+	}
+	if err := authorizeSameOwner(logger, user, dir.OwnerUUID, parent); err != nil {
+		return err
+	}
+	// The repository refuses to move the directory into its own subtree, and rewrites
+	// the paths of all descendants.
+	if err := u.dbDirRepo.UpdateDirRecord(dirUUID, newName, dstParentUUID); err != nil {
+		errUtils.LogErr(logger, "UpdateDirRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveFile(user models.User, fileUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveFile",
+		"fileUUID":  fileUUID,
+	})
+	logger.Debug("Start removing file")
+	defer logger.Debug("Finish removing file")
+	// Make sure the file is not in the recycle bin, where it is removed together with its entry.
+	if _, err := u.readFile(logger, user, fileUUID, models.PermDelete); err != nil {
+		return err
+	}
+	blobs, err := u.dbFileRepo.HardRemoveFileRecord(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveFileRecord", err)
+		return err
+	}
+	u.removeContents(logger, blobs)
+	return nil
+}
+
+func (u *FManLocalUsecase) MoveFileToRecyleBin(user models.User, fileUUID string) error {
+	// Generate a new UUID for the recycle bin entry.
+	newTrashUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "MoveFileToRecyleBin",
+		"fileUUID":  fileUUID,
+		"trashUUID": newTrashUUID,
+	})
+	logger.Debug("Start moving file to recycle bin")
+	defer logger.Debug("Finish moving file to recycle bin")
+	if _, err := u.readFile(logger, user, fileUUID, models.PermDelete); err != nil {
+		return err
+	}
+	if err := u.dbFileRepo.SoftRemoveFileRecord(fileUUID, newTrashUUID); err != nil {
+		errUtils.LogErr(logger, "SoftRemoveFileRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) MoveDirectoryToRecycleBin(user models.User, dirUUID string) error {
+	// Generate a new UUID for the recycle bin entry.
+	newTrashUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "MoveDirectoryToRecycleBin",
+		"dirUUID":   dirUUID,
+		"trashUUID": newTrashUUID,
+	})
+	logger.Debug("Start moving directory to recycle bin")
+	defer logger.Debug("Finish moving directory to recycle bin")
+	dir, err := u.readDir(logger, user, dirUUID, models.PermDelete)
+	if err != nil {
+		return err
+	}
+	if dir.ParentUUID == "" {
+		logger.Info("[-USER-] root directory cannot be removed")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+	}
+	if err := u.authorizeSubtree(logger, user, dir, models.PermDelete); err != nil {
+		return err
+	}
+	if err := u.dbDirRepo.SoftRemoveDirRecord(dirUUID, newTrashUUID); err != nil {
+		errUtils.LogErr(logger, "SoftRemoveDirRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) ListRecycleBin(user models.User) ([]models.TrashEntry, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListRecycleBin",
+	})
+	logger.Debug("Start listing recycle bin")
+	defer logger.Debug("Finish listing recycle bin")
+	if err := authenticated(logger, user); err != nil {
+		return nil, err
+	}
+	entries, err := u.dbTrashRepo.ListTrashRecords(user.UUID, time.Time{})
+	if err != nil {
+		errUtils.LogErr(logger, "ListTrashRecords", err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (u *FManLocalUsecase) RestoreFromRecycleBin(user models.User, trashUUID string, opts models.RestoreOptions) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "RestoreFromRecycleBin",
+		"trashUUID":     trashUUID,
+		"newName":       opts.Name,
+		"dstParentUUID": opts.ParentUUID,
+	})
+	logger.Debug("Start restoring from recycle bin")
+	defer logger.Debug("Finish restoring from recycle bin")
+	switch opts.OnConflict {
+	case "", models.RestoreConflictFail, models.RestoreConflictRename:
+	default:
+		logger.Infof("[-USER-] unknown conflict policy %s", opts.OnConflict)
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown conflict policy %s", opts.OnConflict))
+	}
+	entry, err := u.readTrashEntry(logger, user, trashUUID)
+	if err != nil {
+		return err
+	}
+	name := opts.Name
+	if name == "" {
+		name = entry.Name
+	}
+	parentUUID := opts.ParentUUID
+	if parentUUID == "" {
+		parentUUID = entry.OriginalParentUUID
+	}
+	if err := validateName(name); err != nil {
+		errUtils.LogErr(logger, "validateName", err)
+		return err
+	}
 	// Validate parent UUID.
-	parentUUIDok, err := u.dbValRepo.IsParentUUIDExist(parentUUID)
+	parent, err := u.readParentDir(logger, user, parentUUID, models.PermWrite)
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] IsParentUUIDExist failed with error %s", err.Error())
 		return err
 	}
-	if !parentUUIDok {
-		logger.Infof("[-USER-] parent UUID (%s) does not exist", parentUUID)
-		return fmt.Errorf("parent UUID (%s) does not exist", parentUUID)
+	if err := authorizeSameOwner(logger, user, entry.OwnerUUID, parent); err != nil {
+		return err
 	}
-	// Check if the directory already exists in a desired location in the db.
-	isExist, err := u.dbValRepo.IsNameExist(dirname, parentUUID)
+	// Check if the name already exists in a desired location in the db, and look for
+	// a free name if the conflict policy allows it.
+	isExist, err := u.dbValRepo.IsNameExist(name, parentUUID)
 	if err != nil {
 		logger.Errorf("[-INTERNAL-] IsNameExist failed with error %s", err.Error())
 		return err
 	}
 	if isExist {
-		logger.Infof("[-USER-] %s already exists in the desired location", dirname)
-		return fmt.Errorf("%s already exists in the desired location", dirname)
+		if opts.OnConflict != models.RestoreConflictRename {
+			logger.Infof("[-USER-] %s already exists in the desired location", name)
+			return models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", name))
+		}
+		name, err = u.freeName(name, parentUUID, entry.ItemType == models.EntryTypeDir)
+		if err != nil {
+			errUtils.LogErr(logger, "freeName", err)
+			return err
+		}
+	}
+	if err := u.dbTrashRepo.RestoreTrashRecord(trashUUID, name, parentUUID); err != nil {
+		errUtils.LogErr(logger, "RestoreTrashRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveFromRecycleBin(user models.User, trashUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveFromRecycleBin",
+		"trashUUID": trashUUID,
+	})
+	logger.Debug("Start removing from recycle bin")
+	defer logger.Debug("Finish removing from recycle bin")
+	if _, err := u.readTrashEntry(logger, user, trashUUID); err != nil {
+		return err
+	}
+	blobs, err := u.dbTrashRepo.HardRemoveTrashRecord(trashUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveTrashRecord", err)
+		return err
+	}
+	u.removeContents(logger, blobs)
+	return nil
+}
+
+func (u *FManLocalUsecase) EmptyRecycleBin(user models.User) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "EmptyRecycleBin",
+	})
+	logger.Debug("Start emptying recycle bin")
+	defer logger.Debug("Finish emptying recycle bin")
+	if err := authenticated(logger, user); err != nil {
+		return err
+	}
+	_, err := u.purgeTrash(logger, user.UUID, time.Time{})
+	return err
+}
+
+func (u *FManLocalUsecase) PurgeRecycleBin(deletedBefore time.Time) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "PurgeRecycleBin",
+		"deletedBefore": deletedBefore,
+	})
+	logger.Debug("Start purging recycle bin")
+	defer logger.Debug("Finish purging recycle bin")
+	if deletedBefore.IsZero() {
+		logger.Info("[-USER-] deletedBefore must not be zero")
+		return 0, models.NewFManError(models.InvalidArgumentErrorCode, "deletedBefore must not be zero")
+	}
+	return u.purgeTrash(logger, "", deletedBefore)
+}
+
+// validateName checks if a name can be used for a file/dir.
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\x00") {
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("%q is not a valid name", name))
+	}
+	return nil
+}
+
+// validateDestination checks if a file/dir can be placed with a name into a parent directory
+// by a user, who needs the write permission on it, and returns the parent directory.
+func (u *FManLocalUsecase) validateDestination(logger *log.Entry, user models.User, name, parentUUID string) (models.Directory, error) {
+	if err := validateName(name); err != nil {
+		errUtils.LogErr(logger, "validateName", err)
+		return models.Directory{}, err
+	}
+	// Validate parent UUID.
+	parent, err := u.readParentDir(logger, user, parentUUID, models.PermWrite)
+	if err != nil {
+		return models.Directory{}, err
+	}
+	// Check if the name already exists in a desired location in the db.
+	isExist, err := u.dbValRepo.IsNameExist(name, parentUUID)
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] IsNameExist failed with error %s", err.Error())
+		return models.Directory{}, err
+	}
+	if isExist {
+		logger.Infof("[-USER-] %s already exists in the desired location", name)
+		return models.Directory{}, models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("%s already exists in the desired location", name))
+	}
+	return parent, nil
+}
+
+// createDirectory validates the name and the parent UUID of a new directory created by a
+// user and inserts its record, owned by the owner of the parent directory, to the DB.
+func (u *FManLocalUsecase) createDirectory(logger *log.Entry, user models.User, newDirUUID, dirname, parentUUID string) error {
+	// Validate the name and the parent UUID.
+	parent, err := u.validateDestination(logger, user, dirname, parentUUID)
+	if err != nil {
+		return err
 	}
 
+	// Insert new directory record to the DB.
+	err = u.dbDirRepo.InsertDirRecord(newDirUUID, dirname, parentUUID, parent.OwnerUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "InsertDirRecord", err)
+		return err
+	}
+	return nil
+}
+
+// movePermission returns the permission needed to rename a file/dir and to move it from its
+// parent directory to another one. Moving it away needs the delete permission, as it is gone
+// from its parent afterwards.
+func movePermission(newName, oldName, newParentUUID, oldParentUUID string) models.Permissions {
+	perm := models.PermRead
+	if newName != oldName {
+		perm |= models.PermWrite
+	}
+	if newParentUUID != oldParentUUID {
+		perm |= models.PermDelete
+	}
+	return perm
+}
+
+// saveNewFile creates a new file uploaded by a user as createFile does. With versioning, the
+// content becomes a new version of an existing file with the same name instead. It returns
+// the UUID of the file.
+func (u *FManLocalUsecase) saveNewFile(logger *log.Entry, user models.User, newFileUUID, filename, parentUUID string,
+	contentReader io.Reader, expected models.Checksums) (string, error) {
+	file, err := u.versionedFile(logger, user, filename, parentUUID)
+	if err != nil {
+		return "", err
+	}
+	if file.UUID != "" {
+		logger.Debugf("Adding a new version to file %s", file.UUID)
+		_, err := u.saveNewVersion(logger.WithField("fileUUID", file.UUID), user, file, contentReader, expected)
+		return file.UUID, err
+	}
+	if err := u.createFile(logger, user, newFileUUID, filename, parentUUID, contentReader, expected); err != nil {
+		return "", err
+	}
+	return newFileUUID, nil
+}
+
+// createFile validates the name and the parent UUID of a new file uploaded by a user, saves
+// its content to the storage and inserts its record, owned by the owner of the parent
+// directory, to the DB. The content must have the expected checksums.
+func (u *FManLocalUsecase) createFile(logger *log.Entry, user models.User, newFileUUID, filename, parentUUID string,
+	contentReader io.Reader, expected models.Checksums) error {
+	// Validate the name and the parent UUID.
+	parent, err := u.validateDestination(logger, user, filename, parentUUID)
+	if err != nil {
+		return err
+	}
+	blob, contentType, release, err := u.storeContent(logger, contentReader, parent.OwnerUUID, parentUUID,
+		filename, parent.Path, expected)
+	if err != nil {
+		return err
+	}
+	defer release()
 	// Insert new file record to the DB.
-	err = u.dbDirRepo.InsertDirRecord(newDirUUID, dirname, parentUUID)
+	stored, err := u.dbFileRepo.InsertFileRecord(newFileUUID, filename, parentUUID, parent.OwnerUUID, contentType, blob)
 	if err != nil {
-		logger.Errorf("[-INTERNAL-] InsertDirRecord failed with error %s", err.Error())
+		// If error presents while inserting a new record,
+		// remove the content from the storage.
+		u.removeContents(logger, []models.Blob{blob})
+		logger.Errorf("[-INTERNAL-] InsertFileRecord failed with error %s", err.Error())
+		return err
+	}
+	if stored.StorageKey != blob.StorageKey {
+		// The same content is already stored, so the new copy is not needed.
+		logger.Debugf("Content %s is already stored as %s", stored.Hash, stored.StorageKey)
+		u.removeContents(logger, []models.Blob{blob})
 	}
+	u.indexFileContent(logger, newFileUUID)
+	u.queueThumbnails(logger, newFileUUID, filename)
 	return nil
 }
 
-func (u *FManLocalUsecase) MoveFile() {
-	panic("not implemented")
+// storeContent saves content uploaded with a name into the directory with a path to the
+// storage under a new key, and the storage hashes it on the way. It returns the blob of the
+// content together with its MIME type, which is checked against the content type policy of
+// the directory before anything is saved.
+// The content is added to the files of an owner in a parent directory, and its size is
+// reserved from their quotas. A content of known size is reserved before anything is saved,
+// otherwise saving stops once the available bytes are exceeded, and the saved content is
+// reserved afterwards. The returned function releases the reservation once the content is
+// inserted. The content is removed again if saving fails, it does not fit into a quota or
+// its digests do not match the expected checksums.
+func (u *FManLocalUsecase) storeContent(logger *log.Entry, contentReader io.Reader, ownerUUID, parentUUID string,
+	filename, dirPath string, expected models.Checksums) (models.Blob, string, func(), error) {
+	available, err := u.availableQuota(logger, ownerUUID, parentUUID)
+	if err != nil {
+		return models.Blob{}, "", nil, err
+	}
+	size := contentSize(contentReader)
+	if available >= 0 && size > available {
+		return models.Blob{}, "", nil, quotaExceeded(logger, available)
+	}
+	release := func() {}
+	if size >= 0 {
+		if release, err = u.reserveQuota(logger, ownerUUID, parentUUID, size); err != nil {
+			return models.Blob{}, "", nil, err
+		}
+	}
+	contentType, contentReader, err := u.checkContentType(logger, filename, dirPath, contentReader)
+	if err != nil {
+		release()
+		return models.Blob{}, "", nil, err
+	}
+	var quota *quotaReader
+	if available >= 0 {
+		quota = &quotaReader{r: contentReader, available: available}
+		contentReader = quota
+	}
+	storageKey := u.uuidGen.NewUUID()
+	saved, err := u.fileOps.SaveFile(storageKey, contentReader, fileUtils.WithContentType(contentType))
+	if err != nil {
+		release()
+		if err := u.fileOps.RemoveFile(storageKey); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("[-INTERNAL-] RemoveFile of %s failed with error %s", storageKey, err.Error())
+		}
+		if quota != nil && quota.exceeded {
+			return models.Blob{}, "", nil, quotaExceeded(logger, available)
+		}
+		logger.Errorf("[-INTERNAL-] SaveFile failed with error %s", err.Error())
+		return models.Blob{}, "", nil, err
+	}
+	blob := models.Blob{
+		Hash:       saved.SHA256,
+		MD5:        saved.MD5,
+		StorageKey: storageKey,
+		RealPath:   saved.Location,
+		Size:       saved.Size,
+		StoredSize: saved.StoredSize,
+	}
+	if err := expected.Verify(blob); err != nil {
+		release()
+		u.removeContents(logger, []models.Blob{blob})
+		logger.Infof("[-USER-] %s", err.Error())
+		return models.Blob{}, "", nil, err
+	}
+	if size < 0 {
+		if release, err = u.reserveQuota(logger, ownerUUID, parentUUID, saved.Size); err != nil {
+			u.removeContents(logger, []models.Blob{blob})
+			return models.Blob{}, "", nil, err
+		}
+	}
+	return blob, contentType, release, nil
+}
+
+// maxFreeNameAttempts is the maximum number of numbered names tried by freeName.
+const maxFreeNameAttempts = 1000
+
+// freeName returns the first numbered variant of a name, e.g. "report (1).pdf" for a file
+// or "photos (1)" for a directory, which is not taken in a parent directory.
+func (u *FManLocalUsecase) freeName(name, parentUUID string, isDir bool) (string, error) {
+	for i := 1; i <= maxFreeNameAttempts; i++ {
+		candidate := numberedName(name, i, isDir)
+		isExist, err := u.dbValRepo.IsNameExist(candidate, parentUUID)
+		if err != nil {
+			return "", err
+		}
+		if !isExist {
+			return candidate, nil
+		}
+	}
+	return "", models.NewFManError(models.AlreadyExistErrorCode, fmt.Sprintf("no free name for %s in the desired location", name))
 }
 
-func (u *FManLocalUsecase) RemoveFile() {
-	panic("not implemented")
+// numberedName returns the numbered variant i of a name, e.g. "report (1).pdf" for a file or
+// "photos (1)" for a directory.
+func numberedName(name string, i int, isDir bool) string {
+	base, ext := name, ""
+	if !isDir {
+		// A leading dot (e.g. ".bashrc") does not start an extension.
+		if j := strings.LastIndexByte(name, '.'); j > 0 {
+			base, ext = name[:j], name[j:]
+		}
+	}
+	return fmt.Sprintf("%s (%d)%s", base, i, ext)
 }
 
-func (u *FManLocalUsecase) MoveFileToRecyleBin() {
-	panic("not implemented")
+// purgeTrash removes the recycle bin entries of an owner, which were deleted before a given
+// time, together with the content of their files. An empty owner removes the entries of all
+// owners, a zero time removes all entries. It returns the number of removed entries.
+func (u *FManLocalUsecase) purgeTrash(logger *log.Entry, ownerUUID string, deletedBefore time.Time) (int, error) {
+	entries, err := u.dbTrashRepo.ListTrashRecords(ownerUUID, deletedBefore)
+	if err != nil {
+		errUtils.LogErr(logger, "ListTrashRecords", err)
+		return 0, err
+	}
+	removed := 0
+	for _, entry := range entries {
+		blobs, err := u.dbTrashRepo.HardRemoveTrashRecord(entry.UUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			// The entry was removed together with an ancestor, or concurrently.
+			continue
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "HardRemoveTrashRecord", err)
+			return removed, err
+		}
+		removed++
+		u.removeContents(logger, blobs)
+	}
+	return removed, nil
+}
+
+// removeContents removes blobs, which are not referenced anymore, from the storage.
+// Failures are only logged, as the content cannot be reached anymore anyway.
+func (u *FManLocalUsecase) removeContents(logger *log.Entry, blobs []models.Blob) {
+	for _, blob := range blobs {
+		logger.Debugf("Removing content %s", blob.StorageKey)
+		if err := u.fileOps.RemoveFile(blob.StorageKey); err != nil {
+			logger.Errorf("[-INTERNAL-] RemoveFile of %s failed with error %s", blob.StorageKey, err.Error())
+		}
+	}
 }
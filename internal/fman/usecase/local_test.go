@@ -0,0 +1,270 @@
+package usecase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
+)
+
+// testEnv holds a usecase working on an in-memory repository and a local storage.
+type testEnv struct {
+	uc         *FManLocalUsecase
+	repo       *repo.FManMemoryRepo
+	storageDir string
+}
+
+func newTestEnv(t *testing.T, opts Options) *testEnv {
+	t.Helper()
+	r := repo.NewFManMemoryRepo()
+	storageDir := t.TempDir()
+	storage := fileUtils.CreateNewLocalFileOperator(storageDir)
+	uc := NewFManLocalUsecase(r, r, r, r, r, r, r, r, r, r, r, r, &uuidUtils.GoogleUUIDGenerator{}, storage, opts)
+	return &testEnv{uc: uc, repo: r, storageDir: storageDir}
+}
+
+// newUser inserts a user together with its root directory.
+func (e *testEnv) newUser(t *testing.T, username string) models.User {
+	t.Helper()
+	user := models.User{
+		UUID:        username + "-uuid",
+		Username:    username,
+		RootDirUUID: username + "-root",
+	}
+	if err := e.repo.InsertUserRecord(user); err != nil {
+		t.Fatalf("InsertUserRecord failed: %s", err)
+	}
+	return user
+}
+
+// mkdir creates a directory and returns its UUID.
+func (e *testEnv) mkdir(t *testing.T, user models.User, dirname, parentUUID string) string {
+	t.Helper()
+	if err := e.uc.CreateNewDirectory(user, dirname, parentUUID); err != nil {
+		t.Fatalf("CreateNewDirectory %s failed: %s", dirname, err)
+	}
+	dir, err := e.repo.ReadDirRecordByName(dirname, parentUUID)
+	if err != nil {
+		t.Fatalf("ReadDirRecordByName %s failed: %s", dirname, err)
+	}
+	return dir.UUID
+}
+
+// upload uploads a file and returns its UUID.
+func (e *testEnv) upload(t *testing.T, user models.User, filename, parentUUID, content string) string {
+	t.Helper()
+	if err := e.uc.UploadFile(user, filename, parentUUID, strings.NewReader(content), models.Checksums{}); err != nil {
+		t.Fatalf("UploadFile %s failed: %s", filename, err)
+	}
+	file, err := e.repo.ReadFileRecordByName(filename, parentUUID)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName %s failed: %s", filename, err)
+	}
+	return file.UUID
+}
+
+// dirUUID returns the UUID of the directory with a name in a parent directory.
+func (e *testEnv) dirUUID(t *testing.T, dirname, parentUUID string) string {
+	t.Helper()
+	dir, err := e.repo.ReadDirRecordByName(dirname, parentUUID)
+	if err != nil {
+		t.Fatalf("ReadDirRecordByName %s failed: %s", dirname, err)
+	}
+	return dir.UUID
+}
+
+func (e *testEnv) filePath(t *testing.T, fileUUID string) string {
+	t.Helper()
+	file, err := e.repo.ReadFileRecord(fileUUID)
+	if err != nil {
+		t.Fatalf("ReadFileRecord failed: %s", err)
+	}
+	return file.Path
+}
+
+func (e *testEnv) dirPath(t *testing.T, dirUUID string) string {
+	t.Helper()
+	dir, err := e.repo.ReadDirRecord(dirUUID)
+	if err != nil {
+		t.Fatalf("ReadDirRecord failed: %s", err)
+	}
+	return dir.Path
+}
+
+// countStoredFiles returns the number of regular files in the storage.
+func (e *testEnv) countStoredFiles(t *testing.T) int {
+	t.Helper()
+	count := 0
+	err := filepath.Walk(e.storageDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func (e *testEnv) download(t *testing.T, user models.User, fileUUID string) string {
+	t.Helper()
+	_, content, err := e.uc.DownloadFile(user, fileUUID)
+	if err != nil {
+		t.Fatalf("DownloadFile failed: %s", err)
+	}
+	defer content.Close()
+	b, err := ioutil.ReadAll(content)
+	if err != nil {
+		t.Fatalf("reading the content failed: %s", err)
+	}
+	return string(b)
+}
+
+func TestMoveFileRewritesPath(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	docs := e.mkdir(t, alice, "docs", alice.RootDirUUID)
+	archive := e.mkdir(t, alice, "archive", alice.RootDirUUID)
+	file := e.upload(t, alice, "a.txt", docs, "a")
+
+	if err := e.uc.MoveFile(alice, file, "b.txt", ""); err != nil {
+		t.Fatalf("MoveFile failed: %s", err)
+	}
+	if got := e.filePath(t, file); got != "/docs/b.txt" {
+		t.Errorf("renamed file has path %s, want /docs/b.txt", got)
+	}
+	if err := e.uc.MoveFile(alice, file, "", archive); err != nil {
+		t.Fatalf("MoveFile failed: %s", err)
+	}
+	if got := e.filePath(t, file); got != "/archive/b.txt" {
+		t.Errorf("moved file has path %s, want /archive/b.txt", got)
+	}
+}
+
+func TestMoveDirectoryRewritesSubtreePaths(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	a := e.mkdir(t, alice, "a", alice.RootDirUUID)
+	b := e.mkdir(t, alice, "b", a)
+	d := e.mkdir(t, alice, "d", b)
+	c := e.upload(t, alice, "c.txt", b, "c")
+	f := e.upload(t, alice, "f.txt", d, "f")
+	// A sibling whose name shares the prefix of the moved directory keeps its paths.
+	ab := e.mkdir(t, alice, "ab", alice.RootDirUUID)
+	g := e.upload(t, alice, "g.txt", ab, "g")
+	x := e.mkdir(t, alice, "x", alice.RootDirUUID)
+
+	if err := e.uc.MoveDirectory(alice, a, "a2", x); err != nil {
+		t.Fatalf("MoveDirectory failed: %s", err)
+	}
+	for _, tc := range []struct {
+		path string
+		want string
+	}{
+		{e.dirPath(t, a), "/x/a2"},
+		{e.dirPath(t, b), "/x/a2/b"},
+		{e.dirPath(t, d), "/x/a2/b/d"},
+		{e.filePath(t, c), "/x/a2/b/c.txt"},
+		{e.filePath(t, f), "/x/a2/b/d/f.txt"},
+		{e.dirPath(t, ab), "/ab"},
+		{e.filePath(t, g), "/ab/g.txt"},
+	} {
+		if tc.path != tc.want {
+			t.Errorf("got path %s, want %s", tc.path, tc.want)
+		}
+	}
+
+	// Moving a directory into its own subtree or moving a root directory changes no path.
+	if err := e.uc.MoveDirectory(alice, x, "", d); !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+		t.Errorf("MoveDirectory into the own subtree returned %v, want an invalid argument error", err)
+	}
+	if err := e.uc.MoveDirectory(alice, alice.RootDirUUID, "", x); !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+		t.Errorf("MoveDirectory of the root directory returned %v, want an invalid argument error", err)
+	}
+	if got := e.filePath(t, f); got != "/x/a2/b/d/f.txt" {
+		t.Errorf("file has path %s after the failed moves, want /x/a2/b/d/f.txt", got)
+	}
+}
+
+func TestMoveDirectoryOfOtherOwner(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	bob := e.newUser(t, "bob")
+	a := e.mkdir(t, alice, "a", alice.RootDirUUID)
+
+	if err := e.uc.MoveDirectory(bob, a, "", bob.RootDirUUID); !models.IsFManErrorCode(err, models.ForbiddenErrorCode) {
+		t.Errorf("MoveDirectory by another user returned %v, want a forbidden error", err)
+	}
+	if got := e.dirPath(t, a); got != "/a" {
+		t.Errorf("directory has path %s, want /a", got)
+	}
+}
+
+func TestRemovingFilesReleasesSharedContent(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	docs := e.mkdir(t, alice, "docs", alice.RootDirUUID)
+	a := e.upload(t, alice, "a.txt", alice.RootDirUUID, "same")
+	b := e.upload(t, alice, "b.txt", alice.RootDirUUID, "same")
+	if err := e.uc.CopyFile(alice, a, docs); err != nil {
+		t.Fatalf("CopyFile failed: %s", err)
+	}
+	copied, err := e.repo.ReadFileRecordByName("a.txt", docs)
+	if err != nil {
+		t.Fatalf("ReadFileRecordByName failed: %s", err)
+	}
+	if got := e.countStoredFiles(t); got != 1 {
+		t.Fatalf("%d contents are stored for three files with the same content, want 1", got)
+	}
+
+	// The content is kept as long as a file, the ones in the recycle bin included, refers to it.
+	if err := e.uc.RemoveFile(alice, a); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	if err := e.uc.MoveFileToRecyleBin(alice, b); err != nil {
+		t.Fatalf("MoveFileToRecyleBin failed: %s", err)
+	}
+	if got := e.download(t, alice, copied.UUID); got != "same" {
+		t.Errorf("the copy has content %q, want %q", got, "same")
+	}
+	if err := e.uc.RemoveDirectory(alice, docs, nil); err != nil {
+		t.Fatalf("RemoveDirectory failed: %s", err)
+	}
+	if got := e.countStoredFiles(t); got != 1 {
+		t.Errorf("%d contents are stored while a file in the recycle bin refers to it, want 1", got)
+	}
+	if err := e.uc.EmptyRecycleBin(alice); err != nil {
+		t.Fatalf("EmptyRecycleBin failed: %s", err)
+	}
+	if got := e.countStoredFiles(t); got != 0 {
+		t.Errorf("%d contents are stored after all files were removed, want 0", got)
+	}
+}
+
+func TestReplacedVersionsReleaseContent(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	a := e.upload(t, alice, "a.txt", alice.RootDirUUID, "old")
+	b := e.upload(t, alice, "b.txt", alice.RootDirUUID, "new")
+
+	// Without versioning, the old content of a is released, while the new one is shared with b.
+	if _, err := e.uc.UpdateFileContent(alice, a, strings.NewReader("new"), models.Checksums{}); err != nil {
+		t.Fatalf("UpdateFileContent failed: %s", err)
+	}
+	if got := e.countStoredFiles(t); got != 1 {
+		t.Errorf("%d contents are stored, want 1", got)
+	}
+	if err := e.uc.RemoveFile(alice, b); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	if got := e.download(t, alice, a); got != "new" {
+		t.Errorf("a has content %q, want %q", got, "new")
+	}
+}
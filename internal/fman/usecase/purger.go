@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/nvthongswansea/xtreme/internal/fman"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultPurgeInterval is how often the purgers look for expired entries if no interval
+// is given.
+const DefaultPurgeInterval = time.Hour
+
+// RecycleBinPurger removes entries from the recycle bin permanently once they are older
+// than a retention period.
+type RecycleBinPurger struct {
+	uc        fman.FmanUsecase
+	retention time.Duration
+	interval  time.Duration
+}
+
+// NewRecycleBinPurger creates a new RecycleBinPurger. An interval <= 0 is replaced by
+// DefaultPurgeInterval.
+func NewRecycleBinPurger(uc fman.FmanUsecase, retention, interval time.Duration) *RecycleBinPurger {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return &RecycleBinPurger{
+		uc,
+		retention,
+		interval,
+	}
+}
+
+// Run purges expired entries right away and then once every interval, until ctx is done.
+func (p *RecycleBinPurger) Run(ctx context.Context) {
+	runEvery(ctx, p.interval, p.purge)
+}
+
+// purge removes the entries which were deleted more than the retention period ago.
+func (p *RecycleBinPurger) purge() {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-purger",
+		"Operation": "purge",
+		"retention": p.retention,
+	})
+	removed, err := p.uc.PurgeRecycleBin(time.Now().Add(-p.retention))
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] PurgeRecycleBin failed with error %s", err.Error())
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Purged %d expired entries from recycle bin", removed)
+	}
+}
+
+// UploadPurger removes resumable uploads together with their partial content once they
+// expired.
+type UploadPurger struct {
+	uc       fman.FmanUsecase
+	interval time.Duration
+}
+
+// NewUploadPurger creates a new UploadPurger. An interval <= 0 is replaced by
+// DefaultPurgeInterval.
+func NewUploadPurger(uc fman.FmanUsecase, interval time.Duration) *UploadPurger {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return &UploadPurger{
+		uc,
+		interval,
+	}
+}
+
+// Run purges expired uploads right away and then once every interval, until ctx is done.
+func (p *UploadPurger) Run(ctx context.Context) {
+	runEvery(ctx, p.interval, p.purge)
+}
+
+// purge removes the uploads which expired by now.
+func (p *UploadPurger) purge() {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-purger",
+		"Operation": "purge",
+	})
+	removed, err := p.uc.PurgeExpiredUploads(time.Now())
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] PurgeExpiredUploads failed with error %s", err.Error())
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Purged %d expired uploads", removed)
+	}
+}
+
+// VersionPurger removes old file versions together with their content once they are not
+// retained by the version policy anymore.
+type VersionPurger struct {
+	uc       fman.FmanUsecase
+	interval time.Duration
+}
+
+// NewVersionPurger creates a new VersionPurger. An interval <= 0 is replaced by
+// DefaultPurgeInterval.
+func NewVersionPurger(uc fman.FmanUsecase, interval time.Duration) *VersionPurger {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	return &VersionPurger{
+		uc,
+		interval,
+	}
+}
+
+// Run purges expired versions right away and then once every interval, until ctx is done.
+func (p *VersionPurger) Run(ctx context.Context) {
+	runEvery(ctx, p.interval, p.purge)
+}
+
+// purge removes the versions which are not retained by now.
+func (p *VersionPurger) purge() {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-purger",
+		"Operation": "purge",
+	})
+	removed, err := p.uc.PurgeFileVersions(time.Now())
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] PurgeFileVersions failed with error %s", err.Error())
+		return
+	}
+	if removed > 0 {
+		logger.Infof("Purged %d old file versions", removed)
+	}
+}
+
+// runEvery calls fn right away and then once every interval, until ctx is done.
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package usecase
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+func (u *FManLocalUsecase) ReadUsage(user models.User, userUUID string) (models.Usage, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ReadUsage",
+		"userUUID":  userUUID,
+	})
+	logger.Debug("Start reading usage")
+	defer logger.Debug("Finish reading usage")
+	if userUUID == "" {
+		userUUID = user.UUID
+	}
+	if err := authorize(logger, user, userUUID); err != nil {
+		return models.Usage{}, err
+	}
+	quota, err := u.userQuota(logger, userUUID)
+	if err != nil {
+		return models.Usage{}, err
+	}
+	dirQuotas, err := u.dbQuotaRepo.ListOwnerDirQuotaRecords(userUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListOwnerDirQuotaRecords", err)
+		return models.Usage{}, err
+	}
+	if dirQuotas == nil {
+		dirQuotas = []models.DirQuota{}
+	}
+	return models.Usage{
+		UserUUID:    userUUID,
+		Quota:       quota,
+		Directories: dirQuotas,
+	}, nil
+}
+
+func (u *FManLocalUsecase) SetUserQuota(user models.User, userUUID string, limit int64) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "SetUserQuota",
+		"userUUID":  userUUID,
+		"limit":     limit,
+	})
+	logger.Debug("Start setting user quota")
+	defer logger.Debug("Finish setting user quota")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return err
+	}
+	if limit < 0 {
+		logger.Info("[-USER-] quota must not be negative")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "quota must not be negative")
+	}
+	if err := u.dbQuotaRepo.UpdateUserQuotaLimit(userUUID, limit); err != nil {
+		errUtils.LogErr(logger, "UpdateUserQuotaLimit", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) SetDirectoryQuota(user models.User, dirUUID string, limit int64) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "SetDirectoryQuota",
+		"dirUUID":   dirUUID,
+		"limit":     limit,
+	})
+	logger.Debug("Start setting directory quota")
+	defer logger.Debug("Finish setting directory quota")
+	if limit < 0 {
+		logger.Info("[-USER-] quota must not be negative")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "quota must not be negative")
+	}
+	if _, err := u.readDir(logger, user, dirUUID, models.PermManage); err != nil {
+		return err
+	}
+	if err := u.dbQuotaRepo.UpdateDirQuotaLimit(dirUUID, limit); err != nil {
+		errUtils.LogErr(logger, "UpdateDirQuotaLimit", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RecomputeUsage() ([]models.UsageCorrection, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RecomputeUsage",
+	})
+	logger.Debug("Start recomputing usage")
+	defer logger.Debug("Finish recomputing usage")
+	corrections, err := u.dbQuotaRepo.RecomputeUsageRecords()
+	if err != nil {
+		errUtils.LogErr(logger, "RecomputeUsageRecords", err)
+		return nil, err
+	}
+	for _, c := range corrections {
+		logger.Warnf("Corrected usage of user %s from %d to %d bytes", c.UserUUID, c.OldUsedBytes, c.NewUsedBytes)
+	}
+	return corrections, nil
+}
+
+func (u *FManLocalUsecase) ReadStorageStats(user models.User) (models.StorageStats, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ReadStorageStats",
+	})
+	logger.Debug("Start reading storage stats")
+	defer logger.Debug("Finish reading storage stats")
+	if err := authorizeAdmin(logger, user); err != nil {
+		return models.StorageStats{}, err
+	}
+	stats, err := u.dbQuotaRepo.ReadStorageStatsRecord()
+	if err != nil {
+		errUtils.LogErr(logger, "ReadStorageStatsRecord", err)
+		return models.StorageStats{}, err
+	}
+	return stats, nil
+}
+
+// userQuota returns the quota of a user, where a zero limit is replaced by the default quota.
+func (u *FManLocalUsecase) userQuota(logger *log.Entry, userUUID string) (models.Quota, error) {
+	quota, err := u.dbQuotaRepo.ReadUserQuotaRecord(userUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadUserQuotaRecord", err)
+		return models.Quota{}, err
+	}
+	if quota.LimitBytes == 0 {
+		quota.LimitBytes = u.opts.DefaultQuota
+	}
+	return quota, nil
+}
+
+// availableQuota returns the number of bytes which can still be added to the files of an
+// owner in a parent directory, limited by the quota of the owner and the quotas of the
+// parent and its ancestors. It is -1 if there is no limit.
+func (u *FManLocalUsecase) availableQuota(logger *log.Entry, ownerUUID, parentUUID string) (int64, error) {
+	available := int64(-1)
+	quota, err := u.userQuota(logger, ownerUUID)
+	switch {
+	case models.IsFManErrorCode(err, models.NotFoundErrorCode):
+		// Records created before users existed are not counted.
+	case err != nil:
+		return 0, err
+	default:
+		available = quota.Available()
+	}
+	dirQuotas, err := u.dbQuotaRepo.ListAncestorDirQuotaRecords(parentUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListAncestorDirQuotaRecords", err)
+		return 0, err
+	}
+	for _, dirQuota := range dirQuotas {
+		if n := dirQuota.Available(); available < 0 || n < available {
+			available = n
+		}
+	}
+	return available, nil
+}
+
+// checkQuota checks if a content of a given size can be added to the files of an owner in
+// a parent directory without exceeding a quota.
+func (u *FManLocalUsecase) checkQuota(logger *log.Entry, ownerUUID, parentUUID string, size int64) error {
+	available, err := u.availableQuota(logger, ownerUUID, parentUUID)
+	if err != nil {
+		return err
+	}
+	if available >= 0 && size > available {
+		return quotaExceeded(logger, available)
+	}
+	return nil
+}
+
+// quotaReservationTTL is how long a reservation of a quota holds its bytes, if it is not
+// released before, e.g. because the server crashed while the files were added.
+const quotaReservationTTL = time.Hour
+
+// reserveQuota reserves a number of bytes of the quotas of an owner and of a parent
+// directory and its ancestors, so that concurrent operations cannot exceed a quota
+// together. The returned function releases the reservation, which must be called once the
+// files taking up the bytes are inserted, or could not be added.
+func (u *FManLocalUsecase) reserveQuota(logger *log.Entry, ownerUUID, parentUUID string, size int64) (func(), error) {
+	if size <= 0 {
+		return func() {}, nil
+	}
+	reservation := models.QuotaReservation{
+		UUID:      u.uuidGen.NewUUID(),
+		OwnerUUID: ownerUUID,
+		DirUUID:   parentUUID,
+		Size:      size,
+		ExpiresAt: time.Now().UTC().Add(quotaReservationTTL),
+	}
+	if err := u.dbQuotaRepo.ReserveQuotaRecord(reservation, u.opts.DefaultQuota); err != nil {
+		errUtils.LogErr(logger, "ReserveQuotaRecord", err)
+		return nil, err
+	}
+	return func() {
+		if err := u.dbQuotaRepo.RemoveQuotaReservationRecord(reservation.UUID); err != nil {
+			errUtils.LogErr(logger, "RemoveQuotaReservationRecord", err)
+		}
+	}, nil
+}
+
+// quotaExceeded logs and returns the error of a content, which does not fit into the
+// available bytes of a quota.
+func quotaExceeded(logger *log.Entry, available int64) error {
+	logger.Infof("[-USER-] storage quota exceeded, %d bytes available", available)
+	return models.NewFManError(models.QuotaExceededErrorCode, fmt.Sprintf("storage quota exceeded, %d bytes available", available))
+}
+
+// contentSize returns the number of bytes left in a content without reading it, or -1 if
+// it is unknown.
+func contentSize(contentReader io.Reader) int64 {
+	seeker, ok := contentReader.(io.Seeker)
+	if !ok {
+		return -1
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return -1
+	}
+	if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+		return -1
+	}
+	return end - offset
+}
+
+// quotaReader reads a content until more than the available bytes of a quota are read,
+// and fails then.
+type quotaReader struct {
+	r         io.Reader
+	available int64
+	exceeded  bool
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > q.available+1 {
+		p = p[:q.available+1]
+	}
+	n, err := q.r.Read(p)
+	if int64(n) > q.available {
+		q.exceeded = true
+		return 0, models.NewFManError(models.QuotaExceededErrorCode, "storage quota exceeded")
+	}
+	q.available -= int64(n)
+	return n, err
+}
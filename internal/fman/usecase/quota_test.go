@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// unsizedReader hides the size of a content, as a request body without a length does.
+type unsizedReader struct {
+	io.Reader
+}
+
+func TestStoreContentReservesQuota(t *testing.T) {
+	e := newTestEnv(t, Options{DefaultQuota: 10})
+	alice := e.newUser(t, "alice")
+	logger := log.WithField("test", t.Name())
+
+	_, _, release, err := e.uc.storeContent(logger, strings.NewReader("123456"), alice.UUID, alice.RootDirUUID,
+		"a.txt", "/", models.Checksums{})
+	if err != nil {
+		t.Fatalf("storeContent failed: %s", err)
+	}
+	// The bytes of the first content are reserved until it is released, although no file
+	// counts them as used yet.
+	for _, content := range []io.Reader{strings.NewReader("123456"), unsizedReader{strings.NewReader("123456")}} {
+		_, _, _, err := e.uc.storeContent(logger, content, alice.UUID, alice.RootDirUUID, "b.txt", "/", models.Checksums{})
+		if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+			t.Errorf("storeContent with a reservation held returned %v, want a quota exceeded error", err)
+		}
+	}
+	if got := e.countStoredFiles(t); got != 1 {
+		t.Errorf("%d contents are stored, want only the first one", got)
+	}
+	release()
+	_, _, release, err = e.uc.storeContent(logger, unsizedReader{strings.NewReader("123456")}, alice.UUID,
+		alice.RootDirUUID, "b.txt", "/", models.Checksums{})
+	if err != nil {
+		t.Fatalf("storeContent after the release failed: %s", err)
+	}
+	release()
+}
+
+func TestUploadsReleaseReservations(t *testing.T) {
+	e := newTestEnv(t, Options{DefaultQuota: 10})
+	alice := e.newUser(t, "alice")
+
+	// Once the files are inserted, their bytes are counted as used instead of reserved, so
+	// the quota is filled exactly.
+	e.upload(t, alice, "a.txt", alice.RootDirUUID, "123456")
+	b := e.upload(t, alice, "b.txt", alice.RootDirUUID, "7890")
+	err := e.uc.UploadFile(alice, "c.txt", alice.RootDirUUID, strings.NewReader("x"), models.Checksums{})
+	if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+		t.Errorf("UploadFile into a full quota returned %v, want a quota exceeded error", err)
+	}
+	usage, err := e.uc.ReadUsage(alice, "")
+	if err != nil {
+		t.Fatalf("ReadUsage failed: %s", err)
+	}
+	if usage.Quota.UsedBytes != 10 {
+		t.Errorf("%d bytes are used, want 10", usage.Quota.UsedBytes)
+	}
+
+	// A failed upload releases its reservation as well.
+	if err := e.uc.RemoveFile(alice, b); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	err = e.uc.UploadFile(alice, "c.txt", alice.RootDirUUID, strings.NewReader("1234"), models.Checksums{MD5: "0"})
+	if !models.IsFManErrorCode(err, models.InvalidArgumentErrorCode) {
+		t.Errorf("UploadFile with a wrong checksum returned %v, want an invalid argument error", err)
+	}
+	e.upload(t, alice, "c.txt", alice.RootDirUUID, "1234")
+}
+
+func TestDirectoryQuotaCountsReservations(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	docs := e.mkdir(t, alice, "docs", alice.RootDirUUID)
+	sub := e.mkdir(t, alice, "sub", docs)
+	if err := e.repo.UpdateDirQuotaLimit(docs, 5); err != nil {
+		t.Fatalf("UpdateDirQuotaLimit failed: %s", err)
+	}
+	logger := log.WithField("test", t.Name())
+
+	// A reservation in a subdirectory counts towards the quota of its ancestor.
+	_, _, release, err := e.uc.storeContent(logger, strings.NewReader("123"), alice.UUID, sub, "a.txt", "/docs/sub",
+		models.Checksums{})
+	if err != nil {
+		t.Fatalf("storeContent failed: %s", err)
+	}
+	defer release()
+	_, _, _, err = e.uc.storeContent(logger, strings.NewReader("123"), alice.UUID, docs, "b.txt", "/docs",
+		models.Checksums{})
+	if !models.IsFManErrorCode(err, models.QuotaExceededErrorCode) {
+		t.Errorf("storeContent beyond the directory quota returned %v, want a quota exceeded error", err)
+	}
+	// Outside of the directory, only the quota of the user applies, which is unlimited.
+	_, _, releaseRoot, err := e.uc.storeContent(logger, strings.NewReader("123"), alice.UUID, alice.RootDirUUID, "c.txt",
+		"/", models.Checksums{})
+	if err != nil {
+		t.Fatalf("storeContent outside of the directory failed: %s", err)
+	}
+	releaseRoot()
+}
@@ -0,0 +1,265 @@
+package usecase
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// textExtensions holds the extensions of text-like files, whose content is indexed for the
+// search.
+var textExtensions = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".rst": true, ".tex": true, ".log": true,
+	".csv": true, ".tsv": true, ".json": true, ".xml": true, ".yaml": true, ".yml": true,
+	".toml": true, ".ini": true, ".cfg": true, ".conf": true, ".env": true, ".sql": true,
+	".html": true, ".htm": true, ".css": true, ".scss": true, ".svg": true,
+	".go": true, ".py": true, ".rb": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true,
+	".java": true, ".kt": true, ".scala": true, ".c": true, ".h": true, ".cc": true, ".cpp": true,
+	".hpp": true, ".cs": true, ".rs": true, ".swift": true, ".php": true, ".pl": true, ".lua": true,
+	".r": true, ".sh": true, ".bash": true, ".ps1": true, ".bat": true, ".proto": true,
+	".graphql": true, ".vue": true, ".dart": true,
+}
+
+// textFilenames holds the names of text-like files without a text extension.
+var textFilenames = map[string]bool{
+	"readme": true, "license": true, "makefile": true, "dockerfile": true, "changelog": true,
+}
+
+// isTextLike checks if a file is text-like by its name.
+func isTextLike(filename string) bool {
+	name := strings.ToLower(filename)
+	return textExtensions[path.Ext(name)] || textFilenames[name]
+}
+
+func (u *FManLocalUsecase) Search(user models.User, query string, opts models.SearchOptions) ([]models.SearchResult, int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "Search",
+		"query":     query,
+	})
+	logger.Debug("Start searching")
+	defer logger.Debug("Finish searching")
+	if err := authenticated(logger, user); err != nil {
+		return nil, 0, err
+	}
+	terms := models.ParseSearchQuery(query)
+	opts.Tag = normalizeTag(opts.Tag)
+	// Without terms, all files/dirs carrying the tag are found.
+	if len(terms) == 0 && opts.Tag == "" {
+		logger.Info("[-USER-] search query is empty")
+		return nil, 0, models.NewFManError(models.InvalidArgumentErrorCode, "search query is empty")
+	}
+	if err := validateSearchOptions(logger, opts); err != nil {
+		return nil, 0, err
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = models.DefaultSearchLimit
+	}
+	if opts.Limit > models.MaxSearchLimit {
+		opts.Limit = models.MaxSearchLimit
+	}
+	if opts.UnderDirUUID != "" {
+		if _, err := u.readDir(logger, user, opts.UnderDirUUID, models.PermRead); err != nil {
+			return nil, 0, err
+		}
+	}
+	searchQuery := models.SearchQuery{SearchOptions: opts, Terms: terms}
+	if !user.IsAdmin {
+		scopeUUIDs, err := u.searchScope(logger, user)
+		if err != nil {
+			return nil, 0, err
+		}
+		searchQuery.ScopeUUIDs = scopeUUIDs
+	}
+	// Hits which are not readable by the user are skipped, so more hits are fetched until
+	// the page is full. One hit more than needed tells if there is a next page.
+	results := []models.SearchResult{}
+	for {
+		searchQuery.Limit = opts.Limit + 1
+		hits, err := u.dbSearchRepo.SearchRecords(searchQuery)
+		if err != nil {
+			errUtils.LogErr(logger, "SearchRecords", err)
+			return nil, 0, err
+		}
+		for i, hit := range hits {
+			if len(results) == opts.Limit {
+				return results, searchQuery.Offset + i, nil
+			}
+			result, ok, err := u.readSearchResult(logger, user, hit)
+			if err != nil {
+				return nil, 0, err
+			}
+			if ok {
+				results = append(results, result)
+			}
+		}
+		if len(hits) < searchQuery.Limit {
+			return results, 0, nil
+		}
+		searchQuery.Offset += len(hits)
+	}
+}
+
+func (u *FManLocalUsecase) ReindexSearch() (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ReindexSearch",
+	})
+	logger.Debug("Start reindexing search")
+	defer logger.Debug("Finish reindexing search")
+	files, err := u.dbSearchRepo.ListUnindexedFileRecords()
+	if err != nil {
+		errUtils.LogErr(logger, "ListUnindexedFileRecords", err)
+		return 0, err
+	}
+	indexed := 0
+	for _, file := range files {
+		ok, err := u.indexContent(logger.WithField("fileUUID", file.UUID), file)
+		if err != nil {
+			return indexed, err
+		}
+		if ok {
+			indexed++
+		}
+	}
+	return indexed, nil
+}
+
+// validateSearchOptions checks the filters of a search.
+func validateSearchOptions(logger *log.Entry, opts models.SearchOptions) error {
+	var msg string
+	switch {
+	case opts.Type != "" && opts.Type != models.EntryTypeFile && opts.Type != models.EntryTypeDir:
+		msg = fmt.Sprintf("unknown type %s", opts.Type)
+	case opts.MinSize < 0 || opts.MaxSize < 0:
+		msg = "sizes must not be negative"
+	case opts.MaxSize > 0 && opts.MinSize > opts.MaxSize:
+		msg = "minimum size must not exceed maximum size"
+	case !opts.UpdatedAfter.IsZero() && !opts.UpdatedBefore.IsZero() && !opts.UpdatedAfter.Before(opts.UpdatedBefore):
+		msg = "updated_after must be before updated_before"
+	case opts.Offset < 0:
+		msg = "offset must not be negative"
+	default:
+		return nil
+	}
+	logger.Infof("[-USER-] %s", msg)
+	return models.NewFManError(models.InvalidArgumentErrorCode, msg)
+}
+
+// searchScope returns the files/dirs, in whose subtrees a user can find files/dirs: the
+// root directory of the user, and the files/dirs shared with the user by access control
+// entries granting permissions.
+func (u *FManLocalUsecase) searchScope(logger *log.Entry, user models.User) ([]string, error) {
+	principals, err := u.principals(logger, user)
+	if err != nil {
+		return nil, err
+	}
+	principalUUIDs := []string{user.UUID}
+	for groupUUID := range principals.groupUUIDs {
+		principalUUIDs = append(principalUUIDs, groupUUID)
+	}
+	entries, err := u.dbACLRepo.ListPrincipalACLRecords(principalUUIDs)
+	if err != nil {
+		errUtils.LogErr(logger, "ListPrincipalACLRecords", err)
+		return nil, err
+	}
+	scopeUUIDs := []string{user.RootDirUUID}
+	seen := map[string]bool{user.RootDirUUID: true}
+	for _, entry := range entries {
+		if entry.Permissions != 0 && !seen[entry.ItemUUID] {
+			seen[entry.ItemUUID] = true
+			scopeUUIDs = append(scopeUUIDs, entry.ItemUUID)
+		}
+	}
+	return scopeUUIDs, nil
+}
+
+// readSearchResult reads the file/dir of a search hit. ok is false if the file/dir was
+// removed in the meantime, or the user cannot read it, e.g. because a deeper access
+// control entry revokes the permissions shared above.
+func (u *FManLocalUsecase) readSearchResult(logger *log.Entry, user models.User, hit models.SearchHit) (models.SearchResult, bool, error) {
+	result := models.SearchResult{ItemType: hit.ItemType, Score: hit.Score}
+	var perms models.Permissions
+	if hit.ItemType == models.EntryTypeFile {
+		file, err := u.dbFileRepo.ReadFileRecord(hit.UUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			return models.SearchResult{}, false, nil
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadFileRecord", err)
+			return models.SearchResult{}, false, err
+		}
+		perms, err = u.permissions(logger, user, file.OwnerUUID, file.UUID, file.ParentUUID)
+		if err != nil {
+			return models.SearchResult{}, false, err
+		}
+		result.File = &file
+	} else {
+		dir, err := u.dbDirRepo.ReadDirRecord(hit.UUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			return models.SearchResult{}, false, nil
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadDirRecord", err)
+			return models.SearchResult{}, false, err
+		}
+		perms, err = u.permissions(logger, user, dir.OwnerUUID, "", dir.UUID)
+		if err != nil {
+			return models.SearchResult{}, false, err
+		}
+		result.Directory = &dir
+	}
+	return result, perms.Has(models.PermRead), nil
+}
+
+// indexContent indexes the text of a file's content for the search, if the file is
+// text-like and its content is not indexed yet. At most models.MaxIndexedContentSize bytes
+// are indexed. ok is true if the content was read and indexed.
+func (u *FManLocalUsecase) indexContent(logger *log.Entry, file models.File) (ok bool, err error) {
+	if !isTextLike(file.Filename) {
+		return false, nil
+	}
+	indexed, err := u.dbSearchRepo.IsContentIndexed(file.ContentHash)
+	if err != nil {
+		errUtils.LogErr(logger, "IsContentIndexed", err)
+		return false, err
+	}
+	if indexed {
+		return false, nil
+	}
+	content, err := u.fileOps.ReadFile(file.StorageKey)
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] ReadFile of %s failed with error %s", file.StorageKey, err.Error())
+		return false, err
+	}
+	defer content.Close()
+	text, err := io.ReadAll(io.LimitReader(content, models.MaxIndexedContentSize))
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] Reading content %s failed with error %s", file.StorageKey, err.Error())
+		return false, err
+	}
+	if err := u.dbSearchRepo.IndexContentRecord(file.ContentHash, strings.ToValidUTF8(string(text), " ")); err != nil {
+		errUtils.LogErr(logger, "IndexContentRecord", err)
+		return false, err
+	}
+	return true, nil
+}
+
+// indexFileContent indexes the content of a file like indexContent after the file was
+// created, changed or renamed. Failures are only logged, as the change is done anyway and
+// the content is indexed again by ReindexSearch.
+func (u *FManLocalUsecase) indexFileContent(logger *log.Entry, fileUUID string) {
+	file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+	if err != nil {
+		if !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			errUtils.LogErr(logger, "ReadFileRecord", err)
+		}
+		return
+	}
+	u.indexContent(logger, file)
+}
@@ -0,0 +1,412 @@
+package usecase
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// shareTokenBytes is the number of random bytes of a share link token.
+	shareTokenBytes = 32
+
+	// maxSharePasswordLength is the maximum length of a share link password in bytes, as
+	// bcrypt ignores everything after it.
+	maxSharePasswordLength = 72
+)
+
+func (u *FManLocalUsecase) CreateShareLink(user models.User, opts models.ShareLinkOptions) (models.ShareLink, error) {
+	// Generate a new UUID.
+	newLinkUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "CreateShareLink",
+		"linkUUID":  newLinkUUID,
+		"itemUUID":  opts.ItemUUID,
+		"itemType":  opts.ItemType,
+	})
+	logger.Debug("Start creating share link")
+	defer logger.Debug("Finish creating share link")
+	if opts.Mode == "" {
+		opts.Mode = models.ShareModeRead
+	}
+	if err := validateShareLinkOptions(opts); err != nil {
+		errUtils.LogErr(logger, "validateShareLinkOptions", err)
+		return models.ShareLink{}, err
+	}
+	// Only files/dirs, on which the user has the share permission, can be shared.
+	switch opts.ItemType {
+	case models.EntryTypeFile:
+		if _, err := u.readFile(logger, user, opts.ItemUUID, models.PermShare); err != nil {
+			return models.ShareLink{}, err
+		}
+	case models.EntryTypeDir:
+		if _, err := u.readDir(logger, user, opts.ItemUUID, models.PermShare); err != nil {
+			return models.ShareLink{}, err
+		}
+	}
+	// Downloads, listings and uploads through the link are made by the user, who must be able
+	// to read or to upload as well.
+	creator := shareLinkUser(user.UUID)
+	switch {
+	case opts.Mode == models.ShareModeUpload:
+		if _, err := u.readDir(logger, creator, opts.ItemUUID, models.PermWrite); err != nil {
+			return models.ShareLink{}, err
+		}
+	case opts.ItemType == models.EntryTypeFile:
+		if _, err := u.readFile(logger, creator, opts.ItemUUID, models.PermRead); err != nil {
+			return models.ShareLink{}, err
+		}
+	default:
+		if _, err := u.readDir(logger, creator, opts.ItemUUID, models.PermRead); err != nil {
+			return models.ShareLink{}, err
+		}
+	}
+	token, err := newShareToken()
+	if err != nil {
+		errUtils.LogErr(logger, "newShareToken", err)
+		return models.ShareLink{}, err
+	}
+	link := models.ShareLink{
+		UUID:         newLinkUUID,
+		Token:        token,
+		ItemUUID:     opts.ItemUUID,
+		ItemType:     opts.ItemType,
+		OwnerUUID:    user.UUID,
+		Mode:         opts.Mode,
+		MaxDownloads: opts.MaxDownloads,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if !opts.ExpiresAt.IsZero() {
+		expiresAt := opts.ExpiresAt.UTC()
+		link.ExpiresAt = &expiresAt
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			errUtils.LogErr(logger, "GenerateFromPassword", err)
+			return models.ShareLink{}, err
+		}
+		link.PasswordHash = string(hash)
+		link.HasPassword = true
+	}
+	if err := u.dbShareRepo.InsertShareLinkRecord(link); err != nil {
+		errUtils.LogErr(logger, "InsertShareLinkRecord", err)
+		return models.ShareLink{}, err
+	}
+	return link, nil
+}
+
+func (u *FManLocalUsecase) ListShareLinks(user models.User) ([]models.ShareLink, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListShareLinks",
+	})
+	logger.Debug("Start listing share links")
+	defer logger.Debug("Finish listing share links")
+	if err := authenticated(logger, user); err != nil {
+		return nil, err
+	}
+	links, err := u.dbShareRepo.ListShareLinkRecords(user.UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListShareLinkRecords", err)
+		return nil, err
+	}
+	return links, nil
+}
+
+func (u *FManLocalUsecase) RevokeShareLink(user models.User, linkUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RevokeShareLink",
+		"linkUUID":  linkUUID,
+	})
+	logger.Debug("Start revoking share link")
+	defer logger.Debug("Finish revoking share link")
+	link, err := u.dbShareRepo.ReadShareLinkRecord(linkUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadShareLinkRecord", err)
+		return err
+	}
+	if err := authorize(logger, user, link.OwnerUUID); err != nil {
+		return err
+	}
+	if err := u.dbShareRepo.HardRemoveShareLinkRecord(linkUUID); err != nil {
+		errUtils.LogErr(logger, "HardRemoveShareLinkRecord", err)
+		return err
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) OpenShareLink(token, password string) (models.SharedItem, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "OpenShareLink",
+	})
+	logger.Debug("Start opening share link")
+	defer logger.Debug("Finish opening share link")
+	link, err := u.openShareLink(logger, token, password)
+	if err != nil {
+		return models.SharedItem{}, err
+	}
+	item := models.SharedItem{Link: link}
+	if link.ItemType == models.EntryTypeFile {
+		file, err := u.readSharedFile(logger, link, link.ItemUUID)
+		if err != nil {
+			return models.SharedItem{}, err
+		}
+		item.File = &file
+		return item, nil
+	}
+	dir, err := u.readSharedDir(logger, link, link.ItemUUID)
+	if err != nil {
+		return models.SharedItem{}, err
+	}
+	item.Directory = &dir
+	return item, nil
+}
+
+func (u *FManLocalUsecase) ListSharedDirectory(token, password, dirUUID string, opts models.DirListOptions) (models.Directory, string, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListSharedDirectory",
+		"dirUUID":   dirUUID,
+	})
+	logger.Debug("Start listing shared directory")
+	defer logger.Debug("Finish listing shared directory")
+	link, err := u.openShareLink(logger, token, password)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	if dirUUID == "" {
+		dirUUID = link.ItemUUID
+	}
+	sharedDir, err := u.readSharedDir(logger, link, dirUUID)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	// The creator of the link lists the directory, so the read permission of the creator is
+	// checked again, as the permissions may have been changed since the link was created.
+	creator := shareLinkUser(link.OwnerUUID)
+	perms, err := u.authorizePermission(logger, creator, models.PermRead, sharedDir.OwnerUUID, "", sharedDir.UUID)
+	if err != nil {
+		return models.Directory{}, "", err
+	}
+	dir, nextCursor, err := u.dbDirRepo.ListDirRecord(dirUUID, opts)
+	if err != nil {
+		errUtils.LogErr(logger, "ListDirRecord", err)
+		return models.Directory{}, "", err
+	}
+	if err := u.hideUnreadableChildren(logger, creator, perms, &dir); err != nil {
+		return models.Directory{}, "", err
+	}
+	return dir, nextCursor, nil
+}
+
+func (u *FManLocalUsecase) DownloadSharedFile(token, password, fileUUID string, count bool) (models.File, io.ReadSeekCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "DownloadSharedFile",
+		"fileUUID":  fileUUID,
+		"count":     count,
+	})
+	logger.Debug("Start downloading shared file")
+	defer logger.Debug("Finish downloading shared file")
+	link, err := u.openShareLink(logger, token, password)
+	if err != nil {
+		return models.File{}, nil, err
+	}
+	if fileUUID == "" {
+		fileUUID = link.ItemUUID
+	}
+	file, err := u.readSharedFile(logger, link, fileUUID)
+	if err != nil {
+		return models.File{}, nil, err
+	}
+	// The creator of the link downloads the file, so the read permission of the creator is
+	// checked again.
+	if err := u.authorizeFile(logger, shareLinkUser(link.OwnerUUID), file, models.PermRead); err != nil {
+		return models.File{}, nil, err
+	}
+	// The download is counted before the content is read, so the limit holds even if
+	// downloads are interrupted.
+	if count {
+		if err := u.dbShareRepo.CountShareLinkDownload(link.UUID); err != nil {
+			errUtils.LogErr(logger, "CountShareLinkDownload", err)
+			return models.File{}, nil, err
+		}
+	}
+	content := fileUtils.NewFileReadSeeker(u.fileOps, file.StorageKey, int64(file.FileSize))
+	return file, content, nil
+}
+
+func (u *FManLocalUsecase) UploadSharedFile(token, password, filename, parentUUID string, contentReader io.Reader,
+	expected models.Checksums) error {
+	// Generate a new UUID.
+	newFileUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "UploadSharedFile",
+		"filename":   filename,
+		"fileUUID":   newFileUUID,
+		"parentUUID": parentUUID,
+	})
+	logger.Debug("Start uploading shared file")
+	defer logger.Debug("Finish uploading shared file")
+	link, err := u.openShareLink(logger, token, password)
+	if err != nil {
+		return err
+	}
+	if link.Mode != models.ShareModeUpload || link.ItemType != models.EntryTypeDir {
+		logger.Info("[-USER-] share link does not allow uploads")
+		return models.NewFManError(models.ForbiddenErrorCode, "share link does not allow uploads")
+	}
+	if parentUUID == "" {
+		parentUUID = link.ItemUUID
+	}
+	if _, err := u.readSharedDir(logger, link, parentUUID); err != nil {
+		return err
+	}
+	// The creator of the link uploads the file, so the write permission of the creator is
+	// checked again. The file is owned by the owner of its directory, and existing files are
+	// never replaced through a link.
+	return u.createFile(logger, shareLinkUser(link.OwnerUUID), newFileUUID, filename, parentUUID, contentReader,
+		expected)
+}
+
+// shareLinkUser returns the creator of a share link, as whom downloads, listings and uploads
+// through the link are made. The user record is not read, so the creator has no admin rights,
+// and needs the permissions as the owner or through access control entries.
+func shareLinkUser(creatorUUID string) models.User {
+	return models.User{UUID: creatorUUID}
+}
+
+// validateShareLinkOptions checks the options of a new share link.
+func validateShareLinkOptions(opts models.ShareLinkOptions) error {
+	switch opts.ItemType {
+	case models.EntryTypeFile, models.EntryTypeDir:
+	default:
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown item type %s", opts.ItemType))
+	}
+	switch opts.Mode {
+	case models.ShareModeRead:
+	case models.ShareModeUpload:
+		if opts.ItemType != models.EntryTypeDir {
+			return models.NewFManError(models.InvalidArgumentErrorCode, "only directories can be shared for uploads")
+		}
+	default:
+		return models.NewFManError(models.InvalidArgumentErrorCode, fmt.Sprintf("unknown share mode %s", opts.Mode))
+	}
+	if opts.MaxDownloads < 0 {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "maximum downloads must not be negative")
+	}
+	if !opts.ExpiresAt.IsZero() && !opts.ExpiresAt.After(time.Now()) {
+		return models.NewFManError(models.InvalidArgumentErrorCode, "expiration time must be in the future")
+	}
+	if len(opts.Password) > maxSharePasswordLength {
+		return models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("password must not be longer than %d bytes", maxSharePasswordLength))
+	}
+	return nil
+}
+
+// newShareToken returns a new random token of a share link, which is safe to use in URLs.
+func newShareToken() (string, error) {
+	b := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// openShareLink reads the share link with a token, and checks that it can still be used
+// and that the password matches.
+func (u *FManLocalUsecase) openShareLink(logger *log.Entry, token, password string) (models.ShareLink, error) {
+	link, err := u.dbShareRepo.ReadShareLinkRecordByToken(token)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadShareLinkRecordByToken", err)
+		return models.ShareLink{}, err
+	}
+	logger = logger.WithField("linkUUID", link.UUID)
+	if link.HasPassword {
+		if password == "" {
+			logger.Info("[-USER-] share link requires a password")
+			return models.ShareLink{}, models.NewFManError(models.UnauthorizedErrorCode, "share link requires a password")
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			logger.Info("[-USER-] invalid share link password")
+			return models.ShareLink{}, models.NewFManError(models.UnauthorizedErrorCode, "invalid share link password")
+		}
+	}
+	if link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt) {
+		logger.Info("[-USER-] share link expired")
+		return models.ShareLink{}, models.NewFManError(models.ExpiredErrorCode, "share link expired")
+	}
+	if link.MaxDownloads > 0 && link.Downloads >= link.MaxDownloads {
+		logger.Info("[-USER-] share link reached its download limit")
+		return models.ShareLink{}, models.NewFManError(models.ExpiredErrorCode, "share link reached its download limit")
+	}
+	return link, nil
+}
+
+// readSharedFile reads a file record, which is shared by a link either itself or as part
+// of a shared directory's subtree.
+func (u *FManLocalUsecase) readSharedFile(logger *log.Entry, link models.ShareLink, fileUUID string) (models.File, error) {
+	if link.ItemType == models.EntryTypeFile && fileUUID != link.ItemUUID {
+		return models.File{}, notShared(logger, fileUUID)
+	}
+	file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadFileRecord", err)
+		return models.File{}, err
+	}
+	if link.ItemType == models.EntryTypeDir {
+		if _, err := u.readSharedDir(logger, link, file.ParentUUID); err != nil {
+			return models.File{}, err
+		}
+	}
+	return file, nil
+}
+
+// readSharedDir reads a directory record, which is the directory shared by a link or
+// one of its descendants.
+func (u *FManLocalUsecase) readSharedDir(logger *log.Entry, link models.ShareLink, dirUUID string) (models.Directory, error) {
+	if link.ItemType != models.EntryTypeDir {
+		return models.Directory{}, notShared(logger, dirUUID)
+	}
+	var dir models.Directory
+	// Walk up to the shared directory. Soft-removed directories are not found, so nothing
+	// in the recycle bin is shared.
+	for uuid := dirUUID; ; {
+		current, err := u.dbDirRepo.ReadDirRecord(uuid)
+		if err != nil {
+			errUtils.LogErr(logger, "ReadDirRecord", err)
+			return models.Directory{}, err
+		}
+		if uuid == dirUUID {
+			dir = current
+		}
+		if uuid == link.ItemUUID {
+			return dir, nil
+		}
+		if current.ParentUUID == "" {
+			return models.Directory{}, notShared(logger, dirUUID)
+		}
+		uuid = current.ParentUUID
+	}
+}
+
+// notShared logs and returns the error of a file/dir, which is not shared by a link. It
+// is reported as not existing, so the link does not reveal anything outside of it.
+func notShared(logger *log.Entry, UUID string) error {
+	logger.Infof("[-USER-] %s is not shared by the link", UUID)
+	return models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("%s does not exist", UUID))
+}
@@ -0,0 +1,343 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	// GIF images are decoded by image.Decode.
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultThumbnailWorkers is the number of images a Thumbnailer makes thumbnails of at the
+// same time if no number is given.
+const DefaultThumbnailWorkers = 2
+
+// thumbnailQueueSize is the maximum number of files waiting for a Thumbnailer. Files which
+// do not fit into the queue get their thumbnails on the first request instead.
+const thumbnailQueueSize = 256
+
+// thumbnailJPEGQuality is the quality of the thumbnails of JPEG images.
+const thumbnailJPEGQuality = 85
+
+// imageExtensions holds the extensions of image files, which get thumbnails.
+var imageExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
+}
+
+// isImage checks if a file is an image getting thumbnails by its name.
+func isImage(filename string) bool {
+	return imageExtensions[strings.ToLower(path.Ext(filename))]
+}
+
+func (u *FManLocalUsecase) ReadThumbnail(user models.User, fileUUID, size string) (models.Thumbnail, io.ReadSeekCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ReadThumbnail",
+		"fileUUID":  fileUUID,
+		"size":      size,
+	})
+	logger.Debug("Start reading thumbnail")
+	defer logger.Debug("Finish reading thumbnail")
+	if size == "" {
+		size = models.DefaultThumbnailSize
+	}
+	if _, ok := models.ThumbnailSizes[size]; !ok {
+		logger.Infof("[-USER-] unknown thumbnail size %s", size)
+		return models.Thumbnail{}, nil, models.NewFManError(models.InvalidArgumentErrorCode,
+			fmt.Sprintf("unknown thumbnail size %s", size))
+	}
+	file, err := u.readFile(logger, user, fileUUID, models.PermRead)
+	if err != nil {
+		return models.Thumbnail{}, nil, err
+	}
+	if !isImage(file.Filename) {
+		logger.Infof("[-USER-] %s is not an image", file.Filename)
+		return models.Thumbnail{}, nil, models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("file %s has no thumbnails", fileUUID))
+	}
+	// Thumbnails, which were not made in the background yet, are made right away.
+	thumbnails, err := u.makeThumbnails(logger, file)
+	if err != nil {
+		return models.Thumbnail{}, nil, err
+	}
+	thumbnail := thumbnails[size]
+	content := fileUtils.NewFileReadSeeker(u.fileOps, thumbnail.StorageKey, thumbnail.FileSize)
+	return thumbnail, content, nil
+}
+
+// Thumbnailer makes the thumbnails of image files in the background, after their content
+// was uploaded or replaced.
+type Thumbnailer struct {
+	uc      *FManLocalUsecase
+	workers int
+}
+
+// NewThumbnailer creates a new Thumbnailer. A number of workers <= 0 is replaced by
+// DefaultThumbnailWorkers.
+func NewThumbnailer(uc *FManLocalUsecase, workers int) *Thumbnailer {
+	if workers <= 0 {
+		workers = DefaultThumbnailWorkers
+	}
+	return &Thumbnailer{
+		uc,
+		workers,
+	}
+}
+
+// Run makes the thumbnails of the queued files, until ctx is done.
+func (t *Thumbnailer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < t.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case fileUUID := <-t.uc.thumbnailQueue:
+					t.makeThumbnails(fileUUID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// makeThumbnails makes the thumbnails of the current version of a queued file.
+func (t *Thumbnailer) makeThumbnails(fileUUID string) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-thumbnailer",
+		"Operation": "makeThumbnails",
+		"fileUUID":  fileUUID,
+	})
+	file, err := t.uc.dbFileRepo.ReadFileRecord(fileUUID)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		// The file was removed in the meantime.
+		return
+	}
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] ReadFileRecord failed with error %s", err.Error())
+		return
+	}
+	if !isImage(file.Filename) {
+		return
+	}
+	// The errors are logged by makeThumbnails.
+	t.uc.makeThumbnails(logger, file)
+}
+
+// queueThumbnails queues a file for a Thumbnailer after its content changed, if it is an
+// image. The thumbnails of older versions are removed, so they are not cached anymore.
+// Failures are only logged, as the thumbnails are made on the first request anyway.
+func (u *FManLocalUsecase) queueThumbnails(logger *log.Entry, fileUUID, filename string) {
+	images, err := u.dbThumbnailRepo.HardRemoveStaleThumbnailRecords(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveStaleThumbnailRecords", err)
+	}
+	u.removeContents(logger, images)
+	if !isImage(filename) {
+		return
+	}
+	select {
+	case u.thumbnailQueue <- fileUUID:
+	default:
+		logger.Debugf("Thumbnail queue is full, skipping file %s", fileUUID)
+	}
+}
+
+// makeThumbnails returns the thumbnails in all sizes of the current version of an image file,
+// and makes those which do not exist yet from the content of the file.
+func (u *FManLocalUsecase) makeThumbnails(logger *log.Entry, file models.File) (map[string]models.Thumbnail, error) {
+	thumbnails := make(map[string]models.Thumbnail, len(models.ThumbnailSizes))
+	var missing []string
+	for size := range models.ThumbnailSizes {
+		thumbnail, err := u.dbThumbnailRepo.ReadThumbnailRecord(file.UUID, file.Version, size)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			missing = append(missing, size)
+			continue
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadThumbnailRecord", err)
+			return nil, err
+		}
+		thumbnails[size] = thumbnail
+	}
+	if len(missing) == 0 {
+		return thumbnails, nil
+	}
+	logger.Debugf("Making %d thumbnails of version %d", len(missing), file.Version)
+	src, format, err := u.decodeImage(logger, file)
+	if err != nil {
+		return nil, err
+	}
+	// The largest thumbnail is scaled down from the image, and every smaller one from the
+	// next larger, so the image is only scanned once.
+	sort.Slice(missing, func(i, j int) bool {
+		return models.ThumbnailSizes[missing[i]] > models.ThumbnailSizes[missing[j]]
+	})
+	for _, size := range missing {
+		src = scaleDown(src, models.ThumbnailSizes[size])
+		thumbnail, err := u.storeThumbnail(logger, file, size, src, format)
+		if err != nil {
+			return nil, err
+		}
+		thumbnails[size] = thumbnail
+	}
+	return thumbnails, nil
+}
+
+// decodeImage reads and decodes the content of an image file. It returns the format of the
+// image, png, jpeg or gif. Only the first frame of animated GIFs is decoded. Images, which
+// are too large or invalid, have no thumbnails.
+func (u *FManLocalUsecase) decodeImage(logger *log.Entry, file models.File) (image.Image, string, error) {
+	if file.FileSize > models.MaxThumbnailSourceSize {
+		logger.Infof("[-USER-] image of %d bytes is too large for thumbnails", file.FileSize)
+		return nil, "", models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("images larger than %d bytes have no thumbnails", models.MaxThumbnailSourceSize))
+	}
+	content, err := u.fileOps.ReadFile(file.StorageKey)
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] ReadFile of %s failed with error %s", file.StorageKey, err.Error())
+		return nil, "", err
+	}
+	defer content.Close()
+	data, err := io.ReadAll(io.LimitReader(content, models.MaxThumbnailSourceSize))
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] Reading content %s failed with error %s", file.StorageKey, err.Error())
+		return nil, "", err
+	}
+	// The dimensions are checked before the pixels are decoded.
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && format != "png" && format != "jpeg" && format != "gif" {
+		err = fmt.Errorf("unsupported format %s", format)
+	}
+	if err != nil {
+		logger.Infof("[-USER-] content of %s is not a supported image: %s", file.Filename, err.Error())
+		return nil, "", models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("file %s is not a PNG, JPEG or GIF image", file.UUID))
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > models.MaxThumbnailSourcePixels {
+		logger.Infof("[-USER-] image of %dx%d pixels cannot have thumbnails", config.Width, config.Height)
+		return nil, "", models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("images with more than %d pixels have no thumbnails", models.MaxThumbnailSourcePixels))
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		logger.Infof("[-USER-] content of %s is not a valid image: %s", file.Filename, err.Error())
+		return nil, "", models.NewFManError(models.NotFoundErrorCode,
+			fmt.Sprintf("file %s is not a valid %s image", file.UUID, format))
+	}
+	return img, format, nil
+}
+
+// storeThumbnail encodes a thumbnail of the current version of a file, and saves it to the
+// storage and the DB. Thumbnails of JPEG images are JPEG images, the others are PNG images,
+// which keep the transparency.
+func (u *FManLocalUsecase) storeThumbnail(logger *log.Entry, file models.File, size string, img image.Image,
+	format string) (models.Thumbnail, error) {
+	var buf bytes.Buffer
+	contentType := "image/png"
+	var err error
+	if format == "jpeg" {
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] Encoding %s thumbnail failed with error %s", size, err.Error())
+		return models.Thumbnail{}, err
+	}
+	storageKey := "thumbnail-" + u.uuidGen.NewUUID()
+	saved, err := u.fileOps.SaveFile(storageKey, &buf, fileUtils.WithContentType(contentType))
+	if err != nil {
+		if err := u.fileOps.RemoveFile(storageKey); err != nil && !os.IsNotExist(err) {
+			logger.Errorf("[-INTERNAL-] RemoveFile of %s failed with error %s", storageKey, err.Error())
+		}
+		logger.Errorf("[-INTERNAL-] SaveFile failed with error %s", err.Error())
+		return models.Thumbnail{}, err
+	}
+	blob := models.Blob{StorageKey: storageKey, RealPath: saved.Location, Size: saved.Size, StoredSize: saved.StoredSize}
+	bounds := img.Bounds()
+	stored, err := u.dbThumbnailRepo.InsertThumbnailRecord(models.Thumbnail{
+		FileUUID:    file.UUID,
+		Version:     file.Version,
+		Size:        size,
+		StorageKey:  storageKey,
+		RealPath:    saved.Location,
+		ContentType: contentType,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		FileSize:    saved.Size,
+	})
+	if err != nil {
+		u.removeContents(logger, []models.Blob{blob})
+		errUtils.LogErr(logger, "InsertThumbnailRecord", err)
+		return models.Thumbnail{}, err
+	}
+	if stored.StorageKey != storageKey {
+		// The thumbnail was made concurrently, so this one is not needed.
+		u.removeContents(logger, []models.Blob{blob})
+	}
+	return stored, nil
+}
+
+// scaleDown scales an image down with a box filter, so it fits into a square with a given
+// side, keeping its aspect ratio. Images which already fit keep their size.
+func scaleDown(src image.Image, side int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= side && sh <= side {
+		return src
+	}
+	dw, dh := side, side
+	if sw > sh {
+		dh = sh * side / sw
+	} else {
+		dw = sw * side / sh
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := b.Min.Y+dy*sh/dh, b.Min.Y+(dy+1)*sh/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := b.Min.X+dx*sw/dw, b.Min.X+(dx+1)*sw/dw
+			// Each destination pixel is the average of the source pixels it covers, in
+			// premultiplied colors, so transparent pixels do not darken the edges.
+			var r, g, bl, a, n uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					cr, cg, cb, ca := src.At(x, y).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
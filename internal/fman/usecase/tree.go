@@ -0,0 +1,260 @@
+package usecase
+
+import (
+	"fmt"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	log "github.com/sirupsen/logrus"
+)
+
+func (u *FManLocalUsecase) CopyDirectory(user models.User, srcUUID, dstParentUUID string, progress models.ProgressFunc) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":         "usecase-local",
+		"Operation":     "CopyDirectory",
+		"sourceDirUUID": srcUUID,
+		"dstParentUUID": dstParentUUID,
+	})
+	logger.Debug("Start copying directory")
+	defer logger.Debug("Finish copying directory")
+	srcDir, err := u.readDir(logger, user, srcUUID, models.PermRead)
+	if err != nil {
+		return err
+	}
+	dstParent, err := u.validateDestination(logger, user, srcDir.Dirname, dstParentUUID)
+	if err != nil {
+		return err
+	}
+	if err := u.checkNotInSubtree(dstParentUUID, srcUUID); err != nil {
+		errUtils.LogErr(logger, "checkNotInSubtree", err)
+		return err
+	}
+	dirs, files, err := u.walkSubtree(srcUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "walkSubtree", err)
+		return err
+	}
+	dirs, files, err = u.readableSubtree(logger, user, dirs, files)
+	if err != nil {
+		return err
+	}
+	p := models.Progress{FilesTotal: len(files)}
+	for _, file := range files {
+		p.BytesTotal += file.FileSize
+	}
+	// The copies are owned by the owner of the destination, and counted in full although
+	// they share the content.
+	release, err := u.reserveQuota(logger, dstParent.OwnerUUID, dstParentUUID, int64(p.BytesTotal))
+	if err != nil {
+		return err
+	}
+	defer release()
+	report(progress, p)
+
+	// Everything created so far, which is removed again if the copy fails midway.
+	var createdDirs, createdFiles []string
+	rollback := func() {
+		logger.Debugf("Rolling back %d files and %d directories", len(createdFiles), len(createdDirs))
+		for i := len(createdFiles) - 1; i >= 0; i-- {
+			blobs, err := u.dbFileRepo.HardRemoveFileRecord(createdFiles[i])
+			if err != nil {
+				logger.Errorf("[-INTERNAL-] HardRemoveFileRecord of %s failed with error %s", createdFiles[i], err.Error())
+			}
+			// Only content which lost its source in the meantime is released.
+			u.removeContents(logger, blobs)
+		}
+		// Descendants are created after their parents, so they are removed before them.
+		for i := len(createdDirs) - 1; i >= 0; i-- {
+			if err := u.dbDirRepo.HardRemoveDirRecord(createdDirs[i]); err != nil {
+				logger.Errorf("[-INTERNAL-] HardRemoveDirRecord of %s failed with error %s", createdDirs[i], err.Error())
+			}
+		}
+	}
+	// Map the UUIDs of the source directories to the UUIDs of their copies.
+	newDirUUIDs := map[string]string{srcDir.ParentUUID: dstParentUUID}
+	for _, dir := range dirs {
+		newDirUUID := u.uuidGen.NewUUID()
+		if err := u.dbDirRepo.InsertDirRecord(newDirUUID, dir.Dirname, newDirUUIDs[dir.ParentUUID], dstParent.OwnerUUID); err != nil {
+			errUtils.LogErr(logger, "InsertDirRecord", err)
+			rollback()
+			return err
+		}
+		newDirUUIDs[dir.UUID] = newDirUUID
+		createdDirs = append(createdDirs, newDirUUID)
+		if err := u.copyAttributes(logger, dir.UUID, newDirUUID); err != nil {
+			rollback()
+			return err
+		}
+	}
+	for _, file := range files {
+		newFileUUID := u.uuidGen.NewUUID()
+		if err := u.copyFileRecord(logger, newFileUUID, dstParent.OwnerUUID, file, newDirUUIDs[file.ParentUUID]); err != nil {
+			rollback()
+			return err
+		}
+		createdFiles = append(createdFiles, newFileUUID)
+		p.FilesDone++
+		p.BytesDone += file.FileSize
+		report(progress, p)
+	}
+	return nil
+}
+
+func (u *FManLocalUsecase) RemoveDirectory(user models.User, dirUUID string, progress models.ProgressFunc) error {
+	// Generate a new UUID for the recycle bin entry, which holds the subtree while it is removed.
+	newTrashUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RemoveDirectory",
+		"dirUUID":   dirUUID,
+		"trashUUID": newTrashUUID,
+	})
+	logger.Debug("Start removing directory")
+	defer logger.Debug("Finish removing directory")
+	dir, err := u.readDir(logger, user, dirUUID, models.PermDelete)
+	if err != nil {
+		return err
+	}
+	if dir.ParentUUID == "" {
+		logger.Info("[-USER-] root directory cannot be removed")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "root directory cannot be removed")
+	}
+	if err := u.authorizeSubtree(logger, user, dir, models.PermDelete); err != nil {
+		return err
+	}
+	dirs, files, err := u.walkSubtree(dirUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "walkSubtree", err)
+		return err
+	}
+	logger.Debugf("Removing %d files and %d directories", len(files), len(dirs))
+	// Hide the whole subtree at once, then remove its records in a single step. If the
+	// second step fails, the subtree is put back where it was.
+	if err := u.dbDirRepo.SoftRemoveDirRecord(dirUUID, newTrashUUID); err != nil {
+		errUtils.LogErr(logger, "SoftRemoveDirRecord", err)
+		return err
+	}
+	blobs, err := u.dbTrashRepo.HardRemoveTrashRecord(newTrashUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveTrashRecord", err)
+		if err := u.dbTrashRepo.RestoreTrashRecord(newTrashUUID, dirs[0].Dirname, dirs[0].ParentUUID); err != nil {
+			logger.Errorf("[-INTERNAL-] RestoreTrashRecord failed with error %s", err.Error())
+		}
+		return err
+	}
+	// The records of all files are gone at once, then the content which is not shared with
+	// other files is removed one by one.
+	p := models.Progress{FilesDone: len(files), FilesTotal: len(files), BlobsTotal: len(blobs)}
+	for _, file := range files {
+		p.BytesTotal += file.FileSize
+	}
+	p.BytesDone = p.BytesTotal
+	report(progress, p)
+	for _, blob := range blobs {
+		u.removeContents(logger, []models.Blob{blob})
+		p.BlobsDone++
+		report(progress, p)
+	}
+	return nil
+}
+
+// copyFileRecord inserts a new file record owned by ownerUUID in a parent directory, which
+// shares the content and the attributes of a file. The new file is removed again if its
+// attributes cannot be copied.
+func (u *FManLocalUsecase) copyFileRecord(logger *log.Entry, newFileUUID, ownerUUID string, file models.File, parentUUID string) error {
+	if _, err := u.dbFileRepo.InsertFileRecord(newFileUUID, file.Filename, parentUUID, ownerUUID, file.ContentType,
+		models.Blob{Hash: file.ContentHash}); err != nil {
+		errUtils.LogErr(logger, "InsertFileRecord", err)
+		return err
+	}
+	if err := u.copyAttributes(logger, file.UUID, newFileUUID); err != nil {
+		blobs, rmErr := u.dbFileRepo.HardRemoveFileRecord(newFileUUID)
+		if rmErr != nil {
+			logger.Errorf("[-INTERNAL-] HardRemoveFileRecord of %s failed with error %s", newFileUUID, rmErr.Error())
+		}
+		// Only content which lost its source in the meantime is released.
+		u.removeContents(logger, blobs)
+		return err
+	}
+	return nil
+}
+
+// readableSubtree returns the directories and files of a subtree returned by walkSubtree, on
+// which a user has the read permission, in the same order. The user must have it on the
+// root. Directories without it are left out together with their whole subtree.
+func (u *FManLocalUsecase) readableSubtree(logger *log.Entry, user models.User, dirs []models.Directory,
+	files []models.File) ([]models.Directory, []models.File, error) {
+	perms, err := u.subtreePermissions(logger, user, models.PermRead, dirs, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	included := map[string]bool{dirs[0].ParentUUID: true}
+	var readableDirs []models.Directory
+	for _, dir := range dirs {
+		if included[dir.ParentUUID] && perms[dir.UUID].Has(models.PermRead) {
+			included[dir.UUID] = true
+			readableDirs = append(readableDirs, dir)
+		}
+	}
+	var readableFiles []models.File
+	for _, file := range files {
+		if included[file.ParentUUID] && perms[file.UUID].Has(models.PermRead) {
+			readableFiles = append(readableFiles, file)
+		}
+	}
+	if len(readableDirs) < len(dirs) || len(readableFiles) < len(files) {
+		logger.Debugf("Skipping %d unreadable files and %d unreadable directories", len(files)-len(readableFiles),
+			len(dirs)-len(readableDirs))
+	}
+	return readableDirs, readableFiles, nil
+}
+
+// walkSubtree returns a directory together with all its descendant directories, where
+// parents come before their children, and all files in the subtree.
+func (u *FManLocalUsecase) walkSubtree(dirUUID string) ([]models.Directory, []models.File, error) {
+	root, err := u.dbDirRepo.ReadDirRecord(dirUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dirs := []models.Directory{root}
+	var files []models.File
+	for i := 0; i < len(dirs); i++ {
+		opts := models.DirListOptions{Limit: models.MaxListLimit}
+		for {
+			page, nextCursor, err := u.dbDirRepo.ListDirRecord(dirs[i].UUID, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			dirs = append(dirs, page.ListOfDirs...)
+			files = append(files, page.ListOfFiles...)
+			if nextCursor == "" {
+				break
+			}
+			opts.Cursor = nextCursor
+		}
+	}
+	return dirs, files, nil
+}
+
+// checkNotInSubtree returns an error if a directory is ancestorUUID itself or lies beneath it.
+func (u *FManLocalUsecase) checkNotInSubtree(dirUUID, ancestorUUID string) error {
+	for dirUUID != "" {
+		if dirUUID == ancestorUUID {
+			return models.NewFManError(models.InvalidArgumentErrorCode,
+				fmt.Sprintf("directory %s cannot be copied into itself or its descendants", ancestorUUID))
+		}
+		dir, err := u.dbDirRepo.ReadDirRecord(dirUUID)
+		if err != nil {
+			return err
+		}
+		dirUUID = dir.ParentUUID
+	}
+	return nil
+}
+
+// report calls progress, if it is not nil.
+func report(progress models.ProgressFunc, p models.Progress) {
+	if progress != nil {
+		progress(p)
+	}
+}
@@ -0,0 +1,327 @@
+package usecase
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultUploadExpiration is how long a resumable upload can be continued after its
+// creation if no expiration is given.
+const DefaultUploadExpiration = 24 * time.Hour
+
+// uploadLock is the lock of a resumable upload.
+type uploadLock struct {
+	mu sync.Mutex
+
+	// refs is the number of callers holding or waiting for the lock.
+	refs int
+}
+
+func (u *FManLocalUsecase) CreateUpload(user models.User, filename, parentUUID string, length int64, metadata string) (models.Upload, error) {
+	// Generate a new UUID.
+	newUploadUUID := u.uuidGen.NewUUID()
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "CreateUpload",
+		"filename":   filename,
+		"uploadUUID": newUploadUUID,
+		"parentUUID": parentUUID,
+		"length":     length,
+	})
+	logger.Debug("Start creating upload")
+	defer logger.Debug("Finish creating upload")
+	store, err := u.partialFileStore()
+	if err != nil {
+		errUtils.LogErr(logger, "partialFileStore", err)
+		return models.Upload{}, err
+	}
+	if length < 0 {
+		logger.Info("[-USER-] upload length must not be negative")
+		return models.Upload{}, models.NewFManError(models.InvalidArgumentErrorCode, "upload length must not be negative")
+	}
+	if u.opts.MaxUploadSize > 0 && length > u.opts.MaxUploadSize {
+		logger.Infof("[-USER-] upload length exceeds the maximum of %d bytes", u.opts.MaxUploadSize)
+		return models.Upload{}, models.NewFManError(models.TooLargeErrorCode,
+			fmt.Sprintf("upload length exceeds the maximum of %d bytes", u.opts.MaxUploadSize))
+	}
+	// Fail early, the destination and the quota are checked again when the upload completes.
+	// An upload to an existing file adds a new version to it, which counts towards the quota
+	// of the file's owner.
+	file, err := u.versionedFile(logger, user, filename, parentUUID)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	ownerUUID := file.OwnerUUID
+	if file.UUID == "" {
+		parent, err := u.validateDestination(logger, user, filename, parentUUID)
+		if err != nil {
+			return models.Upload{}, err
+		}
+		ownerUUID = parent.OwnerUUID
+	}
+	if err := u.checkQuota(logger, ownerUUID, parentUUID, length); err != nil {
+		return models.Upload{}, err
+	}
+	now := time.Now().UTC()
+	upload := models.Upload{
+		UUID:       newUploadUUID,
+		Filename:   filename,
+		ParentUUID: parentUUID,
+		OwnerUUID:  user.UUID,
+		Length:     length,
+		Metadata:   metadata,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(u.opts.UploadExpiration),
+	}
+	if err := store.CreatePartialFile(newUploadUUID); err != nil {
+		logger.Errorf("[-INTERNAL-] CreatePartialFile failed with error %s", err.Error())
+		return models.Upload{}, err
+	}
+	if err := u.dbUploadRepo.InsertUploadRecord(upload); err != nil {
+		errUtils.LogErr(logger, "InsertUploadRecord", err)
+		u.removePartialFile(logger, store, newUploadUUID)
+		return models.Upload{}, err
+	}
+	// An empty upload is complete right away.
+	if length == 0 {
+		unlock := u.lockUpload(newUploadUUID)
+		defer unlock()
+		return u.completeUpload(logger, user, store, upload)
+	}
+	return upload, nil
+}
+
+func (u *FManLocalUsecase) ReadUpload(user models.User, uploadUUID string) (models.Upload, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "ReadUpload",
+		"uploadUUID": uploadUUID,
+	})
+	logger.Debug("Start reading upload")
+	defer logger.Debug("Finish reading upload")
+	return u.readUnexpiredUpload(logger, user, uploadUUID)
+}
+
+func (u *FManLocalUsecase) WriteUploadChunk(user models.User, uploadUUID string, offset int64, contentReader io.Reader) (models.Upload, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "WriteUploadChunk",
+		"uploadUUID": uploadUUID,
+		"offset":     offset,
+	})
+	logger.Debug("Start writing upload chunk")
+	defer logger.Debug("Finish writing upload chunk")
+	store, err := u.partialFileStore()
+	if err != nil {
+		errUtils.LogErr(logger, "partialFileStore", err)
+		return models.Upload{}, err
+	}
+	unlock := u.lockUpload(uploadUUID)
+	defer unlock()
+	upload, err := u.readUnexpiredUpload(logger, user, uploadUUID)
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if offset != upload.Offset {
+		logger.Infof("[-USER-] upload is at offset %d", upload.Offset)
+		return models.Upload{}, models.NewFManError(models.ConflictErrorCode, fmt.Sprintf("upload is at offset %d", upload.Offset))
+	}
+	if upload.FileUUID != "" {
+		// The upload is already complete, e.g. the client did not get the last response.
+		return upload, nil
+	}
+	if upload.Offset < upload.Length {
+		n, err := store.WritePartialFile(uploadUUID, offset, io.LimitReader(contentReader, upload.Length-offset))
+		// Keep what was received before an error, so the client can resume from there. The
+		// offset fails to move on with ConflictErrorCode, if a chunk for the same offset was
+		// received by another server in the meantime.
+		if n > 0 {
+			if err := u.dbUploadRepo.UpdateUploadOffset(uploadUUID, offset, offset+n); err != nil {
+				errUtils.LogErr(logger, "UpdateUploadOffset", err)
+				return models.Upload{}, err
+			}
+			upload.Offset = offset + n
+		}
+		if err != nil {
+			logger.Errorf("[-INTERNAL-] WritePartialFile failed with error %s", err.Error())
+			return models.Upload{}, err
+		}
+	}
+	if upload.Offset < upload.Length {
+		return upload, nil
+	}
+	// Anything after the declared length is not part of the upload.
+	if n, _ := contentReader.Read(make([]byte, 1)); n > 0 {
+		logger.Info("[-USER-] chunk exceeds the upload length")
+		return models.Upload{}, models.NewFManError(models.InvalidArgumentErrorCode, "chunk exceeds the upload length")
+	}
+	// A failed completion, e.g. because the name was taken in the meantime, can be retried
+	// by sending an empty chunk at the final offset.
+	return u.completeUpload(logger, user, store, upload)
+}
+
+func (u *FManLocalUsecase) TerminateUpload(user models.User, uploadUUID string) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":      "usecase-local",
+		"Operation":  "TerminateUpload",
+		"uploadUUID": uploadUUID,
+	})
+	logger.Debug("Start terminating upload")
+	defer logger.Debug("Finish terminating upload")
+	store, err := u.partialFileStore()
+	if err != nil {
+		errUtils.LogErr(logger, "partialFileStore", err)
+		return err
+	}
+	unlock := u.lockUpload(uploadUUID)
+	defer unlock()
+	upload, err := u.readUnexpiredUpload(logger, user, uploadUUID)
+	if err != nil {
+		return err
+	}
+	return u.removeUpload(logger, store, upload)
+}
+
+func (u *FManLocalUsecase) PurgeExpiredUploads(now time.Time) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "PurgeExpiredUploads",
+	})
+	logger.Debug("Start purging expired uploads")
+	defer logger.Debug("Finish purging expired uploads")
+	store, err := u.partialFileStore()
+	if err != nil {
+		errUtils.LogErr(logger, "partialFileStore", err)
+		return 0, err
+	}
+	uploads, err := u.dbUploadRepo.ListExpiredUploadRecords(now)
+	if err != nil {
+		errUtils.LogErr(logger, "ListExpiredUploadRecords", err)
+		return 0, err
+	}
+	removed := 0
+	for _, upload := range uploads {
+		unlock := u.lockUpload(upload.UUID)
+		err := u.removeUpload(logger, store, upload)
+		unlock()
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			// The upload was terminated concurrently.
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// completeUpload creates the file of a resumable upload, whose content is completely
+// received, in the same way as UploadFile by the user. The caller must hold the lock of
+// the upload.
+func (u *FManLocalUsecase) completeUpload(logger *log.Entry, user models.User, store fileUtils.PartialFileStore,
+	upload models.Upload) (models.Upload, error) {
+	newFileUUID := u.uuidGen.NewUUID()
+	logger = logger.WithField("fileUUID", newFileUUID)
+	content, err := store.ReadPartialFile(upload.UUID)
+	if err != nil {
+		logger.Errorf("[-INTERNAL-] ReadPartialFile failed with error %s", err.Error())
+		return models.Upload{}, err
+	}
+	fileUUID, err := u.saveNewFile(logger, user, newFileUUID, upload.Filename, upload.ParentUUID, content,
+		models.Checksums{})
+	content.Close()
+	if err != nil {
+		return models.Upload{}, err
+	}
+	if err := u.dbUploadRepo.CompleteUploadRecord(upload.UUID, fileUUID); err != nil {
+		errUtils.LogErr(logger, "CompleteUploadRecord", err)
+		return models.Upload{}, err
+	}
+	// The record of a complete upload is kept until it expires, so clients which
+	// missed the last response can still see that it is complete.
+	u.removePartialFile(logger, store, upload.UUID)
+	upload.FileUUID = fileUUID
+	return upload, nil
+}
+
+// removeUpload removes a resumable upload together with its partial file.
+// The caller must hold the lock of the upload.
+func (u *FManLocalUsecase) removeUpload(logger *log.Entry, store fileUtils.PartialFileStore, upload models.Upload) error {
+	if err := u.dbUploadRepo.HardRemoveUploadRecord(upload.UUID); err != nil {
+		errUtils.LogErr(logger, "HardRemoveUploadRecord", err)
+		return err
+	}
+	if upload.FileUUID == "" {
+		u.removePartialFile(logger, store, upload.UUID)
+	}
+	return nil
+}
+
+// removePartialFile removes the partial file of a resumable upload. Failures are only logged.
+func (u *FManLocalUsecase) removePartialFile(logger *log.Entry, store fileUtils.PartialFileStore, uploadUUID string) {
+	logger.Debugf("Removing partial file %s", uploadUUID)
+	if err := store.RemovePartialFile(uploadUUID); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("[-INTERNAL-] RemovePartialFile failed with error %s", err.Error())
+	}
+}
+
+// readUnexpiredUpload reads a resumable upload, which a user may access, and treats it as not
+// existing once it expired.
+func (u *FManLocalUsecase) readUnexpiredUpload(logger *log.Entry, user models.User, uploadUUID string) (models.Upload, error) {
+	upload, err := u.dbUploadRepo.ReadUploadRecord(uploadUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadUploadRecord", err)
+		return models.Upload{}, err
+	}
+	if err := authorize(logger, user, upload.OwnerUUID); err != nil {
+		return models.Upload{}, err
+	}
+	if !time.Now().Before(upload.ExpiresAt) {
+		logger.Infof("[-USER-] upload %s expired", uploadUUID)
+		return models.Upload{}, models.NewFManError(models.NotFoundErrorCode, fmt.Sprintf("upload %s expired", uploadUUID))
+	}
+	return upload, nil
+}
+
+// partialFileStore returns the storage as a PartialFileStore, if it supports resumable uploads.
+func (u *FManLocalUsecase) partialFileStore() (fileUtils.PartialFileStore, error) {
+	store, ok := u.fileOps.(fileUtils.PartialFileStore)
+	if !ok {
+		return nil, models.NewFManError(models.InternalErrorCode, "storage does not support resumable uploads")
+	}
+	return store, nil
+}
+
+// lockUpload locks a resumable upload and returns the function unlocking it. The lock only
+// serializes the chunks within this server, the offset of the upload is only moved on in the
+// repository from the offset the chunk was written at.
+func (u *FManLocalUsecase) lockUpload(uploadUUID string) func() {
+	u.uploadLocksMu.Lock()
+	lock, ok := u.uploadLocks[uploadUUID]
+	if !ok {
+		lock = &uploadLock{}
+		u.uploadLocks[uploadUUID] = lock
+	}
+	lock.refs++
+	u.uploadLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		u.uploadLocksMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(u.uploadLocks, uploadUUID)
+		}
+		u.uploadLocksMu.Unlock()
+	}
+}
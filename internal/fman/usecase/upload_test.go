@@ -0,0 +1,75 @@
+package usecase
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/internal/fman/repo"
+	"github.com/nvthongswansea/xtreme/internal/models"
+)
+
+// racingUploadRepo moves the offset of an upload on once after it was read, as another
+// server receiving a chunk for the same offset would.
+type racingUploadRepo struct {
+	*repo.FManMemoryRepo
+	raced bool
+}
+
+func (r *racingUploadRepo) ReadUploadRecord(UUID string) (models.Upload, error) {
+	upload, err := r.FManMemoryRepo.ReadUploadRecord(UUID)
+	if err == nil && !r.raced {
+		r.raced = true
+		if err := r.FManMemoryRepo.UpdateUploadOffset(UUID, upload.Offset, upload.Offset+2); err != nil {
+			return models.Upload{}, err
+		}
+	}
+	return upload, err
+}
+
+func TestWriteUploadChunkConflictsWithOtherServer(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	alice := e.newUser(t, "alice")
+	upload, err := e.uc.CreateUpload(alice, "a.txt", alice.RootDirUUID, 4, "")
+	if err != nil {
+		t.Fatalf("CreateUpload failed: %s", err)
+	}
+	racing := &racingUploadRepo{FManMemoryRepo: e.repo}
+	e.uc.dbUploadRepo = racing
+
+	_, err = e.uc.WriteUploadChunk(alice, upload.UUID, 0, strings.NewReader("ab"))
+	if !models.IsFManErrorCode(err, models.ConflictErrorCode) {
+		t.Errorf("WriteUploadChunk returned %v, want a conflict error", err)
+	}
+	got, err := e.repo.ReadUploadRecord(upload.UUID)
+	if err != nil {
+		t.Fatalf("ReadUploadRecord failed: %s", err)
+	}
+	if got.Offset != 2 {
+		t.Errorf("upload is at offset %d, want 2 of the other server", got.Offset)
+	}
+}
+
+func TestLockUploadRemovesUnusedLocks(t *testing.T) {
+	e := newTestEnv(t, Options{})
+	var wg sync.WaitGroup
+	counts := map[string]*int{"upload-1": new(int), "upload-2": new(int)}
+	for i := 0; i < 50; i++ {
+		uploadUUID := []string{"upload-1", "upload-2"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := e.uc.lockUpload(uploadUUID)
+			defer unlock()
+			// The count is only safe to change while holding the lock of the upload.
+			*counts[uploadUUID]++
+		}()
+	}
+	wg.Wait()
+	if *counts["upload-1"] != 25 || *counts["upload-2"] != 25 {
+		t.Errorf("got counts %d and %d, want 25 for each upload", *counts["upload-1"], *counts["upload-2"])
+	}
+	if len(e.uc.uploadLocks) != 0 {
+		t.Errorf("%d locks are left after all were unlocked", len(e.uc.uploadLocks))
+	}
+}
@@ -0,0 +1,295 @@
+package usecase
+
+import (
+	"io"
+	"path"
+	"time"
+
+	errUtils "github.com/nvthongswansea/xtreme/internal/err-utils"
+	"github.com/nvthongswansea/xtreme/internal/models"
+	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
+	log "github.com/sirupsen/logrus"
+)
+
+func (u *FManLocalUsecase) UpdateFileContent(user models.User, fileUUID string, contentReader io.Reader,
+	expected models.Checksums) (models.FileVersion, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "UpdateFileContent",
+		"fileUUID":  fileUUID,
+	})
+	logger.Debug("Start updating file content")
+	defer logger.Debug("Finish updating file content")
+	// Check the file before its content is stored.
+	file, err := u.readFile(logger, user, fileUUID, models.PermWrite)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	return u.saveNewVersion(logger, user, file, contentReader, expected)
+}
+
+func (u *FManLocalUsecase) ListFileVersions(user models.User, fileUUID string) ([]models.FileVersion, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "ListFileVersions",
+		"fileUUID":  fileUUID,
+	})
+	logger.Debug("Start listing file versions")
+	defer logger.Debug("Finish listing file versions")
+	if _, err := u.readFile(logger, user, fileUUID, models.PermRead); err != nil {
+		return nil, err
+	}
+	versions, err := u.dbVersionRepo.ListVersionRecords(fileUUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListVersionRecords", err)
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (u *FManLocalUsecase) DownloadFileVersion(user models.User, fileUUID string, version int) (models.File, io.ReadSeekCloser, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "DownloadFileVersion",
+		"fileUUID":  fileUUID,
+		"version":   version,
+	})
+	logger.Debug("Start downloading file version")
+	defer logger.Debug("Finish downloading file version")
+	file, err := u.readFile(logger, user, fileUUID, models.PermRead)
+	if err != nil {
+		return models.File{}, nil, err
+	}
+	v, err := u.dbVersionRepo.ReadVersionRecord(fileUUID, version)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadVersionRecord", err)
+		return models.File{}, nil, err
+	}
+	// The file is returned as it was when the version was its content.
+	file.Version = v.Version
+	file.FileSize = v.FileSize
+	file.ContentHash = v.ContentHash
+	file.ContentType = v.ContentType
+	file.StorageKey = v.StorageKey
+	file.RealPath = v.RealPath
+	file.UpdatedAt = v.CreatedAt
+	content := fileUtils.NewFileReadSeeker(u.fileOps, v.StorageKey, int64(v.FileSize))
+	return file, content, nil
+}
+
+func (u *FManLocalUsecase) RestoreFileVersion(user models.User, fileUUID string, version int) (models.FileVersion, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "RestoreFileVersion",
+		"fileUUID":  fileUUID,
+		"version":   version,
+	})
+	logger.Debug("Start restoring file version")
+	defer logger.Debug("Finish restoring file version")
+	file, err := u.readFile(logger, user, fileUUID, models.PermWrite)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	v, err := u.dbVersionRepo.ReadVersionRecord(fileUUID, version)
+	if err != nil {
+		errUtils.LogErr(logger, "ReadVersionRecord", err)
+		return models.FileVersion{}, err
+	}
+	if v.IsCurrent {
+		return v, nil
+	}
+	// The new version is counted in full, although it shares the content.
+	release, err := u.reserveQuota(logger, file.OwnerUUID, file.ParentUUID, int64(v.FileSize))
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	defer release()
+	// The old content becomes a new version, so the history in between is kept.
+	restored, err := u.dbVersionRepo.InsertVersionRecord(fileUUID, models.Blob{Hash: v.ContentHash, MD5: v.ContentMD5},
+		v.ContentType, user.UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "InsertVersionRecord", err)
+		return models.FileVersion{}, err
+	}
+	u.pruneVersionsOf(logger, fileUUID)
+	u.queueThumbnails(logger, fileUUID, file.Filename)
+	return restored, nil
+}
+
+func (u *FManLocalUsecase) SetFileVersionPolicy(user models.User, fileUUID string, policy models.VersionPolicy) error {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "SetFileVersionPolicy",
+		"fileUUID":  fileUUID,
+	})
+	logger.Debug("Start setting file version policy")
+	defer logger.Debug("Finish setting file version policy")
+	if policy.MaxVersions < 0 || policy.MaxAge < 0 {
+		logger.Info("[-USER-] version policy must not be negative")
+		return models.NewFManError(models.InvalidArgumentErrorCode, "version policy must not be negative")
+	}
+	if _, err := u.readFile(logger, user, fileUUID, models.PermWrite); err != nil {
+		return err
+	}
+	if err := u.dbVersionRepo.UpdateVersionPolicy(fileUUID, policy); err != nil {
+		errUtils.LogErr(logger, "UpdateVersionPolicy", err)
+		return err
+	}
+	// A stricter policy applies right away.
+	u.pruneVersionsOf(logger, fileUUID)
+	return nil
+}
+
+func (u *FManLocalUsecase) PurgeFileVersions(now time.Time) (int, error) {
+	logger := log.WithFields(log.Fields{
+		"Layer":     "usecase-local",
+		"Operation": "PurgeFileVersions",
+	})
+	logger.Debug("Start purging file versions")
+	defer logger.Debug("Finish purging file versions")
+	fileUUIDs, err := u.dbVersionRepo.ListVersionedFileRecords()
+	if err != nil {
+		errUtils.LogErr(logger, "ListVersionedFileRecords", err)
+		return 0, err
+	}
+	removed := 0
+	for _, fileUUID := range fileUUIDs {
+		file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			// The file was removed concurrently.
+			continue
+		}
+		if err != nil {
+			errUtils.LogErr(logger, "ReadFileRecord", err)
+			return removed, err
+		}
+		n, err := u.pruneVersions(logger, file, now)
+		if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+// versionedFile returns the file with a given name in a parent directory, which gets a new
+// version on an upload with the same name by a user. Its UUID is empty if there is no such
+// file or versioning is disabled.
+func (u *FManLocalUsecase) versionedFile(logger *log.Entry, user models.User, filename, parentUUID string) (models.File, error) {
+	if !u.opts.Versioning {
+		return models.File{}, nil
+	}
+	file, err := u.dbFileRepo.ReadFileRecordByName(filename, parentUUID)
+	if models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		return models.File{}, nil
+	}
+	if err != nil {
+		errUtils.LogErr(logger, "ReadFileRecordByName", err)
+		return models.File{}, err
+	}
+	if err := u.authorizeFile(logger, user, file, models.PermWrite); err != nil {
+		return models.File{}, err
+	}
+	return file, nil
+}
+
+// saveNewVersion saves content to the storage and adds it as a new version, uploaded by a
+// user, to a file, then removes the old versions which are not retained by the version policy.
+// The new version counts towards the quota of the file's owner, and its content must have
+// the expected checksums.
+func (u *FManLocalUsecase) saveNewVersion(logger *log.Entry, user models.User, file models.File,
+	contentReader io.Reader, expected models.Checksums) (models.FileVersion, error) {
+	fileUUID := file.UUID
+	blob, contentType, release, err := u.storeContent(logger, contentReader, file.OwnerUUID, file.ParentUUID,
+		file.Filename, path.Dir(file.Path), expected)
+	if err != nil {
+		return models.FileVersion{}, err
+	}
+	defer release()
+	version, err := u.dbVersionRepo.InsertVersionRecord(fileUUID, blob, contentType, user.UUID)
+	if err != nil {
+		u.removeContents(logger, []models.Blob{blob})
+		errUtils.LogErr(logger, "InsertVersionRecord", err)
+		return models.FileVersion{}, err
+	}
+	if version.StorageKey != blob.StorageKey {
+		// The same content is already stored, so the new copy is not needed.
+		logger.Debugf("Content %s is already stored as %s", version.ContentHash, version.StorageKey)
+		u.removeContents(logger, []models.Blob{blob})
+	}
+	u.pruneVersionsOf(logger, fileUUID)
+	u.indexFileContent(logger, fileUUID)
+	u.queueThumbnails(logger, fileUUID, file.Filename)
+	return version, nil
+}
+
+// pruneVersionsOf removes the old versions of a file which are not retained by its version
+// policy. Failures are only logged, as the versions are pruned again with the next change
+// or purge.
+func (u *FManLocalUsecase) pruneVersionsOf(logger *log.Entry, fileUUID string) {
+	file, err := u.dbFileRepo.ReadFileRecord(fileUUID)
+	if err == nil {
+		_, err = u.pruneVersions(logger, file, time.Now())
+	}
+	if err != nil && !models.IsFManErrorCode(err, models.NotFoundErrorCode) {
+		logger.Errorf("[-INTERNAL-] pruneVersions failed with error %s", err.Error())
+	}
+}
+
+// pruneVersions removes the old versions of a file which are not retained by its version
+// policy at a given time, together with their content unless other versions or files share
+// it. It returns the number of removed versions.
+func (u *FManLocalUsecase) pruneVersions(logger *log.Entry, file models.File, now time.Time) (int, error) {
+	policy := u.versionPolicy(file)
+	if policy.MaxVersions <= 0 && policy.MaxAge <= 0 {
+		return 0, nil
+	}
+	versions, err := u.dbVersionRepo.ListVersionRecords(file.UUID)
+	if err != nil {
+		errUtils.LogErr(logger, "ListVersionRecords", err)
+		return 0, err
+	}
+	var expired []int
+	for i, v := range versions {
+		if v.IsCurrent {
+			continue
+		}
+		switch {
+		case policy.MaxVersions > 0 && i >= policy.MaxVersions:
+			expired = append(expired, v.Version)
+		// An old version ages from the moment it was replaced by the next newer one.
+		case policy.MaxAge > 0 && i > 0 && now.Sub(versions[i-1].CreatedAt) > policy.MaxAge:
+			expired = append(expired, v.Version)
+		}
+	}
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	logger.Debugf("Removing %d old versions of file %s", len(expired), file.UUID)
+	blobs, err := u.dbVersionRepo.HardRemoveVersionRecords(file.UUID, expired)
+	if err != nil {
+		errUtils.LogErr(logger, "HardRemoveVersionRecords", err)
+		return 0, err
+	}
+	u.removeContents(logger, blobs)
+	return len(expired), nil
+}
+
+// versionPolicy returns the version policy in effect for a file. The fields of the file's
+// own policy override the global ones. Without versioning only the current version is kept.
+func (u *FManLocalUsecase) versionPolicy(file models.File) models.VersionPolicy {
+	if !u.opts.Versioning {
+		return models.VersionPolicy{MaxVersions: 1}
+	}
+	policy := u.opts.VersionPolicy
+	if file.VersionPolicy.MaxVersions > 0 {
+		policy.MaxVersions = file.VersionPolicy.MaxVersions
+	}
+	if file.VersionPolicy.MaxAge > 0 {
+		policy.MaxAge = file.VersionPolicy.MaxAge
+	}
+	return policy
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Permissions is a set of permissions on a file or a directory.
+type Permissions int
+
+// Permissions granted by access control entries. Write, delete and share include read,
+// and manage includes all other permissions.
+const (
+	// PermRead allows reading a file's content and versions, or listing a directory.
+	PermRead Permissions = 1 << iota
+
+	// PermWrite allows changing a file's content, renaming a file/dir, and creating files
+	// and directories in a directory.
+	PermWrite
+
+	// PermDelete allows moving a file/dir to the recycle bin, removing it permanently, and
+	// moving it to another directory.
+	PermDelete
+
+	// PermShare allows creating share links to a file/dir.
+	PermShare
+
+	// PermManage allows changing the access control entries and the quota of a file/dir.
+	PermManage
+
+	// PermAll holds all permissions, which the owner of a file/dir and admins have.
+	PermAll = PermRead | PermWrite | PermDelete | PermShare | PermManage
+)
+
+// permissionNames maps the permissions to their names in JSON.
+var permissionNames = []struct {
+	perm Permissions
+	name string
+}{
+	{PermRead, "read"},
+	{PermWrite, "write"},
+	{PermDelete, "delete"},
+	{PermShare, "share"},
+	{PermManage, "manage"},
+}
+
+// ParsePermissions returns the permissions with the given names together with the
+// permissions they include.
+func ParsePermissions(names []string) (Permissions, error) {
+	var p Permissions
+	for _, name := range names {
+		found := false
+		for _, pn := range permissionNames {
+			if pn.name == name {
+				p |= pn.perm
+				found = true
+			}
+		}
+		if !found {
+			return 0, NewFManError(InvalidArgumentErrorCode, fmt.Sprintf("unknown permission %s", name))
+		}
+	}
+	if p&PermManage != 0 {
+		p = PermAll
+	}
+	if p != 0 {
+		p |= PermRead
+	}
+	return p, nil
+}
+
+// Has checks if all permissions of q are in p.
+func (p Permissions) Has(q Permissions) bool {
+	return p&q == q
+}
+
+// Names returns the names of the permissions in p.
+func (p Permissions) Names() []string {
+	names := []string{}
+	for _, pn := range permissionNames {
+		if p.Has(pn.perm) {
+			names = append(names, pn.name)
+		}
+	}
+	return names
+}
+
+// MarshalJSON encodes the permissions as a list of names, e.g. ["read","write"].
+func (p Permissions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Names())
+}
+
+// UnmarshalJSON decodes the permissions from a list of names.
+func (p *Permissions) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	parsed, err := ParsePermissions(names)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+const (
+	// PrincipalUser denotes a user, who is granted permissions by an access control entry.
+	PrincipalUser = "user"
+
+	// PrincipalGroup denotes a group, whose members are granted permissions by an access
+	// control entry.
+	PrincipalGroup = "group"
+)
+
+// ACLEntry holds an access control entry, which grants permissions on a file or a
+// directory together with its subtree to a user or a group.
+//
+// The permissions of a user on a file/dir are given by the entries of the nearest item
+// with entries for the user, starting at the file/dir itself and walking up to the root.
+// An entry for the user itself overrides the entries for the user's groups on the same
+// item, whose permissions are combined otherwise. So an entry deeper in the tree, even
+// without any permissions, overrides the entries inherited from above.
+type ACLEntry struct {
+	// UUID of the file/dir.
+	ItemUUID string `json:"item_uuid"`
+
+	// Type of the item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// Type of the principal, PrincipalUser or PrincipalGroup.
+	PrincipalType string `json:"principal_type"`
+
+	// UUID of the user or the group.
+	PrincipalUUID string `json:"principal_uuid"`
+
+	// Permissions granted to the principal.
+	Permissions Permissions `json:"permissions"`
+
+	// UUID of the user who set the entry.
+	GrantedBy string `json:"granted_by"`
+
+	// Time of the last update of the entry.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Group holds properties of a group of users, who can be granted permissions together.
+type Group struct {
+	// UUID of the group.
+	UUID string `json:"uuid"`
+
+	// Name of the group, unique among all groups.
+	Name string `json:"name"`
+
+	// UUIDs of the members of the group.
+	MemberUUIDs []string `json:"member_uuids"`
+
+	// Time when the group is created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SharedEntry holds a file or a directory, which is shared with a user by an access
+// control entry, together with the user's permissions on it.
+type SharedEntry struct {
+	// Type of the item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// File shared with the user, nil if a directory is shared.
+	File *File `json:"file,omitempty"`
+
+	// Directory shared with the user, nil if a file is shared.
+	Directory *Directory `json:"directory,omitempty"`
+
+	// Permissions of the user on the item.
+	Permissions Permissions `json:"permissions"`
+}
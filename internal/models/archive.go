@@ -0,0 +1,76 @@
+package models
+
+const (
+	// ArchiveFormatZip is the ZIP format, which switches to ZIP64 for large content.
+	ArchiveFormatZip = "zip"
+
+	// ArchiveFormatTar is the uncompressed tar format.
+	ArchiveFormatTar = "tar"
+
+	// ArchiveFormatTarGz is the tar format compressed with gzip.
+	ArchiveFormatTarGz = "tar.gz"
+
+	// MaxArchiveItems is the maximum number of files/dirs selected for an archive at once.
+	MaxArchiveItems = 1000
+)
+
+// Archive holds properties of an archive of files and directories, which is built while it
+// is downloaded.
+type Archive struct {
+	// Name of the archive, e.g. "photos.zip".
+	Name string `json:"name"`
+
+	// Format of the archive, ArchiveFormatZip, ArchiveFormatTar or ArchiveFormatTarGz.
+	Format string `json:"format"`
+
+	// Number of files in the archive.
+	Files int `json:"files"`
+
+	// Number of directories in the archive.
+	Dirs int `json:"dirs"`
+
+	// Total size of the content of the files in bytes.
+	Bytes uint64 `json:"bytes"`
+}
+
+// IsArchiveFormat checks if a format is one of the supported archive formats.
+func IsArchiveFormat(format string) bool {
+	switch format {
+	case ArchiveFormatZip, ArchiveFormatTar, ArchiveFormatTarGz:
+		return true
+	}
+	return false
+}
+
+// ExtractionLimits limit the extraction of uploaded archives to defend against decompression
+// bombs.
+type ExtractionLimits struct {
+	// MaxEntries is the maximum number of entries of an archive, including the skipped ones.
+	MaxEntries int
+
+	// MaxBytes is the maximum total size in bytes of the extracted files.
+	MaxBytes int64
+
+	// MaxRatio is the maximum ratio of the total size of the extracted files to the size of
+	// the archive, e.g. 100.
+	MaxRatio float64
+}
+
+// Extraction holds the result of extracting an archive into a directory.
+type Extraction struct {
+	// Format of the archive, ArchiveFormatZip, ArchiveFormatTar or ArchiveFormatTarGz.
+	Format string `json:"format"`
+
+	// Number of extracted files, including the new versions of existing files.
+	Files int `json:"files"`
+
+	// Number of created directories. Existing directories are extracted into.
+	Dirs int `json:"dirs"`
+
+	// Total size of the extracted files in bytes.
+	Bytes uint64 `json:"bytes"`
+
+	// Paths of the skipped entries, which are neither files nor directories, e.g. symbolic
+	// links.
+	Skipped []string `json:"skipped,omitempty"`
+}
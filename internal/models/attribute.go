@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+const (
+	// MaxTagsPerItem is the maximum number of tags on a file or a directory.
+	MaxTagsPerItem = 64
+
+	// MaxMetadataPerItem is the maximum number of metadata keys on a file or a directory.
+	MaxMetadataPerItem = 64
+
+	// MaxMetadataValueLength is the maximum length of a metadata value in bytes.
+	MaxMetadataValueLength = 4096
+
+	// MaxBulkTagItems is the maximum number of files/dirs tagged at once.
+	MaxBulkTagItems = 1000
+)
+
+// ItemRef refers to a file or a directory.
+type ItemRef struct {
+	// Type of the item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// UUID of the file/dir.
+	UUID string `json:"uuid"`
+}
+
+// MetadataEntry holds a user-defined key/value attribute of a file or a directory.
+type MetadataEntry struct {
+	// Key of the attribute, unique per file/dir.
+	Key string `json:"key"`
+
+	// Value of the attribute.
+	Value string `json:"value"`
+
+	// Time of the last update of the attribute.
+	UpdatedAt time.Time `json:"updated_at"`
+}
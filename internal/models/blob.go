@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Blob holds properties of a piece of content in the storage, which is shared by all
+// files with the same content.
+type Blob struct {
+	// Hex-encoded SHA-256 hash of the content.
+	Hash string `json:"hash"`
+
+	// Hex-encoded MD5 digest of the content, empty for content stored before it was
+	// computed.
+	MD5 string `json:"md5"`
+
+	// Name of the content in the storage.
+	StorageKey string `json:"-"`
+
+	// Real path of the content, where it is logically stored in the disk.
+	RealPath string `json:"-"`
+
+	// Size of the content in bytes.
+	Size int64 `json:"size"`
+
+	// Number of bytes the content takes up in the storage, which is less than its size
+	// if it is compressed.
+	StoredSize int64 `json:"stored_size"`
+
+	// Time when the content is stored.
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "fmt"
+
+// Checksums holds the hex-encoded digests of a content, which a client expects it to have.
+// Empty digests are not checked.
+type Checksums struct {
+	SHA256 string
+	MD5    string
+}
+
+// Verify checks if the digests of a blob match the expected ones.
+func (c Checksums) Verify(blob Blob) error {
+	if c.SHA256 != "" && c.SHA256 != blob.Hash {
+		return NewFManError(InvalidArgumentErrorCode,
+			fmt.Sprintf("SHA-256 digest of the content is %s, not %s as expected", blob.Hash, c.SHA256))
+	}
+	if c.MD5 != "" && c.MD5 != blob.MD5 {
+		return NewFManError(InvalidArgumentErrorCode,
+			fmt.Sprintf("MD5 digest of the content is %s, not %s as expected", blob.MD5, c.MD5))
+	}
+	return nil
+}
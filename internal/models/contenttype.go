@@ -0,0 +1,56 @@
+package models
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// ContentTypePolicy restricts the MIME types of uploaded content. Types are given without
+// parameters, e.g. image/png, or as wildcards of all subtypes, e.g. image/*.
+type ContentTypePolicy struct {
+	// Allowed types. Empty allows all types, which are not blocked.
+	Allowed []string
+
+	// Blocked types, which are rejected even if they are allowed.
+	Blocked []string
+}
+
+// Allows checks if a policy allows a content type. The parameters of the type, e.g. its
+// charset, are ignored.
+func (p ContentTypePolicy) Allows(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if matchContentType(p.Blocked, mediaType) {
+		return false
+	}
+	return len(p.Allowed) == 0 || matchContentType(p.Allowed, mediaType)
+}
+
+// Validate checks if all types of a policy are well-formed.
+func (p ContentTypePolicy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.Allowed...), p.Blocked...) {
+		parts := strings.Split(pattern, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[0] == "*" || parts[1] == "" ||
+			strings.ContainsAny(pattern, " ;") {
+			return NewFManError(InvalidArgumentErrorCode, fmt.Sprintf("%q is not a valid content type", pattern))
+		}
+	}
+	return nil
+}
+
+// matchContentType checks if a media type without parameters matches one of the types.
+func matchContentType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
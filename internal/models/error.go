@@ -1,6 +1,7 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -9,12 +10,68 @@ const (
 	InternalServerErrorMessage = "Oops! Something wrong happened in our server."
 )
 
+// Error codes of FManError.
+const (
+	// InternalErrorCode indicates an unexpected failure inside xtreme.
+	InternalErrorCode = iota + 1
+
+	// NotFoundErrorCode indicates that a requested file/dir does not exist.
+	NotFoundErrorCode
+
+	// AlreadyExistErrorCode indicates that a file/dir name is already taken in a location.
+	AlreadyExistErrorCode
+
+	// InvalidArgumentErrorCode indicates that an operation was called with invalid input.
+	InvalidArgumentErrorCode
+
+	// ConflictErrorCode indicates that an operation does not match the current state of
+	// a resource, e.g. a chunk sent for another offset of a resumable upload.
+	ConflictErrorCode
+
+	// TooLargeErrorCode indicates that a content is larger than allowed.
+	TooLargeErrorCode
+
+	// UnauthorizedErrorCode indicates that the credentials or the token of a user are
+	// missing or invalid.
+	UnauthorizedErrorCode
+
+	// ForbiddenErrorCode indicates that a user is not allowed to access a file/dir.
+	ForbiddenErrorCode
+
+	// QuotaExceededErrorCode indicates that a content does not fit into the storage quota
+	// of a user or a directory.
+	QuotaExceededErrorCode
+
+	// ExpiredErrorCode indicates that a resource cannot be used anymore, e.g. a share link
+	// which expired or reached its download limit.
+	ExpiredErrorCode
+
+	// UnsupportedMediaTypeErrorCode indicates that the MIME type of a content is not
+	// allowed in a location.
+	UnsupportedMediaTypeErrorCode
+)
+
 type FManError struct {
 	Code    int
 	Message string
 	ErrTime time.Time
 }
 
+// NewFManError returns a new FManError with a given code and message.
+func NewFManError(code int, message string) FManError {
+	return FManError{
+		Code:    code,
+		Message: message,
+		ErrTime: time.Now(),
+	}
+}
+
 func (e FManError) Error() string {
 	return fmt.Sprintf("Error code: %d. Error message: %s. Time: %s", e.Code, e.Message, e.ErrTime.String())
 }
+
+// IsFManErrorCode checks if an error is a FManError with a given code.
+func IsFManErrorCode(err error, code int) bool {
+	var fmanErr FManError
+	return errors.As(err, &fmanErr) && fmanErr.Code == code
+}
@@ -4,56 +4,96 @@ import (
 	"time"
 )
 
+const (
+	// RootDirUUID is the UUID of the global root directory, which is created when the
+	// database is initialized. Users get root directories of their own.
+	RootDirUUID = "00000000-0000-0000-0000-000000000000"
+
+	// RootDirPath is the human-readable path of the root directory.
+	RootDirPath = "/"
+)
+
 // File holds properties of a File.
 type File struct {
 	// UUID of the file.
-	UUID string
+	UUID string `json:"uuid"`
 
 	// Name of the file.
-	Filename string
+	Filename string `json:"filename"`
 
 	// Human-readable path of the file.
-	Path string
+	Path string `json:"path"`
 
 	// Real path of the file, where it is logically stored in the disk.
-	RealPath string
+	RealPath string `json:"-"`
+
+	// Hex-encoded SHA-256 hash of the content of the file, which identifies its Blob.
+	ContentHash string `json:"sha256"`
+
+	// Hex-encoded MD5 digest of the content, empty for content stored before it was computed.
+	ContentMD5 string `json:"md5"`
+
+	// Name of the content of the file in the storage.
+	StorageKey string `json:"-"`
+
+	// Number of the current version of the content.
+	Version int `json:"version"`
+
+	// Policy limiting the retained old versions of the file. Zero fields fall back to
+	// the global policy.
+	VersionPolicy VersionPolicy `json:"version_policy"`
 
 	// Parent directory UUID.
-	ParentUUID string
+	ParentUUID string `json:"parent_uuid"`
+
+	// UUID of the user owning the file, empty for files created before users existed.
+	OwnerUUID string `json:"owner_uuid"`
 
 	// Size of the file.
-	FileSize uint64
+	FileSize uint64 `json:"file_size"`
+
+	// Number of bytes the content takes up in the storage, e.g. after compression. It is
+	// not counted towards quotas, which count FileSize.
+	StoredSize uint64 `json:"stored_size"`
+
+	// MIME type of the content, detected when it was uploaded. Empty for content uploaded
+	// before detection existed.
+	ContentType string `json:"content_type"`
 
 	// Time when the file is created.
-	CreatedAt time.Time
+	CreatedAt time.Time `json:"created_at"`
 
 	// Time of the last file update.
-	UpdatedAt time.Time
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Directory holds properties of a directory/folder.
 type Directory struct {
 	// UUID of the directory.
-	UUID string
+	UUID string `json:"uuid"`
 
 	// Name of the directory.
-	Dirname string
+	Dirname string `json:"dirname"`
 
 	// Human-readable path of the directory.
-	Path string
+	Path string `json:"path"`
 
-	// Parent directory UUID.
-	ParentUUID string
+	// Parent directory UUID, empty for root directories.
+	ParentUUID string `json:"parent_uuid"`
+
+	// UUID of the user owning the directory, empty for directories created before users
+	// existed.
+	OwnerUUID string `json:"owner_uuid"`
 
 	// Time when the directory is created.
-	CreatedAt time.Time
+	CreatedAt time.Time `json:"created_at"`
 
 	// Time of the last directory update.
-	UpdatedAt time.Time
+	UpdatedAt time.Time `json:"updated_at"`
 
 	// A list of child-files.
-	ListOfFiles []File
+	ListOfFiles []File `json:"files,omitempty"`
 
 	// A list of child-dirs.
-	ListOfDirs []Directory
+	ListOfDirs []Directory `json:"dirs,omitempty"`
 }
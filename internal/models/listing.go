@@ -0,0 +1,53 @@
+package models
+
+const (
+	// SortByName sorts entries of a directory listing by name in natural order (e.g. a2 < a10).
+	SortByName = "name"
+
+	// SortBySize sorts entries of a directory listing by size. Directories have size 0.
+	SortBySize = "size"
+
+	// SortByCreatedAt sorts entries of a directory listing by creation time.
+	SortByCreatedAt = "created_at"
+
+	// SortByUpdatedAt sorts entries of a directory listing by the last update time.
+	SortByUpdatedAt = "updated_at"
+)
+
+const (
+	// EntryTypeFile denotes a file in a directory listing.
+	EntryTypeFile = "file"
+
+	// EntryTypeDir denotes a directory in a directory listing.
+	EntryTypeDir = "dir"
+)
+
+const (
+	// DefaultListLimit is the number of entries in a listing page if no limit is given.
+	DefaultListLimit = 100
+
+	// MaxListLimit is the maximum number of entries in a listing page.
+	MaxListLimit = 1000
+)
+
+// DirListOptions holds options for listing the children of a directory.
+// Directories are always listed before files.
+type DirListOptions struct {
+	// Sort key, one of SortByName, SortBySize, SortByCreatedAt or SortByUpdatedAt.
+	SortBy string
+
+	// Sort in descending order.
+	Desc bool
+
+	// Only list entries of a type, EntryTypeFile or EntryTypeDir. Empty means both.
+	Type string
+
+	// Only list entries whose names start with NamePrefix (case-sensitive).
+	NamePrefix string
+
+	// Maximum number of entries in a page.
+	Limit int
+
+	// Cursor returned with the previous page. Empty means the first page.
+	Cursor string
+}
@@ -0,0 +1,27 @@
+package models
+
+// Progress holds the progress of an operation on a directory/folder and its subtree.
+type Progress struct {
+	// Number of files processed so far.
+	FilesDone int `json:"files_done"`
+
+	// Number of files in the subtree.
+	FilesTotal int `json:"files_total"`
+
+	// Number of bytes processed so far.
+	BytesDone uint64 `json:"bytes_done"`
+
+	// Total size of the files in the subtree.
+	BytesTotal uint64 `json:"bytes_total"`
+
+	// Number of contents removed from the storage so far, only reported by removals once
+	// all files are processed.
+	BlobsDone int `json:"blobs_done,omitempty"`
+
+	// Number of contents of the removed files which are not used by any other file.
+	BlobsTotal int `json:"blobs_total,omitempty"`
+}
+
+// ProgressFunc is called with the progress of an operation after each processed file.
+// It is called from the goroutine running the operation, so it should return quickly.
+type ProgressFunc func(Progress)
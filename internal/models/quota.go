@@ -0,0 +1,104 @@
+package models
+
+import "time"
+
+// Quota holds the storage limit of a user or a directory together with the bytes used.
+// The bytes used are the sizes of all versions of the files counted, so content shared by
+// files through deduplication is counted once per version.
+type Quota struct {
+	// LimitBytes is the maximum number of bytes. Zero means no limit.
+	LimitBytes int64 `json:"limit_bytes"`
+
+	// UsedBytes is the number of bytes used.
+	UsedBytes int64 `json:"used_bytes"`
+}
+
+// Available returns the number of bytes which can still be added, or -1 if there is no limit.
+func (q Quota) Available() int64 {
+	if q.LimitBytes <= 0 {
+		return -1
+	}
+	if q.UsedBytes >= q.LimitBytes {
+		return 0
+	}
+	return q.LimitBytes - q.UsedBytes
+}
+
+// DirQuota holds the quota of a directory, which limits the bytes used by the files in its
+// subtree outside of the recycle bin.
+type DirQuota struct {
+	// UUID of the directory.
+	DirUUID string `json:"dir_uuid"`
+
+	// Path of the directory.
+	Path string `json:"path"`
+
+	Quota
+}
+
+// Usage holds the quota of a user and the bytes used by the user's files, the recycle bin
+// included.
+type Usage struct {
+	// UUID of the user.
+	UserUUID string `json:"user_uuid"`
+
+	Quota
+
+	// Directories lists the quotas of the user's directories, which have one.
+	Directories []DirQuota `json:"directories"`
+}
+
+// UsageCorrection holds the bytes used by a user, which were counted wrong, before and
+// after being recomputed.
+type UsageCorrection struct {
+	// UUID of the user.
+	UserUUID string `json:"user_uuid"`
+
+	// OldUsedBytes is the number of bytes counted before.
+	OldUsedBytes int64 `json:"old_used_bytes"`
+
+	// NewUsedBytes is the recomputed number of bytes.
+	NewUsedBytes int64 `json:"new_used_bytes"`
+}
+
+// StorageStats holds statistics of the contents in the storage. Content shared by files
+// through deduplication is counted once.
+type StorageStats struct {
+	// Blobs is the number of stored contents.
+	Blobs int64 `json:"blobs"`
+
+	// CompressedBlobs is the number of contents, which take up fewer bytes in the storage
+	// than their size.
+	CompressedBlobs int64 `json:"compressed_blobs"`
+
+	// LogicalBytes is the total size of the contents.
+	LogicalBytes int64 `json:"logical_bytes"`
+
+	// StoredBytes is the number of bytes the contents take up in the storage.
+	StoredBytes int64 `json:"stored_bytes"`
+
+	// SavedBytes is the number of bytes saved by compression, which is LogicalBytes minus
+	// StoredBytes. It is negative if the storage adds more, e.g. for encryption, than
+	// compression saves.
+	SavedBytes int64 `json:"saved_bytes"`
+}
+
+// QuotaReservation holds bytes of the quotas of an owner and of a directory and its
+// ancestors, which are taken up by files being added, until the files are counted as used.
+type QuotaReservation struct {
+	// UUID of the reservation.
+	UUID string `json:"uuid"`
+
+	// UUID of the owner of the files.
+	OwnerUUID string `json:"owner_uuid"`
+
+	// UUID of the directory of the files.
+	DirUUID string `json:"dir_uuid"`
+
+	// Size is the number of bytes reserved.
+	Size int64 `json:"size"`
+
+	// ExpiresAt is when the reservation is dropped, if it was not released before, e.g. by a
+	// crashed server.
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// DefaultSearchLimit is the number of results in a search page if no limit is given.
+	DefaultSearchLimit = 20
+
+	// MaxSearchLimit is the maximum number of results in a search page.
+	MaxSearchLimit = 100
+
+	// MaxIndexedContentSize is the maximum number of bytes of a content, which are indexed
+	// for the search. The rest of the content cannot be found.
+	MaxIndexedContentSize = 1 << 20
+
+	// MaxSearchTokenLength is the maximum number of characters of a search token. Longer
+	// words are cut to this length, both when indexing and when searching.
+	MaxSearchTokenLength = 64
+)
+
+// SearchTerm holds a term of a search query, which is either a single word or a phrase of
+// words, which must follow each other.
+type SearchTerm struct {
+	// Lowercase words of the term.
+	Words []string
+
+	// Match the last word as a prefix, e.g. "rep" matches "report".
+	Prefix bool
+}
+
+// SearchOptions holds the filters and the page of a search. Zero values do not filter.
+type SearchOptions struct {
+	// Only find entries of a type, EntryTypeFile or EntryTypeDir.
+	Type string
+
+	// Only find files of at least MinSize bytes.
+	MinSize int64
+
+	// Only find files of at most MaxSize bytes.
+	MaxSize int64
+
+	// Only find files/dirs updated at or after UpdatedAfter.
+	UpdatedAfter time.Time
+
+	// Only find files/dirs updated before UpdatedBefore.
+	UpdatedBefore time.Time
+
+	// Only find files/dirs owned by a user.
+	OwnerUUID string
+
+	// Only find files/dirs beneath a directory.
+	UnderDirUUID string
+
+	// Only find files/dirs carrying a tag.
+	Tag string
+
+	// Maximum number of results in a page.
+	Limit int
+
+	// Number of results skipped before the page.
+	Offset int
+}
+
+// SearchQuery holds a parsed search query together with its options.
+type SearchQuery struct {
+	SearchOptions
+
+	// Terms, which must all match a file/dir. No terms match all files/dirs, e.g. to list
+	// the files/dirs carrying a tag.
+	Terms []SearchTerm
+
+	// Only find files/dirs which are one of these files/dirs or lie beneath one of these
+	// directories. Empty means everywhere.
+	ScopeUUIDs []string
+}
+
+// SearchHit holds a file or a directory found by a search.
+type SearchHit struct {
+	// Type of the item, EntryTypeFile or EntryTypeDir.
+	ItemType string
+
+	// UUID of the file/dir.
+	UUID string
+
+	// Relevance of the file/dir, higher is better.
+	Score int
+}
+
+// SearchResult holds a file or a directory found by a search together with its relevance.
+type SearchResult struct {
+	// Type of the item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// File found, nil if a directory is found.
+	File *File `json:"file,omitempty"`
+
+	// Directory found, nil if a file is found.
+	Directory *Directory `json:"directory,omitempty"`
+
+	// Relevance of the file/dir, higher is better.
+	Score int `json:"score"`
+}
+
+// SearchTokens splits a text into lowercase words, which are runs of letters and digits.
+func SearchTokens(text string) []string {
+	var tokens []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if runes := []rune(word); len(runes) > MaxSearchTokenLength {
+			word = string(runes[:MaxSearchTokenLength])
+		}
+		tokens = append(tokens, word)
+	}
+	return tokens
+}
+
+// ParseSearchQuery parses a search query into terms. Words in double quotes form a phrase,
+// as do words joined by punctuation, e.g. annual-report. A trailing * matches the last
+// word of a term as a prefix, e.g. rep* or "annual rep*".
+func ParseSearchQuery(query string) []SearchTerm {
+	var terms []SearchTerm
+	addTerm := func(text string) {
+		prefix := strings.HasSuffix(text, "*")
+		words := SearchTokens(text)
+		if len(words) > 0 {
+			terms = append(terms, SearchTerm{Words: words, Prefix: prefix})
+		}
+	}
+	for i, part := range strings.Split(query, `"`) {
+		if i%2 == 1 {
+			// A quoted phrase, or the rest of the query after an unclosed quote.
+			addTerm(part)
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			addTerm(field)
+		}
+	}
+	return terms
+}
@@ -0,0 +1,89 @@
+package models
+
+import "time"
+
+const (
+	// ShareModeRead lets the holders of a share link browse and download the shared
+	// file/dir.
+	ShareModeRead = "read"
+
+	// ShareModeUpload lets the holders of a share link also upload new files into the
+	// shared directory and its subdirectories.
+	ShareModeUpload = "upload"
+)
+
+// ShareLink holds properties of a link, which gives anyone holding its token access to
+// a file or a directory together with its subtree without logging in.
+type ShareLink struct {
+	// UUID of the link.
+	UUID string `json:"uuid"`
+
+	// Token identifying the link in its URL. It is random and cannot be guessed.
+	Token string `json:"token"`
+
+	// UUID of the shared file/dir.
+	ItemUUID string `json:"item_uuid"`
+
+	// Type of the shared item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// UUID of the user who created the link.
+	OwnerUUID string `json:"owner_uuid"`
+
+	// bcrypt hash of the password, empty if the link is not protected by a password.
+	PasswordHash string `json:"-"`
+
+	// HasPassword is true if the link is protected by a password.
+	HasPassword bool `json:"has_password"`
+
+	// Mode of the link, ShareModeRead or ShareModeUpload.
+	Mode string `json:"mode"`
+
+	// MaxDownloads is the maximum number of file downloads through the link. Zero means
+	// no limit.
+	MaxDownloads int `json:"max_downloads"`
+
+	// Downloads is the number of file downloads through the link so far.
+	Downloads int `json:"downloads"`
+
+	// Time after which the link cannot be used anymore, nil if it does not expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// Time when the link is created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SharedItem holds a share link together with the shared file or directory, as seen by
+// the holders of the link.
+type SharedItem struct {
+	// Link through which the item is shared.
+	Link ShareLink `json:"link"`
+
+	// File shared by the link, nil if a directory is shared.
+	File *File `json:"file,omitempty"`
+
+	// Directory shared by the link without its children, nil if a file is shared.
+	Directory *Directory `json:"directory,omitempty"`
+}
+
+// ShareLinkOptions holds options for creating a share link.
+type ShareLinkOptions struct {
+	// UUID of the file/dir to share.
+	ItemUUID string
+
+	// Type of the item to share, EntryTypeFile or EntryTypeDir.
+	ItemType string
+
+	// Password protecting the link. Empty means no password.
+	Password string
+
+	// Mode of the link, ShareModeRead or ShareModeUpload. Empty means ShareModeRead.
+	Mode string
+
+	// MaxDownloads is the maximum number of file downloads through the link. Zero means
+	// no limit.
+	MaxDownloads int
+
+	// Time after which the link cannot be used anymore. Zero means it does not expire.
+	ExpiresAt time.Time
+}
@@ -0,0 +1,64 @@
+package models
+
+import "time"
+
+// Sizes of thumbnails.
+const (
+	ThumbnailSizeSmall  = "small"
+	ThumbnailSizeMedium = "medium"
+	ThumbnailSizeLarge  = "large"
+
+	// DefaultThumbnailSize is the size of a thumbnail if none is requested.
+	DefaultThumbnailSize = ThumbnailSizeMedium
+)
+
+const (
+	// MaxThumbnailSourceSize is the maximum size in bytes of an image file getting
+	// thumbnails.
+	MaxThumbnailSourceSize = 50 << 20
+
+	// MaxThumbnailSourcePixels is the maximum number of pixels of an image getting
+	// thumbnails, which limits the memory used to decode it.
+	MaxThumbnailSourcePixels = 50_000_000
+)
+
+// ThumbnailSizes maps the sizes of thumbnails to the maximum width and height in pixels of
+// their images. Images are scaled down to fit, keeping their aspect ratio, but never up.
+var ThumbnailSizes = map[string]int{
+	ThumbnailSizeSmall:  128,
+	ThumbnailSizeMedium: 256,
+	ThumbnailSizeLarge:  512,
+}
+
+// Thumbnail holds properties of a scaled-down image of a version of an image file.
+type Thumbnail struct {
+	// UUID of the file.
+	FileUUID string `json:"file_uuid"`
+
+	// Number of the version of the file's content the thumbnail shows.
+	Version int `json:"version"`
+
+	// Size of the thumbnail, one of the keys of ThumbnailSizes.
+	Size string `json:"size"`
+
+	// Name of the image in the storage.
+	StorageKey string `json:"-"`
+
+	// Real path of the image, where it is logically stored in the disk.
+	RealPath string `json:"-"`
+
+	// MIME type of the image, image/png or image/jpeg.
+	ContentType string `json:"content_type"`
+
+	// Width of the image in pixels.
+	Width int `json:"width"`
+
+	// Height of the image in pixels.
+	Height int `json:"height"`
+
+	// Size of the image in bytes.
+	FileSize int64 `json:"file_size"`
+
+	// Time when the thumbnail is created.
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+const (
+	// RestoreConflictFail makes a restore fail if the name is already taken in the destination.
+	RestoreConflictFail = "fail"
+
+	// RestoreConflictRename restores an entry under a free name, e.g. "report (1).pdf",
+	// if the name is already taken in the destination.
+	RestoreConflictRename = "rename"
+)
+
+// TrashEntry holds a file or a directory together with its subtree, which was moved
+// to the recycle bin.
+type TrashEntry struct {
+	// UUID of the entry.
+	UUID string `json:"uuid"`
+
+	// UUID of the removed file/dir.
+	ItemUUID string `json:"item_uuid"`
+
+	// Type of the removed item, EntryTypeFile or EntryTypeDir.
+	ItemType string `json:"item_type"`
+
+	// Name of the removed item.
+	Name string `json:"name"`
+
+	// UUID of the directory the item was removed from.
+	OriginalParentUUID string `json:"original_parent_uuid"`
+
+	// Human-readable path of the item when it was removed.
+	OriginalPath string `json:"original_path"`
+
+	// UUID of the user owning the removed item.
+	OwnerUUID string `json:"owner_uuid"`
+
+	// Time when the item was moved to the recycle bin.
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// RestoreOptions holds options for restoring an entry from the recycle bin.
+type RestoreOptions struct {
+	// Directory to restore the item into. Empty means the original parent directory.
+	ParentUUID string
+
+	// Name to restore the item with. Empty means the original name.
+	Name string
+
+	// What to do if the name is already taken, RestoreConflictFail or RestoreConflictRename.
+	// Empty means RestoreConflictFail.
+	OnConflict string
+}
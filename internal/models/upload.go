@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Upload holds properties of a resumable upload, whose content arrives in chunks.
+type Upload struct {
+	// UUID of the upload.
+	UUID string `json:"uuid"`
+
+	// Name of the file to be created.
+	Filename string `json:"filename"`
+
+	// UUID of the directory the file is created in.
+	ParentUUID string `json:"parent_uuid"`
+
+	// UUID of the user who created the upload.
+	OwnerUUID string `json:"owner_uuid"`
+
+	// Size of the whole content in bytes.
+	Length int64 `json:"length"`
+
+	// Number of bytes received so far.
+	Offset int64 `json:"offset"`
+
+	// Metadata sent by the client when the upload was created, in the format of the
+	// tus Upload-Metadata header.
+	Metadata string `json:"metadata"`
+
+	// UUID of the created file once the upload is complete, empty before.
+	FileUUID string `json:"file_uuid,omitempty"`
+
+	// Time when the upload is created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time after which the upload cannot be continued anymore.
+	ExpiresAt time.Time `json:"expires_at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import "time"
+
+// User holds properties of a user account.
+type User struct {
+	// UUID of the user.
+	UUID string `json:"uuid"`
+
+	// Name the user logs in with, unique among all users.
+	Username string `json:"username"`
+
+	// bcrypt hash of the password.
+	PasswordHash string `json:"-"`
+
+	// UUID of the root directory of the user, which is created together with the user.
+	RootDirUUID string `json:"root_dir_uuid"`
+
+	// IsAdmin is true for users who can access the files and directories of all users.
+	IsAdmin bool `json:"is_admin"`
+
+	// Time when the user is created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time of the last user update.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RefreshToken holds a refresh token of a user, which can be exchanged for new tokens
+// once. Only its UUID is stored, the token itself is a signed JWT.
+type RefreshToken struct {
+	// UUID of the token, which is the jti claim of the JWT.
+	UUID string `json:"uuid"`
+
+	// UUID of the user the token is issued to.
+	UserUUID string `json:"user_uuid"`
+
+	// Time when the token is issued.
+	CreatedAt time.Time `json:"created_at"`
+
+	// Time after which the token cannot be used anymore.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenPair holds the tokens issued on login or refresh.
+type TokenPair struct {
+	// AccessToken authenticates requests in the Authorization header as a Bearer token.
+	AccessToken string `json:"access_token"`
+
+	// RefreshToken can be exchanged for a new TokenPair once.
+	RefreshToken string `json:"refresh_token"`
+
+	// TokenType is always Bearer.
+	TokenType string `json:"token_type"`
+
+	// ExpiresIn is the number of seconds the access token is valid for.
+	ExpiresIn int64 `json:"expires_in"`
+}
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FileVersion holds properties of a version of a file's content.
+type FileVersion struct {
+	// UUID of the file.
+	FileUUID string `json:"file_uuid"`
+
+	// Number of the version, starting at 1 for the first content of the file.
+	Version int `json:"version"`
+
+	// Size of the content.
+	FileSize uint64 `json:"file_size"`
+
+	// Number of bytes the content takes up in the storage, e.g. after compression.
+	StoredSize uint64 `json:"stored_size"`
+
+	// MIME type of the content, detected when it was uploaded.
+	ContentType string `json:"content_type"`
+
+	// Hex-encoded SHA-256 hash of the content, which identifies its Blob.
+	ContentHash string `json:"sha256"`
+
+	// Hex-encoded MD5 digest of the content, empty for content stored before it was computed.
+	ContentMD5 string `json:"md5"`
+
+	// Name of the content in the storage.
+	StorageKey string `json:"-"`
+
+	// Real path of the content, where it is logically stored in the disk.
+	RealPath string `json:"-"`
+
+	// UUID of the user who uploaded the content, empty if unknown.
+	UploadedBy string `json:"uploaded_by"`
+
+	// Time when the version is created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// IsCurrent is true for the version which is the content of the file.
+	IsCurrent bool `json:"is_current"`
+}
+
+// VersionPolicy limits the old versions which are retained of a file. The current
+// version is always retained. Zero fields do not limit anything.
+type VersionPolicy struct {
+	// MaxVersions is the maximum number of retained versions including the current one.
+	MaxVersions int
+
+	// MaxAge is how long an old version is retained after it was replaced by a newer one.
+	MaxAge time.Duration
+}
+
+// versionPolicyJSON is the JSON format of VersionPolicy, where MaxAge is a duration
+// string like "720h".
+type versionPolicyJSON struct {
+	MaxVersions int    `json:"max_versions"`
+	MaxAge      string `json:"max_age"`
+}
+
+// MarshalJSON encodes a VersionPolicy with its MaxAge as a duration string.
+func (p VersionPolicy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(versionPolicyJSON{MaxVersions: p.MaxVersions, MaxAge: p.MaxAge.String()})
+}
+
+// UnmarshalJSON decodes a VersionPolicy with its MaxAge as a duration string. An empty
+// MaxAge means zero.
+func (p *VersionPolicy) UnmarshalJSON(data []byte) error {
+	var v versionPolicyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var maxAge time.Duration
+	if v.MaxAge != "" {
+		var err error
+		if maxAge, err = time.ParseDuration(v.MaxAge); err != nil {
+			return err
+		}
+	}
+	*p = VersionPolicy{MaxVersions: v.MaxVersions, MaxAge: maxAge}
+	return nil
+}
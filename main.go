@@ -1,26 +1,43 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/nvthongswansea/xtreme/internal/auth"
+	authRestful "github.com/nvthongswansea/xtreme/internal/auth/delivery/restful"
+	_authUC "github.com/nvthongswansea/xtreme/internal/auth/usecase"
+	"github.com/nvthongswansea/xtreme/internal/fman"
 	restful "github.com/nvthongswansea/xtreme/internal/fman/delivery/restful"
 	_fmanRepo "github.com/nvthongswansea/xtreme/internal/fman/repo"
 	_fmanUC "github.com/nvthongswansea/xtreme/internal/fman/usecase"
+	"github.com/nvthongswansea/xtreme/internal/models"
 	fileUtils "github.com/nvthongswansea/xtreme/pkg/file-utils"
 	uuidUtils "github.com/nvthongswansea/xtreme/pkg/uuid-utils"
 	log "github.com/sirupsen/logrus"
 )
 
 var configFilePath string
+var recomputeUsage bool
+var reindexSearch bool
+var rotateMasterKey bool
 var xtremeCfg *Config
 
-func init() {
+// loadConfig parses the command line args and reads the config file they name.
+func loadConfig() {
 	// Get config file path from cmd args
 	flag.StringVar(&configFilePath, "config_file", "", "Path of the config file")
+	flag.BoolVar(&recomputeUsage, "recompute_usage", false, "Recount the bytes used by every user and exit")
+	flag.BoolVar(&reindexSearch, "reindex_search", false, "Index the text of files not indexed for the search yet and exit")
+	flag.BoolVar(&rotateMasterKey, "rotate_master_key", false, "Encrypt the data keys of all files with the current master key and exit")
 	flag.Parse()
 	if configFilePath == "" {
 		fmt.Println("config_file arg is missing!")
@@ -54,14 +71,253 @@ func init() {
 	}
 }
 
+// fmanRepo combines all repositories used by the file manager and the authentication.
+type fmanRepo interface {
+	fman.FManFileDBRepo
+	fman.FManDirDBRepo
+	fman.FManValidateDBRepo
+	fman.FManTrashDBRepo
+	fman.FManUploadDBRepo
+	fman.FManVersionDBRepo
+	fman.FManQuotaDBRepo
+	fman.FManShareDBRepo
+	fman.FManACLDBRepo
+	fman.FManSearchDBRepo
+	fman.FManAttributeDBRepo
+	fman.FManThumbnailDBRepo
+	auth.AuthUserDBRepo
+	auth.AuthTokenDBRepo
+}
+
+// newFManRepo returns the file manager repository selected in the database config.
+func newFManRepo(cfg DatabaseConfig) (fmanRepo, error) {
+	switch cfg.Driver {
+	case "", "sqlite":
+		if cfg.DSN == "" {
+			return nil, errors.New("database.dsn is missing")
+		}
+		return _fmanRepo.NewFManSQLiteRepo(cfg.DSN)
+	case "postgres":
+		if cfg.DSN == "" {
+			return nil, errors.New("database.dsn is missing")
+		}
+		return _fmanRepo.NewFManPostgresRepo(cfg.DSN)
+	case "memory":
+		return _fmanRepo.NewFManMemoryRepo(), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", cfg.Driver)
+	}
+}
+
+// newFileOps returns the storage of file content selected in the storage config, which
+// encrypts the content if the encryption is enabled, and compresses it before if the
+// compression is enabled, as encrypted content does not compress.
+func newFileOps(cfg StorageConfig, uploadDir string) (fileUtils.FileSaveReadRemover, error) {
+	fileOps, err := newStorage(cfg, uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Encryption.Enabled {
+		if fileOps, err = newEncryptedStorage(fileOps, cfg.Encryption); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Compression.Enabled {
+		return fileUtils.CreateNewCompressedFileOperator(fileOps, cfg.Compression.MinSavings)
+	}
+	return fileOps, nil
+}
+
+// newEncryptedStorage returns a storage encrypting the content of another one with the master
+// keys in the encryption config.
+func newEncryptedStorage(fileOps fileUtils.FileSaveReadRemover, cfg EncryptionConfig) (fileUtils.FileSaveReadRemover, error) {
+	current, err := readMasterKey(cfg.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, keyCfg := range cfg.PreviousMasterKeys {
+		key, err := readMasterKey(keyCfg)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return fileUtils.CreateNewEncryptedFileOperator(fileOps, current, previous...)
+}
+
+// newStorage returns the driver of the storage of file content selected in the storage config.
+func newStorage(cfg StorageConfig, uploadDir string) (fileUtils.FileSaveReadRemover, error) {
+	switch cfg.Driver {
+	case "", "local":
+		return fileUtils.CreateNewLocalFileOperator(uploadDir), nil
+	case "s3":
+		if cfg.S3.PartSize != 0 && cfg.S3.PartSize < fileUtils.MinS3PartSize {
+			return nil, fmt.Errorf("S3 part size must be at least %d bytes", fileUtils.MinS3PartSize)
+		}
+		return fileUtils.CreateNewS3FileOperator(fileUtils.S3Config{
+			Endpoint:        cfg.S3.Endpoint,
+			Region:          cfg.S3.Region,
+			Bucket:          cfg.S3.Bucket,
+			Prefix:          cfg.S3.Prefix,
+			AccessKeyID:     cfg.S3.AccessKeyID,
+			SecretAccessKey: cfg.S3.SecretAccessKey,
+			PathStyle:       cfg.S3.PathStyle,
+			PartSize:        cfg.S3.PartSize,
+			PartialDir:      uploadDir,
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver %s", cfg.Driver)
+	}
+}
+
+// readMasterKey reads a master key from the file or the environment variable given in its
+// config.
+func readMasterKey(cfg MasterKeyConfig) ([]byte, error) {
+	var encoded string
+	switch {
+	case cfg.File != "" && cfg.Env != "":
+		return nil, errors.New("master key must be read from either a file or an environment variable")
+	case cfg.File != "":
+		content, err := ioutil.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	case cfg.Env != "":
+		encoded = os.Getenv(cfg.Env)
+		if encoded == "" {
+			return nil, fmt.Errorf("environment variable %s holding the master key is not set", cfg.Env)
+		}
+	default:
+		return nil, errors.New("master key needs a file or an environment variable")
+	}
+	return fileUtils.ParseMasterKey(strings.TrimSpace(encoded))
+}
+
+// contentTypePolicies returns the global and the per-directory policies restricting the MIME
+// types of uploaded files, which are declared in the content types config.
+func contentTypePolicies(cfg ContentTypeConfig) (models.ContentTypePolicy, map[string]models.ContentTypePolicy, error) {
+	policy := models.ContentTypePolicy{Allowed: cfg.Allowed, Blocked: cfg.Blocked}
+	if err := policy.Validate(); err != nil {
+		return models.ContentTypePolicy{}, nil, err
+	}
+	dirPolicies := make(map[string]models.ContentTypePolicy, len(cfg.Directories))
+	for _, dir := range cfg.Directories {
+		dirPolicy := models.ContentTypePolicy{Allowed: dir.Allowed, Blocked: dir.Blocked}
+		if err := dirPolicy.Validate(); err != nil {
+			return models.ContentTypePolicy{}, nil, err
+		}
+		if !strings.HasPrefix(dir.Path, "/") {
+			return models.ContentTypePolicy{}, nil, fmt.Errorf("directory path %q must start with /", dir.Path)
+		}
+		dirPolicies[dir.Path] = dirPolicy
+	}
+	return policy, dirPolicies, nil
+}
+
 func main() {
-	sqliteRepo := _fmanRepo.NewFManSQLiteRepo()
+	loadConfig()
+	dbRepo, err := newFManRepo(xtremeCfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to open the database: %s", err.Error())
+	}
+	if closer, ok := dbRepo.(io.Closer); ok {
+		defer closer.Close()
+	}
 	uuidGenerator := &uuidUtils.GoogleUUIDGenerator{}
-	localFileOps := fileUtils.CreateNewLocalFileOperator(xtremeCfg.Backend.UploadDir)
-	fmanUC := _fmanUC.NewFManLocalUsecase(sqliteRepo, sqliteRepo, sqliteRepo, uuidGenerator, localFileOps)
+	fileOps, err := newFileOps(xtremeCfg.Storage, xtremeCfg.Backend.UploadDir)
+	if err != nil {
+		log.Fatalf("Failed to set up the storage: %s", err.Error())
+	}
+	contentTypePolicy, dirContentTypePolicies, err := contentTypePolicies(xtremeCfg.ContentTypes)
+	if err != nil {
+		log.Fatalf("Invalid content types: %s", err.Error())
+	}
+	fmanUC := _fmanUC.NewFManLocalUsecase(dbRepo, dbRepo, dbRepo, dbRepo, dbRepo, dbRepo, dbRepo, dbRepo, dbRepo, dbRepo,
+		dbRepo, dbRepo, uuidGenerator, fileOps, _fmanUC.Options{
+			UploadExpiration: xtremeCfg.Upload.Expiration,
+			MaxUploadSize:    xtremeCfg.Upload.MaxSize,
+			Versioning:       xtremeCfg.Versioning.Enabled,
+			VersionPolicy: models.VersionPolicy{
+				MaxVersions: xtremeCfg.Versioning.MaxVersions,
+				MaxAge:      xtremeCfg.Versioning.MaxAge,
+			},
+			DefaultQuota:           xtremeCfg.Quota.DefaultUserBytes,
+			ContentTypePolicy:      contentTypePolicy,
+			DirContentTypePolicies: dirContentTypePolicies,
+			ExtractionLimits: models.ExtractionLimits{
+				MaxEntries: xtremeCfg.Extraction.MaxEntries,
+				MaxBytes:   xtremeCfg.Extraction.MaxBytes,
+				MaxRatio:   xtremeCfg.Extraction.MaxRatio,
+			},
+		})
+	// Fix the counts of used bytes, e.g. after the database was changed by hand.
+	if recomputeUsage {
+		corrections, err := fmanUC.RecomputeUsage()
+		if err != nil {
+			log.Fatalf("Failed to recompute usage: %s", err.Error())
+		}
+		for _, c := range corrections {
+			fmt.Printf("user %s: %d -> %d bytes\n", c.UserUUID, c.OldUsedBytes, c.NewUsedBytes)
+		}
+		fmt.Printf("Recomputed usage, corrected %d users\n", len(corrections))
+		return
+	}
+	// Index the files stored before the search existed, or whose indexing failed.
+	if reindexSearch {
+		indexed, err := fmanUC.ReindexSearch()
+		if err != nil {
+			log.Fatalf("Failed to reindex search: %s", err.Error())
+		}
+		fmt.Printf("Reindexed search, indexed %d contents\n", indexed)
+		return
+	}
+	// Encrypt the data keys with a new master key, which replaced the previous one.
+	if rotateMasterKey {
+		rewrapped, err := fmanUC.RotateMasterKey()
+		if err != nil {
+			log.Fatalf("Failed to rotate master key: %s", err.Error())
+		}
+		fmt.Printf("Rotated master key, re-encrypted %d data keys\n", rewrapped)
+		return
+	}
+	if xtremeCfg.Auth.JWTSecret == "" {
+		log.Fatal("auth.jwt_secret must be set")
+	}
+	authUC := _authUC.NewAuthJWTUsecase(dbRepo, dbRepo, uuidGenerator, _authUC.Options{
+		Secret:            []byte(xtremeCfg.Auth.JWTSecret),
+		AccessTokenTTL:    xtremeCfg.Auth.AccessTokenTTL,
+		RefreshTokenTTL:   xtremeCfg.Auth.RefreshTokenTTL,
+		AllowRegistration: xtremeCfg.Auth.AllowRegistration,
+	})
+	// Create the admin, who can access the files of all users.
+	if xtremeCfg.Auth.AdminUsername != "" {
+		if _, err := authUC.EnsureAdmin(xtremeCfg.Auth.AdminUsername, xtremeCfg.Auth.AdminPassword); err != nil {
+			log.Fatalf("Failed to create the admin: %s", err.Error())
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Start removing expired refresh tokens.
+	go _authUC.NewTokenPurger(authUC, xtremeCfg.Auth.PurgeInterval).Run(ctx)
+	// Start removing expired entries from the recycle bin.
+	if xtremeCfg.RecycleBin.Retention > 0 {
+		purger := _fmanUC.NewRecycleBinPurger(fmanUC, xtremeCfg.RecycleBin.Retention, xtremeCfg.RecycleBin.PurgeInterval)
+		go purger.Run(ctx)
+	}
+	// Start removing expired resumable uploads.
+	go _fmanUC.NewUploadPurger(fmanUC, xtremeCfg.Upload.PurgeInterval).Run(ctx)
+	// Start removing old file versions which are not retained anymore.
+	if xtremeCfg.Versioning.Enabled {
+		go _fmanUC.NewVersionPurger(fmanUC, xtremeCfg.Versioning.PurgeInterval).Run(ctx)
+	}
+	// Start making the thumbnails of uploaded images.
+	go _fmanUC.NewThumbnailer(fmanUC, xtremeCfg.Thumbnails.Workers).Run(ctx)
 	//Start web service
 	e := echo.New()
-	restful.InitFmanHandler(e, fmanUC)
+	authMiddleware := authRestful.InitAuthHandler(e, authUC)
+	restful.InitFmanHandler(e, fmanUC, authMiddleware)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	backendAddr := fmt.Sprintf("%s:%d", xtremeCfg.Backend.Host, xtremeCfg.Backend.Port)
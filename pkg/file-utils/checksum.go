@@ -0,0 +1,61 @@
+package fileUtils
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// SavedFile holds properties of a file saved to a source.
+type SavedFile struct {
+	// Size is the number of bytes of the content.
+	Size int64
+
+	// StoredSize is the number of bytes the content takes up in the source, which differs
+	// from Size if the content is transformed before it is stored, e.g. compressed.
+	StoredSize int64
+
+	// Location of the file, e.g. its path on the local disk.
+	Location string
+
+	// SHA256 and MD5 are the hex-encoded digests of the saved content.
+	SHA256 string
+	MD5    string
+}
+
+// checksumReader computes the SHA-256 and MD5 digests of the content read through it.
+type checksumReader struct {
+	r      io.Reader
+	sha256 hash.Hash
+	md5    hash.Hash
+}
+
+// newChecksumReader returns a checksumReader reading from r.
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{
+		r:      r,
+		sha256: sha256.New(),
+		md5:    md5.New(),
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.sha256.Write(p[:n])
+	c.md5.Write(p[:n])
+	return n, err
+}
+
+// savedFile returns the properties of a file of a size at a location, whose content was
+// read through the checksumReader.
+func (c *checksumReader) savedFile(size int64, location string) SavedFile {
+	return SavedFile{
+		Size:       size,
+		StoredSize: size,
+		Location:   location,
+		SHA256:     hex.EncodeToString(c.sha256.Sum(nil)),
+		MD5:        hex.EncodeToString(c.md5.Sum(nil)),
+	}
+}
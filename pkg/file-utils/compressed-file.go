@@ -0,0 +1,261 @@
+package fileUtils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultCompressionMinSavings is the fraction of its size, which compression must save
+	// on the content of a file for it to be stored compressed, unless configured otherwise.
+	DefaultCompressionMinSavings = 0.1
+
+	// compressionSampleSize is the size of the start of a content, which is compressed on
+	// trial to find out if compressing the whole content is worthwhile.
+	compressionSampleSize = 128 << 10
+
+	// compressedFileMagic starts every file stored compressed with gzip.
+	compressedFileMagic = "XTRMCMP1"
+
+	// rawFileMagic starts a file stored as it is, whose content starts with one of the magic
+	// numbers, so it is not mistaken for a compressed file.
+	rawFileMagic = "XTRMRAW1"
+)
+
+// layeredFileOperator is a FileSaveReadRemover transforming the files of another one.
+type layeredFileOperator interface {
+	// baseFileOperator returns the FileSaveReadRemover the files are stored in.
+	baseFileOperator() FileSaveReadRemover
+}
+
+// CompressedFileOperator compresses the files of another FileSaveReadRemover at rest. A file
+// is compressed with gzip if its content is of a compressible type, e.g. text, and a trial
+// on the start of the content saves enough; otherwise it is stored as it is. Files are
+// decompressed transparently, and files stored before compression was enabled are read as
+// they are.
+type CompressedFileOperator struct {
+	fs         FileSaveReadRemover
+	minSavings float64
+}
+
+// compressedPartialFileOperator is a CompressedFileOperator over a storage supporting partial
+// files, which are passed through uncompressed until they are saved as complete files.
+type compressedPartialFileOperator struct {
+	*CompressedFileOperator
+	PartialFileStore
+}
+
+// CreateNewCompressedFileOperator create a new CompressedFileOperator compressing the files of
+// fs, which compression saves at least the fraction minSavings of, e.g. 0.1 for 10%. Zero falls
+// back to DefaultCompressionMinSavings. If fs is a PartialFileStore, so is the returned
+// FileSaveReadRemover.
+func CreateNewCompressedFileOperator(fs FileSaveReadRemover, minSavings float64) (FileSaveReadRemover, error) {
+	if minSavings < 0 || minSavings >= 1 {
+		return nil, fmt.Errorf("minimum savings of compression must be between 0 and 1, got %g", minSavings)
+	}
+	if minSavings == 0 {
+		minSavings = DefaultCompressionMinSavings
+	}
+	c := &CompressedFileOperator{fs: fs, minSavings: minSavings}
+	if store, ok := fs.(PartialFileStore); ok {
+		return &compressedPartialFileOperator{c, store}, nil
+	}
+	return c, nil
+}
+
+// SaveFile saves a file from a reader, compressed if that is worthwhile. The type of the
+// content is taken from the options, and detected from its start if they do not tell it. It
+// returns the size and the digests of the content, and the size of the stored file. The
+// options are not passed on, as they tell about the content rather than the stored file.
+func (c *CompressedFileOperator) SaveFile(filename string, contentReader io.Reader, opts ...SaveOption) (SavedFile, error) {
+	contentType := newSaveOptions(opts).contentType
+	checksums := newChecksumReader(contentReader)
+	sample := make([]byte, compressionSampleSize)
+	n, err := io.ReadFull(checksums, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return SavedFile{}, err
+	}
+	sample = sample[:n]
+	if contentType == "" {
+		contentType = http.DetectContentType(sample)
+	}
+	content := &countingReader{r: io.MultiReader(bytes.NewReader(sample), checksums)}
+	var stored io.Reader
+	switch {
+	case c.worthCompressing(contentType, sample):
+		stored = io.MultiReader(strings.NewReader(compressedFileMagic), newCompressingReader(content))
+	case bytes.HasPrefix(sample, []byte(compressedFileMagic)) || bytes.HasPrefix(sample, []byte(rawFileMagic)):
+		stored = io.MultiReader(strings.NewReader(rawFileMagic), content)
+	default:
+		stored = content
+	}
+	saved, err := c.fs.SaveFile(filename, stored)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	result := checksums.savedFile(content.n, saved.Location)
+	result.StoredSize = saved.StoredSize
+	return result, nil
+}
+
+// ReadFile returns an io.ReadCloser decompressing a file if it is stored compressed.
+func (c *CompressedFileOperator) ReadFile(filename string) (io.ReadCloser, error) {
+	rc, err := c.fs.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(compressedFileMagic))
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return nil, err
+	}
+	switch string(head[:n]) {
+	case compressedFileMagic:
+		decompressed, err := gzip.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, fmt.Errorf("compressed file %s is corrupted: %v", filename, err)
+		}
+		return &limitedReadCloser{decompressed, rc}, nil
+	case rawFileMagic:
+		return rc, nil
+	default:
+		return &limitedReadCloser{io.MultiReader(bytes.NewReader(head[:n]), rc), rc}, nil
+	}
+}
+
+// ReadFileRange returns an io.ReadCloser reading length bytes of a file starting at offset.
+// A compressed file is decompressed from its beginning, while only the range of a file stored
+// as it is is read, if the underlying storage is a FileRangeReader.
+func (c *CompressedFileOperator) ReadFileRange(filename string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	head, err := readFileRange(c.fs, filename, 0, int64(len(compressedFileMagic)))
+	if err != nil {
+		return nil, err
+	}
+	magic, err := ioutil.ReadAll(head)
+	head.Close()
+	if err != nil {
+		return nil, err
+	}
+	switch string(magic) {
+	case compressedFileMagic:
+		rc, err := c.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil && err != io.EOF {
+			rc.Close()
+			return nil, err
+		}
+		return &limitedReadCloser{io.LimitReader(rc, length), rc}, nil
+	case rawFileMagic:
+		return readFileRange(c.fs, filename, int64(len(rawFileMagic))+offset, length)
+	default:
+		return readFileRange(c.fs, filename, offset, length)
+	}
+}
+
+// RemoveFile removes a file.
+func (c *CompressedFileOperator) RemoveFile(filename string) error {
+	return c.fs.RemoveFile(filename)
+}
+
+func (c *CompressedFileOperator) baseFileOperator() FileSaveReadRemover {
+	return c.fs
+}
+
+// worthCompressing checks if a content of a type starting with a sample is of a compressible
+// type, and compressing the sample saves at least the minimum savings.
+func (c *CompressedFileOperator) worthCompressing(contentType string, sample []byte) bool {
+	if len(sample) == 0 || !isCompressibleType(contentType) {
+		return false
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(sample); err != nil {
+		return false
+	}
+	if err := gz.Close(); err != nil {
+		return false
+	}
+	compressedSize := float64(len(compressedFileMagic) + buf.Len())
+	return compressedSize <= float64(len(sample))*(1-c.minSavings)
+}
+
+// isCompressibleType checks if the content of a MIME type is usually compressible. Formats,
+// which are compressed already, e.g. images, archives and video, are not, and neither is
+// application/octet-stream, which unknown binary content, e.g. encrypted data, falls back to.
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "application/ecmascript", "application/javascript", "application/json", "application/postscript",
+		"application/vnd.ms-fontobject", "application/wasm", "application/x-sh", "application/xml", "audio/aiff",
+		"audio/basic", "audio/midi", "audio/wave", "font/otf", "font/ttf", "image/bmp", "image/x-icon":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// compressingReader reads the content of another reader compressed with gzip.
+type compressingReader struct {
+	src   io.Reader
+	gz    *gzip.Writer
+	chunk []byte
+	// compressed holds the compressed bytes, which are not read yet.
+	compressed bytes.Buffer
+	done       bool
+}
+
+// newCompressingReader returns a compressingReader compressing the content of r.
+func newCompressingReader(r io.Reader) *compressingReader {
+	c := &compressingReader{src: r, chunk: make([]byte, 32<<10)}
+	c.gz = gzip.NewWriter(&c.compressed)
+	return c
+}
+
+func (c *compressingReader) Read(p []byte) (int, error) {
+	for c.compressed.Len() == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := c.src.Read(c.chunk)
+		if _, err := c.gz.Write(c.chunk[:n]); err != nil {
+			return 0, err
+		}
+		if err == io.EOF {
+			if err := c.gz.Close(); err != nil {
+				return 0, err
+			}
+			c.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return c.compressed.Read(p)
+}
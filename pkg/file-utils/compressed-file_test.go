@@ -0,0 +1,156 @@
+package fileUtils
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestCompressedFileOperator returns a CompressedFileOperator over a LocalFileOperator,
+// which is returned too.
+func newTestCompressedFileOperator(t *testing.T) (*compressedPartialFileOperator, *LocalFileOperator) {
+	t.Helper()
+	local := CreateNewLocalFileOperator(t.TempDir())
+	fs, err := CreateNewCompressedFileOperator(local, 0)
+	if err != nil {
+		t.Fatalf("CreateNewCompressedFileOperator failed: %s", err)
+	}
+	return fs.(*compressedPartialFileOperator), local
+}
+
+// csvContent returns a compressible CSV export with a number of rows.
+func csvContent(rows int) string {
+	var b strings.Builder
+	b.WriteString("id,name,amount\n")
+	for i := 0; i < rows; i++ {
+		b.WriteString(strconv.Itoa(i) + ",customer-" + strconv.Itoa(i%10) + ",100.00\n")
+	}
+	return b.String()
+}
+
+// randomContent returns an incompressible content of a size.
+func randomContent(size int) string {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(b)
+	return string(b)
+}
+
+func TestCompressedFileOperator(t *testing.T) {
+	c, local := newTestCompressedFileOperator(t)
+	// The text is larger than the sample compressed on trial.
+	text := csvContent(20000)
+	for name, content := range map[string]string{
+		"text":   text,
+		"random": randomContent(1000),
+		"empty":  "",
+		// Content looking like a stored file is stored with a marker.
+		"magic": compressedFileMagic + "not compressed",
+	} {
+		saved, err := c.SaveFile(name, strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("SaveFile of %s failed: %s", name, err)
+		}
+		if saved.Size != int64(len(content)) {
+			t.Errorf("SaveFile of %s returned size %d, want %d", name, saved.Size, len(content))
+		}
+		compressed := strings.HasPrefix(string(storedContent(t, local, name)), compressedFileMagic)
+		if compressed != (name == "text") {
+			t.Errorf("%s is stored compressed: %t", name, compressed)
+		}
+		if compressed && saved.StoredSize*5 > saved.Size {
+			t.Errorf("%s is stored in %d of %d bytes, want a fifth at most", name, saved.StoredSize, saved.Size)
+		}
+		if got, err := readAll(c.ReadFile(name)); err != nil || got != content {
+			t.Errorf("ReadFile of %s read %d bytes, %v, want %d", name, len(got), err, len(content))
+		}
+		if len(content) > 10 {
+			want := content[len(content)-10:]
+			if got, err := readAll(c.ReadFileRange(name, int64(len(content)-10), 10)); err != nil || got != want {
+				t.Errorf("ReadFileRange of %s read %q, %v, want %q", name, got, err, want)
+			}
+		}
+	}
+
+	// Files stored before compression was enabled are read as they are.
+	if _, err := local.SaveFile("plain", strings.NewReader("plain text")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(c.ReadFileRange("plain", 6, 4)); err != nil || got != "text" {
+		t.Errorf("ReadFileRange of an uncompressed file read %q, %v", got, err)
+	}
+	if _, err := CreateNewCompressedFileOperator(local, 1); err == nil {
+		t.Error("CreateNewCompressedFileOperator with savings of 1 succeeded")
+	}
+}
+
+func TestCompressedFileOperatorContentType(t *testing.T) {
+	c, local := newTestCompressedFileOperator(t)
+	text := csvContent(1000)
+	for _, tc := range []struct {
+		name    string
+		content string
+		opts    []SaveOption
+		want    bool
+	}{
+		{"detected", text, nil, true},
+		{"json", `{"rows": "` + text + `"}`, []SaveOption{WithContentType("application/json")}, true},
+		// The given type wins over the detected one.
+		{"jpeg", text, []SaveOption{WithContentType("image/jpeg")}, false},
+		// Unknown binary content is not compressed on trial, even if it would save enough.
+		{"binary", strings.Repeat("\x00\x01", 1000), nil, false},
+		{"octet-stream", text, []SaveOption{WithContentType("application/octet-stream")}, false},
+	} {
+		if _, err := c.SaveFile(tc.name, strings.NewReader(tc.content), tc.opts...); err != nil {
+			t.Fatalf("SaveFile of %s failed: %s", tc.name, err)
+		}
+		compressed := strings.HasPrefix(string(storedContent(t, local, tc.name)), compressedFileMagic)
+		if compressed != tc.want {
+			t.Errorf("%s is stored compressed: %t, want %t", tc.name, compressed, tc.want)
+		}
+		if got, err := readAll(c.ReadFile(tc.name)); err != nil || got != tc.content {
+			t.Errorf("ReadFile of %s read %d bytes, %v, want %d", tc.name, len(got), err, len(tc.content))
+		}
+	}
+}
+
+func TestCompressedEncryptedFileOperator(t *testing.T) {
+	local := CreateNewLocalFileOperator(t.TempDir())
+	encrypted, err := CreateNewEncryptedFileOperator(local, testMasterKey(1))
+	if err != nil {
+		t.Fatalf("CreateNewEncryptedFileOperator failed: %s", err)
+	}
+	c, err := CreateNewCompressedFileOperator(encrypted, 0)
+	if err != nil {
+		t.Fatalf("CreateNewCompressedFileOperator failed: %s", err)
+	}
+	content := csvContent(1000)
+	saved, err := c.SaveFile("blob", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	if saved.StoredSize >= saved.Size {
+		t.Errorf("content of %d bytes is stored in %d bytes, want fewer", saved.Size, saved.StoredSize)
+	}
+	if got, err := readAll(c.ReadFile("blob")); err != nil || got != content {
+		t.Errorf("ReadFile read %d bytes, %v, want %d", len(got), err, len(content))
+	}
+	if _, ok := FindKeyRotator(c); !ok {
+		t.Error("no KeyRotator found under the compression")
+	}
+
+	// Partial files are encrypted, but not compressed.
+	store, ok := c.(PartialFileStore)
+	if !ok {
+		t.Fatal("compressed storage is not a PartialFileStore")
+	}
+	if err := store.CreatePartialFile("upload"); err != nil {
+		t.Fatalf("CreatePartialFile failed: %s", err)
+	}
+	if _, err := store.WritePartialFile("upload", 0, strings.NewReader(content)); err != nil {
+		t.Fatalf("WritePartialFile failed: %s", err)
+	}
+	if got, err := readAll(store.ReadPartialFile("upload")); err != nil || got != content {
+		t.Errorf("ReadPartialFile read %d bytes, %v, want %d", len(got), err, len(content))
+	}
+}
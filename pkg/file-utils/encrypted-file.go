@@ -0,0 +1,925 @@
+package fileUtils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	// MasterKeySize is the size in bytes of a master key, which is an AES-256 key.
+	MasterKeySize = 32
+
+	// dataKeySize is the size in bytes of the AES-256 key of a file.
+	dataKeySize = 32
+
+	// encryptedChunkSize is the size of the chunks of plaintext, which are encrypted and
+	// authenticated one by one, so a file can be streamed and read at any offset.
+	encryptedChunkSize = 64 << 10
+
+	// encryptedChunkOverhead is the size of the authentication tag of an encrypted chunk.
+	encryptedChunkOverhead = 16
+
+	// encryptedFileMagic starts every encrypted file, which tells it apart from a file
+	// stored before encryption was enabled.
+	encryptedFileMagic = "XTRMENC1"
+
+	// keyEnvelopeMagic starts every key envelope.
+	keyEnvelopeMagic = "XTRMKEY1"
+
+	// keyEnvelopeSuffix ends the names of key envelopes.
+	keyEnvelopeSuffix = ".key"
+
+	// partialStateMagic starts the state of every encrypted partial file.
+	partialStateMagic = "XTRMPST1"
+
+	// partialStateSuffix ends the names of the states of encrypted partial files.
+	partialStateSuffix = ".state"
+)
+
+// KeyRotator provides an interface to re-encrypt the data keys of files with a new master
+// key without rewriting their content.
+type KeyRotator interface {
+	// RewrapDataKey encrypts the data key of a file with the current master key, unless it
+	// is encrypted with it already. It returns whether the data key was re-encrypted.
+	RewrapDataKey(filename string) (bool, error)
+}
+
+// FindKeyRotator returns the KeyRotator among the layers of a FileSaveReadRemover, e.g. the
+// encryption under a compression layer.
+func FindKeyRotator(fs FileSaveReadRemover) (KeyRotator, bool) {
+	for {
+		if rotator, ok := fs.(KeyRotator); ok {
+			return rotator, true
+		}
+		layered, ok := fs.(layeredFileOperator)
+		if !ok {
+			return nil, false
+		}
+		fs = layered.baseFileOperator()
+	}
+}
+
+// ParseMasterKey decodes a master key given as 64 hex digits or as base64.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes encoded as hex or base64", MasterKeySize)
+	}
+	return key, nil
+}
+
+// masterKey encrypts the data keys of files.
+type masterKey struct {
+	// id identifies the key in the names of key envelopes without revealing it.
+	id   string
+	aead cipher.AEAD
+}
+
+// newMasterKey returns a masterKey from its raw bytes.
+func newMasterKey(key []byte) (masterKey, error) {
+	if len(key) != MasterKeySize {
+		return masterKey{}, fmt.Errorf("master key must be %d bytes", MasterKeySize)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return masterKey{}, err
+	}
+	sum := sha256.Sum256(key)
+	return masterKey{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// newGCM returns an AES-GCM cipher with a key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedFileOperator encrypts the files of another FileSaveReadRemover at rest. Every file
+// is encrypted with its own random data key in chunks of AES-GCM, so it can be streamed and
+// read at any offset, and any change of the content is detected when it is read. The data key
+// is encrypted with a master key and stored next to the file in a small key envelope, so the
+// master key can be rotated by re-encrypting the data keys only.
+// Files stored before encryption was enabled are read as they are.
+type EncryptedFileOperator struct {
+	fs       FileSaveReadRemover
+	current  masterKey
+	previous []masterKey
+}
+
+// encryptedPartialFileOperator is an EncryptedFileOperator over a storage supporting partial
+// files. Every partial file is encrypted with its own data key in AES-CTR, which keeps the
+// offsets of the plaintext, so a chunk can be written at any offset. Every write starts a
+// segment with a random IV, so a chunk written again never reuses a key stream. As CTR does
+// not detect changes, a sealed state next to the partial file holds its size and the SHA-256
+// of its encrypted content, which it is checked against when it is read or written again.
+type encryptedPartialFileOperator struct {
+	*EncryptedFileOperator
+	store PartialFileStore
+}
+
+// CreateNewEncryptedFileOperator create a new EncryptedFileOperator encrypting the files of
+// fs. New data keys are encrypted with the current master key, while the previous master keys
+// still decrypt the data keys, which were not rotated yet. If fs is a PartialFileStore, so is
+// the returned FileSaveReadRemover.
+func CreateNewEncryptedFileOperator(fs FileSaveReadRemover, current []byte, previous ...[]byte) (FileSaveReadRemover, error) {
+	currentKey, err := newMasterKey(current)
+	if err != nil {
+		return nil, err
+	}
+	e := &EncryptedFileOperator{fs: fs, current: currentKey}
+	for _, key := range previous {
+		previousKey, err := newMasterKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if previousKey.id != currentKey.id {
+			e.previous = append(e.previous, previousKey)
+		}
+	}
+	if store, ok := fs.(PartialFileStore); ok {
+		return &encryptedPartialFileOperator{e, store}, nil
+	}
+	return e, nil
+}
+
+// SaveFile encrypts a file from a reader with a new data key and saves it, then saves the
+// data key encrypted with the current master key. It returns the size and the digests of the
+// plaintext, and the size of the encrypted file. The options are not passed on, as they
+// tell about the plaintext rather than the stored file.
+func (e *EncryptedFileOperator) SaveFile(filename string, contentReader io.Reader, _ ...SaveOption) (SavedFile, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return SavedFile{}, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	checksums := newChecksumReader(contentReader)
+	encrypted := newEncryptingReader(checksums, aead)
+	saved, err := e.fs.SaveFile(filename, encrypted)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	if err := e.saveEnvelope(filename, e.current, dataKey); err != nil {
+		return SavedFile{}, err
+	}
+	result := checksums.savedFile(encrypted.size, saved.Location)
+	result.StoredSize = saved.StoredSize
+	return result, nil
+}
+
+// ReadFile returns an io.ReadCloser decrypting a file.
+func (e *EncryptedFileOperator) ReadFile(filename string) (io.ReadCloser, error) {
+	aead, err := e.openDataKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := e.fs.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, len(encryptedFileMagic))
+	n, err := io.ReadFull(rc, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		rc.Close()
+		return nil, err
+	}
+	isEncrypted := string(head[:n]) == encryptedFileMagic
+	if aead == nil {
+		if isEncrypted {
+			rc.Close()
+			return nil, fmt.Errorf("file %s is encrypted with an unknown master key", filename)
+		}
+		return &limitedReadCloser{io.MultiReader(bytes.NewReader(head[:n]), rc), rc}, nil
+	}
+	if !isEncrypted {
+		rc.Close()
+		return nil, fmt.Errorf("file %s is not encrypted although it has a data key", filename)
+	}
+	return &limitedReadCloser{newDecryptingReader(filename, rc, aead, 0), rc}, nil
+}
+
+// ReadFileRange returns an io.ReadCloser decrypting length bytes of a file starting at
+// offset. Only the chunks holding the range are read, if the underlying storage is a
+// FileRangeReader.
+func (e *EncryptedFileOperator) ReadFileRange(filename string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	aead, err := e.openDataKey(filename)
+	if err != nil {
+		return nil, err
+	}
+	if aead == nil {
+		if err := e.checkUnencrypted(filename); err != nil {
+			return nil, err
+		}
+		return readFileRange(e.fs, filename, offset, length)
+	}
+	// Read the chunks holding the range together with the first byte after them, which
+	// tells whether the last of them is the last chunk of the file.
+	const encryptedChunk = encryptedChunkSize + encryptedChunkOverhead
+	first, last := offset/encryptedChunkSize, (offset+length-1)/encryptedChunkSize
+	start := int64(len(encryptedFileMagic)) + first*encryptedChunk
+	rc, err := readFileRange(e.fs, filename, start, (last-first+1)*encryptedChunk+1)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := newDecryptingReader(filename, rc, aead, uint64(first))
+	if _, err := io.CopyN(ioutil.Discard, decrypted, offset-first*encryptedChunkSize); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(decrypted, length), rc}, nil
+}
+
+func (e *EncryptedFileOperator) baseFileOperator() FileSaveReadRemover {
+	return e.fs
+}
+
+// RemoveFile removes a file together with its key envelopes.
+func (e *EncryptedFileOperator) RemoveFile(filename string) error {
+	err := e.fs.RemoveFile(filename)
+	for _, key := range e.masterKeys() {
+		if rmErr := e.fs.RemoveFile(envelopeName(filename, key)); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// RewrapDataKey encrypts the data key of a file with the current master key, unless it is
+// encrypted with it already, and removes its envelopes of the previous master keys. The new
+// envelope is saved before the old one is removed, so the file stays readable if the rotation
+// is interrupted. Files stored before encryption was enabled have no data key.
+func (e *EncryptedFileOperator) RewrapDataKey(filename string) (bool, error) {
+	dataKey, key, err := e.readEnvelope(filename)
+	if err != nil {
+		return false, err
+	}
+	if dataKey == nil {
+		return false, e.checkUnencrypted(filename)
+	}
+	rewrapped := false
+	if key.id != e.current.id {
+		if err := e.saveEnvelope(filename, e.current, dataKey); err != nil {
+			return false, err
+		}
+		rewrapped = true
+	}
+	for _, previous := range e.previous {
+		if err := e.fs.RemoveFile(envelopeName(filename, previous)); err != nil && !os.IsNotExist(err) {
+			return rewrapped, err
+		}
+	}
+	return rewrapped, nil
+}
+
+// CreatePartialFile creates a new empty partial file together with the envelope of its new
+// data key and its state, which are partial files themselves.
+func (e *encryptedPartialFileOperator) CreatePartialFile(filename string) error {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	keys, err := newPartialKeys(dataKey)
+	if err != nil {
+		return err
+	}
+	envelope, err := sealEnvelope(filename, e.current, dataKey)
+	if err != nil {
+		return err
+	}
+	name := envelopeName(filename, e.current)
+	if err := e.store.CreatePartialFile(name); err != nil {
+		return err
+	}
+	if _, err := e.store.WritePartialFile(name, 0, bytes.NewReader(envelope)); err != nil {
+		e.store.RemovePartialFile(name)
+		return err
+	}
+	if err := e.store.CreatePartialFile(partialStateName(filename)); err != nil {
+		e.store.RemovePartialFile(name)
+		return err
+	}
+	if err := e.writePartialState(filename, keys, &partialState{hash: sha256.New()}); err != nil {
+		e.RemovePartialFile(filename)
+		return err
+	}
+	if err := e.store.CreatePartialFile(filename); err != nil {
+		e.RemovePartialFile(filename)
+		return err
+	}
+	return nil
+}
+
+// WritePartialFile encrypts the content of a reader and writes it to a partial file starting
+// at offset, and returns the number of bytes written. Writing before the end of the partial
+// file cuts it off at offset, after its content up to there is checked.
+func (e *encryptedPartialFileOperator) WritePartialFile(filename string, offset int64, contentReader io.Reader) (int64, error) {
+	keys, err := e.openPartialKeys(filename)
+	if err != nil {
+		return 0, err
+	}
+	if keys == nil {
+		return e.store.WritePartialFile(filename, offset, contentReader)
+	}
+	state, err := e.readPartialState(filename, keys)
+	if err != nil {
+		return 0, err
+	}
+	if offset > state.size {
+		return 0, fmt.Errorf("partial file %s cannot be written at offset %d after its end at %d", filename, offset,
+			state.size)
+	}
+	if offset < state.size {
+		if err := e.cutPartialFile(filename, state, offset); err != nil {
+			return 0, err
+		}
+	}
+	segment := partialSegment{offset: offset}
+	if _, err := rand.Read(segment.iv[:]); err != nil {
+		return 0, err
+	}
+	before, err := cloneHash(state.hash)
+	if err != nil {
+		return 0, err
+	}
+	hashed := &countingWriter{w: state.hash}
+	encrypted := &cipher.StreamReader{S: cipher.NewCTR(keys.content, segment.iv[:]), R: contentReader}
+	n, err := e.store.WritePartialFile(filename, offset, io.TeeReader(encrypted, hashed))
+	if n != hashed.n {
+		// Bytes were read, but not written, so the written ones are hashed again.
+		state.hash = before
+		if hashErr := e.hashPartialFile(filename, state.hash, offset, n); hashErr != nil {
+			return 0, hashErr
+		}
+	}
+	if n > 0 {
+		state.size = offset + n
+		state.segments = append(state.segments, segment)
+	}
+	// The write only counts, once the state holds it. Otherwise the next write is made at
+	// the previous end again.
+	if stateErr := e.writePartialState(filename, keys, state); stateErr != nil {
+		return 0, stateErr
+	}
+	return n, err
+}
+
+// ReadPartialFile returns an io.ReadCloser decrypting a partial file from the beginning,
+// which fails at the end if the partial file does not match its state. Partial files created
+// before encryption was enabled are read as they are.
+func (e *encryptedPartialFileOperator) ReadPartialFile(filename string) (io.ReadCloser, error) {
+	keys, err := e.openPartialKeys(filename)
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return e.store.ReadPartialFile(filename)
+	}
+	state, err := e.readPartialState(filename, keys)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := e.store.ReadPartialFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedReadCloser{newPartialDecryptingReader(filename, rc, keys.content, state), rc}, nil
+}
+
+// RemovePartialFile removes a partial file together with the envelopes of its data key and
+// its state.
+func (e *encryptedPartialFileOperator) RemovePartialFile(filename string) error {
+	err := e.store.RemovePartialFile(filename)
+	names := []string{partialStateName(filename)}
+	for _, key := range e.masterKeys() {
+		names = append(names, envelopeName(filename, key))
+	}
+	for _, name := range names {
+		if rmErr := e.store.RemovePartialFile(name); rmErr != nil && !os.IsNotExist(rmErr) && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
+
+// partialKeys holds the keys of an encrypted partial file, which are derived from its data key.
+type partialKeys struct {
+	// content encrypts the content in AES-CTR.
+	content cipher.Block
+
+	// state seals the state in AES-GCM.
+	state cipher.AEAD
+}
+
+// newPartialKeys derives the keys of a partial file from its data key, so the key streams of
+// the content never meet the ones of the state.
+func newPartialKeys(dataKey []byte) (*partialKeys, error) {
+	content, err := aes.NewCipher(deriveKey(dataKey, "content"))
+	if err != nil {
+		return nil, err
+	}
+	state, err := newGCM(deriveKey(dataKey, "state"))
+	if err != nil {
+		return nil, err
+	}
+	return &partialKeys{content: content, state: state}, nil
+}
+
+// deriveKey derives a key for a purpose given by a label from another key.
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+// openPartialKeys returns the keys of a partial file, or nil if the partial file has no
+// envelope of a known master key. The data keys of partial files are not rotated, as the
+// partial files expire, but the previous master keys decrypt them.
+func (e *encryptedPartialFileOperator) openPartialKeys(filename string) (*partialKeys, error) {
+	for _, key := range e.masterKeys() {
+		rc, err := e.store.ReadPartialFile(envelopeName(filename, key))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dataKey, err := openEnvelope(filename, key, rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		return newPartialKeys(dataKey)
+	}
+	return nil, nil
+}
+
+// partialSegment is the part of an encrypted partial file written at once, which has a key
+// stream of its own. It ends where the next segment starts.
+type partialSegment struct {
+	offset int64
+	iv     [aes.BlockSize]byte
+}
+
+// partialState holds what an encrypted partial file is checked against.
+type partialState struct {
+	// size is the number of bytes written.
+	size int64
+
+	// hash is the SHA-256 of the encrypted content, which further writes continue.
+	hash hash.Hash
+
+	segments []partialSegment
+}
+
+// partialStateName returns the name of the state of a partial file.
+func partialStateName(filename string) string {
+	return filename + partialStateSuffix
+}
+
+// writePartialState seals the state of a partial file and writes it over the previous one.
+// The name of the partial file is authenticated, so the state cannot be used for another one.
+func (e *encryptedPartialFileOperator) writePartialState(filename string, keys *partialKeys, state *partialState) error {
+	hashState, err := state.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	plain := make([]byte, 0, 16+len(hashState)+len(state.segments)*(8+aes.BlockSize))
+	plain = appendUint64(plain, uint64(state.size))
+	plain = appendUint64(plain, uint64(len(hashState)))
+	plain = append(plain, hashState...)
+	for _, segment := range state.segments {
+		plain = appendUint64(plain, uint64(segment.offset))
+		plain = append(plain, segment.iv[:]...)
+	}
+	nonce := make([]byte, keys.state.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := append([]byte(partialStateMagic), nonce...)
+	sealed = keys.state.Seal(sealed, nonce, plain, []byte(filename))
+	_, err = e.store.WritePartialFile(partialStateName(filename), 0, bytes.NewReader(sealed))
+	return err
+}
+
+// readPartialState reads the state of a partial file and checks that it was not changed.
+func (e *encryptedPartialFileOperator) readPartialState(filename string, keys *partialKeys) (*partialState, error) {
+	rc, err := e.store.ReadPartialFile(partialStateName(filename))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	sealed, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	corrupted := fmt.Errorf("state of partial file %s is corrupted", filename)
+	nonceEnd := len(partialStateMagic) + keys.state.NonceSize()
+	if len(sealed) < nonceEnd || string(sealed[:len(partialStateMagic)]) != partialStateMagic {
+		return nil, corrupted
+	}
+	plain, err := keys.state.Open(nil, sealed[len(partialStateMagic):nonceEnd], sealed[nonceEnd:], []byte(filename))
+	if err != nil || len(plain) < 16 {
+		return nil, corrupted
+	}
+	state := &partialState{size: int64(binary.BigEndian.Uint64(plain)), hash: sha256.New()}
+	hashLen := binary.BigEndian.Uint64(plain[8:])
+	plain = plain[16:]
+	if hashLen > uint64(len(plain)) || (len(plain)-int(hashLen))%(8+aes.BlockSize) != 0 {
+		return nil, corrupted
+	}
+	if err := state.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(plain[:hashLen]); err != nil {
+		return nil, corrupted
+	}
+	for plain = plain[hashLen:]; len(plain) > 0; plain = plain[8+aes.BlockSize:] {
+		segment := partialSegment{offset: int64(binary.BigEndian.Uint64(plain))}
+		copy(segment.iv[:], plain[8:])
+		state.segments = append(state.segments, segment)
+	}
+	return state, nil
+}
+
+// cutPartialFile checks the content of a partial file against its state, and cuts the state
+// off at offset. The partial file itself is cut off by the next write.
+func (e *encryptedPartialFileOperator) cutPartialFile(filename string, state *partialState, offset int64) error {
+	want := state.hash.Sum(nil)
+	h := sha256.New()
+	if err := e.hashPartialFile(filename, h, 0, offset); err != nil {
+		return err
+	}
+	cut, err := cloneHash(h)
+	if err != nil {
+		return err
+	}
+	if err := e.hashPartialFile(filename, h, offset, state.size-offset); err != nil {
+		return err
+	}
+	if !hmac.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("partial file %s is corrupted", filename)
+	}
+	state.size = offset
+	state.hash = cut
+	for i, segment := range state.segments {
+		if segment.offset >= offset {
+			state.segments = state.segments[:i]
+			break
+		}
+	}
+	return nil
+}
+
+// hashPartialFile writes length bytes of a partial file starting at offset to a hash.
+func (e *encryptedPartialFileOperator) hashPartialFile(filename string, h hash.Hash, offset, length int64) error {
+	rc, err := e.store.ReadPartialFile(filename)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil {
+		return err
+	}
+	_, err = io.CopyN(h, rc, length)
+	return err
+}
+
+// cloneHash returns a copy of a SHA-256 hash.
+func cloneHash(h hash.Hash) (hash.Hash, error) {
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	clone := sha256.New()
+	if err := clone.(encoding.BinaryUnmarshaler).UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// appendUint64 appends a big-endian uint64 to b.
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+// countingWriter counts the bytes written to another writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// partialDecryptingReader reads the plaintext of an encrypted partial file from its start,
+// and checks the encrypted content against the state of the partial file at the end.
+type partialDecryptingReader struct {
+	filename string
+	src      io.Reader
+	block    cipher.Block
+	state    *partialState
+	hash     hash.Hash
+	stream   cipher.Stream
+	// pos is the number of bytes read so far, and next the index of the next segment.
+	pos  int64
+	next int
+}
+
+// newPartialDecryptingReader returns a partialDecryptingReader decrypting a partial file read
+// by r. Anything after the size in the state, e.g. left by an interrupted write, is ignored.
+func newPartialDecryptingReader(filename string, r io.Reader, block cipher.Block, state *partialState) *partialDecryptingReader {
+	return &partialDecryptingReader{
+		filename: filename,
+		src:      io.LimitReader(r, state.size),
+		block:    block,
+		state:    state,
+		hash:     sha256.New(),
+	}
+}
+
+func (r *partialDecryptingReader) Read(p []byte) (int, error) {
+	segments := r.state.segments
+	if r.next < len(segments) && r.pos == segments[r.next].offset {
+		r.stream = cipher.NewCTR(r.block, segments[r.next].iv[:])
+		r.next++
+	}
+	// A read stops at the start of the next segment, whose key stream differs.
+	if r.next < len(segments) && int64(len(p)) > segments[r.next].offset-r.pos {
+		p = p[:segments[r.next].offset-r.pos]
+	}
+	n, err := r.src.Read(p)
+	if n > 0 {
+		if r.stream == nil {
+			return 0, fmt.Errorf("partial file %s is corrupted", r.filename)
+		}
+		r.hash.Write(p[:n])
+		r.stream.XORKeyStream(p[:n], p[:n])
+		r.pos += int64(n)
+	}
+	if err == io.EOF && (r.pos != r.state.size || !hmac.Equal(r.hash.Sum(nil), r.state.hash.Sum(nil))) {
+		return n, fmt.Errorf("partial file %s is corrupted", r.filename)
+	}
+	return n, err
+}
+
+// masterKeys returns the current master key followed by the previous ones.
+func (e *EncryptedFileOperator) masterKeys() []masterKey {
+	return append([]masterKey{e.current}, e.previous...)
+}
+
+// checkUnencrypted checks that a file without a data key was stored before encryption was
+// enabled, rather than encrypted with an unknown master key.
+func (e *EncryptedFileOperator) checkUnencrypted(filename string) error {
+	head, err := readFileRange(e.fs, filename, 0, int64(len(encryptedFileMagic)))
+	if err != nil {
+		return err
+	}
+	defer head.Close()
+	magic, err := ioutil.ReadAll(head)
+	if err != nil {
+		return err
+	}
+	if string(magic) == encryptedFileMagic {
+		return fmt.Errorf("file %s is encrypted with an unknown master key", filename)
+	}
+	return nil
+}
+
+// envelopeName returns the name of the envelope of a file's data key encrypted with a
+// master key.
+func envelopeName(filename string, key masterKey) string {
+	return filename + "." + key.id + keyEnvelopeSuffix
+}
+
+// saveEnvelope encrypts the data key of a file with a master key, and saves it as an
+// envelope. The name of the file is authenticated, so the envelope cannot be used for
+// another file.
+func (e *EncryptedFileOperator) saveEnvelope(filename string, key masterKey, dataKey []byte) error {
+	envelope, err := sealEnvelope(filename, key, dataKey)
+	if err != nil {
+		return err
+	}
+	_, err = e.fs.SaveFile(envelopeName(filename, key), bytes.NewReader(envelope))
+	return err
+}
+
+// sealEnvelope returns the envelope of the data key of a file encrypted with a master key.
+func sealEnvelope(filename string, key masterKey, dataKey []byte) ([]byte, error) {
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	envelope := append([]byte(keyEnvelopeMagic), nonce...)
+	return key.aead.Seal(envelope, nonce, dataKey, []byte(filename)), nil
+}
+
+// openEnvelope decrypts the data key of a file from its envelope encrypted with a master
+// key, which is read from r.
+func openEnvelope(filename string, key masterKey, r io.Reader) ([]byte, error) {
+	envelope, err := ioutil.ReadAll(io.LimitReader(r, 1024))
+	if err != nil {
+		return nil, err
+	}
+	nonceEnd := len(keyEnvelopeMagic) + key.aead.NonceSize()
+	if len(envelope) < nonceEnd || string(envelope[:len(keyEnvelopeMagic)]) != keyEnvelopeMagic {
+		return nil, fmt.Errorf("key envelope of file %s is malformed", filename)
+	}
+	dataKey, err := key.aead.Open(nil, envelope[len(keyEnvelopeMagic):nonceEnd], envelope[nonceEnd:], []byte(filename))
+	if err != nil || len(dataKey) != dataKeySize {
+		return nil, fmt.Errorf("key envelope of file %s is corrupted", filename)
+	}
+	return dataKey, nil
+}
+
+// readEnvelope returns the data key of a file together with the master key it was
+// decrypted with. The data key is nil if the file has no envelope of a known master key.
+func (e *EncryptedFileOperator) readEnvelope(filename string) ([]byte, masterKey, error) {
+	for _, key := range e.masterKeys() {
+		rc, err := e.fs.ReadFile(envelopeName(filename, key))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, masterKey{}, err
+		}
+		dataKey, err := openEnvelope(filename, key, rc)
+		rc.Close()
+		if err != nil {
+			return nil, masterKey{}, err
+		}
+		return dataKey, key, nil
+	}
+	return nil, masterKey{}, nil
+}
+
+// openDataKey returns the cipher with the data key of a file, or nil if the file has no
+// envelope of a known master key.
+func (e *EncryptedFileOperator) openDataKey(filename string) (cipher.AEAD, error) {
+	dataKey, _, err := e.readEnvelope(filename)
+	if err != nil || dataKey == nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+// chunkNonce returns the nonce of a chunk of a file. The last chunk is marked, so a file
+// cut off after any chunk is detected. As every file has its own data key, nonces are never
+// reused.
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+// encryptingReader reads the encrypted chunks of the content of another reader, preceded by
+// encryptedFileMagic.
+type encryptingReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	index uint64
+	// size is the number of bytes of plaintext read so far.
+	size  int64
+	plain []byte
+	// pending is the part of the current encrypted chunk, which is not read yet.
+	pending []byte
+	sealed  []byte
+	done    bool
+}
+
+// newEncryptingReader returns an encryptingReader encrypting the content of r.
+func newEncryptingReader(r io.Reader, aead cipher.AEAD) *encryptingReader {
+	return &encryptingReader{
+		src:     bufio.NewReader(r),
+		aead:    aead,
+		plain:   make([]byte, encryptedChunkSize),
+		pending: []byte(encryptedFileMagic),
+		sealed:  make([]byte, 0, encryptedChunkSize+encryptedChunkOverhead),
+	}
+}
+
+func (r *encryptingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// sealChunk encrypts the next chunk of plaintext. An empty content is a single empty chunk.
+func (r *encryptingReader) sealChunk() error {
+	n, err := io.ReadFull(r.src, r.plain)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err != nil
+	if !last {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	r.size += int64(n)
+	r.pending = r.aead.Seal(r.sealed[:0], chunkNonce(r.index, last), r.plain[:n], nil)
+	r.index++
+	r.done = last
+	return nil
+}
+
+// decryptingReader reads the plaintext of the encrypted chunks of another reader.
+type decryptingReader struct {
+	filename string
+	src      *bufio.Reader
+	aead     cipher.AEAD
+	index    uint64
+	chunk    []byte
+	// pending is the part of the current decrypted chunk, which is not read yet.
+	pending []byte
+	done    bool
+}
+
+// newDecryptingReader returns a decryptingReader decrypting the chunks of a file read by r,
+// which starts with the chunk of an index.
+func newDecryptingReader(filename string, r io.Reader, aead cipher.AEAD, index uint64) *decryptingReader {
+	return &decryptingReader{
+		filename: filename,
+		src:      bufio.NewReader(r),
+		aead:     aead,
+		index:    index,
+		chunk:    make([]byte, encryptedChunkSize+encryptedChunkOverhead),
+	}
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.openChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// openChunk decrypts the next chunk and checks that it was neither changed nor moved.
+func (r *decryptingReader) openChunk() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	last := err != nil
+	if !last {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plain, err := r.aead.Open(r.chunk[:0], chunkNonce(r.index, last), r.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("chunk %d of file %s is corrupted", r.index, r.filename)
+	}
+	r.pending = plain
+	r.index++
+	r.done = last
+	return nil
+}
@@ -0,0 +1,281 @@
+package fileUtils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testMasterKey returns a master key whose bytes are all b.
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, MasterKeySize)
+}
+
+// newTestEncryptedFileOperator returns an EncryptedFileOperator over a LocalFileOperator,
+// which is returned too.
+func newTestEncryptedFileOperator(t *testing.T, current []byte, previous ...[]byte) (*encryptedPartialFileOperator, *LocalFileOperator) {
+	t.Helper()
+	local := CreateNewLocalFileOperator(t.TempDir())
+	return wrapTestEncryptedFileOperator(t, local, current, previous...), local
+}
+
+// wrapTestEncryptedFileOperator returns an EncryptedFileOperator over local.
+func wrapTestEncryptedFileOperator(t *testing.T, local *LocalFileOperator, current []byte,
+	previous ...[]byte) *encryptedPartialFileOperator {
+	t.Helper()
+	fs, err := CreateNewEncryptedFileOperator(local, current, previous...)
+	if err != nil {
+		t.Fatalf("CreateNewEncryptedFileOperator failed: %s", err)
+	}
+	return fs.(*encryptedPartialFileOperator)
+}
+
+// storedContent reads a file of a LocalFileOperator as it is stored.
+func storedContent(t *testing.T, local *LocalFileOperator, name string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(local.basePath, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncryptedFileOperator(t *testing.T) {
+	e, local := newTestEncryptedFileOperator(t, testMasterKey(1))
+	// The content spans several chunks, the last of them partly.
+	content := strings.Repeat("0123456789abcdef", 3*encryptedChunkSize/16+100)
+	saved, err := e.SaveFile("blob", strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	if saved.Size != int64(len(content)) || saved.StoredSize <= saved.Size {
+		t.Errorf("SaveFile returned size %d stored in %d bytes, want %d stored in more", saved.Size, saved.StoredSize,
+			len(content))
+	}
+	if stored := storedContent(t, local, "blob"); bytes.Contains(stored, []byte("0123456789abcdef")) {
+		t.Error("content is stored as plaintext")
+	}
+	if got, err := readAll(e.ReadFile("blob")); err != nil || got != content {
+		t.Errorf("ReadFile read %d bytes, %v, want the content", len(got), err)
+	}
+	for _, r := range []struct{ offset, length int64 }{
+		{0, 10},
+		{encryptedChunkSize - 5, 10},
+		{2*encryptedChunkSize + 7, encryptedChunkSize},
+		{int64(len(content)) - 3, 3},
+	} {
+		want := content[r.offset : r.offset+r.length]
+		if got, err := readAll(e.ReadFileRange("blob", r.offset, r.length)); err != nil || got != want {
+			t.Errorf("ReadFileRange(%d, %d) read %q, %v, want %q", r.offset, r.length, got, err, want)
+		}
+	}
+
+	// Files stored before encryption was enabled are read as they are.
+	if _, err := local.SaveFile("plain", strings.NewReader("plain text")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(e.ReadFile("plain")); err != nil || got != "plain text" {
+		t.Errorf("ReadFile of an unencrypted file read %q, %v", got, err)
+	}
+
+	if err := e.RemoveFile("blob"); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	if entries, _ := ioutil.ReadDir(local.basePath); len(entries) != 1 {
+		t.Errorf("%d files left after a removal, want only the unencrypted one", len(entries))
+	}
+}
+
+func TestEncryptedFileOperatorDetectsChanges(t *testing.T) {
+	e, local := newTestEncryptedFileOperator(t, testMasterKey(1))
+	content := strings.Repeat("x", 2*encryptedChunkSize)
+	if _, err := e.SaveFile("blob", strings.NewReader(content)); err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	stored := storedContent(t, local, "blob")
+	path := filepath.Join(local.basePath, "blob")
+
+	changed := append([]byte{}, stored...)
+	changed[len(encryptedFileMagic)+10] ^= 1
+	// The last chunk is cut off.
+	truncated := stored[:len(encryptedFileMagic)+encryptedChunkSize+encryptedChunkOverhead]
+	for name, b := range map[string][]byte{"changed": changed, "truncated": truncated} {
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readAll(e.ReadFile("blob")); err == nil {
+			t.Errorf("ReadFile of a %s file succeeded", name)
+		}
+	}
+
+	// A file cannot be read with the data key of another one.
+	if err := ioutil.WriteFile(path, stored, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.SaveFile("other", strings.NewReader("other")); err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	envelope := envelopeName("blob", e.current)
+	other := storedContent(t, local, envelopeName("other", e.current))
+	if err := ioutil.WriteFile(filepath.Join(local.basePath, envelope), other, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.ReadFile("blob"); err == nil {
+		t.Error("ReadFile with the key envelope of another file succeeded")
+	}
+}
+
+func TestEncryptedFileOperatorKeyRotation(t *testing.T) {
+	old, local := newTestEncryptedFileOperator(t, testMasterKey(1))
+	if _, err := old.SaveFile("blob", strings.NewReader("secret")); err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	before := storedContent(t, local, "blob")
+
+	// The previous master key still decrypts the data keys until they are rotated.
+	rotated := wrapTestEncryptedFileOperator(t, local, testMasterKey(2), testMasterKey(1))
+	if got, err := readAll(rotated.ReadFile("blob")); err != nil || got != "secret" {
+		t.Errorf("ReadFile with a previous master key read %q, %v", got, err)
+	}
+	rotator, ok := FindKeyRotator(rotated)
+	if !ok {
+		t.Fatal("no KeyRotator found")
+	}
+	for i, want := range []bool{true, false} {
+		if done, err := rotator.RewrapDataKey("blob"); err != nil || done != want {
+			t.Errorf("RewrapDataKey #%d returned %t, %v, want %t", i+1, done, err, want)
+		}
+	}
+	if !bytes.Equal(storedContent(t, local, "blob"), before) {
+		t.Error("the content was rewritten by the rotation")
+	}
+	if _, err := os.Stat(filepath.Join(local.basePath, envelopeName("blob", old.current))); !os.IsNotExist(err) {
+		t.Errorf("envelope of the previous master key is left, stat returned %v", err)
+	}
+
+	current := wrapTestEncryptedFileOperator(t, local, testMasterKey(2))
+	if got, err := readAll(current.ReadFile("blob")); err != nil || got != "secret" {
+		t.Errorf("ReadFile after the rotation read %q, %v", got, err)
+	}
+	if _, err := readAll(wrapTestEncryptedFileOperator(t, local, testMasterKey(3)).ReadFile("blob")); err == nil {
+		t.Error("ReadFile with an unknown master key succeeded")
+	}
+}
+
+func TestEncryptedPartialFiles(t *testing.T) {
+	e, local := newTestEncryptedFileOperator(t, testMasterKey(1))
+	if err := e.CreatePartialFile("upload"); err != nil {
+		t.Fatalf("CreatePartialFile failed: %s", err)
+	}
+	// The chunks end at offsets, which are not multiples of the AES block size, and the
+	// interrupted end of a chunk is written again.
+	for _, chunk := range []struct {
+		offset  int64
+		content string
+	}{
+		{0, "the quick brown fox jumps xx"},
+		{26, "over the lazy dog"},
+		{43, ", again and again"},
+	} {
+		if _, err := e.WritePartialFile("upload", chunk.offset, strings.NewReader(chunk.content)); err != nil {
+			t.Fatalf("WritePartialFile at %d failed: %s", chunk.offset, err)
+		}
+	}
+	want := "the quick brown fox jumps over the lazy dog, again and again"
+	if stored := storedContent(t, local, filepath.Join(partialDir, "upload")); len(stored) != len(want) ||
+		bytes.Contains(stored, []byte("quick")) {
+		t.Errorf("partial file is stored as %q, want %d encrypted bytes", stored, len(want))
+	}
+	if got, err := readAll(e.ReadPartialFile("upload")); err != nil || got != want {
+		t.Errorf("ReadPartialFile read %q, %v, want %q", got, err, want)
+	}
+
+	// Partial files created with a previous master key stay readable.
+	rotated := wrapTestEncryptedFileOperator(t, local, testMasterKey(2), testMasterKey(1))
+	if got, err := readAll(rotated.ReadPartialFile("upload")); err != nil || got != want {
+		t.Errorf("ReadPartialFile with a previous master key read %q, %v", got, err)
+	}
+	if err := rotated.RemovePartialFile("upload"); err != nil {
+		t.Fatalf("RemovePartialFile failed: %s", err)
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(local.basePath, partialDir)); len(entries) != 0 {
+		t.Errorf("%d partial files left after a removal, want 0", len(entries))
+	}
+
+	// Partial files created before encryption was enabled are read as they are.
+	if err := local.CreatePartialFile("plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := local.WritePartialFile("plain", 0, strings.NewReader("plain")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.WritePartialFile("plain", 5, strings.NewReader(" text")); err != nil {
+		t.Fatalf("WritePartialFile failed: %s", err)
+	}
+	if got, err := readAll(e.ReadPartialFile("plain")); err != nil || got != "plain text" {
+		t.Errorf("ReadPartialFile of an unencrypted partial file read %q, %v", got, err)
+	}
+}
+
+func TestEncryptedPartialFilesDetectChanges(t *testing.T) {
+	e, local := newTestEncryptedFileOperator(t, testMasterKey(1))
+	if err := e.CreatePartialFile("upload"); err != nil {
+		t.Fatalf("CreatePartialFile failed: %s", err)
+	}
+	stored := filepath.Join(local.basePath, partialDir, "upload")
+	if _, err := e.WritePartialFile("upload", 0, strings.NewReader("aaaaaaaa")); err != nil {
+		t.Fatalf("WritePartialFile failed: %s", err)
+	}
+	first := storedContent(t, local, filepath.Join(partialDir, "upload"))
+
+	// A chunk written again at the same offset gets a key stream of its own, so the XOR of
+	// both ciphertexts does not reveal the XOR of both plaintexts.
+	if _, err := e.WritePartialFile("upload", 0, strings.NewReader("bbbbbbbb")); err != nil {
+		t.Fatalf("WritePartialFile at the same offset failed: %s", err)
+	}
+	second := storedContent(t, local, filepath.Join(partialDir, "upload"))
+	reused := 0
+	for i := range first {
+		if first[i]^second[i] == 'a'^'b' {
+			reused++
+		}
+	}
+	if reused == len(first) {
+		t.Error("chunk written again reuses the key stream")
+	}
+
+	// A write before the end cuts the partial file off at its offset.
+	if _, err := e.WritePartialFile("upload", 4, strings.NewReader("cc")); err != nil {
+		t.Fatalf("WritePartialFile before the end failed: %s", err)
+	}
+	if got, err := readAll(e.ReadPartialFile("upload")); err != nil || got != "bbbbcc" {
+		t.Errorf("ReadPartialFile read %q, %v, want %q", got, err, "bbbbcc")
+	}
+	if _, err := e.WritePartialFile("upload", 7, strings.NewReader("d")); err == nil {
+		t.Error("WritePartialFile after the end succeeded")
+	}
+
+	// Changed bytes fail the read as well as a write before them.
+	tampered := storedContent(t, local, filepath.Join(partialDir, "upload"))
+	tampered[1] ^= 1
+	if err := ioutil.WriteFile(stored, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(e.ReadPartialFile("upload")); err == nil {
+		t.Error("ReadPartialFile of a changed partial file succeeded")
+	}
+	if _, err := e.WritePartialFile("upload", 4, strings.NewReader("dd")); err == nil {
+		t.Error("WritePartialFile before the end of a changed partial file succeeded")
+	}
+
+	// So do bytes cut off.
+	if err := ioutil.WriteFile(stored, tampered[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readAll(e.ReadPartialFile("upload")); err == nil {
+		t.Error("ReadPartialFile of a shortened partial file succeeded")
+	}
+}
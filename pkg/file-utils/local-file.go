@@ -9,8 +9,8 @@ import (
 
 // FileSaveReadRemover provides an interface to save/read/remove a file to/from/from a source.
 type FileSaveReadRemover interface {
-	// Save file to a source.
-	SaveFile(filename string, contentReader io.Reader) (int64, string, error)
+	// Save file to a source. The digests of the content are computed while it is saved.
+	SaveFile(filename string, contentReader io.Reader, opts ...SaveOption) (SavedFile, error)
 
 	// ReadFile returns an instance of io.ReadCloser. Data can be read from the instance via
 	// Read() function. NOTE: Remember to Close() after reading the content.
@@ -20,6 +20,40 @@ type FileSaveReadRemover interface {
 	RemoveFile(filename string) error
 }
 
+// SaveOption tells a FileSaveReadRemover more about a content it saves.
+type SaveOption func(*saveOptions)
+
+// saveOptions holds what the SaveOptions of a content tell.
+type saveOptions struct {
+	// contentType is the MIME type of the content, or empty if it is unknown.
+	contentType string
+}
+
+// WithContentType tells the MIME type of a content, e.g. as detected from its name and its
+// first bytes before it is saved, which a transforming source may base its choices on.
+func WithContentType(contentType string) SaveOption {
+	return func(o *saveOptions) {
+		o.contentType = contentType
+	}
+}
+
+// newSaveOptions applies SaveOptions.
+func newSaveOptions(opts []SaveOption) saveOptions {
+	var o saveOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// FileRangeReader provides an interface to read a part of a file from a source
+// without reading the content before it.
+type FileRangeReader interface {
+	// ReadFileRange returns an io.ReadCloser reading length bytes of a file starting at offset.
+	// NOTE: Remember to Close() after reading the content.
+	ReadFileRange(filename string, offset, length int64) (io.ReadCloser, error)
+}
+
 type LocalFileOperator struct {
 	basePath string
 }
@@ -32,25 +66,30 @@ func CreateNewLocalFileOperator(basePath string) *LocalFileOperator {
 }
 
 // SaveFile saves a file from a reader to the local disk, return the number of bytes
-// saved on the local disk and the location of the file.
-// If the filename already exists, return error.
-func (fs *LocalFileOperator) SaveFile(filename string, contentReader io.Reader) (int64, string, error) {
+// saved on the local disk, the location of the file and the digests of its content.
+// If the filename already exists, return error. The content is stored as it is, whatever
+// the options tell about it.
+func (fs *LocalFileOperator) SaveFile(filename string, contentReader io.Reader, _ ...SaveOption) (SavedFile, error) {
 	// filePathOD filepath on disk.
 	filePathOD := filepath.Join(fs.basePath, filename)
 	// Check if the file already exists.
 	if _, err := os.Stat(filePathOD); err == nil {
 		// Return error if the file already exists.
-		return 0, "", fmt.Errorf("File %s already exist", filePathOD)
+		return SavedFile{}, fmt.Errorf("File %s already exist", filePathOD)
 	}
 	// Create a new empty dst file.
 	dstF, err := os.Create(filePathOD)
 	if err != nil {
-		return 0, "", err
+		return SavedFile{}, err
 	}
 	defer dstF.Close()
 	// Copy content to the dst file.
-	size, err := io.Copy(dstF, contentReader)
-	return size, filePathOD, err
+	checksums := newChecksumReader(contentReader)
+	size, err := io.Copy(dstF, checksums)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	return checksums.savedFile(size, filePathOD), nil
 }
 
 // ReadFile returns an os.File pointer with a given filename, which can be only used for reading the file content from the
@@ -67,3 +106,18 @@ func (fs *LocalFileOperator) RemoveFile(filename string) error {
 	filePathOD := filepath.Join(fs.basePath, filename)
 	return os.Remove(filePathOD)
 }
+
+// ReadFileRange returns an io.ReadCloser reading length bytes of a file starting at offset.
+func (fs *LocalFileOperator) ReadFileRange(filename string, offset, length int64) (io.ReadCloser, error) {
+	// filePathOD filepath on disk.
+	filePathOD := filepath.Join(fs.basePath, filename)
+	f, err := os.Open(filePathOD)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(f, length), f}, nil
+}
@@ -0,0 +1,47 @@
+package fileUtils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalFileOperator(t *testing.T) {
+	fs := CreateNewLocalFileOperator(t.TempDir())
+	saved, err := fs.SaveFile("blob", strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	want := SavedFile{
+		Size:       11,
+		StoredSize: 11,
+		Location:   filepath.Join(fs.basePath, "blob"),
+		SHA256:     "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		MD5:        "5eb63bbbe01eeed093cb22bb8f5acdc3",
+	}
+	if saved != want {
+		t.Errorf("SaveFile returned %+v, want %+v", saved, want)
+	}
+	if _, err := fs.SaveFile("blob", strings.NewReader("other")); err == nil {
+		t.Error("SaveFile overwrote an existing file")
+	}
+
+	r, err := fs.ReadFileRange("blob", 6, 3)
+	if err != nil {
+		t.Fatalf("ReadFileRange failed: %s", err)
+	}
+	b, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(b) != "wor" {
+		t.Errorf("ReadFileRange read %q, %v, want wor", b, err)
+	}
+
+	if err := fs.RemoveFile("blob"); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	if _, err := fs.ReadFile("blob"); !os.IsNotExist(err) {
+		t.Errorf("ReadFile of a removed file returned %v, want not exist", err)
+	}
+}
@@ -0,0 +1,79 @@
+package fileUtils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// PartialFileStore provides an interface to build a file from consecutive chunks, which
+// may arrive in separate requests. Partial files are kept apart from complete files and
+// survive restarts.
+type PartialFileStore interface {
+	// CreatePartialFile creates a new empty partial file.
+	CreatePartialFile(filename string) error
+
+	// WritePartialFile writes the content of a reader to a partial file starting at offset,
+	// and returns the number of bytes written. Anything after offset is discarded first, so a
+	// chunk which was interrupted midway can be written again.
+	WritePartialFile(filename string, offset int64, contentReader io.Reader) (int64, error)
+
+	// ReadPartialFile returns an io.ReadCloser reading a partial file from the beginning.
+	// NOTE: Remember to Close() after reading the content.
+	ReadPartialFile(filename string) (io.ReadCloser, error)
+
+	// RemovePartialFile removes a partial file.
+	RemovePartialFile(filename string) error
+}
+
+// partialDir is the directory, relative to the base path, holding the partial files of
+// a LocalFileOperator.
+const partialDir = ".partial"
+
+// CreatePartialFile creates a new empty partial file on the local disk.
+// If the filename already exists, return error.
+func (fs *LocalFileOperator) CreatePartialFile(filename string) error {
+	dir := filepath.Join(fs.basePath, partialDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, filename), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WritePartialFile writes the content of a reader to a partial file on the local disk
+// starting at offset, and returns the number of bytes written. The data is synced to
+// the disk before returning.
+func (fs *LocalFileOperator) WritePartialFile(filename string, offset int64, contentReader io.Reader) (int64, error) {
+	f, err := os.OpenFile(filepath.Join(fs.basePath, partialDir, filename), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, contentReader)
+	// Keep what was received before an error, so the client can resume from there.
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	return n, err
+}
+
+// ReadPartialFile returns an os.File pointer of a partial file, which can be only used
+// for reading its content from the local disk.
+func (fs *LocalFileOperator) ReadPartialFile(filename string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(fs.basePath, partialDir, filename))
+}
+
+// RemovePartialFile removes a partial file from the local disk.
+func (fs *LocalFileOperator) RemovePartialFile(filename string) error {
+	return os.Remove(filepath.Join(fs.basePath, partialDir, filename))
+}
@@ -0,0 +1,121 @@
+package fileUtils
+
+import (
+	"errors"
+	"io"
+)
+
+// limitedReadCloser reads from a limited reader and closes the underlying source.
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readFileRange reads length bytes of a file of fs starting at offset. Only the range is read,
+// if fs is a FileRangeReader.
+func readFileRange(fs FileSaveReadRemover, filename string, offset, length int64) (io.ReadCloser, error) {
+	if rr, ok := fs.(FileRangeReader); ok {
+		return rr.ReadFileRange(filename, offset, length)
+	}
+	rc, err := fs.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	return &limitedReadCloser{io.LimitReader(rc, length), rc}, nil
+}
+
+// fileReadSeeker provides random access to a file of a known size in a FileSaveReadRemover.
+// The file is (re)opened lazily at the current offset on the first Read after a Seek.
+type fileReadSeeker struct {
+	fs       FileSaveReadRemover
+	filename string
+	size     int64
+	offset   int64
+	rc       io.ReadCloser
+}
+
+// NewFileReadSeeker returns an io.ReadSeekCloser over a file of a known size in a
+// FileSaveReadRemover. If fs implements FileRangeReader, reads after a seek start
+// at the new offset directly; otherwise the file is read from the beginning and the
+// content before the offset is skipped.
+func NewFileReadSeeker(fs FileSaveReadRemover, filename string, size int64) io.ReadSeekCloser {
+	return &fileReadSeeker{
+		fs:       fs,
+		filename: filename,
+		size:     size,
+	}
+}
+
+func (r *fileReadSeeker) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.rc == nil {
+		rc, err := r.open()
+		if err != nil {
+			return 0, err
+		}
+		r.rc = rc
+	}
+	if remaining := r.size - r.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *fileReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	if abs != r.offset && r.rc != nil {
+		r.rc.Close()
+		r.rc = nil
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *fileReadSeeker) Close() error {
+	if r.rc == nil {
+		return nil
+	}
+	err := r.rc.Close()
+	r.rc = nil
+	return err
+}
+
+// open opens the file at the current offset.
+func (r *fileReadSeeker) open() (io.ReadCloser, error) {
+	if rr, ok := r.fs.(FileRangeReader); ok {
+		return rr.ReadFileRange(r.filename, r.offset, r.size-r.offset)
+	}
+	rc, err := r.fs.ReadFile(r.filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, rc, r.offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return rc, nil
+}
@@ -0,0 +1,376 @@
+package fileUtils
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultS3PartSize is the size of the parts a file is uploaded in, if no size is given.
+	DefaultS3PartSize = 8 << 20
+
+	// MinS3PartSize is the minimum size of all parts but the last one accepted by S3.
+	MinS3PartSize = 5 << 20
+
+	// maxS3Parts is the maximum number of parts of a multipart upload.
+	maxS3Parts = 10000
+)
+
+// S3Config holds settings of an S3FileOperator.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible service, e.g. https://s3.eu-central-1.amazonaws.com.
+	Endpoint string
+
+	// Region is the region the requests are signed for, e.g. eu-central-1.
+	Region string
+
+	// Bucket holding the files.
+	Bucket string
+
+	// Prefix is prepended to the names of all files, e.g. "xtreme/".
+	Prefix string
+
+	// AccessKeyID and SecretAccessKey are the credentials the requests are signed with.
+	AccessKeyID     string
+	SecretAccessKey string
+
+	// PathStyle addresses the bucket in the path (https://host/bucket/key) instead of the
+	// host name (https://bucket.host/key). Most S3-compatible services require it.
+	PathStyle bool
+
+	// PartSize is the size of the parts large files are uploaded in. Files smaller than a
+	// part are uploaded in a single request. Zero means DefaultS3PartSize.
+	PartSize int64
+
+	// HTTPClient sends the requests. Nil means http.DefaultClient.
+	HTTPClient *http.Client
+
+	// PartialDir is a directory on the local disk, where partial files of resumable uploads
+	// are staged, as S3 cannot append to an object. Empty disables partial files.
+	PartialDir string
+}
+
+// S3FileOperator stores files as objects in a bucket of an S3-compatible object storage.
+// Partial files are staged on the local disk, see S3Config.PartialDir.
+type S3FileOperator struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	partial  *LocalFileOperator
+}
+
+// CreateNewS3FileOperator create a new S3FileOperator.
+func CreateNewS3FileOperator(cfg S3Config) (*S3FileOperator, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("S3 endpoint %s must be an http(s) URL", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket must not be empty")
+	}
+	if cfg.PartSize <= 0 {
+		cfg.PartSize = DefaultS3PartSize
+	}
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var partial *LocalFileOperator
+	if cfg.PartialDir != "" {
+		partial = CreateNewLocalFileOperator(cfg.PartialDir)
+	}
+	return &S3FileOperator{
+		cfg,
+		endpoint,
+		client,
+		partial,
+	}, nil
+}
+
+// SaveFile saves a file from a reader to the bucket, return the number of bytes saved,
+// the location of the file and the digests of its content. Large files are uploaded in
+// parts, so at most one part is held in memory. Unlike on the local disk, an existing file
+// with the same name is replaced, as S3 cannot check and write in one step. The content is
+// stored as it is, whatever the options tell about it.
+func (s *S3FileOperator) SaveFile(filename string, contentReader io.Reader, _ ...SaveOption) (SavedFile, error) {
+	location := "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix + filename
+	checksums := newChecksumReader(contentReader)
+	// Read the first part to know whether the file fits in a single request.
+	part := make([]byte, s.cfg.PartSize)
+	n, err := io.ReadFull(checksums, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := s.putObject(filename, part[:n]); err != nil {
+			return SavedFile{}, err
+		}
+		return checksums.savedFile(int64(n), location), nil
+	}
+	if err != nil {
+		return SavedFile{}, err
+	}
+	size, err := s.putMultipart(filename, part, checksums)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	return checksums.savedFile(size, location), nil
+}
+
+// ReadFile returns an io.ReadCloser reading a file from the bucket.
+func (s *S3FileOperator) ReadFile(filename string) (io.ReadCloser, error) {
+	return s.getObject(filename, nil)
+}
+
+// ReadFileRange returns an io.ReadCloser reading length bytes of a file starting at offset.
+func (s *S3FileOperator) ReadFileRange(filename string, offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)}}
+	return s.getObject(filename, header)
+}
+
+// RemoveFile removes a file from the bucket.
+func (s *S3FileOperator) RemoveFile(filename string) error {
+	resp, err := s.do(http.MethodDelete, filename, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3ResponseError(http.MethodDelete, filename, resp)
+	}
+	return nil
+}
+
+// errNoPartialDir is returned by the PartialFileStore methods of an S3FileOperator without
+// a PartialDir.
+var errNoPartialDir = errors.New("S3 storage has no directory for partial files")
+
+// CreatePartialFile creates a new empty partial file in the PartialDir on the local disk.
+// If the filename already exists, return error.
+func (s *S3FileOperator) CreatePartialFile(filename string) error {
+	if s.partial == nil {
+		return errNoPartialDir
+	}
+	return s.partial.CreatePartialFile(filename)
+}
+
+// WritePartialFile writes the content of a reader to a partial file in the PartialDir
+// starting at offset, and returns the number of bytes written.
+func (s *S3FileOperator) WritePartialFile(filename string, offset int64, contentReader io.Reader) (int64, error) {
+	if s.partial == nil {
+		return 0, errNoPartialDir
+	}
+	return s.partial.WritePartialFile(filename, offset, contentReader)
+}
+
+// ReadPartialFile returns an io.ReadCloser reading a partial file in the PartialDir, e.g.
+// to save it to the bucket once it is complete.
+func (s *S3FileOperator) ReadPartialFile(filename string) (io.ReadCloser, error) {
+	if s.partial == nil {
+		return nil, errNoPartialDir
+	}
+	return s.partial.ReadPartialFile(filename)
+}
+
+// RemovePartialFile removes a partial file from the PartialDir.
+func (s *S3FileOperator) RemovePartialFile(filename string) error {
+	if s.partial == nil {
+		return errNoPartialDir
+	}
+	return s.partial.RemovePartialFile(filename)
+}
+
+// putObject uploads a file in a single request.
+func (s *S3FileOperator) putObject(filename string, content []byte) error {
+	resp, err := s.do(http.MethodPut, filename, nil, nil, content)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(http.MethodPut, filename, resp)
+	}
+	return nil
+}
+
+// getObject downloads a file, or a range of it.
+func (s *S3FileOperator) getObject(filename string, header http.Header) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, filename, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		defer resp.Body.Close()
+		return nil, s3ResponseError(http.MethodGet, filename, resp)
+	}
+	return resp.Body, nil
+}
+
+// s3InitiateMultipartUploadResult is the response to the initiation of a multipart upload.
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// s3CompleteMultipartUpload is the request completing a multipart upload.
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name         `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletePart `xml:"Part"`
+}
+
+// s3CompletePart is an uploaded part in s3CompleteMultipartUpload.
+type s3CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// putMultipart uploads a file in parts, starting with a full first part which was read
+// already. The upload is aborted if a part fails.
+func (s *S3FileOperator) putMultipart(filename string, first []byte, contentReader io.Reader) (int64, error) {
+	resp, err := s.do(http.MethodPost, filename, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	var initiated s3InitiateMultipartUploadResult
+	err = decodeS3Response(http.MethodPost, filename, resp, &initiated)
+	if err != nil {
+		return 0, err
+	}
+	uploadID := initiated.UploadID
+	size, parts, err := s.putParts(filename, uploadID, first, contentReader)
+	if err == nil {
+		err = s.completeMultipart(filename, uploadID, parts)
+	}
+	if err != nil {
+		// Abort the upload, so the uploaded parts do not take up space.
+		resp, abortErr := s.do(http.MethodDelete, filename, url.Values{"uploadId": {uploadID}}, nil, nil)
+		if abortErr == nil {
+			resp.Body.Close()
+		}
+		return 0, err
+	}
+	return size, nil
+}
+
+// putParts uploads the parts of a multipart upload, and returns the size of the file
+// together with the uploaded parts.
+func (s *S3FileOperator) putParts(filename, uploadID string, part []byte, contentReader io.Reader) (int64, []s3CompletePart, error) {
+	var size int64
+	var parts []s3CompletePart
+	n := len(part)
+	for partNumber := 1; ; partNumber++ {
+		if partNumber > maxS3Parts {
+			return 0, nil, fmt.Errorf("file %s has more than %d parts", filename, maxS3Parts)
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+		resp, err := s.do(http.MethodPut, filename, query, nil, part[:n])
+		if err != nil {
+			return 0, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return 0, nil, s3ResponseError(http.MethodPut, filename, resp)
+		}
+		resp.Body.Close()
+		parts = append(parts, s3CompletePart{PartNumber: partNumber, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+		n, err = io.ReadFull(contentReader, part)
+		if err == io.EOF {
+			return size, parts, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, nil, err
+		}
+	}
+}
+
+// completeMultipart completes a multipart upload with its uploaded parts.
+func (s *S3FileOperator) completeMultipart(filename, uploadID string, parts []s3CompletePart) error {
+	body, err := xml.Marshal(s3CompleteMultipartUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPost, filename, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	// S3 may report an error with status 200 after it started sending the response.
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := decodeS3Response(http.MethodPost, filename, resp, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("S3 POST %s failed: %s: %s", filename, result.Code, result.Message)
+	}
+	return nil
+}
+
+// do sends a signed request for a file to the bucket.
+func (s *S3FileOperator) do(method, filename string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	key := s.cfg.Prefix + filename
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	// The path is sent exactly as it is signed.
+	u.RawPath = canonicalURIV4(&u)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	signV4(req, payloadHash, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.Region, time.Now())
+	return s.client.Do(req)
+}
+
+// decodeS3Response decodes the XML body of a successful response, and closes it.
+func decodeS3Response(method, filename string, resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(method, filename, resp)
+	}
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+// s3ResponseError converts an unexpected response to an error. A missing file is reported
+// as fs.ErrNotExist, like by LocalFileOperator.
+func s3ResponseError(method, filename string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: method, Path: filename, Err: fs.ErrNotExist}
+	}
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(body, &s3Err) == nil && s3Err.Code != "" {
+		return fmt.Errorf("S3 %s %s failed with status %d: %s: %s", method, filename, resp.StatusCode, s3Err.Code, s3Err.Message)
+	}
+	return fmt.Errorf("S3 %s %s failed with status %d", method, filename, resp.StatusCode)
+}
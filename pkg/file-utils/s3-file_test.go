@@ -0,0 +1,160 @@
+package fileUtils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/nvthongswansea/xtreme/pkg/file-utils/s3test"
+)
+
+const (
+	testS3AccessKeyID     = "access-key"
+	testS3SecretAccessKey = "secret-key"
+	testS3Region          = "us-east-1"
+	testS3Bucket          = "xtreme"
+)
+
+// newTestS3FileOperator returns an S3FileOperator of a fake server, which uploads files in
+// parts of 4 bytes and signs its requests with a secret access key.
+func newTestS3FileOperator(t *testing.T, srv *s3test.Server, secretAccessKey string) *S3FileOperator {
+	t.Helper()
+	s, err := CreateNewS3FileOperator(S3Config{
+		Endpoint:        srv.URL,
+		Region:          testS3Region,
+		Bucket:          testS3Bucket,
+		Prefix:          "files/",
+		AccessKeyID:     testS3AccessKeyID,
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       true,
+		PartSize:        4,
+		PartialDir:      t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("CreateNewS3FileOperator failed: %s", err)
+	}
+	return s
+}
+
+// readAll reads and closes r, if opening it did not fail with err.
+func readAll(r io.ReadCloser, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func TestS3FileOperatorSaveFile(t *testing.T) {
+	srv := s3test.NewServer(testS3AccessKeyID, testS3SecretAccessKey, testS3Region)
+	defer srv.Close()
+	s := newTestS3FileOperator(t, srv, testS3SecretAccessKey)
+
+	for _, content := range []string{"", "abc", "0123456789"} {
+		saved, err := s.SaveFile("blob", strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("SaveFile of %d bytes failed: %s", len(content), err)
+		}
+		if saved.Size != int64(len(content)) || saved.Location != "s3://xtreme/files/blob" {
+			t.Errorf("SaveFile returned %+v for %d bytes", saved, len(content))
+		}
+		if sum := sha256.Sum256([]byte(content)); saved.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("SaveFile returned SHA-256 %s for %q", saved.SHA256, content)
+		}
+		if object, _ := srv.Object(testS3Bucket, "files/blob"); string(object) != content {
+			t.Errorf("stored %q, want %q", object, content)
+		}
+		if got, err := readAll(s.ReadFile("blob")); err != nil || got != content {
+			t.Errorf("ReadFile read %q, %v, want %q", got, err, content)
+		}
+	}
+	if n := srv.PendingUploads(); n != 0 {
+		t.Errorf("%d multipart uploads left pending", n)
+	}
+}
+
+func TestS3FileOperatorAbortsFailedMultipartUpload(t *testing.T) {
+	srv := s3test.NewServer(testS3AccessKeyID, testS3SecretAccessKey, testS3Region)
+	defer srv.Close()
+	s := newTestS3FileOperator(t, srv, testS3SecretAccessKey)
+	srv.FailPart(2)
+	if _, err := s.SaveFile("blob", strings.NewReader("0123456789")); err == nil {
+		t.Fatal("SaveFile succeeded although a part failed")
+	}
+	if srv.ObjectCount() != 0 || srv.PendingUploads() != 0 {
+		t.Errorf("%d objects and %d pending uploads left after a failed upload", srv.ObjectCount(), srv.PendingUploads())
+	}
+}
+
+func TestS3FileOperatorReadRangeAndRemove(t *testing.T) {
+	srv := s3test.NewServer(testS3AccessKeyID, testS3SecretAccessKey, testS3Region)
+	defer srv.Close()
+	s := newTestS3FileOperator(t, srv, testS3SecretAccessKey)
+	if _, err := s.SaveFile("blob", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("SaveFile failed: %s", err)
+	}
+	if got, err := readAll(s.ReadFileRange("blob", 6, 3)); err != nil || got != "wor" {
+		t.Errorf("ReadFileRange read %q, %v, want wor", got, err)
+	}
+	if got, err := readAll(s.ReadFileRange("blob", 6, 100)); err != nil || got != "world" {
+		t.Errorf("ReadFileRange past the end read %q, %v, want world", got, err)
+	}
+
+	if err := s.RemoveFile("blob"); err != nil {
+		t.Fatalf("RemoveFile failed: %s", err)
+	}
+	if _, err := s.ReadFile("blob"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile of a removed file returned %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestS3FileOperatorRejectedSignature(t *testing.T) {
+	srv := s3test.NewServer(testS3AccessKeyID, testS3SecretAccessKey, testS3Region)
+	defer srv.Close()
+	s := newTestS3FileOperator(t, srv, "wrong-secret")
+	_, err := s.SaveFile("blob", strings.NewReader("abc"))
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("SaveFile with a wrong secret returned %v, want SignatureDoesNotMatch", err)
+	}
+	if srv.ObjectCount() != 0 {
+		t.Errorf("%d objects stored with a wrong signature", srv.ObjectCount())
+	}
+}
+
+func TestS3FileOperatorPartialFiles(t *testing.T) {
+	srv := s3test.NewServer(testS3AccessKeyID, testS3SecretAccessKey, testS3Region)
+	defer srv.Close()
+	var s PartialFileStore = newTestS3FileOperator(t, srv, testS3SecretAccessKey)
+	if err := s.CreatePartialFile("upload"); err != nil {
+		t.Fatalf("CreatePartialFile failed: %s", err)
+	}
+	if _, err := s.WritePartialFile("upload", 0, strings.NewReader("01234xx")); err != nil {
+		t.Fatalf("WritePartialFile failed: %s", err)
+	}
+	// The interrupted end of the chunk is written again.
+	if _, err := s.WritePartialFile("upload", 5, strings.NewReader("56789")); err != nil {
+		t.Fatalf("WritePartialFile failed: %s", err)
+	}
+	if got, err := readAll(s.ReadPartialFile("upload")); err != nil || got != "0123456789" {
+		t.Errorf("ReadPartialFile read %q, %v, want 0123456789", got, err)
+	}
+	if srv.ObjectCount() != 0 {
+		t.Errorf("%d objects stored for a partial file, want 0", srv.ObjectCount())
+	}
+	if err := s.RemovePartialFile("upload"); err != nil {
+		t.Fatalf("RemovePartialFile failed: %s", err)
+	}
+
+	noPartialDir, err := CreateNewS3FileOperator(S3Config{Endpoint: srv.URL, Bucket: testS3Bucket})
+	if err != nil {
+		t.Fatalf("CreateNewS3FileOperator failed: %s", err)
+	}
+	if err := noPartialDir.CreatePartialFile("upload"); err != errNoPartialDir {
+		t.Errorf("CreatePartialFile without a PartialDir returned %v, want errNoPartialDir", err)
+	}
+}
@@ -0,0 +1,340 @@
+// Package s3test provides an in-process fake of an S3-compatible object storage, so
+// S3FileOperator can be tested without a cloud account, e.g.:
+//
+//	srv := s3test.NewServer("access-key", "secret-key", "us-east-1")
+//	defer srv.Close()
+//	fs, err := fileUtils.CreateNewS3FileOperator(fileUtils.S3Config{
+//		Endpoint: srv.URL, Region: "us-east-1", Bucket: "xtreme",
+//		AccessKeyID: "access-key", SecretAccessKey: "secret-key", PathStyle: true,
+//	})
+//
+// The server supports path-style addressing of single and multipart PUT, GET with
+// ranges, HEAD and DELETE, and rejects requests whose signature does not match.
+package s3test
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Server is a fake S3-compatible object storage. Buckets exist as soon as they are used.
+type Server struct {
+	*httptest.Server
+
+	accessKeyID     string
+	secretAccessKey string
+	region          string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]*multipartUpload
+	nextID   int
+	failPart int
+}
+
+// multipartUpload holds the parts of a multipart upload in progress.
+type multipartUpload struct {
+	object string
+	parts  map[int][]byte
+}
+
+// NewServer starts a new Server accepting requests signed with the given credentials
+// for a region. Close it after use.
+func NewServer(accessKeyID, secretAccessKey, region string) *Server {
+	s := &Server{
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		region:          region,
+		objects:         make(map[string][]byte),
+		uploads:         make(map[string]*multipartUpload),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Object returns the content of an object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.objects[bucket+"/"+key]
+	return content, ok
+}
+
+// ObjectCount returns the number of stored objects in all buckets.
+func (s *Server) ObjectCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+// PendingUploads returns the number of multipart uploads, which are neither completed
+// nor aborted.
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+// FailPart makes uploads of parts with a given number fail. Zero lets all parts succeed.
+func (s *Server) FailPart(partNumber int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPart = partNumber
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if err := s.verifySignature(r, body); err != nil {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+	object := strings.TrimPrefix(r.URL.Path, "/")
+	if !strings.Contains(object, "/") {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "only path-style object requests are supported")
+		return
+	}
+	query := r.URL.Query()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && has(query, "uploads"):
+		s.nextID++
+		uploadID := strconv.Itoa(s.nextID)
+		s.uploads[uploadID] = &multipartUpload{object: object, parts: make(map[int][]byte)}
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: uploadID})
+	case r.Method == http.MethodPut && has(query, "uploadId"):
+		upload, ok := s.readUpload(w, query.Get("uploadId"), object)
+		if !ok {
+			return
+		}
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil || partNumber < 1 {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+			return
+		}
+		if partNumber == s.failPart {
+			writeError(w, http.StatusInternalServerError, "InternalError", "part upload failed")
+			return
+		}
+		upload.parts[partNumber] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodPost && has(query, "uploadId"):
+		upload, ok := s.readUpload(w, query.Get("uploadId"), object)
+		if !ok {
+			return
+		}
+		var complete struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil || len(complete.Parts) == 0 {
+			writeError(w, http.StatusBadRequest, "MalformedXML", "invalid list of parts")
+			return
+		}
+		var content []byte
+		for i, part := range complete.Parts {
+			data, ok := upload.parts[part.PartNumber]
+			if !ok || part.PartNumber != i+1 || part.ETag != etag(data) {
+				writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d does not match", part.PartNumber))
+				return
+			}
+			content = append(content, data...)
+		}
+		s.objects[object] = content
+		delete(s.uploads, query.Get("uploadId"))
+		writeXML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			ETag    string   `xml:"ETag"`
+		}{ETag: etag(content)})
+	case r.Method == http.MethodDelete && has(query, "uploadId"):
+		if _, ok := s.readUpload(w, query.Get("uploadId"), object); !ok {
+			return
+		}
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[object] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		content, ok := s.objects[object]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+			return
+		}
+		serveContent(w, r, content)
+	case r.Method == http.MethodDelete:
+		delete(s.objects, object)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method is not supported")
+	}
+}
+
+// readUpload returns a multipart upload of an object, or writes an error.
+func (s *Server) readUpload(w http.ResponseWriter, uploadID, object string) (*multipartUpload, bool) {
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.object != object {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the specified upload does not exist")
+		return nil, false
+	}
+	return upload, true
+}
+
+// serveContent writes an object, or the range requested by a single "bytes=first-last" range.
+func serveContent(w http.ResponseWriter, r *http.Request, content []byte) {
+	w.Header().Set("ETag", etag(content))
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+		return
+	}
+	var first, last int
+	if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &first, &last); err != nil || first > last || first >= len(content) {
+		writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable")
+		return
+	}
+	if last >= len(content) {
+		last = len(content) - 1
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(content)))
+	w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodGet {
+		w.Write(content[first : last+1])
+	}
+}
+
+// verifySignature checks the AWS Signature Version 4 of a request in the Authorization header.
+func (s *Server) verifySignature(r *http.Request, body []byte) error {
+	auth := r.Header.Get("Authorization")
+	const algorithm = "AWS4-HMAC-SHA256 "
+	if !strings.HasPrefix(auth, algorithm) {
+		return fmt.Errorf("missing or unsupported Authorization header")
+	}
+	fields := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimPrefix(auth, algorithm), ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.accessKeyID || credential[2] != s.region ||
+		credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential %s", fields["Credential"])
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payloadHash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("payload hash does not match the body")
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if !strings.HasPrefix(amzDate, credential[1]) {
+		return fmt.Errorf("date of the credential does not match X-Amz-Date")
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + s.secretAccessKey)
+	for _, part := range credential[1:] {
+		key = hmacSHA256(key, part)
+	}
+	if !hmac.Equal([]byte(hex.EncodeToString(hmacSHA256(key, stringToSign))), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// canonicalQuery returns the query parameters in the canonical form of Signature Version 4.
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, escape(name)+"="+escape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// escape percent-encodes everything but unreserved characters.
+func escape(s string) string {
+	return strings.NewReplacer("+", "%20", "*", "%2A", "%7E", "~").Replace(url.QueryEscape(s))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func etag(content []byte) string {
+	sum := md5.Sum(content)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+// has checks if a query parameter is present, even with an empty value.
+func has(query url.Values, name string) bool {
+	_, ok := query[name]
+	return ok
+}